@@ -0,0 +1,122 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxAppVersions is the maximum amount of distinct app versions used as metric
+// label values. Versions seen after that are labeled `other`, so clients
+// sending arbitrary versions can't blow up the amount of time series.
+const maxAppVersions = 32
+
+// Label values of an unknown platform or version, and of versions exceeding
+// maxAppVersions.
+const (
+	appUnknown = "unknown"
+	appOther   = "other"
+)
+
+// appClient is the platform and version of the app that sent a request, as
+// low-cardinality metric label values.
+type appClient struct {
+	platform string
+	version  string
+}
+
+// appVersions are the app versions used as label values so far.
+var appVersions = &versionSet{seen: make(map[string]bool)}
+
+// versionSet is a set of at most maxAppVersions versions. It's safe for
+// concurrent use.
+type versionSet struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+// bucket returns version if it's in the set, or could be added to it, and
+// `other` if the set is full.
+func (vs *versionSet) bucket(version string) string {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	if !vs.seen[version] {
+		if len(vs.seen) >= maxAppVersions {
+			return appOther
+		}
+		vs.seen[version] = true
+	}
+	return version
+}
+
+// parseAppClient returns the app platform and version of r. The version is
+// read from the `X-App-Version` header, e.g. `1.4.2` or `android/1.4.2`, or
+// else from the first product of the `User-Agent` header, e.g.
+// `CoronaMelder/1.4.2 (Android 10)`. Versions are bucketed by major and minor
+// version (e.g. `1.4`). The platform is `android` or `ios`, if either is
+// mentioned in the headers.
+func parseAppClient(r *http.Request) appClient {
+	appVersion := r.Header.Get("X-App-Version")
+	userAgent := r.Header.Get("User-Agent")
+
+	client := appClient{platform: parsePlatform(appVersion + " " + userAgent), version: appUnknown}
+
+	version := appVersion
+	if i := strings.LastIndexByte(version, '/'); i >= 0 {
+		version = version[i+1:]
+	}
+	if version == "" {
+		// Browsers don't identify an app.
+		if product := strings.Fields(userAgent); len(product) > 0 && !strings.HasPrefix(product[0], "Mozilla/") {
+			if i := strings.IndexByte(product[0], '/'); i >= 0 {
+				version = product[0][i+1:]
+			}
+		}
+	}
+	if bucket, ok := versionBucket(version); ok {
+		client.version = appVersions.bucket(bucket)
+	}
+
+	return client
+}
+
+func parsePlatform(s string) string {
+	s = strings.ToLower(s)
+	switch {
+	case strings.Contains(s, "android"):
+		return "android"
+	case strings.Contains(s, "ios"), strings.Contains(s, "iphone"), strings.Contains(s, "ipad"), strings.Contains(s, "cfnetwork"):
+		return "ios"
+	}
+	return appUnknown
+}
+
+// versionBucket returns the major and minor version of a semantic version,
+// optionally prefixed with `v`, e.g. `1.4` for `v1.4.2-beta`.
+func versionBucket(version string) (string, bool) {
+	version = strings.TrimPrefix(version, "v")
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return "", false
+	}
+	for _, part := range parts[:2] {
+		if !isNumeric(part) {
+			return "", false
+		}
+	}
+	return parts[0] + "." + parts[1], true
+}
+
+// isNumeric returns true for 1 to 4 digits, which is plenty for versions.
+func isNumeric(s string) bool {
+	if len(s) == 0 || len(s) > 4 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestParseAppClient(t *testing.T) {
+	tests := []struct {
+		name       string
+		appVersion string
+		userAgent  string
+		exp        appClient
+	}{
+		{
+			name:       "app version header",
+			appVersion: "1.4.2",
+			userAgent:  "okhttp/4.7.2",
+			exp:        appClient{platform: appUnknown, version: "1.4"},
+		},
+		{
+			name:       "app version header with platform",
+			appVersion: "android/2.0.13",
+			exp:        appClient{platform: "android", version: "2.0"},
+		},
+		{
+			name:      "user agent",
+			userAgent: "CoronaMelder/1.4.2 (iPhone; iOS 13.5; Scale/3.00)",
+			exp:       appClient{platform: "ios", version: "1.4"},
+		},
+		{
+			name:      "user agent with `v` prefix",
+			userAgent: "CoronaMelder/v1.5.0-beta (Android 10)",
+			exp:       appClient{platform: "android", version: "1.5"},
+		},
+		{
+			name:      "browser",
+			userAgent: "Mozilla/5.0 (Linux; Android 10) AppleWebKit/537.36",
+			exp:       appClient{platform: "android", version: appUnknown},
+		},
+		{
+			name:       "invalid version",
+			appVersion: "latest",
+			exp:        appClient{platform: appUnknown, version: appUnknown},
+		},
+		{
+			name: "no headers",
+			exp:  appClient{platform: appUnknown, version: appUnknown},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/diagnosis-keys", nil)
+			r.Header.Set("X-App-Version", tt.appVersion)
+			r.Header.Set("User-Agent", tt.userAgent)
+
+			if got := parseAppClient(r); got != tt.exp {
+				t.Errorf("expected: %+v, got: %+v", tt.exp, got)
+			}
+		})
+	}
+}
+
+func TestVersionSet(t *testing.T) {
+	vs := &versionSet{seen: make(map[string]bool)}
+	for i := 0; i < maxAppVersions; i++ {
+		if got, exp := vs.bucket("1."+strconv.Itoa(i)), "1."+strconv.Itoa(i); got != exp {
+			t.Fatalf("expected: %v, got: %v", exp, got)
+		}
+	}
+
+	if got := vs.bucket("2.0"); got != appOther {
+		t.Errorf("expected: %v, got: %v", appOther, got)
+	}
+	if got := vs.bucket("1.0"); got != "1.0" {
+		t.Errorf("expected known version to be kept, got: %v", got)
+	}
+}
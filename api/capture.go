@@ -0,0 +1,70 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// capturedHeaders are removed from captured requests, as they may identify a
+// user or grant access.
+var capturedHeaders = []string{
+	"Authorization",
+	"Cookie",
+	"Forwarded",
+	"Proxy-Authorization",
+	"X-Forwarded-For",
+	"X-Real-Ip",
+	"X-Verification-Certificate",
+	"X-Verification-Hmac-Key",
+}
+
+// captureUpload stores a sanitized copy of a malformed upload request in the
+// capture directory, in HTTP/1.1 wire format, so it can be replayed against a
+// local server, e.g. with `nc localhost 80 < upload-*.http`. The Temporary
+// Exposure Keys in body are zeroed; the other fields are kept as is, as they
+// are usually what makes an upload malformed.
+func (h *handler) captureUpload(r *http.Request, body []byte) {
+	keySize, _ := h.uploadFormat(r)
+	sanitized := make([]byte, len(body))
+	copy(sanitized, body)
+	for i := 0; i < len(sanitized); i += int(keySize) {
+		end := i + 16
+		if end > len(sanitized) {
+			end = len(sanitized)
+		}
+		for j := i; j < end; j++ {
+			sanitized[j] = 0
+		}
+	}
+
+	req, err := http.NewRequest(r.Method, r.URL.RequestURI(), bytes.NewReader(sanitized))
+	if err != nil {
+		h.logger.Error("Could not capture upload", diag.Err(err))
+		return
+	}
+	req.Host = r.Host
+	req.Header = r.Header.Clone()
+	for _, key := range capturedHeaders {
+		req.Header.Del(key)
+	}
+	req.Close = true
+
+	f, err := ioutil.TempFile(h.captureDir, fmt.Sprintf("upload-%v-*.http", time.Now().UTC().Format("20060102T150405Z")))
+	if err != nil {
+		h.logger.Error("Could not capture upload", diag.Err(err))
+		return
+	}
+	defer f.Close()
+
+	if err := req.Write(f); err != nil {
+		h.logger.Error("Could not capture upload", diag.Err(err))
+		return
+	}
+
+	h.logger.Debug("Malformed upload captured.", diag.F("path", f.Name()))
+}
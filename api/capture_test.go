@@ -0,0 +1,85 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+func TestCaptureUpload(t *testing.T) {
+	dir := t.TempDir()
+	handler, err := NewHandler(context.Background(), Config{
+		Diag:       diag.Config{Repository: noopRepo, Logger: diag.NewNopLogger(), MaxUploadBatchSize: 14},
+		CaptureDir: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One valid key, followed by a truncated one.
+	body := bytes.Repeat([]byte{0xff}, diag.DiagnosisKeySize+10)
+	req := httptest.NewRequest("POST", "http://example.com/diagnosis-keys", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	req.Header.Set("User-Agent", "test-app/1.0")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if got, exp := w.Code, http.StatusBadRequest; got != exp {
+		t.Fatalf("expected: %v, got: %v", exp, got)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "upload-*.http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 {
+		t.Fatalf("expected 1 captured upload, got: %v", len(paths))
+	}
+	f, err := os.Open(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The capture can be replayed as is.
+	captured, err := http.ReadRequest(bufio.NewReader(f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, exp := captured.URL.Path, "/diagnosis-keys"; got != exp {
+		t.Errorf("expected: %v, got: %v", exp, got)
+	}
+	if got := captured.Header.Get("Authorization"); got != "" {
+		t.Errorf("expected `Authorization` header to be removed, got: %v", got)
+	}
+	if got, exp := captured.Header.Get("User-Agent"), "test-app/1.0"; got != exp {
+		t.Errorf("expected: %v, got: %v", exp, got)
+	}
+
+	got, err := ioutil.ReadAll(captured.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := make([]byte, len(body))
+	copy(exp[16:diag.DiagnosisKeySize], body[16:diag.DiagnosisKeySize])
+	if !bytes.Equal(got, exp) {
+		t.Errorf("expected: %x, got: %x", exp, got)
+	}
+
+	// Valid uploads aren't captured.
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "http://example.com/diagnosis-keys", bytes.NewReader(body[:diag.DiagnosisKeySize])))
+	if got, exp := w.Code, http.StatusOK; got != exp {
+		t.Fatalf("expected: %v, got: %v", exp, got)
+	}
+	if paths, _ := filepath.Glob(filepath.Join(dir, "*")); len(paths) != 1 {
+		t.Errorf("expected 1 captured upload, got: %v", len(paths))
+	}
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// enInterval is the duration of an Exposure Notification interval, the unit
+// of `RollingStartNumber`.
+const enInterval = 10 * time.Minute
+
+// acceptableClockSkew is the maximum difference between a device clock and
+// the server's, for which the `RollingStartNumber` of a key generated on the
+// device is off by at most one interval.
+const acceptableClockSkew = enInterval
+
+// timeResponse is the JSON representation of the server time.
+type timeResponse struct {
+	Time                  time.Time `json:"time"`
+	UnixTime              int64     `json:"unixTime"`
+	ENIntervalNumber      int64     `json:"enIntervalNumber"`
+	AcceptableSkewSeconds int64     `json:"acceptableSkewSeconds"`
+}
+
+// serverTime writes the current UTC time in JSON, so clients can detect
+// grossly wrong device clocks before generating keys with invalid
+// `RollingStartNumber` values.
+func (h *handler) serverTime(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	writeJSON(w, timeResponse{
+		Time:                  now,
+		UnixTime:              now.Unix(),
+		ENIntervalNumber:      now.Unix() / int64(enInterval/time.Second),
+		AcceptableSkewSeconds: int64(acceptableClockSkew / time.Second),
+	})
+}
@@ -0,0 +1,243 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+const digestTimeout = 30 * time.Second
+
+// Upload rejection reasons, as reported in the digest.
+const (
+	rejectInvalidBody   = "invalid_body"
+	rejectUnauthorized  = "unauthorized"
+	rejectQueueFull     = "queue_full"
+	rejectQuotaExceeded = "quota_exceeded"
+	rejectOverloaded    = "overloaded"
+	rejectError         = "error"
+)
+
+// SMTPConfig represents the configuration for sending the digest by email.
+type SMTPConfig struct {
+	// Addr is the host:port of the SMTP server. STARTTLS is used if the
+	// server supports it.
+	Addr string
+	// Username and Password are used for PLAIN authentication, if set.
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// uploadStats counts uploads and their rejections since the last digest.
+type uploadStats struct {
+	mu         sync.Mutex
+	uploads    int64
+	keys       int64
+	rejections map[string]int64
+}
+
+func newUploadStats() *uploadStats {
+	return &uploadStats{rejections: make(map[string]int64)}
+}
+
+func (us *uploadStats) accept(client appClient, keys int) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	us.uploads++
+	us.keys += int64(keys)
+
+	uploads.Inc("accepted", client.platform, client.version)
+	uploadedKeys.Add(float64(keys))
+}
+
+func (us *uploadStats) reject(client appClient, reason string) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	us.rejections[reason]++
+
+	uploads.Inc(reason, client.platform, client.version)
+}
+
+// reset returns the counts, and resets them.
+func (us *uploadStats) reset() (uploads, keys int64, rejections map[string]int64) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	uploads, keys, rejections = us.uploads, us.keys, us.rejections
+	us.uploads, us.keys, us.rejections = 0, 0, make(map[string]int64)
+	return uploads, keys, rejections
+}
+
+// digest summarizes the operation of the server over a period, for programme
+// managers without log access. It never contains key material.
+type digest struct {
+	From         time.Time        `json:"from"`
+	To           time.Time        `json:"to"`
+	Uploads      int64            `json:"uploads"`
+	UploadedKeys int64            `json:"uploadedKeys"`
+	Rejections   map[string]int64 `json:"rejections"`
+	// Publications is the amount of cache refreshes, which publish uploaded
+	// keys for download.
+	Publications int64    `json:"publications"`
+	CachedKeys   int64    `json:"cachedKeys"`
+	Anomalies    []string `json:"anomalies"`
+
+	// refreshes is the total amount of cache refreshes at the time of the
+	// digest, to compute Publications of the next one.
+	refreshes int64
+}
+
+// digest returns the digest for the period since from, given the total
+// amount of cache refreshes at that time, and resets the upload counts.
+func (h *handler) digest(ctx context.Context, from, to time.Time, refreshes int64) (digest, error) {
+	status, err := h.diagSvc.Status(ctx)
+	if err != nil {
+		return digest{}, err
+	}
+
+	d := digest{
+		From:         from,
+		To:           to,
+		Publications: status.Refreshes - refreshes,
+		CachedKeys:   status.CachedKeys,
+		Anomalies:    []string{},
+		refreshes:    status.Refreshes,
+	}
+	d.Uploads, d.UploadedKeys, d.Rejections = h.uploadStats.reset()
+
+	if !status.RefreshHealthy {
+		d.Anomalies = append(d.Anomalies, fmt.Sprintf("Cache refresh is unhealthy, last refreshed at %v.", formatTime(status.RefreshedAt)))
+	}
+	if status.PurgeEnabled && !status.PurgeHealthy {
+		d.Anomalies = append(d.Anomalies, fmt.Sprintf("Purging expired keys is unhealthy, last purged at %v.", formatTime(status.PurgedAt)))
+	}
+	if status.QuotaExceeded {
+		d.Anomalies = append(d.Anomalies, "The stored keys quota is exceeded.")
+	}
+	if h.errorLog != nil {
+		var n int
+		for _, entry := range h.errorLog.Entries() {
+			if !entry.Time.Before(from) {
+				n++
+			}
+		}
+		if n > 0 {
+			d.Anomalies = append(d.Anomalies, fmt.Sprintf("%v error(s) logged, see `/admin/status` for the most recent.", n))
+		}
+	}
+
+	return d, nil
+}
+
+// text returns the plain text representation of d, for email.
+func (d digest) text() string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "ct-diag-server digest from %v to %v\n\n", formatTime(d.From), formatTime(d.To))
+	fmt.Fprintf(buf, "Accepted uploads: %v (%v keys)\n", d.Uploads, d.UploadedKeys)
+
+	reasons := make([]string, 0, len(d.Rejections))
+	for reason := range d.Rejections {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	if len(reasons) == 0 {
+		fmt.Fprintf(buf, "Rejected uploads: 0\n")
+	}
+	for _, reason := range reasons {
+		fmt.Fprintf(buf, "Rejected uploads (%v): %v\n", reason, d.Rejections[reason])
+	}
+
+	fmt.Fprintf(buf, "Publications (cache refreshes): %v\n", d.Publications)
+	fmt.Fprintf(buf, "Keys available for download: %v\n\n", d.CachedKeys)
+
+	if len(d.Anomalies) == 0 {
+		fmt.Fprintf(buf, "No anomalies.\n")
+	} else {
+		fmt.Fprintf(buf, "Anomalies:\n")
+	}
+	for _, anomaly := range d.Anomalies {
+		fmt.Fprintf(buf, "- %v\n", anomaly)
+	}
+
+	return buf.String()
+}
+
+// pushDigests sends a digest every day at midnight (UTC), covering the
+// preceding day, to webhookURL (as JSON) and/or by email, until ctx is done.
+func (h *handler) pushDigests(ctx context.Context, webhookURL string, smtpCfg *SMTPConfig) {
+	client := &http.Client{Timeout: digestTimeout}
+	from := time.Now().UTC()
+	var refreshes int64
+	if status, err := h.diagSvc.Status(ctx); err == nil {
+		refreshes = status.Refreshes
+	}
+
+	for {
+		to := from.Truncate(24 * time.Hour).Add(24 * time.Hour)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(to)):
+		}
+
+		d, err := h.digest(ctx, from, to, refreshes)
+		from = to
+		if err != nil {
+			h.logger.Error("Could not create digest.", diag.Err(err))
+			continue
+		}
+		refreshes = d.refreshes
+
+		if webhookURL != "" {
+			if err := postJSON(ctx, client, webhookURL, d); err != nil {
+				h.logger.Error("Could not push digest.", diag.Err(err))
+			}
+		}
+		if smtpCfg != nil {
+			if err := sendEmail(*smtpCfg, "ct-diag-server digest for "+d.From.Format(dateLayout), d.text()); err != nil {
+				h.logger.Error("Could not email digest.", diag.Err(err))
+			}
+		}
+	}
+}
+
+// sendEmail sends a plain text email.
+func sendEmail(cfg SMTPConfig, subject, body string) error {
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		host, _, err := net.SplitHostPort(cfg.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+
+	msg := &bytes.Buffer{}
+	fmt.Fprintf(msg, "From: %v\r\n", cfg.From)
+	fmt.Fprintf(msg, "To: %v\r\n", strings.Join(cfg.To, ", "))
+	fmt.Fprintf(msg, "Subject: %v\r\n", subject)
+	fmt.Fprintf(msg, "Date: %v\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return smtp.SendMail(cfg.Addr, auth, cfg.From, cfg.To, msg.Bytes())
+}
+
+// formatTime formats t in RFC 3339, or "never" for the zero time.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
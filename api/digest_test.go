@@ -0,0 +1,100 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+func TestDigest(t *testing.T) {
+	ctx := context.Background()
+	errorLog := diag.NewErrorLog(diag.NewNopLogger(), 10)
+	diagSvc, err := diag.NewService(ctx, diag.Config{Repository: noopRepo, Logger: errorLog, MaxUploadBatchSize: 14})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &handler{
+		diagSvc:     diagSvc,
+		logger:      errorLog,
+		slos:        newSLOTracker(0),
+		errorLog:    errorLog,
+		uploadStats: newUploadStats(),
+	}
+
+	from := time.Now().Add(-time.Hour)
+	for _, body := range [][]byte{make([]byte, diag.DiagnosisKeySize*2), []byte("foobar")} {
+		w := httptest.NewRecorder()
+		h.postDiagnosisKeys(w, httptest.NewRequest("POST", "http://example.com/diagnosis-keys", bytes.NewReader(body)))
+	}
+	if err := diagSvc.RefreshCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	errorLog.Error("Could not refresh cache", diag.Err(errors.New("database is down")))
+
+	d, err := h.digest(ctx, from, time.Now(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Uploads != 1 || d.UploadedKeys != 2 {
+		t.Errorf("expected 1 upload with 2 keys, got: %v upload(s) with %v key(s)", d.Uploads, d.UploadedKeys)
+	}
+	if exp := map[string]int64{rejectInvalidBody: 1}; !reflect.DeepEqual(d.Rejections, exp) {
+		t.Errorf("expected: %v, got: %v", exp, d.Rejections)
+	}
+	if d.Publications != 1 {
+		t.Errorf("expected: 1, got: %v", d.Publications)
+	}
+	if exp := []string{"1 error(s) logged, see `/admin/status` for the most recent."}; !reflect.DeepEqual(d.Anomalies, exp) {
+		t.Errorf("expected: %v, got: %v", exp, d.Anomalies)
+	}
+
+	text := d.text()
+	for _, exp := range []string{"Accepted uploads: 1 (2 keys)", "Rejected uploads (invalid_body): 1", "- 1 error(s) logged"} {
+		if !strings.Contains(text, exp) {
+			t.Errorf("expected text to contain: %v, got: %v", exp, text)
+		}
+	}
+
+	// Counts are reset after each digest.
+	d, err = h.digest(ctx, time.Now(), time.Now(), d.refreshes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Uploads != 0 || len(d.Rejections) != 0 || d.Publications != 0 {
+		t.Errorf("expected counts to be reset, got: %+v", d)
+	}
+}
+
+func TestDigestWebhook(t *testing.T) {
+	var got []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := &bytes.Buffer{}
+		buf.ReadFrom(r.Body)
+		got = buf.Bytes()
+	}))
+	defer srv.Close()
+
+	d := digest{
+		From:       time.Date(2020, time.May, 4, 0, 0, 0, 0, time.UTC),
+		To:         time.Date(2020, time.May, 5, 0, 0, 0, 0, time.UTC),
+		Uploads:    3,
+		Rejections: map[string]int64{rejectQueueFull: 2},
+		Anomalies:  []string{},
+	}
+	if err := postJSON(context.Background(), srv.Client(), srv.URL, d); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `{"from":"2020-05-04T00:00:00Z","to":"2020-05-05T00:00:00Z","uploads":3,"uploadedKeys":0,"rejections":{"queue_full":2},"publications":0,"cachedKeys":0,"anomalies":[]}`
+	if string(got) != exp {
+		t.Errorf("expected: %v, got: %s", exp, got)
+	}
+}
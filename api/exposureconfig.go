@@ -0,0 +1,279 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/assets"
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+const (
+	// defaultExposureConfigVersion is the version ID of the exposure
+	// configuration, if no history is configured or stored.
+	defaultExposureConfigVersion = "default"
+
+	// exposureConfigReloadInterval is the interval between reloads of the
+	// stored versions of the exposure configuration, so versions stored via
+	// other instances are served too. Request-driven handlers reload on
+	// request, once the interval passed.
+	exposureConfigReloadInterval = time.Minute
+
+	// maxExposureConfigSize is the maximum size of an exposure configuration
+	// in a request body.
+	maxExposureConfigSize = 64 * 1024
+)
+
+// exposureConfigs holds the versions of the exposure configuration: the
+// configured history (see diag.Config.ExposureConfigHistory) and the versions
+// stored in the repository.
+type exposureConfigs struct {
+	configured diag.ExposureConfigHistory
+	// fallback is the exposure configuration of the flags, effective from
+	// startup, if no history is configured. Stored versions that are
+	// effective at startup replace it.
+	fallback diag.ExposureConfigVersion
+
+	mu       sync.RWMutex
+	history  diag.ExposureConfigHistory
+	encoded  map[string]encodedExposureConfig
+	loadedAt time.Time
+}
+
+// encodedExposureConfig is the JSON encoding of a version of the exposure
+// configuration, and its entity tag, a hash of the version ID and encoding.
+type encodedExposureConfig struct {
+	buf  []byte
+	etag string
+}
+
+// exposureConfig sets the versions of the exposure configuration of cfg, and
+// returns the handler of `/exposure-config`. GET requests are served the
+// version that's effective at the time of the request for the scope of the
+// `region` and `appVersion` query parameters (see
+// diag.ExposureConfigHistory.EffectiveFor) in JSON, with its ID in the
+// `X-Exposure-Config-Version` header and an `ETag`, so clients can poll with
+// `If-None-Match`. PUT requests store a new version (see putExposureConfig).
+// Without history, the exposure configuration is effective from now on.
+func (h *handler) exposureConfig(ctx context.Context, cfg diag.Config) (http.HandlerFunc, error) {
+	h.exposureConfigs = &exposureConfigs{
+		configured: cfg.ExposureConfigHistory,
+		fallback: diag.ExposureConfigVersion{
+			ID:            defaultExposureConfigVersion,
+			EffectiveFrom: time.Now().UTC(),
+			Config:        cfg.ExposureConfig,
+		},
+	}
+	if err := h.exposureConfigs.set(nil); err != nil {
+		return nil, err
+	}
+	if err := h.loadExposureConfigs(ctx); err != nil {
+		h.logger.Error("Could not load stored exposure configurations.", diag.Err(err))
+	}
+
+	if !h.requestDriven {
+		go h.reloadExposureConfigs(ctx)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			h.requireAdmin(h.putExposureConfig)(w, r)
+			return
+		}
+
+		if h.requestDriven && h.exposureConfigs.stale(time.Now()) {
+			if err := h.loadExposureConfigs(r.Context()); err != nil {
+				h.logger.Error("Could not load stored exposure configurations.", diag.Err(err))
+			}
+		}
+
+		scope, ok := parseExposureConfigScope(w, r)
+		if !ok {
+			return
+		}
+		version, encoded := h.exposureConfigs.effective(time.Now(), scope)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Exposure-Config-Version", version.ID)
+		w.Header().Set("ETag", encoded.etag)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(encoded.buf))
+	}, nil
+}
+
+// reloadExposureConfigs loads the stored versions of the exposure
+// configuration every exposureConfigReloadInterval, until ctx is done.
+func (h *handler) reloadExposureConfigs(ctx context.Context) {
+	t := time.NewTicker(exposureConfigReloadInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if err := h.loadExposureConfigs(ctx); err != nil && err != context.Canceled {
+			h.logger.Error("Could not load stored exposure configurations.", diag.Err(err))
+		}
+	}
+}
+
+// putExposureConfig reads an exposure configuration in JSON from an HTTP
+// request, and stores it as new version of the scope of the `region` and
+// `appVersion` query parameters, effective immediately. The stored version is
+// written in JSON.
+func (h *handler) putExposureConfig(w http.ResponseWriter, r *http.Request) {
+	scope, ok := parseExposureConfigScope(w, r)
+	if !ok {
+		return
+	}
+	buf, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxExposureConfigSize))
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidBody, "Could not read request body.")
+		return
+	}
+	expCfg, err := assets.ParseExposureConfig(buf)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidBody, err.Error())
+		return
+	}
+
+	version, err := h.diagSvc.StoreExposureConfig(r.Context(), scope, expCfg)
+	switch err {
+	case nil:
+	case diag.ErrExposureConfigStoreUnsupported:
+		writeNotFound(w)
+		return
+	default:
+		h.logger.Error("Could not store exposure configuration", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	h.logger.Info("Exposure configuration stored.", diag.F("version", version.ID))
+
+	if err := h.loadExposureConfigs(r.Context()); err != nil {
+		h.logger.Error("Could not load stored exposure configurations.", diag.Err(err))
+	}
+
+	writeJSON(w, version)
+}
+
+// exposureConfigHistory writes the versions of the exposure configuration in
+// JSON, including those that aren't effective yet, for audits of risk scores.
+// The response can be used as `-exposureConfigHistory` file.
+func (h *handler) exposureConfigHistory(w http.ResponseWriter, r *http.Request) {
+	h.exposureConfigs.mu.RLock()
+	history := h.exposureConfigs.history
+	h.exposureConfigs.mu.RUnlock()
+
+	writeJSON(w, history)
+}
+
+// parseExposureConfigScope returns the scope of the optional `region` and
+// `appVersion` query parameters, e.g. `?region=NL&appVersion=1.2`. If a
+// parameter is invalid, an error response is written and false is returned.
+func parseExposureConfigScope(w http.ResponseWriter, r *http.Request) (diag.ExposureConfigScope, bool) {
+	var scope diag.ExposureConfigScope
+	query := r.URL.Query()
+
+	if _, ok := query["region"]; ok {
+		regions, err := diag.ParseRegions(query.Get("region"))
+		if err != nil || len(regions) != 1 {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRegionParam, "Invalid `region` query parameter, must be a single region, e.g. `NL`.")
+			return diag.ExposureConfigScope{}, false
+		}
+		scope.Region = regions[0]
+	}
+	if _, ok := query["appVersion"]; ok {
+		scope.AppVersion = query.Get("appVersion")
+		if err := scope.Validate(); err != nil {
+			writeProblem(w, http.StatusBadRequest, codeInvalidAppVersionParam, "Invalid `appVersion` query parameter, must be dot separated numbers, e.g. `1.2`.")
+			return diag.ExposureConfigScope{}, false
+		}
+	}
+
+	return scope, true
+}
+
+// loadExposureConfigs loads the versions of the exposure configuration stored
+// in the repository, if supported.
+func (h *handler) loadExposureConfigs(ctx context.Context) error {
+	stored, err := h.diagSvc.StoredExposureConfigs(ctx)
+	if err == diag.ErrExposureConfigStoreUnsupported {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return h.exposureConfigs.set(stored)
+}
+
+// set merges the configured history with the stored versions, ordered by
+// EffectiveFrom, and encodes the versions.
+func (ec *exposureConfigs) set(stored diag.ExposureConfigHistory) error {
+	history := make(diag.ExposureConfigHistory, 0, len(ec.configured)+len(stored)+1)
+	history = append(history, ec.configured...)
+	if _, ok := stored.Effective(ec.fallback.EffectiveFrom); len(ec.configured) == 0 && !ok {
+		history = append(history, ec.fallback)
+	}
+	history = append(history, stored...)
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].EffectiveFrom.Before(history[j].EffectiveFrom)
+	})
+
+	encoded := make(map[string]encodedExposureConfig, len(history))
+	for _, version := range history {
+		buf, err := json.Marshal(version.Config)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(append([]byte(version.ID+"\n"), buf...))
+		encoded[version.ID] = encodedExposureConfig{
+			buf:  buf,
+			etag: `"` + hex.EncodeToString(sum[:16]) + `"`,
+		}
+	}
+
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	ec.history = history
+	ec.encoded = encoded
+	ec.loadedAt = time.Now()
+
+	return nil
+}
+
+// stale returns true if the versions were loaded more than
+// exposureConfigReloadInterval before now.
+func (ec *exposureConfigs) stale(now time.Time) bool {
+	ec.mu.RLock()
+	defer ec.mu.RUnlock()
+
+	return now.Sub(ec.loadedAt) >= exposureConfigReloadInterval
+}
+
+// effective returns the version that's effective at t for scope, and its
+// encoding.
+func (ec *exposureConfigs) effective(t time.Time, scope diag.ExposureConfigScope) (diag.ExposureConfigVersion, encodedExposureConfig) {
+	ec.mu.RLock()
+	defer ec.mu.RUnlock()
+
+	version, ok := ec.history.EffectiveFor(t, scope.Region, scope.AppVersion)
+	if !ok {
+		// Configured histories have a version of the default scope
+		// effective at startup, so this only happens for clocks set back.
+		for _, version := range ec.history {
+			if version.ExposureConfigScope == (diag.ExposureConfigScope{}) {
+				return version, ec.encoded[version.ID]
+			}
+		}
+	}
+	return version, ec.encoded[version.ID]
+}
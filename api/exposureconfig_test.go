@@ -0,0 +1,242 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+type testExposureConfigRepository struct {
+	testRepository
+	mu       sync.Mutex
+	versions diag.ExposureConfigHistory
+}
+
+func (ts *testExposureConfigRepository) StoreExposureConfig(_ context.Context, version diag.ExposureConfigVersion) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.versions = append(ts.versions, version)
+	return nil
+}
+
+func (ts *testExposureConfigRepository) FindExposureConfigs(_ context.Context) (diag.ExposureConfigHistory, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return append(diag.ExposureConfigHistory(nil), ts.versions...), nil
+}
+
+func TestExposureConfigHistory(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	history := diag.ExposureConfigHistory{
+		{ID: "v1", EffectiveFrom: now.Add(-48 * time.Hour), Config: diag.ExposureConfig{MinimumRiskScore: 1}},
+		{ID: "v2", EffectiveFrom: now.Add(-24 * time.Hour), Config: diag.ExposureConfig{MinimumRiskScore: 2}},
+		{ID: "v3", EffectiveFrom: now.Add(24 * time.Hour), Config: diag.ExposureConfig{MinimumRiskScore: 3}},
+	}
+	handler, err := NewHandler(context.Background(), Config{
+		Diag:       diag.Config{Repository: noopRepo, Logger: diag.NewNopLogger(), ExposureConfigHistory: history},
+		AdminToken: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("effective version", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/exposure-config", nil))
+		resp := w.Result()
+
+		if got, exp := resp.Header.Get("X-Exposure-Config-Version"), "v2"; got != exp {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+		var got diag.ExposureConfig
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, history[1].Config) {
+			t.Errorf("expected: %+v, got: %+v", history[1].Config, got)
+		}
+	})
+
+	t.Run("history", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/admin/exposure-config/history", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		resp := w.Result()
+
+		if got, exp := resp.StatusCode, http.StatusOK; got != exp {
+			t.Fatalf("expected: %v, got: %v", exp, got)
+		}
+		var got diag.ExposureConfigHistory
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, history) {
+			t.Errorf("expected: %+v, got: %+v", history, got)
+		}
+	})
+}
+
+func TestPutExposureConfig(t *testing.T) {
+	repo := &testExposureConfigRepository{testRepository: noopRepo}
+	handler, err := NewHandler(context.Background(), Config{
+		Diag:       diag.Config{Repository: repo, Logger: diag.NewNopLogger(), ExposureConfig: diag.ExposureConfig{MinimumRiskScore: 1}},
+		AdminToken: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(etag string) *http.Response {
+		req := httptest.NewRequest("GET", "http://example.com/exposure-config", nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result()
+	}
+	put := func(token, body string) *http.Response {
+		req := httptest.NewRequest("PUT", "http://example.com/exposure-config", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	resp := get("")
+	if got, exp := resp.Header.Get("X-Exposure-Config-Version"), defaultExposureConfigVersion; got != exp {
+		t.Errorf("expected: %v, got: %v", exp, got)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("expected ETag header")
+	}
+
+	t.Run("not modified", func(t *testing.T) {
+		if got, exp := get(etag).StatusCode, http.StatusNotModified; got != exp {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		if got, exp := put("", `{"minimumRiskScore": 2}`).StatusCode, http.StatusUnauthorized; got != exp {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+		if got, exp := put("wrong", `{"minimumRiskScore": 2}`).StatusCode, http.StatusUnauthorized; got != exp {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+	})
+
+	t.Run("invalid body", func(t *testing.T) {
+		resp := put("secret", `{"minimumRiskScor": 2}`)
+		if got, exp := readProblem(t, resp).Code, codeInvalidBody; got != exp {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+		if len(repo.versions) != 0 {
+			t.Errorf("expected no stored versions, got: %+v", repo.versions)
+		}
+	})
+
+	t.Run("store", func(t *testing.T) {
+		resp := put("secret", `{"minimumRiskScore": 2}`)
+		if got, exp := resp.StatusCode, http.StatusOK; got != exp {
+			t.Fatalf("expected: %v, got: %v", exp, got)
+		}
+		var version diag.ExposureConfigVersion
+		if err := json.NewDecoder(resp.Body).Decode(&version); err != nil {
+			t.Fatal(err)
+		}
+		if len(repo.versions) != 1 || repo.versions[0].ID != version.ID {
+			t.Fatalf("expected stored version %v, got: %+v", version.ID, repo.versions)
+		}
+
+		resp = get(etag)
+		if got, exp := resp.StatusCode, http.StatusOK; got != exp {
+			t.Fatalf("expected: %v, got: %v", exp, got)
+		}
+		if got := resp.Header.Get("X-Exposure-Config-Version"); got != version.ID {
+			t.Errorf("expected: %v, got: %v", version.ID, got)
+		}
+		var got diag.ExposureConfig
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.MinimumRiskScore != 2 {
+			t.Errorf("expected: 2, got: %v", got.MinimumRiskScore)
+		}
+	})
+
+	t.Run("scoped", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "http://example.com/exposure-config?region=nl&appVersion=1.2", strings.NewReader(`{"minimumRiskScore": 3}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var version diag.ExposureConfigVersion
+		if err := json.NewDecoder(w.Result().Body).Decode(&version); err != nil {
+			t.Fatal(err)
+		}
+		if version.Region != "NL" || version.AppVersion != "1.2" || !strings.HasPrefix(version.ID, "NL/1.2/") {
+			t.Fatalf("unexpected version: %+v", version)
+		}
+
+		tests := []struct {
+			query string
+			exp   uint8
+		}{
+			{"?region=NL&appVersion=1.10", 3},
+			{"?region=NL&appVersion=1.1", 2},
+			{"?region=BE&appVersion=1.2", 2},
+			{"?region=NL", 2},
+			{"", 2},
+		}
+		for _, tt := range tests {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/exposure-config"+tt.query, nil))
+			var got diag.ExposureConfig
+			if err := json.NewDecoder(w.Result().Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.MinimumRiskScore != tt.exp {
+				t.Errorf("%q: expected: %v, got: %v", tt.query, tt.exp, got.MinimumRiskScore)
+			}
+		}
+
+		for query, code := range map[string]string{"?region=NL,BE": codeInvalidRegionParam, "?appVersion=1.2-beta": codeInvalidAppVersionParam} {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/exposure-config"+query, nil))
+			if got := readProblem(t, w.Result()).Code; got != code {
+				t.Errorf("%q: expected: %v, got: %v", query, code, got)
+			}
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		handler, err := NewHandler(context.Background(), Config{
+			Diag:       diag.Config{Repository: noopRepo, Logger: diag.NewNopLogger()},
+			AdminToken: "secret",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("PUT", "http://example.com/exposure-config", strings.NewReader(`{}`))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if got, exp := w.Result().StatusCode, http.StatusNotFound; got != exp {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+	"github.com/dstotijn/ct-diag-server/federation"
+)
+
+// postFederatedKeys stores Diagnosis Keys pushed by a peer server (see package
+// federation), tagged with the origin whose key signed the push. Pushes are
+// only accepted if senders are configured.
+func (h *handler) postFederatedKeys(w http.ResponseWriter, r *http.Request) {
+	if len(h.federationSenders) == 0 {
+		writeNotFound(w)
+		return
+	}
+
+	maxBytesReader := http.MaxBytesReader(w, r.Body, federation.MaxBatchSize*diag.DiagnosisKeySize)
+	body, err := ioutil.ReadAll(maxBytesReader)
+	var diagKeys []diag.DiagnosisKey
+	if err == nil {
+		diagKeys, err = diag.ParseDiagnosisKeys(bytes.NewReader(body))
+	}
+	if err != nil {
+		code := codeInvalidKeyLength
+		if isMaxBytesError(err) {
+			code = codeBatchTooLarge
+		}
+		writeProblem(w, http.StatusBadRequest, code, fmt.Sprintf("Invalid body: %v", err))
+		return
+	}
+
+	origin, err := federation.VerifyPush(h.federationSenders, r.Header, body)
+	if err != nil {
+		writeProblem(w, http.StatusUnauthorized, codeInvalidSignature, err.Error())
+		return
+	}
+
+	if err := h.diagSvc.StoreFederatedDiagnosisKeys(r.Context(), diagKeys, origin); err != nil {
+		h.logger.Error("Could not store federated diagnosis keys", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	fmt.Fprint(w, "OK")
+}
+
+// federationStatusResponse is the JSON representation of the sync status of
+// federation peers.
+type federationStatusResponse struct {
+	Peers []federation.PeerStatus `json:"peers"`
+}
+
+// federationStatus writes the sync status of the peers keys are pulled from
+// in JSON, so operators notice broken exchanges. It's only available if peers
+// are configured.
+func (h *handler) federationStatus(w http.ResponseWriter, r *http.Request) {
+	if h.federation == nil {
+		writeNotFound(w)
+		return
+	}
+
+	writeJSON(w, federationStatusResponse{Peers: h.federation.Status()})
+}
+
+// pullFederation pulls the keys the peers published since the previous pull,
+// for schedulers of request-driven deployments, which don't pull in the
+// background. The sync status of the peers is written in JSON.
+func (h *handler) pullFederation(w http.ResponseWriter, r *http.Request) {
+	if h.federation == nil {
+		writeNotFound(w)
+		return
+	}
+
+	if err := h.federation.Pull(r.Context()); err != nil {
+		h.logger.Error("Could not pull diagnosis keys from peers", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	writeJSON(w, federationStatusResponse{Peers: h.federation.Status()})
+}
+
+// pushFederation pushes the local keys stored since the previous push to the
+// peers, see pullFederation.
+func (h *handler) pushFederation(w http.ResponseWriter, r *http.Request) {
+	if h.federationPusher == nil {
+		writeNotFound(w)
+		return
+	}
+
+	if err := h.federationPusher.Push(r.Context()); err != nil {
+		h.logger.Error("Could not push diagnosis keys to peers", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	fmt.Fprint(w, "OK")
+}
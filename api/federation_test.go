@@ -0,0 +1,210 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+	"github.com/dstotijn/ct-diag-server/federation"
+)
+
+type testOriginRepository struct {
+	testRepository
+	origins map[[16]byte]string
+}
+
+func (ts *testOriginRepository) StoreDiagnosisKeysWithOrigin(_ context.Context, diagKeys []diag.DiagnosisKey, _ time.Time, origin string) error {
+	for _, diagKey := range diagKeys {
+		ts.origins[diagKey.TemporaryExposureKey] = origin
+	}
+	return nil
+}
+
+func TestPostFederatedKeys(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	diag.WriteDiagnosisKeys(&body, diag.DiagnosisKey{TemporaryExposureKey: [16]byte{'n', 1}})
+	digest := sha256.Sum256(body.Bytes())
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &testOriginRepository{testRepository: noopRepo, origins: make(map[[16]byte]string)}
+	newHandler := func(senders map[string]*ecdsa.PublicKey) http.Handler {
+		handler, err := NewHandler(context.Background(), Config{
+			Diag:              diag.Config{Repository: repo, Logger: diag.NewNopLogger()},
+			FederationSenders: senders,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return handler
+	}
+	post := func(h http.Handler, origin string, signature []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "http://example.com/federation/diagnosis-keys", bytes.NewReader(body.Bytes()))
+		req.Header.Set(federation.OriginHeader, origin)
+		req.Header.Set(federation.SignatureHeader, base64.StdEncoding.EncodeToString(signature))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		if got, exp := post(newHandler(nil), "NL", signature).Code, 404; got != exp {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+	})
+
+	handler := newHandler(map[string]*ecdsa.PublicKey{"NL": &key.PublicKey})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		for _, tt := range []struct {
+			origin    string
+			signature []byte
+		}{
+			{"BE", signature},
+			{"NL", []byte("foobar")},
+		} {
+			w := post(handler, tt.origin, tt.signature)
+			if got := readProblem(t, w.Result()).Code; got != codeInvalidSignature {
+				t.Errorf("expected: %v, got: %v", codeInvalidSignature, got)
+			}
+		}
+		if len(repo.origins) != 0 {
+			t.Errorf("expected no stored keys, got: %v", repo.origins)
+		}
+	})
+
+	t.Run("valid signature", func(t *testing.T) {
+		if got, exp := post(handler, "NL", signature).Code, 200; got != exp {
+			t.Fatalf("expected: %v, got: %v", exp, got)
+		}
+		if got := repo.origins[[16]byte{'n', 1}]; got != "NL" {
+			t.Errorf("expected: NL, got: %q", got)
+		}
+	})
+}
+
+func TestFederationStatus(t *testing.T) {
+	newHandler := func(puller *federation.Puller) http.Handler {
+		handler, err := NewHandler(context.Background(), Config{
+			Diag:       diag.Config{Repository: noopRepo, Logger: diag.NewNopLogger()},
+			AdminToken: "secret",
+			Federation: puller,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return handler
+	}
+	get := func(h http.Handler) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://example.com/admin/federation/status", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("pulling disabled", func(t *testing.T) {
+		if got, exp := get(newHandler(nil)).Code, http.StatusNotFound; got != exp {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+	})
+
+	t.Run("peers", func(t *testing.T) {
+		puller, err := federation.New(federation.Config{
+			Repository: noopRepo,
+			Peers:      []federation.Peer{{Origin: "DE", URL: "https://diag.example.de"}},
+			Logger:     diag.NewNopLogger(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := get(newHandler(puller))
+		if got, exp := w.Code, http.StatusOK; got != exp {
+			t.Fatalf("expected: %v, got: %v", exp, got)
+		}
+		var body federationStatusResponse
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if len(body.Peers) != 1 || body.Peers[0].Origin != "DE" || body.Peers[0].LastSuccess != nil {
+			t.Errorf("unexpected peers: %+v", body.Peers)
+		}
+	})
+}
+
+func TestPullFederation(t *testing.T) {
+	var status int32 = http.StatusOK
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer peer.Close()
+
+	puller, err := federation.New(federation.Config{
+		Repository: noopRepo,
+		Peers:      []federation.Peer{{Origin: "DE", URL: peer.URL}},
+		Logger:     diag.NewNopLogger(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := NewHandler(context.Background(), Config{
+		Diag:       diag.Config{Repository: noopRepo, Logger: diag.NewNopLogger()},
+		AdminToken: "secret",
+		Federation: puller,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "http://example.com"+path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("pull", func(t *testing.T) {
+		w := post("/admin/federation/pull")
+		if got, exp := w.Code, http.StatusOK; got != exp {
+			t.Fatalf("expected: %v, got: %v", exp, got)
+		}
+		var body federationStatusResponse
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if len(body.Peers) != 1 || body.Peers[0].LastSuccess == nil {
+			t.Errorf("expected successful pull, got: %+v", body.Peers)
+		}
+	})
+
+	t.Run("failing peer", func(t *testing.T) {
+		atomic.StoreInt32(&status, http.StatusBadGateway)
+		if got, exp := post("/admin/federation/pull").Code, http.StatusInternalServerError; got != exp {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+	})
+
+	t.Run("pushing disabled", func(t *testing.T) {
+		if got, exp := post("/admin/federation/push").Code, http.StatusNotFound; got != exp {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+	})
+}
@@ -3,125 +3,696 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dstotijn/ct-diag-server/diag"
-
-	"go.uber.org/zap"
+	"github.com/dstotijn/ct-diag-server/federation"
+	"github.com/dstotijn/ct-diag-server/verification"
 )
 
+// maxRevocationBatchSize is the maximum amount of keys per revocation request.
+const maxRevocationBatchSize = 1000
+
+// dateLayout is the layout of the date in date-scoped listing URLs.
+const dateLayout = "2006-01-02"
+
 type handler struct {
-	diagSvc diag.Service
-	logger  *zap.Logger
+	diagSvc    diag.Service
+	logger     diag.Logger
+	adminToken string
+	slos       *sloTracker
+	// readinessTimeout is the timeout of the dependency checks of
+	// `/health/ready`.
+	readinessTimeout time.Duration
+	// streamHeartbeat is the interval of heartbeats of idle key streams.
+	streamHeartbeat time.Duration
+	hourlyBuckets   bool
+	errorLog        *diag.ErrorLog
+	captureDir      string
+	uploadStats     *uploadStats
+	verifier        *verification.Verifier
+	messages        Messages
+	regions         bool
+	reportTypes     bool
+	// rollingPeriods enables uploads in the extended binary representation.
+	rollingPeriods bool
+	// exposureConfigs holds the versions of the exposure configuration, see
+	// diag.Config.ExposureConfigHistory and diag.ExposureConfigRepository.
+	exposureConfigs *exposureConfigs
+	// federationSenders are the public keys of the peers that may push keys,
+	// by origin.
+	federationSenders map[string]*ecdsa.PublicKey
+	federation        *federation.Puller
+	federationPusher  *federation.Pusher
+	// requestDriven disables background goroutines, see
+	// Config.RequestDriven.
+	requestDriven bool
+	// openAPIDoc is the JSON encoding of the OpenAPI document of the routes.
+	openAPIDoc []byte
+}
+
+// Config represents the configuration to create a Handler.
+type Config struct {
+	Diag diag.Config
+	// Service, if set, is used instead of creating a service from Diag, e.g.
+	// so the caller can write a cache snapshot on shutdown. Diag is still used
+	// for the exposure configuration.
+	Service *diag.Service
+	Logger  diag.Logger
+	// AdminToken is the bearer token required for requests to `/admin`
+	// endpoints. Admin endpoints are disabled when empty.
+	AdminToken string
+	// SLOWindow is the rolling time window used for SLO reporting.
+	SLOWindow time.Duration
+	// ReadinessTimeout is the timeout of the dependency checks of
+	// `/health/ready`. Zero means 2 seconds.
+	ReadinessTimeout time.Duration
+	// StreamHeartbeat is the interval of heartbeats of idle key streams (see
+	// `/admin/diagnosis-keys/stream`), so intermediaries don't close their
+	// connections. Zero means 15 seconds.
+	StreamHeartbeat time.Duration
+	// SLOWebhookURL is the URL SLO reports are periodically POSTed to,
+	// disabled when empty.
+	SLOWebhookURL      string
+	SLOWebhookInterval time.Duration
+	// HourlyBuckets enables hour-scoped listings, e.g.
+	// `/diagnosis-keys/2020-05-04/13`, for sub-daily notification latency.
+	// The cache is then refreshed at the start of every hour, so buckets are
+	// published as soon as they are complete (unless Service is set, in which
+	// case its CacheAlignment should be set accordingly).
+	HourlyBuckets bool
+	// Docs, if set, is served at `/docs/`, e.g. the embedded API
+	// documentation (see package assets).
+	Docs fs.FS
+	// ErrorLog, if set, provides the recent errors listed on the status page
+	// (`/admin/status`). It should wrap the loggers of the service and the
+	// handler.
+	ErrorLog *diag.ErrorLog
+	// CaptureDir, if set, is the directory sanitized copies of malformed
+	// uploads are stored in, for debugging client integrations. It's meant
+	// for development only.
+	CaptureDir string
+	// DigestWebhookURL is the URL a daily digest (uploads, rejections,
+	// publications and anomalies) is POSTed to, disabled when empty.
+	DigestWebhookURL string
+	// DigestSMTP, if set, is used to email the daily digest.
+	DigestSMTP *SMTPConfig
+	// Verifier, if set, requires uploads to carry a valid verification
+	// certificate (see package verification) in the
+	// `X-Verification-Certificate` header, with the HMAC key of its `tekmac`
+	// claim (base64 encoded) in the `X-Verification-HMAC-Key` header.
+	Verifier *verification.Verifier
+	// Messages overrides or adds to the built-in user-facing error messages
+	// (see ParseMessages), which are localized per `Accept-Language`.
+	Messages Messages
+	// FederationSenders, if set, are the public keys of the peer servers that
+	// may push Diagnosis Keys to `/federation/diagnosis-keys` (see package
+	// federation), by origin.
+	FederationSenders map[string]*ecdsa.PublicKey
+	// Federation, if set, is the puller whose peers are listed at
+	// `/admin/federation/status`. Pulls can be triggered via
+	// `/admin/federation/pull`, and pushes of FederationPusher, if set, via
+	// `/admin/federation/push`.
+	Federation       *federation.Puller
+	FederationPusher *federation.Pusher
+	// Regions enables tagging uploads with regions (the `regions` query
+	// parameter) and region-scoped listings (`/diagnosis-keys?region=NL`).
+	// The repository must store regions, and the cache must implement
+	// diag.RegionCache.
+	Regions bool
+	// ReportTypes enables report types and symptom onsets on upload (the
+	// `reportType` and `symptomOnsetInterval` query parameters, or the claims
+	// of verification certificates), which are included in export files. The
+	// repository must store them, and the cache must implement
+	// diag.ReportCache.
+	ReportTypes bool
+	// RollingPeriods enables uploads with rolling periods, in the extended
+	// binary representation (`Content-Type:
+	// application/vnd.ct-diag.extended-keys`), which are included in export
+	// files. The repository must store them, and the cache must implement
+	// diag.RollingPeriodCache.
+	RollingPeriods bool
+	// RequestDriven makes the handler suitable for request-driven
+	// environments (e.g. AWS Lambda or Cloud Run), which suspend instances
+	// between requests: no background goroutines are started, the cache is
+	// hydrated on demand (see diag.Config.RequestDriven, which Service must
+	// be created with), and stored exposure configurations are reloaded on
+	// request. SLO and digest webhooks can't be used.
+	RequestDriven bool
 }
 
 // NewHandler returns a new Handler.
-func NewHandler(ctx context.Context, cfg diag.Config, logger *zap.Logger) (http.Handler, error) {
-	diagSvc, err := diag.NewService(ctx, cfg)
-	if err != nil {
-		return nil, err
+func NewHandler(ctx context.Context, cfg Config) (http.Handler, error) {
+	if cfg.HourlyBuckets && cfg.Diag.CacheAlignment == 0 {
+		cfg.Diag.CacheAlignment = time.Hour
+	}
+	if cfg.ReadinessTimeout == 0 {
+		cfg.ReadinessTimeout = defaultReadinessTimeout
+	}
+	if cfg.StreamHeartbeat == 0 {
+		cfg.StreamHeartbeat = defaultStreamHeartbeat
+	}
+	if cfg.RequestDriven {
+		if cfg.SLOWebhookURL != "" || cfg.DigestWebhookURL != "" || cfg.DigestSMTP != nil {
+			return nil, errors.New("api: webhooks and digests require background goroutines, which request-driven handlers don't run")
+		}
+		cfg.Diag.RequestDriven = true
+	}
+
+	var diagSvc diag.Service
+	if cfg.Service != nil {
+		diagSvc = *cfg.Service
+	} else {
+		var err error
+		diagSvc, err = diag.NewService(ctx, cfg.Diag)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = cfg.Diag.Logger
 	}
 
 	h := handler{
-		diagSvc: diagSvc,
-		logger:  logger,
+		diagSvc:           diagSvc,
+		logger:            logger,
+		adminToken:        cfg.AdminToken,
+		slos:              newSLOTracker(cfg.SLOWindow),
+		readinessTimeout:  cfg.ReadinessTimeout,
+		streamHeartbeat:   cfg.StreamHeartbeat,
+		hourlyBuckets:     cfg.HourlyBuckets,
+		errorLog:          cfg.ErrorLog,
+		captureDir:        cfg.CaptureDir,
+		uploadStats:       newUploadStats(),
+		verifier:          cfg.Verifier,
+		messages:          defaultMessages.merge(cfg.Messages),
+		regions:           cfg.Regions,
+		reportTypes:       cfg.ReportTypes,
+		rollingPeriods:    cfg.RollingPeriods,
+		federationSenders: cfg.FederationSenders,
+		federation:        cfg.Federation,
+		federationPusher:  cfg.FederationPusher,
+		requestDriven:     cfg.RequestDriven,
+	}
+
+	expConfigHandler, err := h.exposureConfig(ctx, cfg.Diag)
+	if err != nil {
+		return nil, err
 	}
 
-	expConfigHandler, err := exposureConfig(cfg.ExposureConfig)
+	routes := h.routes(expConfigHandler)
+	doc, err := openAPI(routes)
 	if err != nil {
 		return nil, err
 	}
+	if h.openAPIDoc, err = json.Marshal(doc); err != nil {
+		return nil, err
+	}
 
 	mux := http.NewServeMux()
+	for _, rt := range routes {
+		mux.HandleFunc(rt.pattern, h.wrap(rt))
+	}
 
-	mux.HandleFunc("/diagnosis-keys", h.diagnosisKeys)
-	mux.HandleFunc("/exposure-config", expConfigHandler)
-	mux.HandleFunc("/health", h.health)
+	if cfg.Docs != nil {
+		mux.Handle("/docs/", http.StripPrefix("/docs/", http.FileServer(http.FS(cfg.Docs))))
+	}
+
+	if cfg.SLOWebhookURL != "" {
+		go h.slos.pushReports(ctx, cfg.SLOWebhookURL, cfg.SLOWebhookInterval, logger)
+	}
+	if cfg.DigestWebhookURL != "" || cfg.DigestSMTP != nil {
+		go h.pushDigests(ctx, cfg.DigestWebhookURL, cfg.DigestSMTP)
+	}
 
 	return mux, nil
 }
 
 // diagnosisKeys handles both GET and POST requests.
 func (h *handler) diagnosisKeys(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodHead:
-		fallthrough
-	case http.MethodGet:
-		h.listDiagnosisKeys(w, r)
-	case http.MethodPost:
+	if r.Method == http.MethodPost {
 		h.postDiagnosisKeys(w, r)
-	default:
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
 	}
+	h.listDiagnosisKeys(w, r)
 }
 
 // listDiagnosisKeys writes all diagnosis keys as binary data in the HTTP response.
 func (h *handler) listDiagnosisKeys(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Cache-Control", "public, max-age=0, s-maxage=600")
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 
+	query := r.URL.Query()
+	if _, ok := query["region"]; ok {
+		h.listDiagnosisKeysRegion(w, r)
+		return
+	}
+	_, cursorSet := query["cursor"]
+	_, limitSet := query["limit"]
+	if cursorSet || limitSet {
+		if query.Get("after") != "" {
+			writeProblem(w, http.StatusBadRequest, codeConflictingParams, "The `after` query parameter can't be combined with `cursor` or `limit`.")
+			return
+		}
+		h.listDiagnosisKeysPage(w, r)
+		return
+	}
+
 	var after [16]byte
-	afterParam := r.URL.Query().Get("after")
+	afterParam := query.Get("after")
 	if afterParam != "" {
 		buf, err := hex.DecodeString(afterParam)
 		if err != nil || len(buf) != 16 {
-			msg := fmt.Sprintf("Invalid `after` query parameter, must be the hexadecimal encoding of a 16 byte key.")
-			http.Error(w, msg, http.StatusBadRequest)
+			msg := "Invalid `after` query parameter, must be the hexadecimal encoding of a 16 byte key."
+			writeProblem(w, http.StatusBadRequest, codeInvalidAfterParam, msg)
 			return
 		}
 
 		copy(after[:], buf)
 	}
 
+	// With the `ETag` header set, `http.ServeContent` replies to conditional
+	// requests (`If-None-Match`, `If-Modified-Since`) with a `304 Not Modified`.
+	w.Header().Set("ETag", h.diagSvc.ETag(afterParam))
+
 	rs := h.diagSvc.ReadSeeker(after)
 	lastModified := h.diagSvc.LastModified()
 	http.ServeContent(w, r, "", lastModified, rs)
 }
 
+// lastModifiedResponse is the JSON representation of the last modified time
+// of the Diagnosis Keys. It's null if no keys are published yet.
+type lastModifiedResponse struct {
+	LastModified *time.Time `json:"lastModified"`
+}
+
+// lastModified writes the last modified time of the Diagnosis Keys, both as
+// `Last-Modified` header and JSON, so clients can cheaply check for updates.
+// Only the cache metadata is used; the keys themselves are never read.
+func (h *handler) lastModified(w http.ResponseWriter, r *http.Request) {
+	var resp lastModifiedResponse
+	lastModified := h.diagSvc.LastModified()
+	if !lastModified.IsZero() {
+		t := lastModified.UTC()
+		resp.LastModified = &t
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	http.ServeContent(w, r, "", lastModified, bytes.NewReader(body))
+}
+
+// batchIndexResponse is the JSON representation of the available batches.
+type batchIndexResponse struct {
+	Batches []batchResponse `json:"batches"`
+}
+
+// batchResponse is the JSON representation of a batch, with the path of its
+// date-scoped or hour-scoped listing.
+type batchResponse struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Keys  int64     `json:"keys"`
+	Path  string    `json:"path"`
+}
+
+// batchIndex writes the published batches of the last 14 days in JSON, so
+// clients can fetch only the batches they don't have yet, instead of the
+// full set of Diagnosis Keys.
+func (h *handler) batchIndex(w http.ResponseWriter, r *http.Request) {
+	batches, err := h.diagSvc.Batches(h.hourlyBuckets)
+	if err != nil {
+		h.logger.Error("Could not list batches", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	resp := batchIndexResponse{Batches: make([]batchResponse, len(batches))}
+	for i, batch := range batches {
+		path := "/diagnosis-keys/" + batch.Start.Format(dateLayout)
+		if batch.End.Sub(batch.Start) == time.Hour {
+			path += batch.Start.Format("/15")
+		}
+		resp.Batches[i] = batchResponse{Start: batch.Start, End: batch.End, Keys: batch.Keys, Path: path}
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	http.ServeContent(w, r, "", h.diagSvc.LastModified(), bytes.NewReader(body))
+}
+
+// diagnosisKeysByTime handles GET requests for date-scoped listings, e.g.
+// `/diagnosis-keys/2020-05-04`, which contain the Diagnosis Keys published on
+// that (UTC) date, and (if enabled) hour-scoped listings, e.g.
+// `/diagnosis-keys/2020-05-04/13`. Listings of the past never change, so they
+// can be cached for a long time. Hours are only served once complete.
+func (h *handler) diagnosisKeysByTime(w http.ResponseWriter, r *http.Request) {
+	start, end, ok := h.parseBucket(strings.TrimPrefix(r.URL.Path, "/diagnosis-keys/"))
+	if !ok || start.After(time.Now()) {
+		writeNotFound(w)
+		return
+	}
+	published := h.diagSvc.IsPublished(end)
+	if end.Sub(start) == time.Hour && !published {
+		writeNotFound(w)
+		return
+	}
+
+	if published {
+		w.Header().Set("Cache-Control", cacheLong)
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	lastModified := h.diagSvc.LastModified()
+	if lastModified.After(end) {
+		lastModified = end
+	}
+
+	rs := h.diagSvc.ReadSeekerBetween(start, end)
+	http.ServeContent(w, r, "", lastModified, rs)
+}
+
+// exportByTime handles GET requests for signed Exposure Notification export
+// files of date-scoped (or hour-scoped) listings, e.g.
+// `/exposure-key-export/2020-05-04.zip`. Each export is a single batch, so
+// it's only served once the time range is published, and never changes.
+func (h *handler) exportByTime(w http.ResponseWriter, r *http.Request) {
+	bucket := strings.TrimPrefix(r.URL.Path, "/exposure-key-export/")
+	if !strings.HasSuffix(bucket, ".zip") {
+		writeNotFound(w)
+		return
+	}
+	start, end, ok := h.parseBucket(strings.TrimSuffix(bucket, ".zip"))
+	if !ok || !h.diagSvc.IsPublished(end) {
+		writeNotFound(w)
+		return
+	}
+
+	file, err := h.diagSvc.Export(start, end)
+	if err == diag.ErrExportUnsupported {
+		writeNotFound(w)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Could not export diagnosis keys", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+
+	lastModified := h.diagSvc.LastModified()
+	if lastModified.After(end) {
+		lastModified = end
+	}
+	http.ServeContent(w, r, "", lastModified, bytes.NewReader(file))
+}
+
+// parseBucket parses a time bucket in the format `2006-01-02` or, if hourly
+// buckets are enabled, `2006-01-02/15`.
+func (h *handler) parseBucket(s string) (start, end time.Time, ok bool) {
+	i := strings.IndexByte(s, '/')
+	if i == -1 {
+		start, err := time.Parse(dateLayout, s)
+		if err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+		return start, start.AddDate(0, 0, 1), true
+	}
+	if !h.hourlyBuckets {
+		return time.Time{}, time.Time{}, false
+	}
+
+	date, hour := s[:i], s[i+1:]
+	start, err := time.Parse(dateLayout, date)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	h24, err := strconv.Atoi(hour)
+	if err != nil || len(hour) != 2 || h24 < 0 || h24 > 23 {
+		return time.Time{}, time.Time{}, false
+	}
+	start = start.Add(time.Duration(h24) * time.Hour)
+
+	return start, start.Add(time.Hour), true
+}
+
 // postDiagnosisKeys reads POST data from an HTTP request and stores it.
 func (h *handler) postDiagnosisKeys(w http.ResponseWriter, r *http.Request) {
-	uploadLimit := h.diagSvc.MaxUploadBatchSize() * diag.DiagnosisKeySize
+	client := parseAppClient(r)
+	regions, ok := h.parseUploadRegions(w, r)
+	if !ok {
+		h.uploadStats.reject(client, rejectInvalidBody)
+		return
+	}
+	report, ok := h.parseUploadReport(w, r)
+	if !ok {
+		h.uploadStats.reject(client, rejectInvalidBody)
+		return
+	}
+	keySize, parse := h.uploadFormat(r)
+	uploadLimit := h.diagSvc.MaxUploadBatchSize() * keySize
 	maxBytesReader := http.MaxBytesReader(w, r.Body, int64(uploadLimit))
-	diagKeys, err := diag.ParseDiagnosisKeys(maxBytesReader)
+	body, err := ioutil.ReadAll(maxBytesReader)
+	var diagKeys []diag.DiagnosisKey
+	if err == nil {
+		diagKeys, err = parse(bytes.NewReader(body))
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Invalid body: %v", err), http.StatusBadRequest)
+		h.uploadStats.reject(client, rejectInvalidBody)
+		if h.captureDir != "" {
+			h.captureUpload(r, body)
+		}
+		code := codeInvalidKeyLength
+		switch {
+		case isMaxBytesError(err):
+			code = codeBatchTooLarge
+		case err != io.ErrUnexpectedEOF:
+			// E.g. an invalid rolling period in the extended format.
+			code = codeInvalidBody
+		}
+		h.writeError(w, r, http.StatusBadRequest, code, msgInvalidBody, err)
 		return
 	}
+	for i := range diagKeys {
+		diagKeys[i].Regions = regions
+	}
 
-	err = h.diagSvc.StoreDiagnosisKeys(r.Context(), diagKeys)
+	if h.verifier != nil {
+		hmacKey, _ := base64.StdEncoding.DecodeString(r.Header.Get("X-Verification-HMAC-Key"))
+		claims, err := h.verifier.Verify(r.Header.Get("X-Verification-Certificate"), hmacKey, body)
+		if err != nil {
+			h.uploadStats.reject(client, rejectUnauthorized)
+			h.writeError(w, r, http.StatusUnauthorized, codeInvalidCertificate, msgInvalidCertificate, err)
+			return
+		}
+		if err := h.verifier.AuthorizeRegions(claims, regions); err != nil {
+			// Valid certificates for other regions point at a misconfigured
+			// or compromised verification server, so rejections are logged.
+			h.logger.Warn("Upload rejected for regions of certificate.",
+				diag.F("regions", strings.Join(regions, ",")),
+				diag.F("issuer", claims.Issuer),
+				diag.F("keyID", claims.KeyID),
+				diag.F("remoteAddr", r.RemoteAddr),
+				diag.F("userAgent", r.UserAgent()),
+			)
+			h.uploadStats.reject(client, rejectUnauthorized)
+			h.writeError(w, r, http.StatusForbidden, codeRegionNotAllowed, msgInvalidCertificate, err)
+			return
+		}
+		if h.reportTypes {
+			report = report.withClaims(claims)
+		}
+	}
+	report.apply(diagKeys)
+
+	uploadedAt := time.Now()
+	stored, err := h.diagSvc.StoreDiagnosisKeys(r.Context(), diagKeys)
+	if err == diag.ErrUploadQueueFull || err == diag.ErrOverloaded {
+		reason := rejectQueueFull
+		if err == diag.ErrOverloaded {
+			reason = rejectOverloaded
+		}
+		h.uploadStats.reject(client, reason)
+		// The delay grows with the queue depth, so clients back off adaptively.
+		retryAfter := h.diagSvc.Load().RetryAfter
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter/time.Second)))
+		h.writeError(w, r, http.StatusServiceUnavailable, codeUnavailable, msgUnavailable)
+		return
+	}
+	if err == diag.ErrMaxUploadExceeded {
+		h.uploadStats.reject(client, rejectInvalidBody)
+		h.writeError(w, r, http.StatusBadRequest, codeBatchTooLarge, msgInvalidBody, err)
+		return
+	}
+	if verr, ok := err.(*diag.InvalidKeysError); ok {
+		h.uploadStats.reject(client, rejectInvalidBody)
+		if h.captureDir != "" {
+			h.captureUpload(r, body)
+		}
+		h.writeError(w, r, http.StatusBadRequest, codeImplausibleKeys, msgInvalidBody, verr)
+		return
+	}
+	if err == diag.ErrQuotaExceeded {
+		h.uploadStats.reject(client, rejectQuotaExceeded)
+		h.writeError(w, r, http.StatusServiceUnavailable, codeQuotaExceeded, msgUnavailable)
+		return
+	}
 	if err != nil {
-		h.logger.Error("Could not store diagnosis keys", zap.Error(err))
-		writeInternalErrorResp(w, err)
+		h.uploadStats.reject(client, rejectError)
+		h.logger.Error("Could not store diagnosis keys", diag.Err(err))
+		h.writeError(w, r, http.StatusInternalServerError, codeInternalError, msgInternalError)
 		return
 	}
 
-	fmt.Fprint(w, "OK")
-}
+	h.uploadStats.accept(client, len(diagKeys))
+
+	publishAt := h.diagSvc.EstimatedPublicationTime(uploadedAt)
+	w.Header().Set("X-Estimated-Publication-Time", publishAt.Format(time.RFC3339))
+
+	// The receipt only covers the keys this upload stored, so keys uploaded
+	// before (e.g. published keys uploaded again) can't be withdrawn with it.
+	// These keys are stored already, so a failure to issue a receipt doesn't
+	// fail the upload.
+	if len(stored) > 0 {
+		receipt, err := h.diagSvc.IssueReceipt(stored, stored[0].UploadedAt)
+		switch err {
+		case nil:
+			w.Header().Set("X-Upload-Receipt", receipt)
+		case diag.ErrReceiptsUnsupported:
+		default:
+			h.logger.Error("Could not issue upload receipt", diag.Err(err))
+		}
+	}
 
-// health writes OK in the HTTP response.
-func (h *handler) health(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "OK")
 }
 
-func writeInternalErrorResp(w http.ResponseWriter, err error) {
-	code := http.StatusInternalServerError
-	http.Error(w, http.StatusText(code), code)
+// revocations writes the revocation list as binary data in the HTTP response,
+// with its signature (base64 encoded) in the `X-Signature` header.
+func (h *handler) revocations(w http.ResponseWriter, r *http.Request) {
+	rs, signature, lastModified, err := h.diagSvc.Revocations()
+	if err == diag.ErrRevocationUnsupported {
+		writeNotFound(w)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Could not get revocations", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("X-Signature", base64.StdEncoding.EncodeToString(signature))
+
+	http.ServeContent(w, r, "", lastModified, rs)
 }
 
-// exposureConfig returns the exposure configuration in JSON.
-func exposureConfig(expCfg diag.ExposureConfig) (http.HandlerFunc, error) {
-	buf, err := json.Marshal(expCfg)
+// postRevocations reads a bytestream of Temporary Exposure Keys (16 bytes
+// each) from an HTTP request, and revokes them. With `dryRun=true`, what
+// revoking them would change is written instead, and nothing is revoked.
+func (h *handler) postRevocations(w http.ResponseWriter, r *http.Request) {
+	maxBytesReader := http.MaxBytesReader(w, r.Body, maxRevocationBatchSize*16)
+	buf, err := ioutil.ReadAll(maxBytesReader)
+	if err == nil && (len(buf) == 0 || len(buf)%16 != 0) {
+		err = io.ErrUnexpectedEOF
+	}
 	if err != nil {
-		return nil, err
+		writeProblem(w, http.StatusBadRequest, codeInvalidKeyLength, fmt.Sprintf("Invalid body: %v", err))
+		return
+	}
+
+	keys := make([][16]byte, len(buf)/16)
+	for i := range keys {
+		copy(keys[i][:], buf[i*16:])
+	}
+
+	if isDryRun(r) {
+		h.revocationDryRun(w, r, keys)
+		return
+	}
+
+	err = h.diagSvc.RevokeDiagnosisKeys(r.Context(), keys)
+	if err == diag.ErrRevocationUnsupported {
+		writeNotFound(w)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Could not revoke diagnosis keys", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	h.logger.Info("Diagnosis keys revoked.", diag.F("count", len(keys)))
+
+	fmt.Fprint(w, "OK")
+}
+
+// refreshCache refreshes the cache from the repository. Concurrent requests
+// are coalesced into a single refresh.
+func (h *handler) refreshCache(w http.ResponseWriter, r *http.Request) {
+	if err := h.diagSvc.RefreshCache(r.Context()); err != nil {
+		h.logger.Error("Could not refresh cache", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
 	}
 
+	fmt.Fprint(w, "OK")
+}
+
+// requireAdmin wraps next, only allowing requests bearing the admin token.
+// If no admin token is configured, admin endpoints are disabled altogether.
+func (h *handler) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(buf)
-	}, nil
+		if h.adminToken == "" {
+			writeNotFound(w)
+			return
+		}
+
+		auth := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(auth, "Bearer ")
+		if auth == token || subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeProblem(w, http.StatusUnauthorized, codeUnauthorized, "")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// isMaxBytesError returns if err is returned by the reader of
+// http.MaxBytesReader, when the limit is exceeded. The error has no exported
+// type before Go 1.19, so its message is compared.
+func isMaxBytesError(err error) bool {
+	return err != nil && err.Error() == "http: request body too large"
 }
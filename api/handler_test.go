@@ -3,27 +3,37 @@ package api
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"io"
 	"io/ioutil"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/dstotijn/ct-diag-server/diag"
-
-	"go.uber.org/zap"
+	"github.com/dstotijn/ct-diag-server/federation"
+	"github.com/dstotijn/ct-diag-server/verification"
 )
 
 type testRepository struct {
 	storeDiagnosisKeysFn   func(context.Context, []diag.DiagnosisKey, time.Time) error
-	findAllDiagnosisKeysFn func(context.Context) ([]byte, error)
+	findAllDiagnosisKeysFn func(context.Context) ([]diag.DiagnosisKey, error)
 	lastModifiedFn         func(context.Context) (time.Time, error)
 }
 
@@ -31,7 +41,7 @@ func (ts testRepository) StoreDiagnosisKeys(ctx context.Context, diagKeys []diag
 	return ts.storeDiagnosisKeysFn(ctx, diagKeys, createdAt)
 }
 
-func (ts testRepository) FindAllDiagnosisKeys(ctx context.Context) ([]byte, error) {
+func (ts testRepository) FindAllDiagnosisKeys(ctx context.Context) ([]diag.DiagnosisKey, error) {
 	return ts.findAllDiagnosisKeysFn(ctx)
 }
 
@@ -41,21 +51,49 @@ func (ts testRepository) LastModified(ctx context.Context) (time.Time, error) {
 
 var noopRepo = testRepository{
 	storeDiagnosisKeysFn:   func(_ context.Context, _ []diag.DiagnosisKey, _ time.Time) error { return nil },
-	findAllDiagnosisKeysFn: func(_ context.Context) ([]byte, error) { return nil, nil },
+	findAllDiagnosisKeysFn: func(_ context.Context) ([]diag.DiagnosisKey, error) { return nil, nil },
 	lastModifiedFn:         func(_ context.Context) (time.Time, error) { return time.Time{}, nil },
 }
 
-func newTestHandler(t *testing.T, cfg *diag.Config) http.Handler {
+type testLogEntry struct {
+	level  string
+	msg    string
+	fields map[string]interface{}
+}
+
+// testLogger is a diag.Logger that records log entries.
+type testLogger struct {
+	mu      sync.Mutex
+	entries []testLogEntry
+}
+
+func (l *testLogger) log(level, msg string, fields []diag.Field) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := testLogEntry{level: level, msg: msg, fields: make(map[string]interface{})}
+	for _, f := range fields {
+		entry.fields[f.Key] = f.Value
+	}
+	l.entries = append(l.entries, entry)
+}
+
+func (l *testLogger) Debug(msg string, fields ...diag.Field) { l.log("debug", msg, fields) }
+func (l *testLogger) Info(msg string, fields ...diag.Field)  { l.log("info", msg, fields) }
+func (l *testLogger) Warn(msg string, fields ...diag.Field)  { l.log("warn", msg, fields) }
+func (l *testLogger) Error(msg string, fields ...diag.Field) { l.log("error", msg, fields) }
+
+func newTestHandler(t testing.TB, cfg *diag.Config) http.Handler {
 	if cfg == nil {
 		cfg = &diag.Config{Repository: noopRepo}
 	}
 
-	logger := zap.NewNop()
+	logger := diag.NewNopLogger()
 	if cfg.Logger == nil {
 		cfg.Logger = logger
 	}
 
-	handler, err := NewHandler(context.Background(), *cfg, logger)
+	handler, err := NewHandler(context.Background(), Config{Diag: *cfg, Logger: logger})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -86,6 +124,58 @@ func TestHealth(t *testing.T) {
 	if got := strings.TrimSpace(string(body)); got != expBody {
 		t.Errorf("expected: %v, got: `%s`", expBody, got)
 	}
+
+	t.Run("load hints", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/health", nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		var got healthResponse
+		if err := json.NewDecoder(w.Result().Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		exp := healthResponse{Status: "ok", Load: diag.Load{PoolSaturation: -1}, RetryAfterSeconds: 5}
+		if got != exp {
+			t.Errorf("expected: %+v, got: %+v", exp, got)
+		}
+	})
+}
+
+func TestDocs(t *testing.T) {
+	docs := fstest.MapFS{
+		"index.html":   &fstest.MapFile{Data: []byte("<html></html>")},
+		"openapi.yaml": &fstest.MapFile{Data: []byte("openapi: 3.0.0")},
+	}
+	handler, err := NewHandler(context.Background(), Config{
+		Diag:   diag.Config{Repository: noopRepo, Logger: diag.NewNopLogger()},
+		Logger: diag.NewNopLogger(),
+		Docs:   docs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, expBody := range map[string]string{"/docs/": "<html></html>", "/docs/openapi.yaml": "openapi: 3.0.0"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com"+path, nil))
+
+		body, err := ioutil.ReadAll(w.Result().Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != expBody {
+			t.Errorf("%v: expected: %v, got: `%s`", path, expBody, body)
+		}
+	}
+
+	// Docs are disabled by default.
+	w := httptest.NewRecorder()
+	newTestHandler(t, nil).ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/docs/", nil))
+	if got := w.Result().StatusCode; got != 404 {
+		t.Errorf("expected: %v, got: %v", 404, got)
+	}
 }
 
 func TestExposureConfig(t *testing.T) {
@@ -164,10 +254,8 @@ func TestListDiagnosisKeys(t *testing.T) {
 		expLastModified := time.Date(2020, time.May, 2, 23, 30, 0, 0, time.UTC)
 		cfg := &diag.Config{
 			Repository: testRepository{
-				findAllDiagnosisKeysFn: func(_ context.Context) ([]byte, error) {
-					buf := &bytes.Buffer{}
-					diag.WriteDiagnosisKeys(buf, expDiagKeys...)
-					return buf.Bytes(), nil
+				findAllDiagnosisKeysFn: func(_ context.Context) ([]diag.DiagnosisKey, error) {
+					return expDiagKeys, nil
 				},
 				lastModifiedFn: func(_ context.Context) (time.Time, error) { return expLastModified, nil },
 			},
@@ -236,7 +324,7 @@ func TestListDiagnosisKeys(t *testing.T) {
 			diagKeys      []diag.DiagnosisKey
 			after         string
 			expStatusCode int
-			expBody       string
+			expCode       string
 			expDiagKeys   []diag.DiagnosisKey
 		}{
 			{
@@ -245,7 +333,7 @@ func TestListDiagnosisKeys(t *testing.T) {
 				after:         "foobar",
 				expStatusCode: 400,
 				expDiagKeys:   nil,
-				expBody:       "Invalid `after` query parameter, must be the hexadecimal encoding of a 16 byte key.",
+				expCode:       codeInvalidAfterParam,
 			},
 			{
 				name:          "no diagnosis keys in database",
@@ -303,10 +391,8 @@ func TestListDiagnosisKeys(t *testing.T) {
 			t.Run(tt.name, func(t *testing.T) {
 				cfg := &diag.Config{
 					Repository: testRepository{
-						findAllDiagnosisKeysFn: func(_ context.Context) ([]byte, error) {
-							buf := &bytes.Buffer{}
-							diag.WriteDiagnosisKeys(buf, tt.diagKeys...)
-							return buf.Bytes(), nil
+						findAllDiagnosisKeysFn: func(_ context.Context) ([]diag.DiagnosisKey, error) {
+							return tt.diagKeys, nil
 						},
 						lastModifiedFn: noopRepo.lastModifiedFn,
 					},
@@ -326,13 +412,9 @@ func TestListDiagnosisKeys(t *testing.T) {
 					t.Errorf("expected: %v, got: %v", tt.expStatusCode, got)
 				}
 
-				if tt.expBody != "" {
-					body, err := ioutil.ReadAll(resp.Body)
-					if err != nil {
-						t.Fatal(err)
-					}
-					if got := strings.TrimSpace(string(body)); got != tt.expBody {
-						t.Fatalf("expected: %v, got: `%s`", tt.expBody, got)
+				if tt.expCode != "" {
+					if got := readProblem(t, resp).Code; got != tt.expCode {
+						t.Fatalf("expected: %v, got: %v", tt.expCode, got)
 					}
 				}
 
@@ -369,30 +451,649 @@ func TestListDiagnosisKeys(t *testing.T) {
 			})
 		}
 	})
+
+	t.Run("conditional requests", func(t *testing.T) {
+		diagKeys := []diag.DiagnosisKey{
+			{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: uint32(42)},
+			{TemporaryExposureKey: [16]byte{2}, RollingStartNumber: uint32(43)},
+		}
+		lastModified := time.Date(2020, time.May, 2, 23, 30, 0, 0, time.UTC)
+		cfg := &diag.Config{
+			Repository: testRepository{
+				findAllDiagnosisKeysFn: func(_ context.Context) ([]diag.DiagnosisKey, error) {
+					return diagKeys, nil
+				},
+				lastModifiedFn: func(_ context.Context) (time.Time, error) { return lastModified, nil },
+			},
+		}
+		handler := newTestHandler(t, cfg)
+
+		get := func(path string, header http.Header) *http.Response {
+			req := httptest.NewRequest("GET", "http://example.com"+path, nil)
+			for k := range header {
+				req.Header.Set(k, header.Get(k))
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			return w.Result()
+		}
+
+		resp := get("/diagnosis-keys", nil)
+		etag := resp.Header.Get("ETag")
+		if etag == "" || strings.HasPrefix(etag, "W/") {
+			t.Fatalf("expected strong ETag, got: %q", etag)
+		}
+		afterETag := get("/diagnosis-keys?after=01000000000000000000000000000000", nil).Header.Get("ETag")
+		if afterETag == etag {
+			t.Errorf("expected ETag to differ for `after` query parameter, got: %q", afterETag)
+		}
+
+		tests := []struct {
+			name          string
+			header        http.Header
+			expStatusCode int
+		}{
+			{
+				name:          "matching If-None-Match",
+				header:        http.Header{"If-None-Match": {etag}},
+				expStatusCode: http.StatusNotModified,
+			},
+			{
+				name:          "other If-None-Match",
+				header:        http.Header{"If-None-Match": {`"foobar"`}},
+				expStatusCode: http.StatusOK,
+			},
+			{
+				name:          "If-Modified-Since at last modified time",
+				header:        http.Header{"If-Modified-Since": {lastModified.Format(http.TimeFormat)}},
+				expStatusCode: http.StatusNotModified,
+			},
+			{
+				name:          "If-Modified-Since before last modified time",
+				header:        http.Header{"If-Modified-Since": {lastModified.Add(-time.Hour).Format(http.TimeFormat)}},
+				expStatusCode: http.StatusOK,
+			},
+			{
+				// If-None-Match takes precedence over If-Modified-Since.
+				name: "other If-None-Match with If-Modified-Since",
+				header: http.Header{
+					"If-None-Match":     {`"foobar"`},
+					"If-Modified-Since": {lastModified.Format(http.TimeFormat)},
+				},
+				expStatusCode: http.StatusOK,
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				resp := get("/diagnosis-keys", tt.header)
+				if got := resp.StatusCode; got != tt.expStatusCode {
+					t.Fatalf("expected: %v, got: %v", tt.expStatusCode, got)
+				}
+				body, _ := ioutil.ReadAll(resp.Body)
+				if tt.expStatusCode == http.StatusNotModified && len(body) != 0 {
+					t.Errorf("expected empty body, got: %v bytes", len(body))
+				}
+			})
+		}
+	})
 }
 
-func TestPostDiagnosisKeys(t *testing.T) {
-	t.Run("missing post body", func(t *testing.T) {
+func TestListDiagnosisKeysPage(t *testing.T) {
+	diagKeys := []diag.DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: 42, UploadedAt: time.Date(2020, time.May, 4, 12, 0, 0, 0, time.UTC)},
+		{TemporaryExposureKey: [16]byte{2}, RollingStartNumber: 43, UploadedAt: time.Date(2020, time.May, 4, 13, 0, 0, 0, time.UTC)},
+		{TemporaryExposureKey: [16]byte{3}, RollingStartNumber: 44, UploadedAt: time.Date(2020, time.May, 4, 14, 0, 0, 0, time.UTC)},
+	}
+	cfg := &diag.Config{
+		Repository: testRepository{
+			findAllDiagnosisKeysFn: func(_ context.Context) ([]diag.DiagnosisKey, error) {
+				return diagKeys, nil
+			},
+			lastModifiedFn: func(_ context.Context) (time.Time, error) { return diagKeys[2].UploadedAt, nil },
+		},
+	}
+	handler := newTestHandler(t, cfg)
+
+	get := func(target string) *http.Response {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		return w.Result()
+	}
+
+	t.Run("pages", func(t *testing.T) {
+		var got []byte
+		target := "http://example.com/diagnosis-keys?limit=2"
+		for pages := 0; target != ""; pages++ {
+			if pages > 2 {
+				t.Fatal("expected at most 2 pages")
+			}
+			resp := get(target)
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected: %v, got: %v", http.StatusOK, resp.StatusCode)
+			}
+			if resp.Header.Get("X-Next-Cursor") == "" {
+				t.Error("expected `X-Next-Cursor` header")
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			for i := 0; i < len(body); i += diag.DiagnosisKeySize {
+				got = append(got, body[i])
+			}
+
+			target = ""
+			if link := resp.Header.Get("Link"); link != "" {
+				if !strings.HasSuffix(link, `>; rel="next"`) {
+					t.Fatalf("unexpected `Link` header: %v", link)
+				}
+				target = "http://example.com" + strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+			}
+		}
+		if exp := []byte{1, 2, 3}; !bytes.Equal(got, exp) {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+	})
+
+	t.Run("next cursor at end", func(t *testing.T) {
+		resp := get("http://example.com/diagnosis-keys?cursor=")
+		body, _ := ioutil.ReadAll(resp.Body)
+		if got, exp := len(body), len(diagKeys)*diag.DiagnosisKeySize; got != exp {
+			t.Fatalf("expected: %v bytes, got: %v", exp, got)
+		}
+		if link := resp.Header.Get("Link"); link != "" {
+			t.Errorf("expected no `Link` header, got: %v", link)
+		}
+
+		resp = get("http://example.com/diagnosis-keys?cursor=" + resp.Header.Get("X-Next-Cursor"))
+		body, _ = ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || len(body) != 0 {
+			t.Errorf("expected empty page, got: %v (%v bytes)", resp.StatusCode, len(body))
+		}
+	})
+
+	t.Run("signed pages", func(t *testing.T) {
+		if sig := get("http://example.com/diagnosis-keys?limit=2").Header.Get("X-Signature"); sig != "" {
+			t.Errorf("expected no `X-Signature` header without signing key, got: %v", sig)
+		}
+
+		signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed := newTestHandler(t, &diag.Config{Repository: cfg.Repository, Signer: signingKey})
+		w := httptest.NewRecorder()
+		signed.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/diagnosis-keys?limit=2", nil))
+		resp := w.Result()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if got, exp := len(body), 2*diag.DiagnosisKeySize; got != exp {
+			t.Fatalf("expected: %v bytes, got: %v", exp, got)
+		}
+
+		verifier := federation.SignatureVerifier{"NL": &signingKey.PublicKey}
+		if err := verifier.VerifyPage(federation.Peer{Origin: "NL"}, resp.Header, body); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	for _, target := range []string{
+		"/diagnosis-keys?cursor=foobar",
+		"/diagnosis-keys?limit=0",
+		"/diagnosis-keys?limit=foobar",
+		"/diagnosis-keys?limit=2&after=01000000000000000000000000000000",
+	} {
+		t.Run(target, func(t *testing.T) {
+			if got := get("http://example.com" + target).StatusCode; got != http.StatusBadRequest {
+				t.Errorf("expected: %v, got: %v", http.StatusBadRequest, got)
+			}
+		})
+	}
+}
+
+func TestListDiagnosisKeysByDate(t *testing.T) {
+	diagKeys := []diag.DiagnosisKey{
+		{
+			TemporaryExposureKey: [16]byte{1},
+			RollingStartNumber:   uint32(42),
+			UploadedAt:           time.Date(2020, time.May, 3, 23, 59, 0, 0, time.UTC),
+		},
+		{
+			TemporaryExposureKey: [16]byte{2},
+			RollingStartNumber:   uint32(43),
+			UploadedAt:           time.Date(2020, time.May, 4, 13, 0, 0, 0, time.UTC),
+		},
+		{
+			TemporaryExposureKey: [16]byte{3},
+			RollingStartNumber:   uint32(44),
+			UploadedAt:           time.Date(2020, time.May, 5, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	lastModified := time.Date(2020, time.May, 5, 0, 0, 0, 0, time.UTC)
+	cfg := &diag.Config{
+		Repository: testRepository{
+			findAllDiagnosisKeysFn: func(_ context.Context) ([]diag.DiagnosisKey, error) {
+				return diagKeys, nil
+			},
+			lastModifiedFn: func(_ context.Context) (time.Time, error) { return lastModified, nil },
+		},
+	}
+	handler := newTestHandler(t, cfg)
+
+	t.Run("published date", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/diagnosis-keys/2020-05-04", nil)
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+		resp := w.Result()
+
+		expStatusCode := 200
+		if got := resp.StatusCode; got != expStatusCode {
+			t.Fatalf("expected: %v, got: %v", expStatusCode, got)
+		}
+
+		expCacheControl := "public, max-age=3600, s-maxage=86400"
+		if got := resp.Header.Get("Cache-Control"); got != expCacheControl {
+			t.Errorf("expected: %v, got: %v", expCacheControl, got)
+		}
+
+		expLastModified := time.Date(2020, time.May, 5, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat)
+		if got := resp.Header.Get("Last-Modified"); got != expLastModified {
+			t.Errorf("expected: %v, got: %v", expLastModified, got)
+		}
+
+		expBody := &bytes.Buffer{}
+		if err := diag.WriteDiagnosisKeys(expBody, diagKeys[1]); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, expBody.Bytes()) {
+			t.Errorf("expected: %v, got: %v", expBody.Bytes(), got)
+		}
+	})
+
+	t.Run("date without diagnosis keys", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/diagnosis-keys/2020-05-01", nil)
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+		resp := w.Result()
+
+		expStatusCode := 200
+		if got := resp.StatusCode; got != expStatusCode {
+			t.Errorf("expected: %v, got: %v", expStatusCode, got)
+		}
+
+		expContentLength := "0"
+		if got := resp.Header.Get("Content-Length"); got != expContentLength {
+			t.Errorf("expected: %v, got: %v", expContentLength, got)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format("2006-01-02")
+		for _, date := range []string{"2020-5-4", "foobar", tomorrow} {
+			req := httptest.NewRequest("GET", "http://example.com/diagnosis-keys/"+date, nil)
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+			resp := w.Result()
+
+			expStatusCode := 404
+			if got := resp.StatusCode; got != expStatusCode {
+				t.Errorf("%v: expected: %v, got: %v", date, expStatusCode, got)
+			}
+		}
+	})
+}
+
+func TestListDiagnosisKeysByHour(t *testing.T) {
+	diagKeys := []diag.DiagnosisKey{
+		{
+			TemporaryExposureKey: [16]byte{1},
+			RollingStartNumber:   uint32(42),
+			UploadedAt:           time.Date(2020, time.May, 4, 12, 59, 0, 0, time.UTC),
+		},
+		{
+			TemporaryExposureKey: [16]byte{2},
+			RollingStartNumber:   uint32(43),
+			UploadedAt:           time.Date(2020, time.May, 4, 13, 30, 0, 0, time.UTC),
+		},
+	}
+	diagCfg := diag.Config{
+		Repository: testRepository{
+			findAllDiagnosisKeysFn: func(_ context.Context) ([]diag.DiagnosisKey, error) {
+				return diagKeys, nil
+			},
+			lastModifiedFn: func(_ context.Context) (time.Time, error) { return diagKeys[1].UploadedAt, nil },
+		},
+		Logger: diag.NewNopLogger(),
+	}
+
+	t.Run("hourly buckets enabled", func(t *testing.T) {
+		handler, err := NewHandler(context.Background(), Config{Diag: diagCfg, HourlyBuckets: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest("GET", "http://example.com/diagnosis-keys/2020-05-04/13", nil)
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+		resp := w.Result()
+
+		expStatusCode := 200
+		if got := resp.StatusCode; got != expStatusCode {
+			t.Fatalf("expected: %v, got: %v", expStatusCode, got)
+		}
+
+		expBody := &bytes.Buffer{}
+		if err := diag.WriteDiagnosisKeys(expBody, diagKeys[1]); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, expBody.Bytes()) {
+			t.Errorf("expected: %v, got: %v", expBody.Bytes(), got)
+		}
+
+		// The current hour isn't complete yet.
+		now := time.Now().UTC()
+		for _, path := range []string{now.Format("2006-01-02/15"), "2020-05-04/24", "2020-05-04/1", "2020-05-04/"} {
+			req := httptest.NewRequest("GET", "http://example.com/diagnosis-keys/"+path, nil)
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			expStatusCode := 404
+			if got := w.Result().StatusCode; got != expStatusCode {
+				t.Errorf("%v: expected: %v, got: %v", path, expStatusCode, got)
+			}
+		}
+	})
+
+	t.Run("hourly buckets disabled", func(t *testing.T) {
+		handler, err := NewHandler(context.Background(), Config{Diag: diagCfg})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest("GET", "http://example.com/diagnosis-keys/2020-05-04/13", nil)
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		expStatusCode := 404
+		if got := w.Result().StatusCode; got != expStatusCode {
+			t.Errorf("expected: %v, got: %v", expStatusCode, got)
+		}
+	})
+}
+
+func TestLastModified(t *testing.T) {
+	t.Run("no diagnosis keys", func(t *testing.T) {
 		handler := newTestHandler(t, nil)
-		req := httptest.NewRequest("POST", "http://example.com/diagnosis-keys", nil)
+
+		req := httptest.NewRequest("GET", "http://example.com/diagnosis-keys/last-modified", nil)
 		w := httptest.NewRecorder()
 
 		handler.ServeHTTP(w, req)
 		resp := w.Result()
 
-		expStatusCode := 400
+		if got := resp.Header.Get("Last-Modified"); got != "" {
+			t.Errorf("expected no Last-Modified header, got: %v", got)
+		}
+		got, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expBody := `{"lastModified":null}`
+		if strings.TrimSpace(string(got)) != expBody {
+			t.Errorf("expected: %v, got: %s", expBody, got)
+		}
+	})
+
+	lastModified := time.Date(2020, time.May, 4, 13, 30, 0, 0, time.UTC)
+	handler := newTestHandler(t, &diag.Config{
+		Repository: testRepository{
+			findAllDiagnosisKeysFn: func(_ context.Context) ([]diag.DiagnosisKey, error) {
+				return []diag.DiagnosisKey{{TemporaryExposureKey: [16]byte{1}, UploadedAt: lastModified}}, nil
+			},
+			lastModifiedFn: func(_ context.Context) (time.Time, error) { return lastModified, nil },
+		},
+	})
+
+	t.Run("diagnosis keys found", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/diagnosis-keys/last-modified", nil)
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+		resp := w.Result()
+
+		expStatusCode := 200
 		if got := resp.StatusCode; got != expStatusCode {
+			t.Fatalf("expected: %v, got: %v", expStatusCode, got)
+		}
+		if got, exp := resp.Header.Get("Last-Modified"), lastModified.Format(http.TimeFormat); got != exp {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+
+		var body lastModifiedResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body.LastModified == nil || !body.LastModified.Equal(lastModified) {
+			t.Errorf("expected: %v, got: %v", lastModified, body.LastModified)
+		}
+	})
+
+	t.Run("not modified", func(t *testing.T) {
+		req := httptest.NewRequest("HEAD", "http://example.com/diagnosis-keys/last-modified", nil)
+		req.Header.Set("If-Modified-Since", lastModified.Format(http.TimeFormat))
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		expStatusCode := 304
+		if got := w.Result().StatusCode; got != expStatusCode {
 			t.Errorf("expected: %v, got: %v", expStatusCode, got)
 		}
+	})
+}
+
+type testRevokingRepository struct {
+	testRepository
+	mu          sync.Mutex
+	revocations []diag.Revocation
+}
+
+func (ts *testRevokingRepository) StoreRevocations(_ context.Context, keys [][16]byte, revokedAt time.Time) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for _, key := range keys {
+		ts.revocations = append(ts.revocations, diag.Revocation{TemporaryExposureKey: key, RevokedAt: revokedAt})
+	}
+	return nil
+}
+
+func (ts *testRevokingRepository) FindAllRevocations(_ context.Context) ([]diag.Revocation, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.revocations, nil
+}
+
+func TestRevocations(t *testing.T) {
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newRequest := func(method, target string, body io.Reader) *http.Request {
+		req := httptest.NewRequest(method, target, body)
+		req.Header.Set("Authorization", "Bearer secret")
+		return req
+	}
+
+	t.Run("revocation disabled", func(t *testing.T) {
+		handler, err := NewHandler(context.Background(), Config{
+			Diag:       diag.Config{Repository: noopRepo, Logger: diag.NewNopLogger()},
+			AdminToken: "secret",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, req := range []*http.Request{
+			newRequest("GET", "http://example.com/revocations", nil),
+			newRequest("POST", "http://example.com/admin/revocations", bytes.NewReader(make([]byte, 16))),
+		} {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			expStatusCode := 404
+			if got := w.Result().StatusCode; got != expStatusCode {
+				t.Errorf("%v %v: expected: %v, got: %v", req.Method, req.URL.Path, expStatusCode, got)
+			}
+		}
+	})
+
+	t.Run("revoke and list keys", func(t *testing.T) {
+		handler, err := NewHandler(context.Background(), Config{
+			Diag: diag.Config{
+				Repository: &testRevokingRepository{testRepository: noopRepo},
+				Logger:     diag.NewNopLogger(),
+				Signer:     signingKey,
+			},
+			AdminToken: "secret",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Incomplete key.
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, newRequest("POST", "http://example.com/admin/revocations", bytes.NewReader(make([]byte, 15))))
+		if got := w.Result().StatusCode; got != 400 {
+			t.Fatalf("expected: %v, got: %v", 400, got)
+		}
+
+		keys := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, newRequest("POST", "http://example.com/admin/revocations", bytes.NewReader(keys)))
+		if got := w.Result().StatusCode; got != 200 {
+			t.Fatalf("expected: %v, got: %v", 200, got)
+		}
+
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/revocations", nil))
+		resp := w.Result()
+		if got := resp.StatusCode; got != 200 {
+			t.Fatalf("expected: %v, got: %v", 200, got)
+		}
 
-		expBody := "Invalid body: unexpected EOF"
 		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			t.Fatal(err)
 		}
+		if !bytes.Equal(body, keys) {
+			t.Errorf("expected: %v, got: %v", keys, body)
+		}
 
-		if got := strings.TrimSpace(string(body)); got != expBody {
-			t.Errorf("expected: %v, got: `%s`", expBody, got)
+		sig, err := base64.StdEncoding.DecodeString(resp.Header.Get("X-Signature"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var esig struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(sig, &esig); err != nil {
+			t.Fatal(err)
+		}
+		digest := sha256.Sum256(body)
+		if !ecdsa.Verify(&signingKey.PublicKey, digest[:], esig.R, esig.S) {
+			t.Error("expected valid signature")
+		}
+	})
+}
+
+func TestExportByDate(t *testing.T) {
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diagKeys := []diag.DiagnosisKey{
+		{
+			TemporaryExposureKey: [16]byte{1},
+			RollingStartNumber:   uint32(42),
+			UploadedAt:           time.Date(2020, time.May, 4, 13, 0, 0, 0, time.UTC),
+		},
+	}
+	repo := testRepository{
+		findAllDiagnosisKeysFn: func(_ context.Context) ([]diag.DiagnosisKey, error) { return diagKeys, nil },
+		lastModifiedFn:         func(_ context.Context) (time.Time, error) { return diagKeys[0].UploadedAt, nil },
+	}
+
+	t.Run("export disabled", func(t *testing.T) {
+		handler := newTestHandler(t, &diag.Config{Repository: repo, Signer: signingKey})
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/exposure-key-export/2020-05-04.zip", nil))
+		if got, exp := w.Result().StatusCode, 404; got != exp {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+	})
+
+	handler := newTestHandler(t, &diag.Config{
+		Repository: repo,
+		Signer:     signingKey,
+		Export:     &diag.ExportConfig{Region: "204", VerificationKeyVersion: "v1"},
+	})
+
+	t.Run("published date", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/exposure-key-export/2020-05-04.zip", nil))
+		resp := w.Result()
+
+		if got, exp := resp.StatusCode, 200; got != exp {
+			t.Fatalf("expected: %v, got: %v", exp, got)
+		}
+		if got, exp := resp.Header.Get("Content-Type"), "application/zip"; got != exp {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+		if got, exp := resp.Header.Get("Cache-Control"), "public, max-age=3600, s-maxage=86400"; got != exp {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		today := time.Now().UTC().Format("2006-01-02")
+		for _, path := range []string{"2020-05-04", "2020-05-04.bin", "foobar.zip", today + ".zip"} {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/exposure-key-export/"+path, nil))
+			if got, exp := w.Result().StatusCode, 404; got != exp {
+				t.Errorf("%v: expected: %v, got: %v", path, exp, got)
+			}
+		}
+	})
+}
+
+func TestPostDiagnosisKeys(t *testing.T) {
+	t.Run("missing post body", func(t *testing.T) {
+		handler := newTestHandler(t, nil)
+		req := httptest.NewRequest("POST", "http://example.com/diagnosis-keys", nil)
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+		resp := w.Result()
+
+		expStatusCode := 400
+		if got := resp.StatusCode; got != expStatusCode {
+			t.Errorf("expected: %v, got: %v", expStatusCode, got)
+		}
+
+		exp := problem{Type: "about:blank", Title: "Bad Request", Status: 400, Detail: "Invalid body: unexpected EOF", Code: codeInvalidKeyLength}
+		if got := readProblem(t, resp); got != exp {
+			t.Errorf("expected: %+v, got: %+v", exp, got)
 		}
 	})
 
@@ -410,14 +1111,9 @@ func TestPostDiagnosisKeys(t *testing.T) {
 			t.Errorf("expected: %v, got: %v", expStatusCode, got)
 		}
 
-		expBody := "Invalid body: unexpected EOF"
-		resBody, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		if got := strings.TrimSpace(string(resBody)); got != expBody {
-			t.Errorf("expected: %v, got: `%s`", expBody, got)
+		exp := problem{Type: "about:blank", Title: "Bad Request", Status: 400, Detail: "Invalid body: unexpected EOF", Code: codeInvalidKeyLength}
+		if got := readProblem(t, resp); got != exp {
+			t.Errorf("expected: %+v, got: %+v", exp, got)
 		}
 	})
 
@@ -460,14 +1156,9 @@ func TestPostDiagnosisKeys(t *testing.T) {
 			t.Errorf("expected: %v, got: %v", expStatusCode, got)
 		}
 
-		expBody := "Invalid body: http: request body too large"
-		resBody, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		if got := strings.TrimSpace(string(resBody)); got != expBody {
-			t.Fatalf("expected: %v, got: `%s`", expBody, got)
+		exp := problem{Type: "about:blank", Title: "Bad Request", Status: 400, Detail: "Invalid body: http: request body too large", Code: codeBatchTooLarge}
+		if got := readProblem(t, resp); got != exp {
+			t.Fatalf("expected: %+v, got: %+v", exp, got)
 		}
 	})
 
@@ -534,11 +1225,114 @@ func TestPostDiagnosisKeys(t *testing.T) {
 				t.Fatalf("expected: %v, got: `%s`", expBody, got)
 			}
 
+			// The cache is refreshed every 5 minutes by default.
+			publishAt, err := time.Parse(time.RFC3339, resp.Header.Get("X-Estimated-Publication-Time"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if now := time.Now(); !publishAt.After(now.Add(-time.Second)) || publishAt.After(now.Add(5*time.Minute)) {
+				t.Errorf("expected estimated publication time within 5 minutes, got: %v", publishAt)
+			}
+
 			if !reflect.DeepEqual(storedDiagKeys, expDiagKeys) {
 				t.Errorf("expected: %#v, got: %#v", expDiagKeys, storedDiagKeys)
 			}
 		})
 
+		t.Run("upload event is logged", func(t *testing.T) {
+			eventLogger := &testLogger{}
+			cfg := &diag.Config{
+				Repository:        noopRepo,
+				UploadEventLogger: eventLogger,
+			}
+			handler := newTestHandler(t, cfg)
+
+			req := httptest.NewRequest("POST", "http://example.com/diagnosis-keys", validBody())
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if len(eventLogger.entries) != 1 {
+				t.Fatalf("expected: 1 upload event, got: %v", len(eventLogger.entries))
+			}
+
+			fields := eventLogger.entries[0].fields
+			if got := fields["keyCount"]; got != len(expDiagKeys) {
+				t.Errorf("expected: %v, got: %v", len(expDiagKeys), got)
+			}
+			expRiskLevels := map[string]int{"0": 1}
+			if got := fields["transmissionRiskLevels"]; !reflect.DeepEqual(got, expRiskLevels) {
+				t.Errorf("expected: %v, got: %v", expRiskLevels, got)
+			}
+		})
+
+		t.Run("upload queue is full", func(t *testing.T) {
+			storing := make(chan struct{})
+			done := make(chan struct{})
+			cfg := &diag.Config{
+				Repository: testRepository{
+					findAllDiagnosisKeysFn: noopRepo.findAllDiagnosisKeysFn,
+					storeDiagnosisKeysFn: func(_ context.Context, _ []diag.DiagnosisKey, _ time.Time) error {
+						storing <- struct{}{}
+						<-done
+						return nil
+					},
+					lastModifiedFn: noopRepo.lastModifiedFn,
+				},
+				MaxConcurrentUploads: 1,
+				MaxQueuedUploads:     0,
+			}
+			handler := newTestHandler(t, cfg)
+
+			// Occupy the only upload slot.
+			go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "http://example.com/diagnosis-keys", validBody()))
+			<-storing
+			defer close(done)
+
+			req := httptest.NewRequest("POST", "http://example.com/diagnosis-keys", validBody())
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+			resp := w.Result()
+
+			expStatusCode := 503
+			if got := resp.StatusCode; got != expStatusCode {
+				t.Errorf("expected: %v, got: %v", expStatusCode, got)
+			}
+
+			if got := resp.Header.Get("Retry-After"); got == "" {
+				t.Error("expected `Retry-After` header")
+			}
+		})
+
+		t.Run("stored keys quota is exceeded", func(t *testing.T) {
+			cfg := &diag.Config{
+				Repository: testRepository{
+					findAllDiagnosisKeysFn: func(_ context.Context) ([]diag.DiagnosisKey, error) {
+						return []diag.DiagnosisKey{{TemporaryExposureKey: [16]byte{1}}, {TemporaryExposureKey: [16]byte{2}}}, nil
+					},
+					storeDiagnosisKeysFn: func(_ context.Context, _ []diag.DiagnosisKey, _ time.Time) error {
+						t.Error("expected upload not to be stored")
+						return nil
+					},
+					lastModifiedFn: noopRepo.lastModifiedFn,
+				},
+				MaxStoredKeys:          1,
+				RefuseUploadsOverQuota: true,
+			}
+			handler := newTestHandler(t, cfg)
+
+			req := httptest.NewRequest("POST", "http://example.com/diagnosis-keys", validBody())
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			expStatusCode := 503
+			if got := w.Result().StatusCode; got != expStatusCode {
+				t.Errorf("expected: %v, got: %v", expStatusCode, got)
+			}
+		})
+
 		t.Run("diag.Service returns unexpected error", func(t *testing.T) {
 			cfg := &diag.Config{
 				Repository: testRepository{
@@ -561,14 +1355,9 @@ func TestPostDiagnosisKeys(t *testing.T) {
 				t.Errorf("expected: %v, got: %v", expStatusCode, got)
 			}
 
-			expBody := "Internal Server Error"
-			resBody, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				t.Fatal(err)
-			}
-
-			if got := strings.TrimSpace(string(resBody)); got != expBody {
-				t.Fatalf("expected: %v, got: `%s`", expBody, got)
+			exp := problem{Type: "about:blank", Title: "Internal Server Error", Status: 500, Detail: "Internal Server Error", Code: codeInternalError}
+			if got := readProblem(t, resp); got != exp {
+				t.Fatalf("expected: %+v, got: %+v", exp, got)
 			}
 		})
 	})
@@ -586,4 +1375,198 @@ func TestUnsupportedMethod(t *testing.T) {
 	if got := resp.StatusCode; got != expStatusCode {
 		t.Errorf("expected: %v, got: %v", expStatusCode, got)
 	}
+	if got := readProblem(t, resp).Code; got != codeMethodNotAllowed {
+		t.Errorf("expected: %v, got: %v", codeMethodNotAllowed, got)
+	}
+}
+
+func TestBatchIndex(t *testing.T) {
+	yesterday := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+	diagKeys := []diag.DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, UploadedAt: yesterday.Add(13 * time.Hour)},
+		{TemporaryExposureKey: [16]byte{2}, UploadedAt: yesterday.Add(14 * time.Hour)},
+	}
+	handler := newTestHandler(t, &diag.Config{Repository: testRepository{
+		findAllDiagnosisKeysFn: func(_ context.Context) ([]diag.DiagnosisKey, error) { return diagKeys, nil },
+		lastModifiedFn:         func(_ context.Context) (time.Time, error) { return diagKeys[1].UploadedAt, nil },
+	}})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/diagnosis-keys/index", nil))
+	resp := w.Result()
+
+	if got, exp := resp.StatusCode, 200; got != exp {
+		t.Fatalf("expected: %v, got: %v", exp, got)
+	}
+	if got, exp := resp.Header.Get("Content-Type"), "application/json"; got != exp {
+		t.Errorf("expected: %v, got: %v", exp, got)
+	}
+
+	var got batchIndexResponse
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	exp := batchIndexResponse{Batches: []batchResponse{{
+		Start: yesterday,
+		End:   yesterday.AddDate(0, 0, 1),
+		Keys:  2,
+		Path:  "/diagnosis-keys/" + yesterday.Format("2006-01-02"),
+	}}}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected: %+v, got: %+v", exp, got)
+	}
+}
+
+func TestPostDiagnosisKeysVerification(t *testing.T) {
+	secret := []byte("s3cret")
+	verifier, err := verification.New(verification.Config{
+		Issuer:     "verification",
+		Audience:   "ct-diag",
+		HMACSecret: secret,
+		Regions:    map[string]verification.RegionPolicy{"BE": {Issuers: []string{"be.verification"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := NewHandler(context.Background(), Config{
+		Diag:     diag.Config{Repository: noopRepo, Logger: diag.NewNopLogger()},
+		Verifier: verifier,
+		Regions:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := make([]byte, diag.DiagnosisKeySize)
+	hmacKey := []byte("app generated key")
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(body)
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":    "verification",
+		"aud":    "ct-diag",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"tekmac": base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256"}`)) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	mac = hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	cert := signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name          string
+		cert          string
+		hmacKey       []byte
+		regions       string
+		expStatusCode int
+	}{
+		{"valid certificate", cert, hmacKey, "", 200},
+		{"valid certificate for region", cert, hmacKey, "NL", 200},
+		{"missing certificate", "", hmacKey, "", 401},
+		{"wrong HMAC key", cert, []byte("foobar"), "", 401},
+		{"region of other issuer", cert, hmacKey, "BE,NL", 403},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := "http://example.com/diagnosis-keys"
+			if tt.regions != "" {
+				target += "?regions=" + tt.regions
+			}
+			req := httptest.NewRequest("POST", target, bytes.NewReader(body))
+			req.Header.Set("X-Verification-Certificate", tt.cert)
+			req.Header.Set("X-Verification-HMAC-Key", base64.StdEncoding.EncodeToString(tt.hmacKey))
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if got := w.Result().StatusCode; got != tt.expStatusCode {
+				t.Errorf("expected: %v, got: %v", tt.expStatusCode, got)
+			}
+		})
+	}
+}
+
+func TestServerTime(t *testing.T) {
+	handler := newTestHandler(t, nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/time", nil))
+	resp := w.Result()
+
+	if got, exp := resp.StatusCode, 200; got != exp {
+		t.Fatalf("expected: %v, got: %v", exp, got)
+	}
+	if got, exp := resp.Header.Get("Cache-Control"), "no-store"; got != exp {
+		t.Errorf("expected: %v, got: %v", exp, got)
+	}
+
+	var body timeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(body.Time); d < 0 || d > time.Minute {
+		t.Errorf("expected current time, got: %v", body.Time)
+	}
+	if got, exp := body.ENIntervalNumber, body.UnixTime/600; got != exp {
+		t.Errorf("expected: %v, got: %v", exp, got)
+	}
+	if got, exp := body.AcceptableSkewSeconds, int64(600); got != exp {
+		t.Errorf("expected: %v, got: %v", exp, got)
+	}
+}
+
+// discardResponseWriter is an http.ResponseWriter that discards the body, so
+// benchmarks measure the handler rather than buffering of the response.
+type discardResponseWriter struct {
+	header http.Header
+	code   int
+}
+
+func (w *discardResponseWriter) Header() http.Header         { return w.header }
+func (w *discardResponseWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *discardResponseWriter) WriteHeader(code int)        { w.code = code }
+
+func BenchmarkListings(b *testing.B) {
+	yesterday := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+	var diagKeys []diag.DiagnosisKey
+	for i := 0; i < 140000; i++ {
+		diagKeys = append(diagKeys, diag.DiagnosisKey{
+			TemporaryExposureKey: [16]byte{byte(i), byte(i >> 8), byte(i >> 16), 1},
+			UploadedAt:           yesterday.AddDate(0, 0, -13).Add(time.Duration(i) * 13 * 24 * time.Hour / 140000),
+		})
+	}
+	handler := newTestHandler(b, &diag.Config{Repository: testRepository{
+		findAllDiagnosisKeysFn: func(_ context.Context) ([]diag.DiagnosisKey, error) { return diagKeys, nil },
+		lastModifiedFn:         func(_ context.Context) (time.Time, error) { return diagKeys[len(diagKeys)-1].UploadedAt, nil },
+	}})
+
+	w := &discardResponseWriter{header: make(http.Header)}
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/diagnosis-keys", nil))
+	etag := w.header.Get("ETag")
+
+	for _, bb := range []struct {
+		name   string
+		target string
+		header http.Header
+	}{
+		{"full", "/diagnosis-keys", nil},
+		{"full not modified", "/diagnosis-keys", http.Header{"If-None-Match": {etag}}},
+		{"date", "/diagnosis-keys/" + yesterday.Format(dateLayout), nil},
+		{"index", "/diagnosis-keys/index", nil},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			req := httptest.NewRequest("GET", "http://example.com"+bb.target, nil)
+			for k, v := range bb.header {
+				req.Header[k] = v
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				w := &discardResponseWriter{header: make(http.Header)}
+				handler.ServeHTTP(w, req)
+			}
+		})
+	}
 }
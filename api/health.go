@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// defaultReadinessTimeout is the default timeout of the dependency checks of
+// the readiness probe.
+const defaultReadinessTimeout = 2 * time.Second
+
+// healthResponse is the JSON representation of the health check, with the
+// current load as hints for clients to adapt their upload retries.
+type healthResponse struct {
+	Status            string    `json:"status"`
+	Load              diag.Load `json:"load"`
+	RetryAfterSeconds int       `json:"retryAfterSeconds"`
+}
+
+// health writes `OK`, or if the client accepts JSON, the current load too.
+func (h *handler) health(w http.ResponseWriter, r *http.Request) {
+	if !strings.Contains(r.Header.Get("Accept"), "application/json") {
+		fmt.Fprint(w, "OK")
+		return
+	}
+
+	load := h.diagSvc.Load()
+	writeJSON(w, healthResponse{
+		Status:            "ok",
+		Load:              load,
+		RetryAfterSeconds: int(load.RetryAfter / time.Second),
+	})
+}
+
+// liveness writes `OK` as long as the process serves requests, for liveness
+// probes. It doesn't check dependencies, so an unavailable database doesn't
+// get the server restarted.
+func (h *handler) liveness(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, "OK")
+}
+
+// readinessResponse is the JSON representation of the readiness probe.
+type readinessResponse struct {
+	Status string `json:"status"`
+	// Checks are the statuses of the dependencies, `ok` or `unavailable`,
+	// by name.
+	Checks         map[string]string `json:"checks"`
+	CacheHydrated  bool              `json:"cacheHydrated"`
+	RefreshedAt    *time.Time        `json:"refreshedAt"`
+	RefreshHealthy bool              `json:"refreshHealthy"`
+}
+
+// readiness writes the readiness of the service in JSON, for readiness
+// probes: `200 OK` if the repositories can be pinged within the readiness
+// timeout and the cache is hydrated, else `503 Service Unavailable`. Errors
+// are logged instead of returned, as the endpoint isn't authenticated.
+func (h *handler) readiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.readinessTimeout)
+	defer cancel()
+	readiness := h.diagSvc.Readiness(ctx)
+
+	resp := readinessResponse{
+		Status:         "ok",
+		Checks:         make(map[string]string, len(readiness.Dependencies)),
+		CacheHydrated:  readiness.Hydrated,
+		RefreshHealthy: readiness.RefreshHealthy,
+	}
+	if !readiness.RefreshedAt.IsZero() {
+		t := readiness.RefreshedAt.UTC()
+		resp.RefreshedAt = &t
+	}
+
+	for name, err := range readiness.Dependencies {
+		resp.Checks[name] = "ok"
+		if err != nil {
+			resp.Checks[name] = "unavailable"
+			h.logger.Warn("Readiness check failed.", diag.F("dependency", name), diag.Err(err))
+		}
+	}
+
+	if !readiness.Ready {
+		resp.Status = "unavailable"
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeJSON(w, resp)
+}
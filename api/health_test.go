@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// pingRepository is a testRepository implementing diag.Pinger.
+type pingRepository struct {
+	testRepository
+	pingFn func(context.Context) error
+}
+
+func (pr pingRepository) PingContext(ctx context.Context) error {
+	return pr.pingFn(ctx)
+}
+
+func TestLiveness(t *testing.T) {
+	repo := pingRepository{testRepository: noopRepo, pingFn: func(_ context.Context) error {
+		return errors.New("database is down")
+	}}
+	handler := newTestHandler(t, &diag.Config{Repository: repo})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/health/live", nil))
+	if got := w.Result().StatusCode; got != http.StatusOK {
+		t.Errorf("expected: %v, got: %v", http.StatusOK, got)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name          string
+		pingFn        func(context.Context) error
+		expStatusCode int
+		expStatus     string
+	}{
+		{
+			name:          "ready",
+			pingFn:        func(_ context.Context) error { return nil },
+			expStatusCode: http.StatusOK,
+			expStatus:     "ok",
+		},
+		{
+			name:          "database down",
+			pingFn:        func(_ context.Context) error { return errors.New("connection refused") },
+			expStatusCode: http.StatusServiceUnavailable,
+			expStatus:     "unavailable",
+		},
+		{
+			name: "database unresponsive",
+			pingFn: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			expStatusCode: http.StatusServiceUnavailable,
+			expStatus:     "unavailable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := pingRepository{testRepository: noopRepo, pingFn: tt.pingFn}
+			handler, err := NewHandler(context.Background(), Config{
+				Diag:             diag.Config{Repository: repo, Logger: diag.NewNopLogger()},
+				ReadinessTimeout: 10 * time.Millisecond,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/health/ready", nil))
+			resp := w.Result()
+
+			if got := resp.StatusCode; got != tt.expStatusCode {
+				t.Errorf("expected: %v, got: %v", tt.expStatusCode, got)
+			}
+			var got readinessResponse
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.expStatus {
+				t.Errorf("expected: %v, got: %v", tt.expStatus, got.Status)
+			}
+			if exp := map[string]string{"repository": tt.expStatus}; !reflect.DeepEqual(got.Checks, exp) {
+				t.Errorf("expected: %v, got: %v", exp, got.Checks)
+			}
+			if !got.CacheHydrated || got.RefreshedAt == nil {
+				t.Errorf("expected hydrated cache, got: %+v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// Limits of the `limit` query parameter of job runs.
+const (
+	defaultJobRunsLimit = 50
+	maxJobRunsLimit     = 1000
+)
+
+// jobRunsResponse is the JSON representation of background job runs.
+type jobRunsResponse struct {
+	Runs []diag.JobRun `json:"runs"`
+}
+
+// jobRuns writes the latest `limit` runs of background jobs in JSON, newest
+// first. With `failed=true`, only failed runs are listed.
+func (h *handler) jobRuns(w http.ResponseWriter, r *http.Request) {
+	limit := defaultJobRunsLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxJobRunsLimit {
+			writeProblem(w, http.StatusBadRequest, codeInvalidLimitParam, "Invalid `limit` query parameter, must be between 1 and 1000.")
+			return
+		}
+		limit = n
+	}
+	failedOnly := r.URL.Query().Get("failed") == "true"
+
+	runs, err := h.diagSvc.JobRuns(r.Context(), limit, failedOnly)
+	switch err {
+	case nil:
+	case diag.ErrJobHistoryUnsupported:
+		writeNotFound(w)
+		return
+	default:
+		h.logger.Error("Could not find job runs", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	if runs == nil {
+		runs = []diag.JobRun{}
+	}
+	writeJSON(w, jobRunsResponse{Runs: runs})
+}
+
+// retryJob reruns the job of the failed run given by the `id` query parameter,
+// and writes the new run in JSON.
+func (h *handler) retryJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidIDParam, "Invalid `id` query parameter.")
+		return
+	}
+
+	run, err := h.diagSvc.RetryJob(r.Context(), id)
+	switch err {
+	case nil:
+	case diag.ErrJobHistoryUnsupported, diag.ErrJobRunNotFound:
+		writeNotFound(w)
+		return
+	case diag.ErrJobRunNotFailed:
+		writeProblem(w, http.StatusConflict, codeJobRunNotFailed, "Job run didn't fail, only failed runs can be retried.")
+		return
+	default:
+		h.logger.Error("Could not retry job", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	h.logger.Info("Job retried.", diag.F("id", id), diag.F("type", run.Type), diag.F("error", run.Error))
+
+	writeJSON(w, run)
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+type testJobRepository struct {
+	testRepository
+	mu   sync.Mutex
+	runs []diag.JobRun
+}
+
+func (ts *testJobRepository) StoreJobRun(_ context.Context, run diag.JobRun) (int64, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	run.ID = int64(len(ts.runs) + 1)
+	ts.runs = append(ts.runs, run)
+	return run.ID, nil
+}
+
+func (ts *testJobRepository) FindJobRuns(_ context.Context, limit int, failedOnly bool) ([]diag.JobRun, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	var runs []diag.JobRun
+	for i := len(ts.runs) - 1; i >= 0 && len(runs) < limit; i-- {
+		if !failedOnly || ts.runs[i].Error != "" {
+			runs = append(runs, ts.runs[i])
+		}
+	}
+	return runs, nil
+}
+
+func (ts *testJobRepository) FindJobRun(_ context.Context, id int64) (diag.JobRun, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if id < 1 || id > int64(len(ts.runs)) {
+		return diag.JobRun{}, diag.ErrJobRunNotFound
+	}
+	return ts.runs[id-1], nil
+}
+
+func TestJobs(t *testing.T) {
+	repo := &testJobRepository{testRepository: noopRepo}
+	repo.runs = []diag.JobRun{
+		{ID: 1, Type: diag.JobRefresh, StartedAt: time.Unix(42, 0).UTC(), EndedAt: time.Unix(43, 0).UTC(), Error: "foobar"},
+		{ID: 2, Type: diag.JobRefresh, StartedAt: time.Unix(44, 0).UTC(), EndedAt: time.Unix(45, 0).UTC()},
+	}
+	handler, err := NewHandler(context.Background(), Config{
+		Diag:       diag.Config{Repository: repo, Logger: diag.NewNopLogger()},
+		AdminToken: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, target string) *http.Response {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	t.Run("list failed runs", func(t *testing.T) {
+		resp := do("GET", "http://example.com/admin/jobs?failed=true")
+		if got, exp := resp.StatusCode, 200; got != exp {
+			t.Fatalf("expected: %v, got: %v", exp, got)
+		}
+		var body jobRunsResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if len(body.Runs) != 1 || body.Runs[0].ID != 1 || body.Runs[0].Error != "foobar" {
+			t.Errorf("unexpected runs: %+v", body.Runs)
+		}
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		resp := do("POST", "http://example.com/admin/jobs/retry?id=1")
+		if got, exp := resp.StatusCode, 200; got != exp {
+			t.Fatalf("expected: %v, got: %v", exp, got)
+		}
+		var run diag.JobRun
+		if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+			t.Fatal(err)
+		}
+		if run.ID != 3 || run.Type != diag.JobRefresh || run.RetryOf != 1 || run.Error != "" {
+			t.Errorf("unexpected run: %+v", run)
+		}
+	})
+
+	t.Run("invalid retries", func(t *testing.T) {
+		for target, exp := range map[string]int{
+			"http://example.com/admin/jobs/retry?id=2":   409,
+			"http://example.com/admin/jobs/retry?id=42":  404,
+			"http://example.com/admin/jobs/retry?id=foo": 400,
+		} {
+			if got := do("POST", target).StatusCode; got != exp {
+				t.Errorf("%v: expected: %v, got: %v", target, exp, got)
+			}
+		}
+	})
+
+	t.Run("job history disabled", func(t *testing.T) {
+		handler, err := NewHandler(context.Background(), Config{
+			Diag:       diag.Config{Repository: noopRepo, Logger: diag.NewNopLogger()},
+			AdminToken: "secret",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("GET", "http://example.com/admin/jobs", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if got, exp := w.Result().StatusCode, 404; got != exp {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+	})
+	t.Run("separate job repository", func(t *testing.T) {
+		jobs := &testJobRepository{runs: repo.runs}
+		handler, err := NewHandler(context.Background(), Config{
+			Diag:       diag.Config{Repository: noopRepo, JobRepository: jobs, Logger: diag.NewNopLogger()},
+			AdminToken: "secret",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("GET", "http://example.com/admin/jobs", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var body jobRunsResponse
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if got, exp := len(body.Runs), len(jobs.runs); got != exp {
+			t.Errorf("expected %v runs, got: %v", exp, got)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// storedKeyResponse is the JSON representation of a stored Diagnosis Key, for
+// incident response.
+type storedKeyResponse struct {
+	TemporaryExposureKey  string    `json:"temporaryExposureKey"`
+	RollingStartNumber    uint32    `json:"rollingStartNumber"`
+	RollingStartTime      time.Time `json:"rollingStartTime"`
+	TransmissionRiskLevel byte      `json:"transmissionRiskLevel"`
+	UploadedAt            time.Time `json:"uploadedAt"`
+	Index                 int64     `json:"index"`
+	// Hexdump is the binary representation of the key, as served by
+	// listings, in `hexdump -C` format.
+	Hexdump string `json:"hexdump"`
+}
+
+// diagnosisKeyByTEK writes the stored Diagnosis Key given by the path
+// (`/admin/keys/{hexTEK}`) in JSON. Every lookup is logged for auditing, with
+// a fingerprint of the requested key instead of the key itself.
+func (h *handler) diagnosisKeyByTEK(w http.ResponseWriter, r *http.Request) {
+	buf, err := hex.DecodeString(strings.TrimPrefix(r.URL.Path, "/admin/keys/"))
+	if err != nil || len(buf) != 16 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidKeyParam, "Invalid key, must be the hexadecimal encoding of a 16 byte key.")
+		return
+	}
+	var tek [16]byte
+	copy(tek[:], buf)
+
+	diagKey, err := h.diagSvc.FindDiagnosisKey(r.Context(), tek)
+	h.logger.Info("Diagnosis key looked up.",
+		diag.F("fingerprint", keyFingerprint(tek)),
+		diag.F("found", err == nil),
+		diag.F("remoteAddr", r.RemoteAddr),
+		diag.F("userAgent", r.UserAgent()),
+	)
+	switch err {
+	case nil:
+	case diag.ErrKeyNotFound, diag.ErrKeyLookupUnsupported:
+		writeNotFound(w)
+		return
+	default:
+		h.logger.Error("Could not find diagnosis key", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	bin, _ := diagKey.MarshalBinary()
+	writeJSON(w, storedKeyResponse{
+		TemporaryExposureKey:  hex.EncodeToString(tek[:]),
+		RollingStartNumber:    diagKey.RollingStartNumber,
+		RollingStartTime:      time.Unix(int64(diagKey.RollingStartNumber)*600, 0).UTC(),
+		TransmissionRiskLevel: diagKey.TransmissionRiskLevel,
+		UploadedAt:            diagKey.UploadedAt,
+		Index:                 diagKey.Index,
+		Hexdump:               hex.Dump(bin),
+	})
+}
+
+// keyFingerprint returns a short, non reversible identifier of a Temporary
+// Exposure Key, for correlating audit log entries without logging key
+// material.
+func keyFingerprint(tek [16]byte) string {
+	sum := sha256.Sum256(tek[:])
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+type testKeyFinderRepository struct {
+	testRepository
+	diagKey diag.StoredDiagnosisKey
+}
+
+func (ts testKeyFinderRepository) FindDiagnosisKeyByTEK(_ context.Context, tek [16]byte) (diag.StoredDiagnosisKey, error) {
+	if tek != ts.diagKey.TemporaryExposureKey {
+		return diag.StoredDiagnosisKey{}, diag.ErrKeyNotFound
+	}
+	return ts.diagKey, nil
+}
+
+func TestDiagnosisKeyByTEK(t *testing.T) {
+	tek := [16]byte{0xa7, 0x75, 0x2b, 0x99}
+	repo := testKeyFinderRepository{
+		testRepository: noopRepo,
+		diagKey: diag.StoredDiagnosisKey{
+			DiagnosisKey: diag.DiagnosisKey{TemporaryExposureKey: tek, RollingStartNumber: 2650847, TransmissionRiskLevel: 5, UploadedAt: time.Unix(42, 0).UTC()},
+			Index:        7,
+		},
+	}
+	logger := &testLogger{}
+	handler, err := NewHandler(context.Background(), Config{
+		Diag:       diag.Config{Repository: repo, Logger: diag.NewNopLogger()},
+		Logger:     logger,
+		AdminToken: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("found", func(t *testing.T) {
+		w := get("http://example.com/admin/keys/" + hex.EncodeToString(tek[:]))
+		if got, exp := w.Code, 200; got != exp {
+			t.Fatalf("expected: %v, got: %v", exp, got)
+		}
+		var body storedKeyResponse
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body.TemporaryExposureKey != hex.EncodeToString(tek[:]) || body.Index != 7 || body.TransmissionRiskLevel != 5 {
+			t.Errorf("unexpected body: %+v", body)
+		}
+		if exp := time.Date(2020, 5, 26, 15, 50, 0, 0, time.UTC); !body.RollingStartTime.Equal(exp) {
+			t.Errorf("expected: %v, got: %v", exp, body.RollingStartTime)
+		}
+		if !strings.HasPrefix(body.Hexdump, "00000000  a7 75 2b 99") {
+			t.Errorf("unexpected hexdump: %q", body.Hexdump)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if got, exp := get("http://example.com/admin/keys/"+strings.Repeat("00", 16)).Code, 404; got != exp {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+		if got, exp := get("http://example.com/admin/keys/foobar").Code, 400; got != exp {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+	})
+
+	t.Run("access is audited", func(t *testing.T) {
+		logger.mu.Lock()
+		defer logger.mu.Unlock()
+		var lookups int
+		for _, entry := range logger.entries {
+			if entry.msg != "Diagnosis key looked up." {
+				continue
+			}
+			lookups++
+			if fp := entry.fields["fingerprint"]; fp == hex.EncodeToString(tek[:]) || fp == "" {
+				t.Errorf("expected fingerprint, got: %v", fp)
+			}
+		}
+		if lookups != 2 {
+			t.Errorf("expected: 2 audited lookups, got: %v", lookups)
+		}
+	})
+
+	t.Run("requires admin token", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/admin/keys/"+hex.EncodeToString(tek[:]), nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != 401 {
+			t.Errorf("expected: 401, got: %v", w.Code)
+		}
+	})
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Message IDs of the user-facing error messages returned to apps. Apps may
+// show these to end users, so they are localized (see Messages).
+const (
+	msgInvalidBody        = "invalidBody"
+	msgInvalidCertificate = "invalidCertificate"
+	msgInvalidReceipt     = "invalidReceipt"
+	msgUnavailable        = "unavailable"
+	msgInternalError      = "internalError"
+)
+
+// defaultLanguage is the language used when none of the languages accepted by
+// a client is available.
+const defaultLanguage = "en"
+
+// Messages is a catalog of user-facing error messages, keyed by language tag
+// (e.g. `nl` or `pt-br`, lowercase) and message ID. A message may contain a
+// `%v` verb, which is replaced by the (English) error details.
+type Messages map[string]map[string]string
+
+// defaultMessages is the built-in catalog. Deployments can add languages or
+// override messages with Config.Messages.
+var defaultMessages = Messages{
+	"en": {
+		msgInvalidBody:        "Invalid body: %v",
+		msgInvalidCertificate: "Invalid verification certificate: %v",
+		msgInvalidReceipt:     "Invalid upload receipt.",
+		msgUnavailable:        "Service Unavailable",
+		msgInternalError:      "Internal Server Error",
+	},
+	"nl": {
+		msgInvalidBody:        "Ongeldige inhoud: %v",
+		msgInvalidCertificate: "Ongeldig verificatiecertificaat: %v",
+		msgInvalidReceipt:     "Ongeldig uploadbewijs.",
+		msgUnavailable:        "Dienst niet beschikbaar, probeer het later opnieuw.",
+		msgInternalError:      "Interne serverfout.",
+	},
+	"de": {
+		msgInvalidBody:        "Ungültiger Inhalt: %v",
+		msgInvalidCertificate: "Ungültiges Verifizierungszertifikat: %v",
+		msgInvalidReceipt:     "Ungültiger Upload-Beleg.",
+		msgUnavailable:        "Dienst nicht verfügbar, bitte versuchen Sie es später erneut.",
+		msgInternalError:      "Interner Serverfehler.",
+	},
+	"fr": {
+		msgInvalidBody:        "Contenu invalide : %v",
+		msgInvalidCertificate: "Certificat de vérification invalide : %v",
+		msgInvalidReceipt:     "Reçu de téléversement invalide.",
+		msgUnavailable:        "Service indisponible, veuillez réessayer plus tard.",
+		msgInternalError:      "Erreur interne du serveur.",
+	},
+}
+
+// ParseMessages parses a JSON message catalog, e.g. to override or add to the
+// built-in messages. Unknown message IDs are rejected, to catch typos.
+func ParseMessages(buf []byte) (Messages, error) {
+	var msgs Messages
+	if err := json.Unmarshal(buf, &msgs); err != nil {
+		return nil, fmt.Errorf("api: could not parse messages: %v", err)
+	}
+
+	parsed := make(Messages, len(msgs))
+	for lang, m := range msgs {
+		for id := range m {
+			if _, ok := defaultMessages[defaultLanguage][id]; !ok {
+				return nil, fmt.Errorf("api: unknown message ID %q (language %q)", id, lang)
+			}
+		}
+		parsed[strings.ToLower(lang)] = m
+	}
+
+	return parsed, nil
+}
+
+// merge returns the messages of msgs, overridden and extended by those of
+// overrides.
+func (msgs Messages) merge(overrides Messages) Messages {
+	merged := make(Messages, len(msgs)+len(overrides))
+	for _, catalog := range []Messages{msgs, overrides} {
+		for lang, m := range catalog {
+			lang = strings.ToLower(lang)
+			if merged[lang] == nil {
+				merged[lang] = make(map[string]string, len(m))
+			}
+			for id, msg := range m {
+				merged[lang][id] = msg
+			}
+		}
+	}
+	return merged
+}
+
+// lookup returns message `id` in the most preferred language of an
+// `Accept-Language` header value that has it, falling back to the default
+// language. Tags are matched exactly first, then by their primary subtag
+// (e.g. `nl-BE` matches `nl`).
+func (msgs Messages) lookup(acceptLanguage, id string) (msg, lang string) {
+	for _, tag := range acceptedLanguages(acceptLanguage) {
+		candidates := []string{tag}
+		if i := strings.IndexByte(tag, '-'); i > 0 {
+			candidates = append(candidates, tag[:i])
+		}
+		for _, c := range candidates {
+			if msg, ok := msgs[c][id]; ok {
+				return msg, c
+			}
+		}
+	}
+	return msgs[defaultLanguage][id], defaultLanguage
+}
+
+// acceptedLanguages returns the lowercase language tags of an
+// `Accept-Language` header value, ordered by quality value. Tags with a zero
+// quality value, and the `*` wildcard, are omitted.
+func acceptedLanguages(header string) []string {
+	type accepted struct {
+		tag string
+		q   float64
+	}
+	var langs []accepted
+
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(params[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			v, err := strconv.ParseFloat(param[2:], 64)
+			if err != nil {
+				v = 0
+			}
+			q = v
+		}
+		if q <= 0 {
+			continue
+		}
+		langs = append(langs, accepted{tag, q})
+	}
+
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	tags := make([]string, len(langs))
+	for i := range langs {
+		tags[i] = langs[i].tag
+	}
+	return tags
+}
+
+// writeError writes an error response with error code `code`, and user-facing
+// message `id` as detail, in the language preferred by the client (see
+// `Accept-Language`).
+func (h *handler) writeError(w http.ResponseWriter, r *http.Request, status int, code, id string, args ...interface{}) {
+	msg, lang := h.messages.lookup(r.Header.Get("Accept-Language"), id)
+	// Overrides may leave out the error details.
+	if len(args) > 0 && strings.Contains(msg, "%v") {
+		msg = fmt.Sprintf(msg, args...)
+	}
+	w.Header().Set("Content-Language", lang)
+	w.Header().Add("Vary", "Accept-Language")
+	writeProblem(w, status, code, msg)
+}
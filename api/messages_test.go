@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+func TestAcceptedLanguages(t *testing.T) {
+	tests := []struct {
+		header string
+		exp    []string
+	}{
+		{header: "", exp: []string{}},
+		{header: "nl", exp: []string{"nl"}},
+		{header: "de;q=0.5, nl-BE, *;q=0.1", exp: []string{"nl-be", "de"}},
+		{header: "fr;q=0, en;q=0.8", exp: []string{"en"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			got := acceptedLanguages(tt.header)
+			if strings.Join(got, ",") != strings.Join(tt.exp, ",") {
+				t.Errorf("expected: %v, got: %v", tt.exp, got)
+			}
+		})
+	}
+}
+
+func TestLocalizedErrors(t *testing.T) {
+	overrides, err := ParseMessages([]byte(`{"ES": {"invalidBody": "Contenido no válido."}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseMessages([]byte(`{"es": {"invalidBodyy": "Contenido no válido."}}`)); err == nil {
+		t.Error("expected error for unknown message ID")
+	}
+
+	logger := diag.NewNopLogger()
+	cfg := diag.Config{Repository: noopRepo, Logger: logger}
+	handler, err := NewHandler(context.Background(), Config{Diag: cfg, Logger: logger, Messages: overrides})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		expBody        string
+		expLanguage    string
+	}{
+		{name: "default", expBody: "Invalid body: unexpected EOF", expLanguage: "en"},
+		{name: "built-in language", acceptLanguage: "nl-NL, en;q=0.5", expBody: "Ongeldige inhoud: unexpected EOF", expLanguage: "nl"},
+		{name: "override", acceptLanguage: "es", expBody: "Contenido no válido.", expLanguage: "es"},
+		{name: "unavailable language", acceptLanguage: "ja", expBody: "Invalid body: unexpected EOF", expLanguage: "en"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://example.com/diagnosis-keys", strings.NewReader("foobar"))
+			if tt.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			resp := w.Result()
+
+			if got, exp := resp.StatusCode, 400; got != exp {
+				t.Errorf("expected: %v, got: %v", exp, got)
+			}
+			if got := readProblem(t, resp).Detail; got != tt.expBody {
+				t.Errorf("expected: %v, got: %v", tt.expBody, got)
+			}
+			if got := resp.Header.Get("Content-Language"); got != tt.expLanguage {
+				t.Errorf("expected: %v, got: %v", tt.expLanguage, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// metadataResponse is the JSON representation of the API metadata: the
+// limits and formats of uploads, so clients don't need to hardcode them.
+type metadataResponse struct {
+	MaxUploadBatchSize uint     `json:"maxUploadBatchSize"`
+	MaxUploadBytes     uint     `json:"maxUploadBytes"`
+	DiagnosisKeySize   int      `json:"diagnosisKeySize"`
+	UploadContentTypes []string `json:"uploadContentTypes"`
+}
+
+// metadata writes the API metadata in JSON.
+func (h *handler) metadata(w http.ResponseWriter, r *http.Request) {
+	maxUploadBatchSize := h.diagSvc.MaxUploadBatchSize()
+	writeJSON(w, metadataResponse{
+		MaxUploadBatchSize: maxUploadBatchSize,
+		MaxUploadBytes:     maxUploadBatchSize * diag.DiagnosisKeySize,
+		DiagnosisKeySize:   diag.DiagnosisKeySize,
+		UploadContentTypes: h.uploadMediaTypes(),
+	})
+}
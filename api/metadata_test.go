@@ -0,0 +1,34 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+func TestMetadata(t *testing.T) {
+	handler := newTestHandler(t, &diag.Config{Repository: noopRepo, MaxUploadBatchSize: 7})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/metadata", nil))
+	if got, exp := w.Code, 200; got != exp {
+		t.Fatalf("expected: %v, got: %v", exp, got)
+	}
+
+	var got metadataResponse
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	exp := metadataResponse{
+		MaxUploadBatchSize: 7,
+		MaxUploadBytes:     147,
+		DiagnosisKeySize:   21,
+		UploadContentTypes: []string{"application/octet-stream"},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected: %+v, got: %+v", exp, got)
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/metrics"
+)
+
+var (
+	httpRequests = metrics.DefaultRegistry.Counter(
+		"ctdiag_http_requests_total",
+		"Total number of HTTP requests, by route and status code.",
+		"route", "code",
+	)
+	httpRequestDuration = metrics.DefaultRegistry.Histogram(
+		"ctdiag_http_request_duration_seconds",
+		"Duration of HTTP requests, by route.",
+		metrics.DefaultBuckets,
+		"route",
+	)
+	httpResponseBytes = metrics.DefaultRegistry.Counter(
+		"ctdiag_http_response_bytes_total",
+		"Total number of response body bytes served, by route.",
+		"route",
+	)
+	uploads = metrics.DefaultRegistry.Counter(
+		"ctdiag_uploads_total",
+		"Total number of Diagnosis Key uploads, by result (`accepted` or the rejection reason), app platform and app version.",
+		"result", "platform", "app_version",
+	)
+	uploadedKeys = metrics.DefaultRegistry.Counter(
+		"ctdiag_uploaded_keys_total",
+		"Total number of Diagnosis Keys in accepted uploads.",
+	)
+	clientRequests = metrics.DefaultRegistry.Counter(
+		"ctdiag_client_requests_total",
+		"Total number of HTTP requests, by app platform and app version (major and minor).",
+		"platform", "app_version",
+	)
+)
+
+// instrument records request count, duration and response size metrics of
+// requests to route.
+func instrument(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &metricsRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		httpRequests.Inc(route, strconv.Itoa(rec.status))
+		httpRequestDuration.Observe(time.Since(start).Seconds(), route)
+		httpResponseBytes.Add(float64(rec.bytes), route)
+
+		client := parseAppClient(r)
+		clientRequests.Inc(client.platform, client.version)
+	}
+}
+
+// metricsRecorder is an http.ResponseWriter that records the status code and
+// the number of body bytes written.
+type metricsRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *metricsRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *metricsRecorder) Write(p []byte) (int, error) {
+	n, err := rec.ResponseWriter.Write(p)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher, for streaming responses.
+func (rec *metricsRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInstrument(t *testing.T) {
+	const route = "/instrument-test"
+	next := instrument(route, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("hello"))
+	})
+
+	next(httptest.NewRecorder(), httptest.NewRequest("GET", route, nil))
+	next(httptest.NewRecorder(), httptest.NewRequest("GET", route+"?fail=1", nil))
+
+	if got := httpRequests.Value(route, "200"); got != 1 {
+		t.Errorf("expected 1 request with status 200, got: %v", got)
+	}
+	if got := httpRequests.Value(route, "500"); got != 1 {
+		t.Errorf("expected 1 request with status 500, got: %v", got)
+	}
+	if got := httpResponseBytes.Value(route); got != float64(len("hello")+len("boom\n")) {
+		t.Errorf("expected %v bytes, got: %v", len("hello")+len("boom\n"), got)
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+	"github.com/dstotijn/ct-diag-server/metrics"
+)
+
+// metricsSnapshot is a point in time copy of all metrics and the recent SLO
+// aggregates.
+type metricsSnapshot struct {
+	GeneratedAt time.Time        `json:"generatedAt"`
+	Metrics     []metrics.Family `json:"metrics"`
+	SLO         sloReport        `json:"slo"`
+}
+
+// signedMetricsSnapshot is the JSON representation of an exported metrics
+// snapshot. Signature is the (base64 encoded) signature of the exact bytes of
+// Snapshot.
+type signedMetricsSnapshot struct {
+	Snapshot  json.RawMessage `json:"snapshot"`
+	Signature []byte          `json:"signature"`
+}
+
+// exportMetrics writes a signed metrics snapshot in JSON, as a file download,
+// for audits of deployments that can't be scraped by monitoring systems.
+func (h *handler) exportMetrics(w http.ResponseWriter, r *http.Request) {
+	snapshot := metricsSnapshot{
+		GeneratedAt: time.Now().UTC(),
+		Metrics:     metrics.DefaultRegistry.Snapshot(),
+		SLO:         h.slos.report(),
+	}
+	buf, err := json.Marshal(snapshot)
+	if err != nil {
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	signature, err := h.diagSvc.Sign(buf)
+	switch err {
+	case nil:
+	case diag.ErrSigningUnsupported:
+		writeNotFound(w)
+		return
+	default:
+		h.logger.Error("Could not sign metrics snapshot", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	filename := fmt.Sprintf("metrics-%v.json", snapshot.GeneratedAt.Format("20060102T150405Z"))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	writeJSON(w, signedMetricsSnapshot{Snapshot: buf, Signature: signature})
+}
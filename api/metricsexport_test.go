@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+func TestExportMetrics(t *testing.T) {
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("GET", "http://example.com/admin/metrics/export", nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		return req
+	}
+
+	t.Run("signing disabled", func(t *testing.T) {
+		handler, err := NewHandler(context.Background(), Config{
+			Diag:       diag.Config{Repository: noopRepo, Logger: diag.NewNopLogger()},
+			AdminToken: "s3cret",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, newRequest())
+		if got, exp := w.Result().StatusCode, 404; got != exp {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+	})
+
+	t.Run("signed snapshot", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler, err := NewHandler(context.Background(), Config{
+			Diag:       diag.Config{Repository: noopRepo, Logger: diag.NewNopLogger(), Signer: key},
+			AdminToken: "s3cret",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/diagnosis-keys", nil))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, newRequest())
+		resp := w.Result()
+		if got, exp := resp.StatusCode, 200; got != exp {
+			t.Fatalf("expected: %v, got: %v", exp, got)
+		}
+		if got := resp.Header.Get("Content-Disposition"); got == "" {
+			t.Error("expected `Content-Disposition` header")
+		}
+
+		var signed signedMetricsSnapshot
+		if err := json.NewDecoder(resp.Body).Decode(&signed); err != nil {
+			t.Fatal(err)
+		}
+		digest := sha256.Sum256(signed.Snapshot)
+		if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], signed.Signature) {
+			t.Error("invalid signature")
+		}
+
+		var snapshot metricsSnapshot
+		if err := json.Unmarshal(signed.Snapshot, &snapshot); err != nil {
+			t.Fatal(err)
+		}
+		if got := snapshot.SLO.Endpoints["GET /diagnosis-keys"].Requests; got != 1 {
+			t.Errorf("expected 1 request in SLO aggregates, got: %v", got)
+		}
+	})
+}
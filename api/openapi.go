@@ -0,0 +1,670 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// The OpenAPI document of the API is generated from the route table, so it
+// can't miss an endpoint: every method of every route must be documented in
+// apiOperations, else NewHandler fails. JSON payloads are described by
+// reflecting on the types the handlers write.
+
+// OpenAPI 3.0 objects, limited to the fields the generated document uses.
+type (
+	openAPIDocument struct {
+		OpenAPI    string                                 `json:"openapi"`
+		Info       openAPIInfo                            `json:"info"`
+		Paths      map[string]map[string]openAPIOperation `json:"paths"`
+		Components openAPIComponents                      `json:"components"`
+	}
+	openAPIInfo struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Version     string `json:"version"`
+	}
+	openAPIComponents struct {
+		Schemas         map[string]*openAPISchema        `json:"schemas"`
+		SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+	}
+	openAPISecurityScheme struct {
+		Type   string `json:"type"`
+		Scheme string `json:"scheme"`
+	}
+	openAPIOperation struct {
+		Summary     string                     `json:"summary"`
+		Description string                     `json:"description,omitempty"`
+		Tags        []string                   `json:"tags,omitempty"`
+		Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+		RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+		Responses   map[string]openAPIResponse `json:"responses"`
+		Security    []map[string][]string      `json:"security,omitempty"`
+	}
+	openAPIParameter struct {
+		Name        string         `json:"name"`
+		In          string         `json:"in"`
+		Description string         `json:"description,omitempty"`
+		Required    bool           `json:"required,omitempty"`
+		Schema      *openAPISchema `json:"schema"`
+	}
+	openAPIRequestBody struct {
+		Required bool                        `json:"required"`
+		Content  map[string]openAPIMediaType `json:"content"`
+	}
+	openAPIResponse struct {
+		Description string                      `json:"description"`
+		Headers     map[string]openAPIHeader    `json:"headers,omitempty"`
+		Content     map[string]openAPIMediaType `json:"content,omitempty"`
+	}
+	openAPIHeader struct {
+		Description string         `json:"description"`
+		Schema      *openAPISchema `json:"schema"`
+	}
+	openAPIMediaType struct {
+		Schema *openAPISchema `json:"schema"`
+	}
+	openAPISchema struct {
+		Ref                  string                    `json:"$ref,omitempty"`
+		Type                 string                    `json:"type,omitempty"`
+		Format               string                    `json:"format,omitempty"`
+		Description          string                    `json:"description,omitempty"`
+		Nullable             bool                      `json:"nullable,omitempty"`
+		Enum                 []string                  `json:"enum,omitempty"`
+		Items                *openAPISchema            `json:"items,omitempty"`
+		Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+		AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+		OneOf                []*openAPISchema          `json:"oneOf,omitempty"`
+	}
+)
+
+// openAPIVersion is the version of the API in the generated document.
+const openAPIVersion = "0.9.0"
+
+// apiOperation documents the operation of a route for a method, at an OpenAPI
+// path. Routes matching path prefixes (e.g. `/diagnosis-keys/`) may have
+// operations at several paths. Path parameters are added from the path.
+type apiOperation struct {
+	pattern string
+	method  string
+	path    string
+	op      openAPIOperation
+}
+
+// Schemas and content shorthands of apiOperations.
+var (
+	stringSchema  = &openAPISchema{Type: "string"}
+	integerSchema = &openAPISchema{Type: "integer"}
+	binarySchema  = &openAPISchema{Type: "string", Format: "binary"}
+	problemSchema = &openAPISchema{Ref: "#/components/schemas/Problem"}
+)
+
+func binaryContent(mediaType string) map[string]openAPIMediaType {
+	return map[string]openAPIMediaType{mediaType: {Schema: binarySchema}}
+}
+
+func textContent() map[string]openAPIMediaType {
+	return map[string]openAPIMediaType{"text/plain; charset=utf-8": {Schema: stringSchema}}
+}
+
+// jsonContent returns JSON content, described by the type of v.
+func jsonContent(v interface{}) map[string]openAPIMediaType {
+	return map[string]openAPIMediaType{"application/json": {Schema: schemaOf(reflect.TypeOf(v))}}
+}
+
+func problemResponse(description string) openAPIResponse {
+	return openAPIResponse{Description: description, Content: map[string]openAPIMediaType{mediaTypeProblem: {Schema: problemSchema}}}
+}
+
+func queryParam(name, description string, schema *openAPISchema) openAPIParameter {
+	return openAPIParameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+func headerParam(name, description string) openAPIParameter {
+	return openAPIParameter{Name: name, In: "header", Description: description, Schema: stringSchema}
+}
+
+func okResponse(content map[string]openAPIMediaType) openAPIResponse {
+	return openAPIResponse{Description: "Successful response", Content: content}
+}
+
+// pathParams describes the path parameters of apiOperations, by name.
+var pathParams = map[string]openAPIParameter{
+	"date":   {Description: "Date in `YYYY-MM-DD` format (UTC).", Schema: &openAPISchema{Type: "string", Format: "date"}},
+	"hour":   {Description: "Hour in `HH` format (UTC).", Schema: stringSchema},
+	"hexTEK": {Description: "Hexadecimal encoding of a Temporary Exposure Key.", Schema: stringSchema},
+}
+
+// apiOperations documents the operations of all routes, see openAPI.
+var apiOperations = []apiOperation{
+	{"/diagnosis-keys", http.MethodGet, "/diagnosis-keys", openAPIOperation{
+		Summary: "List Diagnosis Keys",
+		Description: "Lists all published Diagnosis Keys, in the binary representation: 21 bytes per key, i.e. the " +
+			"`TemporaryExposureKey` (16 bytes), `RollingStartNumber` (4 bytes, big endian) and `TransmissionRiskLevel` " +
+			"(1 byte). Supports byte range and conditional requests (`If-None-Match`, `If-Modified-Since`).",
+		Tags: []string{"Diagnosis Keys"},
+		Parameters: []openAPIParameter{
+			queryParam("after", "Lists the keys uploaded after the given key (hexadecimal encoding of a Temporary Exposure Key).", stringSchema),
+			queryParam("cursor", "Opaque cursor of the page, as returned in the `X-Next-Cursor` header. Empty for the first page. Can't be combined with `after`.", stringSchema),
+			queryParam("limit", "Maximum amount of keys of the page. Can't be combined with `after`.", integerSchema),
+			queryParam("region", "Lists the keys tagged with the given region on upload, if regions are enabled.", stringSchema),
+		},
+		Responses: map[string]openAPIResponse{
+			"200": {
+				Description: "Successful response",
+				Headers: map[string]openAPIHeader{
+					"ETag":          {Description: "Strong entity tag of the listing, changes whenever keys are published.", Schema: stringSchema},
+					"Last-Modified": {Description: "Publication time of the listing.", Schema: stringSchema},
+					"X-Next-Cursor": {Description: "Cursor of the next page, for requests with `cursor` or `limit`.", Schema: stringSchema},
+					"Link":          {Description: "URL of the next page (`rel=\"next\"`), if more keys follow.", Schema: stringSchema},
+					"X-Signature":   {Description: "Base64 encoded ECDSA signature of the SHA-256 digest of the page, for requests with `cursor` or `limit`, if signing is enabled.", Schema: stringSchema},
+				},
+				Content: binaryContent(mediaTypeBinary),
+			},
+			"206": {Description: "Partial content of a byte range request", Content: binaryContent(mediaTypeBinary)},
+			"304": {Description: "Not modified"},
+			"400": problemResponse("Invalid query parameters"),
+		},
+	}},
+	{"/diagnosis-keys", http.MethodPost, "/diagnosis-keys", openAPIOperation{
+		Summary: "Upload Diagnosis Keys",
+		Description: "Stores a bytestream of `1 <= n` Diagnosis Keys in the binary representation, where `n` is the " +
+			"maximum upload batch size of the server (see `/metadata`). With rolling periods enabled, the extended " +
+			"representation (22 bytes per key) is accepted too. Duplicate keys are ignored.",
+		Tags: []string{"Diagnosis Keys"},
+		Parameters: []openAPIParameter{
+			headerParam("X-Verification-Certificate", "Verification certificate, if required by the server."),
+			headerParam("X-Verification-HMAC-Key", "Base64 encoded HMAC key of the certificate's `tekmac` claim."),
+			queryParam("regions", "Comma separated regions to tag the keys with, if regions are enabled.", stringSchema),
+			queryParam("reportType", "Report type of the keys, if report types are enabled.", &openAPISchema{
+				Type: "string",
+				Enum: []string{diag.ReportTypeConfirmedTest.String(), diag.ReportTypeConfirmedClinicalDiagnosis.String(), diag.ReportTypeSelfReport.String()},
+			}),
+			queryParam("symptomOnsetInterval", "Exposure Notification interval number of the symptom onset, if report types are enabled.", integerSchema),
+		},
+		RequestBody: &openAPIRequestBody{
+			Required: true,
+			Content: map[string]openAPIMediaType{
+				mediaTypeBinary:       {Schema: binarySchema},
+				mediaTypeExtendedKeys: {Schema: binarySchema},
+			},
+		},
+		Responses: map[string]openAPIResponse{
+			"200": {
+				Description: "Successful response",
+				Headers: map[string]openAPIHeader{
+					"X-Estimated-Publication-Time": {Description: "Estimated time (RFC 3339) at which the keys are published.", Schema: stringSchema},
+					"X-Upload-Receipt":             {Description: "Signed receipt of the upload, if enabled, for withdrawing it.", Schema: stringSchema},
+				},
+				Content: textContent(),
+			},
+			"400": problemResponse("Invalid body or query parameters"),
+			"401": problemResponse("Missing or invalid verification certificate"),
+			"403": problemResponse("The verification certificate doesn't authorize uploads for the regions"),
+			"503": problemResponse("The server is overloaded; retry after the `Retry-After` header"),
+		},
+	}},
+	{"/diagnosis-keys/", http.MethodGet, "/diagnosis-keys/{date}", openAPIOperation{
+		Summary:     "List Diagnosis Keys by date",
+		Description: "Lists the Diagnosis Keys published on a date, in the binary representation. Listings of past dates never change.",
+		Tags:        []string{"Diagnosis Keys"},
+		Responses: map[string]openAPIResponse{
+			"200": okResponse(binaryContent(mediaTypeBinary)),
+			"404": problemResponse("Invalid or future date"),
+		},
+	}},
+	{"/diagnosis-keys/", http.MethodGet, "/diagnosis-keys/{date}/{hour}", openAPIOperation{
+		Summary:     "List Diagnosis Keys by hour",
+		Description: "Lists the Diagnosis Keys published in an hour, in the binary representation, if hourly listings are enabled.",
+		Tags:        []string{"Diagnosis Keys"},
+		Responses: map[string]openAPIResponse{
+			"200": okResponse(binaryContent(mediaTypeBinary)),
+			"404": problemResponse("Incomplete, invalid or disabled hour"),
+		},
+	}},
+	{"/diagnosis-keys/last-modified", http.MethodGet, "/diagnosis-keys/last-modified", openAPIOperation{
+		Summary:     "Check for updates",
+		Description: "Returns the publication time of the listing, without downloading it. Supports `If-Modified-Since`.",
+		Tags:        []string{"Diagnosis Keys"},
+		Responses: map[string]openAPIResponse{
+			"200": okResponse(jsonContent(lastModifiedResponse{})),
+			"304": {Description: "Not modified"},
+		},
+	}},
+	{"/diagnosis-keys/index", http.MethodGet, "/diagnosis-keys/index", openAPIOperation{
+		Summary:     "List batches",
+		Description: "Lists the published batches of the last 14 days, with the paths of their listings. Batches without keys are omitted.",
+		Tags:        []string{"Diagnosis Keys"},
+		Responses:   map[string]openAPIResponse{"200": okResponse(jsonContent(batchIndexResponse{}))},
+	}},
+	{"/diagnosis-keys/withdraw", http.MethodPost, "/diagnosis-keys/withdraw", openAPIOperation{
+		Summary:     "Withdraw an upload",
+		Description: "Withdraws an upload, given its receipt: its keys are deleted, and revoked if they were published already.",
+		Tags:        []string{"Diagnosis Keys"},
+		RequestBody: &openAPIRequestBody{Required: true, Content: map[string]openAPIMediaType{mediaTypeText: {Schema: stringSchema}}},
+		Responses: map[string]openAPIResponse{
+			"200": okResponse(textContent()),
+			"401": problemResponse("Invalid upload receipt"),
+			"404": problemResponse("Upload receipts are disabled"),
+		},
+	}},
+	{"/exposure-key-export/", http.MethodGet, "/exposure-key-export/{date}.zip", openAPIOperation{
+		Summary:     "Download an export file by date",
+		Description: "Returns the signed Exposure Notification export file (a ZIP archive with `export.bin` and `export.sig`) of the Diagnosis Keys published on a date, if exports are enabled.",
+		Tags:        []string{"Export files"},
+		Responses: map[string]openAPIResponse{
+			"200": okResponse(binaryContent("application/zip")),
+			"404": problemResponse("Unpublished, invalid or disabled export"),
+		},
+	}},
+	{"/exposure-key-export/", http.MethodGet, "/exposure-key-export/{date}/{hour}.zip", openAPIOperation{
+		Summary:     "Download an export file by hour",
+		Description: "Returns the signed export file of the Diagnosis Keys published in an hour, if exports and hourly listings are enabled.",
+		Tags:        []string{"Export files"},
+		Responses: map[string]openAPIResponse{
+			"200": okResponse(binaryContent("application/zip")),
+			"404": problemResponse("Unpublished, invalid or disabled export"),
+		},
+	}},
+	{"/metadata", http.MethodGet, "/metadata", openAPIOperation{
+		Summary:     "Retrieve API metadata",
+		Description: "Returns the upload limits and formats of the server, so clients don't need to hardcode them.",
+		Tags:        []string{"Server"},
+		Responses:   map[string]openAPIResponse{"200": okResponse(jsonContent(metadataResponse{}))},
+	}},
+	{"/exposure-config", http.MethodGet, "/exposure-config", openAPIOperation{
+		Summary:     "Retrieve exposure configuration",
+		Description: "Returns the version of the ENExposureConfiguration that's effective at the time of the request for apps of the given region and app version: the one of the most specific matching scope, or else the default. Its ID is returned in the `X-Exposure-Config-Version` header, and its entity tag in the `ETag` header, for conditional requests (`If-None-Match`).",
+		Tags:        []string{"Server"},
+		Parameters:  exposureConfigScopeParams,
+		Responses: map[string]openAPIResponse{
+			"200": okResponse(jsonContent(diag.ExposureConfig{})),
+			"304": {Description: "Not modified (`If-None-Match`)"},
+			"400": problemResponse("Invalid query parameters"),
+		},
+	}},
+	{"/exposure-config", http.MethodPut, "/exposure-config", openAPIOperation{
+		Summary:     "Update exposure configuration",
+		Description: "Stores a new version of the ENExposureConfiguration, effective immediately for apps of the given region and app version (default: all), and returns it. Unknown fields are rejected.",
+		Tags:        []string{"Admin"},
+		Security:    adminSecurity,
+		Parameters:  exposureConfigScopeParams,
+		RequestBody: &openAPIRequestBody{Required: true, Content: jsonContent(diag.ExposureConfig{})},
+		Responses: map[string]openAPIResponse{
+			"200": okResponse(jsonContent(diag.ExposureConfigVersion{})),
+			"400": problemResponse("Invalid body or query parameters"),
+			"404": problemResponse("The repository doesn't support storing exposure configurations"),
+		},
+	}},
+	{"/revocations", http.MethodGet, "/revocations", openAPIOperation{
+		Summary:     "List revoked keys",
+		Description: "Lists the revoked Temporary Exposure Keys (16 bytes each), signed with the signing key of the server (`X-Signature` header), if revocations are enabled.",
+		Tags:        []string{"Diagnosis Keys"},
+		Responses: map[string]openAPIResponse{
+			"200": okResponse(binaryContent(mediaTypeBinary)),
+			"404": problemResponse("Revocations are disabled"),
+		},
+	}},
+	{"/transparency/sth", http.MethodGet, "/transparency/sth", openAPIOperation{
+		Summary:   "Retrieve the signed tree head",
+		Tags:      []string{"Transparency log"},
+		Responses: map[string]openAPIResponse{"200": okResponse(jsonContent(treeHeadResponse{})), "404": problemResponse("The transparency log is disabled")},
+	}},
+	{"/transparency/inclusion", http.MethodGet, "/transparency/inclusion", openAPIOperation{
+		Summary: "Retrieve an inclusion proof",
+		Tags:    []string{"Transparency log"},
+		Parameters: []openAPIParameter{
+			{Name: "key", In: "query", Description: "Hexadecimal encoding of a Temporary Exposure Key.", Required: true, Schema: stringSchema},
+			queryParam("treeSize", "Size of the tree, default: latest.", integerSchema),
+		},
+		Responses: map[string]openAPIResponse{"200": okResponse(jsonContent(inclusionProofResponse{})), "400": problemResponse("Invalid query parameters")},
+	}},
+	{"/transparency/consistency", http.MethodGet, "/transparency/consistency", openAPIOperation{
+		Summary: "Retrieve a consistency proof",
+		Tags:    []string{"Transparency log"},
+		Parameters: []openAPIParameter{
+			{Name: "first", In: "query", Description: "Size of the first tree.", Required: true, Schema: integerSchema},
+			queryParam("second", "Size of the second tree, default: latest.", integerSchema),
+		},
+		Responses: map[string]openAPIResponse{"200": okResponse(jsonContent(consistencyProofResponse{})), "400": problemResponse("Invalid tree sizes")},
+	}},
+	{"/transparency/leaves", http.MethodGet, "/transparency/leaves", openAPIOperation{
+		Summary:   "List the leaves of the transparency log",
+		Tags:      []string{"Transparency log"},
+		Responses: map[string]openAPIResponse{"200": okResponse(binaryContent(mediaTypeBinary)), "404": problemResponse("The transparency log is disabled")},
+	}},
+	{"/federation/diagnosis-keys", http.MethodPost, "/federation/diagnosis-keys", openAPIOperation{
+		Summary:     "Receive pushed keys",
+		Description: "Stores Diagnosis Keys pushed by a peer server, in the binary representation, if senders are configured.",
+		Tags:        []string{"Federation"},
+		Parameters: []openAPIParameter{
+			headerParam("X-Federation-Origin", "Origin of the peer."),
+			headerParam("X-Signature", "Base64 encoded ECDSA signature of the SHA-256 digest of the body, by the peer."),
+		},
+		RequestBody: &openAPIRequestBody{Required: true, Content: binaryContent(mediaTypeBinary)},
+		Responses: map[string]openAPIResponse{
+			"200": okResponse(textContent()),
+			"401": problemResponse("Unknown origin or invalid signature"),
+			"404": problemResponse("Pushes are disabled"),
+		},
+	}},
+	{"/health", http.MethodGet, "/health", openAPIOperation{
+		Summary:     "Health check",
+		Description: "Returns `OK`, or with an `Accept: application/json` header, the current upload load too.",
+		Tags:        []string{"Server"},
+		Responses: map[string]openAPIResponse{"200": okResponse(map[string]openAPIMediaType{
+			"text/plain; charset=utf-8": {Schema: stringSchema},
+			"application/json":          jsonContent(healthResponse{})["application/json"],
+		})},
+	}},
+	{"/health/live", http.MethodGet, "/health/live", openAPIOperation{
+		Summary:     "Liveness probe",
+		Description: "Returns `OK` as long as the server handles requests, without checking its dependencies.",
+		Tags:        []string{"Server"},
+		Responses:   map[string]openAPIResponse{"200": okResponse(textContent())},
+	}},
+	{"/health/ready", http.MethodGet, "/health/ready", openAPIOperation{
+		Summary:     "Readiness probe",
+		Description: "Pings the databases and reports the cache hydration status.",
+		Tags:        []string{"Server"},
+		Responses: map[string]openAPIResponse{
+			"200": okResponse(jsonContent(readinessResponse{})),
+			"503": {Description: "A database is unavailable, or the cache isn't hydrated", Content: jsonContent(readinessResponse{})},
+		},
+	}},
+	{"/time", http.MethodGet, "/time", openAPIOperation{
+		Summary:     "Check the device clock",
+		Description: "Returns the server time, so clients can detect a wrong device clock before generating keys.",
+		Tags:        []string{"Server"},
+		Responses:   map[string]openAPIResponse{"200": okResponse(jsonContent(timeResponse{}))},
+	}},
+	{"/openapi.json", http.MethodGet, "/openapi.json", openAPIOperation{
+		Summary:     "Retrieve the OpenAPI document",
+		Description: "Returns this document.",
+		Tags:        []string{"Server"},
+		Responses:   map[string]openAPIResponse{"200": okResponse(map[string]openAPIMediaType{"application/json": {Schema: &openAPISchema{Type: "object"}}})},
+	}},
+	{"/admin/slo", http.MethodGet, "/admin/slo", openAPIOperation{
+		Summary:   "Retrieve the SLO report",
+		Responses: map[string]openAPIResponse{"200": okResponse(jsonContent(sloReport{}))},
+	}},
+	{"/admin/metrics/export", http.MethodGet, "/admin/metrics/export", openAPIOperation{
+		Summary:     "Export metrics",
+		Description: "Returns a snapshot of all metrics and SLO aggregates, signed with the signing key of the server.",
+		Responses: map[string]openAPIResponse{
+			"200": okResponse(jsonContent(signedMetricsSnapshot{})),
+			"404": problemResponse("No signing key is configured"),
+		},
+	}},
+	{"/admin/revocations", http.MethodPost, "/admin/revocations", openAPIOperation{
+		Summary:     "Revoke Diagnosis Keys",
+		Description: "Revokes a bytestream of `1 <= n <= 1000` Temporary Exposure Keys (16 bytes each).",
+		Parameters: []openAPIParameter{
+			queryParam("dryRun", "Lists the keys that would be revoked, already are, or aren't stored, without revoking them, if `true`.", &openAPISchema{Type: "boolean"}),
+		},
+		RequestBody: &openAPIRequestBody{Required: true, Content: binaryContent(mediaTypeBinary)},
+		Responses: map[string]openAPIResponse{
+			"200": okResponse(map[string]openAPIMediaType{
+				"text/plain; charset=utf-8": {Schema: stringSchema},
+				"application/json":          jsonContent(revocationReportResponse{})["application/json"],
+			}),
+			"400": problemResponse("Invalid body"),
+		},
+	}},
+	{"/admin/cache/refresh", http.MethodPost, "/admin/cache/refresh", openAPIOperation{
+		Summary:   "Refresh the cache",
+		Responses: map[string]openAPIResponse{"200": okResponse(textContent())},
+	}},
+	{"/admin/purge", http.MethodPost, "/admin/purge", openAPIOperation{
+		Summary:     "Purge expired Diagnosis Keys",
+		Description: "Purges the keys uploaded before the retention period now, like the janitor, and returns the job run.",
+		Parameters: []openAPIParameter{
+			queryParam("dryRun", "Lists the keys (and their amounts per upload day) that would be purged, without purging them, if `true`.", &openAPISchema{Type: "boolean"}),
+		},
+		Responses: map[string]openAPIResponse{
+			"200": okResponse(map[string]openAPIMediaType{
+				"application/json": {Schema: &openAPISchema{OneOf: []*openAPISchema{schemaOf(reflect.TypeOf(diag.JobRun{})), schemaOf(reflect.TypeOf(purgeReportResponse{}))}}},
+			}),
+			"404": problemResponse("Purging is disabled, or dry runs are unsupported by the database"),
+		},
+	}},
+	{"/admin/status", http.MethodGet, "/admin/status", openAPIOperation{
+		Summary:   "Status page",
+		Responses: map[string]openAPIResponse{"200": okResponse(map[string]openAPIMediaType{"text/html; charset=utf-8": {Schema: stringSchema}})},
+	}},
+	{"/admin/jobs", http.MethodGet, "/admin/jobs", openAPIOperation{
+		Summary: "List job runs",
+		Parameters: []openAPIParameter{
+			queryParam("limit", "Maximum amount of runs, newest first (default: 50).", integerSchema),
+			queryParam("failed", "Lists failed runs only, if `true`.", &openAPISchema{Type: "boolean"}),
+		},
+		Responses: map[string]openAPIResponse{
+			"200": okResponse(jsonContent(jobRunsResponse{})),
+			"400": problemResponse("Invalid query parameters"),
+		},
+	}},
+	{"/admin/jobs/retry", http.MethodPost, "/admin/jobs/retry", openAPIOperation{
+		Summary:    "Retry a failed job run",
+		Parameters: []openAPIParameter{{Name: "id", In: "query", Description: "ID of the failed run.", Required: true, Schema: integerSchema}},
+		Responses: map[string]openAPIResponse{
+			"200": okResponse(jsonContent(diag.JobRun{})),
+			"400": problemResponse("Invalid ID, or the run didn't fail"),
+			"404": problemResponse("Unknown run"),
+		},
+	}},
+	{"/admin/keys/", http.MethodGet, "/admin/keys/{hexTEK}", openAPIOperation{
+		Summary: "Look up a Diagnosis Key",
+		Responses: map[string]openAPIResponse{
+			"200": okResponse(jsonContent(storedKeyResponse{})),
+			"400": problemResponse("Invalid key"),
+			"404": problemResponse("Unknown key"),
+		},
+	}},
+	{"/admin/exposure-config/history", http.MethodGet, "/admin/exposure-config/history", openAPIOperation{
+		Summary:   "List the versions of the exposure configuration",
+		Responses: map[string]openAPIResponse{"200": okResponse(jsonContent(diag.ExposureConfigHistory{}))},
+	}},
+	{"/admin/federation/status", http.MethodGet, "/admin/federation/status", openAPIOperation{
+		Summary:     "Federation peer status",
+		Description: "Lists the sync status of the peers keys are pulled from: the last (successful) pull, imported keys, refused pages, and the lag behind the publication time advertised by the peer.",
+		Responses: map[string]openAPIResponse{
+			"200": okResponse(jsonContent(federationStatusResponse{})),
+			"404": problemResponse("Pulling from peers is disabled"),
+		},
+	}},
+	{"/admin/federation/pull", http.MethodPost, "/admin/federation/pull", openAPIOperation{
+		Summary:     "Pull Diagnosis Keys from federation peers",
+		Description: "Pulls the keys each peer published since the previous pull now, e.g. triggered by a scheduler when running request-driven, and returns the sync status of the peers.",
+		Responses: map[string]openAPIResponse{
+			"200": okResponse(jsonContent(federationStatusResponse{})),
+			"404": problemResponse("Pulling from peers is disabled"),
+		},
+	}},
+	{"/admin/federation/push", http.MethodPost, "/admin/federation/push", openAPIOperation{
+		Summary:     "Push Diagnosis Keys to federation peers",
+		Description: "Pushes the local keys stored since the previous push to each peer now, e.g. triggered by a scheduler when running request-driven.",
+		Responses: map[string]openAPIResponse{
+			"200": okResponse(textContent()),
+			"404": problemResponse("Pushing to peers is disabled"),
+		},
+	}},
+	{"/admin/diagnosis-keys/stream", http.MethodGet, "/admin/diagnosis-keys/stream", openAPIOperation{
+		Summary: "Stream all Diagnosis Keys",
+		Description: "Streams all published Diagnosis Keys as frames of a varint encoded length and a `TemporaryExposureKey` " +
+			"message of export files. Zero length frames are heartbeats. The amount of keys is sent as `X-Key-Count` trailer.",
+		Responses: map[string]openAPIResponse{"200": okResponse(binaryContent(mediaTypeKeyStream))},
+	}},
+}
+
+// problemCodes are the error codes of problem responses.
+var problemCodes = []string{
+	codeInvalidBody, codeInvalidKeyLength, codeBatchTooLarge, codeImplausibleKeys, codeInvalidCertificate,
+	codeRegionNotAllowed, codeInvalidReceipt, codeInvalidSignature, codeUnavailable, codeQuotaExceeded, codeInvalidAfterParam,
+	codeInvalidCursorParam, codeInvalidLimitParam, codeConflictingParams, codeInvalidRegionParam,
+	codeInvalidRegionsParam, codeInvalidAppVersionParam, codeInvalidReportTypeParam,
+	codeInvalidSymptomOnsetParam, codeInvalidKeyParam, codeInvalidIDParam, codeInvalidTreeSize, codeJobRunNotFailed, codeUnsupportedMediaType,
+	codeMethodNotAllowed, codeUnauthorized, codeNotFound, codeInternalError,
+}
+
+// adminSecurity is the security requirement of operations that require the
+// admin token.
+var adminSecurity = []map[string][]string{{"adminToken": {}}}
+
+// exposureConfigScopeParams are the query parameters of the scope of versions
+// of the exposure configuration.
+var exposureConfigScopeParams = []openAPIParameter{
+	queryParam("region", "Region of the app, e.g. `NL`.", stringSchema),
+	queryParam("appVersion", "Version of the app, in dot separated numbers, e.g. `1.2`.", stringSchema),
+}
+
+var pathParamRegexp = regexp.MustCompile(`\{(\w+)\}`)
+
+// openAPI returns the OpenAPI document of routes. It returns an error if a
+// method of a route isn't documented in apiOperations.
+func openAPI(routes []route) (openAPIDocument, error) {
+	doc := openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title:       "ct-diag-server",
+			Description: "HTTP server for storing and retrieving Diagnosis Keys of the Apple/Google Exposure Notification framework.",
+			Version:     openAPIVersion,
+		},
+		Paths: make(map[string]map[string]openAPIOperation),
+		Components: openAPIComponents{
+			Schemas:         map[string]*openAPISchema{"Problem": schemaOf(reflect.TypeOf(problem{}))},
+			SecuritySchemes: map[string]openAPISecurityScheme{"adminToken": {Type: "http", Scheme: "bearer"}},
+		},
+	}
+	doc.Components.Schemas["Problem"].Properties["code"].Enum = problemCodes
+
+	for _, rt := range routes {
+		for _, method := range rt.methods {
+			documented := false
+			for _, apiOp := range apiOperations {
+				if apiOp.pattern != rt.pattern || apiOp.method != method {
+					continue
+				}
+				documented = true
+
+				op := apiOp.op
+				op.Parameters = append(pathParameters(apiOp.path), op.Parameters...)
+				op.Responses = make(map[string]openAPIResponse, len(apiOp.op.Responses)+1)
+				for status, resp := range apiOp.op.Responses {
+					op.Responses[status] = resp
+				}
+				// Operations of public routes can require the admin token
+				// themselves, see adminSecurity.
+				if rt.admin {
+					op.Tags = []string{"Admin"}
+					op.Security = adminSecurity
+				}
+				if op.Security != nil {
+					op.Responses["401"] = problemResponse("Missing or invalid admin token")
+				}
+				if op.RequestBody != nil {
+					op.Responses["415"] = problemResponse("Unsupported `Content-Type`, the supported types are listed in the `Accept` header")
+				}
+				if doc.Paths[apiOp.path] == nil {
+					doc.Paths[apiOp.path] = make(map[string]openAPIOperation)
+				}
+				doc.Paths[apiOp.path][strings.ToLower(method)] = op
+			}
+			if !documented {
+				return openAPIDocument{}, fmt.Errorf("api: route %v %v isn't documented", method, rt.pattern)
+			}
+		}
+	}
+
+	return doc, nil
+}
+
+// pathParameters returns the parameters of the `{name}` segments of path.
+func pathParameters(path string) []openAPIParameter {
+	var params []openAPIParameter
+	for _, m := range pathParamRegexp.FindAllStringSubmatch(path, -1) {
+		param := pathParams[m[1]]
+		param.Name, param.In, param.Required = m[1], "path", true
+		if param.Schema == nil {
+			param.Schema = stringSchema
+		}
+		params = append(params, param)
+	}
+	return params
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaOf returns the schema of the JSON encoding of values of type t, as
+// written by encoding/json.
+func schemaOf(t reflect.Type) *openAPISchema {
+	switch t {
+	case timeType:
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		return &openAPISchema{Type: "object"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := schemaOf(t.Elem())
+		s.Nullable = true
+		return s
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &openAPISchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number"}
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &openAPISchema{Type: "string", Format: "byte"}
+		}
+		return &openAPISchema{Type: "array", Items: schemaOf(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: schemaOf(t.Elem())}
+	case reflect.Struct:
+		s := &openAPISchema{Type: "object", Properties: make(map[string]*openAPISchema)}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := f.Name
+			if tag := f.Tag.Get("json"); tag != "" {
+				name = strings.Split(tag, ",")[0]
+			}
+			if f.PkgPath != "" || name == "-" {
+				continue
+			}
+			// Fields of embedded structs are promoted.
+			if f.Anonymous && name == f.Name && f.Type.Kind() == reflect.Struct {
+				for k, v := range schemaOf(f.Type).Properties {
+					s.Properties[k] = v
+				}
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			s.Properties[name] = schemaOf(f.Type)
+		}
+		return s
+	}
+
+	return &openAPISchema{}
+}
+
+// openAPIJSON writes the OpenAPI document of the API.
+func (h *handler) openAPIJSON(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(h.openAPIDoc)
+}
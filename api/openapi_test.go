@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+func TestOpenAPI(t *testing.T) {
+	handler, err := NewHandler(context.Background(), Config{
+		Diag: diag.Config{Repository: noopRepo, Logger: diag.NewNopLogger()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/openapi.json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected: %v, got: %v", http.StatusOK, w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("expected: application/json, got: %v", got)
+	}
+
+	var doc openAPIDocument
+	if err := json.NewDecoder(w.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.0.3" {
+		t.Errorf("expected: 3.0.3, got: %v", doc.OpenAPI)
+	}
+
+	for _, apiOp := range apiOperations {
+		if _, ok := doc.Paths[apiOp.path]; !ok {
+			t.Errorf("expected path %v", apiOp.path)
+		}
+	}
+
+	byDate := doc.Paths["/diagnosis-keys/{date}"]["get"]
+	if len(byDate.Parameters) != 1 || byDate.Parameters[0].In != "path" || !byDate.Parameters[0].Required {
+		t.Errorf("expected required path parameter, got: %+v", byDate.Parameters)
+	}
+
+	slo := doc.Paths["/admin/slo"]["get"]
+	if len(slo.Security) != 1 || slo.Responses["401"].Description == "" {
+		t.Errorf("expected admin operation to require the admin token, got: %+v", slo)
+	}
+
+	timeSchema := doc.Paths["/time"]["get"].Responses["200"].Content["application/json"].Schema
+	if s := timeSchema.Properties["time"]; s == nil || s.Format != "date-time" {
+		t.Errorf("expected `time` property of type date-time, got: %+v", s)
+	}
+	lastModified := doc.Paths["/diagnosis-keys/last-modified"]["get"].Responses["200"].Content["application/json"].Schema
+	if s := lastModified.Properties["lastModified"]; s == nil || !s.Nullable {
+		t.Errorf("expected nullable `lastModified` property, got: %+v", s)
+	}
+
+	upload := doc.Paths["/diagnosis-keys"]["post"]
+	if _, ok := upload.Responses["415"]; !ok {
+		t.Error("expected `415` response of operation with request body")
+	}
+
+	if got := len(doc.Components.Schemas["Problem"].Properties["code"].Enum); got != len(problemCodes) {
+		t.Errorf("expected: %v error codes, got: %v", len(problemCodes), got)
+	}
+}
+
+func TestOpenAPIUndocumentedRoute(t *testing.T) {
+	routes := []route{{"/foobar", "", []string{http.MethodGet}, false, cacheNone, nil}}
+	if _, err := openAPI(routes); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// listDiagnosisKeysPage writes at most `limit` diagnosis keys after the
+// opaque `cursor` as binary data in the HTTP response. The cursor of the next
+// page is written in the `X-Next-Cursor` header, also when there are no more
+// keys yet, so clients can use it to sync new keys later on. If more keys
+// follow, a `Link` header with the URL of the next page is written as well.
+// If a signing key is configured, the signature of the page (base64 encoded)
+// is written in the `X-Signature` header, so peers can verify pulled pages.
+// Signatures are computed once per cache refresh, see diag.Service.ReadSeekerPageSigned.
+func (h *handler) listDiagnosisKeysPage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	cursor, err := diag.ParseCursor(query.Get("cursor"))
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidCursorParam, "Invalid `cursor` query parameter, must be a cursor returned in the `X-Next-Cursor` header.")
+		return
+	}
+
+	var limit int
+	if limitParam := query.Get("limit"); limitParam != "" {
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit < 1 {
+			writeProblem(w, http.StatusBadRequest, codeInvalidLimitParam, "Invalid `limit` query parameter, must be a positive integer.")
+			return
+		}
+	}
+
+	rs, next, more, signature, err := h.diagSvc.ReadSeekerPageSigned(cursor, limit)
+	if err != nil {
+		h.logger.Error("Could not list diagnosis keys page", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+	if signature != nil {
+		w.Header().Set("X-Signature", base64.StdEncoding.EncodeToString(signature))
+	}
+
+	w.Header().Set("X-Next-Cursor", next.String())
+	if more {
+		nextQuery := url.Values{"cursor": {next.String()}}
+		if limit > 0 {
+			nextQuery.Set("limit", strconv.Itoa(limit))
+		}
+		w.Header().Set("Link", "<"+r.URL.Path+"?"+nextQuery.Encode()+`>; rel="next"`)
+	}
+	w.Header().Set("ETag", h.diagSvc.ETag(cursor.String()+"/"+strconv.Itoa(limit)))
+
+	http.ServeContent(w, r, "", h.diagSvc.LastModified(), rs)
+}
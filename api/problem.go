@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// mediaTypeProblem is the media type of error responses, see RFC 7807.
+const mediaTypeProblem = "application/problem+json"
+
+// Error codes of error responses. Unlike messages, they are stable, so
+// clients can handle errors without parsing them.
+const (
+	codeInvalidBody              = "invalid_body"
+	codeInvalidKeyLength         = "invalid_key_length"
+	codeBatchTooLarge            = "batch_too_large"
+	codeImplausibleKeys          = "implausible_keys"
+	codeInvalidCertificate       = "invalid_certificate"
+	codeRegionNotAllowed         = "region_not_allowed"
+	codeInvalidReceipt           = "invalid_receipt"
+	codeInvalidSignature         = "invalid_signature"
+	codeUnavailable              = "unavailable"
+	codeQuotaExceeded            = "quota_exceeded"
+	codeInvalidAfterParam        = "invalid_after_param"
+	codeInvalidCursorParam       = "invalid_cursor_param"
+	codeInvalidLimitParam        = "invalid_limit_param"
+	codeConflictingParams        = "conflicting_params"
+	codeInvalidRegionParam       = "invalid_region_param"
+	codeInvalidRegionsParam      = "invalid_regions_param"
+	codeInvalidAppVersionParam   = "invalid_app_version_param"
+	codeInvalidReportTypeParam   = "invalid_report_type_param"
+	codeInvalidSymptomOnsetParam = "invalid_symptom_onset_param"
+	codeInvalidKeyParam          = "invalid_key_param"
+	codeInvalidIDParam           = "invalid_id_param"
+	codeInvalidTreeSize          = "invalid_tree_size"
+	codeJobRunNotFailed          = "job_run_not_failed"
+	codeUnsupportedMediaType     = "unsupported_media_type"
+	codeMethodNotAllowed         = "method_not_allowed"
+	codeUnauthorized             = "unauthorized"
+	codeNotFound                 = "not_found"
+	codeInternalError            = "internal_error"
+)
+
+// problem is the JSON representation of an error response, a "problem
+// details" object (RFC 7807) with the error code as extension member.
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Code   string `json:"code"`
+}
+
+// writeProblem writes an error response with HTTP status code `status`, error
+// code `code` and (optionally) a human-readable explanation. Problems aren't
+// further specified by a type URI, so their title is the status text.
+func writeProblem(w http.ResponseWriter, status int, code, detail string) {
+	// Handlers may have set headers for the response they'd have written.
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", mediaTypeProblem)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	})
+}
+
+// writeNotFound writes a `404 Not Found` error response.
+func writeNotFound(w http.ResponseWriter) {
+	writeProblem(w, http.StatusNotFound, codeNotFound, "")
+}
+
+func writeInternalErrorResp(w http.ResponseWriter, err error) {
+	writeProblem(w, http.StatusInternalServerError, codeInternalError, "")
+}
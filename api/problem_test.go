@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// readProblem decodes the problem details of an error response.
+func readProblem(t *testing.T, resp *http.Response) problem {
+	t.Helper()
+
+	if got := resp.Header.Get("Content-Type"); got != mediaTypeProblem {
+		t.Errorf("expected: %v, got: %v", mediaTypeProblem, got)
+	}
+	var p problem
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != resp.StatusCode {
+		t.Errorf("expected: %v, got: %v", resp.StatusCode, p.Status)
+	}
+	return p
+}
+
+func TestProblems(t *testing.T) {
+	handler := newTestHandler(t, &diag.Config{Repository: noopRepo})
+
+	tests := []struct {
+		name          string
+		method        string
+		target        string
+		contentType   string
+		expStatusCode int
+		expCode       string
+	}{
+		{"invalid cursor", "GET", "/diagnosis-keys?cursor=foobar", "", 400, codeInvalidCursorParam},
+		{"invalid limit", "GET", "/diagnosis-keys?limit=0", "", 400, codeInvalidLimitParam},
+		{"conflicting params", "GET", "/diagnosis-keys?limit=1&after=00", "", 400, codeConflictingParams},
+		{"unsupported media type", "POST", "/diagnosis-keys", "application/json", 415, codeUnsupportedMediaType},
+		{"not found", "GET", "/diagnosis-keys/2020-13-01", "", 404, codeNotFound},
+		{"admin disabled", "GET", "/admin/status", "", 404, codeNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "http://example.com"+tt.target, nil)
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			resp := w.Result()
+
+			if got := resp.StatusCode; got != tt.expStatusCode {
+				t.Errorf("expected: %v, got: %v", tt.expStatusCode, got)
+			}
+			p := readProblem(t, resp)
+			if p.Code != tt.expCode {
+				t.Errorf("expected: %v, got: %v", tt.expCode, p.Code)
+			}
+			if exp := http.StatusText(tt.expStatusCode); p.Title != exp {
+				t.Errorf("expected: %v, got: %v", exp, p.Title)
+			}
+		})
+	}
+}
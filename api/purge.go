@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// purgeReportResponse is the JSON representation of the Diagnosis Keys a
+// purge would delete.
+type purgeReportResponse struct {
+	Before  time.Time          `json:"before"`
+	Count   int                `json:"count"`
+	Batches []purgeBatch       `json:"batches"`
+	Keys    []purgedKeyElement `json:"keys"`
+}
+
+type purgeBatch struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+type purgedKeyElement struct {
+	TemporaryExposureKey string    `json:"temporaryExposureKey"`
+	UploadedAt           time.Time `json:"uploadedAt"`
+}
+
+// revocationReportResponse is the JSON representation of what revoking keys
+// would change. Unknown is null if stored keys can't be looked up.
+type revocationReportResponse struct {
+	Revoked        []string `json:"revoked"`
+	AlreadyRevoked []string `json:"alreadyRevoked"`
+	Unknown        []string `json:"unknown"`
+}
+
+// isDryRun returns whether r asks for a dry run (`dryRun=true`), which
+// reports the changes of a destructive operation without making them.
+func isDryRun(r *http.Request) bool {
+	return r.URL.Query().Get("dryRun") == "true"
+}
+
+// purge purges expired Diagnosis Keys now, like the janitor, and writes the
+// run in JSON. With `dryRun=true`, the keys that would be deleted are written
+// instead, and nothing is deleted.
+func (h *handler) purge(w http.ResponseWriter, r *http.Request) {
+	if isDryRun(r) {
+		h.purgeDryRun(w, r)
+		return
+	}
+
+	run, err := h.diagSvc.Purge(r.Context())
+	switch err {
+	case nil:
+	case diag.ErrPurgeUnsupported:
+		writeNotFound(w)
+		return
+	default:
+		h.logger.Error("Could not purge diagnosis keys", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	h.logger.Info("Purge run.", diag.F("error", run.Error))
+
+	writeJSON(w, run)
+}
+
+// purgeDryRun writes the Diagnosis Keys a purge would delete in JSON.
+func (h *handler) purgeDryRun(w http.ResponseWriter, r *http.Request) {
+	report, err := h.diagSvc.PurgeDryRun(r.Context())
+	switch err {
+	case nil:
+	case diag.ErrPurgeUnsupported, diag.ErrDryRunUnsupported:
+		writeNotFound(w)
+		return
+	default:
+		h.logger.Error("Could not dry run purge", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	resp := purgeReportResponse{
+		Before:  report.Before,
+		Count:   len(report.Keys),
+		Batches: make([]purgeBatch, len(report.Batches)),
+		Keys:    make([]purgedKeyElement, len(report.Keys)),
+	}
+	for i, batch := range report.Batches {
+		resp.Batches[i] = purgeBatch{Date: batch.Date, Count: batch.Count}
+	}
+	for i, rawKey := range report.Keys {
+		resp.Keys[i] = purgedKeyElement{
+			TemporaryExposureKey: hex.EncodeToString(rawKey.TemporaryExposureKey),
+			UploadedAt:           rawKey.UploadedAt.UTC(),
+		}
+	}
+	writeJSON(w, resp)
+}
+
+// revocationDryRun writes what revoking keys would change in JSON.
+func (h *handler) revocationDryRun(w http.ResponseWriter, r *http.Request, keys [][16]byte) {
+	report, err := h.diagSvc.RevokeDiagnosisKeysDryRun(r.Context(), keys)
+	switch err {
+	case nil:
+	case diag.ErrRevocationUnsupported:
+		writeNotFound(w)
+		return
+	default:
+		h.logger.Error("Could not dry run revocation", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	writeJSON(w, revocationReportResponse{
+		Revoked:        hexKeys(report.Revoked),
+		AlreadyRevoked: hexKeys(report.AlreadyRevoked),
+		Unknown:        hexKeys(report.Unknown),
+	})
+}
+
+// hexKeys returns the hexadecimal encodings of keys, or nil if keys is nil.
+func hexKeys(keys [][16]byte) []string {
+	if keys == nil {
+		return nil
+	}
+	encoded := make([]string, len(keys))
+	for i, key := range keys {
+		encoded[i] = hex.EncodeToString(key[:])
+	}
+	return encoded
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// testPurgingRepository deletes keys from rawKeys. It's safe for concurrent
+// use, as the janitor purges on startup.
+type testPurgingRepository struct {
+	testRepository
+	mu      sync.Mutex
+	rawKeys []diag.RawDiagnosisKey
+}
+
+func (ts *testPurgingRepository) DeleteDiagnosisKeysBefore(_ context.Context, t time.Time) (int64, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	var kept []diag.RawDiagnosisKey
+	for _, rawKey := range ts.rawKeys {
+		if !rawKey.UploadedAt.Before(t) {
+			kept = append(kept, rawKey)
+		}
+	}
+	n := len(ts.rawKeys) - len(kept)
+	ts.rawKeys = kept
+	return int64(n), nil
+}
+
+func (ts *testPurgingRepository) ScanDiagnosisKeys(_ context.Context, fn func(diag.RawDiagnosisKey) error) error {
+	ts.mu.Lock()
+	rawKeys := ts.rawKeys
+	ts.mu.Unlock()
+	for _, rawKey := range rawKeys {
+		if err := fn(rawKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ts *testPurgingRepository) QuarantineDiagnosisKeys(context.Context, []diag.Violation, time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (ts *testPurgingRepository) keyCount() int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return len(ts.rawKeys)
+}
+
+func TestPurge(t *testing.T) {
+	// Keys are uploaded at noon, so keys of the same day share a batch.
+	today := time.Now().UTC().Truncate(24 * time.Hour).Add(12 * time.Hour)
+	daysAgo := func(days int) time.Time { return today.AddDate(0, 0, -days) }
+	newRepo := func() *testPurgingRepository {
+		return &testPurgingRepository{
+			testRepository: noopRepo,
+			rawKeys: []diag.RawDiagnosisKey{
+				{TemporaryExposureKey: []byte{1, 15: 0}, UploadedAt: daysAgo(20)},
+				{TemporaryExposureKey: []byte{2, 15: 0}, UploadedAt: daysAgo(1)},
+				// Imported after a newer key, with an older upload time.
+				{TemporaryExposureKey: []byte{3, 15: 0}, UploadedAt: daysAgo(20).Add(-time.Minute)},
+				{TemporaryExposureKey: []byte{4, 15: 0}, UploadedAt: daysAgo(16)},
+			},
+		}
+	}
+	newHandler := func(repo diag.Repository, dryRun bool) http.Handler {
+		handler, err := NewHandler(context.Background(), Config{
+			Diag: diag.Config{
+				Repository:      repo,
+				Logger:          diag.NewNopLogger(),
+				RetentionPeriod: 14 * 24 * time.Hour,
+				PurgeDryRun:     dryRun,
+			},
+			AdminToken: "secret",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return handler
+	}
+	post := func(handler http.Handler, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", target, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		repo := newRepo()
+		handler := newHandler(repo, true)
+
+		w := post(handler, "http://example.com/admin/purge?dryRun=true")
+		if got, exp := w.Code, http.StatusOK; got != exp {
+			t.Fatalf("expected: %v, got: %v", exp, got)
+		}
+		var body purgeReportResponse
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, key := range body.Keys {
+			keys = append(keys, key.TemporaryExposureKey[:2])
+		}
+		if exp := []string{"01", "03", "04"}; body.Count != 3 || !reflect.DeepEqual(keys, exp) {
+			t.Errorf("expected: %v, got: %v (count: %v)", exp, keys, body.Count)
+		}
+		expBatches := []purgeBatch{
+			{Date: daysAgo(20).Format(dateLayout), Count: 2},
+			{Date: daysAgo(16).Format(dateLayout), Count: 1},
+		}
+		if !reflect.DeepEqual(body.Batches, expBatches) {
+			t.Errorf("expected: %v, got: %v", expBatches, body.Batches)
+		}
+
+		// With purges in dry run mode, neither the janitor nor the endpoint
+		// deletes keys.
+		if got, exp := post(handler, "http://example.com/admin/purge").Code, http.StatusOK; got != exp {
+			t.Fatalf("expected: %v, got: %v", exp, got)
+		}
+		if got := repo.keyCount(); got != 4 {
+			t.Errorf("expected: 4 keys, got: %v", got)
+		}
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		repo := newRepo()
+		w := post(newHandler(repo, false), "http://example.com/admin/purge")
+		if got, exp := w.Code, http.StatusOK; got != exp {
+			t.Fatalf("expected: %v, got: %v", exp, got)
+		}
+		var run diag.JobRun
+		if err := json.NewDecoder(w.Body).Decode(&run); err != nil {
+			t.Fatal(err)
+		}
+		if run.Type != diag.JobPurge || run.Error != "" {
+			t.Errorf("unexpected run: %+v", run)
+		}
+		if got := repo.keyCount(); got != 1 {
+			t.Errorf("expected: 1 key, got: %v", got)
+		}
+	})
+
+	t.Run("purging disabled", func(t *testing.T) {
+		handler, err := NewHandler(context.Background(), Config{
+			Diag:       diag.Config{Repository: noopRepo, Logger: diag.NewNopLogger()},
+			AdminToken: "secret",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, target := range []string{"http://example.com/admin/purge", "http://example.com/admin/purge?dryRun=true"} {
+			if got, exp := post(handler, target).Code, http.StatusNotFound; got != exp {
+				t.Errorf("%v: expected: %v, got: %v", target, exp, got)
+			}
+		}
+	})
+}
+
+func TestRevocationDryRun(t *testing.T) {
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &testRevokingRepository{
+		testRepository: noopRepo,
+		revocations:    []diag.Revocation{{TemporaryExposureKey: [16]byte{1}, RevokedAt: time.Now()}},
+	}
+	handler, err := NewHandler(context.Background(), Config{
+		Diag:       diag.Config{Repository: repo, Logger: diag.NewNopLogger(), Signer: signingKey},
+		AdminToken: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	for _, key := range [][16]byte{{1}, {2}, {2}} {
+		body.Write(key[:])
+	}
+	req := httptest.NewRequest("POST", "http://example.com/admin/revocations?dryRun=true", &body)
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if got, exp := w.Code, http.StatusOK; got != exp {
+		t.Fatalf("expected: %v, got: %v", exp, got)
+	}
+
+	var report revocationReportResponse
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	exp := revocationReportResponse{
+		Revoked:        []string{"02000000000000000000000000000000"},
+		AlreadyRevoked: []string{"01000000000000000000000000000000"},
+	}
+	if !reflect.DeepEqual(report, exp) {
+		t.Errorf("expected: %+v, got: %+v", exp, report)
+	}
+	if len(repo.revocations) != 1 {
+		t.Errorf("expected no stored revocations, got: %v", repo.revocations)
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// parseUploadRegions returns the regions an upload is tagged with, from the
+// optional `regions` query parameter, e.g. `?regions=NL,BE`. If the parameter
+// is invalid, or regions aren't enabled, an error response is written and
+// false is returned.
+func (h *handler) parseUploadRegions(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	query := r.URL.Query()
+	if _, ok := query["regions"]; !ok {
+		return nil, true
+	}
+	if !h.regions {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRegionsParam, "Region tags aren't supported by this server.")
+		return nil, false
+	}
+	regions, err := diag.ParseRegions(query.Get("regions"))
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRegionsParam, fmt.Sprintf("Invalid `regions` query parameter: %v", err))
+		return nil, false
+	}
+	return regions, true
+}
+
+// listDiagnosisKeysRegion handles GET requests for region-scoped listings,
+// e.g. `/diagnosis-keys?region=NL`, which contain the Diagnosis Keys tagged
+// with that region on upload, ordered by upload. Region-scoped listings aren't
+// paginated.
+func (h *handler) listDiagnosisKeysRegion(w http.ResponseWriter, r *http.Request) {
+	if !h.regions {
+		writeNotFound(w)
+		return
+	}
+
+	query := r.URL.Query()
+	_, cursorSet := query["cursor"]
+	_, limitSet := query["limit"]
+	if cursorSet || limitSet || query.Get("after") != "" {
+		writeProblem(w, http.StatusBadRequest, codeConflictingParams, "The `region` query parameter can't be combined with `after`, `cursor` or `limit`.")
+		return
+	}
+
+	regions, err := diag.ParseRegions(query.Get("region"))
+	if err != nil || len(regions) != 1 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRegionParam, "Invalid `region` query parameter, must be a single region, e.g. `NL`.")
+		return
+	}
+	region := regions[0]
+
+	rs, err := h.diagSvc.ReadSeekerRegion(region)
+	if err != nil {
+		h.logger.Error("Could not list diagnosis keys of region", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", h.diagSvc.ETag("region="+region))
+	http.ServeContent(w, r, "", h.diagSvc.LastModified(), rs)
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+func newRegionsTestHandler(t *testing.T, repo testRepository, regions bool) http.Handler {
+	handler, err := NewHandler(context.Background(), Config{
+		Diag:    diag.Config{Repository: repo, Logger: diag.NewNopLogger()},
+		Regions: regions,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func TestPostDiagnosisKeysRegions(t *testing.T) {
+	var stored []diag.DiagnosisKey
+	repo := noopRepo
+	repo.storeDiagnosisKeysFn = func(_ context.Context, diagKeys []diag.DiagnosisKey, _ time.Time) error {
+		stored = diagKeys
+		return nil
+	}
+	var body bytes.Buffer
+	diag.WriteDiagnosisKeys(&body, diag.DiagnosisKey{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: uint32(time.Now().Unix() / 600)})
+
+	post := func(h http.Handler, target string) *http.Response {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", target, bytes.NewReader(body.Bytes())))
+		return w.Result()
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		resp := post(newRegionsTestHandler(t, repo, false), "http://example.com/diagnosis-keys?regions=NL")
+		if got := readProblem(t, resp).Code; got != codeInvalidRegionsParam {
+			t.Errorf("expected: %v, got: %v", codeInvalidRegionsParam, got)
+		}
+	})
+
+	handler := newRegionsTestHandler(t, repo, true)
+
+	t.Run("invalid", func(t *testing.T) {
+		resp := post(handler, "http://example.com/diagnosis-keys?regions=NL,N-L")
+		if got := readProblem(t, resp).Code; got != codeInvalidRegionsParam {
+			t.Errorf("expected: %v, got: %v", codeInvalidRegionsParam, got)
+		}
+	})
+
+	t.Run("tagged", func(t *testing.T) {
+		resp := post(handler, "http://example.com/diagnosis-keys?regions=nl,BE")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected: %v, got: %v", http.StatusOK, resp.StatusCode)
+		}
+		if len(stored) != 1 {
+			t.Fatalf("expected 1 stored key, got: %v", len(stored))
+		}
+		if exp := []string{"NL", "BE"}; !reflect.DeepEqual(stored[0].Regions, exp) {
+			t.Errorf("expected: %v, got: %v", exp, stored[0].Regions)
+		}
+	})
+}
+
+func TestListDiagnosisKeysRegion(t *testing.T) {
+	uploadedAt := time.Date(2020, time.May, 4, 12, 0, 0, 0, time.UTC)
+	diagKeys := []diag.DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, UploadedAt: uploadedAt, Regions: []string{"NL"}},
+		{TemporaryExposureKey: [16]byte{2}, UploadedAt: uploadedAt, Regions: []string{"BE"}},
+		{TemporaryExposureKey: [16]byte{3}, UploadedAt: uploadedAt, Regions: []string{"BE", "NL"}},
+	}
+	repo := testRepository{
+		findAllDiagnosisKeysFn: func(_ context.Context) ([]diag.DiagnosisKey, error) { return diagKeys, nil },
+		lastModifiedFn:         func(_ context.Context) (time.Time, error) { return uploadedAt, nil },
+	}
+
+	get := func(h http.Handler, target string) *http.Response {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		return w.Result()
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		resp := get(newRegionsTestHandler(t, repo, false), "http://example.com/diagnosis-keys?region=NL")
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected: %v, got: %v", http.StatusNotFound, resp.StatusCode)
+		}
+	})
+
+	handler := newRegionsTestHandler(t, repo, true)
+
+	t.Run("region", func(t *testing.T) {
+		resp := get(handler, "http://example.com/diagnosis-keys?region=nl")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected: %v, got: %v", http.StatusOK, resp.StatusCode)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		var got []byte
+		for i := 0; i < len(body); i += diag.DiagnosisKeySize {
+			got = append(got, body[i])
+		}
+		if exp := []byte{1, 3}; !bytes.Equal(got, exp) {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+
+		etag := get(handler, "http://example.com/diagnosis-keys?region=BE").Header.Get("ETag")
+		if etag == "" || etag == resp.Header.Get("ETag") {
+			t.Errorf("expected distinct ETags per region, got: %v", etag)
+		}
+	})
+
+	for target, code := range map[string]string{
+		"/diagnosis-keys?region=NL,BE":                                     codeInvalidRegionParam,
+		"/diagnosis-keys?region=":                                          codeInvalidRegionParam,
+		"/diagnosis-keys?region=NL&limit=2":                                codeConflictingParams,
+		"/diagnosis-keys?region=NL&after=01000000000000000000000000000000": codeConflictingParams,
+	} {
+		t.Run(target, func(t *testing.T) {
+			if got := readProblem(t, get(handler, "http://example.com"+target)).Code; got != code {
+				t.Errorf("expected: %v, got: %v", code, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+	"github.com/dstotijn/ct-diag-server/verification"
+)
+
+// verificationReportTypes are the report types of the `reportType` claim of
+// verification certificates.
+var verificationReportTypes = map[string]diag.ReportType{
+	"confirmed":   diag.ReportTypeConfirmedTest,
+	"likely":      diag.ReportTypeConfirmedClinicalDiagnosis,
+	"user-report": diag.ReportTypeSelfReport,
+}
+
+// uploadReport is the report type and symptom onset of an upload.
+type uploadReport struct {
+	reportType diag.ReportType
+	// symptomOnsetInterval is the Exposure Notification interval of the
+	// symptom onset, zero if unknown.
+	symptomOnsetInterval uint32
+}
+
+// parseUploadReport returns the report of an upload, from the optional
+// `reportType` (e.g. `confirmed_test`) and `symptomOnsetInterval` query
+// parameters. If a parameter is invalid, or report types aren't enabled, an
+// error response is written and false is returned.
+func (h *handler) parseUploadReport(w http.ResponseWriter, r *http.Request) (uploadReport, bool) {
+	var report uploadReport
+	query := r.URL.Query()
+	_, reportTypeSet := query["reportType"]
+	_, onsetSet := query["symptomOnsetInterval"]
+	if !reportTypeSet && !onsetSet {
+		return report, true
+	}
+	if !h.reportTypes {
+		writeProblem(w, http.StatusBadRequest, codeInvalidReportTypeParam, "Report types aren't supported by this server.")
+		return report, false
+	}
+
+	if reportTypeSet {
+		reportType, err := diag.ParseReportType(query.Get("reportType"))
+		if err != nil || reportType == diag.ReportTypeUnknown || reportType > diag.ReportTypeSelfReport {
+			writeProblem(w, http.StatusBadRequest, codeInvalidReportTypeParam,
+				"Invalid `reportType` query parameter, must be one of `confirmed_test`, `confirmed_clinical_diagnosis` and `self_report`.")
+			return report, false
+		}
+		report.reportType = reportType
+	}
+	if onsetSet {
+		interval, err := strconv.ParseUint(query.Get("symptomOnsetInterval"), 10, 32)
+		if err != nil || interval == 0 {
+			writeProblem(w, http.StatusBadRequest, codeInvalidSymptomOnsetParam,
+				fmt.Sprintf("Invalid `symptomOnsetInterval` query parameter (got: %q), must be an Exposure Notification interval number.", query.Get("symptomOnsetInterval")))
+			return report, false
+		}
+		report.symptomOnsetInterval = uint32(interval)
+	}
+
+	return report, true
+}
+
+// withClaims returns the report, with the report type and symptom onset of
+// the claims of a verification certificate, which take precedence.
+func (report uploadReport) withClaims(claims verification.Claims) uploadReport {
+	if reportType, ok := verificationReportTypes[claims.ReportType]; ok {
+		report.reportType = reportType
+	}
+	if claims.SymptomOnsetInterval > 0 {
+		report.symptomOnsetInterval = claims.SymptomOnsetInterval
+	}
+	return report
+}
+
+// apply sets the report type and days since onset of symptoms of diagKeys.
+// Keys with a rolling start too far from the symptom onset get no days since
+// onset of symptoms.
+func (report uploadReport) apply(diagKeys []diag.DiagnosisKey) {
+	for i := range diagKeys {
+		diagKeys[i].ReportType = report.reportType
+		if report.symptomOnsetInterval > 0 {
+			diagKeys[i].DaysSinceOnsetOfSymptoms = diag.DaysSinceOnsetOfSymptoms(diagKeys[i].RollingStartNumber, report.symptomOnsetInterval)
+		}
+	}
+}
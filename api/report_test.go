@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+func TestPostDiagnosisKeysReportTypes(t *testing.T) {
+	var stored []diag.DiagnosisKey
+	repo := noopRepo
+	repo.storeDiagnosisKeysFn = func(_ context.Context, diagKeys []diag.DiagnosisKey, _ time.Time) error {
+		stored = diagKeys
+		return nil
+	}
+	rollingStartNumber := uint32(time.Now().Unix()/600) / 144 * 144
+	var body bytes.Buffer
+	diag.WriteDiagnosisKeys(&body,
+		diag.DiagnosisKey{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: rollingStartNumber},
+		diag.DiagnosisKey{TemporaryExposureKey: [16]byte{2}, RollingStartNumber: rollingStartNumber - 144},
+	)
+
+	newHandler := func(reportTypes bool) http.Handler {
+		handler, err := NewHandler(context.Background(), Config{
+			Diag:        diag.Config{Repository: repo, Logger: diag.NewNopLogger()},
+			ReportTypes: reportTypes,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return handler
+	}
+	post := func(h http.Handler, target string) *http.Response {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", target, bytes.NewReader(body.Bytes())))
+		return w.Result()
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		resp := post(newHandler(false), "http://example.com/diagnosis-keys?reportType=confirmed_test")
+		if got := readProblem(t, resp).Code; got != codeInvalidReportTypeParam {
+			t.Errorf("expected: %v, got: %v", codeInvalidReportTypeParam, got)
+		}
+	})
+
+	handler := newHandler(true)
+
+	for target, code := range map[string]string{
+		"/diagnosis-keys?reportType=revoked":              codeInvalidReportTypeParam,
+		"/diagnosis-keys?reportType=positive":             codeInvalidReportTypeParam,
+		"/diagnosis-keys?symptomOnsetInterval=monday":     codeInvalidSymptomOnsetParam,
+		"/diagnosis-keys?symptomOnsetInterval=0":          codeInvalidSymptomOnsetParam,
+		"/diagnosis-keys?symptomOnsetInterval=4294967296": codeInvalidSymptomOnsetParam,
+	} {
+		t.Run(target, func(t *testing.T) {
+			if got := readProblem(t, post(handler, "http://example.com"+target)).Code; got != code {
+				t.Errorf("expected: %v, got: %v", code, got)
+			}
+		})
+	}
+
+	t.Run("report", func(t *testing.T) {
+		target := "http://example.com/diagnosis-keys?reportType=confirmed_test&symptomOnsetInterval=" + strconv.Itoa(int(rollingStartNumber-2*144))
+		resp := post(handler, target)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected: %v, got: %v", http.StatusOK, resp.StatusCode)
+		}
+		if len(stored) != 2 {
+			t.Fatalf("expected 2 stored keys, got: %v", len(stored))
+		}
+		for i, exp := range []int32{2, 1} {
+			if got := stored[i].ReportType; got != diag.ReportTypeConfirmedTest {
+				t.Errorf("key %v: expected: %v, got: %v", i, diag.ReportTypeConfirmedTest, got)
+			}
+			if got := stored[i].DaysSinceOnsetOfSymptoms; got == nil || *got != exp {
+				t.Errorf("key %v: expected: %v, got: %v", i, exp, got)
+			}
+		}
+	})
+}
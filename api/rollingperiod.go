@@ -0,0 +1,31 @@
+package api
+
+import (
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// uploadMediaTypes returns the supported `Content-Type` values of uploads.
+func (h *handler) uploadMediaTypes() []string {
+	if h.rollingPeriods {
+		return []string{mediaTypeBinary, mediaTypeExtendedKeys}
+	}
+	return []string{mediaTypeBinary}
+}
+
+// uploadFormat returns the size of a Diagnosis Key in the body of an upload,
+// and the func for parsing it: the extended binary representation (with
+// rolling periods) for `Content-Type: application/vnd.ct-diag.extended-keys`
+// if rolling periods are enabled, else the binary representation.
+func (h *handler) uploadFormat(r *http.Request) (keySize uint, parse func(io.Reader) ([]diag.DiagnosisKey, error)) {
+	if h.rollingPeriods {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err == nil && mediaType == mediaTypeExtendedKeys {
+			return diag.ExtendedDiagnosisKeySize, diag.ParseExtendedDiagnosisKeys
+		}
+	}
+	return diag.DiagnosisKeySize, diag.ParseDiagnosisKeys
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+func TestPostDiagnosisKeysRollingPeriods(t *testing.T) {
+	var stored []diag.DiagnosisKey
+	repo := noopRepo
+	repo.storeDiagnosisKeysFn = func(_ context.Context, diagKeys []diag.DiagnosisKey, _ time.Time) error {
+		stored = diagKeys
+		return nil
+	}
+	rollingStartNumber := uint32(time.Now().Unix()/600) / 144 * 144
+	var body bytes.Buffer
+	diag.WriteExtendedDiagnosisKeys(&body,
+		diag.DiagnosisKey{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: rollingStartNumber, RollingPeriod: 72},
+		diag.DiagnosisKey{TemporaryExposureKey: [16]byte{2}, RollingStartNumber: rollingStartNumber - 144},
+	)
+
+	newHandler := func(rollingPeriods bool) http.Handler {
+		handler, err := NewHandler(context.Background(), Config{
+			Diag:           diag.Config{Repository: repo, Logger: diag.NewNopLogger()},
+			RollingPeriods: rollingPeriods,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return handler
+	}
+	post := func(h http.Handler, b []byte) *http.Response {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "http://example.com/diagnosis-keys", bytes.NewReader(b))
+		req.Header.Set("Content-Type", mediaTypeExtendedKeys)
+		h.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		resp := post(newHandler(false), body.Bytes())
+		if got := readProblem(t, resp).Code; got != codeUnsupportedMediaType {
+			t.Errorf("expected: %v, got: %v", codeUnsupportedMediaType, got)
+		}
+	})
+
+	handler := newHandler(true)
+
+	t.Run("invalid rolling period", func(t *testing.T) {
+		invalid := append([]byte(nil), body.Bytes()...)
+		invalid[diag.DiagnosisKeySize] = 0
+		if got := readProblem(t, post(handler, invalid)).Code; got != codeInvalidBody {
+			t.Errorf("expected: %v, got: %v", codeInvalidBody, got)
+		}
+	})
+
+	t.Run("rolling periods", func(t *testing.T) {
+		resp := post(handler, body.Bytes())
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected: %v, got: %v", http.StatusOK, resp.StatusCode)
+		}
+		if len(stored) != 2 {
+			t.Fatalf("expected 2 stored keys, got: %v", len(stored))
+		}
+		for i, exp := range []uint8{72, diag.MaxRollingPeriod} {
+			if got := stored[i].RollingPeriod; got != exp {
+				t.Errorf("key %v: expected: %v, got: %v", i, exp, got)
+			}
+		}
+	})
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// Cache policies, used as `Cache-Control` header of successful GET and HEAD
+// responses. A handler can override the policy by setting the header itself.
+const (
+	cacheNone = ""
+	// cacheShort is for resources that change with every cache refresh.
+	cacheShort = "public, max-age=0, s-maxage=600"
+	// cacheLong is for resources that never change once published.
+	cacheLong  = "public, max-age=3600, s-maxage=86400"
+	cacheNever = "no-store"
+)
+
+// Media types of request bodies.
+const (
+	mediaTypeBinary = "application/octet-stream"
+	mediaTypeText   = "text/plain"
+	// mediaTypeExtendedKeys is the media type of uploads in the extended
+	// binary representation, see diag.ParseExtendedDiagnosisKeys.
+	mediaTypeExtendedKeys = "application/vnd.ct-diag.extended-keys"
+)
+
+// route describes an endpoint. Method checks, authentication, cache headers
+// and SLO tracking are applied uniformly by wrap, so handlers only deal with
+// requests they can serve.
+type route struct {
+	pattern string
+	// name identifies the endpoint in SLO reports. Routes without a name are
+	// not tracked.
+	name string
+	// methods are the allowed methods. HEAD is implied by GET.
+	methods []string
+	admin   bool
+	cache   string
+	handler http.HandlerFunc
+}
+
+// routes returns the route table of the handler.
+func (h *handler) routes(expConfigHandler http.HandlerFunc) []route {
+	get := []string{http.MethodGet}
+	post := []string{http.MethodPost}
+
+	return []route{
+		{pattern: "/diagnosis-keys", name: "/diagnosis-keys", methods: []string{http.MethodGet, http.MethodPost}, cache: cacheShort, handler: accepts(h.diagnosisKeys, h.uploadMediaTypes()...)},
+		{pattern: "/diagnosis-keys/", name: "/diagnosis-keys/{date}", methods: get, cache: cacheShort, handler: h.diagnosisKeysByTime},
+		{pattern: "/diagnosis-keys/last-modified", name: "/diagnosis-keys/last-modified", methods: get, cache: cacheShort, handler: h.lastModified},
+		{pattern: "/diagnosis-keys/index", name: "/diagnosis-keys/index", methods: get, cache: cacheShort, handler: h.batchIndex},
+		{pattern: "/diagnosis-keys/withdraw", name: "/diagnosis-keys/withdraw", methods: post, cache: cacheNone, handler: accepts(h.withdraw, mediaTypeText)},
+		{pattern: "/exposure-key-export/", name: "/exposure-key-export/{date}.zip", methods: get, cache: cacheLong, handler: h.exportByTime},
+		{pattern: "/metadata", name: "/metadata", methods: get, cache: cacheShort, handler: h.metadata},
+		{pattern: "/exposure-config", name: "/exposure-config", methods: []string{http.MethodGet, http.MethodPut}, cache: cacheNone, handler: accepts(expConfigHandler, "application/json")},
+		{pattern: "/revocations", name: "/revocations", methods: get, cache: cacheShort, handler: h.revocations},
+		{pattern: "/transparency/sth", name: "/transparency/sth", methods: get, cache: cacheShort, handler: h.treeHead},
+		{pattern: "/transparency/inclusion", name: "/transparency/inclusion", methods: get, cache: cacheNone, handler: h.inclusionProof},
+		{pattern: "/transparency/consistency", name: "/transparency/consistency", methods: get, cache: cacheNone, handler: h.consistencyProof},
+		{pattern: "/transparency/leaves", name: "/transparency/leaves", methods: get, cache: cacheShort, handler: h.leaves},
+		{pattern: "/federation/diagnosis-keys", methods: post, cache: cacheNone, handler: accepts(h.postFederatedKeys, mediaTypeBinary)},
+		{pattern: "/health", methods: get, cache: cacheNone, handler: h.health},
+		{pattern: "/health/live", methods: get, cache: cacheNever, handler: h.liveness},
+		{pattern: "/health/ready", methods: get, cache: cacheNever, handler: h.readiness},
+		{pattern: "/time", methods: get, cache: cacheNever, handler: h.serverTime},
+		{pattern: "/openapi.json", methods: get, cache: cacheShort, handler: h.openAPIJSON},
+		{pattern: "/admin/slo", methods: get, admin: true, cache: cacheNever, handler: h.slo},
+		{pattern: "/admin/metrics/export", methods: get, admin: true, cache: cacheNever, handler: h.exportMetrics},
+		{pattern: "/admin/revocations", methods: post, admin: true, cache: cacheNone, handler: accepts(h.postRevocations, mediaTypeBinary)},
+		{pattern: "/admin/cache/refresh", methods: post, admin: true, cache: cacheNone, handler: h.refreshCache},
+		{pattern: "/admin/purge", methods: post, admin: true, cache: cacheNone, handler: h.purge},
+		{pattern: "/admin/status", methods: get, admin: true, cache: cacheNever, handler: h.status},
+		{pattern: "/admin/jobs", methods: get, admin: true, cache: cacheNever, handler: h.jobRuns},
+		{pattern: "/admin/jobs/retry", methods: post, admin: true, cache: cacheNone, handler: h.retryJob},
+		{pattern: "/admin/keys/", methods: get, admin: true, cache: cacheNever, handler: h.diagnosisKeyByTEK},
+		{pattern: "/admin/diagnosis-keys/stream", methods: get, admin: true, cache: cacheNever, handler: h.streamDiagnosisKeys},
+		{pattern: "/admin/exposure-config/history", methods: get, admin: true, cache: cacheNever, handler: h.exposureConfigHistory},
+		{pattern: "/admin/federation/status", methods: get, admin: true, cache: cacheNever, handler: h.federationStatus},
+		{pattern: "/admin/federation/pull", methods: post, admin: true, cache: cacheNone, handler: h.pullFederation},
+		{pattern: "/admin/federation/push", methods: post, admin: true, cache: cacheNone, handler: h.pushFederation},
+	}
+}
+
+// wrap returns the handler of rt, with its method checks, authentication,
+// cache policy and SLO tracking applied.
+func (h *handler) wrap(rt route) http.HandlerFunc {
+	allowed := make(map[string]bool)
+	for _, method := range rt.methods {
+		allowed[method] = true
+		if method == http.MethodGet {
+			allowed[http.MethodHead] = true
+		}
+	}
+	allow := strings.Join(rt.methods, ", ")
+	if allowed[http.MethodHead] {
+		allow += ", " + http.MethodHead
+	}
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		if !allowed[r.Method] {
+			w.Header().Set("Allow", allow)
+			writeProblem(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "")
+			return
+		}
+		if rt.cache != cacheNone && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			w = &cacheWriter{ResponseWriter: w, policy: rt.cache}
+		}
+		rt.handler(w, r)
+	}
+	// Named routes serve published data, as do most admin routes, so
+	// request-driven handlers hydrate the cache on demand first.
+	if h.requestDriven && (rt.name != "" || rt.admin) {
+		next = h.hydrating(next)
+	}
+	if rt.admin {
+		next = h.requireAdmin(next)
+	}
+	if rt.name != "" {
+		next = h.slos.instrument(rt.name, next)
+	}
+	next = instrument(rt.pattern, next)
+
+	return next
+}
+
+// hydrating returns next, with the cache hydrated (or refreshed, if due)
+// before each request, see diag.Service.HydrateIfStale.
+func (h *handler) hydrating(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h.diagSvc.HydrateIfStale(r.Context()); err != nil {
+			h.logger.Error("Could not hydrate cache", diag.Err(err))
+			writeInternalErrorResp(w, err)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// accepts returns next, with POST and PUT requests whose `Content-Type` is not
+// one of types rejected with `415 Unsupported Media Type` before the body is
+// read. The supported types are advertised in the `Accept` response header.
+// Requests without `Content-Type` are let through, as clients never needed to
+// set it.
+func accepts(next http.HandlerFunc, types ...string) http.HandlerFunc {
+	supported := strings.Join(types, ", ")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		contentType := r.Header.Get("Content-Type")
+		if (r.Method != http.MethodPost && r.Method != http.MethodPut) || contentType == "" {
+			next(w, r)
+			return
+		}
+
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err == nil {
+			for _, t := range types {
+				if mediaType == t {
+					next(w, r)
+					return
+				}
+			}
+		}
+
+		w.Header().Set("Accept", supported)
+		msg := fmt.Sprintf("Unsupported `Content-Type` %q, must be one of: %v.", contentType, supported)
+		writeProblem(w, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, msg)
+	}
+}
+
+// cacheWriter is an http.ResponseWriter that sets the `Cache-Control` header
+// of successful responses, unless the handler already set it. Errors are
+// never cached.
+type cacheWriter struct {
+	http.ResponseWriter
+	policy      string
+	wroteHeader bool
+}
+
+func (cw *cacheWriter) WriteHeader(code int) {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		h := cw.Header()
+		if (code < 300 || code == http.StatusNotModified) && h.Get("Cache-Control") == "" {
+			h.Set("Cache-Control", cw.policy)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *cacheWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush implements http.Flusher, for streaming responses.
+func (cw *cacheWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+func TestRoutes(t *testing.T) {
+	h := &handler{slos: newSLOTracker(0), adminToken: "s3cret"}
+	ok := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("OK")) }
+
+	for _, rt := range h.routes(ok) {
+		rt.handler = ok
+		handler := h.wrap(rt)
+
+		t.Run(rt.pattern, func(t *testing.T) {
+			// Every route rejects methods it doesn't allow.
+			req := httptest.NewRequest("DELETE", "http://example.com"+rt.pattern, nil)
+			req.Header.Set("Authorization", "Bearer s3cret")
+			w := httptest.NewRecorder()
+			handler(w, req)
+			if got, exp := w.Code, http.StatusMethodNotAllowed; got != exp {
+				t.Errorf("expected: %v, got: %v", exp, got)
+			}
+			if w.Header().Get("Allow") == "" {
+				t.Error("expected `Allow` header")
+			}
+
+			// Admin routes require the admin token.
+			if rt.admin {
+				w := httptest.NewRecorder()
+				handler(w, httptest.NewRequest(rt.methods[0], "http://example.com"+rt.pattern, nil))
+				if got, exp := w.Code, http.StatusUnauthorized; got != exp {
+					t.Errorf("expected: %v, got: %v", exp, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRouteCachePolicy(t *testing.T) {
+	h := &handler{slos: newSLOTracker(0)}
+
+	tests := []struct {
+		name     string
+		method   string
+		handler  http.HandlerFunc
+		expCache string
+	}{
+		{
+			name:     "success",
+			method:   "GET",
+			handler:  func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("OK")) },
+			expCache: cacheShort,
+		},
+		{
+			name:   "error",
+			method: "GET",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				writeInternalErrorResp(w, nil)
+			},
+			expCache: "",
+		},
+		{
+			name:   "override",
+			method: "GET",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Cache-Control", cacheLong)
+				w.Write([]byte("OK"))
+			},
+			expCache: cacheLong,
+		},
+		{
+			name:     "post",
+			method:   "POST",
+			handler:  func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("OK")) },
+			expCache: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := h.wrap(route{
+				pattern: "/foo",
+				methods: []string{http.MethodGet, http.MethodPost},
+				cache:   cacheShort,
+				handler: tt.handler,
+			})
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(tt.method, "http://example.com/foo", nil))
+			if got := w.Header().Get("Cache-Control"); got != tt.expCache {
+				t.Errorf("expected: %q, got: %q", tt.expCache, got)
+			}
+		})
+	}
+}
+
+func TestRoutesHead(t *testing.T) {
+	handler, err := NewHandler(context.Background(), Config{Diag: diag.Config{Repository: noopRepo, Logger: diag.NewNopLogger()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("HEAD", "http://example.com/diagnosis-keys", nil))
+	if got, exp := w.Code, http.StatusOK; got != exp {
+		t.Errorf("expected: %v, got: %v", exp, got)
+	}
+	if got, exp := w.Header().Get("Cache-Control"), cacheShort; got != exp {
+		t.Errorf("expected: %v, got: %v", exp, got)
+	}
+}
+
+func TestAccepts(t *testing.T) {
+	handler := accepts(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("OK")) }, mediaTypeBinary)
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		expCode     int
+	}{
+		{name: "supported", method: "POST", contentType: "application/octet-stream", expCode: http.StatusOK},
+		{name: "supported, with parameters", method: "POST", contentType: "Application/Octet-Stream; foo=bar", expCode: http.StatusOK},
+		{name: "missing", method: "POST", expCode: http.StatusOK},
+		{name: "unsupported", method: "POST", contentType: "application/x-protobuf", expCode: http.StatusUnsupportedMediaType},
+		{name: "invalid", method: "POST", contentType: "application/", expCode: http.StatusUnsupportedMediaType},
+		{name: "unsupported, GET", method: "GET", contentType: "application/x-protobuf", expCode: http.StatusOK},
+		{name: "unsupported, PUT", method: "PUT", contentType: "application/x-protobuf", expCode: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "http://example.com/diagnosis-keys", nil)
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+
+			if got := w.Code; got != tt.expCode {
+				t.Errorf("expected: %v, got: %v", tt.expCode, got)
+			}
+			if tt.expCode == http.StatusUnsupportedMediaType {
+				if got, exp := w.Header().Get("Accept"), mediaTypeBinary; got != exp {
+					t.Errorf("expected: %v, got: %v", exp, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestDriven(t *testing.T) {
+	var calls int32
+	repo := testRepository{
+		storeDiagnosisKeysFn: noopRepo.storeDiagnosisKeysFn,
+		findAllDiagnosisKeysFn: func(_ context.Context) ([]diag.DiagnosisKey, error) {
+			atomic.AddInt32(&calls, 1)
+			return []diag.DiagnosisKey{{TemporaryExposureKey: [16]byte{1}, UploadedAt: time.Now()}}, nil
+		},
+		lastModifiedFn: noopRepo.lastModifiedFn,
+	}
+	handler, err := NewHandler(context.Background(), Config{
+		Diag:          diag.Config{Repository: repo, Logger: diag.NewNopLogger()},
+		RequestDriven: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com"+path, nil))
+		return w
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 0 {
+		t.Fatalf("expected no hydration on startup, got: %v", got)
+	}
+	if got, exp := get("/health/ready").Code, http.StatusOK; got != exp {
+		t.Errorf("expected: %v, got: %v", exp, got)
+	}
+	if got := atomic.LoadInt32(&calls); got != 0 {
+		t.Errorf("expected no hydration for health checks, got: %v", got)
+	}
+
+	for i := 0; i < 2; i++ {
+		w := get("/diagnosis-keys")
+		body, _ := ioutil.ReadAll(w.Body)
+		if len(body) != diag.DiagnosisKeySize {
+			t.Errorf("expected: %v, got: %v", diag.DiagnosisKeySize, len(body))
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("expected: 1, got: %v", got)
+	}
+
+	t.Run("webhooks", func(t *testing.T) {
+		_, err := NewHandler(context.Background(), Config{
+			Diag:          diag.Config{Repository: repo, Logger: diag.NewNopLogger()},
+			RequestDriven: true,
+			SLOWebhookURL: "https://example.com/slo",
+		})
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+const (
+	defaultSLOWindow          = time.Hour
+	maxSLOSamples             = 10000
+	sloWebhookTimeout         = 10 * time.Second
+	defaultSLOWebhookInterval = time.Minute
+)
+
+// sloTracker tracks success rates and latencies of requests per endpoint, over
+// a rolling time window.
+type sloTracker struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu        sync.Mutex
+	endpoints map[string]*sloSamples
+}
+
+// sloSamples is a ring buffer of request samples.
+type sloSamples struct {
+	samples []sloSample
+	next    int
+}
+
+type sloSample struct {
+	at       time.Time
+	duration time.Duration
+	ok       bool
+}
+
+// sloReport represents the SLO status of all endpoints.
+type sloReport struct {
+	GeneratedAt time.Time              `json:"generatedAt"`
+	Window      string                 `json:"window"`
+	Endpoints   map[string]endpointSLO `json:"endpoints"`
+}
+
+// endpointSLO represents the SLO status of an endpoint. Requests resulting in
+// a 5xx status code are regarded as unsuccessful.
+type endpointSLO struct {
+	Requests     int     `json:"requests"`
+	SuccessRate  float64 `json:"successRate"`
+	LatencyP50Ms float64 `json:"latencyP50Ms"`
+	LatencyP90Ms float64 `json:"latencyP90Ms"`
+	LatencyP99Ms float64 `json:"latencyP99Ms"`
+}
+
+func newSLOTracker(window time.Duration) *sloTracker {
+	if window == 0 {
+		window = defaultSLOWindow
+	}
+	return &sloTracker{
+		window:    window,
+		now:       time.Now,
+		endpoints: make(map[string]*sloSamples),
+	}
+}
+
+// instrument wraps next, recording a sample for every request. Samples are
+// grouped by request method and the given endpoint name.
+func (t *sloTracker) instrument(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := t.now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		t.record(r.Method+" "+endpoint, t.now().Sub(start), rec.status < 500)
+	}
+}
+
+func (t *sloTracker) record(endpoint string, d time.Duration, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, found := t.endpoints[endpoint]
+	if !found {
+		s = &sloSamples{}
+		t.endpoints[endpoint] = s
+	}
+
+	sample := sloSample{at: t.now(), duration: d, ok: ok}
+	if len(s.samples) < maxSLOSamples {
+		s.samples = append(s.samples, sample)
+		return
+	}
+	s.samples[s.next] = sample
+	s.next = (s.next + 1) % maxSLOSamples
+}
+
+// report computes the SLO status of all endpoints for the samples within the
+// rolling window.
+func (t *sloTracker) report() sloReport {
+	now := t.now()
+	cutoff := now.Add(-t.window)
+	report := sloReport{
+		GeneratedAt: now.UTC(),
+		Window:      t.window.String(),
+		Endpoints:   make(map[string]endpointSLO),
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for endpoint, s := range t.endpoints {
+		var durations []time.Duration
+		var okCount int
+		for _, sample := range s.samples {
+			if sample.at.Before(cutoff) {
+				continue
+			}
+			durations = append(durations, sample.duration)
+			if sample.ok {
+				okCount++
+			}
+		}
+		if len(durations) == 0 {
+			continue
+		}
+
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		report.Endpoints[endpoint] = endpointSLO{
+			Requests:     len(durations),
+			SuccessRate:  float64(okCount) / float64(len(durations)),
+			LatencyP50Ms: percentileMs(durations, 0.50),
+			LatencyP90Ms: percentileMs(durations, 0.90),
+			LatencyP99Ms: percentileMs(durations, 0.99),
+		}
+	}
+
+	return report
+}
+
+// percentileMs returns the p-th percentile (nearest rank) of sorted durations,
+// in milliseconds.
+func percentileMs(sorted []time.Duration, p float64) float64 {
+	i := int(float64(len(sorted))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+// pushReports periodically POSTs the SLO report as JSON to url, until ctx is
+// done.
+func (t *sloTracker) pushReports(ctx context.Context, url string, interval time.Duration, logger diag.Logger) {
+	if interval == 0 {
+		interval = defaultSLOWebhookInterval
+	}
+	client := &http.Client{Timeout: sloWebhookTimeout}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := postJSON(ctx, client, url, t.report()); err != nil {
+				logger.Error("Could not push SLO report.", diag.Err(err))
+			}
+		}
+	}
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status: %v", resp.Status)
+	}
+
+	return nil
+}
+
+// slo writes the SLO report in JSON.
+func (h *handler) slo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.slos.report())
+}
+
+// statusRecorder is an http.ResponseWriter that records the status code.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+// Flush implements http.Flusher, for streaming responses.
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
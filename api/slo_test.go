@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+func TestSLO(t *testing.T) {
+	newHandler := func(t *testing.T, adminToken string) http.Handler {
+		handler, err := NewHandler(context.Background(), Config{
+			Diag:       diag.Config{Repository: noopRepo, Logger: diag.NewNopLogger()},
+			AdminToken: adminToken,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return handler
+	}
+
+	t.Run("admin endpoints disabled", func(t *testing.T) {
+		handler := newHandler(t, "")
+		req := httptest.NewRequest("GET", "http://example.com/admin/slo", nil)
+		req.Header.Set("Authorization", "Bearer ")
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		if got := w.Result().StatusCode; got != http.StatusNotFound {
+			t.Errorf("expected: %v, got: %v", http.StatusNotFound, got)
+		}
+	})
+
+	t.Run("invalid admin token", func(t *testing.T) {
+		handler := newHandler(t, "s3cret")
+		req := httptest.NewRequest("GET", "http://example.com/admin/slo", nil)
+		req.Header.Set("Authorization", "Bearer foobar")
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		if got := w.Result().StatusCode; got != http.StatusUnauthorized {
+			t.Errorf("expected: %v, got: %v", http.StatusUnauthorized, got)
+		}
+	})
+
+	t.Run("report", func(t *testing.T) {
+		handler := newHandler(t, "s3cret")
+
+		for i := 0; i < 3; i++ {
+			req := httptest.NewRequest("GET", "http://example.com/diagnosis-keys", nil)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+		}
+
+		req := httptest.NewRequest("GET", "http://example.com/admin/slo", nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+		resp := w.Result()
+
+		if got := resp.StatusCode; got != http.StatusOK {
+			t.Fatalf("expected: %v, got: %v", http.StatusOK, got)
+		}
+
+		var report sloReport
+		if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+
+		got, ok := report.Endpoints["GET /diagnosis-keys"]
+		if !ok {
+			t.Fatalf("expected report for `GET /diagnosis-keys`, got: %+v", report.Endpoints)
+		}
+		if got.Requests != 3 || got.SuccessRate != 1 {
+			t.Errorf("expected: 3 successful requests, got: %+v", got)
+		}
+	})
+}
+
+func TestSLOTrackerReport(t *testing.T) {
+	now := time.Date(2020, time.May, 4, 12, 0, 0, 0, time.UTC)
+	tracker := newSLOTracker(time.Hour)
+	tracker.now = func() time.Time { return now }
+
+	// Outside of the rolling window.
+	tracker.record("GET /foo", time.Second, false)
+	now = now.Add(2 * time.Hour)
+
+	for i := 1; i <= 100; i++ {
+		tracker.record("GET /foo", time.Duration(i)*time.Millisecond, i%10 != 0)
+	}
+
+	exp := endpointSLO{
+		Requests:     100,
+		SuccessRate:  0.9,
+		LatencyP50Ms: 50,
+		LatencyP90Ms: 90,
+		LatencyP99Ms: 99,
+	}
+	if got := tracker.report().Endpoints["GET /foo"]; got != exp {
+		t.Errorf("expected: %+v, got: %+v", exp, got)
+	}
+}
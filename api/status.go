@@ -0,0 +1,49 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/assets"
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// statusPage is the data of the operator status page.
+type statusPage struct {
+	GeneratedAt time.Time
+	Status      diag.Status
+	SLO         sloReport
+	Errors      []diag.LogEntry
+}
+
+// status renders the operator status page in HTML, for at-a-glance visibility
+// without a monitoring stack. Recent errors are only listed if an error log
+// is configured.
+func (h *handler) status(w http.ResponseWriter, r *http.Request) {
+	status, err := h.diagSvc.Status(r.Context())
+	if err != nil {
+		h.logger.Error("Could not get status", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	page := statusPage{
+		GeneratedAt: time.Now(),
+		Status:      status,
+		SLO:         h.slos.report(),
+	}
+	if h.errorLog != nil {
+		page.Errors = h.errorLog.Entries()
+	}
+
+	buf := &bytes.Buffer{}
+	if err := assets.StatusTemplate.Execute(buf, page); err != nil {
+		h.logger.Error("Could not render status page", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
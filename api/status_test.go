@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+func TestStatusPage(t *testing.T) {
+	diagKeys := []diag.DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, UploadedAt: time.Date(2020, time.May, 4, 12, 0, 0, 0, time.UTC)},
+		{TemporaryExposureKey: [16]byte{2}, UploadedAt: time.Date(2020, time.May, 4, 13, 0, 0, 0, time.UTC)},
+	}
+	errorLog := diag.NewErrorLog(diag.NewNopLogger(), 10)
+	handler, err := NewHandler(context.Background(), Config{
+		Diag: diag.Config{
+			Repository: testRepository{
+				findAllDiagnosisKeysFn: func(_ context.Context) ([]diag.DiagnosisKey, error) { return diagKeys, nil },
+				lastModifiedFn:         func(_ context.Context) (time.Time, error) { return diagKeys[1].UploadedAt, nil },
+			},
+			Logger: errorLog,
+		},
+		AdminToken: "s3cret",
+		ErrorLog:   errorLog,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errorLog.Error("Could not refresh cache", diag.Err(errors.New("database is <down>")))
+
+	req := httptest.NewRequest("GET", "http://example.com/admin/status", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+	resp := w.Result()
+
+	expStatusCode := 200
+	if got := resp.StatusCode; got != expStatusCode {
+		t.Fatalf("expected: %v, got: %v", expStatusCode, got)
+	}
+	if got, exp := resp.Header.Get("Content-Type"), "text/html; charset=utf-8"; got != exp {
+		t.Errorf("expected: %v, got: %v", exp, got)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, exp := range []string{
+		"<tr><th>Cached keys</th><td>2</td></tr>",
+		"<tr><th>Stored keys (estimate)</th><td>unknown</td></tr>",
+		"<tr><th>Last modified</th><td>2020-05-04T13:00:00Z</td></tr>",
+		"Could not refresh cache",
+		"error: database is &lt;down&gt;",
+	} {
+		if !strings.Contains(string(body), exp) {
+			t.Errorf("expected body to contain: %v", exp)
+		}
+	}
+
+	// The status page requires the admin token.
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/admin/status", nil))
+	if got := w.Result().StatusCode; got != 401 {
+		t.Errorf("expected: %v, got: %v", 401, got)
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// mediaTypeKeyStream is the media type of key streams, see
+// diag.WriteKeyStream.
+const mediaTypeKeyStream = "application/vnd.ct-diag.key-stream"
+
+const (
+	defaultStreamHeartbeat = 15 * time.Second
+	// streamPageSize is the amount of keys written (and flushed) at once.
+	streamPageSize = 10000
+)
+
+// streamDiagnosisKeys writes all published Diagnosis Keys as a key stream,
+// flushed after every page of keys. Heartbeats are written while the stream
+// is idle, e.g. because the client reads slowly. The amount of keys is sent
+// as `X-Key-Count` trailer, so clients can tell a complete stream from a
+// broken connection.
+func (h *handler) streamDiagnosisKeys(w http.ResponseWriter, r *http.Request) {
+	if _, ok := w.(http.Flusher); !ok {
+		writeInternalErrorResp(w, errors.New("api: response writer doesn't support flushing"))
+		return
+	}
+	w.Header().Set("Content-Type", mediaTypeKeyStream)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Trailer", "X-Key-Count")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	sw := &streamWriter{w: w}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sw.heartbeat(h.streamHeartbeat, done)
+	}()
+	n, err := h.diagSvc.WriteKeyStream(r.Context(), sw, streamPageSize, sw.flush)
+	close(done)
+	wg.Wait()
+
+	if err != nil {
+		// The status code is sent already, so the connection is broken off
+		// without trailer instead.
+		if r.Context().Err() == nil {
+			h.logger.Error("Could not write key stream", diag.Err(err))
+		}
+		panic(http.ErrAbortHandler)
+	}
+	w.Header().Set("X-Key-Count", strconv.Itoa(n))
+}
+
+// streamWriter writes a key stream to w, interleaved with heartbeats.
+type streamWriter struct {
+	mu sync.Mutex
+	w  http.ResponseWriter
+	// idle is unset by writes, so heartbeats are only sent while the stream
+	// is idle.
+	idle bool
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.idle = false
+	return sw.w.Write(p)
+}
+
+func (sw *streamWriter) flush() error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.w.(http.Flusher).Flush()
+	return nil
+}
+
+// heartbeat writes a heartbeat every interval without other writes, until
+// done is closed.
+func (sw *streamWriter) heartbeat(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		sw.mu.Lock()
+		if sw.idle {
+			sw.w.Write(diag.KeyStreamHeartbeat)
+			sw.w.(http.Flusher).Flush()
+		}
+		sw.idle = true
+		sw.mu.Unlock()
+	}
+}
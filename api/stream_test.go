@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+func TestStreamDiagnosisKeys(t *testing.T) {
+	diagKeys := []diag.DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: 42, UploadedAt: time.Date(2020, time.May, 4, 12, 0, 0, 0, time.UTC)},
+		{TemporaryExposureKey: [16]byte{2}, RollingStartNumber: 43, UploadedAt: time.Date(2020, time.May, 4, 13, 0, 0, 0, time.UTC)},
+	}
+	repo := testRepository{
+		findAllDiagnosisKeysFn: func(context.Context) ([]diag.DiagnosisKey, error) { return diagKeys, nil },
+		lastModifiedFn:         func(context.Context) (time.Time, error) { return diagKeys[1].UploadedAt, nil },
+	}
+	handler, err := NewHandler(context.Background(), Config{
+		Diag:       diag.Config{Repository: repo, Logger: diag.NewNopLogger()},
+		AdminToken: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	get := func(token string) *http.Response {
+		req, _ := http.NewRequest("GET", srv.URL+"/admin/diagnosis-keys/stream", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := get("secret")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected: %v, got: %v", http.StatusOK, resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Type"); got != mediaTypeKeyStream {
+		t.Errorf("expected: %v, got: %v", mediaTypeKeyStream, got)
+	}
+	var got []byte
+	err = diag.ReadKeyStream(resp.Body, func(diagKey diag.DiagnosisKey) error {
+		got = append(got, diagKey.TemporaryExposureKey[0])
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "\x01\x02" {
+		t.Errorf("expected keys 1 and 2, got: %v", got)
+	}
+	if got := resp.Trailer.Get("X-Key-Count"); got != "2" {
+		t.Errorf("expected: 2, got: %q", got)
+	}
+
+	unauthorized := get("foobar")
+	unauthorized.Body.Close()
+	if unauthorized.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected: %v, got: %v", http.StatusUnauthorized, unauthorized.StatusCode)
+	}
+}
+
+func TestStreamWriterHeartbeat(t *testing.T) {
+	w := httptest.NewRecorder()
+	sw := &streamWriter{w: w}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		sw.heartbeat(time.Millisecond, done)
+		close(stopped)
+	}()
+
+	for deadline := time.Now().Add(time.Second); ; {
+		sw.mu.Lock()
+		n := w.Body.Len()
+		sw.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected heartbeat")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(done)
+	<-stopped
+
+	for _, b := range w.Body.Bytes() {
+		if b != diag.KeyStreamHeartbeat[0] {
+			t.Fatalf("expected only heartbeats, got: %v", w.Body.Bytes())
+		}
+	}
+	if !w.Flushed {
+		t.Error("expected heartbeats to be flushed")
+	}
+}
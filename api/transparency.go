@@ -0,0 +1,176 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+	"github.com/dstotijn/ct-diag-server/merkle"
+)
+
+// treeHeadResponse is the JSON representation of a signed tree head. Byte
+// slices are base64 encoded.
+type treeHeadResponse struct {
+	TreeSize  uint64 `json:"treeSize"`
+	Timestamp int64  `json:"timestamp"`
+	RootHash  []byte `json:"rootHash"`
+	Signature []byte `json:"signature"`
+}
+
+// inclusionProofResponse is the JSON representation of an inclusion proof.
+type inclusionProofResponse struct {
+	LeafIndex uint64   `json:"leafIndex"`
+	TreeSize  uint64   `json:"treeSize"`
+	AuditPath [][]byte `json:"auditPath"`
+}
+
+// consistencyProofResponse is the JSON representation of a consistency proof.
+type consistencyProofResponse struct {
+	First  uint64   `json:"first"`
+	Second uint64   `json:"second"`
+	Proof  [][]byte `json:"proof"`
+}
+
+// treeHead writes the latest signed tree head of the transparency log in JSON.
+func (h *handler) treeHead(w http.ResponseWriter, r *http.Request) {
+	head, err := h.diagSvc.TreeHead()
+	if err == diag.ErrTransparencyUnsupported {
+		writeNotFound(w)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Could not get tree head", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	writeJSON(w, treeHeadResponse{
+		TreeSize:  head.TreeSize,
+		Timestamp: head.Timestamp.UnixNano() / int64(time.Millisecond),
+		RootHash:  head.RootHash[:],
+		Signature: head.Signature,
+	})
+}
+
+// inclusionProof writes the proof of inclusion of the key given by the `key`
+// query parameter, in the tree of size `treeSize` (default: latest), in JSON.
+func (h *handler) inclusionProof(w http.ResponseWriter, r *http.Request) {
+	var key [16]byte
+	buf, err := hex.DecodeString(r.URL.Query().Get("key"))
+	if err != nil || len(buf) != 16 {
+		msg := "Invalid `key` query parameter, must be the hexadecimal encoding of a 16 byte key."
+		writeProblem(w, http.StatusBadRequest, codeInvalidKeyParam, msg)
+		return
+	}
+	copy(key[:], buf)
+
+	var treeSize uint64
+	if v := r.URL.Query().Get("treeSize"); v != "" {
+		treeSize, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, codeInvalidTreeSize, "Invalid `treeSize` query parameter.")
+			return
+		}
+	}
+
+	proof, err := h.diagSvc.InclusionProof(key, treeSize)
+	switch err {
+	case nil:
+	case diag.ErrTransparencyUnsupported, diag.ErrKeyNotFound:
+		writeNotFound(w)
+		return
+	case diag.ErrInvalidTreeSize:
+		writeProblem(w, http.StatusBadRequest, codeInvalidTreeSize, "Invalid `treeSize` query parameter, exceeds size of the log.")
+		return
+	default:
+		h.logger.Error("Could not get inclusion proof", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	writeJSON(w, inclusionProofResponse{
+		LeafIndex: proof.LeafIndex,
+		TreeSize:  proof.TreeSize,
+		AuditPath: hashesToBytes(proof.AuditPath),
+	})
+}
+
+// consistencyProof writes the proof that the tree of size `first` is a prefix
+// of the tree of size `second` (default: latest), in JSON.
+func (h *handler) consistencyProof(w http.ResponseWriter, r *http.Request) {
+	first, err := strconv.ParseUint(r.URL.Query().Get("first"), 10, 64)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidTreeSize, "Invalid `first` query parameter.")
+		return
+	}
+
+	var second uint64
+	if v := r.URL.Query().Get("second"); v != "" {
+		second, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, codeInvalidTreeSize, "Invalid `second` query parameter.")
+			return
+		}
+	}
+
+	proof, err := h.diagSvc.ConsistencyProof(first, second)
+	switch err {
+	case nil:
+	case diag.ErrTransparencyUnsupported:
+		writeNotFound(w)
+		return
+	case diag.ErrInvalidTreeSize:
+		writeProblem(w, http.StatusBadRequest, codeInvalidTreeSize, "Invalid tree sizes, must satisfy `0 < first <= second <= size of the log`.")
+		return
+	default:
+		h.logger.Error("Could not get consistency proof", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	// Proofs between fixed tree sizes never change.
+	if second != 0 {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+	writeJSON(w, consistencyProofResponse{
+		First:  proof.First,
+		Second: proof.Second,
+		Proof:  hashesToBytes(proof.Proof),
+	})
+}
+
+// leaves writes the leaves of the transparency log as binary data in the
+// HTTP response, for monitors to verify tree heads.
+func (h *handler) leaves(w http.ResponseWriter, r *http.Request) {
+	rs, err := h.diagSvc.Leaves()
+	if err == diag.ErrTransparencyUnsupported {
+		writeNotFound(w)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Could not get transparency log leaves", diag.Err(err))
+		writeInternalErrorResp(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	http.ServeContent(w, r, "", time.Time{}, rs)
+}
+
+func hashesToBytes(hashes []merkle.Hash) [][]byte {
+	bufs := make([][]byte, len(hashes))
+	for i := range hashes {
+		bufs[i] = hashes[i][:]
+	}
+	return bufs
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
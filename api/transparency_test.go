@@ -0,0 +1,192 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+	"github.com/dstotijn/ct-diag-server/merkle"
+)
+
+func TestTransparencyLog(t *testing.T) {
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diagKeys := []diag.DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: 42, UploadedAt: time.Date(2020, time.May, 4, 12, 0, 0, 0, time.UTC)},
+		{TemporaryExposureKey: [16]byte{2}, RollingStartNumber: 43, UploadedAt: time.Date(2020, time.May, 4, 13, 0, 0, 0, time.UTC)},
+		{TemporaryExposureKey: [16]byte{3}, RollingStartNumber: 44, UploadedAt: time.Date(2020, time.May, 4, 14, 0, 0, 0, time.UTC)},
+		// Not yet settled, so not included in the log.
+		{TemporaryExposureKey: [16]byte{4}, RollingStartNumber: 45, UploadedAt: time.Now().UTC()},
+	}
+	repo := testRepository{
+		findAllDiagnosisKeysFn: func(_ context.Context) ([]diag.DiagnosisKey, error) { return diagKeys, nil },
+		lastModifiedFn:         func(_ context.Context) (time.Time, error) { return diagKeys[3].UploadedAt, nil },
+	}
+
+	t.Run("transparency log disabled", func(t *testing.T) {
+		handler := newTestHandler(t, &diag.Config{Repository: repo})
+
+		for _, target := range []string{"/transparency/sth", "/transparency/inclusion?key=01000000000000000000000000000000", "/transparency/leaves"} {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com"+target, nil))
+
+			expStatusCode := 404
+			if got := w.Result().StatusCode; got != expStatusCode {
+				t.Errorf("%v: expected: %v, got: %v", target, expStatusCode, got)
+			}
+		}
+	})
+
+	handler := newTestHandler(t, &diag.Config{Repository: repo, Signer: signingKey})
+
+	var head treeHeadResponse
+	t.Run("signed tree head", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/transparency/sth", nil))
+		if got := w.Result().StatusCode; got != 200 {
+			t.Fatalf("expected: %v, got: %v", 200, got)
+		}
+		if err := json.NewDecoder(w.Result().Body).Decode(&head); err != nil {
+			t.Fatal(err)
+		}
+
+		if head.TreeSize != 3 {
+			t.Errorf("expected: %v, got: %v", 3, head.TreeSize)
+		}
+
+		leafHashes := make([]merkle.Hash, 3)
+		for i := range leafHashes {
+			buf := &bytes.Buffer{}
+			diag.WriteDiagnosisKeys(buf, diagKeys[i])
+			leafHashes[i] = merkle.LeafHash(buf.Bytes())
+		}
+		root := merkle.Root(leafHashes)
+		if !bytes.Equal(head.RootHash, root[:]) {
+			t.Errorf("expected: %x, got: %x", root, head.RootHash)
+		}
+
+		signed := make([]byte, 16)
+		binary.BigEndian.PutUint64(signed[0:8], uint64(head.Timestamp))
+		binary.BigEndian.PutUint64(signed[8:16], head.TreeSize)
+		digest := sha256.Sum256(append(signed, head.RootHash...))
+
+		var sig struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(head.Signature, &sig); err != nil {
+			t.Fatal(err)
+		}
+		if !ecdsa.Verify(&signingKey.PublicKey, digest[:], sig.R, sig.S) {
+			t.Error("expected valid signature")
+		}
+	})
+
+	t.Run("inclusion proof", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/transparency/inclusion?key=02000000000000000000000000000000", nil))
+		if got := w.Result().StatusCode; got != 200 {
+			t.Fatalf("expected: %v, got: %v", 200, got)
+		}
+
+		var proof inclusionProofResponse
+		if err := json.NewDecoder(w.Result().Body).Decode(&proof); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := &bytes.Buffer{}
+		diag.WriteDiagnosisKeys(buf, diagKeys[1])
+		auditPath := make([]merkle.Hash, len(proof.AuditPath))
+		for i := range proof.AuditPath {
+			copy(auditPath[i][:], proof.AuditPath[i])
+		}
+		var root merkle.Hash
+		copy(root[:], head.RootHash)
+
+		if !merkle.VerifyInclusion(merkle.LeafHash(buf.Bytes()), proof.LeafIndex, proof.TreeSize, auditPath, root) {
+			t.Errorf("expected valid inclusion proof, got: %+v", proof)
+		}
+
+		for target, expStatusCode := range map[string]int{
+			"/transparency/inclusion?key=04000000000000000000000000000000":            404,
+			"/transparency/inclusion?key=foobar":                                      400,
+			"/transparency/inclusion?key=02000000000000000000000000000000&treeSize=4": 400,
+		} {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com"+target, nil))
+			if got := w.Result().StatusCode; got != expStatusCode {
+				t.Errorf("%v: expected: %v, got: %v", target, expStatusCode, got)
+			}
+		}
+	})
+
+	t.Run("consistency proof", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/transparency/consistency?first=1", nil))
+		if got := w.Result().StatusCode; got != 200 {
+			t.Fatalf("expected: %v, got: %v", 200, got)
+		}
+
+		var proof consistencyProofResponse
+		if err := json.NewDecoder(w.Result().Body).Decode(&proof); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := &bytes.Buffer{}
+		diag.WriteDiagnosisKeys(buf, diagKeys[0])
+		firstRoot := merkle.LeafHash(buf.Bytes())
+		var secondRoot merkle.Hash
+		copy(secondRoot[:], head.RootHash)
+		hashes := make([]merkle.Hash, len(proof.Proof))
+		for i := range proof.Proof {
+			copy(hashes[i][:], proof.Proof[i])
+		}
+
+		if proof.First != 1 || proof.Second != 3 {
+			t.Errorf("expected: 1 -> 3, got: %v -> %v", proof.First, proof.Second)
+		}
+		if !merkle.VerifyConsistency(proof.First, proof.Second, firstRoot, secondRoot, hashes) {
+			t.Errorf("expected valid consistency proof, got: %+v", proof)
+		}
+
+		for _, target := range []string{
+			"/transparency/consistency",
+			"/transparency/consistency?first=0",
+			"/transparency/consistency?first=2&second=1",
+			"/transparency/consistency?first=1&second=4",
+		} {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com"+target, nil))
+			if got := w.Result().StatusCode; got != 400 {
+				t.Errorf("%v: expected: %v, got: %v", target, 400, got)
+			}
+		}
+	})
+
+	t.Run("leaves", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/transparency/leaves", nil))
+
+		expBody := &bytes.Buffer{}
+		diag.WriteDiagnosisKeys(expBody, diagKeys[:3]...)
+		got, err := ioutil.ReadAll(w.Result().Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, expBody.Bytes()) {
+			t.Errorf("expected: %v, got: %v", expBody.Bytes(), got)
+		}
+	})
+}
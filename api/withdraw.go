@@ -0,0 +1,44 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// maxReceiptSize is the maximum size of an upload receipt in a request body.
+const maxReceiptSize = 4096
+
+// withdraw reads an upload receipt (see `X-Upload-Receipt`) from an HTTP
+// request, and withdraws the upload: its keys are deleted, and revoked if
+// they were already published (see diag.Service.Withdraw). The receipt
+// authenticates the request.
+func (h *handler) withdraw(w http.ResponseWriter, r *http.Request) {
+	buf, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxReceiptSize))
+	if err != nil {
+		h.writeError(w, r, http.StatusBadRequest, codeInvalidBody, msgInvalidBody, err)
+		return
+	}
+
+	withdrawal, err := h.diagSvc.Withdraw(r.Context(), strings.TrimSpace(string(buf)))
+	switch err {
+	case nil:
+	case diag.ErrReceiptsUnsupported, diag.ErrWithdrawalUnsupported:
+		writeNotFound(w)
+		return
+	case diag.ErrInvalidReceipt:
+		h.writeError(w, r, http.StatusUnauthorized, codeInvalidReceipt, msgInvalidReceipt)
+		return
+	default:
+		h.logger.Error("Could not withdraw upload", diag.Err(err))
+		h.writeError(w, r, http.StatusInternalServerError, codeInternalError, msgInternalError)
+		return
+	}
+
+	h.logger.Info("Upload withdrawn.", diag.F("deleted", withdrawal.Deleted), diag.F("revoked", withdrawal.Revoked), diag.F("kept", withdrawal.Kept))
+
+	fmt.Fprint(w, "OK")
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// testDeletingRepository is an in-memory repository recording deleted and
+// revoked keys.
+type testDeletingRepository struct {
+	testRepository
+	mu      sync.Mutex
+	stored  []diag.DiagnosisKey
+	deleted [][16]byte
+	revoked [][16]byte
+}
+
+func (ts *testDeletingRepository) FindAllDiagnosisKeys(_ context.Context) ([]diag.DiagnosisKey, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return append([]diag.DiagnosisKey(nil), ts.stored...), nil
+}
+
+func (ts *testDeletingRepository) InsertDiagnosisKeys(_ context.Context, diagKeys []diag.DiagnosisKey, uploadedAt time.Time) ([]diag.DiagnosisKey, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	var inserted []diag.DiagnosisKey
+	for _, diagKey := range diagKeys {
+		if ts.find(diagKey.TemporaryExposureKey) >= 0 {
+			continue
+		}
+		diagKey.UploadedAt = uploadedAt
+		ts.stored = append(ts.stored, diagKey)
+		inserted = append(inserted, diagKey)
+	}
+	return inserted, nil
+}
+
+func (ts *testDeletingRepository) DeleteDiagnosisKeys(_ context.Context, keys [][16]byte, uploadedAt time.Time) ([][16]byte, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	var deleted [][16]byte
+	for _, key := range keys {
+		if i := ts.find(key); i >= 0 && ts.stored[i].UploadedAt.Unix() == uploadedAt.Unix() {
+			ts.stored = append(ts.stored[:i], ts.stored[i+1:]...)
+			deleted = append(deleted, key)
+		}
+	}
+	ts.deleted = append(ts.deleted, deleted...)
+	return deleted, nil
+}
+
+func (ts *testDeletingRepository) FindDiagnosisKeyByTEK(_ context.Context, tek [16]byte) (diag.StoredDiagnosisKey, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	i := ts.find(tek)
+	if i < 0 {
+		return diag.StoredDiagnosisKey{}, diag.ErrKeyNotFound
+	}
+	return diag.StoredDiagnosisKey{DiagnosisKey: ts.stored[i], Index: int64(i)}, nil
+}
+
+func (ts *testDeletingRepository) StoreRevocations(_ context.Context, keys [][16]byte, _ time.Time) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.revoked = append(ts.revoked, keys...)
+	return nil
+}
+
+func (ts *testDeletingRepository) FindAllRevocations(_ context.Context) ([]diag.Revocation, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	var revocations []diag.Revocation
+	for _, key := range ts.revoked {
+		revocations = append(revocations, diag.Revocation{TemporaryExposureKey: key})
+	}
+	return revocations, nil
+}
+
+// find returns the index of the stored key with the given Temporary Exposure
+// Key, or -1.
+func (ts *testDeletingRepository) find(key [16]byte) int {
+	for i := range ts.stored {
+		if ts.stored[i].TemporaryExposureKey == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestWithdraw(t *testing.T) {
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("withdraw upload with receipt", func(t *testing.T) {
+		repo := &testDeletingRepository{testRepository: noopRepo}
+		handler := newTestHandler(t, &diag.Config{Repository: repo, Signer: signingKey, UploadReceipts: true})
+
+		key := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+		body := append(key[:], 0, 0, 0, 42, 5)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "http://example.com/diagnosis-keys", bytes.NewReader(body)))
+		receipt := w.Result().Header.Get("X-Upload-Receipt")
+		if w.Code != 200 || receipt == "" {
+			t.Fatalf("expected upload with receipt, got: %v (receipt: %q)", w.Code, receipt)
+		}
+
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "http://example.com/diagnosis-keys/withdraw", strings.NewReader("foo.bar")))
+		if got, exp := w.Code, 401; got != exp {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "http://example.com/diagnosis-keys/withdraw", strings.NewReader(receipt)))
+		if got, exp := w.Code, 200; got != exp {
+			t.Fatalf("expected: %v, got: %v", exp, got)
+		}
+		if len(repo.deleted) != 1 || repo.deleted[0] != key {
+			t.Errorf("expected key to be deleted, got: %v", repo.deleted)
+		}
+	})
+
+	t.Run("published keys uploaded again", func(t *testing.T) {
+		published := diag.DiagnosisKey{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: 42, TransmissionRiskLevel: 5, UploadedAt: time.Now().Add(-time.Hour)}
+		repo := &testDeletingRepository{testRepository: noopRepo, stored: []diag.DiagnosisKey{published}}
+		handler := newTestHandler(t, &diag.Config{Repository: repo, Signer: signingKey, UploadReceipts: true})
+
+		// The published key is downloaded, and uploaded again, on its own
+		// and with a new key.
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/diagnosis-keys", nil))
+		body := w.Body.Bytes()
+		if len(body) != diag.DiagnosisKeySize {
+			t.Fatalf("expected published key, got: %x", body)
+		}
+
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "http://example.com/diagnosis-keys", bytes.NewReader(body)))
+		if got := w.Result().Header.Get("X-Upload-Receipt"); w.Code != 200 || got != "" {
+			t.Errorf("expected upload without receipt, got: %v (receipt: %q)", w.Code, got)
+		}
+
+		key := [16]byte{2}
+		body = append(append(body, key[:]...), 0, 0, 0, 42, 5)
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "http://example.com/diagnosis-keys", bytes.NewReader(body)))
+		receipt := w.Result().Header.Get("X-Upload-Receipt")
+		if w.Code != 200 || receipt == "" {
+			t.Fatalf("expected upload with receipt, got: %v (receipt: %q)", w.Code, receipt)
+		}
+
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "http://example.com/diagnosis-keys/withdraw", strings.NewReader(receipt)))
+		if got, exp := w.Code, 200; got != exp {
+			t.Fatalf("expected: %v, got: %v", exp, got)
+		}
+		// Depending on whether the new key is published yet, it's deleted or
+		// revoked, but the published key is neither.
+		withdrawn := append(append([][16]byte(nil), repo.deleted...), repo.revoked...)
+		if len(withdrawn) != 1 || withdrawn[0] != key {
+			t.Errorf("expected only new key to be withdrawn, got: %v", withdrawn)
+		}
+	})
+
+	t.Run("receipts disabled", func(t *testing.T) {
+		handler := newTestHandler(t, nil)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "http://example.com/diagnosis-keys", bytes.NewReader(make([]byte, diag.DiagnosisKeySize))))
+		if got := w.Result().Header.Get("X-Upload-Receipt"); got != "" {
+			t.Errorf("expected no receipt, got: %q", got)
+		}
+
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "http://example.com/diagnosis-keys/withdraw", strings.NewReader("foo.bar")))
+		if got, exp := w.Code, 404; got != exp {
+			t.Errorf("expected: %v, got: %v", exp, got)
+		}
+	})
+}
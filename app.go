@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/api"
+	"github.com/dstotijn/ct-diag-server/assets"
+	"github.com/dstotijn/ct-diag-server/config"
+	"github.com/dstotijn/ct-diag-server/diag"
+	"github.com/dstotijn/ct-diag-server/diag/zaplog"
+	"github.com/dstotijn/ct-diag-server/federation"
+	"github.com/dstotijn/ct-diag-server/secrets"
+
+	"go.uber.org/zap"
+)
+
+// app is the server, constructed from the configuration: the HTTP handler and
+// the services behind it.
+type app struct {
+	handler http.Handler
+	diagSvc diag.Service
+	puller  *federation.Puller
+	pusher  *federation.Pusher
+
+	closers []func() error
+}
+
+// newApp constructs the server. Background goroutines of the diagnosis key
+// service and the HTTP handler are started, unless `-serverless` is set; the
+// ones of the secrets watcher and federation are started by run.
+func newApp(ctx context.Context, cfg config.Config, secretsWatcher *secrets.Watcher, errorLog *diag.ErrorLog, logger *zap.Logger) (_ *app, err error) {
+	a := &app{}
+	defer func() {
+		if err != nil {
+			a.close()
+		}
+	}()
+	var diagLogger diag.Logger = errorLog
+
+	// Pick up secrets rotated in the secret manager.
+	var dsnSource func() string
+	if secretsWatcher != nil {
+		if _, ok := cfg.SecretRefs["POSTGRES_DSN"]; ok {
+			dsnSource = func() string { return secretsWatcher.Get("POSTGRES_DSN") }
+		}
+	}
+
+	// Each backend is opened once, and shared by the data classes it stores.
+	dbs := make(map[string]repository)
+	openDB := func(backend string) (repository, error) {
+		if db, ok := dbs[backend]; ok {
+			return db, nil
+		}
+		db, err := openRepository(ctx, cfg, backend, dsnSource, diagLogger, logger)
+		if err != nil {
+			return nil, err
+		}
+		dbs[backend] = db
+		a.closers = append(a.closers, db.Close)
+		return db, nil
+	}
+	repo, err := openDB(cfg.DB)
+	if err != nil {
+		return nil, err
+	}
+	revocationsRepo, err := openDB(cfg.RevocationsBackend())
+	if err != nil {
+		return nil, err
+	}
+	jobsRepo, err := openDB(cfg.JobsBackend())
+	if err != nil {
+		return nil, err
+	}
+
+	exposureCfg := assets.DefaultExposureConfig()
+	if cfg.ExposureConfig != "" {
+		buf, err := ioutil.ReadFile(cfg.ExposureConfig)
+		if err != nil {
+			return nil, fmt.Errorf("could not read exposure config: %v", err)
+		}
+		exposureCfg, err = assets.ParseExposureConfig(buf)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exposure config: %v", err)
+		}
+	}
+	var exposureCfgHistory diag.ExposureConfigHistory
+	if cfg.ExposureConfigHistory != "" {
+		buf, err := ioutil.ReadFile(cfg.ExposureConfigHistory)
+		if err != nil {
+			return nil, fmt.Errorf("could not read exposure config history: %v", err)
+		}
+		exposureCfgHistory, err = assets.ParseExposureConfigHistory(buf)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exposure config history: %v", err)
+		}
+	}
+
+	var messages api.Messages
+	if cfg.Messages != "" {
+		buf, err := ioutil.ReadFile(cfg.Messages)
+		if err != nil {
+			return nil, fmt.Errorf("could not read messages: %v", err)
+		}
+		messages, err = api.ParseMessages(buf)
+		if err != nil {
+			return nil, fmt.Errorf("invalid messages: %v", err)
+		}
+	}
+
+	var signer crypto.Signer
+	if cfg.SigningKey != "" {
+		key, err := config.ParseSigningKey([]byte(cfg.SigningKey))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse signing key: %v", err)
+		}
+		signer = key
+		if _, ok := cfg.SecretRefs["SIGNING_KEY"]; ok {
+			rs := &rotatingSigner{}
+			rs.set(key)
+			secretsWatcher.OnChange("SIGNING_KEY", func(v string) {
+				key, err := config.ParseSigningKey([]byte(v))
+				if err != nil {
+					logger.Error("Could not parse rotated signing key.", zap.Error(err))
+					return
+				}
+				rs.set(key)
+				logger.Info("Signing key rotated.")
+			})
+			signer = rs
+		}
+	}
+
+	var uploadEventLogger diag.Logger
+	if cfg.UploadEventLog != "" {
+		l, err := newUploadEventLogger(cfg.UploadEventLog)
+		if err != nil {
+			return nil, fmt.Errorf("could not create upload event logger: %v", err)
+		}
+		a.closers = append(a.closers, l.Sync)
+		uploadEventLogger = zaplog.New(l)
+	}
+
+	var snapshot io.Reader
+	if cfg.CacheSnapshot != "" {
+		f, err := os.Open(cfg.CacheSnapshot)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			logger.Warn("Could not open cache snapshot.", zap.Error(err))
+		default:
+			a.closers = append(a.closers, f.Close)
+			snapshot = f
+		}
+	}
+
+	snapshotCodec, _ := diag.ParseSnapshotCodec(cfg.CacheSnapshotCompression)
+
+	var cache diag.Cache = &diag.MemoryCache{}
+	if cfg.CacheLayout == config.CacheLayoutDaily {
+		cache = &diag.DayCache{}
+	}
+
+	diagCfg := diag.Config{
+		Repository:                   repo,
+		Cache:                        cache,
+		CacheInterval:                cfg.CacheInterval,
+		MaxUploadBatchSize:           cfg.MaxUploadBatchSize,
+		ExposureConfig:               exposureCfg,
+		ExposureConfigHistory:        exposureCfgHistory,
+		Logger:                       diagLogger,
+		RetentionPeriod:              cfg.RetentionPeriod,
+		PurgeDryRun:                  cfg.PurgeDryRun,
+		MaxConcurrentUploads:         cfg.MaxConcurrentUploads,
+		MaxQueuedUploads:             cfg.MaxQueuedUploads,
+		UploadSaturationThreshold:    cfg.UploadSaturationThreshold,
+		UploadEventLogger:            uploadEventLogger,
+		Signer:                       signer,
+		CacheSnapshot:                snapshot,
+		SnapshotCodec:                snapshotCodec,
+		DuplicateFilter:              cfg.DuplicateFilter,
+		DefaultTransmissionRiskLevel: byte(cfg.DefaultTransmissionRiskLevel),
+		MaxKeyAge:                    cfg.MaxKeyAge,
+		MaxFederatedKeyAge:           cfg.MaxFederatedKeyAge,
+		KeyClockSkew:                 cfg.KeyClockSkew,
+		MaxStoredKeys:                cfg.MaxStoredKeys,
+		RefuseUploadsOverQuota:       cfg.RefuseUploadsOverQuota,
+		PublishEmptyBatches:          cfg.PublishEmptyBatches,
+		UploadReceipts:               cfg.UploadReceipts,
+		RevocationRepository:         revocationsRepo,
+		JobRepository:                jobsRepo,
+		RequestDriven:                cfg.Serverless,
+	}
+	if cfg.HourlyBuckets {
+		diagCfg.CacheAlignment = time.Hour
+	}
+	// The configuration is validated, so parse errors can be ignored.
+	if cfg.RefreshSchedule != "" {
+		diagCfg.RefreshSchedule, _ = cfg.ParseSchedule(cfg.RefreshSchedule)
+	}
+	if cfg.PurgeSchedule != "" {
+		diagCfg.PurgeSchedule, _ = cfg.ParseSchedule(cfg.PurgeSchedule)
+	}
+	if cfg.ExportRegion != "" {
+		diagCfg.Export = &diag.ExportConfig{
+			Region:                 cfg.ExportRegion,
+			VerificationKeyID:      cfg.ExportKeyID,
+			VerificationKeyVersion: cfg.ExportKeyVersion,
+		}
+	}
+	a.diagSvc, err = diag.NewService(ctx, diagCfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not create diagnosis key service: %v", err)
+	}
+
+	var federationSchedule diag.Schedule
+	if cfg.FederationSchedule != "" {
+		federationSchedule, _ = cfg.ParseSchedule(cfg.FederationSchedule)
+	}
+
+	// Pull keys of peer servers into the repository; they're published with
+	// the next cache refresh.
+	if cfg.FederationPeers != "" {
+		// The configuration is validated, so parse errors can be ignored.
+		peers, _ := federation.ParsePeers(cfg.FederationPeers)
+		var verifier federation.Verifier
+		if peerKeys, _ := cfg.FederationPeerPublicKeys(); peerKeys != nil {
+			verifier = federation.SignatureVerifier(peerKeys)
+		}
+		a.puller, err = federation.New(federation.Config{
+			Repository: repo,
+			Peers:      peers,
+			Logger:     diagLogger,
+			Interval:   cfg.FederationInterval,
+			Schedule:   federationSchedule,
+			MaxKeyAge:  cfg.MaxFederatedKeyAge,
+			Verifier:   verifier,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not create federation puller: %v", err)
+		}
+	}
+
+	// Push keys uploaded to this server to peer servers.
+	if cfg.FederationPushPeers != "" {
+		// The configuration is validated, so parse errors can be ignored.
+		peers, _ := federation.ParsePeers(cfg.FederationPushPeers)
+		a.pusher, err = federation.NewPusher(federation.PushConfig{
+			Repository:     repo,
+			Peers:          peers,
+			Origin:         cfg.FederationOrigin,
+			Signer:         signer,
+			Logger:         diagLogger,
+			Interval:       cfg.FederationInterval,
+			Schedule:       federationSchedule,
+			CheckpointPath: cfg.FederationPushCheckpoint,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not create federation pusher: %v", err)
+		}
+	}
+
+	// The configuration is validated, so errors can be ignored.
+	verifier, _ := cfg.Verifier()
+	federationSenders, _ := cfg.FederationSenderKeys()
+
+	apiCfg := api.Config{
+		Diag:               diagCfg,
+		Service:            &a.diagSvc,
+		Logger:             diagLogger,
+		AdminToken:         cfg.AdminToken,
+		SLOWindow:          cfg.SLOWindow,
+		ReadinessTimeout:   cfg.ReadinessTimeout,
+		StreamHeartbeat:    cfg.StreamHeartbeat,
+		SLOWebhookURL:      cfg.SLOWebhookURL,
+		SLOWebhookInterval: cfg.SLOWebhookInterval,
+		HourlyBuckets:      cfg.HourlyBuckets,
+		ErrorLog:           errorLog,
+		DigestWebhookURL:   cfg.DigestWebhookURL,
+		Verifier:           verifier,
+		Messages:           messages,
+		FederationSenders:  federationSenders,
+		Federation:         a.puller,
+		FederationPusher:   a.pusher,
+		Regions:            cfg.Regions,
+		ReportTypes:        cfg.ReportTypes,
+		RollingPeriods:     cfg.RollingPeriods,
+		RequestDriven:      cfg.Serverless,
+	}
+	if cfg.DigestEmailTo != "" {
+		apiCfg.DigestSMTP = &api.SMTPConfig{
+			Addr:     cfg.DigestSMTPAddr,
+			Username: cfg.DigestSMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.DigestEmailFrom,
+		}
+		for _, to := range strings.Split(cfg.DigestEmailTo, ",") {
+			apiCfg.DigestSMTP.To = append(apiCfg.DigestSMTP.To, strings.TrimSpace(to))
+		}
+	}
+	if cfg.Dev {
+		apiCfg.Docs = assets.Docs()
+		apiCfg.CaptureDir = cfg.CaptureDir
+	}
+	a.handler, err = api.NewHandler(ctx, apiCfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not create HTTP handler: %v", err)
+	}
+
+	return a, nil
+}
+
+// run starts the secrets watcher and federation in the background, until ctx
+// is done. Serverless instances don't run them; federation is triggered via
+// the admin API instead.
+func (a *app) run(ctx context.Context, cfg config.Config, secretsWatcher *secrets.Watcher, logger diag.Logger) {
+	if secretsWatcher != nil && cfg.SecretsRefreshInterval > 0 {
+		go secretsWatcher.Run(ctx, cfg.SecretsRefreshInterval, logger)
+	}
+	if a.puller != nil {
+		go a.puller.Run(ctx)
+	}
+	if a.pusher != nil {
+		go a.pusher.Run(ctx)
+	}
+}
+
+// close closes the databases and files opened by newApp.
+func (a *app) close() {
+	for i := len(a.closers) - 1; i >= 0; i-- {
+		a.closers[i]()
+	}
+}
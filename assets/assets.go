@@ -0,0 +1,119 @@
+// Package assets embeds the static assets of the server, so the binary is
+// self-contained: the OpenAPI specification and its documentation page, the
+// default exposure configuration, and HTML templates.
+package assets
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"sort"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+//go:embed docs
+var docs embed.FS
+
+//go:embed exposure-config.json
+var defaultExposureConfig []byte
+
+//go:embed templates
+var templates embed.FS
+
+// StatusTemplate is the template of the operator status page. Times are
+// formatted with the `time` function, with the zero time rendered as `never`.
+var StatusTemplate = template.Must(template.New("status.html").Funcs(template.FuncMap{
+	"time": formatTime,
+}).ParseFS(templates, "templates/status.html"))
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Docs returns the file system served as API documentation, with the page at
+// `index.html`, rendering the OpenAPI document served at `/openapi.json`.
+func Docs() fs.FS {
+	sub, err := fs.Sub(docs, "docs")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// DefaultExposureConfig returns the default exposure configuration.
+func DefaultExposureConfig() diag.ExposureConfig {
+	expCfg, err := ParseExposureConfig(defaultExposureConfig)
+	if err != nil {
+		panic(err)
+	}
+	return expCfg
+}
+
+// ParseExposureConfig parses an exposure configuration in JSON, e.g. one based
+// on the default in `exposure-config.json`. Unknown fields are rejected, to
+// catch typos.
+func ParseExposureConfig(buf []byte) (diag.ExposureConfig, error) {
+	var expCfg diag.ExposureConfig
+
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&expCfg); err != nil {
+		return diag.ExposureConfig{}, fmt.Errorf("assets: could not parse exposure config: %v", err)
+	}
+
+	return expCfg, nil
+}
+
+// ParseExposureConfigHistory parses the versions of an exposure configuration
+// in JSON, an array of objects with an `id`, an `effectiveFrom` timestamp
+// (RFC 3339), optionally a `region` and `appVersion` (see
+// diag.ExposureConfigScope) and a `config` (see ParseExposureConfig), as
+// exported by `/admin/exposure-config/history`. The versions are sorted by
+// their effective time, which must be unique per scope, and IDs must be
+// unique.
+func ParseExposureConfigHistory(buf []byte) (diag.ExposureConfigHistory, error) {
+	var history diag.ExposureConfigHistory
+
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&history); err != nil {
+		return nil, fmt.Errorf("assets: could not parse exposure config history: %v", err)
+	}
+	if len(history) == 0 {
+		return nil, fmt.Errorf("assets: exposure config history has no versions")
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].EffectiveFrom.Before(history[j].EffectiveFrom)
+	})
+	ids := make(map[string]bool)
+	last := make(map[diag.ExposureConfigScope]diag.ExposureConfigVersion)
+	for _, version := range history {
+		prev, ok := last[version.ExposureConfigScope]
+		if err := version.ExposureConfigScope.Validate(); err != nil {
+			return nil, fmt.Errorf("assets: invalid scope of exposure config version %q: %v", version.ID, err)
+		}
+		switch {
+		case version.ID == "":
+			return nil, fmt.Errorf("assets: exposure config version effective from %v has no ID", version.EffectiveFrom.Format(time.RFC3339))
+		case ids[version.ID]:
+			return nil, fmt.Errorf("assets: duplicate exposure config version %q", version.ID)
+		case version.EffectiveFrom.IsZero():
+			return nil, fmt.Errorf("assets: exposure config version %q has no effective time", version.ID)
+		case ok && version.EffectiveFrom.Equal(prev.EffectiveFrom):
+			return nil, fmt.Errorf("assets: exposure config versions %q and %q have the same effective time", prev.ID, version.ID)
+		}
+		ids[version.ID] = true
+		last[version.ExposureConfigScope] = version
+	}
+
+	return history, nil
+}
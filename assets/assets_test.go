@@ -0,0 +1,74 @@
+package assets
+
+import (
+	"io/fs"
+	"reflect"
+	"testing"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+func TestDefaultExposureConfig(t *testing.T) {
+	levels := []int{1, 2, 3, 4, 5, 6, 7, 8}
+	exp := diag.ExposureConfig{
+		MinimumRiskScore:                 0,
+		AttenuationLevelValues:           levels,
+		AttenuationWeight:                50,
+		DaysSinceLastExposureLevelValues: levels,
+		DaysSinceLastExposureWeight:      50,
+		DurationLevelValues:              levels,
+		DurationWeight:                   50,
+		TransmissionRiskLevelValues:      levels,
+		TransmissionRiskWeight:           50,
+	}
+
+	if got := DefaultExposureConfig(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected: %+v, got: %+v", exp, got)
+	}
+}
+
+func TestParseExposureConfig(t *testing.T) {
+	if _, err := ParseExposureConfig([]byte(`{"minimumRiskScor": 1}`)); err == nil {
+		t.Error("expected error for unknown field")
+	}
+	if _, err := ParseExposureConfig([]byte(`{"minimumRiskScore": 256}`)); err == nil {
+		t.Error("expected error for invalid value")
+	}
+}
+
+func TestParseExposureConfigHistory(t *testing.T) {
+	history, err := ParseExposureConfigHistory([]byte(`[
+		{"id": "v2", "effectiveFrom": "2020-06-01T00:00:00Z", "config": {"minimumRiskScore": 2}},
+		{"id": "v1", "effectiveFrom": "2020-05-01T00:00:00Z", "config": {"minimumRiskScore": 1}},
+		{"id": "NL/v1", "effectiveFrom": "2020-06-01T00:00:00Z", "region": "NL", "appVersion": "1.2", "config": {"minimumRiskScore": 3}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 || history[0].ID != "v1" || history[1].Config.MinimumRiskScore != 2 || history[2].Region != "NL" {
+		t.Errorf("expected versions sorted by effective time, got: %+v", history)
+	}
+
+	for _, s := range []string{
+		`[]`,
+		`[{"id": "v1", "effectiveFrom": "2020-05-01T00:00:00Z", "config": {"minimumRiskScor": 1}}]`,
+		`[{"id": "", "effectiveFrom": "2020-05-01T00:00:00Z", "config": {}}]`,
+		`[{"id": "v1", "config": {}}]`,
+		`[{"id": "v1", "effectiveFrom": "2020-05-01T00:00:00Z", "config": {}}, {"id": "v1", "effectiveFrom": "2020-06-01T00:00:00Z", "config": {}}]`,
+		`[{"id": "v1", "effectiveFrom": "2020-05-01T00:00:00Z", "config": {}}, {"id": "v2", "effectiveFrom": "2020-05-01T00:00:00Z", "config": {}}]`,
+		`[{"id": "v1", "effectiveFrom": "2020-05-01T00:00:00Z", "region": "nl", "config": {}}]`,
+		`[{"id": "v1", "effectiveFrom": "2020-05-01T00:00:00Z", "appVersion": "1.x", "config": {}}]`,
+	} {
+		if _, err := ParseExposureConfigHistory([]byte(s)); err == nil {
+			t.Errorf("%v: expected error", s)
+		}
+	}
+}
+
+func TestDocs(t *testing.T) {
+	for _, name := range []string{"index.html"} {
+		if _, err := fs.Stat(Docs(), name); err != nil {
+			t.Errorf("%v: unexpected error: %v", name, err)
+		}
+	}
+}
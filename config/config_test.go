@@ -0,0 +1,306 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func defaultConfig(t *testing.T) Config {
+	cfg := Config{PostgresDSN: "postgres://localhost:5432/ct-diag?sslmode=disable"}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg.RegisterFlags(fs)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// writePublicKey writes a PEM encoded ECDSA P-256 public key to a temporary
+// file, and returns its path.
+func writePublicKey(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "peer.pem")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("defaults are valid", func(t *testing.T) {
+		if err := defaultConfig(t).Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("all problems are reported", func(t *testing.T) {
+		cfg := defaultConfig(t)
+		cfg.PostgresDSN = ""
+		cfg.CacheInterval = -time.Minute
+		cfg.RetentionPeriod = 24 * time.Hour
+		cfg.PartitionInterval = "monthly"
+		cfg.RefuseUploadsOverQuota = true
+		cfg.SLOWebhookURL = "example.com/slo"
+		cfg.SigningKey = "foobar"
+		cfg.CaptureDir = "/does/not/exist"
+		cfg.RefreshSchedule = "0 25 * * *"
+		cfg.CacheSnapshotCompression = "lz4"
+		cfg.UploadSaturationThreshold = 1.5
+
+		err := cfg.Validate()
+		verr, ok := err.(*ValidationError)
+		if !ok {
+			t.Fatalf("expected *ValidationError, got: %v", err)
+		}
+
+		for _, exp := range []string{
+			"`POSTGRES_DSN` environment variable is required",
+			"`-cacheInterval` must not be negative",
+			"`-retentionPeriod` (24h0m0s) is shorter than",
+			"`-partition` is invalid",
+			"`-refuseUploadsOverQuota` requires `-maxStoredKeys`",
+			"`-sloWebhookURL` must be an absolute HTTP(S) URL",
+			"`SIGNING_KEY` environment variable is invalid",
+			"`-captureDir` requires `-dev`",
+			"`-captureDir` must refer to an existing directory",
+			"`-refreshSchedule` is invalid",
+			"`-cacheSnapshotCompression` is invalid",
+			"`-uploadSaturationThreshold` must be between 0 and 1",
+		} {
+			if !strings.Contains(err.Error(), exp) {
+				t.Errorf("expected error to contain: %v", exp)
+			}
+		}
+		if got, exp := len(verr.Problems), 12; got != exp {
+			t.Errorf("expected: %v problems, got: %v (%v)", exp, got, verr)
+		}
+	})
+
+	t.Run("schedules", func(t *testing.T) {
+		cfg := defaultConfig(t)
+		cfg.RefreshSchedule = "0 9 * * *"
+		cfg.ScheduleTimezone = "Europe/Amsterdam"
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		cfg.ScheduleTimezone = "Mars/Olympus_Mons"
+		cfg.PurgeSchedule = "@daily"
+		cfg.PurgeDryRun = true
+		cfg.FederationSchedule = "*/30 * * * *"
+		err := cfg.Validate()
+		for _, exp := range []string{"`-scheduleTimezone` is invalid", "`-purgeSchedule` requires `-retentionPeriod`", "`-purgeDryRun` requires `-retentionPeriod`", "`-federationSchedule` requires `-federationPeers`"} {
+			if err == nil || !strings.Contains(err.Error(), exp) {
+				t.Errorf("expected error to contain: %v, got: %v", exp, err)
+			}
+		}
+	})
+
+	t.Run("verification", func(t *testing.T) {
+		cfg := defaultConfig(t)
+		cfg.VerificationHMACSecret = "s3cret"
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "Verification of uploads is misconfigured") {
+			t.Errorf("expected verification error, got: %v", err)
+		}
+
+		cfg.VerificationIssuer = "verification"
+		cfg.VerificationAudience = "ct-diag"
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if v, err := cfg.Verifier(); v == nil || err != nil {
+			t.Errorf("expected verifier, got: %v (%v)", v, err)
+		}
+
+		cfg.VerificationKeys = "v1"
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "expected `kid=path`") {
+			t.Errorf("expected invalid key error, got: %v", err)
+		}
+	})
+
+	t.Run("verification regions", func(t *testing.T) {
+		cfg := defaultConfig(t)
+		cfg.VerificationRegions = filepath.Join(t.TempDir(), "regions.json")
+		if err := ioutil.WriteFile(cfg.VerificationRegions, []byte(`{"BE": {"issuers": ["be.verification"]}}`), 0600); err != nil {
+			t.Fatal(err)
+		}
+		err := cfg.Validate()
+		for _, exp := range []string{"`-verificationRegions` requires `-regions`", "`-verificationRegions` requires `-verificationKeys`"} {
+			if err == nil || !strings.Contains(err.Error(), exp) {
+				t.Errorf("expected error to contain: %v, got: %v", exp, err)
+			}
+		}
+
+		cfg.Regions = true
+		cfg.VerificationIssuer = "verification"
+		cfg.VerificationAudience = "ct-diag"
+		cfg.VerificationHMACSecret = "s3cret"
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		if err := ioutil.WriteFile(cfg.VerificationRegions, []byte(`{"be": {}}`), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), `invalid region "be"`) {
+			t.Errorf("expected invalid region error, got: %v", err)
+		}
+	})
+
+	t.Run("sqlite", func(t *testing.T) {
+		cfg := defaultConfig(t)
+		cfg.DB = DBSQLite
+		cfg.PostgresDSN = ""
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		cfg.PartitionInterval = "daily"
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "`-partition` requires `-db=postgres`") {
+			t.Errorf("expected partition error, got: %v", err)
+		}
+
+		cfg.DB = "mysql"
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "`-db` is invalid") {
+			t.Errorf("expected invalid database error, got: %v", err)
+		}
+	})
+
+	t.Run("backends per data class", func(t *testing.T) {
+		cfg := defaultConfig(t)
+		cfg.DB = DBSQLite
+		cfg.PostgresDSN = ""
+		cfg.JobsDB = DBPostgres
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "`POSTGRES_DSN` environment variable is required") {
+			t.Errorf("expected missing DSN error, got: %v", err)
+		}
+		if got := cfg.RevocationsBackend(); got != DBSQLite {
+			t.Errorf("expected: %v, got: %v", DBSQLite, got)
+		}
+
+		cfg.JobsDB = "s3"
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "`-jobsDB` is invalid") {
+			t.Errorf("expected invalid backend error, got: %v", err)
+		}
+	})
+
+	t.Run("federation", func(t *testing.T) {
+		cfg := defaultConfig(t)
+		cfg.FederationPeers = "DE=https://diag.example.de, BE=https://diag.example.be"
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		cfg.FederationPeers = "DE=diag.example.de"
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "`-federationPeers` is invalid") {
+			t.Errorf("expected invalid peers error, got: %v", err)
+		}
+
+		cfg = defaultConfig(t)
+		cfg.FederationPushPeers = "BE=https://diag.example.be"
+		err := cfg.Validate()
+		for _, exp := range []string{"requires `-federationOrigin`", "requires the `SIGNING_KEY`"} {
+			if err == nil || !strings.Contains(err.Error(), exp) {
+				t.Errorf("expected error containing %q, got: %v", exp, err)
+			}
+		}
+
+		cfg = defaultConfig(t)
+		cfg.FederationSenders = "DE=/nonexistent.pem"
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "`-federationSenders` is invalid") {
+			t.Errorf("expected invalid senders error, got: %v", err)
+		}
+
+		keyPath := writePublicKey(t)
+		cfg = defaultConfig(t)
+		cfg.FederationPeers = "DE=https://diag.example.de, BE=https://diag.example.be"
+		cfg.FederationPeerKeys = "DE=" + keyPath + ",BE=" + keyPath
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		cfg.FederationPeerKeys = "DE=" + keyPath
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "lacks a public key of peer \"BE\"") {
+			t.Errorf("expected missing peer key error, got: %v", err)
+		}
+
+		cfg.FederationPeerKeys = "DE=/nonexistent.pem"
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "`-federationPeerKeys` is invalid") {
+			t.Errorf("expected invalid peer keys error, got: %v", err)
+		}
+	})
+
+	t.Run("tls", func(t *testing.T) {
+		cfg := defaultConfig(t)
+		cfg.TLSCert = "/nonexistent/cert.pem"
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "`-tlsCert` and `-tlsKey` must be set together") {
+			t.Errorf("expected missing key error, got: %v", err)
+		}
+
+		cfg.TLSKey = "/nonexistent/key.pem"
+		cfg.AutocertHosts = "diag.example.com"
+		err := cfg.Validate()
+		for _, exp := range []string{"refer to an invalid key pair", "`-tlsCert` and `-autocertHosts` can't be combined"} {
+			if err == nil || !strings.Contains(err.Error(), exp) {
+				t.Errorf("expected error containing %q, got: %v", exp, err)
+			}
+		}
+
+		cfg = defaultConfig(t)
+		cfg.AutocertHosts = "diag.example.com, https://diag.example.org"
+		err = cfg.Validate()
+		for _, exp := range []string{"`-autocertHosts` is invalid", "requires `-autocertCacheDir`"} {
+			if err == nil || !strings.Contains(err.Error(), exp) {
+				t.Errorf("expected error containing %q, got: %v", exp, err)
+			}
+		}
+
+		cfg.AutocertHosts = "diag.example.com, diag.example.org"
+		cfg.AutocertCacheDir = t.TempDir()
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("serverless", func(t *testing.T) {
+		cfg := defaultConfig(t)
+		cfg.Serverless = true
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		cfg.JobsDB = DBSQLite
+		cfg.CacheSnapshot = "ct-diag.snapshot"
+		cfg.SLOWebhookURL = "https://example.com/slo"
+		err := cfg.Validate()
+		for _, exp := range []string{"`-serverless` requires the `postgres` database backend", "`-cacheSnapshot` can't be combined with `-serverless`", "`-sloWebhookURL` can't be combined with `-serverless`"} {
+			if err == nil || !strings.Contains(err.Error(), exp) {
+				t.Errorf("expected error containing %q, got: %v", exp, err)
+			}
+		}
+	})
+
+	t.Run("invalid DSN", func(t *testing.T) {
+		cfg := defaultConfig(t)
+		cfg.PostgresDSN = "postgres://localhost:port/ct-diag"
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "`POSTGRES_DSN` environment variable is invalid") {
+			t.Errorf("expected invalid DSN error, got: %v", err)
+		}
+	})
+}
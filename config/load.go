@@ -0,0 +1,266 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/dstotijn/ct-diag-server/secrets"
+)
+
+// EnvPrefix is the prefix of the environment variables equivalent to flags.
+const EnvPrefix = "CTDIAG_"
+
+// EnvName returns the name of the environment variable equivalent to the flag
+// with the given name, e.g. `CTDIAG_CACHE_INTERVAL` for `cacheInterval`.
+func EnvName(flagName string) string {
+	rs := []rune(flagName)
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	for i, r := range rs {
+		if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(rs[i-1]) || (i+1 < len(rs) && unicode.IsLower(rs[i+1]))) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// Load returns the configuration, defining its flags in fs and parsing them
+// from args. Each setting is taken from the first source that has it:
+//
+//  1. Flag, e.g. `-cacheInterval 1m`.
+//  2. Environment variable, e.g. `CTDIAG_CACHE_INTERVAL=1m` (see EnvName).
+//  3. Config file (flag: `-config`, or `CTDIAG_CONFIG`), a JSON object
+//     keyed by flag name, e.g. `{"cacheInterval": "1m"}`.
+//  4. Default.
+//
+// Secrets are only read from the environment (`POSTGRES_DSN`, `SIGNING_KEY`,
+// `ADMIN_TOKEN`, `SMTP_PASSWORD` and `VERIFICATION_HMAC_SECRET`), or from the file referred to by the
+// environment variable with a `_FILE` suffix, e.g. `POSTGRES_DSN_FILE`. The
+// DSN and signing key can also be fetched from a secret manager (see
+// FetchSecrets), with references in environment variables with a `_SECRET`
+// suffix. Invalid values in the environment or config file are reported
+// together in a *ValidationError; use Validate to check the values.
+func Load(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	var cfg Config
+	var configFile string
+	cfg.RegisterFlags(fs)
+	fs.StringVar(&configFile, "config", "", "JSON file with settings keyed by flag name, overridden by flags and environment variables")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	isSet := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { isSet[f.Name] = true })
+	if v, ok := lookupEnv(EnvName("config")); ok && !isSet["config"] {
+		configFile = v
+	}
+
+	var problems []string
+	var fileValues map[string]string
+	if configFile != "" {
+		var err error
+		fileValues, err = readConfigFile(configFile)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("Config file %q is invalid: %v.", configFile, err))
+		}
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if isSet[f.Name] || f.Name == "config" {
+			return
+		}
+
+		var source string
+		v, ok := lookupEnv(EnvName(f.Name))
+		if ok {
+			source = fmt.Sprintf("environment variable `%v`", EnvName(f.Name))
+		} else if v, ok = fileValues[f.Name]; ok {
+			source = fmt.Sprintf("key %q in config file", f.Name)
+		} else {
+			return
+		}
+
+		if err := fs.Set(f.Name, v); err != nil {
+			problems = append(problems, fmt.Sprintf("Invalid value %q for %v: %v.", v, source, err))
+		}
+	})
+
+	var unknown []string
+	for name := range fileValues {
+		if fs.Lookup(name) == nil || name == "config" {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		problems = append(problems, fmt.Sprintf("Unknown key %q in config file; keys are flag names, e.g. `cacheInterval`.", name))
+	}
+
+	for _, secret := range []struct {
+		name    string
+		dst     *string
+		managed bool
+	}{
+		{"POSTGRES_DSN", &cfg.PostgresDSN, true},
+		{"SIGNING_KEY", &cfg.SigningKey, true},
+		{"ADMIN_TOKEN", &cfg.AdminToken, false},
+		{"SMTP_PASSWORD", &cfg.SMTPPassword, false},
+		{"VERIFICATION_HMAC_SECRET", &cfg.VerificationHMACSecret, false},
+	} {
+		v, err := lookupSecret(secret.name, lookupEnv)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		*secret.dst = v
+
+		ref, ok := lookupEnv(secret.name + "_SECRET")
+		switch {
+		case !ok:
+		case !secret.managed:
+			problems = append(problems, fmt.Sprintf("Secret `%v` can't be fetched from a secret manager; use `%v` or `%v_FILE`.", secret.name, secret.name, secret.name))
+		case v != "":
+			problems = append(problems, fmt.Sprintf("Environment variable `%v_SECRET` is mutually exclusive with `%v` and `%v_FILE`; unset one of them.", secret.name, secret.name, secret.name))
+		default:
+			if cfg.SecretRefs == nil {
+				cfg.SecretRefs = make(map[string]string)
+			}
+			cfg.SecretRefs[secret.name] = ref
+		}
+	}
+
+	if len(cfg.SecretRefs) > 0 {
+		var err error
+		cfg.secrets, err = newSecretsProvider(cfg.SecretsProvider, lookupEnv)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		return Config{}, &ValidationError{Problems: problems}
+	}
+
+	return cfg, nil
+}
+
+// newSecretsProvider returns the secret manager client with the given name,
+// configured with the environment variables of its official tooling.
+func newSecretsProvider(name string, lookupEnv func(string) (string, bool)) (secrets.Provider, error) {
+	getenv := func(key string) string {
+		v, _ := lookupEnv(key)
+		return v
+	}
+
+	switch name {
+	case "vault":
+		token, err := lookupSecret("VAULT_TOKEN", lookupEnv)
+		if err != nil {
+			return nil, err
+		}
+		if getenv("VAULT_ADDR") == "" || token == "" {
+			return nil, errors.New("Secret manager `vault` requires the `VAULT_ADDR` and `VAULT_TOKEN` (or `VAULT_TOKEN_FILE`) environment variables.")
+		}
+		return secrets.VaultProvider{Addr: getenv("VAULT_ADDR"), Token: token}, nil
+	case "aws":
+		region := getenv("AWS_REGION")
+		if region == "" {
+			region = getenv("AWS_DEFAULT_REGION")
+		}
+		provider := secrets.AWSProvider{
+			Region:          region,
+			AccessKeyID:     getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    getenv("AWS_SESSION_TOKEN"),
+		}
+		if provider.Region == "" || provider.AccessKeyID == "" || provider.SecretAccessKey == "" {
+			return nil, errors.New("Secret manager `aws` requires the `AWS_REGION`, `AWS_ACCESS_KEY_ID` and `AWS_SECRET_ACCESS_KEY` environment variables.")
+		}
+		return provider, nil
+	case "":
+		return nil, errors.New("Environment variables with a `_SECRET` suffix require flag `-secretsProvider` to be set.")
+	default:
+		return nil, fmt.Errorf("Flag `-secretsProvider` is invalid (got: %q); allowed values are `vault` and `aws`.", name)
+	}
+}
+
+// FetchSecrets fetches the secrets in SecretRefs from the secret manager, and
+// sets them in cfg. The returned Watcher (nil if there are no SecretRefs) can
+// be used to pick up rotated secrets.
+func (cfg *Config) FetchSecrets(ctx context.Context) (*secrets.Watcher, error) {
+	if len(cfg.SecretRefs) == 0 {
+		return nil, nil
+	}
+
+	w, err := secrets.NewWatcher(ctx, cfg.secrets, cfg.SecretRefs)
+	if err != nil {
+		return nil, &ValidationError{Problems: []string{fmt.Sprintf("Could not fetch secrets from secret manager `%v`: %v.", cfg.SecretsProvider, err)}}
+	}
+	if _, ok := cfg.SecretRefs["POSTGRES_DSN"]; ok {
+		cfg.PostgresDSN = w.Get("POSTGRES_DSN")
+	}
+	if _, ok := cfg.SecretRefs["SIGNING_KEY"]; ok {
+		cfg.SigningKey = w.Get("SIGNING_KEY")
+	}
+
+	return w, nil
+}
+
+// lookupSecret returns the value of the environment variable name or, if
+// `{name}_FILE` is set instead, the contents of the file it refers to, e.g.
+// a mounted Kubernetes or Docker secret. Trailing newlines are trimmed.
+func lookupSecret(name string, lookupEnv func(string) (string, bool)) (string, error) {
+	v, ok := lookupEnv(name)
+	path, fromFile := lookupEnv(name + "_FILE")
+	switch {
+	case ok && fromFile:
+		return "", fmt.Errorf("Environment variables `%v` and `%v_FILE` are mutually exclusive; unset one of them.", name, name)
+	case !fromFile:
+		return v, nil
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Environment variable `%v_FILE` refers to an unreadable file: %v.", name, err)
+	}
+
+	return strings.TrimRight(string(buf), "\r\n"), nil
+}
+
+// readConfigFile reads a JSON object, and returns its values as strings, as
+// accepted by flag.Value.Set.
+func readConfigFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var obj map[string]interface{}
+	dec := json.NewDecoder(f)
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(obj))
+	for k, v := range obj {
+		switch v.(type) {
+		case string, bool, json.Number:
+			values[k] = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("value of %q must be a string, number or boolean", k)
+		}
+	}
+
+	return values, nil
+}
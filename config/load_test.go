@@ -0,0 +1,167 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnvName(t *testing.T) {
+	for flagName, exp := range map[string]string{
+		"addr":               "CTDIAG_ADDR",
+		"cacheInterval":      "CTDIAG_CACHE_INTERVAL",
+		"sloWebhookURL":      "CTDIAG_SLO_WEBHOOK_URL",
+		"maxUploadBatchSize": "CTDIAG_MAX_UPLOAD_BATCH_SIZE",
+	} {
+		if got := EnvName(flagName); got != exp {
+			t.Errorf("%v: expected: %v, got: %v", flagName, exp, got)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := ioutil.WriteFile(configFile, []byte(`{"addr": ":8080", "cacheInterval": "2m", "maxUploadBatchSize": 20, "dev": true}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"CTDIAG_CONFIG":         configFile,
+		"CTDIAG_CACHE_INTERVAL": "1m",
+		"CTDIAG_ADDR":           ":8081",
+		"POSTGRES_DSN":          "postgres://localhost/ct-diag",
+	}
+	lookupEnv := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+
+	cfg, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-addr", ":8082"}, lookupEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flag > environment > config file > default.
+	if exp := ":8082"; cfg.Addr != exp {
+		t.Errorf("addr: expected: %v, got: %v", exp, cfg.Addr)
+	}
+	if exp := time.Minute; cfg.CacheInterval != exp {
+		t.Errorf("cacheInterval: expected: %v, got: %v", exp, cfg.CacheInterval)
+	}
+	if exp := uint(20); cfg.MaxUploadBatchSize != exp {
+		t.Errorf("maxUploadBatchSize: expected: %v, got: %v", exp, cfg.MaxUploadBatchSize)
+	}
+	if !cfg.Dev {
+		t.Error("dev: expected: true, got: false")
+	}
+	if exp := 10 * time.Second; cfg.DBWriteTimeout != exp {
+		t.Errorf("dbWriteTimeout: expected: %v, got: %v", exp, cfg.DBWriteTimeout)
+	}
+	if exp := env["POSTGRES_DSN"]; cfg.PostgresDSN != exp {
+		t.Errorf("expected: %v, got: %v", exp, cfg.PostgresDSN)
+	}
+
+	t.Run("invalid values", func(t *testing.T) {
+		configFile := filepath.Join(t.TempDir(), "config.json")
+		err := ioutil.WriteFile(configFile, []byte(`{"dbReadTimeout": "soon", "cacheIntervall": "1m"}`), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env := map[string]string{"CTDIAG_DUPLICATE_FILTER": "maybe"}
+		lookupEnv := func(key string) (string, bool) {
+			v, ok := env[key]
+			return v, ok
+		}
+
+		_, err = Load(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", configFile}, lookupEnv)
+		verr, ok := err.(*ValidationError)
+		if !ok {
+			t.Fatalf("expected *ValidationError, got: %v", err)
+		}
+		if got, exp := len(verr.Problems), 3; got != exp {
+			t.Fatalf("expected: %v problems, got: %v (%v)", exp, got, verr)
+		}
+		for _, exp := range []string{"CTDIAG_DUPLICATE_FILTER", `"dbReadTimeout" in config file`, `Unknown key "cacheIntervall"`} {
+			if !strings.Contains(err.Error(), exp) {
+				t.Errorf("expected error to contain: %v", exp)
+			}
+		}
+	})
+}
+
+func TestLoadSecretsFromFiles(t *testing.T) {
+	dir := t.TempDir()
+	dsnFile := filepath.Join(dir, "dsn")
+	if err := ioutil.WriteFile(dsnFile, []byte("postgres://localhost/ct-diag\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"POSTGRES_DSN_FILE": dsnFile,
+		"ADMIN_TOKEN":       "s3cret",
+	}
+	lookupEnv := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+
+	cfg, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), nil, lookupEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "postgres://localhost/ct-diag"; cfg.PostgresDSN != exp {
+		t.Errorf("expected: %v, got: %v", exp, cfg.PostgresDSN)
+	}
+	if exp := "s3cret"; cfg.AdminToken != exp {
+		t.Errorf("expected: %v, got: %v", exp, cfg.AdminToken)
+	}
+
+	env["ADMIN_TOKEN_FILE"] = filepath.Join(dir, "token")
+	env["SIGNING_KEY_FILE"] = filepath.Join(dir, "missing")
+	_, err = Load(flag.NewFlagSet("test", flag.ContinueOnError), nil, lookupEnv)
+	for _, exp := range []string{"`ADMIN_TOKEN` and `ADMIN_TOKEN_FILE` are mutually exclusive", "`SIGNING_KEY_FILE` refers to an unreadable file"} {
+		if err == nil || !strings.Contains(err.Error(), exp) {
+			t.Errorf("expected error to contain: %v, got: %v", exp, err)
+		}
+	}
+}
+
+func TestLoadSecretRefs(t *testing.T) {
+	env := map[string]string{
+		"POSTGRES_DSN_SECRET": "secret/ct-diag#dsn",
+		"ADMIN_TOKEN_SECRET":  "secret/ct-diag#token",
+		"SIGNING_KEY":         "foobar",
+		"SIGNING_KEY_SECRET":  "secret/ct-diag#key",
+	}
+	lookupEnv := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+
+	_, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), nil, lookupEnv)
+	for _, exp := range []string{
+		"Secret `ADMIN_TOKEN` can't be fetched from a secret manager",
+		"`SIGNING_KEY_SECRET` is mutually exclusive",
+		"require flag `-secretsProvider` to be set",
+	} {
+		if err == nil || !strings.Contains(err.Error(), exp) {
+			t.Errorf("expected error to contain: %v, got: %v", exp, err)
+		}
+	}
+
+	delete(env, "ADMIN_TOKEN_SECRET")
+	delete(env, "SIGNING_KEY")
+	env["VAULT_ADDR"] = "http://localhost:8200"
+	env["VAULT_TOKEN"] = "s3cret"
+	cfg, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-secretsProvider", "vault"}, lookupEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "secret/ct-diag#dsn"; cfg.SecretRefs["POSTGRES_DSN"] != exp {
+		t.Errorf("expected: %v, got: %v", exp, cfg.SecretRefs["POSTGRES_DSN"])
+	}
+}
@@ -0,0 +1,61 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	amsterdam, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skip(err)
+	}
+	utc := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		expr string
+		loc  *time.Location
+		from string
+		exp  string
+	}{
+		{"* * * * *", nil, "2020-05-04T13:30:15Z", "2020-05-04T13:31:00Z"},
+		{"0 9 * * *", nil, "2020-05-04T08:59:00Z", "2020-05-04T09:00:00Z"},
+		{"0 9 * * *", nil, "2020-05-04T09:00:00Z", "2020-05-05T09:00:00Z"},
+		{"*/15 * * * *", nil, "2020-05-04T13:31:00Z", "2020-05-04T13:45:00Z"},
+		{"5 0-6/2 * * *", nil, "2020-05-04T03:00:00Z", "2020-05-04T04:05:00Z"},
+		{"0 0 * * 7", nil, "2020-05-04T00:00:00Z", "2020-05-10T00:00:00Z"},
+		{"0 0 1 * 1", nil, "2020-05-04T00:00:00Z", "2020-05-11T00:00:00Z"},
+		{"0 0 29 2 *", nil, "2020-03-01T00:00:00Z", "2024-02-29T00:00:00Z"},
+		{"@daily", nil, "2020-12-31T23:59:00Z", "2021-01-01T00:00:00Z"},
+		// 09:00 in Amsterdam is 07:00 UTC in summer, and 08:00 UTC in winter.
+		{"0 9 * * *", amsterdam, "2020-05-04T08:00:00Z", "2020-05-05T07:00:00Z"},
+		{"0 9 * * *", amsterdam, "2020-12-01T00:00:00Z", "2020-12-01T08:00:00Z"},
+		// 02:30 doesn't exist on the day DST starts, so that day is skipped.
+		{"30 2 * * *", amsterdam, "2020-03-28T12:00:00Z", "2020-03-30T00:30:00Z"},
+		{"0 0 30 2 *", nil, "2020-01-01T00:00:00Z", "0001-01-01T00:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		s, err := Parse(tt.expr, tt.loc)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.expr, err)
+		}
+		if got, exp := s.Next(utc(tt.from)), utc(tt.exp); !got.Equal(exp) {
+			t.Errorf("%v from %v: expected: %v, got: %v", tt.expr, tt.from, exp, got.UTC())
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *", "@yearly"} {
+		if _, err := Parse(expr, nil); err == nil {
+			t.Errorf("%q: expected error", expr)
+		}
+	}
+}
@@ -3,35 +3,154 @@
 package postgres
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/dstotijn/ct-diag-server/diag"
 
-	// Register pq for use via database/sql.
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+// maxTxAttempts is the maximum amount of attempts for a transaction that
+// fails due to a serialization failure or deadlock.
+const maxTxAttempts = 3
+
+// connMaxLifetime is the maximum lifetime of connections when the DSN is
+// rotated (see Config.DSNSource).
+const connMaxLifetime = 30 * time.Minute
+
 // Client implements diag.Repository.
 type Client struct {
-	db                *sql.DB
-	lastKnownKeyCount int
+	db                 *sql.DB
+	logger             diag.Logger
+	slowQueryThreshold time.Duration
+	partitionInterval  PartitionInterval
+	readTimeout        time.Duration
+	writeTimeout       time.Duration
+	batchSize          int
+	regions            bool
+	reportTypes        bool
+	rollingPeriods     bool
+	publicationTimes   bool
+
+	partitionsMu sync.Mutex
+	partitions   map[string]bool
+}
+
+// Config represents the configuration to create a Client.
+type Config struct {
+	DSN string
+	// DSNSource, if set, is used instead of DSN for every new connection, so
+	// rotated credentials (e.g. from a secret manager) are picked up without
+	// a restart. Connections are then recycled after connMaxLifetime.
+	DSNSource func() string
+	Logger    diag.Logger
+	// SlowQueryThreshold is the duration after which a repository operation
+	// is logged as slow. Zero disables slow query logging.
+	SlowQueryThreshold time.Duration
+	// PartitionInterval must match the schema of the `diagnosis_keys` table:
+	// PartitionNone for `schema.sql`, else `schema_partitioned.sql`.
+	PartitionInterval PartitionInterval
+	// ReadTimeout and WriteTimeout limit the duration of read and write
+	// operations, both client side (context deadline) and server side
+	// (`statement_timeout`). Zero means no timeout. Purging expired keys is
+	// not subject to WriteTimeout.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// BatchSize is the maximum amount of keys per bulk insert, see
+	// StoreDiagnosisKeys. Zero means defaultBatchSize.
+	BatchSize int
+	// Regions enables storing and reading the regions of Diagnosis Keys. It
+	// requires the `regions` column, see `migrations/005_regions.sql`.
+	Regions bool
+	// ReportTypes enables storing and reading the report types and days since
+	// onset of symptoms of Diagnosis Keys. It requires the columns of
+	// `migrations/007_report_types.sql`.
+	ReportTypes bool
+	// RollingPeriods enables storing and reading the rolling periods of
+	// Diagnosis Keys. It requires the column of
+	// `migrations/008_rolling_period.sql`.
+	RollingPeriods bool
+	// PublicationTimes enables storing the publication times of keys that
+	// were stored late (see diag.PublicationTimeStorer), and listing keys at
+	// them. It requires the column of `migrations/011_published_at.sql`.
+	PublicationTimes bool
 }
 
 // New returns a new Client.
-func New(dsn string) (*Client, error) {
-	db, err := sql.Open("postgres", dsn)
-	if err != nil {
-		return nil, err
+func New(cfg Config) (*Client, error) {
+	var db *sql.DB
+	if cfg.DSNSource != nil {
+		db = sql.OpenDB(rotatingConnector{dsn: cfg.DSNSource})
+		db.SetConnMaxLifetime(connMaxLifetime)
+	} else {
+		var err error
+		db, err = sql.Open("postgres", cfg.DSN)
+		if err != nil {
+			return nil, err
+		}
 	}
 	db.SetMaxIdleConns(5)
 	db.SetMaxOpenConns(30)
+	registerDBStats(db)
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = diag.NewNopLogger()
+	}
 
-	return &Client{db: db}, nil
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	return &Client{
+		db:                 db,
+		logger:             logger,
+		slowQueryThreshold: cfg.SlowQueryThreshold,
+		partitionInterval:  cfg.PartitionInterval,
+		readTimeout:        cfg.ReadTimeout,
+		writeTimeout:       cfg.WriteTimeout,
+		batchSize:          batchSize,
+		regions:            cfg.Regions,
+		reportTypes:        cfg.ReportTypes,
+		rollingPeriods:     cfg.RollingPeriods,
+		publicationTimes:   cfg.PublicationTimes,
+		partitions:         make(map[string]bool),
+	}, nil
+}
+
+// rotatingConnector is a driver.Connector using the current DSN for every new
+// connection.
+type rotatingConnector struct {
+	dsn func() string
+}
+
+func (rc rotatingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	connector, err := pq.NewConnector(rc.dsn())
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+func (rc rotatingConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+// ValidateDSN checks if dsn is a valid connection string (URL or key/value
+// format), without connecting to the database.
+func ValidateDSN(dsn string) error {
+	if _, err := pq.NewConnector(dsn); err != nil {
+		return fmt.Errorf("postgres: invalid DSN: %v", err)
+	}
+	return nil
 }
 
 // Ping uses the underlying database client to for check connectivity.
@@ -39,85 +158,272 @@ func (c *Client) Ping() error {
 	return c.db.Ping()
 }
 
+// PingContext checks connectivity like Ping, subject to the deadline of ctx.
+// It implements diag.Pinger.
+func (c *Client) PingContext(ctx context.Context) error {
+	return c.db.PingContext(ctx)
+}
+
 // Close uses the underlying database client to close all connections.
 func (c *Client) Close() error {
 	return c.db.Close()
 }
 
-// StoreDiagnosisKeys persists an array of diagnosis keys in the database.
+// StoreDiagnosisKeys persists an array of diagnosis keys in the database, using
+// `COPY` in batches of Config.BatchSize keys. Transactions that fail due to a
+// serialization failure or deadlock are retried.
 func (c *Client) StoreDiagnosisKeys(ctx context.Context, diagKeys []diag.DiagnosisKey, uploadedAt time.Time) error {
+	_, err := c.storeDiagnosisKeysWithRetry(ctx, diagKeys, uploadedAt, "")
+	return err
+}
+
+// InsertDiagnosisKeys implements diag.KeyInserter.
+func (c *Client) InsertDiagnosisKeys(ctx context.Context, diagKeys []diag.DiagnosisKey, uploadedAt time.Time) ([]diag.DiagnosisKey, error) {
+	return c.storeDiagnosisKeysWithRetry(ctx, diagKeys, uploadedAt, "")
+}
+
+// StoreDiagnosisKeysWithOrigin implements diag.OriginStorer. Keys without
+// origin (an empty origin) are stored without touching the `origin` column,
+// so the migration adding it (see `migrations`) is only required for
+// federation.
+func (c *Client) StoreDiagnosisKeysWithOrigin(ctx context.Context, diagKeys []diag.DiagnosisKey, uploadedAt time.Time, origin string) error {
+	_, err := c.storeDiagnosisKeysWithRetry(ctx, diagKeys, uploadedAt, origin)
+	return err
+}
+
+// storeDiagnosisKeysWithRetry stores diagKeys, retrying transactions that
+// fail due to a serialization failure or deadlock, and returns the keys that
+// weren't stored yet.
+func (c *Client) storeDiagnosisKeysWithRetry(ctx context.Context, diagKeys []diag.DiagnosisKey, uploadedAt time.Time, origin string) (inserted []diag.DiagnosisKey, err error) {
 	if len(diagKeys) == 0 {
-		return diag.ErrNilDiagKeys
+		return nil, diag.ErrNilDiagKeys
 	}
 
 	if uploadedAt.IsZero() {
-		return errors.New("postgres: uploadedAt cannot be zero")
+		return nil, errors.New("postgres: uploadedAt cannot be zero")
+	}
+
+	start := time.Now()
+	defer func() { c.observe(opStoreDiagnosisKeys, start, len(diagKeys), err) }()
+
+	if c.partitionInterval != PartitionNone {
+		if err := c.ensurePartition(ctx, uploadedAt); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		inserted, err = c.storeDiagnosisKeys(ctx, diagKeys, uploadedAt, origin)
+		if err == nil || attempt == maxTxAttempts || !isRetryable(err) {
+			return inserted, err
+		}
+		txRetries.Inc(opStoreDiagnosisKeys)
 	}
+}
 
-	tx, err := c.db.BeginTx(ctx, nil)
+func (c *Client) storeDiagnosisKeys(ctx context.Context, diagKeys []diag.DiagnosisKey, uploadedAt time.Time, origin string) ([]diag.DiagnosisKey, error) {
+	ctx, cancel := withTimeout(ctx, c.writeTimeout)
+	defer cancel()
+
+	tx, err := c.beginTx(ctx, c.writeTimeout, false)
 	if err != nil {
-		return fmt.Errorf("postgres: could not start transaction: %v", err)
+		return nil, err
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.PrepareContext(ctx, `INSERT INTO diagnosis_keys (temporary_exposure_key, rolling_start_number, transmission_risk_level, uploaded_at) VALUES ($1, $2, $3, $4)
-	ON CONFLICT ON CONSTRAINT diagnosis_keys_pkey DO NOTHING`)
+	if c.partitionInterval != PartitionNone {
+		if err := lockDiagnosisKeys(ctx, tx, diagKeys); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.regions {
+		if err := mergeRegions(ctx, tx, diagKeys); err != nil {
+			return nil, err
+		}
+	}
+
+	diagKeys, err = newDiagnosisKeys(ctx, tx, diagKeys)
+	if err != nil {
+		return nil, err
+	}
+	// Without new keys, the transaction is still committed, as regions may
+	// have been merged.
+	var inserted []diag.DiagnosisKey
+	if len(diagKeys) > 0 {
+		if inserted, err = c.insertDiagnosisKeys(ctx, tx, diagKeys, uploadedAt, origin); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("postgres: cannot commit transaction: %w", err)
+	}
+
+	return inserted, nil
+}
+
+// newDiagnosisKeys returns the keys of diagKeys that aren't stored yet, using
+// a single `ANY($1)` query, so batches with a high duplicate rate (e.g. client
+// retries) don't cost an insert per key. The lookup is an index-only scan of
+// `diagnosis_keys_pkey`, which leads with `temporary_exposure_key` in both
+// schemas. Duplicates within diagKeys are dropped as well. Keys stored
+// concurrently are still handled by the insert statement.
+func newDiagnosisKeys(ctx context.Context, tx *sql.Tx, diagKeys []diag.DiagnosisKey) ([]diag.DiagnosisKey, error) {
+	keys := make(pq.ByteaArray, len(diagKeys))
+	for i := range diagKeys {
+		keys[i] = diagKeys[i].TemporaryExposureKey[:]
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT temporary_exposure_key FROM diagnosis_keys WHERE temporary_exposure_key = ANY($1)`, keys)
 	if err != nil {
-		return fmt.Errorf("postgres: could not prepare statement: %v", err)
+		return nil, fmt.Errorf("postgres: could not execute query: %w", err)
 	}
-	defer stmt.Close()
+	defer rows.Close()
 
+	stored := make(map[[16]byte]bool)
+	for rows.Next() {
+		var key []byte
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("postgres: could not scan row: %w", err)
+		}
+		var tek [16]byte
+		copy(tek[:], key)
+		stored[tek] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: could not iterate over rows: %w", err)
+	}
+
+	newKeys := make([]diag.DiagnosisKey, 0, len(diagKeys))
 	for _, diagKey := range diagKeys {
-		_, err = stmt.ExecContext(ctx,
-			diagKey.TemporaryExposureKey[:],
-			diagKey.RollingStartNumber,
-			diagKey.TransmissionRiskLevel,
-			uploadedAt,
-		)
-		if err != nil {
-			return fmt.Errorf("postgres: could not execute statement: %v", err)
+		if stored[diagKey.TemporaryExposureKey] {
+			continue
 		}
+		stored[diagKey.TemporaryExposureKey] = true
+		newKeys = append(newKeys, diagKey)
 	}
+	duplicateKeys.Add(float64(len(diagKeys) - len(newKeys)))
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("postgres: cannot commit transaction: %v", err)
+	return newKeys, nil
+}
+
+// mergeRegions adds the regions of diagKeys to the regions of the keys that
+// are stored already, e.g. when a border resident's key is uploaded for a
+// second region. The union is sorted, and rows whose regions already contain
+// those of the upload aren't written.
+func mergeRegions(ctx context.Context, tx *sql.Tx, diagKeys []diag.DiagnosisKey) error {
+	regions := make(map[[16]byte][]string)
+	var keys [][16]byte
+	for _, diagKey := range diagKeys {
+		if len(diagKey.Regions) == 0 {
+			continue
+		}
+		if _, ok := regions[diagKey.TemporaryExposureKey]; !ok {
+			keys = append(keys, diagKey.TemporaryExposureKey)
+		}
+		regions[diagKey.TemporaryExposureKey] = append(regions[diagKey.TemporaryExposureKey], diagKey.Regions...)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	teks := make(pq.ByteaArray, len(keys))
+	joined := make(pq.StringArray, len(keys))
+	for i := range keys {
+		teks[i] = keys[i][:]
+		joined[i] = strings.Join(regions[keys[i]], ",")
 	}
 
+	_, err := tx.ExecContext(ctx, `UPDATE diagnosis_keys d
+	SET regions = array(SELECT DISTINCT unnest(d.regions || string_to_array(u.regions, ',')) ORDER BY 1)
+	FROM unnest($1::bytea[], $2::text[]) AS u(temporary_exposure_key, regions)
+	WHERE d.temporary_exposure_key = u.temporary_exposure_key
+	AND NOT coalesce(d.regions @> string_to_array(u.regions, ','), false)`, teks, joined)
+	if err != nil {
+		return fmt.Errorf("postgres: could not merge regions: %w", err)
+	}
 	return nil
 }
 
-// FindAllDiagnosisKeys finds all the Diagnosis Keys and returns them in their
-// binary representation in a buffer.
-func (c *Client) FindAllDiagnosisKeys(ctx context.Context) ([]byte, error) {
+// FindAllDiagnosisKeys finds all the Diagnosis Keys, ordered by upload.
+func (c *Client) FindAllDiagnosisKeys(ctx context.Context) (_ []diag.DiagnosisKey, err error) {
+	var rowCount int
+	start := time.Now()
+	defer func() { c.observe(opFindAllDiagnosisKeys, start, rowCount, err) }()
+
 	// Reduce the amount of allocs by anticipating the needed slice capacity.
-	buf := bytes.NewBuffer(make([]byte, 0, c.lastKnownKeyCount*diag.DiagnosisKeySize))
+	// The estimate is only a hint, so errors are ignored.
+	estimate, _ := c.EstimateKeyCount(ctx)
+	diagKeys := make([]diag.DiagnosisKey, 0, estimate)
 
-	query := `SELECT temporary_exposure_key, rolling_start_number, transmission_risk_level
+	columns := ""
+	if c.regions {
+		columns += ", regions"
+	}
+	if c.reportTypes {
+		columns += ", report_type, days_since_onset_of_symptoms"
+	}
+	if c.rollingPeriods {
+		columns += ", rolling_period"
+	}
+	// Keys that were stored late are listed at their publication time.
+	uploadedAt := "uploaded_at"
+	if c.publicationTimes {
+		uploadedAt = "coalesce(published_at, uploaded_at)"
+	}
+	query := `SELECT temporary_exposure_key, rolling_start_number, transmission_risk_level, ` + uploadedAt + columns + `
 	FROM diagnosis_keys
 	ORDER BY index ASC`
 
-	rows, err := c.db.QueryContext(ctx, query)
+	ctx, cancel := withTimeout(ctx, c.readTimeout)
+	defer cancel()
+
+	tx, err := c.beginTx(ctx, c.readTimeout, true)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("postgres: could not execute query: %v", err)
 	}
 	defer rows.Close()
 
-	var rowCount int
 	for rows.Next() {
 		rowCount++
 		var diagKey diag.DiagnosisKey
 		key := diagKey.TemporaryExposureKey[:0]
-		err := rows.Scan(&key, &diagKey.RollingStartNumber, &diagKey.TransmissionRiskLevel)
-		if err != nil {
+		dest := []interface{}{&key, &diagKey.RollingStartNumber, &diagKey.TransmissionRiskLevel, &diagKey.UploadedAt}
+		var regions pq.StringArray
+		if c.regions {
+			dest = append(dest, &regions)
+		}
+		var reportType sql.NullInt32
+		var days sql.NullInt32
+		if c.reportTypes {
+			dest = append(dest, &reportType, &days)
+		}
+		var rollingPeriod sql.NullInt32
+		if c.rollingPeriods {
+			dest = append(dest, &rollingPeriod)
+		}
+		if err := rows.Scan(dest...); err != nil {
 			return nil, fmt.Errorf("postgres: could not scan row: %v", err)
 		}
 		copy(diagKey.TemporaryExposureKey[:], key)
 		diagKey.UploadedAt = diagKey.UploadedAt.In(time.UTC)
-
-		err = diag.WriteDiagnosisKeys(buf, diagKey)
-		if err != nil {
-			return nil, fmt.Errorf("postgres: could not write to buffer: %v", err)
+		if len(regions) > 0 {
+			diagKey.Regions = regions
+		}
+		diagKey.ReportType = diag.ReportType(reportType.Int32)
+		if days.Valid {
+			diagKey.DaysSinceOnsetOfSymptoms = &days.Int32
 		}
+		diagKey.RollingPeriod = uint8(rollingPeriod.Int32)
+
+		diagKeys = append(diagKeys, diagKey)
 	}
 	rows.Close()
 
@@ -125,17 +431,128 @@ func (c *Client) FindAllDiagnosisKeys(ctx context.Context) ([]byte, error) {
 		return nil, fmt.Errorf("postgres: could not iterate over rows: %v", err)
 	}
 
-	c.lastKnownKeyCount = rowCount
+	rowsScanned.Add(float64(rowCount), opFindAllDiagnosisKeys)
+
+	return diagKeys, nil
+}
+
+// FindLocalDiagnosisKeys finds at most limit Diagnosis Keys without origin,
+// i.e. uploaded to this server, with an index greater than after, ordered by
+// index.
+func (c *Client) FindLocalDiagnosisKeys(ctx context.Context, after int64, limit int) (_ []diag.StoredDiagnosisKey, err error) {
+	var rowCount int
+	start := time.Now()
+	defer func() { c.observe(opFindLocalDiagnosisKeys, start, rowCount, err) }()
+
+	query := `SELECT temporary_exposure_key, rolling_start_number, transmission_risk_level, uploaded_at, index
+	FROM diagnosis_keys
+	WHERE origin IS NULL AND index > $1
+	ORDER BY index ASC
+	LIMIT $2`
+
+	ctx, cancel := withTimeout(ctx, c.readTimeout)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, query, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: could not execute query: %v", err)
+	}
+	defer rows.Close()
+
+	var diagKeys []diag.StoredDiagnosisKey
+	for rows.Next() {
+		rowCount++
+		var diagKey diag.StoredDiagnosisKey
+		key := diagKey.TemporaryExposureKey[:0]
+		err := rows.Scan(&key, &diagKey.RollingStartNumber, &diagKey.TransmissionRiskLevel, &diagKey.UploadedAt, &diagKey.Index)
+		if err != nil {
+			return nil, fmt.Errorf("postgres: could not scan row: %v", err)
+		}
+		copy(diagKey.TemporaryExposureKey[:], key)
+		diagKey.UploadedAt = diagKey.UploadedAt.In(time.UTC)
+
+		diagKeys = append(diagKeys, diagKey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: could not iterate over rows: %v", err)
+	}
+
+	rowsScanned.Add(float64(rowCount), opFindLocalDiagnosisKeys)
 
-	return buf.Bytes(), nil
+	return diagKeys, nil
 }
 
-// LastModified returns the timestamp of the latest uploaded Diagnosis Key.
-func (c *Client) LastModified(ctx context.Context) (time.Time, error) {
+// FindDiagnosisKeyByTEK finds the Diagnosis Key with the given Temporary
+// Exposure Key. If it isn't stored, diag.ErrKeyNotFound is returned.
+func (c *Client) FindDiagnosisKeyByTEK(ctx context.Context, tek [16]byte) (_ diag.StoredDiagnosisKey, err error) {
+	start := time.Now()
+	defer func() { c.observe(opFindDiagnosisKeyByTEK, start, 1, err) }()
+
+	query := `SELECT rolling_start_number, transmission_risk_level, uploaded_at, index
+	FROM diagnosis_keys
+	WHERE temporary_exposure_key = $1`
+
+	ctx, cancel := withTimeout(ctx, c.readTimeout)
+	defer cancel()
+
+	diagKey := diag.StoredDiagnosisKey{DiagnosisKey: diag.DiagnosisKey{TemporaryExposureKey: tek}}
+	err = c.db.QueryRowContext(ctx, query, tek[:]).Scan(&diagKey.RollingStartNumber, &diagKey.TransmissionRiskLevel, &diagKey.UploadedAt, &diagKey.Index)
+	if err == sql.ErrNoRows {
+		return diag.StoredDiagnosisKey{}, diag.ErrKeyNotFound
+	}
+	if err != nil {
+		return diag.StoredDiagnosisKey{}, fmt.Errorf("postgres: could not execute query: %v", err)
+	}
+	diagKey.UploadedAt = diagKey.UploadedAt.In(time.UTC)
+
+	return diagKey, nil
+}
+
+// EstimateKeyCount returns an estimate of the amount of stored Diagnosis Keys,
+// based on the planner statistics of the `diagnosis_keys` table (and its
+// partitions), as of the last vacuum or analyze. It's cheap compared to an
+// exact count, which requires a full table scan.
+func (c *Client) EstimateKeyCount(ctx context.Context) (n int64, err error) {
+	start := time.Now()
+	defer func() { c.observe(opEstimateKeyCount, start, 1, err) }()
+
+	// A never analyzed table has `reltuples` -1 (PostgreSQL 14+) or 0, and
+	// the parent of a partitioned table always has -1 or 0.
+	query := `SELECT COALESCE(SUM(GREATEST(reltuples, 0)), 0)::bigint
+	FROM pg_class
+	WHERE oid = 'diagnosis_keys'::regclass
+	OR oid IN (SELECT inhrelid FROM pg_inherits WHERE inhparent = 'diagnosis_keys'::regclass)`
+
+	ctx, cancel := withTimeout(ctx, c.readTimeout)
+	defer cancel()
+
+	if err := c.db.QueryRowContext(ctx, query).Scan(&n); err != nil {
+		return 0, fmt.Errorf("postgres: could not execute query: %v", err)
+	}
+
+	return n, nil
+}
+
+// LastModified returns the latest upload time of the Diagnosis Keys. Keys
+// imported later with an older upload time (e.g. federation backfill) don't
+// move it backwards.
+func (c *Client) LastModified(ctx context.Context) (_ time.Time, err error) {
+	start := time.Now()
+	defer func() { c.observe(opLastModified, start, 1, err) }()
+
 	var lastModified time.Time
-	query := `SELECT uploaded_at FROM diagnosis_keys ORDER BY index DESC LIMIT 1`
+	query := `SELECT uploaded_at FROM diagnosis_keys ORDER BY uploaded_at DESC LIMIT 1`
+
+	ctx, cancel := withTimeout(ctx, c.readTimeout)
+	defer cancel()
 
-	err := c.db.QueryRowContext(ctx, query).Scan(&lastModified)
+	tx, err := c.beginTx(ctx, c.readTimeout, true)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query).Scan(&lastModified)
 	if err == sql.ErrNoRows {
 		return time.Time{}, diag.ErrNilDiagKeys
 	}
@@ -145,3 +562,133 @@ func (c *Client) LastModified(ctx context.Context) (time.Time, error) {
 
 	return lastModified, nil
 }
+
+// DeleteDiagnosisKeysBefore deletes all Diagnosis Keys uploaded before t, and
+// returns the amount of deleted keys. For a partitioned table, expired
+// partitions are dropped, which is much cheaper than deleting rows.
+func (c *Client) DeleteDiagnosisKeysBefore(ctx context.Context, t time.Time) (n int64, err error) {
+	start := time.Now()
+	defer func() { c.observe(opDeleteDiagnosisKeys, start, int(n), err) }()
+
+	if c.partitionInterval != PartitionNone {
+		n, err = c.dropPartitionsBefore(ctx, t)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	// For a partitioned table, this only affects the partition containing t.
+	res, err := c.db.ExecContext(ctx, `DELETE FROM diagnosis_keys WHERE uploaded_at < $1`, t)
+	if err != nil {
+		return n, fmt.Errorf("postgres: could not execute query: %v", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return n, fmt.Errorf("postgres: could not get affected rows: %v", err)
+	}
+
+	return n + deleted, nil
+}
+
+// DeleteDiagnosisKeys implements diag.KeyDeleter: it deletes the Diagnosis
+// Keys with the given Temporary Exposure Keys that were uploaded in the second
+// of uploadedAt, e.g. for a withdrawn upload, and returns the deleted keys.
+func (c *Client) DeleteDiagnosisKeys(ctx context.Context, keys [][16]byte, uploadedAt time.Time) (deleted [][16]byte, err error) {
+	start := time.Now()
+	defer func() { c.observe(opDeleteDiagnosisKeysByTEK, start, len(deleted), err) }()
+
+	teks := make(pq.ByteaArray, len(keys))
+	for i := range keys {
+		teks[i] = keys[i][:]
+	}
+	from := uploadedAt.Truncate(time.Second)
+
+	ctx, cancel := withTimeout(ctx, c.writeTimeout)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, `DELETE FROM diagnosis_keys
+	WHERE temporary_exposure_key = ANY($1) AND uploaded_at >= $2 AND uploaded_at < $3
+	RETURNING temporary_exposure_key`, teks, from, from.Add(time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("postgres: could not execute query: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tek []byte
+		if err := rows.Scan(&tek); err != nil {
+			return nil, fmt.Errorf("postgres: could not scan row: %v", err)
+		}
+		var key [16]byte
+		copy(key[:], tek)
+		deleted = append(deleted, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: could not iterate over rows: %v", err)
+	}
+
+	return deleted, nil
+}
+
+// StorePublicationTime implements diag.PublicationTimeStorer. If
+// Config.PublicationTimes isn't set, diag.ErrPublicationTimesUnsupported is
+// returned.
+func (c *Client) StorePublicationTime(ctx context.Context, keys [][16]byte, publishedAt time.Time) (err error) {
+	if !c.publicationTimes {
+		return diag.ErrPublicationTimesUnsupported
+	}
+
+	start := time.Now()
+	defer func() { c.observe(opStorePublicationTime, start, len(keys), err) }()
+
+	teks := make(pq.ByteaArray, len(keys))
+	for i := range keys {
+		teks[i] = keys[i][:]
+	}
+
+	ctx, cancel := withTimeout(ctx, c.writeTimeout)
+	defer cancel()
+
+	_, err = c.db.ExecContext(ctx, `UPDATE diagnosis_keys SET published_at = $2 WHERE temporary_exposure_key = ANY($1)`, teks, publishedAt)
+	if err != nil {
+		return fmt.Errorf("postgres: could not execute query: %v", err)
+	}
+
+	return nil
+}
+
+// beginTx starts a transaction, with a statement timeout if timeout is non zero.
+func (c *Client) beginTx(ctx context.Context, timeout time.Duration, readOnly bool) (*sql.Tx, error) {
+	tx, err := c.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: readOnly})
+	if err != nil {
+		return nil, fmt.Errorf("postgres: could not start transaction: %w", err)
+	}
+
+	if timeout > 0 {
+		_, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout.Milliseconds()))
+		if err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("postgres: could not set statement timeout: %w", err)
+		}
+	}
+
+	return tx, nil
+}
+
+// withTimeout returns a copy of ctx with a timeout, if timeout is non zero.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// isRetryable returns true if err is caused by a serialization failure or a
+// deadlock, in which case the transaction can safely be retried.
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == "40001" || pqErr.Code == "40P01"
+}
@@ -1,9 +1,9 @@
 package postgres
 
 import (
-	"bytes"
 	"context"
 	"crypto/rand"
+	"database/sql"
 	"log"
 	"os"
 	"reflect"
@@ -18,7 +18,7 @@ var client *Client
 func TestMain(m *testing.M) {
 	var err error
 
-	client, err = New(os.Getenv("POSTGRES_DSN"))
+	client, err = New(Config{DSN: os.Getenv("POSTGRES_DSN")})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -152,6 +152,345 @@ func TestStoreDiagnosisKeys(t *testing.T) {
 	}
 }
 
+func TestStoreDiagnosisKeysAlreadyStored(t *testing.T) {
+	ctx := context.Background()
+	if _, err := client.db.ExecContext(ctx, "TRUNCATE diagnosis_keys"); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := diag.DiagnosisKey{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: 42, TransmissionRiskLevel: 50}
+	uploadedAt := time.Unix(42, 0).UTC()
+	if err := client.StoreDiagnosisKeys(ctx, []diag.DiagnosisKey{stored}, uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	// All keys of a retried upload are skipped by the existence check.
+	before := duplicateKeys.Value()
+	if err := client.StoreDiagnosisKeys(ctx, []diag.DiagnosisKey{stored}, uploadedAt.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if got := duplicateKeys.Value() - before; got != 1 {
+		t.Errorf("expected 1 duplicate key, got: %v", got)
+	}
+
+	// New keys are stored, already stored keys keep their upload time.
+	newKey := diag.DiagnosisKey{TemporaryExposureKey: [16]byte{2}, RollingStartNumber: 43, TransmissionRiskLevel: 50}
+	if err := client.StoreDiagnosisKeys(ctx, []diag.DiagnosisKey{stored, newKey}, uploadedAt.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := client.FindAllDiagnosisKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored.UploadedAt = uploadedAt
+	newKey.UploadedAt = uploadedAt.Add(time.Hour)
+	if exp := []diag.DiagnosisKey{stored, newKey}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected: %#v, got: %#v", exp, got)
+	}
+}
+
+func TestInsertAndDeleteDiagnosisKeys(t *testing.T) {
+	ctx := context.Background()
+	regionsClient, err := New(Config{DSN: os.Getenv("POSTGRES_DSN"), Regions: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer regionsClient.Close()
+
+	for name, client := range map[string]*Client{"default": client, "regions": regionsClient} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := client.db.ExecContext(ctx, "TRUNCATE diagnosis_keys"); err != nil {
+				t.Fatal(err)
+			}
+
+			uploadedAt := time.Unix(42, 0).UTC()
+			stored := diag.DiagnosisKey{TemporaryExposureKey: [16]byte{1}, TransmissionRiskLevel: 50}
+			if err := client.StoreDiagnosisKeys(ctx, []diag.DiagnosisKey{stored}, uploadedAt); err != nil {
+				t.Fatal(err)
+			}
+
+			// Only keys that weren't stored yet are returned.
+			uploaded := diag.DiagnosisKey{TemporaryExposureKey: [16]byte{2}, TransmissionRiskLevel: 50}
+			got, err := client.InsertDiagnosisKeys(ctx, []diag.DiagnosisKey{stored, uploaded}, uploadedAt.Add(time.Minute+time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exp := []diag.DiagnosisKey{uploaded}; !reflect.DeepEqual(got, exp) {
+				t.Errorf("expected: %+v, got: %+v", exp, got)
+			}
+
+			// Only keys uploaded in the second of the given upload time are
+			// deleted.
+			keys := [][16]byte{stored.TemporaryExposureKey, uploaded.TemporaryExposureKey}
+			deleted, err := client.DeleteDiagnosisKeys(ctx, keys, uploadedAt.Add(time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exp := [][16]byte{uploaded.TemporaryExposureKey}; !reflect.DeepEqual(deleted, exp) {
+				t.Errorf("expected: %v, got: %v", exp, deleted)
+			}
+
+			diagKeys, err := client.FindAllDiagnosisKeys(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(diagKeys) != 1 || diagKeys[0].TemporaryExposureKey != stored.TemporaryExposureKey {
+				t.Errorf("expected only stored key, got: %+v", diagKeys)
+			}
+		})
+	}
+}
+
+func TestStoreDiagnosisKeysWithOrigin(t *testing.T) {
+	ctx := context.Background()
+	if _, err := client.db.ExecContext(ctx, "TRUNCATE diagnosis_keys"); err != nil {
+		t.Fatal(err)
+	}
+
+	uploadedAt := time.Unix(42, 0).UTC()
+	uploaded := diag.DiagnosisKey{TemporaryExposureKey: [16]byte{1}, TransmissionRiskLevel: 50}
+	if err := client.StoreDiagnosisKeys(ctx, []diag.DiagnosisKey{uploaded}, uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+	// Already stored keys keep their origin.
+	pulled := diag.DiagnosisKey{TemporaryExposureKey: [16]byte{2}, TransmissionRiskLevel: 50}
+	if err := client.StoreDiagnosisKeysWithOrigin(ctx, []diag.DiagnosisKey{uploaded, pulled}, uploadedAt, "DE"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		key [16]byte
+		exp sql.NullString
+	}{
+		{uploaded.TemporaryExposureKey, sql.NullString{}},
+		{pulled.TemporaryExposureKey, sql.NullString{String: "DE", Valid: true}},
+	} {
+		var got sql.NullString
+		if err := client.db.QueryRowContext(ctx, `SELECT origin FROM diagnosis_keys WHERE temporary_exposure_key = $1`, tt.key[:]).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.exp {
+			t.Errorf("expected: %+v, got: %+v", tt.exp, got)
+		}
+	}
+}
+
+func TestStoreDiagnosisKeysWithRegions(t *testing.T) {
+	ctx := context.Background()
+	if _, err := client.db.ExecContext(ctx, "TRUNCATE diagnosis_keys"); err != nil {
+		t.Fatal(err)
+	}
+	regionsClient, err := New(Config{DSN: os.Getenv("POSTGRES_DSN"), Regions: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer regionsClient.Close()
+
+	uploadedAt := time.Unix(42, 0).UTC()
+	diagKeys := []diag.DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, TransmissionRiskLevel: 50, UploadedAt: uploadedAt, Regions: []string{"NL", "BE"}},
+		{TemporaryExposureKey: [16]byte{2}, TransmissionRiskLevel: 50, UploadedAt: uploadedAt},
+	}
+	if err := regionsClient.StoreDiagnosisKeys(ctx, diagKeys, uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := regionsClient.FindAllDiagnosisKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, diagKeys) {
+		t.Errorf("expected: %+v, got: %+v", diagKeys, got)
+	}
+}
+
+func TestStoreDiagnosisKeysMergesRegions(t *testing.T) {
+	ctx := context.Background()
+	if _, err := client.db.ExecContext(ctx, "TRUNCATE diagnosis_keys"); err != nil {
+		t.Fatal(err)
+	}
+	regionsClient, err := New(Config{DSN: os.Getenv("POSTGRES_DSN"), Regions: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer regionsClient.Close()
+
+	uploadedAt := time.Unix(42, 0).UTC()
+	tagged := diag.DiagnosisKey{TemporaryExposureKey: [16]byte{1}, TransmissionRiskLevel: 50, Regions: []string{"NL"}}
+	untagged := diag.DiagnosisKey{TemporaryExposureKey: [16]byte{2}, TransmissionRiskLevel: 50}
+	if err := regionsClient.StoreDiagnosisKeys(ctx, []diag.DiagnosisKey{tagged, untagged}, uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys uploaded again for other regions are stored once, with the union
+	// of their regions. Other columns, e.g. the upload time, are kept.
+	uploads := [][]diag.DiagnosisKey{
+		{
+			{TemporaryExposureKey: [16]byte{1}, TransmissionRiskLevel: 10, Regions: []string{"BE", "NL"}},
+			{TemporaryExposureKey: [16]byte{2}, TransmissionRiskLevel: 10, Regions: []string{"DE"}},
+		},
+		{
+			{TemporaryExposureKey: [16]byte{1}, TransmissionRiskLevel: 10, Regions: []string{"NL"}},
+			{TemporaryExposureKey: [16]byte{2}, TransmissionRiskLevel: 10},
+		},
+	}
+	for _, diagKeys := range uploads {
+		if err := regionsClient.StoreDiagnosisKeys(ctx, diagKeys, uploadedAt.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := regionsClient.FindAllDiagnosisKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []diag.DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, TransmissionRiskLevel: 50, UploadedAt: uploadedAt, Regions: []string{"BE", "NL"}},
+		{TemporaryExposureKey: [16]byte{2}, TransmissionRiskLevel: 50, UploadedAt: uploadedAt, Regions: []string{"DE"}},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected: %+v, got: %+v", exp, got)
+	}
+}
+
+func TestStoreDiagnosisKeysWithReportTypes(t *testing.T) {
+	ctx := context.Background()
+	if _, err := client.db.ExecContext(ctx, "TRUNCATE diagnosis_keys"); err != nil {
+		t.Fatal(err)
+	}
+	reportTypesClient, err := New(Config{DSN: os.Getenv("POSTGRES_DSN"), ReportTypes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reportTypesClient.Close()
+
+	uploadedAt := time.Unix(42, 0).UTC()
+	days := int32(-2)
+	diagKeys := []diag.DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, TransmissionRiskLevel: 50, UploadedAt: uploadedAt, ReportType: diag.ReportTypeConfirmedTest, DaysSinceOnsetOfSymptoms: &days},
+		{TemporaryExposureKey: [16]byte{2}, TransmissionRiskLevel: 50, UploadedAt: uploadedAt},
+	}
+	if err := reportTypesClient.StoreDiagnosisKeys(ctx, diagKeys, uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := reportTypesClient.FindAllDiagnosisKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, diagKeys) {
+		t.Errorf("expected: %+v, got: %+v", diagKeys, got)
+	}
+}
+
+func TestStoreDiagnosisKeysWithRollingPeriods(t *testing.T) {
+	ctx := context.Background()
+	if _, err := client.db.ExecContext(ctx, "TRUNCATE diagnosis_keys"); err != nil {
+		t.Fatal(err)
+	}
+	rollingPeriodsClient, err := New(Config{DSN: os.Getenv("POSTGRES_DSN"), RollingPeriods: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rollingPeriodsClient.Close()
+
+	uploadedAt := time.Unix(42, 0).UTC()
+	diagKeys := []diag.DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, TransmissionRiskLevel: 50, UploadedAt: uploadedAt, RollingPeriod: 72},
+		{TemporaryExposureKey: [16]byte{2}, TransmissionRiskLevel: 50, UploadedAt: uploadedAt},
+	}
+	if err := rollingPeriodsClient.StoreDiagnosisKeys(ctx, diagKeys, uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := rollingPeriodsClient.FindAllDiagnosisKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, diagKeys) {
+		t.Errorf("expected: %+v, got: %+v", diagKeys, got)
+	}
+}
+
+func TestFindLocalDiagnosisKeys(t *testing.T) {
+	ctx := context.Background()
+	if _, err := client.db.ExecContext(ctx, "TRUNCATE diagnosis_keys"); err != nil {
+		t.Fatal(err)
+	}
+	uploadedAt := time.Unix(42, 0).UTC()
+
+	local := []diag.DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, TransmissionRiskLevel: 50},
+		{TemporaryExposureKey: [16]byte{2}, TransmissionRiskLevel: 50},
+		{TemporaryExposureKey: [16]byte{3}, TransmissionRiskLevel: 50},
+	}
+	if err := client.StoreDiagnosisKeys(ctx, local[:1], uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+	pulled := diag.DiagnosisKey{TemporaryExposureKey: [16]byte{4}, TransmissionRiskLevel: 50}
+	if err := client.StoreDiagnosisKeysWithOrigin(ctx, []diag.DiagnosisKey{pulled}, uploadedAt, "DE"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.StoreDiagnosisKeys(ctx, local[1:], uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys with an origin are skipped, and pages continue after the index of
+	// their last key.
+	var got []diag.DiagnosisKey
+	var after int64
+	for {
+		page, err := client.FindLocalDiagnosisKeys(ctx, after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, diagKey := range page {
+			got = append(got, diagKey.DiagnosisKey)
+		}
+		after = page[len(page)-1].Index
+	}
+	for i := range local {
+		local[i].UploadedAt = uploadedAt
+	}
+	if !reflect.DeepEqual(got, local) {
+		t.Errorf("expected: %v, got: %v", local, got)
+	}
+}
+
+func TestStoreDiagnosisKeysBatches(t *testing.T) {
+	ctx := context.Background()
+	if _, err := client.db.ExecContext(ctx, "TRUNCATE diagnosis_keys"); err != nil {
+		t.Fatal(err)
+	}
+
+	batchClient, err := New(Config{DSN: os.Getenv("POSTGRES_DSN"), BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer batchClient.Close()
+
+	// Keys spanning multiple batches are stored in order.
+	uploadedAt := time.Unix(42, 0).UTC()
+	var exp []diag.DiagnosisKey
+	for i := byte(1); i <= 5; i++ {
+		exp = append(exp, diag.DiagnosisKey{TemporaryExposureKey: [16]byte{i}, RollingStartNumber: 42, TransmissionRiskLevel: 50, UploadedAt: uploadedAt})
+	}
+	if err := batchClient.StoreDiagnosisKeys(ctx, exp, uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := batchClient.FindAllDiagnosisKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected: %#v, got: %#v", exp, got)
+	}
+}
+
 func TestFindAllDiagnosisKeys(t *testing.T) {
 	ctx := context.Background()
 	key := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
@@ -188,6 +527,7 @@ func TestFindAllDiagnosisKeys(t *testing.T) {
 				{
 					TemporaryExposureKey: key,
 					RollingStartNumber:   uint32(42),
+					UploadedAt:           now,
 				},
 			},
 			expError: nil,
@@ -230,19 +570,44 @@ func TestFindAllDiagnosisKeys(t *testing.T) {
 				t.Fatalf("expected: %v, got: %v", tt.expError, err)
 			}
 
-			expDiagKeys := &bytes.Buffer{}
-			err = diag.WriteDiagnosisKeys(expDiagKeys, tt.expDiagKeys...)
-			if err != nil {
-				t.Fatal(err)
+			if len(diagKeys) == 0 && len(tt.expDiagKeys) == 0 {
+				return
 			}
-
-			if !bytes.Equal(diagKeys, expDiagKeys.Bytes()) {
-				t.Errorf("expected: %+v, got: %+v", expDiagKeys.Bytes(), diagKeys)
+			if !reflect.DeepEqual(diagKeys, tt.expDiagKeys) {
+				t.Errorf("expected: %+v, got: %+v", tt.expDiagKeys, diagKeys)
 			}
 		})
 	}
 }
 
+func TestEstimateKeyCount(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := client.db.ExecContext(ctx, "TRUNCATE diagnosis_keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var diagKeys []diag.DiagnosisKey
+	for i := 0; i < 10; i++ {
+		diagKeys = append(diagKeys, diag.DiagnosisKey{TemporaryExposureKey: [16]byte{byte(i + 1)}, RollingStartNumber: 42})
+	}
+	if err := client.StoreDiagnosisKeys(ctx, diagKeys, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.db.ExecContext(ctx, "ANALYZE diagnosis_keys"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := client.EstimateKeyCount(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := int64(len(diagKeys)); got != exp {
+		t.Errorf("expected: %v, got: %v", exp, got)
+	}
+}
+
 func TestLastModified(t *testing.T) {
 	ctx := context.Background()
 
@@ -298,6 +663,29 @@ func TestLastModified(t *testing.T) {
 			expLastModified: time.Unix(43, 0),
 			expError:        nil,
 		},
+		{
+			name: "backfilled diagnosis key with older upload time",
+			storeReq: []storeReq{
+				{
+					diagKey: diag.DiagnosisKey{
+						TemporaryExposureKey:  randomTEK(),
+						RollingStartNumber:    uint32(42),
+						TransmissionRiskLevel: 50,
+					},
+					lastModified: time.Unix(43, 0),
+				},
+				{
+					diagKey: diag.DiagnosisKey{
+						TemporaryExposureKey:  randomTEK(),
+						RollingStartNumber:    uint32(42),
+						TransmissionRiskLevel: 50,
+					},
+					lastModified: time.Unix(42, 0),
+				},
+			},
+			expLastModified: time.Unix(43, 0),
+			expError:        nil,
+		},
 	}
 
 	for _, tt := range tests {
@@ -342,3 +730,293 @@ func TestLastModified(t *testing.T) {
 		})
 	}
 }
+
+func TestMissingIndexes(t *testing.T) {
+	missing, err := client.MissingIndexes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(missing) != 0 {
+		t.Errorf("expected no missing indexes, got: %v", missing)
+	}
+}
+
+func TestDeleteDiagnosisKeysBefore(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := client.db.ExecContext(ctx, "TRUNCATE diagnosis_keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := diag.DiagnosisKey{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: 42}
+	retained := diag.DiagnosisKey{TemporaryExposureKey: [16]byte{2}, RollingStartNumber: 42}
+
+	if err := client.StoreDiagnosisKeys(ctx, []diag.DiagnosisKey{expired}, time.Unix(42, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.StoreDiagnosisKeys(ctx, []diag.DiagnosisKey{retained}, time.Unix(44, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := client.DeleteDiagnosisKeysBefore(ctx, time.Unix(43, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected: 1, got: %v", n)
+	}
+
+	var count int
+	if err := client.db.QueryRowContext(ctx, "SELECT count(*) FROM diagnosis_keys").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected: 1, got: %v", count)
+	}
+}
+
+func TestPartitionIntervalBounds(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval PartitionInterval
+		t        time.Time
+		expStart time.Time
+		expEnd   time.Time
+	}{
+		{
+			name:     "daily",
+			interval: PartitionDaily,
+			t:        time.Date(2020, time.May, 6, 13, 37, 0, 0, time.UTC),
+			expStart: time.Date(2020, time.May, 6, 0, 0, 0, 0, time.UTC),
+			expEnd:   time.Date(2020, time.May, 7, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "weekly",
+			interval: PartitionWeekly,
+			t:        time.Date(2020, time.May, 6, 13, 37, 0, 0, time.UTC),
+			expStart: time.Date(2020, time.May, 4, 0, 0, 0, 0, time.UTC),
+			expEnd:   time.Date(2020, time.May, 11, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "weekly, on sunday",
+			interval: PartitionWeekly,
+			t:        time.Date(2020, time.May, 10, 23, 0, 0, 0, time.UTC),
+			expStart: time.Date(2020, time.May, 4, 0, 0, 0, 0, time.UTC),
+			expEnd:   time.Date(2020, time.May, 11, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := tt.interval.bounds(tt.t)
+			if !start.Equal(tt.expStart) || !end.Equal(tt.expEnd) {
+				t.Errorf("expected: [%v, %v), got: [%v, %v)", tt.expStart, tt.expEnd, start, end)
+			}
+		})
+	}
+}
+
+func TestStorePublicationTime(t *testing.T) {
+	ctx := context.Background()
+	if _, err := client.db.ExecContext(ctx, "TRUNCATE diagnosis_keys"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.StorePublicationTime(ctx, [][16]byte{{1}}, time.Now()); err != diag.ErrPublicationTimesUnsupported {
+		t.Errorf("expected: %v, got: %v", diag.ErrPublicationTimesUnsupported, err)
+	}
+
+	publicationTimesClient, err := New(Config{DSN: os.Getenv("POSTGRES_DSN"), PublicationTimes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publicationTimesClient.Close()
+
+	uploadedAt := time.Unix(42, 0).UTC()
+	diagKeys := []diag.DiagnosisKey{{TemporaryExposureKey: [16]byte{1}}, {TemporaryExposureKey: [16]byte{2}}}
+	if err := publicationTimesClient.StoreDiagnosisKeys(ctx, diagKeys, uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+	publishedAt := uploadedAt.Add(time.Hour)
+	if err := publicationTimesClient.StorePublicationTime(ctx, [][16]byte{{2}}, publishedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	// Late keys are listed at their publication time.
+	got, err := publicationTimesClient.FindAllDiagnosisKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || !got[0].UploadedAt.Equal(uploadedAt) || !got[1].UploadedAt.Equal(publishedAt) {
+		t.Errorf("unexpected keys: %+v", got)
+	}
+}
+
+func TestKeyLockBucketsOf(t *testing.T) {
+	diagKeys := []diag.DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{0, 0, 1, 2}},
+		{TemporaryExposureKey: [16]byte{0, 0, 0, 1}},
+		{TemporaryExposureKey: [16]byte{0, 0, 0, 1, 42}},
+		{TemporaryExposureKey: [16]byte{0, 0, 0, 1, 42}},
+	}
+	// 0x0102 % 256 == 2.
+	if got, exp := keyLockBucketsOf(diagKeys), []int64{1, 2}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected: %v, got: %v", exp, got)
+	}
+}
+
+func TestRevocations(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := client.db.ExecContext(ctx, "TRUNCATE revoked_keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.StoreRevocations(ctx, nil, time.Unix(42, 0)); err != diag.ErrNilRevokedKeys {
+		t.Fatalf("expected: %v, got: %v", diag.ErrNilRevokedKeys, err)
+	}
+
+	keys := [][16]byte{{1}, {2}}
+	if err := client.StoreRevocations(ctx, keys, time.Unix(42, 0)); err != nil {
+		t.Fatal(err)
+	}
+	// Revoking a key twice should be ignored.
+	if err := client.StoreRevocations(ctx, keys[1:], time.Unix(43, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	revocations, err := client.FindAllRevocations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expRevocations := []diag.Revocation{
+		{TemporaryExposureKey: keys[0], RevokedAt: time.Unix(42, 0).UTC()},
+		{TemporaryExposureKey: keys[1], RevokedAt: time.Unix(42, 0).UTC()},
+	}
+	if !reflect.DeepEqual(revocations, expRevocations) {
+		t.Errorf("expected: %+v, got: %+v", expRevocations, revocations)
+	}
+}
+
+func TestQuarantineDiagnosisKeys(t *testing.T) {
+	ctx := context.Background()
+	if _, err := client.db.ExecContext(ctx, "TRUNCATE diagnosis_keys, quarantined_keys"); err != nil {
+		t.Fatal(err)
+	}
+
+	uploadedAt := time.Unix(42, 0).UTC()
+	diagKeys := []diag.DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: 42, TransmissionRiskLevel: 50},
+		{TemporaryExposureKey: [16]byte{2}, RollingStartNumber: 0, TransmissionRiskLevel: 50},
+	}
+	if err := client.StoreDiagnosisKeys(ctx, diagKeys, uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+	// A key that can't be decoded into a diag.DiagnosisKey.
+	_, err := client.db.ExecContext(ctx, `INSERT INTO diagnosis_keys (temporary_exposure_key, rolling_start_number, transmission_risk_level, uploaded_at)
+	VALUES ($1, 42, $2, $3)`, []byte{3}, []byte{50}, uploadedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	violations, err := diag.Fsck(ctx, client, time.Unix(600*42, 0), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 2 {
+		t.Fatalf("expected 2 violations, got: %+v", violations)
+	}
+
+	n, err := client.QuarantineDiagnosisKeys(ctx, violations, time.Unix(43, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected: 2, got: %v", n)
+	}
+
+	got, err := client.FindAllDiagnosisKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []diag.DiagnosisKey{{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: 42, TransmissionRiskLevel: 50, UploadedAt: uploadedAt}}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected: %+v, got: %+v", exp, got)
+	}
+}
+
+func TestJobRuns(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := client.db.ExecContext(ctx, "TRUNCATE job_runs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := diag.JobRun{Type: diag.JobRefresh, StartedAt: time.Unix(42, 0).UTC(), EndedAt: time.Unix(43, 0).UTC(), Error: "foobar"}
+	if failed.ID, err = client.StoreJobRun(ctx, failed); err != nil {
+		t.Fatal(err)
+	}
+	retry := diag.JobRun{Type: diag.JobRefresh, StartedAt: time.Unix(44, 0).UTC(), EndedAt: time.Unix(45, 0).UTC(), RetryOf: failed.ID}
+	if retry.ID, err = client.StoreJobRun(ctx, retry); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := client.FindJobRuns(ctx, 10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []diag.JobRun{retry, failed}; !reflect.DeepEqual(runs, exp) {
+		t.Errorf("expected: %+v, got: %+v", exp, runs)
+	}
+
+	runs, err = client.FindJobRuns(ctx, 10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []diag.JobRun{failed}; !reflect.DeepEqual(runs, exp) {
+		t.Errorf("expected: %+v, got: %+v", exp, runs)
+	}
+
+	run, err := client.FindJobRun(ctx, failed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(run, failed) {
+		t.Errorf("expected: %+v, got: %+v", failed, run)
+	}
+	if _, err := client.FindJobRun(ctx, retry.ID+1); err != diag.ErrJobRunNotFound {
+		t.Errorf("expected: %v, got: %v", diag.ErrJobRunNotFound, err)
+	}
+}
+
+func TestExposureConfigs(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := client.db.ExecContext(ctx, "TRUNCATE exposure_configs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := diag.ExposureConfigHistory{
+		{ID: "v1", EffectiveFrom: time.Unix(42, 0).UTC(), Config: diag.ExposureConfig{MinimumRiskScore: 1, AttenuationLevelValues: []int{1, 2}}},
+		{ID: "v2", EffectiveFrom: time.Unix(43, 0).UTC(), Config: diag.ExposureConfig{MinimumRiskScore: 2, AttenuationLevelValues: []int{3, 4}}},
+		{ID: "NL/1.2/v3", EffectiveFrom: time.Unix(44, 0).UTC(), ExposureConfigScope: diag.ExposureConfigScope{Region: "NL", AppVersion: "1.2"}, Config: diag.ExposureConfig{MinimumRiskScore: 3}},
+	}
+	for _, i := range []int{1, 2, 0} {
+		if err := client.StoreExposureConfig(ctx, exp[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := client.FindExposureConfigs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected: %+v, got: %+v", exp, got)
+	}
+}
@@ -0,0 +1,210 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+
+	"github.com/lib/pq"
+)
+
+// defaultBatchSize is the default maximum amount of keys per bulk insert.
+const defaultBatchSize = 5000
+
+// insertDiagnosisKeys inserts diagKeys in batches of c.batchSize: each batch
+// is streamed with `COPY` into a temporary staging table, and moved from there
+// with a single `INSERT ... SELECT`, which handles conflicts with keys stored
+// concurrently. This is much faster than an insert per key for large batches,
+// e.g. federation imports. It returns the inserted keys.
+func (c *Client) insertDiagnosisKeys(ctx context.Context, tx *sql.Tx, diagKeys []diag.DiagnosisKey, uploadedAt time.Time, origin string) ([]diag.DiagnosisKey, error) {
+	// The staging table lives as long as the connection, and is emptied on
+	// commit (or rollback).
+	_, err := tx.ExecContext(ctx, `CREATE TEMPORARY TABLE IF NOT EXISTS diagnosis_keys_staging (
+		position integer NOT NULL,
+		temporary_exposure_key bytea NOT NULL,
+		rolling_start_number bigint NOT NULL,
+		transmission_risk_level bytea NOT NULL,
+		regions text[],
+		report_type smallint,
+		days_since_onset_of_symptoms smallint,
+		rolling_period smallint
+	) ON COMMIT DELETE ROWS`)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: could not create staging table: %w", err)
+	}
+
+	// The `origin`, `regions`, report type and rolling period columns are only
+	// written if needed, so uploads don't depend on the migrations adding them.
+	columns, values, args := "", "", []interface{}{uploadedAt}
+	if origin != "" {
+		columns, values = ", origin", ", $2::text"
+		args = append(args, origin)
+	}
+	if c.regions {
+		columns, values = columns+", regions", values+", regions"
+	}
+	if c.reportTypes {
+		columns += ", report_type, days_since_onset_of_symptoms"
+		values += ", report_type, days_since_onset_of_symptoms"
+	}
+	if c.rollingPeriods {
+		columns, values = columns+", rolling_period", values+", rolling_period"
+	}
+
+	// Keys stored concurrently keep their other columns, but get the regions
+	// of this upload too (see mergeRegions). Their rows are returned as well,
+	// but with a non zero `xmax`, so they aren't taken as inserted.
+	conflict := "DO NOTHING RETURNING temporary_exposure_key, true"
+	if c.regions {
+		conflict = `DO UPDATE SET regions = array(SELECT DISTINCT unnest(diagnosis_keys.regions || EXCLUDED.regions) ORDER BY 1)
+	WHERE EXCLUDED.regions IS NOT NULL
+	RETURNING temporary_exposure_key, xmax = 0`
+	}
+
+	// The position preserves the order of the keys, and thus their `index`.
+	query := `INSERT INTO diagnosis_keys (temporary_exposure_key, rolling_start_number, transmission_risk_level, uploaded_at` + columns + `)
+	SELECT temporary_exposure_key, rolling_start_number, transmission_risk_level, $1::timestamptz` + values + `
+	FROM diagnosis_keys_staging
+	ORDER BY position
+	ON CONFLICT ON CONSTRAINT diagnosis_keys_pkey ` + conflict
+	if c.partitionInterval != PartitionNone {
+		// The primary key of a partitioned table includes `uploaded_at`, so
+		// duplicates in other partitions must be checked explicitly. The keys
+		// are locked (see lockDiagnosisKeys), so a concurrent insert of the
+		// same key is committed before this check, and thus visible to it,
+		// and to mergeRegions.
+		query = `INSERT INTO diagnosis_keys (temporary_exposure_key, rolling_start_number, transmission_risk_level, uploaded_at` + columns + `)
+		SELECT s.temporary_exposure_key, s.rolling_start_number, s.transmission_risk_level, $1::timestamptz` + values + `
+		FROM diagnosis_keys_staging s
+		WHERE NOT EXISTS (SELECT 1 FROM diagnosis_keys d WHERE d.temporary_exposure_key = s.temporary_exposure_key)
+		ORDER BY s.position
+		ON CONFLICT DO NOTHING
+		RETURNING temporary_exposure_key, true`
+	}
+
+	inserted := make(map[[16]byte]bool, len(diagKeys))
+	for start := 0; start < len(diagKeys); start += c.batchSize {
+		end := start + c.batchSize
+		if end > len(diagKeys) {
+			end = len(diagKeys)
+		}
+		if start > 0 {
+			if _, err := tx.ExecContext(ctx, `TRUNCATE diagnosis_keys_staging`); err != nil {
+				return nil, fmt.Errorf("postgres: could not truncate staging table: %w", err)
+			}
+		}
+		if err := copyDiagnosisKeys(ctx, tx, diagKeys[start:end]); err != nil {
+			return nil, err
+		}
+		if err := scanInsertedKeys(ctx, tx, inserted, query, args...); err != nil {
+			return nil, err
+		}
+	}
+
+	var insertedKeys []diag.DiagnosisKey
+	for _, diagKey := range diagKeys {
+		if inserted[diagKey.TemporaryExposureKey] {
+			insertedKeys = append(insertedKeys, diagKey)
+		}
+	}
+	return insertedKeys, nil
+}
+
+// scanInsertedKeys executes the insert statement query, and adds the keys it
+// returns as inserted to inserted.
+func scanInsertedKeys(ctx context.Context, tx *sql.Tx, inserted map[[16]byte]bool, query string, args ...interface{}) error {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("postgres: could not execute statement: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tek []byte
+		var ok bool
+		if err := rows.Scan(&tek, &ok); err != nil {
+			return fmt.Errorf("postgres: could not scan row: %w", err)
+		}
+		if ok {
+			var key [16]byte
+			copy(key[:], tek)
+			inserted[key] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("postgres: could not iterate over rows: %w", err)
+	}
+	return nil
+}
+
+// copyDiagnosisKeys streams diagKeys into the staging table.
+func copyDiagnosisKeys(ctx context.Context, tx *sql.Tx, diagKeys []diag.DiagnosisKey) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("diagnosis_keys_staging",
+		"position", "temporary_exposure_key", "rolling_start_number", "transmission_risk_level", "regions",
+		"report_type", "days_since_onset_of_symptoms", "rolling_period"))
+	if err != nil {
+		return fmt.Errorf("postgres: could not prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for i, diagKey := range diagKeys {
+		_, err := stmt.ExecContext(ctx,
+			i,
+			diagKey.TemporaryExposureKey[:],
+			diagKey.RollingStartNumber,
+			diagKey.TransmissionRiskLevel,
+			regionsArray(diagKey.Regions),
+			reportType(diagKey.ReportType),
+			daysSinceOnset(diagKey.DaysSinceOnsetOfSymptoms),
+			rollingPeriod(diagKey.RollingPeriod),
+		)
+		if err != nil {
+			return fmt.Errorf("postgres: could not copy row: %w", err)
+		}
+	}
+	// An empty exec flushes the buffered rows.
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return fmt.Errorf("postgres: could not execute copy: %w", err)
+	}
+
+	return nil
+}
+
+// regionsArray returns the value of the `regions` column: NULL if there are
+// no regions.
+func regionsArray(regions []string) interface{} {
+	if len(regions) == 0 {
+		return nil
+	}
+	return pq.StringArray(regions)
+}
+
+// reportType returns the value of the `report_type` column: NULL for unknown
+// report types.
+func reportType(rt diag.ReportType) interface{} {
+	if rt == diag.ReportTypeUnknown {
+		return nil
+	}
+	return int64(rt)
+}
+
+// daysSinceOnset returns the value of the `days_since_onset_of_symptoms`
+// column: NULL if unknown.
+func daysSinceOnset(days *int32) interface{} {
+	if days == nil {
+		return nil
+	}
+	return int64(*days)
+}
+
+// rollingPeriod returns the value of the `rolling_period` column: NULL for
+// the default rolling period.
+func rollingPeriod(rp uint8) interface{} {
+	if rp == 0 {
+		return nil
+	}
+	return int64(rp)
+}
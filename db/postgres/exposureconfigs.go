@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// Operation names of exposure configurations, used as metric labels.
+const (
+	opStoreExposureConfig = "store_exposure_config"
+	opFindExposureConfigs = "find_exposure_configs"
+)
+
+// StoreExposureConfig persists a version of the exposure configuration.
+func (c *Client) StoreExposureConfig(ctx context.Context, version diag.ExposureConfigVersion) (err error) {
+	start := time.Now()
+	defer func() { c.observe(opStoreExposureConfig, start, 1, err) }()
+
+	buf, err := json.Marshal(version.Config)
+	if err != nil {
+		return fmt.Errorf("postgres: could not encode exposure config: %v", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, c.writeTimeout)
+	defer cancel()
+
+	query := `INSERT INTO exposure_configs (id, effective_from, region, app_version, config) VALUES ($1, $2, $3, $4, $5)`
+	if _, err = c.db.ExecContext(ctx, query, version.ID, version.EffectiveFrom, version.Region, version.AppVersion, buf); err != nil {
+		return fmt.Errorf("postgres: could not execute query: %v", err)
+	}
+
+	return nil
+}
+
+// FindExposureConfigs finds all versions of the exposure configuration,
+// ordered by their effective time.
+func (c *Client) FindExposureConfigs(ctx context.Context) (_ diag.ExposureConfigHistory, err error) {
+	var rowCount int
+	start := time.Now()
+	defer func() { c.observe(opFindExposureConfigs, start, rowCount, err) }()
+
+	ctx, cancel := withTimeout(ctx, c.readTimeout)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, `SELECT id, effective_from, region, app_version, config FROM exposure_configs ORDER BY effective_from`)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: could not execute query: %v", err)
+	}
+	defer rows.Close()
+
+	var history diag.ExposureConfigHistory
+	for rows.Next() {
+		rowCount++
+		var version diag.ExposureConfigVersion
+		var buf []byte
+		if err := rows.Scan(&version.ID, &version.EffectiveFrom, &version.Region, &version.AppVersion, &buf); err != nil {
+			return nil, fmt.Errorf("postgres: could not scan row: %v", err)
+		}
+		if err := json.Unmarshal(buf, &version.Config); err != nil {
+			return nil, fmt.Errorf("postgres: could not decode exposure config %q: %v", version.ID, err)
+		}
+		version.EffectiveFrom = version.EffectiveFrom.In(time.UTC)
+		history = append(history, version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: could not iterate over rows: %v", err)
+	}
+
+	rowsScanned.Add(float64(rowCount), opFindExposureConfigs)
+
+	return history, nil
+}
@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// Operation names of integrity scans, used as metric labels.
+const (
+	opScanDiagnosisKeys       = "scan_diagnosis_keys"
+	opQuarantineDiagnosisKeys = "quarantine_diagnosis_keys"
+)
+
+// ScanDiagnosisKeys calls fn for every stored Diagnosis Key, as stored,
+// ordered by upload. If fn returns an error, the scan is aborted.
+func (c *Client) ScanDiagnosisKeys(ctx context.Context, fn func(diag.RawDiagnosisKey) error) (err error) {
+	var rowCount int
+	start := time.Now()
+	defer func() { c.observe(opScanDiagnosisKeys, start, rowCount, err) }()
+
+	ctx, cancel := withTimeout(ctx, c.readTimeout)
+	defer cancel()
+
+	tx, err := c.beginTx(ctx, c.readTimeout, true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT temporary_exposure_key, rolling_start_number, transmission_risk_level, uploaded_at
+	FROM diagnosis_keys
+	ORDER BY index ASC`)
+	if err != nil {
+		return fmt.Errorf("postgres: could not execute query: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rowCount++
+		var rawKey diag.RawDiagnosisKey
+		if err := rows.Scan(&rawKey.TemporaryExposureKey, &rawKey.RollingStartNumber, &rawKey.TransmissionRiskLevel, &rawKey.UploadedAt); err != nil {
+			return fmt.Errorf("postgres: could not scan row: %v", err)
+		}
+		rawKey.UploadedAt = rawKey.UploadedAt.In(time.UTC)
+		if err := fn(rawKey); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("postgres: could not iterate over rows: %v", err)
+	}
+
+	rowsScanned.Add(float64(rowCount), opScanDiagnosisKeys)
+
+	return nil
+}
+
+// QuarantineDiagnosisKeys moves the keys of violations from `diagnosis_keys`
+// to `quarantined_keys` (see `migrations/006_quarantined_keys.sql`), along
+// with their reason, and returns the amount of moved keys.
+func (c *Client) QuarantineDiagnosisKeys(ctx context.Context, violations []diag.Violation, quarantinedAt time.Time) (n int64, err error) {
+	start := time.Now()
+	defer func() { c.observe(opQuarantineDiagnosisKeys, start, int(n), err) }()
+
+	ctx, cancel := withTimeout(ctx, c.writeTimeout)
+	defer cancel()
+
+	tx, err := c.beginTx(ctx, c.writeTimeout, false)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `WITH moved AS (
+		DELETE FROM diagnosis_keys WHERE temporary_exposure_key = $1
+		RETURNING temporary_exposure_key, rolling_start_number, transmission_risk_level, uploaded_at
+	)
+	INSERT INTO quarantined_keys (temporary_exposure_key, rolling_start_number, transmission_risk_level, uploaded_at, reason, quarantined_at)
+	SELECT temporary_exposure_key, rolling_start_number, transmission_risk_level, uploaded_at, $2, $3
+	FROM moved`)
+	if err != nil {
+		return 0, fmt.Errorf("postgres: could not prepare statement: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, v := range violations {
+		res, err := stmt.ExecContext(ctx, v.Key.TemporaryExposureKey, v.Reason, quarantinedAt)
+		if err != nil {
+			return 0, fmt.Errorf("postgres: could not execute statement: %v", err)
+		}
+		moved, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("postgres: could not get affected rows: %v", err)
+		}
+		n += moved
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("postgres: cannot commit transaction: %v", err)
+	}
+
+	return n, nil
+}
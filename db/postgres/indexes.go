@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+)
+
+// expectedIndexes are the indexes on `diagnosis_keys` that queries of Client
+// rely on. Without them, listing and purging keys results in sequential scans.
+var expectedIndexes = []string{
+	"diagnosis_keys_pkey",
+	"index_idx",
+	"diagnosis_keys_uploaded_at_idx",
+}
+
+// MissingIndexes returns the names of expected indexes on the `diagnosis_keys`
+// table that don't exist in the database. See `migrations` for statements to
+// create them.
+func (c *Client) MissingIndexes(ctx context.Context) ([]string, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT indexname FROM pg_indexes WHERE tablename = 'diagnosis_keys'`)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: could not execute query: %v", err)
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("postgres: could not scan row: %v", err)
+		}
+		existing[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: could not iterate over rows: %v", err)
+	}
+
+	var missing []string
+	for _, name := range expectedIndexes {
+		if !existing[name] {
+			missing = append(missing, name)
+		}
+	}
+
+	return missing, nil
+}
@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// Operation names of job runs, used as metric labels.
+const (
+	opStoreJobRun = "store_job_run"
+	opFindJobRuns = "find_job_runs"
+	opFindJobRun  = "find_job_run"
+)
+
+// StoreJobRun persists a background job run, and returns its ID.
+func (c *Client) StoreJobRun(ctx context.Context, run diag.JobRun) (id int64, err error) {
+	start := time.Now()
+	defer func() { c.observe(opStoreJobRun, start, 1, err) }()
+
+	ctx, cancel := withTimeout(ctx, c.writeTimeout)
+	defer cancel()
+
+	query := `INSERT INTO job_runs (type, started_at, ended_at, error, retry_of) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	err = c.db.QueryRowContext(ctx, query, run.Type, run.StartedAt, run.EndedAt,
+		sql.NullString{String: run.Error, Valid: run.Error != ""},
+		sql.NullInt64{Int64: run.RetryOf, Valid: run.RetryOf != 0},
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("postgres: could not execute query: %v", err)
+	}
+
+	return id, nil
+}
+
+// FindJobRuns finds the latest job runs, newest first. If failedOnly is true,
+// only failed runs are returned.
+func (c *Client) FindJobRuns(ctx context.Context, limit int, failedOnly bool) (_ []diag.JobRun, err error) {
+	var rowCount int
+	start := time.Now()
+	defer func() { c.observe(opFindJobRuns, start, rowCount, err) }()
+
+	ctx, cancel := withTimeout(ctx, c.readTimeout)
+	defer cancel()
+
+	query := `SELECT id, type, started_at, ended_at, error, retry_of FROM job_runs
+	WHERE $1 = false OR error IS NOT NULL ORDER BY id DESC LIMIT $2`
+
+	rows, err := c.db.QueryContext(ctx, query, failedOnly, limit)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: could not execute query: %v", err)
+	}
+	defer rows.Close()
+
+	var runs []diag.JobRun
+	for rows.Next() {
+		rowCount++
+		run, err := scanJobRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: could not iterate over rows: %v", err)
+	}
+
+	rowsScanned.Add(float64(rowCount), opFindJobRuns)
+
+	return runs, nil
+}
+
+// FindJobRun finds a job run by ID. If it doesn't exist, diag.ErrJobRunNotFound
+// is returned.
+func (c *Client) FindJobRun(ctx context.Context, id int64) (_ diag.JobRun, err error) {
+	start := time.Now()
+	defer func() { c.observe(opFindJobRun, start, 1, err) }()
+
+	ctx, cancel := withTimeout(ctx, c.readTimeout)
+	defer cancel()
+
+	row := c.db.QueryRowContext(ctx, `SELECT id, type, started_at, ended_at, error, retry_of FROM job_runs WHERE id = $1`, id)
+	run, err := scanJobRun(row)
+	if err == sql.ErrNoRows {
+		return diag.JobRun{}, diag.ErrJobRunNotFound
+	}
+
+	return run, err
+}
+
+// scanJobRun scans a row of `job_runs`.
+func scanJobRun(row interface{ Scan(...interface{}) error }) (diag.JobRun, error) {
+	var run diag.JobRun
+	var runErr sql.NullString
+	var retryOf sql.NullInt64
+	if err := row.Scan(&run.ID, &run.Type, &run.StartedAt, &run.EndedAt, &runErr, &retryOf); err != nil {
+		if err == sql.ErrNoRows {
+			return diag.JobRun{}, err
+		}
+		return diag.JobRun{}, fmt.Errorf("postgres: could not scan row: %v", err)
+	}
+	run.StartedAt = run.StartedAt.In(time.UTC)
+	run.EndedAt = run.EndedAt.In(time.UTC)
+	run.Error = runErr.String
+	run.RetryOf = retryOf.Int64
+
+	return run, nil
+}
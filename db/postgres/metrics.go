@@ -0,0 +1,103 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+	"github.com/dstotijn/ct-diag-server/metrics"
+)
+
+// Operation names, used as metric labels.
+const (
+	opStoreDiagnosisKeys       = "store_diagnosis_keys"
+	opFindAllDiagnosisKeys     = "find_all_diagnosis_keys"
+	opFindDiagnosisKeyByTEK    = "find_diagnosis_key_by_tek"
+	opFindLocalDiagnosisKeys   = "find_local_diagnosis_keys"
+	opLastModified             = "last_modified"
+	opEstimateKeyCount         = "estimate_key_count"
+	opDeleteDiagnosisKeys      = "delete_diagnosis_keys"
+	opDeleteDiagnosisKeysByTEK = "delete_diagnosis_keys_by_tek"
+	opStorePublicationTime     = "store_publication_time"
+)
+
+var (
+	queryDuration = metrics.DefaultRegistry.Histogram(
+		"ctdiag_postgres_query_duration_seconds",
+		"Duration of repository operations against PostgreSQL.",
+		metrics.DefaultBuckets,
+		"operation",
+	)
+	queryErrors = metrics.DefaultRegistry.Counter(
+		"ctdiag_postgres_query_errors_total",
+		"Total number of failed repository operations against PostgreSQL.",
+		"operation",
+	)
+	rowsScanned = metrics.DefaultRegistry.Counter(
+		"ctdiag_postgres_rows_scanned_total",
+		"Total number of rows scanned by repository operations.",
+		"operation",
+	)
+	duplicateKeys = metrics.DefaultRegistry.Counter(
+		"ctdiag_postgres_duplicate_keys_total",
+		"Total number of uploaded keys skipped as duplicates of stored keys, or of other keys in the same upload.",
+	)
+	txRetries = metrics.DefaultRegistry.Counter(
+		"ctdiag_postgres_tx_retries_total",
+		"Total number of transactions retried after a serialization failure or deadlock.",
+		"operation",
+	)
+)
+
+// observe records the duration and outcome of a repository operation, and
+// logs it if it exceeded the slow query threshold. Only the operation name and
+// the amount of affected rows are logged, never key material.
+func (c *Client) observe(op string, start time.Time, rows int, err error) {
+	d := time.Since(start)
+	queryDuration.Observe(d.Seconds(), op)
+	if err != nil && err != diag.ErrNilDiagKeys {
+		queryErrors.Inc(op)
+	}
+
+	if c.slowQueryThreshold > 0 && d >= c.slowQueryThreshold {
+		c.logger.Warn("Slow query.",
+			diag.F("operation", op),
+			diag.F("duration", d),
+			diag.F("threshold", c.slowQueryThreshold),
+			diag.F("rows", rows),
+		)
+	}
+}
+
+// PoolSaturation returns the fraction of connections in use, relative to the
+// maximum amount of open connections.
+func (c *Client) PoolSaturation() float64 {
+	stats := c.db.Stats()
+	if stats.MaxOpenConnections == 0 {
+		return 0
+	}
+	return float64(stats.InUse) / float64(stats.MaxOpenConnections)
+}
+
+// registerDBStats exposes connection pool statistics of db as gauges.
+func registerDBStats(db *sql.DB) {
+	r := metrics.DefaultRegistry
+	r.GaugeFunc("ctdiag_postgres_open_connections", "Number of established connections, both in use and idle.", func() float64 {
+		return float64(db.Stats().OpenConnections)
+	})
+	r.GaugeFunc("ctdiag_postgres_in_use_connections", "Number of connections currently in use.", func() float64 {
+		return float64(db.Stats().InUse)
+	})
+	r.GaugeFunc("ctdiag_postgres_idle_connections", "Number of idle connections.", func() float64 {
+		return float64(db.Stats().Idle)
+	})
+	r.GaugeFunc("ctdiag_postgres_max_open_connections", "Maximum number of open connections to the database.", func() float64 {
+		return float64(db.Stats().MaxOpenConnections)
+	})
+	r.GaugeFunc("ctdiag_postgres_wait_count", "Total number of connections waited for.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	r.GaugeFunc("ctdiag_postgres_wait_duration_seconds", "Total time blocked waiting for a new connection.", func() float64 {
+		return db.Stats().WaitDuration.Seconds()
+	})
+}
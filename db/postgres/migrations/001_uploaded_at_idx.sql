@@ -0,0 +1,5 @@
+-- Adds an index on `uploaded_at`, used for time based lookups and purging of
+-- old Diagnosis Keys. New deployments get this index via `schema.sql`.
+CREATE INDEX IF NOT EXISTS diagnosis_keys_uploaded_at_idx
+    ON diagnosis_keys USING btree
+    (uploaded_at ASC);
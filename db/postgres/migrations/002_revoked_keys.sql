@@ -0,0 +1,10 @@
+-- Adds the `revoked_keys` table, for Temporary Exposure Keys that were
+-- withdrawn after publication. New deployments get this table via `schema.sql`
+-- (or `schema_partitioned.sql`).
+CREATE TABLE IF NOT EXISTS revoked_keys
+(
+    temporary_exposure_key bytea NOT NULL,
+    revoked_at timestamp with time zone NOT NULL,
+    index bigserial NOT NULL UNIQUE,
+    CONSTRAINT revoked_keys_pkey PRIMARY KEY (temporary_exposure_key)
+);
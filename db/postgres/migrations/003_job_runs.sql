@@ -0,0 +1,18 @@
+-- Adds the `job_runs` table, for the history of background job runs (e.g.
+-- scheduled cache refreshes). New deployments get this table via `schema.sql`
+-- (or `schema_partitioned.sql`).
+CREATE TABLE IF NOT EXISTS job_runs
+(
+    id bigserial NOT NULL,
+    type text NOT NULL,
+    started_at timestamp with time zone NOT NULL,
+    ended_at timestamp with time zone NOT NULL,
+    error text,
+    retry_of bigint,
+    CONSTRAINT job_runs_pkey PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS job_runs_failed_idx
+    ON job_runs USING btree
+    (id DESC)
+    WHERE error IS NOT NULL;
@@ -0,0 +1,5 @@
+-- Adds the `origin` column, for the region of the peer server federated
+-- Diagnosis Keys were pulled from (see package federation). It's only
+-- required when `-federationPeers` is set. New deployments get this column via
+-- `schema.sql` (or `schema_partitioned.sql`).
+ALTER TABLE diagnosis_keys ADD COLUMN IF NOT EXISTS origin text;
@@ -0,0 +1,5 @@
+-- Adds the `regions` column, for the regions Diagnosis Keys were tagged with
+-- on upload (see diag.ParseRegions). It's only required when `-regions` is
+-- set. New deployments get this column via `schema.sql` (or
+-- `schema_partitioned.sql`).
+ALTER TABLE diagnosis_keys ADD COLUMN IF NOT EXISTS regions text[];
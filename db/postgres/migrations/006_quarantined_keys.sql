@@ -0,0 +1,13 @@
+-- Adds the `quarantined_keys` table, for Diagnosis Keys moved out of
+-- `diagnosis_keys` by `ct-diag-server fsck -quarantine`, with the invariant
+-- they violated. New deployments get this table via `schema.sql` (or
+-- `schema_partitioned.sql`).
+CREATE TABLE IF NOT EXISTS quarantined_keys
+(
+    temporary_exposure_key bytea NOT NULL,
+    rolling_start_number bigint NOT NULL,
+    transmission_risk_level bytea NOT NULL,
+    uploaded_at timestamp with time zone NOT NULL,
+    reason text NOT NULL,
+    quarantined_at timestamp with time zone NOT NULL
+);
@@ -0,0 +1,7 @@
+-- Adds the `report_type` and `days_since_onset_of_symptoms` columns, for the
+-- report types and days since onset of symptoms of Diagnosis Keys (see
+-- diag.ReportType). They're only required when `-reportTypes` is set. New
+-- deployments get these columns via `schema.sql` (or
+-- `schema_partitioned.sql`).
+ALTER TABLE diagnosis_keys ADD COLUMN IF NOT EXISTS report_type smallint;
+ALTER TABLE diagnosis_keys ADD COLUMN IF NOT EXISTS days_since_onset_of_symptoms smallint;
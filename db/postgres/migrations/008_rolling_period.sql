@@ -0,0 +1,5 @@
+-- Adds the `rolling_period` column, for the rolling periods of Diagnosis Keys
+-- (see diag.DiagnosisKey). It's only required when `-rollingPeriods` is set.
+-- New deployments get this column via `schema.sql` (or
+-- `schema_partitioned.sql`).
+ALTER TABLE diagnosis_keys ADD COLUMN IF NOT EXISTS rolling_period smallint;
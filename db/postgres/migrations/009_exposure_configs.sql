@@ -0,0 +1,10 @@
+-- Adds the `exposure_configs` table, for the versions of the exposure
+-- configuration stored via `PUT /exposure-config`. New deployments get this
+-- table via `schema.sql` (or `schema_partitioned.sql`).
+CREATE TABLE IF NOT EXISTS exposure_configs
+(
+    id text NOT NULL,
+    effective_from timestamp with time zone NOT NULL,
+    config jsonb NOT NULL,
+    CONSTRAINT exposure_configs_pkey PRIMARY KEY (id)
+);
@@ -0,0 +1,6 @@
+-- Adds the `region` and `app_version` columns, for the scope of versions of the
+-- exposure configuration (see diag.ExposureConfigScope). Empty for the default
+-- scope. New deployments get these columns via `schema.sql` (or
+-- `schema_partitioned.sql`).
+ALTER TABLE exposure_configs ADD COLUMN IF NOT EXISTS region text NOT NULL DEFAULT '';
+ALTER TABLE exposure_configs ADD COLUMN IF NOT EXISTS app_version text NOT NULL DEFAULT '';
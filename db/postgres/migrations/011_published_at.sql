@@ -0,0 +1,5 @@
+-- Adds the `published_at` column, for the publication times of keys that were
+-- stored late (see diag.PublicationTimeStorer). It's only required when
+-- `-publicationTimes` is set. New deployments get this column via `schema.sql`
+-- (or `schema_partitioned.sql`).
+ALTER TABLE diagnosis_keys ADD COLUMN IF NOT EXISTS published_at timestamp with time zone;
@@ -0,0 +1,180 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+
+	"github.com/lib/pq"
+)
+
+// PartitionInterval defines the time range of a partition of the
+// `diagnosis_keys` table.
+type PartitionInterval string
+
+// Supported partition intervals. With PartitionNone, the table is expected to
+// be a regular table (see `schema.sql`), else a table partitioned by range on
+// `uploaded_at` (see `schema_partitioned.sql`).
+const (
+	PartitionNone   PartitionInterval = ""
+	PartitionDaily  PartitionInterval = "daily"
+	PartitionWeekly PartitionInterval = "weekly"
+)
+
+const partitionNameLayout = "20060102"
+
+// Advisory locks of lockDiagnosisKeys. Keys are locked in buckets, as every
+// lock takes a slot in the shared lock table (see `max_locks_per_transaction`),
+// and imports may hold thousands of keys.
+const (
+	keyLockClass   = 0x74656b // "tek"
+	keyLockBuckets = 256
+)
+
+// ParsePartitionInterval parses a partition interval.
+func ParsePartitionInterval(s string) (PartitionInterval, error) {
+	switch pi := PartitionInterval(s); pi {
+	case PartitionNone, PartitionDaily, PartitionWeekly:
+		return pi, nil
+	default:
+		return PartitionNone, fmt.Errorf("postgres: invalid partition interval (%v)", s)
+	}
+}
+
+// bounds returns the (inclusive) start and (exclusive) end of the partition
+// containing t. Weekly partitions start on Monday.
+func (pi PartitionInterval) bounds(t time.Time) (start, end time.Time) {
+	t = t.UTC()
+	start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if pi == PartitionWeekly {
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+		return start, start.AddDate(0, 0, 7)
+	}
+	return start, start.AddDate(0, 0, 1)
+}
+
+func partitionName(start time.Time) string {
+	return "diagnosis_keys_p" + start.Format(partitionNameLayout)
+}
+
+// ensurePartition creates the partition for keys uploaded at t, if it doesn't
+// exist yet. Known partitions are remembered, to avoid DDL statements on
+// every insert.
+func (c *Client) ensurePartition(ctx context.Context, t time.Time) error {
+	start, end := c.partitionInterval.bounds(t)
+	name := partitionName(start)
+
+	c.partitionsMu.Lock()
+	defer c.partitionsMu.Unlock()
+
+	if c.partitions[name] {
+		return nil
+	}
+
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %v PARTITION OF diagnosis_keys FOR VALUES FROM (%v) TO (%v)`,
+		pq.QuoteIdentifier(name),
+		pq.QuoteLiteral(start.Format(time.RFC3339)),
+		pq.QuoteLiteral(end.Format(time.RFC3339)),
+	)
+	_, err := c.db.ExecContext(ctx, query)
+	var pqErr *pq.Error
+	// A concurrent `CREATE TABLE IF NOT EXISTS` may still fail with a unique
+	// violation on the system catalog, or because the table was just created.
+	if err != nil && !(errors.As(err, &pqErr) && (pqErr.Code == "23505" || pqErr.Code == "42P07")) {
+		return fmt.Errorf("postgres: could not create partition: %v", err)
+	}
+
+	c.partitions[name] = true
+
+	return nil
+}
+
+// dropPartitionsBefore drops all partitions containing only keys uploaded
+// before t, and returns the amount of dropped rows.
+func (c *Client) dropPartitionsBefore(ctx context.Context, t time.Time) (int64, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT child.relname
+	FROM pg_inherits
+	JOIN pg_class parent ON pg_inherits.inhparent = parent.oid
+	JOIN pg_class child ON pg_inherits.inhrelid = child.oid
+	WHERE parent.relname = 'diagnosis_keys'`)
+	if err != nil {
+		return 0, fmt.Errorf("postgres: could not execute query: %v", err)
+	}
+	defer rows.Close()
+
+	var expired []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return 0, fmt.Errorf("postgres: could not scan row: %v", err)
+		}
+		start, err := time.Parse(partitionNameLayout, strings.TrimPrefix(name, "diagnosis_keys_p"))
+		if err != nil {
+			// Not a partition managed by this client.
+			continue
+		}
+		if _, end := c.partitionInterval.bounds(start); !end.After(t) {
+			expired = append(expired, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("postgres: could not iterate over rows: %v", err)
+	}
+	rows.Close()
+
+	var n int64
+	for _, name := range expired {
+		var count int64
+		err := c.db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %v", pq.QuoteIdentifier(name))).Scan(&count)
+		if err != nil {
+			return n, fmt.Errorf("postgres: could not count partition rows: %v", err)
+		}
+		if _, err := c.db.ExecContext(ctx, fmt.Sprintf("DROP TABLE %v", pq.QuoteIdentifier(name))); err != nil {
+			return n, fmt.Errorf("postgres: could not drop partition: %v", err)
+		}
+		n += count
+
+		c.partitionsMu.Lock()
+		delete(c.partitions, name)
+		c.partitionsMu.Unlock()
+	}
+
+	return n, nil
+}
+
+// lockDiagnosisKeys takes a transaction level advisory lock on the bucket of
+// each key. The primary key of a partitioned table includes `uploaded_at`, so
+// concurrent transactions could otherwise both find a key absent and insert
+// it, e.g. into different partitions. Buckets are locked in order, so
+// transactions locking the same buckets don't deadlock.
+func lockDiagnosisKeys(ctx context.Context, tx *sql.Tx, diagKeys []diag.DiagnosisKey) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1::integer, bucket) FROM unnest($2::integer[]) AS bucket`,
+		keyLockClass, pq.Int64Array(keyLockBucketsOf(diagKeys)))
+	if err != nil {
+		return fmt.Errorf("postgres: could not lock diagnosis keys: %w", err)
+	}
+	return nil
+}
+
+// keyLockBucketsOf returns the distinct lock buckets of diagKeys, in order.
+// Keys are random, so their first bytes are evenly distributed.
+func keyLockBucketsOf(diagKeys []diag.DiagnosisKey) []int64 {
+	seen := make(map[int64]bool)
+	var buckets []int64
+	for _, diagKey := range diagKeys {
+		bucket := int64(binary.BigEndian.Uint32(diagKey.TemporaryExposureKey[:4]) % keyLockBuckets)
+		if !seen[bucket] {
+			seen[bucket] = true
+			buckets = append(buckets, bucket)
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+	return buckets
+}
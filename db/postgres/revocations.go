@@ -0,0 +1,105 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// Operation names of revocations, used as metric labels.
+const (
+	opStoreRevocations   = "store_revocations"
+	opFindAllRevocations = "find_all_revocations"
+)
+
+// StoreRevocations persists revoked Temporary Exposure Keys. Keys that were
+// already revoked are silently ignored.
+func (c *Client) StoreRevocations(ctx context.Context, keys [][16]byte, revokedAt time.Time) (err error) {
+	if len(keys) == 0 {
+		return diag.ErrNilRevokedKeys
+	}
+
+	if revokedAt.IsZero() {
+		return errors.New("postgres: revokedAt cannot be zero")
+	}
+
+	start := time.Now()
+	defer func() { c.observe(opStoreRevocations, start, len(keys), err) }()
+
+	ctx, cancel := withTimeout(ctx, c.writeTimeout)
+	defer cancel()
+
+	tx, err := c.beginTx(ctx, c.writeTimeout, false)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO revoked_keys (temporary_exposure_key, revoked_at) VALUES ($1, $2)
+	ON CONFLICT ON CONSTRAINT revoked_keys_pkey DO NOTHING`
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("postgres: could not prepare statement: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, key := range keys {
+		if _, err := stmt.ExecContext(ctx, key[:], revokedAt); err != nil {
+			return fmt.Errorf("postgres: could not execute statement: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("postgres: cannot commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// FindAllRevocations finds all revoked Temporary Exposure Keys, ordered by
+// revocation.
+func (c *Client) FindAllRevocations(ctx context.Context) (_ []diag.Revocation, err error) {
+	var rowCount int
+	start := time.Now()
+	defer func() { c.observe(opFindAllRevocations, start, rowCount, err) }()
+
+	ctx, cancel := withTimeout(ctx, c.readTimeout)
+	defer cancel()
+
+	tx, err := c.beginTx(ctx, c.readTimeout, true)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT temporary_exposure_key, revoked_at FROM revoked_keys ORDER BY index ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: could not execute query: %v", err)
+	}
+	defer rows.Close()
+
+	var revocations []diag.Revocation
+	for rows.Next() {
+		rowCount++
+		var revocation diag.Revocation
+		key := revocation.TemporaryExposureKey[:0]
+		if err := rows.Scan(&key, &revocation.RevokedAt); err != nil {
+			return nil, fmt.Errorf("postgres: could not scan row: %v", err)
+		}
+		copy(revocation.TemporaryExposureKey[:], key)
+		revocation.RevokedAt = revocation.RevokedAt.In(time.UTC)
+
+		revocations = append(revocations, revocation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: could not iterate over rows: %v", err)
+	}
+
+	rowsScanned.Add(float64(rowCount), opFindAllRevocations)
+
+	return revocations, nil
+}
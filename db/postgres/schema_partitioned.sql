@@ -0,0 +1,76 @@
+-- Alternative schema for large deployments, with `diagnosis_keys` partitioned
+-- by upload time. Use with the `-partition` flag set to `daily` or `weekly`;
+-- partitions are created on demand, and retention drops expired partitions
+-- instead of deleting rows.
+--
+-- Because unique constraints on partitioned tables must include the partition
+-- key, uniqueness of `temporary_exposure_key` across partitions is enforced by
+-- the client on insert, not by the database: inserts of the same keys are
+-- serialized with transaction level advisory locks.
+CREATE TABLE diagnosis_keys
+(
+    temporary_exposure_key bytea NOT NULL,
+    rolling_start_number bigint NOT NULL, -- We don't really need 64 bytes, but uint32's range doesn't fit in `integer`
+    transmission_risk_level bytea NOT NULL,
+    uploaded_at timestamp with time zone NOT NULL,
+    origin text, -- Region of the peer server federated keys were pulled from, NULL for uploaded keys
+    regions text[], -- Regions the keys were tagged with on upload, NULL for untagged keys
+    report_type smallint, -- Report type (see diag.ReportType), NULL if unknown
+    days_since_onset_of_symptoms smallint, -- NULL if unknown
+    rolling_period smallint, -- NULL for the default rolling period (144)
+    published_at timestamp with time zone, -- Publication time of keys stored late, NULL if published at their upload time
+    index bigserial NOT NULL,
+    CONSTRAINT diagnosis_keys_pkey PRIMARY KEY (temporary_exposure_key, uploaded_at)
+) PARTITION BY RANGE (uploaded_at);
+
+CREATE INDEX index_idx
+    ON diagnosis_keys USING btree
+    (index ASC);
+
+CREATE INDEX diagnosis_keys_uploaded_at_idx
+    ON diagnosis_keys USING btree
+    (uploaded_at ASC);
+
+CREATE TABLE revoked_keys
+(
+    temporary_exposure_key bytea NOT NULL,
+    revoked_at timestamp with time zone NOT NULL,
+    index bigserial NOT NULL UNIQUE,
+    CONSTRAINT revoked_keys_pkey PRIMARY KEY (temporary_exposure_key)
+);
+
+CREATE TABLE quarantined_keys
+(
+    temporary_exposure_key bytea NOT NULL,
+    rolling_start_number bigint NOT NULL,
+    transmission_risk_level bytea NOT NULL,
+    uploaded_at timestamp with time zone NOT NULL,
+    reason text NOT NULL, -- Invariant the key violated, see `ct-diag-server fsck`
+    quarantined_at timestamp with time zone NOT NULL
+);
+
+CREATE TABLE job_runs
+(
+    id bigserial NOT NULL,
+    type text NOT NULL,
+    started_at timestamp with time zone NOT NULL,
+    ended_at timestamp with time zone NOT NULL,
+    error text,
+    retry_of bigint,
+    CONSTRAINT job_runs_pkey PRIMARY KEY (id)
+);
+
+CREATE INDEX job_runs_failed_idx
+    ON job_runs USING btree
+    (id DESC)
+    WHERE error IS NOT NULL;
+
+CREATE TABLE exposure_configs
+(
+    id text NOT NULL,
+    effective_from timestamp with time zone NOT NULL,
+    region text NOT NULL DEFAULT '',
+    app_version text NOT NULL DEFAULT '',
+    config jsonb NOT NULL,
+    CONSTRAINT exposure_configs_pkey PRIMARY KEY (id)
+);
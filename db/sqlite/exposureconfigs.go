@@ -0,0 +1,56 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// StoreExposureConfig persists a version of the exposure configuration.
+func (c *Client) StoreExposureConfig(ctx context.Context, version diag.ExposureConfigVersion) error {
+	buf, err := json.Marshal(version.Config)
+	if err != nil {
+		return fmt.Errorf("sqlite: could not encode exposure config: %v", err)
+	}
+
+	_, err = c.db.ExecContext(ctx, `INSERT INTO exposure_configs (id, effective_from, region, app_version, config) VALUES (?, ?, ?, ?, ?)`,
+		version.ID, version.EffectiveFrom.UnixNano(), version.Region, version.AppVersion, string(buf),
+	)
+	if err != nil {
+		return fmt.Errorf("sqlite: could not execute query: %v", err)
+	}
+
+	return nil
+}
+
+// FindExposureConfigs finds all versions of the exposure configuration,
+// ordered by their effective time.
+func (c *Client) FindExposureConfigs(ctx context.Context) (diag.ExposureConfigHistory, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT id, effective_from, region, app_version, config FROM exposure_configs ORDER BY effective_from`)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: could not execute query: %v", err)
+	}
+	defer rows.Close()
+
+	var history diag.ExposureConfigHistory
+	for rows.Next() {
+		var version diag.ExposureConfigVersion
+		var effectiveFrom int64
+		var config string
+		if err := rows.Scan(&version.ID, &effectiveFrom, &version.Region, &version.AppVersion, &config); err != nil {
+			return nil, fmt.Errorf("sqlite: could not scan row: %v", err)
+		}
+		if err := json.Unmarshal([]byte(config), &version.Config); err != nil {
+			return nil, fmt.Errorf("sqlite: could not decode exposure config %q: %v", version.ID, err)
+		}
+		version.EffectiveFrom = fromUnixNano(effectiveFrom)
+		history = append(history, version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlite: could not iterate over rows: %v", err)
+	}
+
+	return history, nil
+}
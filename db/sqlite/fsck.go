@@ -0,0 +1,87 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// ScanDiagnosisKeys calls fn for every stored Diagnosis Key, as stored,
+// ordered by upload. If fn returns an error, the scan is aborted.
+func (c *Client) ScanDiagnosisKeys(ctx context.Context, fn func(diag.RawDiagnosisKey) error) error {
+	rows, err := c.db.QueryContext(ctx, `SELECT temporary_exposure_key, rolling_start_number, transmission_risk_level, uploaded_at
+	FROM diagnosis_keys
+	ORDER BY id ASC`)
+	if err != nil {
+		return fmt.Errorf("sqlite: could not execute query: %v", err)
+	}
+	defer rows.Close()
+
+	// The rows are read before calling fn, as the single connection is busy
+	// until then.
+	var rawKeys []diag.RawDiagnosisKey
+	for rows.Next() {
+		var rawKey diag.RawDiagnosisKey
+		var transmissionRiskLevel, uploadedAt int64
+		if err := rows.Scan(&rawKey.TemporaryExposureKey, &rawKey.RollingStartNumber, &transmissionRiskLevel, &uploadedAt); err != nil {
+			return fmt.Errorf("sqlite: could not scan row: %v", err)
+		}
+		if transmissionRiskLevel >= 0 && transmissionRiskLevel <= 255 {
+			rawKey.TransmissionRiskLevel = []byte{byte(transmissionRiskLevel)}
+		}
+		rawKey.UploadedAt = fromUnixNano(uploadedAt)
+
+		rawKeys = append(rawKeys, rawKey)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("sqlite: could not iterate over rows: %v", err)
+	}
+	rows.Close()
+
+	for _, rawKey := range rawKeys {
+		if err := fn(rawKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// QuarantineDiagnosisKeys moves the keys of violations from `diagnosis_keys`
+// to `quarantined_keys`, along with their reason, and returns the amount of
+// moved keys.
+func (c *Client) QuarantineDiagnosisKeys(ctx context.Context, violations []diag.Violation, quarantinedAt time.Time) (int64, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("sqlite: could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var n int64
+	for _, v := range violations {
+		_, err := tx.ExecContext(ctx, `INSERT INTO quarantined_keys (temporary_exposure_key, rolling_start_number, transmission_risk_level, uploaded_at, reason, quarantined_at)
+		SELECT temporary_exposure_key, rolling_start_number, transmission_risk_level, uploaded_at, ?, ?
+		FROM diagnosis_keys
+		WHERE temporary_exposure_key = ?`, v.Reason, quarantinedAt.UnixNano(), v.Key.TemporaryExposureKey)
+		if err != nil {
+			return 0, fmt.Errorf("sqlite: could not execute query: %v", err)
+		}
+		res, err := tx.ExecContext(ctx, `DELETE FROM diagnosis_keys WHERE temporary_exposure_key = ?`, v.Key.TemporaryExposureKey)
+		if err != nil {
+			return 0, fmt.Errorf("sqlite: could not execute query: %v", err)
+		}
+		moved, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("sqlite: could not get affected rows: %v", err)
+		}
+		n += moved
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("sqlite: cannot commit transaction: %v", err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,84 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// StoreJobRun persists a background job run, and returns its ID.
+func (c *Client) StoreJobRun(ctx context.Context, run diag.JobRun) (int64, error) {
+	res, err := c.db.ExecContext(ctx, `INSERT INTO job_runs (type, started_at, ended_at, error, retry_of) VALUES (?, ?, ?, ?, ?)`,
+		run.Type, run.StartedAt.UnixNano(), run.EndedAt.UnixNano(),
+		sql.NullString{String: run.Error, Valid: run.Error != ""},
+		sql.NullInt64{Int64: run.RetryOf, Valid: run.RetryOf != 0},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("sqlite: could not execute query: %v", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("sqlite: could not get inserted ID: %v", err)
+	}
+
+	return id, nil
+}
+
+// FindJobRuns finds the latest job runs, newest first. If failedOnly is true,
+// only failed runs are returned.
+func (c *Client) FindJobRuns(ctx context.Context, limit int, failedOnly bool) ([]diag.JobRun, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT id, type, started_at, ended_at, error, retry_of FROM job_runs
+	WHERE ? = 0 OR error IS NOT NULL ORDER BY id DESC LIMIT ?`, failedOnly, limit)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: could not execute query: %v", err)
+	}
+	defer rows.Close()
+
+	var runs []diag.JobRun
+	for rows.Next() {
+		run, err := scanJobRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlite: could not iterate over rows: %v", err)
+	}
+
+	return runs, nil
+}
+
+// FindJobRun finds a job run by ID. If it doesn't exist, diag.ErrJobRunNotFound
+// is returned.
+func (c *Client) FindJobRun(ctx context.Context, id int64) (diag.JobRun, error) {
+	row := c.db.QueryRowContext(ctx, `SELECT id, type, started_at, ended_at, error, retry_of FROM job_runs WHERE id = ?`, id)
+	run, err := scanJobRun(row)
+	if err == sql.ErrNoRows {
+		return diag.JobRun{}, diag.ErrJobRunNotFound
+	}
+
+	return run, err
+}
+
+// scanJobRun scans a row of `job_runs`.
+func scanJobRun(row interface{ Scan(...interface{}) error }) (diag.JobRun, error) {
+	var run diag.JobRun
+	var startedAt, endedAt int64
+	var runErr sql.NullString
+	var retryOf sql.NullInt64
+	if err := row.Scan(&run.ID, &run.Type, &startedAt, &endedAt, &runErr, &retryOf); err != nil {
+		if err == sql.ErrNoRows {
+			return diag.JobRun{}, err
+		}
+		return diag.JobRun{}, fmt.Errorf("sqlite: could not scan row: %v", err)
+	}
+	run.StartedAt = fromUnixNano(startedAt)
+	run.EndedAt = fromUnixNano(endedAt)
+	run.Error = runErr.String
+	run.RetryOf = retryOf.Int64
+
+	return run, nil
+}
@@ -0,0 +1,29 @@
+-- Initial schema, equivalent to `db/postgres/schema.sql`. Times are stored as
+-- Unix nanoseconds.
+CREATE TABLE diagnosis_keys
+(
+    id integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    temporary_exposure_key blob NOT NULL UNIQUE,
+    rolling_start_number integer NOT NULL,
+    transmission_risk_level integer NOT NULL,
+    uploaded_at integer NOT NULL
+);
+
+CREATE INDEX diagnosis_keys_uploaded_at_idx ON diagnosis_keys (uploaded_at);
+
+CREATE TABLE revoked_keys
+(
+    id integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    temporary_exposure_key blob NOT NULL UNIQUE,
+    revoked_at integer NOT NULL
+);
+
+CREATE TABLE job_runs
+(
+    id integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    type text NOT NULL,
+    started_at integer NOT NULL,
+    ended_at integer NOT NULL,
+    error text,
+    retry_of integer
+);
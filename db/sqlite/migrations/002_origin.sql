@@ -0,0 +1,3 @@
+-- Adds the origin of Diagnosis Keys pulled from peer servers (see package
+-- federation). Keys uploaded to this server have no origin.
+ALTER TABLE diagnosis_keys ADD COLUMN origin text;
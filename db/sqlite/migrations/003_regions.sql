@@ -0,0 +1,3 @@
+-- Adds the `regions` column, for the comma separated regions Diagnosis Keys
+-- were tagged with on upload (see diag.ParseRegions). NULL for untagged keys.
+ALTER TABLE diagnosis_keys ADD COLUMN regions text;
@@ -0,0 +1,13 @@
+-- Adds the `quarantined_keys` table, for Diagnosis Keys moved out of
+-- `diagnosis_keys` by `ct-diag-server fsck -quarantine`, with the invariant
+-- they violated.
+CREATE TABLE quarantined_keys
+(
+    id integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    temporary_exposure_key blob NOT NULL,
+    rolling_start_number integer NOT NULL,
+    transmission_risk_level integer NOT NULL,
+    uploaded_at integer NOT NULL,
+    reason text NOT NULL,
+    quarantined_at integer NOT NULL
+);
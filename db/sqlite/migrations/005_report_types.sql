@@ -0,0 +1,5 @@
+-- Adds the `report_type` and `days_since_onset_of_symptoms` columns, for the
+-- report types and days since onset of symptoms of Diagnosis Keys (see
+-- diag.ReportType). NULL if unknown.
+ALTER TABLE diagnosis_keys ADD COLUMN report_type integer;
+ALTER TABLE diagnosis_keys ADD COLUMN days_since_onset_of_symptoms integer;
@@ -0,0 +1,3 @@
+-- Adds the `rolling_period` column, for the rolling periods of Diagnosis Keys
+-- (see diag.DiagnosisKey). NULL for the default rolling period.
+ALTER TABLE diagnosis_keys ADD COLUMN rolling_period integer;
@@ -0,0 +1,8 @@
+-- Adds the `exposure_configs` table, for the versions of the exposure
+-- configuration stored via `PUT /exposure-config`.
+CREATE TABLE exposure_configs
+(
+    id text NOT NULL PRIMARY KEY,
+    effective_from integer NOT NULL,
+    config text NOT NULL
+);
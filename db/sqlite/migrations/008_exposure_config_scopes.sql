@@ -0,0 +1,5 @@
+-- Adds the `region` and `app_version` columns, for the scope of versions of the
+-- exposure configuration (see diag.ExposureConfigScope). Empty for the default
+-- scope.
+ALTER TABLE exposure_configs ADD COLUMN region text NOT NULL DEFAULT '';
+ALTER TABLE exposure_configs ADD COLUMN app_version text NOT NULL DEFAULT '';
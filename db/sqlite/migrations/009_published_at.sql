@@ -0,0 +1,4 @@
+-- Adds the `published_at` column, for the publication times of keys that were
+-- stored late (see diag.PublicationTimeStorer). NULL for keys published at
+-- their upload time.
+ALTER TABLE diagnosis_keys ADD COLUMN published_at integer;
@@ -0,0 +1,74 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// StoreRevocations persists revoked Temporary Exposure Keys. Keys that were
+// already revoked are silently ignored.
+func (c *Client) StoreRevocations(ctx context.Context, keys [][16]byte, revokedAt time.Time) error {
+	if len(keys) == 0 {
+		return diag.ErrNilRevokedKeys
+	}
+	if revokedAt.IsZero() {
+		return errors.New("sqlite: revokedAt cannot be zero")
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("sqlite: could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT OR IGNORE INTO revoked_keys (temporary_exposure_key, revoked_at) VALUES (?, ?)`)
+	if err != nil {
+		return fmt.Errorf("sqlite: could not prepare statement: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, key := range keys {
+		if _, err := stmt.ExecContext(ctx, key[:], revokedAt.UnixNano()); err != nil {
+			return fmt.Errorf("sqlite: could not execute statement: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("sqlite: cannot commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// FindAllRevocations finds all revoked Temporary Exposure Keys, ordered by
+// revocation.
+func (c *Client) FindAllRevocations(ctx context.Context) ([]diag.Revocation, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT temporary_exposure_key, revoked_at FROM revoked_keys ORDER BY id ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: could not execute query: %v", err)
+	}
+	defer rows.Close()
+
+	var revocations []diag.Revocation
+	for rows.Next() {
+		var revocation diag.Revocation
+		var key []byte
+		var revokedAt int64
+		if err := rows.Scan(&key, &revokedAt); err != nil {
+			return nil, fmt.Errorf("sqlite: could not scan row: %v", err)
+		}
+		copy(revocation.TemporaryExposureKey[:], key)
+		revocation.RevokedAt = fromUnixNano(revokedAt)
+
+		revocations = append(revocations, revocation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlite: could not iterate over rows: %v", err)
+	}
+
+	return revocations, nil
+}
@@ -0,0 +1,448 @@
+// Package sqlite provides an implementation of diag.Repository using SQLite
+// for underlying database storage, for small deployments and local
+// development. The schema is created and migrated on startup.
+//
+// The SQLite driver requires cgo: build with `CGO_ENABLED=1`.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+
+	// Registers the `sqlite3` driver.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// busyTimeout is the time to wait for a lock on the database file, e.g. held
+// by another process.
+const busyTimeout = 5 * time.Second
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Client implements diag.Repository.
+type Client struct {
+	db *sql.DB
+}
+
+// Config represents the configuration to create a Client.
+type Config struct {
+	// Path is the path of the database file, which is created if it doesn't
+	// exist.
+	Path string
+}
+
+// New returns a new Client, and migrates the database schema.
+func New(ctx context.Context, cfg Config) (*Client, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("sqlite: path cannot be empty")
+	}
+
+	dsn := fmt.Sprintf("file:%v?_busy_timeout=%d&_journal_mode=WAL", cfg.Path, busyTimeout.Milliseconds())
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: could not open database: %v", err)
+	}
+	// SQLite allows a single writer; serializing all access on one connection
+	// avoids `database is locked` errors within the process.
+	db.SetMaxOpenConns(1)
+
+	c := &Client{db: db}
+	if err := c.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// migrate applies the embedded migrations that weren't applied yet. The
+// amount of applied migrations is tracked with the `user_version` pragma.
+func (c *Client) migrate(ctx context.Context) error {
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return fmt.Errorf("sqlite: could not list migrations: %v", err)
+	}
+	sort.Strings(names)
+
+	var version int
+	if err := c.db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("sqlite: could not read schema version: %v", err)
+	}
+	if version > len(names) {
+		return fmt.Errorf("sqlite: schema version %v is newer than supported (%v)", version, len(names))
+	}
+
+	for i, name := range names[version:] {
+		stmts, err := migrations.ReadFile(name)
+		if err != nil {
+			return fmt.Errorf("sqlite: could not read migration: %v", err)
+		}
+
+		tx, err := c.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("sqlite: could not start transaction: %v", err)
+		}
+		if _, err := tx.ExecContext(ctx, string(stmts)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("sqlite: could not apply migration %v: %v", name, err)
+		}
+		// Pragmas don't support placeholders.
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, version+i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("sqlite: could not set schema version: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("sqlite: cannot commit transaction: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Ping verifies the database is accessible.
+func (c *Client) Ping() error {
+	return c.db.Ping()
+}
+
+// PingContext verifies the database is accessible, subject to the deadline of
+// ctx. It implements diag.Pinger.
+func (c *Client) PingContext(ctx context.Context) error {
+	return c.db.PingContext(ctx)
+}
+
+// Close closes the database.
+func (c *Client) Close() error {
+	return c.db.Close()
+}
+
+// StoreDiagnosisKeys persists an array of diagnosis keys in the database.
+// Keys that were already stored are silently ignored.
+func (c *Client) StoreDiagnosisKeys(ctx context.Context, diagKeys []diag.DiagnosisKey, uploadedAt time.Time) error {
+	_, err := c.storeDiagnosisKeys(ctx, diagKeys, uploadedAt, sql.NullString{})
+	return err
+}
+
+// InsertDiagnosisKeys implements diag.KeyInserter.
+func (c *Client) InsertDiagnosisKeys(ctx context.Context, diagKeys []diag.DiagnosisKey, uploadedAt time.Time) ([]diag.DiagnosisKey, error) {
+	return c.storeDiagnosisKeys(ctx, diagKeys, uploadedAt, sql.NullString{})
+}
+
+// StoreDiagnosisKeysWithOrigin implements diag.OriginStorer.
+func (c *Client) StoreDiagnosisKeysWithOrigin(ctx context.Context, diagKeys []diag.DiagnosisKey, uploadedAt time.Time, origin string) error {
+	_, err := c.storeDiagnosisKeys(ctx, diagKeys, uploadedAt, sql.NullString{String: origin, Valid: origin != ""})
+	return err
+}
+
+// storeDiagnosisKeys stores diagKeys, and returns the keys that weren't stored
+// yet.
+func (c *Client) storeDiagnosisKeys(ctx context.Context, diagKeys []diag.DiagnosisKey, uploadedAt time.Time, origin sql.NullString) ([]diag.DiagnosisKey, error) {
+	if len(diagKeys) == 0 {
+		return nil, diag.ErrNilDiagKeys
+	}
+	if uploadedAt.IsZero() {
+		return nil, errors.New("sqlite: uploadedAt cannot be zero")
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	query := `INSERT OR IGNORE INTO diagnosis_keys (temporary_exposure_key, rolling_start_number, transmission_risk_level, uploaded_at, origin, regions, report_type, days_since_onset_of_symptoms, rolling_period)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: could not prepare statement: %v", err)
+	}
+	defer stmt.Close()
+
+	var inserted []diag.DiagnosisKey
+	for _, diagKey := range diagKeys {
+		res, err := stmt.ExecContext(ctx,
+			diagKey.TemporaryExposureKey[:],
+			diagKey.RollingStartNumber,
+			diagKey.TransmissionRiskLevel,
+			uploadedAt.UnixNano(),
+			origin,
+			encodeRegions(diagKey.Regions),
+			sql.NullInt32{Int32: int32(diagKey.ReportType), Valid: diagKey.ReportType != diag.ReportTypeUnknown},
+			encodeDaysSinceOnset(diagKey.DaysSinceOnsetOfSymptoms),
+			sql.NullInt32{Int32: int32(diagKey.RollingPeriod), Valid: diagKey.RollingPeriod != 0},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("sqlite: could not execute statement: %v", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, fmt.Errorf("sqlite: could not get affected rows: %v", err)
+		}
+		if n > 0 {
+			inserted = append(inserted, diagKey)
+			continue
+		}
+		// Keys that are stored already get the regions of this upload too.
+		if len(diagKey.Regions) > 0 {
+			if err := mergeRegions(ctx, tx, diagKey); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("sqlite: cannot commit transaction: %v", err)
+	}
+
+	return inserted, nil
+}
+
+// mergeRegions adds the regions of diagKey to the regions of the stored key
+// (see diag.MergeRegions).
+func mergeRegions(ctx context.Context, tx *sql.Tx, diagKey diag.DiagnosisKey) error {
+	var stored sql.NullString
+	err := tx.QueryRowContext(ctx, `SELECT regions FROM diagnosis_keys WHERE temporary_exposure_key = ?`,
+		diagKey.TemporaryExposureKey[:]).Scan(&stored)
+	if err != nil {
+		return fmt.Errorf("sqlite: could not query regions: %v", err)
+	}
+
+	regions := decodeRegions(stored)
+	merged := diag.MergeRegions(regions, diagKey.Regions)
+	if len(merged) == len(regions) {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE diagnosis_keys SET regions = ? WHERE temporary_exposure_key = ?`,
+		encodeRegions(merged), diagKey.TemporaryExposureKey[:])
+	if err != nil {
+		return fmt.Errorf("sqlite: could not merge regions: %v", err)
+	}
+	return nil
+}
+
+// FindAllDiagnosisKeys finds all the Diagnosis Keys, ordered by upload.
+func (c *Client) FindAllDiagnosisKeys(ctx context.Context) ([]diag.DiagnosisKey, error) {
+	// Keys that were stored late are listed at their publication time.
+	rows, err := c.db.QueryContext(ctx, `SELECT temporary_exposure_key, rolling_start_number, transmission_risk_level, coalesce(published_at, uploaded_at), regions, report_type, days_since_onset_of_symptoms, rolling_period
+	FROM diagnosis_keys
+	ORDER BY id ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: could not execute query: %v", err)
+	}
+	defer rows.Close()
+
+	var diagKeys []diag.DiagnosisKey
+	for rows.Next() {
+		var diagKey diag.DiagnosisKey
+		var key []byte
+		var uploadedAt int64
+		var regions sql.NullString
+		var reportType, days, rollingPeriod sql.NullInt32
+		if err := rows.Scan(&key, &diagKey.RollingStartNumber, &diagKey.TransmissionRiskLevel, &uploadedAt, &regions, &reportType, &days, &rollingPeriod); err != nil {
+			return nil, fmt.Errorf("sqlite: could not scan row: %v", err)
+		}
+		copy(diagKey.TemporaryExposureKey[:], key)
+		diagKey.UploadedAt = fromUnixNano(uploadedAt)
+		diagKey.Regions = decodeRegions(regions)
+		diagKey.ReportType = diag.ReportType(reportType.Int32)
+		if days.Valid {
+			diagKey.DaysSinceOnsetOfSymptoms = &days.Int32
+		}
+		diagKey.RollingPeriod = uint8(rollingPeriod.Int32)
+
+		diagKeys = append(diagKeys, diagKey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlite: could not iterate over rows: %v", err)
+	}
+
+	return diagKeys, nil
+}
+
+// FindLocalDiagnosisKeys finds at most limit Diagnosis Keys without origin,
+// i.e. uploaded to this server, with an id greater than after, ordered by id.
+func (c *Client) FindLocalDiagnosisKeys(ctx context.Context, after int64, limit int) ([]diag.StoredDiagnosisKey, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT temporary_exposure_key, rolling_start_number, transmission_risk_level, uploaded_at, id
+	FROM diagnosis_keys
+	WHERE origin IS NULL AND id > ?
+	ORDER BY id ASC
+	LIMIT ?`, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: could not execute query: %v", err)
+	}
+	defer rows.Close()
+
+	var diagKeys []diag.StoredDiagnosisKey
+	for rows.Next() {
+		var diagKey diag.StoredDiagnosisKey
+		var key []byte
+		var uploadedAt int64
+		if err := rows.Scan(&key, &diagKey.RollingStartNumber, &diagKey.TransmissionRiskLevel, &uploadedAt, &diagKey.Index); err != nil {
+			return nil, fmt.Errorf("sqlite: could not scan row: %v", err)
+		}
+		copy(diagKey.TemporaryExposureKey[:], key)
+		diagKey.UploadedAt = fromUnixNano(uploadedAt)
+
+		diagKeys = append(diagKeys, diagKey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlite: could not iterate over rows: %v", err)
+	}
+
+	return diagKeys, nil
+}
+
+// FindDiagnosisKeyByTEK finds the Diagnosis Key with the given Temporary
+// Exposure Key. If it isn't stored, diag.ErrKeyNotFound is returned.
+func (c *Client) FindDiagnosisKeyByTEK(ctx context.Context, tek [16]byte) (diag.StoredDiagnosisKey, error) {
+	diagKey := diag.StoredDiagnosisKey{DiagnosisKey: diag.DiagnosisKey{TemporaryExposureKey: tek}}
+	var uploadedAt int64
+	err := c.db.QueryRowContext(ctx, `SELECT rolling_start_number, transmission_risk_level, uploaded_at, id
+	FROM diagnosis_keys
+	WHERE temporary_exposure_key = ?`, tek[:]).Scan(&diagKey.RollingStartNumber, &diagKey.TransmissionRiskLevel, &uploadedAt, &diagKey.Index)
+	if err == sql.ErrNoRows {
+		return diag.StoredDiagnosisKey{}, diag.ErrKeyNotFound
+	}
+	if err != nil {
+		return diag.StoredDiagnosisKey{}, fmt.Errorf("sqlite: could not execute query: %v", err)
+	}
+	diagKey.UploadedAt = fromUnixNano(uploadedAt)
+
+	return diagKey, nil
+}
+
+// EstimateKeyCount returns the amount of stored Diagnosis Keys. For the sizes
+// SQLite is meant for, an exact count is cheap enough.
+func (c *Client) EstimateKeyCount(ctx context.Context) (int64, error) {
+	var n int64
+	if err := c.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM diagnosis_keys`).Scan(&n); err != nil {
+		return 0, fmt.Errorf("sqlite: could not execute query: %v", err)
+	}
+	return n, nil
+}
+
+// LastModified returns the latest upload time of the Diagnosis Keys. Keys
+// imported later with an older upload time (e.g. federation backfill) don't
+// move it backwards.
+func (c *Client) LastModified(ctx context.Context) (time.Time, error) {
+	var lastModified int64
+	err := c.db.QueryRowContext(ctx, `SELECT uploaded_at FROM diagnosis_keys ORDER BY uploaded_at DESC LIMIT 1`).Scan(&lastModified)
+	if err == sql.ErrNoRows {
+		return time.Time{}, diag.ErrNilDiagKeys
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("sqlite: could not execute query: %v", err)
+	}
+
+	return fromUnixNano(lastModified), nil
+}
+
+// DeleteDiagnosisKeysBefore deletes all Diagnosis Keys uploaded before t, and
+// returns the amount of deleted keys.
+func (c *Client) DeleteDiagnosisKeysBefore(ctx context.Context, t time.Time) (int64, error) {
+	res, err := c.db.ExecContext(ctx, `DELETE FROM diagnosis_keys WHERE uploaded_at < ?`, t.UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("sqlite: could not execute query: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("sqlite: could not get affected rows: %v", err)
+	}
+
+	return n, nil
+}
+
+// DeleteDiagnosisKeys implements diag.KeyDeleter: it deletes the Diagnosis
+// Keys with the given Temporary Exposure Keys that were uploaded in the second
+// of uploadedAt, e.g. for a withdrawn upload, and returns the deleted keys.
+func (c *Client) DeleteDiagnosisKeys(ctx context.Context, keys [][16]byte, uploadedAt time.Time) ([][16]byte, error) {
+	from := uploadedAt.Truncate(time.Second)
+	to := from.Add(time.Second)
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var deleted [][16]byte
+	for _, key := range keys {
+		res, err := tx.ExecContext(ctx, `DELETE FROM diagnosis_keys WHERE temporary_exposure_key = ? AND uploaded_at >= ? AND uploaded_at < ?`,
+			key[:], from.UnixNano(), to.UnixNano())
+		if err != nil {
+			return nil, fmt.Errorf("sqlite: could not execute query: %v", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, fmt.Errorf("sqlite: could not get affected rows: %v", err)
+		}
+		if n > 0 {
+			deleted = append(deleted, key)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("sqlite: cannot commit transaction: %v", err)
+	}
+
+	return deleted, nil
+}
+
+// StorePublicationTime implements diag.PublicationTimeStorer.
+func (c *Client) StorePublicationTime(ctx context.Context, keys [][16]byte, publishedAt time.Time) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("sqlite: could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, key := range keys {
+		_, err := tx.ExecContext(ctx, `UPDATE diagnosis_keys SET published_at = ? WHERE temporary_exposure_key = ?`, publishedAt.UnixNano(), key[:])
+		if err != nil {
+			return fmt.Errorf("sqlite: could not execute query: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("sqlite: cannot commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// encodeRegions returns the value of the `regions` column: comma separated
+// regions, or NULL if there are none.
+func encodeRegions(regions []string) sql.NullString {
+	return sql.NullString{String: strings.Join(regions, ","), Valid: len(regions) > 0}
+}
+
+// encodeDaysSinceOnset returns the value of the
+// `days_since_onset_of_symptoms` column: NULL if unknown.
+func encodeDaysSinceOnset(days *int32) sql.NullInt32 {
+	if days == nil {
+		return sql.NullInt32{}
+	}
+	return sql.NullInt32{Int32: *days, Valid: true}
+}
+
+func decodeRegions(v sql.NullString) []string {
+	if !v.Valid || v.String == "" {
+		return nil
+	}
+	return strings.Split(v.String, ",")
+}
+
+func fromUnixNano(v int64) time.Time {
+	return time.Unix(0, v).UTC()
+}
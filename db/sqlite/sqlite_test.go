@@ -0,0 +1,437 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+	client, err := New(context.Background(), Config{Path: filepath.Join(t.TempDir(), "ct-diag.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestStoreDiagnosisKeys(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	key := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	uploadedAt := time.Unix(42, 0).UTC()
+
+	if err := client.StoreDiagnosisKeys(ctx, nil, uploadedAt); err != diag.ErrNilDiagKeys {
+		t.Fatalf("expected: %v, got: %v", diag.ErrNilDiagKeys, err)
+	}
+
+	diagKeys := []diag.DiagnosisKey{{TemporaryExposureKey: key, RollingStartNumber: 42, TransmissionRiskLevel: 50}}
+	for i := 0; i < 2; i++ {
+		// Storing the keys again is a no-op.
+		if err := client.StoreDiagnosisKeys(ctx, diagKeys, uploadedAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := client.FindAllDiagnosisKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []diag.DiagnosisKey{{TemporaryExposureKey: key, RollingStartNumber: 42, TransmissionRiskLevel: 50, UploadedAt: uploadedAt}}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected: %+v, got: %+v", exp, got)
+	}
+
+	lastModified, err := client.LastModified(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lastModified.Equal(uploadedAt) {
+		t.Errorf("expected: %v, got: %v", uploadedAt, lastModified)
+	}
+
+	n, err := client.DeleteDiagnosisKeysBefore(ctx, uploadedAt.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected: 1 deleted key, got: %v", n)
+	}
+	if _, err := client.LastModified(ctx); err != diag.ErrNilDiagKeys {
+		t.Errorf("expected: %v, got: %v", diag.ErrNilDiagKeys, err)
+	}
+}
+
+func TestInsertAndDeleteDiagnosisKeys(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	uploadedAt := time.Unix(42, 0).UTC()
+
+	stored := diag.DiagnosisKey{TemporaryExposureKey: [16]byte{1}}
+	if err := client.StoreDiagnosisKeys(ctx, []diag.DiagnosisKey{stored}, uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only keys that weren't stored yet are returned.
+	uploaded := diag.DiagnosisKey{TemporaryExposureKey: [16]byte{2}}
+	got, err := client.InsertDiagnosisKeys(ctx, []diag.DiagnosisKey{stored, uploaded}, uploadedAt.Add(time.Minute+time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []diag.DiagnosisKey{uploaded}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected: %+v, got: %+v", exp, got)
+	}
+
+	// Only keys uploaded in the second of the given upload time are deleted.
+	keys := [][16]byte{stored.TemporaryExposureKey, uploaded.TemporaryExposureKey}
+	deleted, err := client.DeleteDiagnosisKeys(ctx, keys, uploadedAt.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := [][16]byte{uploaded.TemporaryExposureKey}; !reflect.DeepEqual(deleted, exp) {
+		t.Errorf("expected: %v, got: %v", exp, deleted)
+	}
+
+	diagKeys, err := client.FindAllDiagnosisKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diagKeys) != 1 || diagKeys[0].TemporaryExposureKey != stored.TemporaryExposureKey {
+		t.Errorf("expected only stored key, got: %+v", diagKeys)
+	}
+}
+
+func TestStoreDiagnosisKeysWithOrigin(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	uploadedAt := time.Unix(42, 0).UTC()
+
+	uploaded := diag.DiagnosisKey{TemporaryExposureKey: [16]byte{1}}
+	if err := client.StoreDiagnosisKeys(ctx, []diag.DiagnosisKey{uploaded}, uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+	// Already stored keys keep their origin.
+	pulled := diag.DiagnosisKey{TemporaryExposureKey: [16]byte{2}}
+	if err := client.StoreDiagnosisKeysWithOrigin(ctx, []diag.DiagnosisKey{uploaded, pulled}, uploadedAt, "DE"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		key [16]byte
+		exp sql.NullString
+	}{
+		{uploaded.TemporaryExposureKey, sql.NullString{}},
+		{pulled.TemporaryExposureKey, sql.NullString{String: "DE", Valid: true}},
+	} {
+		var got sql.NullString
+		if err := client.db.QueryRowContext(ctx, `SELECT origin FROM diagnosis_keys WHERE temporary_exposure_key = ?`, tt.key[:]).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.exp {
+			t.Errorf("expected: %+v, got: %+v", tt.exp, got)
+		}
+	}
+}
+
+func TestStoreDiagnosisKeysWithRegions(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	uploadedAt := time.Unix(42, 0).UTC()
+
+	diagKeys := []diag.DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, UploadedAt: uploadedAt, Regions: []string{"NL", "BE"}},
+		{TemporaryExposureKey: [16]byte{2}, UploadedAt: uploadedAt},
+	}
+	if err := client.StoreDiagnosisKeys(ctx, diagKeys, uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := client.FindAllDiagnosisKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, diagKeys) {
+		t.Errorf("expected: %+v, got: %+v", diagKeys, got)
+	}
+}
+
+func TestStoreDiagnosisKeysMergesRegions(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	uploadedAt := time.Unix(42, 0).UTC()
+
+	if err := client.StoreDiagnosisKeys(ctx, []diag.DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, TransmissionRiskLevel: 50, Regions: []string{"NL"}},
+		{TemporaryExposureKey: [16]byte{2}, TransmissionRiskLevel: 50},
+	}, uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys uploaded again for other regions are stored once, with the union
+	// of their regions. Other columns, e.g. the upload time, are kept.
+	uploads := [][]diag.DiagnosisKey{
+		{
+			{TemporaryExposureKey: [16]byte{1}, TransmissionRiskLevel: 10, Regions: []string{"BE", "NL"}},
+			{TemporaryExposureKey: [16]byte{2}, TransmissionRiskLevel: 10, Regions: []string{"DE"}},
+		},
+		{
+			{TemporaryExposureKey: [16]byte{1}, TransmissionRiskLevel: 10, Regions: []string{"NL"}},
+			{TemporaryExposureKey: [16]byte{2}, TransmissionRiskLevel: 10},
+		},
+	}
+	for _, diagKeys := range uploads {
+		if err := client.StoreDiagnosisKeys(ctx, diagKeys, uploadedAt.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := client.FindAllDiagnosisKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []diag.DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, TransmissionRiskLevel: 50, UploadedAt: uploadedAt, Regions: []string{"BE", "NL"}},
+		{TemporaryExposureKey: [16]byte{2}, TransmissionRiskLevel: 50, UploadedAt: uploadedAt, Regions: []string{"DE"}},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected: %+v, got: %+v", exp, got)
+	}
+}
+
+func TestStoreDiagnosisKeysWithReportTypes(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	uploadedAt := time.Unix(42, 0).UTC()
+	days := int32(-2)
+
+	diagKeys := []diag.DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, UploadedAt: uploadedAt, ReportType: diag.ReportTypeConfirmedTest, DaysSinceOnsetOfSymptoms: &days},
+		{TemporaryExposureKey: [16]byte{2}, UploadedAt: uploadedAt},
+	}
+	if err := client.StoreDiagnosisKeys(ctx, diagKeys, uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := client.FindAllDiagnosisKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, diagKeys) {
+		t.Errorf("expected: %+v, got: %+v", diagKeys, got)
+	}
+}
+
+func TestStoreDiagnosisKeysWithRollingPeriods(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	uploadedAt := time.Unix(42, 0).UTC()
+
+	diagKeys := []diag.DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, UploadedAt: uploadedAt, RollingPeriod: 72},
+		{TemporaryExposureKey: [16]byte{2}, UploadedAt: uploadedAt},
+	}
+	if err := client.StoreDiagnosisKeys(ctx, diagKeys, uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := client.FindAllDiagnosisKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, diagKeys) {
+		t.Errorf("expected: %+v, got: %+v", diagKeys, got)
+	}
+}
+
+func TestFindLocalDiagnosisKeys(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	uploadedAt := time.Unix(42, 0).UTC()
+
+	local := []diag.DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, TransmissionRiskLevel: 50},
+		{TemporaryExposureKey: [16]byte{2}, TransmissionRiskLevel: 50},
+		{TemporaryExposureKey: [16]byte{3}, TransmissionRiskLevel: 50},
+	}
+	if err := client.StoreDiagnosisKeys(ctx, local[:1], uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+	pulled := diag.DiagnosisKey{TemporaryExposureKey: [16]byte{4}, TransmissionRiskLevel: 50}
+	if err := client.StoreDiagnosisKeysWithOrigin(ctx, []diag.DiagnosisKey{pulled}, uploadedAt, "DE"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.StoreDiagnosisKeys(ctx, local[1:], uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys with an origin are skipped, and pages continue after the index of
+	// their last key.
+	var got []diag.DiagnosisKey
+	var after int64
+	for {
+		page, err := client.FindLocalDiagnosisKeys(ctx, after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, diagKey := range page {
+			got = append(got, diagKey.DiagnosisKey)
+		}
+		after = page[len(page)-1].Index
+	}
+	for i := range local {
+		local[i].UploadedAt = uploadedAt
+	}
+	if !reflect.DeepEqual(got, local) {
+		t.Errorf("expected: %v, got: %v", local, got)
+	}
+}
+
+func TestQuarantineDiagnosisKeys(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	uploadedAt := time.Unix(42, 0).UTC()
+
+	diagKeys := []diag.DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: 42, TransmissionRiskLevel: 50},
+		{TemporaryExposureKey: [16]byte{2}, RollingStartNumber: 0, TransmissionRiskLevel: 50},
+	}
+	if err := client.StoreDiagnosisKeys(ctx, diagKeys, uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+	// A key that can't be decoded into a diag.DiagnosisKey.
+	_, err := client.db.ExecContext(ctx, `INSERT INTO diagnosis_keys (temporary_exposure_key, rolling_start_number, transmission_risk_level, uploaded_at)
+	VALUES (?, 42, 300, ?)`, []byte{3}, uploadedAt.UnixNano())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	violations, err := diag.Fsck(ctx, client, time.Unix(600*42, 0), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) != 2 {
+		t.Fatalf("expected 2 violations, got: %+v", violations)
+	}
+
+	n, err := client.QuarantineDiagnosisKeys(ctx, violations, time.Unix(43, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected: 2, got: %v", n)
+	}
+
+	got, err := client.FindAllDiagnosisKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []diag.DiagnosisKey{{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: 42, TransmissionRiskLevel: 50, UploadedAt: uploadedAt}}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected: %+v, got: %+v", exp, got)
+	}
+
+	var quarantined int
+	if err := client.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM quarantined_keys`).Scan(&quarantined); err != nil {
+		t.Fatal(err)
+	}
+	if quarantined != 2 {
+		t.Errorf("expected: 2, got: %v", quarantined)
+	}
+}
+
+func TestStorePublicationTime(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	uploadedAt := time.Unix(42, 0).UTC()
+
+	diagKeys := []diag.DiagnosisKey{{TemporaryExposureKey: [16]byte{1}}, {TemporaryExposureKey: [16]byte{2}}}
+	if err := client.StoreDiagnosisKeys(ctx, diagKeys, uploadedAt); err != nil {
+		t.Fatal(err)
+	}
+	publishedAt := uploadedAt.Add(time.Hour)
+	if err := client.StorePublicationTime(ctx, [][16]byte{{2}}, publishedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	// Late keys are listed at their publication time.
+	got, err := client.FindAllDiagnosisKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || !got[0].UploadedAt.Equal(uploadedAt) || !got[1].UploadedAt.Equal(publishedAt) {
+		t.Errorf("unexpected keys: %+v", got)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ct-diag.db")
+	for i := 0; i < 2; i++ {
+		// Reopening an existing database doesn't migrate it again.
+		client, err := New(context.Background(), Config{Path: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Close()
+	}
+}
+
+func TestJobRuns(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+	startedAt := time.Unix(42, 0).UTC()
+
+	id, err := client.StoreJobRun(ctx, diag.JobRun{Type: "purge", StartedAt: startedAt, EndedAt: startedAt, Error: "boom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.StoreJobRun(ctx, diag.JobRun{Type: "purge", StartedAt: startedAt, EndedAt: startedAt, RetryOf: id}); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := client.FindJobRuns(ctx, 10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].ID != id || runs[0].Error != "boom" {
+		t.Errorf("expected failed run %v, got: %+v", id, runs)
+	}
+	if _, err := client.FindJobRun(ctx, 404); err != diag.ErrJobRunNotFound {
+		t.Errorf("expected: %v, got: %v", diag.ErrJobRunNotFound, err)
+	}
+}
+
+func TestExposureConfigs(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	exp := diag.ExposureConfigHistory{
+		{ID: "v1", EffectiveFrom: time.Unix(42, 0).UTC(), Config: diag.ExposureConfig{MinimumRiskScore: 1, AttenuationLevelValues: []int{1, 2}}},
+		{ID: "v2", EffectiveFrom: time.Unix(43, 0).UTC(), Config: diag.ExposureConfig{MinimumRiskScore: 2, AttenuationLevelValues: []int{3, 4}}},
+		{ID: "NL/1.2/v3", EffectiveFrom: time.Unix(44, 0).UTC(), ExposureConfigScope: diag.ExposureConfigScope{Region: "NL", AppVersion: "1.2"}, Config: diag.ExposureConfig{MinimumRiskScore: 3}},
+	}
+	for _, i := range []int{1, 2, 0} {
+		if err := client.StoreExposureConfig(ctx, exp[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.StoreExposureConfig(ctx, exp[0]); err == nil {
+		t.Error("expected error for duplicate version")
+	}
+
+	got, err := client.FindExposureConfigs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected: %+v, got: %+v", exp, got)
+	}
+}
@@ -0,0 +1,146 @@
+package diag
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrPublicationTimesUnsupported is returned by a PublicationTimeStorer that
+// can't store publication times, e.g. because the database lacks the column.
+var ErrPublicationTimesUnsupported = errors.New("diag: repository doesn't support storing publication times")
+
+// PublicationTimeStorer is implemented by repositories that can store the
+// publication time of late keys (see lateKeys), so they stay in the batch they
+// were published in after a restart. FindAllDiagnosisKeys returns the stored
+// publication time of a key as its upload time.
+type PublicationTimeStorer interface {
+	StorePublicationTime(ctx context.Context, keys [][16]byte, publishedAt time.Time) error
+}
+
+// DropExpiredKeys returns the Diagnosis Keys of diagKeys whose rolling
+// interval started at most maxAge before now, and the amount of dropped
+// keys. A zero maxAge keeps all keys. Unlike the plausibility checks of
+// uploads (see Config.MaxKeyAge), expired keys don't invalidate the others,
+// so it's suited for federation, where peers may backfill keys the local
+// server already purged.
+func DropExpiredKeys(diagKeys []DiagnosisKey, maxAge time.Duration, now time.Time) ([]DiagnosisKey, int) {
+	if maxAge == 0 {
+		return diagKeys, 0
+	}
+
+	oldest := now.Add(-maxAge)
+	kept := make([]DiagnosisKey, 0, len(diagKeys))
+	for _, diagKey := range diagKeys {
+		if rollingStartTime(diagKey).Before(oldest) {
+			continue
+		}
+		kept = append(kept, diagKey)
+	}
+
+	return kept, len(diagKeys) - len(kept)
+}
+
+// lateKeys tracks Diagnosis Keys that were stored with an upload time in a
+// time range that was already published, e.g. because of a long running
+// import. They're published at the time of the cache refresh that found them
+// instead, so clients that already downloaded the time range don't miss them.
+// Publication times are stored in the repository if storer is set, and kept
+// in memory (for as long as the keys are stored) otherwise.
+type lateKeys struct {
+	storer PublicationTimeStorer
+
+	mu sync.Mutex
+	// last is the last Diagnosis Key of the previous cache refresh, in
+	// repository order, so keys stored since can be told apart.
+	last        [16]byte
+	hasLast     bool
+	publishedAt map[[16]byte]time.Time
+}
+
+// apply returns diagKeys (ordered by upload) with the upload time of late
+// keys replaced with their publication time. Keys stored since the previous
+// cache refresh, at hydratedAt, are late if their upload time precedes the
+// time ranges that were left unpublished (see Service.IsPublished). If the
+// publication times can't be stored, they're kept in memory, and the error is
+// returned along with the keys.
+func (lk *lateKeys) apply(ctx context.Context, diagKeys []DiagnosisKey, hydratedAt time.Time) ([]DiagnosisKey, error) {
+	lk.mu.Lock()
+	defer lk.mu.Unlock()
+
+	if lk.publishedAt == nil {
+		lk.publishedAt = make(map[[16]byte]time.Time)
+	}
+
+	// If the last key of the previous refresh was deleted since, keys stored
+	// since can't be told apart, and none are considered late.
+	var late [][16]byte
+	if lk.hasLast && !hydratedAt.IsZero() {
+		published := hydratedAt.Add(-publicationMargin)
+		for i := len(diagKeys) - 1; i >= 0; i-- {
+			if diagKeys[i].TemporaryExposureKey != lk.last {
+				continue
+			}
+			for _, diagKey := range diagKeys[i+1:] {
+				if diagKey.UploadedAt.Before(published) {
+					late = append(late, diagKey.TemporaryExposureKey)
+					lateKeysCounter.Inc()
+				}
+			}
+			break
+		}
+	}
+
+	var err error
+	stored := make(map[[16]byte]bool, len(late))
+	if len(late) > 0 && lk.storer != nil {
+		if err = lk.storer.StorePublicationTime(ctx, late, hydratedAt); err == nil {
+			for _, key := range late {
+				stored[key] = true
+			}
+		} else if errors.Is(err, ErrPublicationTimesUnsupported) {
+			err = nil
+		}
+	}
+	for _, key := range late {
+		if !stored[key] {
+			lk.publishedAt[key] = hydratedAt
+		}
+	}
+
+	lk.hasLast = len(diagKeys) > 0
+	if lk.hasLast {
+		lk.last = diagKeys[len(diagKeys)-1].TemporaryExposureKey
+	}
+	if len(lk.publishedAt) == 0 && len(stored) == 0 {
+		return diagKeys, err
+	}
+
+	// The keys returned by the repository are left untouched.
+	applied := make([]DiagnosisKey, len(diagKeys))
+	copy(applied, diagKeys)
+	seen := make(map[[16]byte]bool, len(lk.publishedAt))
+	for i, diagKey := range applied {
+		if stored[diagKey.TemporaryExposureKey] {
+			applied[i].UploadedAt = hydratedAt
+			continue
+		}
+		publishedAt, ok := lk.publishedAt[diagKey.TemporaryExposureKey]
+		if !ok {
+			continue
+		}
+		seen[diagKey.TemporaryExposureKey] = true
+		if diagKey.UploadedAt.Before(publishedAt) {
+			applied[i].UploadedAt = publishedAt
+		}
+	}
+	// Keys that were deleted (e.g. purged) are forgotten.
+	for key := range lk.publishedAt {
+		if !seen[key] {
+			delete(lk.publishedAt, key)
+		}
+	}
+
+	return applied, err
+}
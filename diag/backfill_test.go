@@ -0,0 +1,138 @@
+package diag
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDropExpiredKeys(t *testing.T) {
+	now := time.Date(2020, time.May, 20, 12, 0, 0, 0, time.UTC)
+	rsn := func(t time.Time) uint32 { return uint32(t.Unix() / 600) }
+	diagKeys := []DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: rsn(now.AddDate(0, 0, -20))},
+		{TemporaryExposureKey: [16]byte{2}, RollingStartNumber: rsn(now.AddDate(0, 0, -13))},
+		{TemporaryExposureKey: [16]byte{3}, RollingStartNumber: rsn(now)},
+	}
+
+	kept, dropped := DropExpiredKeys(diagKeys, 14*24*time.Hour, now)
+	if dropped != 1 {
+		t.Errorf("expected: 1, got: %v", dropped)
+	}
+	if len(kept) != 2 || kept[0].TemporaryExposureKey != [16]byte{2} || kept[1].TemporaryExposureKey != [16]byte{3} {
+		t.Errorf("unexpected kept keys: %v", kept)
+	}
+
+	if kept, dropped := DropExpiredKeys(diagKeys, 0, now); dropped != 0 || len(kept) != 3 {
+		t.Errorf("expected all keys to be kept, got: %v (dropped %v)", kept, dropped)
+	}
+}
+
+func TestLateKeys(t *testing.T) {
+	hydratedAt := time.Date(2020, time.May, 20, 12, 0, 0, 0, time.UTC)
+	key := func(b byte, uploadedAt time.Time) DiagnosisKey {
+		return DiagnosisKey{TemporaryExposureKey: [16]byte{b}, UploadedAt: uploadedAt}
+	}
+	early := hydratedAt.Add(-time.Hour)
+
+	ctx := context.Background()
+	var lk lateKeys
+	diagKeys := []DiagnosisKey{key(1, early)}
+	if got, _ := lk.apply(ctx, diagKeys, time.Time{}); got[0].UploadedAt != early {
+		t.Errorf("expected first refresh to be left untouched, got: %v", got[0].UploadedAt)
+	}
+
+	// Key 2 is stored after the refresh, with an upload time in a published
+	// time range; key 3 is a regular upload.
+	recent := hydratedAt.Add(time.Second)
+	diagKeys = []DiagnosisKey{key(1, early), key(2, early.Add(time.Minute)), key(3, recent)}
+	got, err := lk.apply(ctx, diagKeys, hydratedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0].UploadedAt != early {
+		t.Errorf("expected: %v, got: %v", early, got[0].UploadedAt)
+	}
+	if got[1].UploadedAt != hydratedAt {
+		t.Errorf("expected late key to be published at %v, got: %v", hydratedAt, got[1].UploadedAt)
+	}
+	if got[2].UploadedAt != recent {
+		t.Errorf("expected: %v, got: %v", recent, got[2].UploadedAt)
+	}
+	if diagKeys[1].UploadedAt == hydratedAt {
+		t.Error("expected keys of the repository to be left untouched")
+	}
+
+	// The publication time sticks on later refreshes, until the key is deleted.
+	if got, _ := lk.apply(ctx, diagKeys, hydratedAt.Add(time.Hour)); got[1].UploadedAt != hydratedAt {
+		t.Errorf("expected: %v, got: %v", hydratedAt, got[1].UploadedAt)
+	}
+	lk.apply(ctx, []DiagnosisKey{key(3, recent)}, hydratedAt.Add(2*time.Hour))
+	if len(lk.publishedAt) != 0 {
+		t.Errorf("expected deleted late key to be forgotten, got: %v", lk.publishedAt)
+	}
+}
+
+// publicationTimeRepo stores the publication times of late keys, or fails
+// with err.
+type publicationTimeRepo struct {
+	publishedAt map[[16]byte]time.Time
+	err         error
+}
+
+func (repo publicationTimeRepo) StorePublicationTime(ctx context.Context, keys [][16]byte, publishedAt time.Time) error {
+	if repo.err != nil {
+		return repo.err
+	}
+	for _, key := range keys {
+		repo.publishedAt[key] = publishedAt
+	}
+	return nil
+}
+
+func TestLateKeysStored(t *testing.T) {
+	ctx := context.Background()
+	hydratedAt := time.Date(2020, time.May, 20, 12, 0, 0, 0, time.UTC)
+	early := hydratedAt.Add(-time.Hour)
+	key := func(b byte) DiagnosisKey {
+		return DiagnosisKey{TemporaryExposureKey: [16]byte{b}, UploadedAt: early}
+	}
+
+	repo := publicationTimeRepo{publishedAt: make(map[[16]byte]time.Time)}
+	lk := lateKeys{storer: repo}
+	lk.apply(ctx, []DiagnosisKey{key(1)}, time.Time{})
+
+	got, err := lk.apply(ctx, []DiagnosisKey{key(1), key(2)}, hydratedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[1].UploadedAt != hydratedAt {
+		t.Errorf("expected late key to be published at %v, got: %v", hydratedAt, got[1].UploadedAt)
+	}
+	if exp := map[[16]byte]time.Time{{2}: hydratedAt}; !reflect.DeepEqual(repo.publishedAt, exp) {
+		t.Errorf("expected: %v, got: %v", exp, repo.publishedAt)
+	}
+	if len(lk.publishedAt) != 0 {
+		t.Errorf("expected stored publication times not to be kept in memory, got: %v", lk.publishedAt)
+	}
+
+	// If they can't be stored, they're kept in memory.
+	lk.storer = publicationTimeRepo{err: errors.New("boom")}
+	if _, err := lk.apply(ctx, []DiagnosisKey{key(1), key(2), key(3)}, hydratedAt.Add(time.Hour)); err == nil {
+		t.Error("expected error")
+	}
+	if got := lk.publishedAt[[16]byte{3}]; got != hydratedAt.Add(time.Hour) {
+		t.Errorf("expected: %v, got: %v", hydratedAt.Add(time.Hour), got)
+	}
+
+	// Unless the repository doesn't support it at all.
+	lk.storer = publicationTimeRepo{err: ErrPublicationTimesUnsupported}
+	if _, err := lk.apply(ctx, []DiagnosisKey{key(1), key(2), key(3), key(4)}, hydratedAt.Add(2*time.Hour)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, ok := lk.publishedAt[[16]byte{4}]; !ok {
+		t.Error("expected publication time to be kept in memory")
+	}
+}
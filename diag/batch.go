@@ -0,0 +1,56 @@
+package diag
+
+import (
+	"io"
+	"time"
+)
+
+// batchDays is the amount of days batches are listed for, as older keys are
+// no longer relevant for exposure detection.
+const batchDays = 14
+
+// Batch represents the Diagnosis Keys published in a complete time range (a
+// UTC day or hour), which never change once published.
+type Batch struct {
+	Start time.Time
+	End   time.Time
+	Keys  int64
+}
+
+// Batches returns the published daily batches of the last 14 days and, if
+// hourly is true, the published hourly batches of today, ordered by time.
+// Batches without Diagnosis Keys are omitted, unless the service publishes
+// empty batches (see Config.PublishEmptyBatches).
+func (s Service) Batches(hourly bool) ([]Batch, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	var batches []Batch
+	add := func(start, end time.Time) error {
+		if !s.IsPublished(end) {
+			return nil
+		}
+		n, err := s.cache.ReadSeekerBetween(start, end).Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		if n > 0 || s.publishEmptyBatches {
+			batches = append(batches, Batch{Start: start, End: end, Keys: n / DiagnosisKeySize})
+		}
+		return nil
+	}
+
+	for start := today.AddDate(0, 0, -batchDays); start.Before(today); start = start.AddDate(0, 0, 1) {
+		if err := add(start, start.AddDate(0, 0, 1)); err != nil {
+			return nil, err
+		}
+	}
+	if hourly {
+		for start := today; start.Before(today.AddDate(0, 0, 1)); start = start.Add(time.Hour) {
+			if err := add(start, start.Add(time.Hour)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return batches, nil
+}
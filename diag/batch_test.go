@@ -0,0 +1,63 @@
+package diag
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBatches(t *testing.T) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	yesterday := today.AddDate(0, 0, -1)
+	diagKeys := []DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, UploadedAt: today.AddDate(0, 0, -20)},
+		{TemporaryExposureKey: [16]byte{2}, UploadedAt: yesterday.Add(3 * time.Hour)},
+		{TemporaryExposureKey: [16]byte{3}, UploadedAt: yesterday.Add(5 * time.Hour)},
+		{TemporaryExposureKey: [16]byte{4}, UploadedAt: today.AddDate(0, 0, 1)},
+	}
+	svc, err := NewService(context.Background(), Config{
+		Repository: snapshotRepo{diagKeys: diagKeys},
+		Logger:     NewNopLogger(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys older than 14 days and keys of today aren't in a daily batch.
+	batches, err := svc.Batches(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := Batch{Start: yesterday, End: today, Keys: 2}
+	if len(batches) != 1 || batches[0] != exp {
+		t.Errorf("expected: %+v, got: %+v", []Batch{exp}, batches)
+	}
+
+	// Hourly batches of today are only listed once published, which they
+	// aren't until the next cache refresh.
+	batches, err = svc.Batches(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, batch := range batches[1:] {
+		if !svc.IsPublished(batch.End) || batch.End.Sub(batch.Start) != time.Hour {
+			t.Errorf("unexpected batch: %+v", batch)
+		}
+	}
+
+	// With empty batches, every published day is listed.
+	svc.publishEmptyBatches = true
+	batches, err = svc.Batches(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != batchDays {
+		t.Fatalf("expected: %v batches, got: %v", batchDays, len(batches))
+	}
+	if first := batches[0]; !first.Start.Equal(today.AddDate(0, 0, -batchDays)) || first.Keys != 0 {
+		t.Errorf("unexpected first batch: %+v", first)
+	}
+	if last := batches[len(batches)-1]; last != exp {
+		t.Errorf("expected: %+v, got: %+v", exp, last)
+	}
+}
@@ -0,0 +1,133 @@
+package diag
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+)
+
+// bloomFalsePositiveRate is the target false positive rate of the filter of
+// known keys.
+const bloomFalsePositiveRate = 0.01
+
+// bloomFilter is a Bloom filter of Temporary Exposure Keys. Because keys are
+// random, their bytes are used as hashes directly (with double hashing), so no
+// hash function is needed. It's safe for concurrent use.
+type bloomFilter struct {
+	mu   sync.RWMutex
+	bits []uint64
+	k    uint64
+}
+
+// newBloomFilter returns a Bloom filter sized for n keys.
+func newBloomFilter(n int) *bloomFilter {
+	if n < 1024 {
+		n = 1024
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(bloomFalsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+
+	return &bloomFilter{bits: make([]uint64, (m+63)/64), k: k}
+}
+
+func (bf *bloomFilter) locations(key [16]byte, fn func(word uint64, mask uint64) bool) {
+	m := uint64(len(bf.bits)) * 64
+	h1 := binary.LittleEndian.Uint64(key[:8])
+	h2 := binary.LittleEndian.Uint64(key[8:]) | 1
+	for i := uint64(0); i < bf.k; i++ {
+		loc := (h1 + i*h2) % m
+		if !fn(loc/64, 1<<(loc%64)) {
+			return
+		}
+	}
+}
+
+// add adds a key to the filter.
+func (bf *bloomFilter) add(key [16]byte) {
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+
+	bf.locations(key, func(word, mask uint64) bool {
+		bf.bits[word] |= mask
+		return true
+	})
+}
+
+// mayContain returns false if the key is definitely not in the filter.
+func (bf *bloomFilter) mayContain(key [16]byte) bool {
+	bf.mu.RLock()
+	defer bf.mu.RUnlock()
+
+	ok := true
+	bf.locations(key, func(word, mask uint64) bool {
+		ok = bf.bits[word]&mask != 0
+		return ok
+	})
+	return ok
+}
+
+// knownKeys holds the Bloom filter of stored keys, replaced on every cache
+// refresh. It's safe for concurrent use.
+type knownKeys struct {
+	mu     sync.RWMutex
+	filter *bloomFilter
+}
+
+func (kk *knownKeys) get() *bloomFilter {
+	kk.mu.RLock()
+	defer kk.mu.RUnlock()
+	return kk.filter
+}
+
+// set replaces the filter with a filter of the given keys. The filter is
+// sized with headroom for keys uploaded until the next refresh.
+func (kk *knownKeys) set(diagKeys []DiagnosisKey) {
+	filter := newBloomFilter(len(diagKeys) + len(diagKeys)/4)
+	for i := range diagKeys {
+		filter.add(diagKeys[i].TemporaryExposureKey)
+	}
+
+	kk.mu.Lock()
+	defer kk.mu.Unlock()
+	kk.filter = filter
+}
+
+// withoutKnownKeys returns the Diagnosis Keys that aren't stored yet. Keys
+// that are possibly known according to the Bloom filter are verified against
+// the cache (in a single pass), so new keys are never dropped. Keys that are
+// stored but not cached yet are returned; the repository ignores duplicates.
+// Known keys tagged with regions are returned too, as the repository merges
+// their regions (see MergeRegions).
+func (s Service) withoutKnownKeys(diagKeys []DiagnosisKey) []DiagnosisKey {
+	filter := s.knownKeys.get()
+	mc, ok := s.cache.(*MemoryCache)
+	if filter == nil || !ok {
+		return diagKeys
+	}
+
+	maybeKnown := make(map[[16]byte]bool)
+	for _, diagKey := range diagKeys {
+		if filter.mayContain(diagKey.TemporaryExposureKey) {
+			maybeKnown[diagKey.TemporaryExposureKey] = false
+		}
+	}
+	if len(maybeKnown) == 0 {
+		return diagKeys
+	}
+	mc.lookup(maybeKnown)
+
+	newKeys := make([]DiagnosisKey, 0, len(diagKeys))
+	for _, diagKey := range diagKeys {
+		known, ok := maybeKnown[diagKey.TemporaryExposureKey]
+		switch {
+		case known && len(diagKey.Regions) == 0:
+			duplicateKeys.Inc()
+			continue
+		case ok && !known:
+			bloomFalsePositives.Inc()
+		}
+		newKeys = append(newKeys, diagKey)
+	}
+
+	return newKeys
+}
@@ -0,0 +1,131 @@
+package diag
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+func TestBloomFilter(t *testing.T) {
+	const n = 10000
+	filter := newBloomFilter(n)
+
+	keys := make([][16]byte, 2*n)
+	for i := range keys {
+		if _, err := rand.Read(keys[i][:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range keys[:n] {
+		filter.add(key)
+	}
+
+	for _, key := range keys[:n] {
+		if !filter.mayContain(key) {
+			t.Fatalf("expected filter to contain %x", key)
+		}
+	}
+
+	var falsePositives int
+	for _, key := range keys[n:] {
+		if filter.mayContain(key) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / n; rate > 2*bloomFalsePositiveRate {
+		t.Errorf("expected false positive rate of about %v, got: %v", bloomFalsePositiveRate, rate)
+	}
+}
+
+type storingRepo struct {
+	snapshotRepo
+	stored *[]DiagnosisKey
+}
+
+func (r storingRepo) StoreDiagnosisKeys(_ context.Context, diagKeys []DiagnosisKey, _ time.Time) error {
+	*r.stored = append(*r.stored, diagKeys...)
+	return nil
+}
+
+func TestStoreDiagnosisKeysDuplicateFilter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	known := DiagnosisKey{TemporaryExposureKey: [16]byte{1}, UploadedAt: time.Now()}
+	unknown := DiagnosisKey{TemporaryExposureKey: [16]byte{2}}
+	repo := storingRepo{
+		snapshotRepo: snapshotRepo{diagKeys: []DiagnosisKey{known}},
+		stored:       &[]DiagnosisKey{},
+	}
+
+	svc, err := NewService(ctx, Config{Repository: repo, Logger: NewNopLogger(), DuplicateFilter: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.StoreDiagnosisKeys(ctx, []DiagnosisKey{known}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*repo.stored) != 0 {
+		t.Fatalf("expected known key to be skipped, got: %v", *repo.stored)
+	}
+
+	if _, err := svc.StoreDiagnosisKeys(ctx, []DiagnosisKey{known, unknown}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*repo.stored) != 1 || (*repo.stored)[0].TemporaryExposureKey != unknown.TemporaryExposureKey {
+		t.Errorf("expected only unknown key to be stored, got: %v", *repo.stored)
+	}
+
+	// Known keys tagged with regions reach the repository, to merge them.
+	// They're neither duplicates nor false positives.
+	*repo.stored = nil
+	known.Regions = []string{"BE"}
+	duplicates, falsePositives := duplicateKeys.Value(), bloomFalsePositives.Value()
+	if _, err := svc.StoreDiagnosisKeys(ctx, []DiagnosisKey{known}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*repo.stored) != 1 || (*repo.stored)[0].TemporaryExposureKey != known.TemporaryExposureKey {
+		t.Errorf("expected tagged known key to be stored, got: %v", *repo.stored)
+	}
+	if got := duplicateKeys.Value() - duplicates; got != 0 {
+		t.Errorf("expected no duplicates, got: %v", got)
+	}
+	if got := bloomFalsePositives.Value() - falsePositives; got != 0 {
+		t.Errorf("expected no false positives, got: %v", got)
+	}
+}
+
+func TestStoreDiagnosisKeysDuplicateFilterMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	known := DiagnosisKey{TemporaryExposureKey: [16]byte{1}, UploadedAt: time.Now()}
+	unknown := DiagnosisKey{TemporaryExposureKey: [16]byte{2}}
+	repo := storingRepo{
+		snapshotRepo: snapshotRepo{diagKeys: []DiagnosisKey{known}},
+		stored:       &[]DiagnosisKey{},
+	}
+
+	svc, err := NewService(ctx, Config{Repository: repo, Logger: NewNopLogger(), DuplicateFilter: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The filter reports the unknown key as known, like a false positive.
+	svc.knownKeys.get().add(unknown.TemporaryExposureKey)
+
+	duplicates, falsePositives := duplicateKeys.Value(), bloomFalsePositives.Value()
+	if _, err := svc.StoreDiagnosisKeys(ctx, []DiagnosisKey{known, unknown}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*repo.stored) != 1 || (*repo.stored)[0].TemporaryExposureKey != unknown.TemporaryExposureKey {
+		t.Errorf("expected only unknown key to be stored, got: %v", *repo.stored)
+	}
+	if got := duplicateKeys.Value() - duplicates; got != 1 {
+		t.Errorf("expected 1 duplicate, got: %v", got)
+	}
+	if got := bloomFalsePositives.Value() - falsePositives; got != 1 {
+		t.Errorf("expected 1 false positive, got: %v", got)
+	}
+}
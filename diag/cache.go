@@ -3,38 +3,92 @@ package diag
 import (
 	"bytes"
 	"io"
+	"sort"
+	"sync"
 	"time"
 )
 
 // Cache defines an interface for caching binary Diagnosis Key data, to be used
 // in between clients and the repository for listing keys.
 type Cache interface {
-	// Set replaces the cache.
-	Set(buf []byte, lastModified time.Time) error
+	// Set replaces the cache. Diagnosis Keys are ordered by upload.
+	Set(diagKeys []DiagnosisKey, lastModified time.Time) error
 	// LastModified returns the timestamp of the latest uploaded Diagnosis Key.
 	LastModified() time.Time
 	// ReadSeeker returns a io.ReadSeeker for accessing the cache. When a non zero
 	// value is given for `after`, implementors should use Diagnosis Keys
 	// uploaded after the given key, else all Diagnosis Keys should be used..
 	ReadSeeker(after [16]byte) io.ReadSeeker
+	// ReadSeekerBetween returns a io.ReadSeeker for accessing Diagnosis Keys
+	// published in the time range [start, end).
+	ReadSeekerBetween(start, end time.Time) io.ReadSeeker
 }
 
-// MemoryCache represents an in-memory cache.
+// ExportCache is implemented by caches that also hold pre-serialized export
+// files (see Service.Export), so they're generated once per hydration instead
+// of on request.
+type ExportCache interface {
+	// SetExports replaces the export files, keyed by the start and end time
+	// (Unix seconds) of their time range.
+	SetExports(files map[[2]int64][]byte)
+	// Export returns the export file of the time range [start, end), if it's
+	// cached.
+	Export(start, end time.Time) ([]byte, bool)
+}
+
+// MemoryCache represents an in-memory cache. It's safe for concurrent use.
 type MemoryCache struct {
-	buf          []byte
-	lastModified time.Time
+	mu             sync.RWMutex
+	buf            []byte
+	publishedAt    []int64
+	lastModified   time.Time
+	exports        map[[2]int64][]byte
+	regions        map[string][]byte
+	reports        reportMap
+	rollingPeriods rollingPeriodMap
 }
 
-// Set overwrites the cache.
-func (mc *MemoryCache) Set(buf []byte, lastModified time.Time) error {
+// Set overwrites the cache. Diagnosis Keys are stored in their binary
+// representation, along with their publication time. The previous buffer is
+// released, and can be garbage collected once readers returned by ReadSeeker
+// are done with it.
+func (mc *MemoryCache) Set(diagKeys []DiagnosisKey, lastModified time.Time) error {
+	buf := make([]byte, len(diagKeys)*DiagnosisKeySize)
+	publishedAt := make([]int64, len(diagKeys))
+
+	for i, diagKey := range diagKeys {
+		encodeDiagnosisKey(buf[i*DiagnosisKeySize:], diagKey)
+		publishedAt[i] = diagKey.UploadedAt.UnixNano()
+		// A key is published no earlier than the keys uploaded before it, so
+		// publication times are monotonic even if upload times are not (e.g.
+		// because of concurrent uploads).
+		if i > 0 && publishedAt[i] < publishedAt[i-1] {
+			publishedAt[i] = publishedAt[i-1]
+		}
+	}
+
+	regions := encodeRegions(diagKeys)
+	reports := newReportMap(diagKeys)
+	rollingPeriods := newRollingPeriodMap(diagKeys)
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
 	mc.buf = buf
+	mc.publishedAt = publishedAt
 	mc.lastModified = lastModified
+	mc.regions = regions
+	mc.reports = reports
+	mc.rollingPeriods = rollingPeriods
 
 	return nil
 }
 
 // LastModified returns the timestamp of the latest uploaded Diagnosis Key in the cache.
 func (mc *MemoryCache) LastModified() time.Time {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
 	return mc.lastModified
 }
 
@@ -42,18 +96,71 @@ func (mc *MemoryCache) LastModified() time.Time {
 // zero `after` is passed, only Diagnosis Keys uploaded after the given key
 // will be returned. Else, all contents are used.
 func (mc *MemoryCache) ReadSeeker(after [16]byte) io.ReadSeeker {
+	mc.mu.RLock()
+	buf := mc.buf
+	mc.mu.RUnlock()
+
 	if after == [16]byte{} {
-		return bytes.NewReader(mc.buf)
+		return bytes.NewReader(buf)
 	}
 
 	// Look for the key in the buffer.
-	for i := 0; i < len(mc.buf); i = i + DiagnosisKeySize {
-		if bytes.Equal(mc.buf[i:i+16], after[:]) {
+	for i := 0; i < len(buf); i = i + DiagnosisKeySize {
+		if bytes.Equal(buf[i:i+16], after[:]) {
 			// The key was found. The offset becomes the index *after* this key.
-			return bytes.NewReader(mc.buf[i+DiagnosisKeySize:])
+			return bytes.NewReader(buf[i+DiagnosisKeySize:])
 		}
 	}
 
 	// Key was not found. Use an empty reader.
 	return bytes.NewReader([]byte{})
 }
+
+// ReadSeekerBetween returns a io.ReadSeeker for accessing Diagnosis Keys
+// published in the time range [start, end).
+func (mc *MemoryCache) ReadSeekerBetween(start, end time.Time) io.ReadSeeker {
+	mc.mu.RLock()
+	buf := mc.buf
+	publishedAt := mc.publishedAt
+	mc.mu.RUnlock()
+
+	i := sort.Search(len(publishedAt), func(i int) bool { return publishedAt[i] >= start.UnixNano() })
+	j := sort.Search(len(publishedAt), func(j int) bool { return publishedAt[j] >= end.UnixNano() })
+	if j < i {
+		j = i
+	}
+
+	return bytes.NewReader(buf[i*DiagnosisKeySize : j*DiagnosisKeySize])
+}
+
+// SetExports implements ExportCache.
+func (mc *MemoryCache) SetExports(files map[[2]int64][]byte) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.exports = files
+}
+
+// Export implements ExportCache.
+func (mc *MemoryCache) Export(start, end time.Time) ([]byte, bool) {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	file, ok := mc.exports[[2]int64{start.Unix(), end.Unix()}]
+	return file, ok
+}
+
+// lookup sets the value of each key in keys to true if the key is cached.
+func (mc *MemoryCache) lookup(keys map[[16]byte]bool) {
+	mc.mu.RLock()
+	buf := mc.buf
+	mc.mu.RUnlock()
+
+	var key [16]byte
+	for i := 0; i < len(buf); i += DiagnosisKeySize {
+		copy(key[:], buf[i:i+16])
+		if _, ok := keys[key]; ok {
+			keys[key] = true
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package diag
+
+import (
+	"context"
+	"flag"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+var soak = flag.Duration("soak", 0, "Duration of the cache refresh soak test, skipped when zero")
+
+// soakRepo is a Repository returning a growing set of Diagnosis Keys on every
+// call to FindAllDiagnosisKeys, up to maxKeys.
+type soakRepo struct {
+	mu      sync.Mutex
+	keys    int
+	step    int
+	maxKeys int
+}
+
+func (r *soakRepo) StoreDiagnosisKeys(_ context.Context, _ []DiagnosisKey, _ time.Time) error {
+	return nil
+}
+
+func (r *soakRepo) FindAllDiagnosisKeys(_ context.Context) ([]DiagnosisKey, error) {
+	r.mu.Lock()
+	if r.keys < r.maxKeys {
+		r.keys += r.step
+	}
+	n := r.keys
+	r.mu.Unlock()
+
+	diagKeys := make([]DiagnosisKey, n)
+	for i := range diagKeys {
+		diagKeys[i].TemporaryExposureKey = [16]byte{byte(i), byte(i >> 8), byte(i >> 16)}
+	}
+	return diagKeys, nil
+}
+
+func (r *soakRepo) LastModified(_ context.Context) (time.Time, error) {
+	return time.Now(), nil
+}
+
+func heapAlloc() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+// TestCacheRefreshSoak repeatedly refreshes the cache with a growing key set,
+// while concurrently reading from it, and asserts that heap usage stays
+// bounded, i.e. that old cache snapshots aren't retained.
+// Run with: go test ./diag -run Soak -soak 1m
+func TestCacheRefreshSoak(t *testing.T) {
+	if *soak == 0 {
+		t.Skip("skipping soak test, enable with the `-soak` flag")
+	}
+
+	const maxKeys = 500000
+	repo := &soakRepo{step: 10000, maxKeys: maxKeys}
+	svc := Service{repo: repo, cache: &MemoryCache{}, logger: NewNopLogger(), hydratedAt: &syncTime{}, digest: &contentDigest{}, signatures: &signatureCache{}, flights: &flightGroup{}, storedKeys: new(int64)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *soak)
+	defer cancel()
+
+	// Concurrent readers, mimicking clients downloading the full keyset.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				io.Copy(ioutil.Discard, svc.ReadSeeker([16]byte{}))
+			}
+		}()
+	}
+
+	baseline := heapAlloc()
+	var peak uint64
+	for refreshes := 0; ctx.Err() == nil; refreshes++ {
+		if err := svc.hydrateCache(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if refreshes%50 == 0 {
+			if h := heapAlloc(); h > peak {
+				peak = h
+			}
+		}
+	}
+	wg.Wait()
+
+	// Allow for the current snapshot, a snapshot being built (including the
+	// Diagnosis Keys returned by the repository), and snapshots still
+	// referenced by readers, plus some slack for runtime overhead.
+	snapshotSize := uint64(maxKeys * (DiagnosisKeySize + 8))
+	limit := baseline + 8*snapshotSize
+	t.Logf("baseline: %v bytes, peak: %v bytes, snapshot size: %v bytes", baseline, peak, snapshotSize)
+	if peak > limit {
+		t.Errorf("expected heap to stay below %v bytes, got peak: %v bytes", limit, peak)
+	}
+}
@@ -0,0 +1,166 @@
+package diag
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// cursorSize is the size of the binary representation of a Cursor.
+const cursorSize = 8 + 8 + 16
+
+var (
+	// ErrInvalidCursor is used when a cursor can't be parsed.
+	ErrInvalidCursor = errors.New("diag: invalid cursor")
+	// ErrPaginationUnsupported is used when the cache doesn't implement
+	// Paginator.
+	ErrPaginationUnsupported = errors.New("diag: cache doesn't support pagination")
+)
+
+// Cursor is a position in the listing of all Diagnosis Keys, i.e. right after
+// the key that was last returned to a client. The zero value is the start of
+// the listing. Clients should treat its string representation as opaque.
+type Cursor struct {
+	// Index is the amount of keys before the position.
+	Index int64
+	// PublishedAt is the publication time (Unix nanoseconds) and Key the
+	// TemporaryExposureKey of the key before the position. They're used to
+	// find the position if Index is stale, e.g. after expired keys were
+	// purged.
+	PublishedAt int64
+	Key         [16]byte
+}
+
+// String returns the opaque (base64url) representation of c.
+func (c Cursor) String() string {
+	var buf [cursorSize]byte
+	binary.BigEndian.PutUint64(buf[0:8], uint64(c.Index))
+	binary.BigEndian.PutUint64(buf[8:16], uint64(c.PublishedAt))
+	copy(buf[16:], c.Key[:])
+	return base64.RawURLEncoding.EncodeToString(buf[:])
+}
+
+// ParseCursor parses a cursor returned by Cursor.String. An empty string is
+// the start of the listing.
+func ParseCursor(s string) (Cursor, error) {
+	if s == "" {
+		return Cursor{}, nil
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(buf) != cursorSize {
+		return Cursor{}, ErrInvalidCursor
+	}
+	c := Cursor{
+		Index:       int64(binary.BigEndian.Uint64(buf[0:8])),
+		PublishedAt: int64(binary.BigEndian.Uint64(buf[8:16])),
+	}
+	copy(c.Key[:], buf[16:])
+	if c.Index < 0 {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// Paginator is implemented by caches that support cursor-based pagination.
+type Paginator interface {
+	// ReadSeekerPage returns an io.ReadSeeker for accessing at most limit
+	// Diagnosis Keys after cursor (all of them if limit is zero), the cursor
+	// after the last returned key, and whether more keys follow.
+	ReadSeekerPage(cursor Cursor, limit int) (rs io.ReadSeeker, next Cursor, more bool)
+}
+
+// ReadSeekerPage returns an io.ReadSeeker for accessing at most limit
+// Diagnosis Keys after cursor (all of them if limit is zero), the cursor after
+// the last returned key, and whether more keys follow. If the cache doesn't
+// implement Paginator, ErrPaginationUnsupported is returned.
+func (s Service) ReadSeekerPage(cursor Cursor, limit int) (io.ReadSeeker, Cursor, bool, error) {
+	p, ok := s.cache.(Paginator)
+	if !ok {
+		return nil, Cursor{}, false, ErrPaginationUnsupported
+	}
+	rs, next, more := p.ReadSeekerPage(cursor, limit)
+	return rs, next, more, nil
+}
+
+// ReadSeekerPageSigned is like ReadSeekerPage, and returns the signature of
+// the page as well (see SignReader), or nil if no signer is configured.
+// Signatures are cached until the cache is refreshed, so pages aren't signed
+// on every request.
+func (s Service) ReadSeekerPageSigned(cursor Cursor, limit int) (io.ReadSeeker, Cursor, bool, []byte, error) {
+	// The generation is taken before the page is read, so a signature is
+	// only cached (or used) if the page was read from the current cache.
+	generation := s.signatures.currentGeneration()
+	rs, next, more, err := s.ReadSeekerPage(cursor, limit)
+	if err != nil || s.signer == nil {
+		return rs, next, more, nil, err
+	}
+
+	variant := cursor.String() + "/" + strconv.Itoa(limit)
+	if signature, ok := s.signatures.get(generation, variant); ok {
+		return rs, next, more, signature, nil
+	}
+	signature, err := s.SignReader(rs)
+	if err != nil {
+		return nil, Cursor{}, false, nil, err
+	}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return nil, Cursor{}, false, nil, err
+	}
+	s.signatures.set(generation, variant, signature)
+
+	return rs, next, more, signature, nil
+}
+
+// ReadSeekerPage implements Paginator. The position of cursor is found by
+// index. If it's stale, e.g. because expired keys were purged, the position
+// is looked up by publication time in O(log n) instead; keys may then be
+// returned again, but are never skipped.
+func (mc *MemoryCache) ReadSeekerPage(cursor Cursor, limit int) (io.ReadSeeker, Cursor, bool) {
+	mc.mu.RLock()
+	buf := mc.buf
+	publishedAt := mc.publishedAt
+	mc.mu.RUnlock()
+
+	start := cursorPosition(buf, publishedAt, cursor)
+	end := len(publishedAt)
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+
+	next := cursor
+	if end > start {
+		next = Cursor{Index: int64(end), PublishedAt: publishedAt[end-1]}
+		copy(next.Key[:], buf[(end-1)*DiagnosisKeySize:])
+	}
+
+	return bytes.NewReader(buf[start*DiagnosisKeySize : end*DiagnosisKeySize]), next, end < len(publishedAt)
+}
+
+// cursorPosition returns the index of the first key after cursor.
+func cursorPosition(buf []byte, publishedAt []int64, cursor Cursor) int {
+	if cursor == (Cursor{}) {
+		return 0
+	}
+	if i := cursor.Index; i > 0 && i <= int64(len(publishedAt)) && keyAt(buf, int(i-1)) == cursor.Key {
+		return int(i)
+	}
+
+	// Look for the key among the keys with the same publication time. If it's
+	// gone, continue at the first of these keys, so none are skipped.
+	i := sort.Search(len(publishedAt), func(i int) bool { return publishedAt[i] >= cursor.PublishedAt })
+	for j := i; j < len(publishedAt) && publishedAt[j] == cursor.PublishedAt; j++ {
+		if keyAt(buf, j) == cursor.Key {
+			return j + 1
+		}
+	}
+	return i
+}
+
+func keyAt(buf []byte, i int) (key [16]byte) {
+	copy(key[:], buf[i*DiagnosisKeySize:])
+	return key
+}
@@ -0,0 +1,189 @@
+package diag
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestParseCursor(t *testing.T) {
+	exp := Cursor{Index: 42, PublishedAt: time.Date(2020, time.May, 4, 12, 0, 0, 0, time.UTC).UnixNano(), Key: [16]byte{1, 2, 3}}
+	got, err := ParseCursor(exp.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != exp {
+		t.Errorf("expected: %+v, got: %+v", exp, got)
+	}
+
+	if got, err := ParseCursor(""); err != nil || got != (Cursor{}) {
+		t.Errorf("expected zero cursor, got: %+v (err: %v)", got, err)
+	}
+	for _, s := range []string{"foobar", "!!!", exp.String() + "AA"} {
+		if _, err := ParseCursor(s); err != ErrInvalidCursor {
+			t.Errorf("expected: %v for %q, got: %v", ErrInvalidCursor, s, err)
+		}
+	}
+}
+
+func TestMemoryCacheReadSeekerPage(t *testing.T) {
+	start := time.Date(2020, time.May, 4, 12, 0, 0, 0, time.UTC)
+	diagKeys := make([]DiagnosisKey, 5)
+	for i := range diagKeys {
+		diagKeys[i] = DiagnosisKey{
+			TemporaryExposureKey: [16]byte{byte(i + 1)},
+			RollingStartNumber:   uint32(42 + i),
+			// The third and fourth key share their publication time.
+			UploadedAt: start.Add(time.Duration(i-i/3) * time.Minute),
+		}
+	}
+	mc := &MemoryCache{}
+	mc.Set(diagKeys, start)
+
+	// readPage returns the TEKs of a page.
+	readPage := func(cursor Cursor, limit int) ([]byte, Cursor, bool) {
+		rs, next, more := mc.ReadSeekerPage(cursor, limit)
+		buf, err := ioutil.ReadAll(rs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var teks []byte
+		for i := 0; i < len(buf); i += DiagnosisKeySize {
+			teks = append(teks, buf[i])
+		}
+		return teks, next, more
+	}
+
+	// Walk all pages.
+	var got []byte
+	var cursor Cursor
+	for {
+		teks, next, more := readPage(cursor, 2)
+		got = append(got, teks...)
+		cursor = next
+		if !more {
+			break
+		}
+	}
+	if exp := []byte{1, 2, 3, 4, 5}; !bytes.Equal(got, exp) {
+		t.Errorf("expected: %v, got: %v", exp, got)
+	}
+
+	// At the end, the cursor stays put until new keys are published.
+	if teks, next, more := readPage(cursor, 2); len(teks) != 0 || next != cursor || more {
+		t.Errorf("expected empty last page, got: %v (next: %+v, more: %v)", teks, next, more)
+	}
+	afterThird := Cursor{Index: 3, PublishedAt: diagKeys[2].UploadedAt.UnixNano(), Key: diagKeys[2].TemporaryExposureKey}
+
+	// Purge the first two keys, so cursor indexes are stale.
+	mc.Set(diagKeys[2:], start)
+
+	tests := []struct {
+		name   string
+		cursor Cursor
+		exp    []byte
+	}{
+		{
+			name:   "stale index",
+			cursor: afterThird,
+			exp:    []byte{4, 5},
+		},
+		{
+			name:   "key with same publication time is gone",
+			cursor: Cursor{Index: 2, PublishedAt: diagKeys[2].UploadedAt.UnixNano(), Key: [16]byte{42}},
+			exp:    []byte{3, 4, 5},
+		},
+		{
+			name:   "purged key",
+			cursor: Cursor{Index: 1, PublishedAt: diagKeys[0].UploadedAt.UnixNano(), Key: diagKeys[0].TemporaryExposureKey},
+			exp:    []byte{3, 4, 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, _ := readPage(tt.cursor, 0)
+			if !bytes.Equal(got, tt.exp) {
+				t.Errorf("expected: %v, got: %v", tt.exp, got)
+			}
+		})
+	}
+}
+
+// countingSigner is a crypto.Signer counting its signatures.
+type countingSigner struct {
+	*ecdsa.PrivateKey
+	n *int
+}
+
+func (cs countingSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	*cs.n++
+	return cs.PrivateKey.Sign(rand, digest, opts)
+}
+
+func TestReadSeekerPageSigned(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signatures int
+	diagKeys := []DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, UploadedAt: time.Date(2020, time.May, 4, 12, 0, 0, 0, time.UTC)},
+		{TemporaryExposureKey: [16]byte{2}, UploadedAt: time.Date(2020, time.May, 4, 13, 0, 0, 0, time.UTC)},
+	}
+	svc, err := NewService(ctx, Config{
+		Repository: snapshotRepo{diagKeys: diagKeys},
+		Logger:     NewNopLogger(),
+		Signer:     countingSigner{PrivateKey: key, n: &signatures},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// E.g. the transparency log is signed on hydration too.
+	signatures = 0
+
+	page := func(limit int) {
+		t.Helper()
+		rs, _, _, signature, err := svc.ReadSeekerPageSigned(Cursor{}, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := ioutil.ReadAll(rs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest := sha256.Sum256(buf)
+		if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature) {
+			t.Errorf("invalid signature of page with limit %v", limit)
+		}
+	}
+
+	// Pages are signed once per cache refresh.
+	page(0)
+	page(0)
+	page(1)
+	page(1)
+	if signatures != 2 {
+		t.Errorf("expected 2 signatures, got: %v", signatures)
+	}
+
+	svc.repo = snapshotRepo{diagKeys: append(diagKeys, DiagnosisKey{TemporaryExposureKey: [16]byte{3}, UploadedAt: time.Date(2020, time.May, 4, 14, 0, 0, 0, time.UTC)})}
+	if err := svc.hydrateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	signatures = 0
+	page(0)
+	if signatures != 1 {
+		t.Errorf("expected page to be signed again after refresh, got %v signatures", signatures)
+	}
+}
@@ -0,0 +1,364 @@
+package diag
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DayCache represents an in-memory cache partitioned by publication day
+// (UTC). Day buckets (see ReadSeekerBetween) are served from their partition
+// without searching the others, and Set only encodes the days that changed,
+// so past days are retained as is on every refresh. It's safe for concurrent
+// use.
+//
+// Unlike MemoryCache, it doesn't support snapshots or the duplicate filter.
+type DayCache struct {
+	mu           sync.RWMutex
+	days         []*dayPartition
+	lastModified time.Time
+	exports      map[[2]int64][]byte
+}
+
+const secondsPerDay = 24 * 60 * 60
+
+// dayPartition holds the Diagnosis Keys published on a day, in their binary
+// representation, along with their publication time.
+type dayPartition struct {
+	day         int64
+	buf         []byte
+	publishedAt []int64
+}
+
+// Set replaces the cache. Partitions of days with the same Diagnosis Keys as
+// before are reused.
+func (dc *DayCache) Set(diagKeys []DiagnosisKey, lastModified time.Time) error {
+	dc.mu.RLock()
+	prev := make(map[int64]*dayPartition, len(dc.days))
+	for _, p := range dc.days {
+		prev[p.day] = p
+	}
+	dc.mu.RUnlock()
+
+	var days []*dayPartition
+	for i := 0; i < len(diagKeys); {
+		// Publication times are monotonic, like in MemoryCache, so the keys
+		// of a day are contiguous.
+		after := lastPublishedAt(days)
+		publishedAt := diagKeys[i].UploadedAt.UnixNano()
+		if publishedAt < after {
+			publishedAt = after
+		}
+		day := startOfDay(publishedAt)
+		dayEnd := (day + secondsPerDay) * int64(time.Second)
+
+		j := i + 1
+		for ; j < len(diagKeys); j++ {
+			t := diagKeys[j].UploadedAt.UnixNano()
+			if t < publishedAt {
+				t = publishedAt
+			}
+			if t >= dayEnd {
+				break
+			}
+			publishedAt = t
+		}
+
+		p := prev[day]
+		if p == nil || !p.equal(diagKeys[i:j], after) {
+			p = newDayPartition(day, diagKeys[i:j], after)
+		}
+		days = append(days, p)
+		i = j
+	}
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	dc.days = days
+	dc.lastModified = lastModified
+
+	return nil
+}
+
+// startOfDay returns the start (Unix seconds) of the UTC day of t (Unix
+// nanoseconds).
+func startOfDay(t int64) int64 {
+	day := t / int64(24*time.Hour)
+	if t < 0 && t%int64(24*time.Hour) != 0 {
+		day--
+	}
+	return day * secondsPerDay
+}
+
+// lastPublishedAt returns the publication time of the last key of days, or
+// math.MinInt64 if there are none.
+func lastPublishedAt(days []*dayPartition) int64 {
+	if len(days) == 0 {
+		return math.MinInt64
+	}
+	p := days[len(days)-1]
+	return p.publishedAt[len(p.publishedAt)-1]
+}
+
+// newDayPartition returns the partition of day with diagKeys, published no
+// earlier than after.
+func newDayPartition(day int64, diagKeys []DiagnosisKey, after int64) *dayPartition {
+	p := &dayPartition{
+		day:         day,
+		buf:         make([]byte, len(diagKeys)*DiagnosisKeySize),
+		publishedAt: make([]int64, len(diagKeys)),
+	}
+	for i, diagKey := range diagKeys {
+		encodeDiagnosisKey(p.buf[i*DiagnosisKeySize:], diagKey)
+		p.publishedAt[i] = diagKey.UploadedAt.UnixNano()
+		if p.publishedAt[i] < after {
+			p.publishedAt[i] = after
+		}
+		after = p.publishedAt[i]
+	}
+	return p
+}
+
+// equal returns true if p holds exactly diagKeys, published no earlier than
+// after. Keys are compared in their binary representation, without
+// allocating.
+func (p *dayPartition) equal(diagKeys []DiagnosisKey, after int64) bool {
+	if len(diagKeys) != len(p.publishedAt) {
+		return false
+	}
+	var key [DiagnosisKeySize]byte
+	for i, diagKey := range diagKeys {
+		publishedAt := diagKey.UploadedAt.UnixNano()
+		if publishedAt < after {
+			publishedAt = after
+		}
+		after = publishedAt
+		encodeDiagnosisKey(key[:], diagKey)
+		if publishedAt != p.publishedAt[i] || !bytes.Equal(key[:], p.buf[i*DiagnosisKeySize:(i+1)*DiagnosisKeySize]) {
+			return false
+		}
+	}
+	return true
+}
+
+// LastModified returns the timestamp of the latest uploaded Diagnosis Key in
+// the cache.
+func (dc *DayCache) LastModified() time.Time {
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+
+	return dc.lastModified
+}
+
+// ReadSeeker returns a io.ReadSeeker for accessing Diagnosis Keys. When a non
+// zero `after` is passed, only Diagnosis Keys uploaded after the given key
+// will be returned. Else, all contents are used.
+func (dc *DayCache) ReadSeeker(after [16]byte) io.ReadSeeker {
+	dc.mu.RLock()
+	days := dc.days
+	dc.mu.RUnlock()
+
+	segments := make([][]byte, 0, len(days))
+	found := after == [16]byte{}
+	for _, p := range days {
+		if found {
+			segments = append(segments, p.buf)
+			continue
+		}
+		for i := 0; i < len(p.buf); i += DiagnosisKeySize {
+			if bytes.Equal(p.buf[i:i+16], after[:]) {
+				found = true
+				segments = append(segments, p.buf[i+DiagnosisKeySize:])
+				break
+			}
+		}
+	}
+
+	return newSegmentReader(segments)
+}
+
+// ReadSeekerBetween returns a io.ReadSeeker for accessing Diagnosis Keys
+// published in the time range [start, end). Only the partitions of the days
+// in the time range are searched.
+func (dc *DayCache) ReadSeekerBetween(start, end time.Time) io.ReadSeeker {
+	dc.mu.RLock()
+	days := dc.days
+	dc.mu.RUnlock()
+
+	startNano, endNano := start.UnixNano(), end.UnixNano()
+	first := sort.Search(len(days), func(i int) bool { return days[i].day >= startOfDay(startNano) })
+
+	var segments [][]byte
+	for _, p := range days[first:] {
+		if time.Unix(p.day, 0).UnixNano() >= endNano {
+			break
+		}
+		i := sort.Search(len(p.publishedAt), func(i int) bool { return p.publishedAt[i] >= startNano })
+		j := sort.Search(len(p.publishedAt), func(j int) bool { return p.publishedAt[j] >= endNano })
+		if j > i {
+			segments = append(segments, p.buf[i*DiagnosisKeySize:j*DiagnosisKeySize])
+		}
+	}
+
+	return newSegmentReader(segments)
+}
+
+// ReadSeekerPage implements Paginator, like MemoryCache.ReadSeekerPage. The
+// partition of a stale cursor is found by its publication day.
+func (dc *DayCache) ReadSeekerPage(cursor Cursor, limit int) (io.ReadSeeker, Cursor, bool) {
+	dc.mu.RLock()
+	days := dc.days
+	dc.mu.RUnlock()
+
+	offsets := make([]int, len(days)+1)
+	for i, p := range days {
+		offsets[i+1] = offsets[i] + len(p.publishedAt)
+	}
+	total := offsets[len(days)]
+
+	start := dayCursorPosition(days, offsets, cursor)
+	end := total
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+
+	next := cursor
+	var segments [][]byte
+	for i, p := range days {
+		from, to := start-offsets[i], end-offsets[i]
+		if from < 0 {
+			from = 0
+		}
+		if to > len(p.publishedAt) {
+			to = len(p.publishedAt)
+		}
+		if from >= to {
+			continue
+		}
+		segments = append(segments, p.buf[from*DiagnosisKeySize:to*DiagnosisKeySize])
+		next = Cursor{Index: int64(offsets[i] + to), PublishedAt: p.publishedAt[to-1], Key: keyAt(p.buf, to-1)}
+	}
+
+	return newSegmentReader(segments), next, end < total
+}
+
+// dayCursorPosition returns the index of the first key after cursor, given
+// the index of the first key of each partition in offsets.
+func dayCursorPosition(days []*dayPartition, offsets []int, cursor Cursor) int {
+	if cursor == (Cursor{}) {
+		return 0
+	}
+	if i := cursor.Index; i > 0 && i <= int64(offsets[len(days)]) {
+		d := sort.Search(len(days), func(d int) bool { return int64(offsets[d+1]) >= i })
+		if keyAt(days[d].buf, int(i)-1-offsets[d]) == cursor.Key {
+			return int(i)
+		}
+	}
+
+	// Keys with the same publication time are in the same partition. If it's
+	// gone, continue at the first partition after it, so no keys are skipped.
+	day := startOfDay(cursor.PublishedAt)
+	d := sort.Search(len(days), func(d int) bool { return days[d].day >= day })
+	if d == len(days) || days[d].day != day {
+		return offsets[d]
+	}
+	p := days[d]
+	return offsets[d] + cursorPosition(p.buf, p.publishedAt, Cursor{PublishedAt: cursor.PublishedAt, Key: cursor.Key})
+}
+
+// SetExports implements ExportCache.
+func (dc *DayCache) SetExports(files map[[2]int64][]byte) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	dc.exports = files
+}
+
+// Export implements ExportCache.
+func (dc *DayCache) Export(start, end time.Time) ([]byte, bool) {
+	dc.mu.RLock()
+	defer dc.mu.RUnlock()
+
+	file, ok := dc.exports[[2]int64{start.Unix(), end.Unix()}]
+	return file, ok
+}
+
+// segmentReader is an io.ReadSeeker of the concatenation of byte slices, so
+// partitions can be read as one without copying them.
+type segmentReader struct {
+	segments [][]byte
+	size     int64
+	off      int64
+}
+
+func newSegmentReader(segments [][]byte) *segmentReader {
+	sr := &segmentReader{segments: segments}
+	for _, seg := range segments {
+		sr.size += int64(len(seg))
+	}
+	return sr
+}
+
+func (sr *segmentReader) Read(p []byte) (int, error) {
+	if sr.off >= sr.size {
+		return 0, io.EOF
+	}
+
+	n := 0
+	var segStart int64
+	for _, seg := range sr.segments {
+		segEnd := segStart + int64(len(seg))
+		if sr.off < segEnd && n < len(p) {
+			m := copy(p[n:], seg[sr.off-segStart:])
+			n += m
+			sr.off += int64(m)
+		}
+		segStart = segEnd
+	}
+
+	return n, nil
+}
+
+// WriteTo implements io.WriterTo, so io.Copy (e.g. in http.ServeContent)
+// writes the segments without an intermediate buffer.
+func (sr *segmentReader) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	var segStart int64
+	for _, seg := range sr.segments {
+		segEnd := segStart + int64(len(seg))
+		if sr.off < segEnd {
+			n, err := w.Write(seg[sr.off-segStart:])
+			written += int64(n)
+			sr.off += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+		segStart = segEnd
+	}
+	return written, nil
+}
+
+func (sr *segmentReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = sr.off + offset
+	case io.SeekEnd:
+		abs = sr.size + offset
+	default:
+		return 0, errors.New("diag: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("diag: negative position")
+	}
+	sr.off = abs
+	return abs, nil
+}
@@ -0,0 +1,180 @@
+package diag
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// testDayKeys returns n Diagnosis Keys per day for the given amount of days,
+// uploaded every minute from the start of the first day.
+func testDayKeys(days, n int) []DiagnosisKey {
+	start := time.Date(2020, time.May, 4, 0, 0, 0, 0, time.UTC)
+	diagKeys := make([]DiagnosisKey, 0, days*n)
+	for d := 0; d < days; d++ {
+		for i := 0; i < n; i++ {
+			k := len(diagKeys)
+			diagKeys = append(diagKeys, DiagnosisKey{
+				TemporaryExposureKey: [16]byte{byte(k), byte(k >> 8), byte(k >> 16), 1},
+				RollingStartNumber:   uint32(k),
+				UploadedAt:           start.AddDate(0, 0, d).Add(time.Duration(i) * time.Minute),
+			})
+		}
+	}
+	return diagKeys
+}
+
+func readAll(t *testing.T, r io.Reader) []byte {
+	t.Helper()
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestDayCache(t *testing.T) {
+	diagKeys := testDayKeys(3, 5)
+	// An upload out of order is published with the key before it.
+	diagKeys[7].UploadedAt = diagKeys[7].UploadedAt.AddDate(0, 0, -1)
+
+	mc, dc := &MemoryCache{}, &DayCache{}
+	for _, c := range []Cache{mc, dc} {
+		if err := c.Set(diagKeys, diagKeys[len(diagKeys)-1].UploadedAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Listings are the same as those of MemoryCache.
+	start := time.Date(2020, time.May, 4, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		name       string
+		start, end time.Time
+	}{
+		{"first day", start, start.AddDate(0, 0, 1)},
+		{"second day", start.AddDate(0, 0, 1), start.AddDate(0, 0, 2)},
+		{"hour", start.AddDate(0, 0, 1), start.AddDate(0, 0, 1).Add(time.Hour)},
+		{"two days", start.Add(2 * time.Minute), start.AddDate(0, 0, 2).Add(3 * time.Minute)},
+		{"future", start.AddDate(0, 0, 5), start.AddDate(0, 0, 6)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			exp := readAll(t, mc.ReadSeekerBetween(tt.start, tt.end))
+			if got := readAll(t, dc.ReadSeekerBetween(tt.start, tt.end)); !bytes.Equal(got, exp) {
+				t.Errorf("expected: %x, got: %x", exp, got)
+			}
+		})
+	}
+	for _, after := range [][16]byte{{}, diagKeys[6].TemporaryExposureKey, diagKeys[14].TemporaryExposureKey, {42}} {
+		exp := readAll(t, mc.ReadSeeker(after))
+		if got := readAll(t, dc.ReadSeeker(after)); !bytes.Equal(got, exp) {
+			t.Errorf("after %x: expected: %x, got: %x", after, exp, got)
+		}
+	}
+
+	// Pages are the same as those of MemoryCache, also for stale cursors.
+	for _, cursor := range []Cursor{
+		{},
+		{Index: 3, PublishedAt: diagKeys[2].UploadedAt.UnixNano(), Key: diagKeys[2].TemporaryExposureKey},
+		{Index: 99, PublishedAt: diagKeys[6].UploadedAt.UnixNano(), Key: diagKeys[6].TemporaryExposureKey},
+		{Index: 1, PublishedAt: start.AddDate(0, 0, -1).UnixNano(), Key: [16]byte{42}},
+	} {
+		for {
+			expRS, expNext, expMore := mc.ReadSeekerPage(cursor, 4)
+			gotRS, gotNext, gotMore := dc.ReadSeekerPage(cursor, 4)
+			if exp, got := readAll(t, expRS), readAll(t, gotRS); !bytes.Equal(got, exp) {
+				t.Fatalf("cursor %+v: expected: %x, got: %x", cursor, exp, got)
+			}
+			if gotNext != expNext || gotMore != expMore {
+				t.Fatalf("cursor %+v: expected: %+v (%v), got: %+v (%v)", cursor, expNext, expMore, gotNext, gotMore)
+			}
+			if !gotMore {
+				break
+			}
+			cursor = gotNext
+		}
+	}
+
+	// Ranges can be read from the concatenated partitions.
+	rs := dc.ReadSeeker([16]byte{})
+	if _, err := rs.Seek(4*DiagnosisKeySize+3, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2*DiagnosisKeySize)
+	if _, err := io.ReadFull(rs, buf); err != nil {
+		t.Fatal(err)
+	}
+	all := readAll(t, mc.ReadSeeker([16]byte{}))
+	if exp := all[4*DiagnosisKeySize+3 : 6*DiagnosisKeySize+3]; !bytes.Equal(buf, exp) {
+		t.Errorf("expected: %x, got: %x", exp, buf)
+	}
+	if size, _ := rs.Seek(0, io.SeekEnd); size != int64(len(all)) {
+		t.Errorf("expected: %v, got: %v", len(all), size)
+	}
+}
+
+func TestDayCacheReusesUnchangedDays(t *testing.T) {
+	diagKeys := testDayKeys(3, 5)
+	dc := &DayCache{}
+	if err := dc.Set(diagKeys, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	before := append([]*dayPartition(nil), dc.days...)
+
+	// A new key on the last day only replaces its partition.
+	newKey := DiagnosisKey{TemporaryExposureKey: [16]byte{42}, UploadedAt: diagKeys[len(diagKeys)-1].UploadedAt.Add(time.Minute)}
+	if err := dc.Set(append(diagKeys, newKey), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(dc.days) != 3 {
+		t.Fatalf("expected 3 days, got: %v", len(dc.days))
+	}
+	for i, exp := range []bool{true, true, false} {
+		if got := dc.days[i] == before[i]; got != exp {
+			t.Errorf("day %v: expected reused: %v, got: %v", i, exp, got)
+		}
+	}
+}
+
+func BenchmarkCacheSet(b *testing.B) {
+	diagKeys := testDayKeys(14, 10000)
+	for _, c := range []struct {
+		name  string
+		cache Cache
+	}{
+		{"MemoryCache", &MemoryCache{}},
+		{"DayCache", &DayCache{}},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			c.cache.Set(diagKeys, time.Time{})
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// A refresh with the same keys, as most days don't change.
+				c.cache.Set(diagKeys, time.Time{})
+			}
+		})
+	}
+}
+
+func BenchmarkCacheReadSeekerBetween(b *testing.B) {
+	diagKeys := testDayKeys(14, 10000)
+	start := time.Date(2020, time.May, 10, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		name  string
+		cache Cache
+	}{
+		{"MemoryCache", &MemoryCache{}},
+		{"DayCache", &DayCache{}},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			c.cache.Set(diagKeys, time.Time{})
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				io.Copy(ioutil.Discard, c.cache.ReadSeekerBetween(start, start.AddDate(0, 0, 1)))
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package diag
+
+// fieldTransmissionRiskLevel is the field name used as metric label when a
+// default is applied.
+const fieldTransmissionRiskLevel = "transmission_risk_level"
+
+// applyDefaults returns the Diagnosis Keys with configured defaults applied to
+// zero valued fields, which clients may send when they omit a field. The
+// given slice is not modified.
+func (s Service) applyDefaults(diagKeys []DiagnosisKey) []DiagnosisKey {
+	if s.defaultTransmissionRiskLevel == 0 {
+		return diagKeys
+	}
+
+	var withDefaults []DiagnosisKey
+	for i := range diagKeys {
+		if diagKeys[i].TransmissionRiskLevel != 0 {
+			continue
+		}
+		if withDefaults == nil {
+			withDefaults = make([]DiagnosisKey, len(diagKeys))
+			copy(withDefaults, diagKeys)
+		}
+		withDefaults[i].TransmissionRiskLevel = s.defaultTransmissionRiskLevel
+		defaultsApplied.Inc(fieldTransmissionRiskLevel)
+	}
+
+	if withDefaults == nil {
+		return diagKeys
+	}
+	return withDefaults
+}
@@ -0,0 +1,38 @@
+package diag
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStoreDiagnosisKeysDefaults(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	repo := storingRepo{stored: &[]DiagnosisKey{}}
+	svc, err := NewService(ctx, Config{Repository: repo, Logger: NewNopLogger(), DefaultTransmissionRiskLevel: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diagKeys := []DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, TransmissionRiskLevel: 0},
+		{TemporaryExposureKey: [16]byte{2}, TransmissionRiskLevel: 7},
+	}
+	applied := defaultsApplied.Value(fieldTransmissionRiskLevel)
+
+	if _, err := svc.StoreDiagnosisKeys(ctx, diagKeys); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := *repo.stored
+	if len(stored) != 2 || stored[0].TransmissionRiskLevel != 4 || stored[1].TransmissionRiskLevel != 7 {
+		t.Errorf("expected transmission risk levels [4 7], got: %+v", stored)
+	}
+	if diagKeys[0].TransmissionRiskLevel != 0 {
+		t.Error("expected given diagnosis keys not to be modified")
+	}
+	if got := defaultsApplied.Value(fieldTransmissionRiskLevel) - applied; got != 1 {
+		t.Errorf("expected: %v, got: %v", 1, got)
+	}
+}
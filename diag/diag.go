@@ -5,14 +5,15 @@ package diag
 
 import (
 	"context"
+	"crypto"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"strconv"
+	"sync/atomic"
 	"time"
-
-	"go.uber.org/zap"
 )
 
 // DiagnosisKeySize represents the size of a Diagnosis Key when transmitted
@@ -20,7 +21,14 @@ import (
 // for the RollingStartNumber, and 1 byte for the TransmissionRiskLevel).
 const DiagnosisKeySize = 21
 
-const defaultMaxUploadBatchSize = 14
+const (
+	defaultMaxUploadBatchSize = 14
+	defaultCacheInterval      = 5 * time.Minute
+
+	// publicationMargin accounts for uploads that were still being stored
+	// (uncommitted) while the cache was hydrated.
+	publicationMargin = time.Minute
+)
 
 var (
 	// ErrNilDiagKeys is used when an empty diagnosis keyset is encountered.
@@ -38,6 +46,20 @@ type DiagnosisKey struct {
 	RollingStartNumber    uint32
 	TransmissionRiskLevel byte
 	UploadedAt            time.Time
+	// Regions are the regions (e.g. country codes) the key was tagged with on
+	// upload, see ParseRegions. They aren't part of the binary representation.
+	Regions []string
+	// ReportType and DaysSinceOnsetOfSymptoms (nil if unknown) are included
+	// in export files, for risk scoring by clients. They aren't part of the
+	// binary representation either.
+	ReportType               ReportType
+	DaysSinceOnsetOfSymptoms *int32
+	// RollingPeriod is the amount of intervals (10 minutes) the key was valid
+	// for, between 1 and MaxRollingPeriod, or zero for the default
+	// (MaxRollingPeriod), e.g. for keys uploaded in the binary representation.
+	// It's included in export files, and in the extended binary
+	// representation (see ParseExtendedDiagnosisKeys).
+	RollingPeriod uint8
 }
 
 // ExposureConfig represents the parameters for detecting exposure.
@@ -58,26 +80,171 @@ type ExposureConfig struct {
 // in a repository.
 type Repository interface {
 	StoreDiagnosisKeys(ctx context.Context, diagKeys []DiagnosisKey, createdAt time.Time) error
-	FindAllDiagnosisKeys(ctx context.Context) ([]byte, error)
+	// FindAllDiagnosisKeys returns all Diagnosis Keys (including their upload
+	// time), ordered by upload.
+	FindAllDiagnosisKeys(ctx context.Context) ([]DiagnosisKey, error)
 	LastModified(ctx context.Context) (time.Time, error)
 }
 
+// Schedule defines when a periodic task runs, e.g. a cron schedule.
+type Schedule interface {
+	// Next returns the first activation time after t, or the zero time if
+	// there is none.
+	Next(t time.Time) time.Time
+}
+
 // Service represents the service for managing diagnosis keys.
 type Service struct {
 	repo               Repository
 	cache              Cache
 	maxUploadBatchSize uint
-	logger             *zap.Logger
+	logger             Logger
+	purger             Purger
+	retentionPeriod    time.Duration
+	purgedAt           *syncTime
+	purgeDryRun        bool
+	uploads            *uploadLimiter
+	uploadEventLogger  Logger
+	cacheInterval      time.Duration
+	cacheAlignment     time.Duration
+	refreshSchedule    Schedule
+	purgeSchedule      Schedule
+	jobs               JobRecorder
+	hydratedAt         *syncTime
+	digest             *contentDigest
+	signatures         *signatureCache
+	refreshes          *int64
+	revoker            Revoker
+	signer             crypto.Signer
+	revocations        *revocationList
+	tlog               *transparencyLog
+	flights            *flightGroup
+	knownKeys          *knownKeys
+	lateKeys           *lateKeys
+	exportCfg          ExportConfig
+	exports            *exportCache
+	snapshotCodec      SnapshotCodec
+
+	defaultTransmissionRiskLevel byte
+	maxStoredKeys                int
+	refuseUploadsOverQuota       bool
+	storedKeys                   *int64
+	publishEmptyBatches          bool
+	uploadSaturationThreshold    float64
+	uploadReceipts               bool
+	maxKeyAge                    time.Duration
+	keyClockSkew                 time.Duration
+	maxFederatedKeyAge           time.Duration
+	requestDriven                bool
 }
 
 // Config represents the configuration to create a Service.
 type Config struct {
-	Repository         Repository
-	Cache              Cache
-	CacheInterval      time.Duration
+	Repository    Repository
+	Cache         Cache
+	CacheInterval time.Duration
+	// CacheAlignment, if non zero, causes the cache to be refreshed right
+	// after each multiple of it (plus a small margin), on top of the regular
+	// CacheInterval. This publishes time buckets (see ReadSeekerBetween) as
+	// soon as they are complete.
+	CacheAlignment time.Duration
+	// RefreshSchedule, if set, replaces CacheInterval and CacheAlignment: the
+	// cache is refreshed right after each scheduled time (plus a small
+	// margin), so keys are published at announced times.
+	RefreshSchedule    Schedule
 	MaxUploadBatchSize uint
-	Logger             *zap.Logger
+	Logger             Logger
 	ExposureConfig     ExposureConfig
+	// ExposureConfigHistory, if set, replaces ExposureConfig: the version
+	// effective at the time of a request is served.
+	ExposureConfigHistory ExposureConfigHistory
+	// RetentionPeriod is the period after which uploaded Diagnosis Keys are
+	// purged. Zero disables purging. Requires Repository to implement Purger.
+	RetentionPeriod time.Duration
+	// PurgeSchedule, if set, replaces the hourly interval between purges.
+	PurgeSchedule Schedule
+	// PurgeDryRun makes purges report the Diagnosis Keys they would delete
+	// (see PurgeDryRun) instead of deleting them, e.g. to review a retention
+	// period before enabling it in production.
+	PurgeDryRun bool
+	// MaxConcurrentUploads limits the amount of Diagnosis Key uploads that are
+	// stored concurrently. Zero means no limit.
+	MaxConcurrentUploads uint
+	// MaxQueuedUploads is the amount of uploads that may wait for one of the
+	// MaxConcurrentUploads slots. When exceeded, ErrUploadQueueFull is
+	// returned.
+	MaxQueuedUploads uint
+	// UploadEventLogger, if set, receives a structured event per accepted
+	// upload (see StoreDiagnosisKeys), for charting trends in case reports.
+	UploadEventLogger Logger
+	// Signer is used to sign the revocation list, the tree heads of the
+	// transparency log, export files and other artifacts (see Sign).
+	// Revocation is enabled if Signer is set and Repository implements Revoker
+	// (or RevocationRepository is set). The transparency log is enabled if
+	// Signer is set and RetentionPeriod is zero, as purging keys breaks its
+	// append-only property.
+	Signer crypto.Signer
+	// CacheSnapshot, if set, is used to hydrate the cache on startup (see
+	// WriteCacheSnapshot), instead of reading all Diagnosis Keys from the
+	// repository. The cache is then refreshed from the repository right away,
+	// in the background. An invalid or corrupt snapshot is logged and ignored.
+	CacheSnapshot io.Reader
+	// SnapshotCodec, if set, compresses snapshots written by
+	// WriteCacheSnapshot. Compressed snapshots are read if written with gzip
+	// or SnapshotCodec, so the codec can be changed between restarts.
+	SnapshotCodec SnapshotCodec
+	// DuplicateFilter enables a Bloom filter of stored keys (refreshed with
+	// the cache), so uploads of already stored keys are skipped without a
+	// repository call.
+	DuplicateFilter bool
+	// DefaultTransmissionRiskLevel, if non zero, is applied to uploaded keys
+	// with a zero TransmissionRiskLevel, so risk scoring isn't skewed by
+	// clients omitting it.
+	DefaultTransmissionRiskLevel byte
+	// MaxStoredKeys is a soft cap on the amount of stored Diagnosis Keys.
+	// When exceeded, an error is logged on every cache refresh, and uploads
+	// are refused with ErrQuotaExceeded if RefuseUploadsOverQuota is set.
+	// Zero means no cap.
+	MaxStoredKeys          int
+	RefuseUploadsOverQuota bool
+	// Export, if set, enables signed Exposure Notification export files (see
+	// Service.Export). It requires Signer to be set.
+	Export *ExportConfig
+	// PublishEmptyBatches lists batches without Diagnosis Keys (see
+	// Service.Batches), so clients can tell a day without new keys from an
+	// unavailable server.
+	PublishEmptyBatches bool
+	// UploadSaturationThreshold, if non zero, is the saturation (0 to 1) of
+	// the upload queue or the repository connection pool (see Load) at which
+	// uploads are rejected with ErrOverloaded, so clients back off before
+	// the queue is full.
+	UploadSaturationThreshold float64
+	// UploadReceipts enables signed upload receipts (see IssueReceipt), which
+	// allow uploaders to withdraw their upload. It requires Signer to be set
+	// to an ECDSA key, Repository to implement KeyInserter, and KeyDeleter
+	// for withdrawals.
+	UploadReceipts bool
+	// MaxKeyAge, if non zero, rejects uploads with keys whose rolling
+	// interval started more than MaxKeyAge ago, or starts more than
+	// KeyClockSkew from now, with an *InvalidKeysError.
+	MaxKeyAge    time.Duration
+	KeyClockSkew time.Duration
+	// MaxFederatedKeyAge, if non zero, drops keys pushed by peer servers
+	// whose rolling interval started more than MaxFederatedKeyAge ago (see
+	// DropExpiredKeys), e.g. keys backfilled by a peer after this server
+	// purged them.
+	MaxFederatedKeyAge time.Duration
+	// RevocationRepository and JobRepository, if set, are used for
+	// revocations and job runs instead of Repository, so these can be kept
+	// in a different backend than Diagnosis Keys.
+	RevocationRepository Revoker
+	JobRepository        JobRecorder
+	// RequestDriven disables the background goroutines of the service, for
+	// request-driven environments (e.g. AWS Lambda or Cloud Run) that
+	// suspend instances between requests. The cache isn't hydrated on
+	// startup, but on demand (see HydrateIfStale), and expired keys are only
+	// purged via Purge, e.g. by a scheduled job. CacheSnapshot is ignored.
+	RequestDriven bool
 }
 
 // NewService returns a new Service.
@@ -90,6 +257,34 @@ func NewService(ctx context.Context, cfg Config) (Service, error) {
 		cache:              cfg.Cache,
 		maxUploadBatchSize: cfg.MaxUploadBatchSize,
 		logger:             cfg.Logger,
+		uploadEventLogger:  cfg.UploadEventLogger,
+		cacheInterval:      cfg.CacheInterval,
+		cacheAlignment:     cfg.CacheAlignment,
+		refreshSchedule:    cfg.RefreshSchedule,
+		signer:             cfg.Signer,
+		snapshotCodec:      cfg.SnapshotCodec,
+		hydratedAt:         &syncTime{},
+		lateKeys:           &lateKeys{},
+		digest:             &contentDigest{},
+		signatures:         &signatureCache{},
+		refreshes:          new(int64),
+		flights:            &flightGroup{onJoin: func() { coalescedRefreshes.Inc() }},
+
+		defaultTransmissionRiskLevel: cfg.DefaultTransmissionRiskLevel,
+		maxStoredKeys:                cfg.MaxStoredKeys,
+		refuseUploadsOverQuota:       cfg.RefuseUploadsOverQuota,
+		storedKeys:                   new(int64),
+		publishEmptyBatches:          cfg.PublishEmptyBatches,
+		uploadSaturationThreshold:    cfg.UploadSaturationThreshold,
+		uploadReceipts:               cfg.UploadReceipts,
+		maxKeyAge:                    cfg.MaxKeyAge,
+		keyClockSkew:                 cfg.KeyClockSkew,
+		maxFederatedKeyAge:           cfg.MaxFederatedKeyAge,
+		requestDriven:                cfg.RequestDriven,
+	}
+
+	if storer, ok := cfg.Repository.(PublicationTimeStorer); ok {
+		svc.lateKeys.storer = storer
 	}
 
 	// Default to in-memory cache.
@@ -98,8 +293,8 @@ func NewService(ctx context.Context, cfg Config) (Service, error) {
 	}
 
 	// Set sane default for cache refresh interval.
-	if cfg.CacheInterval == 0 {
-		cfg.CacheInterval = 5 * time.Minute
+	if svc.cacheInterval == 0 {
+		svc.cacheInterval = defaultCacheInterval
 	}
 
 	// Set sane default for max upload batch size.
@@ -107,35 +302,188 @@ func NewService(ctx context.Context, cfg Config) (Service, error) {
 		svc.maxUploadBatchSize = defaultMaxUploadBatchSize
 	}
 
-	// Hydrate cache.
-	if err := svc.hydrateCache(ctx); err != nil {
-		return Service{}, fmt.Errorf("diag: could not hydrate cache: %v", err)
+	if cfg.DuplicateFilter {
+		svc.knownKeys = &knownKeys{}
+	}
+
+	if cfg.MaxConcurrentUploads > 0 {
+		svc.uploads = newUploadLimiter(cfg.MaxConcurrentUploads, cfg.MaxQueuedUploads)
+	}
+
+	revoker, _ := cfg.Repository.(Revoker)
+	if cfg.RevocationRepository != nil {
+		revoker = cfg.RevocationRepository
+	}
+	if revoker != nil && cfg.Signer != nil {
+		svc.revoker = revoker
+		svc.revocations = &revocationList{}
+	}
+
+	if cfg.Signer != nil && cfg.RetentionPeriod == 0 {
+		svc.tlog = &transparencyLog{}
+	}
+
+	if cfg.Export != nil && cfg.Signer != nil {
+		svc.exportCfg = *cfg.Export
+		if svc.exportCfg.VerificationKeyID == "" {
+			svc.exportCfg.VerificationKeyID = svc.exportCfg.Region
+		}
+		svc.exports = &exportCache{}
+	}
+
+	if jobs, ok := cfg.Repository.(JobRecorder); ok {
+		svc.jobs = jobs
+	}
+	if cfg.JobRepository != nil {
+		svc.jobs = cfg.JobRepository
+	}
+
+	if cfg.RetentionPeriod > 0 {
+		purger, ok := cfg.Repository.(Purger)
+		if !ok {
+			return Service{}, errors.New("diag: repository doesn't support purging diagnosis keys")
+		}
+		svc.purger = purger
+		svc.retentionPeriod = cfg.RetentionPeriod
+		svc.purgeSchedule = cfg.PurgeSchedule
+		svc.purgedAt = &syncTime{}
+		svc.purgeDryRun = cfg.PurgeDryRun
+	}
+
+	if svc.requestDriven {
+		svc.logger.Info("Cache hydration deferred until the first request.")
+		return svc, nil
+	}
+
+	// Hydrate cache, preferably from a snapshot.
+	var fromSnapshot bool
+	if cfg.CacheSnapshot != nil {
+		if err := svc.hydrateCacheFromSnapshot(ctx, cfg.CacheSnapshot); err != nil {
+			if err == ErrSnapshotCorrupt {
+				corruptSnapshots.Inc()
+			}
+			svc.logger.Warn("Could not hydrate cache from snapshot.", Err(err))
+		} else {
+			fromSnapshot = true
+		}
+	}
+	if !fromSnapshot {
+		if err := svc.hydrateCache(ctx); err != nil {
+			return Service{}, fmt.Errorf("diag: could not hydrate cache: %v", err)
+		}
 	}
 	n, err := svc.cache.ReadSeeker([16]byte{}).Seek(0, io.SeekEnd)
 	if err != nil {
 		return Service{}, fmt.Errorf("diag: could not seek cache: %v", err)
 	}
-	svc.logger.Info("Cache hydrated.", zap.Int64("size", n))
+	svc.logger.Info("Cache hydrated.", F("size", n), F("fromSnapshot", fromSnapshot))
 
 	// Run cache refresh worker in separate goroutine.
 	go func() {
-		if err := svc.refreshCache(ctx, cfg.CacheInterval); err != nil && err != context.Canceled {
-			svc.logger.Error("Could not refresh cache.", zap.Error(err))
+		if fromSnapshot {
+			svc.refresh(ctx)
+		}
+		if err := svc.refreshCache(ctx, svc.cacheInterval); err != nil && err != context.Canceled {
+			svc.logger.Error("Could not refresh cache.", Err(err))
 		}
 	}()
 
+	// Run janitor for purging expired keys in separate goroutine.
+	if svc.purger != nil {
+		go svc.runJanitor(ctx)
+	}
+
 	return svc, nil
 }
 
 // StoreDiagnosisKeys persists a set of diagnosis keys to the repository.
-func (s Service) StoreDiagnosisKeys(ctx context.Context, diagKeys []DiagnosisKey) error {
+// Duplicate keys are ignored. If the maximum amount of concurrent uploads is
+// reached, it waits for a slot or returns ErrUploadQueueFull when the queue is
+// full. If the stored keys quota is exceeded, it may return ErrQuotaExceeded,
+// and if the service is overloaded (see Load), ErrOverloaded. Uploads with
+// implausible keys (see Config.MaxKeyAge) are rejected with an
+// *InvalidKeysError, and uploads of more than MaxUploadBatchSize keys with
+// ErrMaxUploadExceeded.
+//
+// If the repository implements KeyInserter, the keys the upload stored (i.e.
+// that weren't stored yet) are returned, with their upload time, e.g. for
+// IssueReceipt. Otherwise, nil is returned.
+func (s Service) StoreDiagnosisKeys(ctx context.Context, diagKeys []DiagnosisKey) ([]DiagnosisKey, error) {
+	if uint(len(diagKeys)) > s.maxUploadBatchSize {
+		return nil, ErrMaxUploadExceeded
+	}
+	if err := s.checkPlausibility(diagKeys, time.Now()); err != nil {
+		return nil, err
+	}
+	if err := checkReports(diagKeys); err != nil {
+		return nil, err
+	}
+	if err := checkRollingPeriods(diagKeys); err != nil {
+		return nil, err
+	}
+	if s.quotaExceeded() {
+		return nil, ErrQuotaExceeded
+	}
+	if s.uploadSaturationThreshold > 0 && s.Load().Overloaded {
+		return nil, ErrOverloaded
+	}
+
+	diagKeys = s.applyDefaults(diagKeys)
+
+	// Skip keys that are known to be stored already. If all keys are known,
+	// e.g. because a client retried an upload, the upload is a no-op.
+	if s.knownKeys != nil && len(diagKeys) > 0 {
+		diagKeys = s.withoutKnownKeys(diagKeys)
+		if len(diagKeys) == 0 {
+			return nil, nil
+		}
+	}
+
+	if s.uploads != nil {
+		if err := s.uploads.acquire(ctx); err != nil {
+			return nil, err
+		}
+		defer s.uploads.release()
+	}
+
 	now := time.Now().UTC()
 
-	if err := s.repo.StoreDiagnosisKeys(ctx, diagKeys, now); err != nil {
-		return err
+	var stored []DiagnosisKey
+	if inserter, ok := s.repo.(KeyInserter); ok {
+		var err error
+		if stored, err = inserter.InsertDiagnosisKeys(ctx, diagKeys, now); err != nil {
+			return nil, err
+		}
+		for i := range stored {
+			stored[i].UploadedAt = now
+		}
+	} else if err := s.repo.StoreDiagnosisKeys(ctx, diagKeys, now); err != nil {
+		return nil, err
 	}
 
-	return nil
+	if s.uploadEventLogger != nil {
+		s.logUploadEvent(diagKeys, now)
+	}
+
+	return stored, nil
+}
+
+// logUploadEvent emits an event for an accepted upload. To be privacy-safe,
+// it only contains aggregates: the key count and the distribution of
+// transmission risk levels, the upload hour (not the exact time) and the time
+// it took to store the upload.
+func (s Service) logUploadEvent(diagKeys []DiagnosisKey, uploadedAt time.Time) {
+	riskLevels := make(map[string]int)
+	for _, diagKey := range diagKeys {
+		riskLevels[strconv.Itoa(int(diagKey.TransmissionRiskLevel))]++
+	}
+
+	s.uploadEventLogger.Info("Diagnosis keys uploaded.",
+		F("uploadHour", uploadedAt.Truncate(time.Hour)),
+		F("keyCount", len(diagKeys)),
+		F("transmissionRiskLevels", riskLevels),
+		F("latency", time.Since(uploadedAt)),
+	)
 }
 
 // ParseDiagnosisKeys reads and parses diagnosis keys from an io.Reader.
@@ -152,24 +500,18 @@ func ParseDiagnosisKeys(r io.Reader) ([]DiagnosisKey, error) {
 		return nil, io.ErrUnexpectedEOF
 	}
 
-	keyCount := n / DiagnosisKeySize
-	diagKeys := make([]DiagnosisKey, keyCount)
-
-	for i := 0; i < keyCount; i++ {
-		start := i * DiagnosisKeySize
-		var key [16]byte
-		copy(key[:], buf[start:start+16])
-		rollingStartNumber := binary.BigEndian.Uint32(buf[start+16 : start+DiagnosisKeySize])
-		transRiskLevel := buf[start+20]
+	return decodeDiagnosisKeys(buf), nil
+}
 
-		diagKeys[i] = DiagnosisKey{
-			TemporaryExposureKey:  key,
-			RollingStartNumber:    rollingStartNumber,
-			TransmissionRiskLevel: transRiskLevel,
-		}
+// decodeDiagnosisKeys decodes the binary representation of Diagnosis Keys in
+// buf, whose length must be a multiple of DiagnosisKeySize.
+func decodeDiagnosisKeys(buf []byte) []DiagnosisKey {
+	ek := EncodedKeys(buf)
+	diagKeys := make([]DiagnosisKey, ek.Len())
+	for i := range diagKeys {
+		diagKeys[i] = ek.DiagnosisKey(i)
 	}
-
-	return diagKeys, nil
+	return diagKeys
 }
 
 // ReadSeeker returns an io.ReadSeeker for accessing the cache.
@@ -179,7 +521,40 @@ func (s Service) ReadSeeker(after [16]byte) io.ReadSeeker {
 	return s.cache.ReadSeeker(after)
 }
 
-// LastModified returns the timestamp of the latest Diagnosis Key upload.
+// ReadSeekerBetween returns an io.ReadSeeker for accessing Diagnosis Keys
+// published in the time range [start, end).
+func (s Service) ReadSeekerBetween(start, end time.Time) io.ReadSeeker {
+	return s.cache.ReadSeekerBetween(start, end)
+}
+
+// IsPublished returns true if all Diagnosis Keys published before t are
+// available in the cache, i.e. the time range before t is complete.
+func (s Service) IsPublished(t time.Time) bool {
+	return !s.hydratedAt.get().Before(t.Add(publicationMargin))
+}
+
+// EstimatedPublicationTime returns the estimated time at which Diagnosis Keys
+// uploaded at uploadedAt are available for download, i.e. the next cache
+// refresh after the upload.
+func (s Service) EstimatedPublicationTime(uploadedAt time.Time) time.Time {
+	if s.refreshSchedule != nil {
+		return s.refreshSchedule.Next(uploadedAt).Add(publicationMargin).UTC()
+	}
+	next := s.hydratedAt.get().Add(s.cacheInterval)
+	if !next.After(uploadedAt) {
+		next = uploadedAt.Add(s.cacheInterval)
+	}
+	if s.cacheAlignment > 0 {
+		if aligned := nextAlignedRefresh(uploadedAt, s.cacheAlignment); aligned.Before(next) {
+			next = aligned
+		}
+	}
+	return next.UTC()
+}
+
+// LastModified returns the timestamp of the latest Diagnosis Key upload, or of
+// the latest cache refresh that changed the keys without advancing it (e.g.
+// a backfill of keys uploaded earlier). It never moves backwards.
 func (s Service) LastModified() time.Time {
 	return s.cache.LastModified().UTC()
 }
@@ -190,33 +565,99 @@ func (s Service) MaxUploadBatchSize() uint {
 	return s.maxUploadBatchSize
 }
 
+// WriteDiagnosisKeys writes the binary representation of Diagnosis Keys to w.
 func WriteDiagnosisKeys(w io.Writer, diagKeys ...DiagnosisKey) error {
 	// Write binary data for the diagnosis keys. Per diagnosis key, 16 bytes are
-	// written with the diagnosis key itself, and 4 bytes for `RollingStartNumber`
-	// (uint32, big endian). Because both parts have a fixed length, there is no
-	// delimiter.
+	// written with the diagnosis key itself, 4 bytes for `RollingStartNumber`
+	// (uint32, big endian) and 1 byte for `TransmissionRiskLevel`. Because all
+	// parts have a fixed length, there is no delimiter.
+	var buf [DiagnosisKeySize]byte
 	for i := range diagKeys {
-		_, err := w.Write(diagKeys[i].TemporaryExposureKey[:])
-		if err != nil {
+		encodeDiagnosisKey(buf[:], diagKeys[i])
+		if _, err := w.Write(buf[:]); err != nil {
 			return err
 		}
-		rollingStartNumber := make([]byte, 4)
-		binary.BigEndian.PutUint32(rollingStartNumber, diagKeys[i].RollingStartNumber)
-		_, err = w.Write(rollingStartNumber)
-		if err != nil {
+	}
+
+	return nil
+}
+
+// encodeDiagnosisKey writes the binary representation of a Diagnosis Key to
+// dst, which must be at least DiagnosisKeySize bytes.
+func encodeDiagnosisKey(dst []byte, diagKey DiagnosisKey) {
+	copy(dst, diagKey.TemporaryExposureKey[:])
+	binary.BigEndian.PutUint32(dst[16:20], diagKey.RollingStartNumber)
+	dst[20] = diagKey.TransmissionRiskLevel
+}
+
+// RefreshCache hydrates the cache with all Diagnosis Keys from the
+// repository. Concurrent refreshes (including the periodic refresh) are
+// coalesced into a single repository query.
+func (s Service) RefreshCache(ctx context.Context) error {
+	return s.hydrateCache(ctx)
+}
+
+// HydrateIfStale hydrates the cache from the repository if it wasn't hydrated
+// yet, or if a refresh is due (see Config.CacheInterval, CacheAlignment and
+// RefreshSchedule), for request-driven services (see Config.RequestDriven).
+// Concurrent calls are coalesced. If a refresh fails, the error is logged and
+// the stale cache is served; an error is only returned if the cache was never
+// hydrated.
+func (s Service) HydrateIfStale(ctx context.Context) error {
+	if !s.cacheStale(time.Now()) {
+		return nil
+	}
+
+	err := s.flights.do("hydrateCache", func() error {
+		// A hydration may have finished since the check.
+		if !s.cacheStale(time.Now()) {
+			return nil
+		}
+		if err := s.hydrateCacheOnce(ctx); err != nil {
 			return err
 		}
-		_, err = w.Write([]byte{diagKeys[i].TransmissionRiskLevel})
-		if err != nil {
+		s.logger.Info("Cache hydrated on demand.")
+		return nil
+	})
+	if err != nil {
+		if s.hydratedAt.get().IsZero() {
 			return err
 		}
+		s.logger.Error("Could not refresh cache", Err(err))
+		return nil
 	}
 
 	return nil
 }
 
+// cacheStale returns true if the cache wasn't hydrated yet, or if a refresh
+// was due before now.
+func (s Service) cacheStale(now time.Time) bool {
+	hydratedAt := s.hydratedAt.get()
+	switch {
+	case hydratedAt.IsZero():
+		return true
+	case s.refreshSchedule != nil:
+		next := s.refreshSchedule.Next(hydratedAt)
+		return !next.IsZero() && !now.Before(next.Add(publicationMargin))
+	case s.cacheAlignment > 0 && !now.Before(nextAlignedRefresh(hydratedAt, s.cacheAlignment)):
+		return true
+	}
+	return now.Sub(hydratedAt) >= s.cacheInterval
+}
+
+// hydrateCache hydrates the cache from the repository. Concurrent calls share
+// the result of a single hydration.
 func (s Service) hydrateCache(ctx context.Context) error {
-	buf, err := s.repo.FindAllDiagnosisKeys(ctx)
+	return s.flights.do("hydrateCache", func() error {
+		return s.hydrateCacheOnce(ctx)
+	})
+}
+
+func (s Service) hydrateCacheOnce(ctx context.Context) error {
+	start := time.Now()
+
+	diagKeys, err := s.repo.FindAllDiagnosisKeys(ctx)
 	if err != nil {
 		return err
 	}
@@ -226,31 +667,157 @@ func (s Service) hydrateCache(ctx context.Context) error {
 		return err
 	}
 
-	if err := s.cache.Set(buf, lastModified); err != nil {
+	// Keys committed before the start of hydration are guaranteed to be in
+	// the cache.
+	if err := s.setCache(ctx, diagKeys, lastModified, start); err != nil {
 		return err
 	}
+	atomic.AddInt64(s.refreshes, 1)
+	cacheHydrationDuration.Observe(time.Since(start).Seconds())
+
+	return nil
+}
+
+// hydrateCacheFromSnapshot hydrates the cache with a snapshot written by
+// WriteCacheSnapshot.
+func (s Service) hydrateCacheFromSnapshot(ctx context.Context, r io.Reader) error {
+	rc, err := s.decodeSnapshot(r)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	diagKeys, hydratedAt, lastModified, err := readCacheSnapshot(rc)
+	if err != nil {
+		return err
+	}
+
+	return s.setCache(ctx, diagKeys, lastModified, hydratedAt)
+}
+
+// setCache replaces the cache, and updates the revocation list and the
+// transparency log. The published last modified time never moves backwards,
+// see publicationTime.
+func (s Service) setCache(ctx context.Context, diagKeys []DiagnosisKey, lastModified, hydratedAt time.Time) error {
+	if s.lateKeys != nil {
+		var err error
+		diagKeys, err = s.lateKeys.apply(ctx, diagKeys, s.hydratedAt.get())
+		if err != nil {
+			s.logger.Warn("Could not store publication times of late keys; keeping them in memory.", Err(err))
+		}
+	}
+	sum := sumDiagnosisKeys(diagKeys)
+	lastModified = publicationTime(s.cache.LastModified(), lastModified, hydratedAt, sum != s.digest.get())
+
+	s.signatures.begin()
+	err := s.cache.Set(diagKeys, lastModified)
+	s.signatures.end()
+	if err != nil {
+		return err
+	}
+	s.hydratedAt.set(hydratedAt)
+	s.digest.set(sum)
+	cacheSizeBytes.Set(float64(len(diagKeys) * DiagnosisKeySize))
+	s.checkQuota(len(diagKeys))
+
+	if s.knownKeys != nil {
+		s.knownKeys.set(diagKeys)
+	}
+
+	if s.revoker != nil {
+		if err := s.loadRevocations(ctx); err != nil {
+			return err
+		}
+	}
+
+	if s.tlog != nil {
+		if err := s.appendToLog(diagKeys, hydratedAt); err != nil {
+			return err
+		}
+	}
+
+	// Export files are also generated on request, so a failure isn't fatal.
+	if err := s.cacheExports(); err != nil {
+		s.logger.Warn("Could not cache export files.", Err(err))
+	}
 
 	return nil
 }
 
 func (s Service) refreshCache(ctx context.Context, interval time.Duration) error {
+	if s.refreshSchedule != nil {
+		for {
+			// The margin allows time buckets ending at the scheduled time to
+			// be published (see IsPublished).
+			if err := waitUntil(ctx, s.refreshSchedule, publicationMargin); err != nil {
+				return err
+			}
+			s.refresh(ctx)
+		}
+	}
+
 	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	var aligned <-chan time.Time
 	for {
+		if s.cacheAlignment > 0 && aligned == nil {
+			aligned = time.After(time.Until(nextAlignedRefresh(time.Now(), s.cacheAlignment)))
+		}
+
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-t.C:
-			if err := s.hydrateCache(ctx); err != nil {
-				s.logger.Error("Could not refresh cache", zap.Error(err))
-				continue
-			}
-			n, err := s.cache.ReadSeeker([16]byte{}).Seek(0, io.SeekEnd)
-			if err != nil {
-				s.logger.Error("Could not seek cache", zap.Error(err))
-				continue
-			}
-
-			s.logger.Info("Cache refreshed.", zap.Int64("size", n))
+		case <-aligned:
+			aligned = nil
 		}
+
+		s.refresh(ctx)
+	}
+}
+
+// refresh hydrates the cache as background job, logging the outcome.
+func (s Service) refresh(ctx context.Context) {
+	if _, err := s.runJob(ctx, JobRefresh, 0); err != nil {
+		s.logger.Error("Could not refresh cache", Err(err))
+		return
+	}
+	n, err := s.cache.ReadSeeker([16]byte{}).Seek(0, io.SeekEnd)
+	if err != nil {
+		s.logger.Error("Could not seek cache", Err(err))
+		return
+	}
+
+	s.logger.Info("Cache refreshed.", F("size", n))
+}
+
+// waitUntil blocks until margin after the next activation time of schedule,
+// or until ctx is done.
+func waitUntil(ctx context.Context, schedule Schedule, margin time.Duration) error {
+	next := schedule.Next(time.Now())
+	if next.IsZero() {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(time.Until(next.Add(margin)))
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// nextAlignedRefresh returns the first time after now at which the time
+// bucket (of size alignment) preceding it is complete and can be published.
+func nextAlignedRefresh(now time.Time, alignment time.Duration) time.Time {
+	next := now.Truncate(alignment).Add(publicationMargin)
+	if !next.After(now) {
+		next = next.Add(alignment)
 	}
+	return next
 }
@@ -0,0 +1,86 @@
+package diag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// EncodedKeys is the binary representation of Diagnosis Keys (see
+// WriteDiagnosisKeys), as held by the cache: a single contiguous buffer
+// without pointers, which the garbage collector doesn't need to scan. Its
+// methods access keys in place, so callers can iterate keys without
+// materializing a DiagnosisKey per key.
+type EncodedKeys []byte
+
+// ReadEncodedKeys reads the Diagnosis Keys of r, e.g. a reader returned by
+// Service.ReadSeekerBetween. An incomplete key results in
+// io.ErrUnexpectedEOF.
+func ReadEncodedKeys(r io.Reader) (EncodedKeys, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf)%DiagnosisKeySize != 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return EncodedKeys(buf), nil
+}
+
+// Len returns the amount of keys.
+func (ek EncodedKeys) Len() int {
+	return len(ek) / DiagnosisKeySize
+}
+
+// TemporaryExposureKey returns the TemporaryExposureKey of the i-th key.
+func (ek EncodedKeys) TemporaryExposureKey(i int) (key [16]byte) {
+	copy(key[:], ek[i*DiagnosisKeySize:])
+	return key
+}
+
+// RollingStartNumber returns the RollingStartNumber of the i-th key.
+func (ek EncodedKeys) RollingStartNumber(i int) uint32 {
+	return binary.BigEndian.Uint32(ek[i*DiagnosisKeySize+16:])
+}
+
+// TransmissionRiskLevel returns the TransmissionRiskLevel of the i-th key.
+func (ek EncodedKeys) TransmissionRiskLevel(i int) byte {
+	return ek[i*DiagnosisKeySize+20]
+}
+
+// DiagnosisKey returns the i-th key. Its UploadedAt is zero, as it isn't part
+// of the binary representation.
+func (ek EncodedKeys) DiagnosisKey(i int) DiagnosisKey {
+	return DiagnosisKey{
+		TemporaryExposureKey:  ek.TemporaryExposureKey(i),
+		RollingStartNumber:    ek.RollingStartNumber(i),
+		TransmissionRiskLevel: ek.TransmissionRiskLevel(i),
+	}
+}
+
+// sortByKey sorts the keys of ek in place by TemporaryExposureKey.
+func (ek EncodedKeys) sortByKey() {
+	sort.Sort(byTEK(ek))
+}
+
+// byTEK implements sort.Interface, swapping keys in their binary
+// representation.
+type byTEK EncodedKeys
+
+func (b byTEK) Len() int {
+	return len(b) / DiagnosisKeySize
+}
+
+func (b byTEK) Less(i, j int) bool {
+	return bytes.Compare(b[i*DiagnosisKeySize:i*DiagnosisKeySize+16], b[j*DiagnosisKeySize:j*DiagnosisKeySize+16]) < 0
+}
+
+func (b byTEK) Swap(i, j int) {
+	var tmp [DiagnosisKeySize]byte
+	ki, kj := b[i*DiagnosisKeySize:(i+1)*DiagnosisKeySize], b[j*DiagnosisKeySize:(j+1)*DiagnosisKeySize]
+	copy(tmp[:], ki)
+	copy(ki, kj)
+	copy(kj, tmp[:])
+}
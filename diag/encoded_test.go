@@ -0,0 +1,46 @@
+package diag
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestEncodedKeys(t *testing.T) {
+	diagKeys := []DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{9}, RollingStartNumber: 42, TransmissionRiskLevel: 1},
+		{TemporaryExposureKey: [16]byte{3}, RollingStartNumber: 43, TransmissionRiskLevel: 2},
+		{TemporaryExposureKey: [16]byte{5}, RollingStartNumber: 1 << 31, TransmissionRiskLevel: 3},
+	}
+	buf := &bytes.Buffer{}
+	for _, diagKey := range diagKeys {
+		var b [DiagnosisKeySize]byte
+		encodeDiagnosisKey(b[:], diagKey)
+		buf.Write(b[:])
+	}
+
+	keys, err := ReadEncodedKeys(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, exp := keys.Len(), len(diagKeys); got != exp {
+		t.Fatalf("expected: %v, got: %v", exp, got)
+	}
+	for i, exp := range diagKeys {
+		if got := keys.DiagnosisKey(i); !reflect.DeepEqual(got, exp) {
+			t.Errorf("key %v: expected: %+v, got: %+v", i, exp, got)
+		}
+	}
+
+	keys.sortByKey()
+	for i, exp := range []DiagnosisKey{diagKeys[1], diagKeys[2], diagKeys[0]} {
+		if got := keys.DiagnosisKey(i); !reflect.DeepEqual(got, exp) {
+			t.Errorf("sorted key %v: expected: %+v, got: %+v", i, exp, got)
+		}
+	}
+
+	if _, err := ReadEncodedKeys(bytes.NewReader(make([]byte, DiagnosisKeySize+1))); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected: %v, got: %v", io.ErrUnexpectedEOF, err)
+	}
+}
@@ -0,0 +1,61 @@
+package diag
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LogEntry represents a recorded log entry. Field values are redacted (see
+// Redact) and formatted.
+type LogEntry struct {
+	Time    time.Time
+	Message string
+	Fields  map[string]string
+}
+
+// ErrorLog is a Logger that records the most recent Error entries, e.g. for an
+// operator status page, and passes all entries on to another Logger. It's safe
+// for concurrent use.
+type ErrorLog struct {
+	Logger
+
+	mu      sync.Mutex
+	entries []LogEntry
+	size    int
+}
+
+// NewErrorLog returns an ErrorLog recording the last size Error entries, and
+// passing all entries on to l.
+func NewErrorLog(l Logger, size int) *ErrorLog {
+	return &ErrorLog{Logger: l, size: size}
+}
+
+// Error records the entry, and passes it on.
+func (el *ErrorLog) Error(msg string, fields ...Field) {
+	entry := LogEntry{Time: time.Now().UTC(), Message: msg, Fields: make(map[string]string, len(fields))}
+	for _, f := range fields {
+		entry.Fields[f.Key] = fmt.Sprint(Redact(f.Value))
+	}
+
+	el.mu.Lock()
+	el.entries = append(el.entries, entry)
+	if len(el.entries) > el.size {
+		el.entries = el.entries[len(el.entries)-el.size:]
+	}
+	el.mu.Unlock()
+
+	el.Logger.Error(msg, fields...)
+}
+
+// Entries returns the recorded entries, most recent first.
+func (el *ErrorLog) Entries() []LogEntry {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	entries := make([]LogEntry, len(el.entries))
+	for i, entry := range el.entries {
+		entries[len(entries)-1-i] = entry
+	}
+	return entries
+}
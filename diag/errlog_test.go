@@ -0,0 +1,28 @@
+package diag
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestErrorLog(t *testing.T) {
+	el := NewErrorLog(NewNopLogger(), 2)
+
+	el.Info("Not recorded.")
+	el.Error("First.")
+	el.Error("Second.", F("key", [16]byte{1}))
+	el.Error("Third.", F("count", 3))
+
+	entries := el.Entries()
+	var msgs []string
+	for _, entry := range entries {
+		msgs = append(msgs, entry.Message)
+	}
+	if exp := []string{"Third.", "Second."}; !reflect.DeepEqual(msgs, exp) {
+		t.Errorf("expected: %v, got: %v", exp, msgs)
+	}
+
+	if got := entries[1].Fields["key"]; got != redacted {
+		t.Errorf("expected: %v, got: %v", redacted, got)
+	}
+}
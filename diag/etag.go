@@ -0,0 +1,70 @@
+package diag
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+)
+
+// contentDigest is the SHA-256 digest of the cached Diagnosis Keys. It's safe
+// for concurrent use.
+type contentDigest struct {
+	mu  sync.RWMutex
+	sum [sha256.Size]byte
+}
+
+func (cd *contentDigest) get() [sha256.Size]byte {
+	cd.mu.RLock()
+	defer cd.mu.RUnlock()
+	return cd.sum
+}
+
+func (cd *contentDigest) set(sum [sha256.Size]byte) {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	cd.sum = sum
+}
+
+// sumDiagnosisKeys returns the digest of diagKeys: their binary representation,
+// and the metadata that isn't part of it (regions, report type, symptom onset
+// and rolling period). Listings of regions and export files change when only
+// metadata does, e.g. when regions are merged into a stored key, or when the
+// cache is refreshed after hydrating from a snapshot, which has no regions.
+func sumDiagnosisKeys(diagKeys []DiagnosisKey) (sum [sha256.Size]byte) {
+	h := sha256.New()
+	var buf [DiagnosisKeySize + 7]byte
+	for _, diagKey := range diagKeys {
+		encodeDiagnosisKey(buf[:], diagKey)
+		buf[21] = byte(diagKey.ReportType)
+		buf[22] = diagKey.RollingPeriod
+		buf[23] = 0
+		binary.BigEndian.PutUint32(buf[24:], 0)
+		if days := diagKey.DaysSinceOnsetOfSymptoms; days != nil {
+			buf[23] = 1
+			binary.BigEndian.PutUint32(buf[24:], uint32(*days))
+		}
+		h.Write(buf[:])
+		// Regions are prefixed with their length, so the encoding of the
+		// keys is unambiguous.
+		for _, region := range diagKey.Regions {
+			h.Write([]byte{byte(len(region))})
+			h.Write([]byte(region))
+		}
+		h.Write([]byte{0})
+	}
+	h.Sum(sum[:0])
+	return sum
+}
+
+// ETag returns a strong entity tag (including quotes) of a listing of the
+// cached Diagnosis Keys, e.g. for the `after` key passed to ReadSeeker. The
+// variant identifies the listing. The tag changes whenever the cached keys do.
+func (s Service) ETag(variant string) string {
+	sum := s.digest.get()
+	h := sha256.New()
+	h.Write(sum[:])
+	h.Write([]byte(variant))
+
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
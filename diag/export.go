@@ -0,0 +1,340 @@
+package diag
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// exportHeader is the header of `export.bin`, padded to 16 bytes.
+const exportHeader = "EK Export v1    "
+
+// exportKeySize is the maximum size of a TemporaryExposureKey message field:
+// tag and length (2 bytes), key (18 bytes), transmission risk level (up to 3
+// bytes), rolling start number (up to 6 bytes), rolling period (up to 3 bytes),
+// report type (2 bytes) and days since onset of symptoms (2 bytes).
+const exportKeySize = 2 + 18 + 3 + 6 + 3 + 2 + 2
+
+// exportSignatureAlgorithm is the OID of ECDSA with SHA-256.
+const exportSignatureAlgorithm = "1.2.840.10045.4.3.2"
+
+// ErrExportUnsupported is used when exports are disabled, because no export
+// configuration or signer is configured.
+var ErrExportUnsupported = errors.New("diag: export is not supported")
+
+// ExportConfig represents the metadata of Exposure Notification export files.
+type ExportConfig struct {
+	// Region of the keys, e.g. the MCC of a country (`204`).
+	Region string
+	// VerificationKeyID and VerificationKeyVersion identify the public key to
+	// verify export signatures with, as registered with Apple and Google.
+	// VerificationKeyID defaults to Region.
+	VerificationKeyID      string
+	VerificationKeyVersion string
+}
+
+// exportCache holds the export files of the current cache, so they're only
+// generated and signed once per cache refresh.
+type exportCache struct {
+	mu         sync.Mutex
+	hydratedAt time.Time
+	files      map[[2]int64][]byte
+}
+
+// Export returns a signed Exposure Notification export file (a ZIP archive
+// with `export.bin` and `export.sig`) of the Diagnosis Keys published between
+// start and end, in the format expected by Apple and Google clients. Each
+// time range is exported as a single batch.
+func (s Service) Export(start, end time.Time) ([]byte, error) {
+	if s.exports == nil {
+		return nil, ErrExportUnsupported
+	}
+
+	if ec, ok := s.cache.(ExportCache); ok {
+		if file, ok := ec.Export(start, end); ok {
+			return file, nil
+		}
+	}
+
+	hydratedAt := s.hydratedAt.get()
+	key := [2]int64{start.Unix(), end.Unix()}
+
+	s.exports.mu.Lock()
+	defer s.exports.mu.Unlock()
+
+	if !s.exports.hydratedAt.Equal(hydratedAt) {
+		s.exports.hydratedAt = hydratedAt
+		s.exports.files = make(map[[2]int64][]byte)
+	}
+	if file, ok := s.exports.files[key]; ok {
+		return file, nil
+	}
+
+	// Keys are exported in their binary representation, without decoding
+	// them.
+	keys, err := ReadEncodedKeys(s.ReadSeekerBetween(start, end))
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := s.writeExport(keys, start, end)
+	if err != nil {
+		return nil, err
+	}
+	s.exports.files[key] = file
+
+	return file, nil
+}
+
+// cacheExports generates the export files of all published batches (see
+// Batches), and stores them in the cache, if it implements ExportCache. It's
+// called by the hydration worker, so requests are served from pre-serialized
+// files. Hourly batches are included if the cache is refreshed hourly.
+func (s Service) cacheExports() error {
+	ec, ok := s.cache.(ExportCache)
+	if !ok || s.exports == nil {
+		return nil
+	}
+
+	batches, err := s.Batches(s.cacheAlignment == time.Hour)
+	if err != nil {
+		return err
+	}
+	files := make(map[[2]int64][]byte, len(batches))
+	for _, batch := range batches {
+		keys, err := ReadEncodedKeys(s.ReadSeekerBetween(batch.Start, batch.End))
+		if err != nil {
+			return err
+		}
+		file, err := s.writeExport(keys, batch.Start, batch.End)
+		if err != nil {
+			return err
+		}
+		files[[2]int64{batch.Start.Unix(), batch.End.Unix()}] = file
+	}
+	ec.SetExports(files)
+
+	return nil
+}
+
+// ExportMeta represents the metadata of an Exposure Notification export file
+// (see WriteDiagnosisKeyExport).
+type ExportMeta struct {
+	// StartTime and EndTime are the time range of the keys in the export.
+	StartTime time.Time
+	EndTime   time.Time
+	// Region of the keys, e.g. the MCC of a country (`204`).
+	Region string
+	// BatchNum is the (1-based) number of the export within its batch of
+	// BatchSize exports, for time ranges that are split over multiple files.
+	BatchNum  int
+	BatchSize int
+	// Signatures lists the signatures of the export; at least one is
+	// required.
+	Signatures []ExportSignature
+}
+
+// ExportSignature represents a signature of an export file, and the
+// SignatureInfo clients use to pick the public key to verify it with.
+type ExportSignature struct {
+	// VerificationKeyID and VerificationKeyVersion identify the public key
+	// of Signer, as registered with Apple and Google.
+	VerificationKeyID      string
+	VerificationKeyVersion string
+	// Signer signs the export, with ECDSA and SHA-256.
+	Signer crypto.Signer
+}
+
+// writeExport returns the ZIP archive of an export of keys, as a single batch
+// signed with the service's signer. Keys are sorted in place.
+func (s Service) writeExport(keys EncodedKeys, start, end time.Time) ([]byte, error) {
+	// Keys are sorted, so their order doesn't reveal the order of uploads.
+	keys.sortByKey()
+
+	meta := ExportMeta{
+		StartTime: start,
+		EndTime:   end,
+		Region:    s.exportCfg.Region,
+		BatchNum:  1,
+		BatchSize: 1,
+		Signatures: []ExportSignature{{
+			VerificationKeyID:      s.exportCfg.VerificationKeyID,
+			VerificationKeyVersion: s.exportCfg.VerificationKeyVersion,
+			Signer:                 s.signer,
+		}},
+	}
+
+	reports, _ := s.cache.(ReportCache)
+	rollingPeriods, _ := s.cache.(RollingPeriodCache)
+
+	buf := &bytes.Buffer{}
+	if err := writeEncodedExport(buf, meta, keys, reports, rollingPeriods); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// WriteDiagnosisKeyExport writes an Exposure Notification export file (a ZIP
+// archive with `export.bin` and `export.sig`) of diagKeys to w, in the format
+// expected by Apple and Google clients. Keys are written in the given order,
+// so callers should sort them (e.g. by key) to not reveal the order of
+// uploads.
+func WriteDiagnosisKeyExport(w io.Writer, meta ExportMeta, diagKeys ...DiagnosisKey) error {
+	keys := make(EncodedKeys, len(diagKeys)*DiagnosisKeySize)
+	for i, diagKey := range diagKeys {
+		encodeDiagnosisKey(keys[i*DiagnosisKeySize:], diagKey)
+	}
+	return writeEncodedExport(w, meta, keys, newReportMap(diagKeys), newRollingPeriodMap(diagKeys))
+}
+
+// writeEncodedExport writes an export file of keys to w, see
+// WriteDiagnosisKeyExport. The report types and days since onset of symptoms
+// of keys are looked up in reports, and their rolling periods in
+// rollingPeriods, if set.
+func writeEncodedExport(w io.Writer, meta ExportMeta, keys EncodedKeys, reports ReportCache, rollingPeriods RollingPeriodCache) error {
+	switch {
+	case len(meta.Signatures) == 0:
+		return errors.New("diag: export requires at least one signature")
+	case meta.BatchNum < 1 || meta.BatchSize < meta.BatchNum:
+		return fmt.Errorf("diag: invalid export batch number %d of %d", meta.BatchNum, meta.BatchSize)
+	}
+
+	sigInfos := make([][]byte, len(meta.Signatures))
+	sigInfosSize := 0
+	for i, sig := range meta.Signatures {
+		sigInfos[i] = exportSignatureInfo(sig)
+		sigInfosSize += len(sigInfos[i]) + 2
+	}
+
+	// TemporaryExposureKeyExport message, allocated once: the header and
+	// metadata fields take less than 64 bytes besides the signature infos
+	// and the region.
+	bin := make([]byte, 0, len(exportHeader)+64+sigInfosSize+len(meta.Region)+keys.Len()*exportKeySize)
+	bin = append(bin, exportHeader...)
+	bin = appendFixed64Field(bin, 1, uint64(meta.StartTime.Unix()))
+	bin = appendFixed64Field(bin, 2, uint64(meta.EndTime.Unix()))
+	bin = appendBytesField(bin, 3, []byte(meta.Region))
+	bin = appendVarintField(bin, 4, uint64(meta.BatchNum))
+	bin = appendVarintField(bin, 5, uint64(meta.BatchSize))
+	for _, sigInfo := range sigInfos {
+		bin = appendBytesField(bin, 6, sigInfo)
+	}
+	key := make([]byte, 0, exportKeySize)
+	for i := 0; i < keys.Len(); i++ {
+		// TemporaryExposureKey message, encoded in a reused buffer.
+		key = appendExportKey(key[:0], keys, i, reports, rollingPeriods)
+		bin = appendBytesField(bin, 7, key)
+	}
+
+	// TEKSignatureList message, with a TEKSignature per signature.
+	digest := sha256.Sum256(bin)
+	var sig []byte
+	for i, s := range meta.Signatures {
+		signature, err := s.Signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			return err
+		}
+		var tekSig []byte
+		tekSig = appendBytesField(tekSig, 1, sigInfos[i])
+		tekSig = appendVarintField(tekSig, 2, uint64(meta.BatchNum))
+		tekSig = appendVarintField(tekSig, 3, uint64(meta.BatchSize))
+		tekSig = appendBytesField(tekSig, 4, signature)
+		sig = appendBytesField(sig, 1, tekSig)
+	}
+
+	zw := zip.NewWriter(w)
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{
+		{"export.bin", bin},
+		{"export.sig", sig},
+	} {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: meta.EndTime})
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// appendExportKey appends the TemporaryExposureKey message of the i-th key of
+// keys to b. Its report and rolling period are looked up in reports and
+// rollingPeriods, if set.
+func appendExportKey(b []byte, keys EncodedKeys, i int, reports ReportCache, rollingPeriods RollingPeriodCache) []byte {
+	b = appendBytesField(b, 1, keys[i*DiagnosisKeySize:i*DiagnosisKeySize+16])
+	b = appendVarintField(b, 2, uint64(keys.TransmissionRiskLevel(i)))
+	b = appendVarintField(b, 3, uint64(keys.RollingStartNumber(i)))
+	// Without rolling period, clients assume MaxRollingPeriod.
+	if rollingPeriods != nil {
+		if rollingPeriod := rollingPeriods.RollingPeriod(keys.TemporaryExposureKey(i)); rollingPeriod != 0 {
+			b = appendVarintField(b, 4, uint64(rollingPeriod))
+		}
+	}
+	if reports != nil {
+		reportType, days, hasDays := reports.Report(keys.TemporaryExposureKey(i))
+		if reportType != ReportTypeUnknown {
+			b = appendVarintField(b, 5, uint64(reportType))
+		}
+		if hasDays {
+			// `sint32`, zigzag encoded.
+			b = appendVarintField(b, 6, uint64(uint32(days<<1)^uint32(days>>31)))
+		}
+	}
+	return b
+}
+
+// exportSignatureInfo returns the SignatureInfo message of sig.
+func exportSignatureInfo(sig ExportSignature) []byte {
+	var info []byte
+	info = appendBytesField(info, 3, []byte(sig.VerificationKeyVersion))
+	info = appendBytesField(info, 4, []byte(sig.VerificationKeyID))
+	info = appendBytesField(info, 5, []byte(exportSignatureAlgorithm))
+	return info
+}
+
+// Protocol Buffers wire format encoding, for the few message types of export
+// files.
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+func appendVarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendVarint(b, uint64(field<<3|wireVarint))
+	return appendVarint(b, v)
+}
+
+func appendFixed64Field(b []byte, field int, v uint64) []byte {
+	b = appendVarint(b, uint64(field<<3|wireFixed64))
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	b = appendVarint(b, uint64(field<<3|wireBytes))
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
@@ -0,0 +1,284 @@
+package diag
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// pbField is a decoded Protocol Buffers field.
+type pbField struct {
+	num    int
+	varint uint64
+	bytes  []byte
+}
+
+// decodePB decodes the fields of a Protocol Buffers message.
+func decodePB(t *testing.T, b []byte) []pbField {
+	t.Helper()
+	var fields []pbField
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatal("invalid tag")
+		}
+		b = b[n:]
+		f := pbField{num: int(tag >> 3)}
+		switch tag & 7 {
+		case wireVarint:
+			f.varint, n = binary.Uvarint(b)
+			b = b[n:]
+		case wireFixed64:
+			f.varint = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			f.bytes = b[n : n+int(l)]
+			b = b[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type: %v", tag&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// unzipExport returns the files of an export file by name.
+func unzipExport(t *testing.T, file []byte) map[string][]byte {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], err = ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return files
+}
+
+func TestExport(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diagKeys := []DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{9}, RollingStartNumber: 42, TransmissionRiskLevel: 1, UploadedAt: time.Date(2020, time.May, 4, 12, 0, 0, 0, time.UTC)},
+		{TemporaryExposureKey: [16]byte{3}, RollingStartNumber: 43, TransmissionRiskLevel: 2, UploadedAt: time.Date(2020, time.May, 4, 13, 0, 0, 0, time.UTC)},
+		{TemporaryExposureKey: [16]byte{5}, RollingStartNumber: 44, TransmissionRiskLevel: 3, UploadedAt: time.Date(2020, time.May, 5, 12, 0, 0, 0, time.UTC)},
+	}
+	svc, err := NewService(ctx, Config{
+		Repository: snapshotRepo{diagKeys: diagKeys},
+		Logger:     NewNopLogger(),
+		Signer:     key,
+		Export:     &ExportConfig{Region: "204", VerificationKeyID: "204", VerificationKeyVersion: "v1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2020, time.May, 4, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 1)
+	file, err := svc.Export(start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := unzipExport(t, file)
+	bin := files["export.bin"]
+	if got := string(bin[:16]); got != exportHeader {
+		t.Fatalf("expected: %q, got: %q", exportHeader, got)
+	}
+
+	var keys [][]pbField
+	for _, f := range decodePB(t, bin[16:]) {
+		switch f.num {
+		case 1:
+			if exp := uint64(start.Unix()); f.varint != exp {
+				t.Errorf("start timestamp: expected: %v, got: %v", exp, f.varint)
+			}
+		case 2:
+			if exp := uint64(end.Unix()); f.varint != exp {
+				t.Errorf("end timestamp: expected: %v, got: %v", exp, f.varint)
+			}
+		case 3:
+			if got := string(f.bytes); got != "204" {
+				t.Errorf("region: expected: 204, got: %v", got)
+			}
+		case 7:
+			keys = append(keys, decodePB(t, f.bytes))
+		}
+	}
+
+	// Only the keys of the time range are exported, sorted by key.
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got: %v", len(keys))
+	}
+	for i, exp := range []DiagnosisKey{diagKeys[1], diagKeys[0]} {
+		fields := keys[i]
+		if !bytes.Equal(fields[0].bytes, exp.TemporaryExposureKey[:]) {
+			t.Errorf("key %v: expected: %x, got: %x", i, exp.TemporaryExposureKey, fields[0].bytes)
+		}
+		if fields[1].varint != uint64(exp.TransmissionRiskLevel) || fields[2].varint != uint64(exp.RollingStartNumber) {
+			t.Errorf("key %v: unexpected fields: %+v", i, fields)
+		}
+	}
+
+	// The signature in `export.sig` is valid for `export.bin`.
+	sigList := decodePB(t, files["export.sig"])
+	if len(sigList) != 1 {
+		t.Fatalf("expected 1 signature, got: %v", len(sigList))
+	}
+	var signature []byte
+	for _, f := range decodePB(t, sigList[0].bytes) {
+		if f.num == 4 {
+			signature = f.bytes
+		}
+	}
+	digest := sha256.Sum256(bin)
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature) {
+		t.Error("invalid signature")
+	}
+
+	// Exports are generated once per cache refresh.
+	again, err := svc.Export(start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, file) {
+		t.Error("expected cached export")
+	}
+}
+
+func TestExportCachedOnHydration(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	end := time.Now().UTC().Truncate(24 * time.Hour)
+	start := end.AddDate(0, 0, -1)
+	mc := &MemoryCache{}
+	svc, err := NewService(ctx, Config{
+		Repository: snapshotRepo{diagKeys: []DiagnosisKey{{TemporaryExposureKey: [16]byte{1}, UploadedAt: start.Add(time.Hour)}}},
+		Cache:      mc,
+		Logger:     NewNopLogger(),
+		Signer:     key,
+		Export:     &ExportConfig{Region: "204"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cached, ok := mc.Export(start, end)
+	if !ok {
+		t.Fatal("expected export of yesterday to be cached")
+	}
+	file, err := svc.Export(start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(file, cached) {
+		t.Error("expected cached export to be served")
+	}
+}
+
+func TestExportUnsupported(t *testing.T) {
+	svc, err := NewService(context.Background(), Config{Repository: snapshotRepo{}, Logger: NewNopLogger()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Export(time.Now(), time.Now()); err != ErrExportUnsupported {
+		t.Errorf("expected: %v, got: %v", ErrExportUnsupported, err)
+	}
+}
+
+func TestWriteDiagnosisKeyExport(t *testing.T) {
+	var signers []*ecdsa.PrivateKey
+	for i := 0; i < 2; i++ {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers = append(signers, key)
+	}
+	meta := ExportMeta{
+		StartTime: time.Date(2020, time.May, 4, 0, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2020, time.May, 5, 0, 0, 0, 0, time.UTC),
+		Region:    "204",
+		BatchNum:  2,
+		BatchSize: 3,
+		Signatures: []ExportSignature{
+			{VerificationKeyID: "204", VerificationKeyVersion: "v1", Signer: signers[0]},
+			{VerificationKeyID: "204", VerificationKeyVersion: "v2", Signer: signers[1]},
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteDiagnosisKeyExport(buf, meta, DiagnosisKey{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: 42}); err != nil {
+		t.Fatal(err)
+	}
+	files := unzipExport(t, buf.Bytes())
+	bin := files["export.bin"]
+
+	var batch []uint64
+	var sigInfos int
+	for _, f := range decodePB(t, bin[16:]) {
+		switch f.num {
+		case 4, 5:
+			batch = append(batch, f.varint)
+		case 6:
+			sigInfos++
+		}
+	}
+	if len(batch) != 2 || batch[0] != 2 || batch[1] != 3 {
+		t.Errorf("expected batch 2 of 3, got: %v", batch)
+	}
+	if sigInfos != 2 {
+		t.Errorf("expected 2 signature infos, got: %v", sigInfos)
+	}
+
+	// Every signature is valid for `export.bin`.
+	digest := sha256.Sum256(bin)
+	sigList := decodePB(t, files["export.sig"])
+	if len(sigList) != 2 {
+		t.Fatalf("expected 2 signatures, got: %v", len(sigList))
+	}
+	for i, tekSig := range sigList {
+		for _, f := range decodePB(t, tekSig.bytes) {
+			if f.num == 4 && !ecdsa.VerifyASN1(&signers[i].PublicKey, digest[:], f.bytes) {
+				t.Errorf("signature %v: invalid signature", i)
+			}
+		}
+	}
+
+	meta.BatchNum = 4
+	if err := WriteDiagnosisKeyExport(ioutil.Discard, meta); err == nil {
+		t.Error("expected error for invalid batch number")
+	}
+	meta.BatchNum, meta.Signatures = 1, nil
+	if err := WriteDiagnosisKeyExport(ioutil.Discard, meta); err == nil {
+		t.Error("expected error without signatures")
+	}
+}
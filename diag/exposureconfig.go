@@ -0,0 +1,214 @@
+package diag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxAppVersionLength is the maximum length of app versions, see
+// ExposureConfigScope.
+const maxAppVersionLength = 32
+
+// ErrExposureConfigStoreUnsupported is used when the repository doesn't
+// implement ExposureConfigRepository.
+var ErrExposureConfigStoreUnsupported = errors.New("diag: storing exposure configurations is not supported")
+
+// ExposureConfigRepository is implemented by repositories that store versions
+// of the exposure configuration, so it can be changed without redeploys.
+type ExposureConfigRepository interface {
+	// StoreExposureConfig stores a version. Versions are never updated, so
+	// past risk scores remain reproducible.
+	StoreExposureConfig(ctx context.Context, version ExposureConfigVersion) error
+	// FindExposureConfigs returns all stored versions, ordered by
+	// EffectiveFrom.
+	FindExposureConfigs(ctx context.Context) (ExposureConfigHistory, error)
+}
+
+// ExposureConfigVersion is a version of the exposure configuration, which is
+// effective from EffectiveFrom until the next version of its scope is.
+type ExposureConfigVersion struct {
+	ID            string    `json:"id"`
+	EffectiveFrom time.Time `json:"effectiveFrom"`
+	ExposureConfigScope
+	Config ExposureConfig `json:"config"`
+}
+
+// ExposureConfigScope scopes versions of the exposure configuration to the
+// apps of a region and app version, as they may need different risk
+// parameters. The zero value is the default scope, of all apps.
+type ExposureConfigScope struct {
+	// Region is a region (see ParseRegions), e.g. `NL`, or empty for all
+	// regions.
+	Region string `json:"region,omitempty"`
+	// AppVersion is the minimum app version, in dot separated numbers, e.g.
+	// `1.2`, or empty for all app versions.
+	AppVersion string `json:"appVersion,omitempty"`
+}
+
+// Validate returns an error if the region or app version of the scope is
+// invalid. Regions must be in upper case.
+func (s ExposureConfigScope) Validate() error {
+	if s.Region != "" && !validRegion(s.Region) {
+		return fmt.Errorf("diag: invalid region %q", s.Region)
+	}
+	if s.AppVersion != "" && !validAppVersion(s.AppVersion) {
+		return fmt.Errorf("diag: invalid app version %q, must be dot separated numbers, e.g. `1.2`", s.AppVersion)
+	}
+	return nil
+}
+
+// Matches returns true if the scope applies to apps of the given region and
+// app version; empty if unknown.
+func (s ExposureConfigScope) Matches(region, appVersion string) bool {
+	if s.Region != "" && s.Region != region {
+		return false
+	}
+	if s.AppVersion != "" && (appVersion == "" || CompareAppVersions(appVersion, s.AppVersion) < 0) {
+		return false
+	}
+	return true
+}
+
+// specificity compares the scope with o: regional scopes are more specific
+// than others, and then scopes with a higher minimum app version.
+func (s ExposureConfigScope) specificity(o ExposureConfigScope) int {
+	switch {
+	case s.Region != "" && o.Region == "":
+		return 1
+	case s.Region == "" && o.Region != "":
+		return -1
+	}
+	return CompareAppVersions(s.AppVersion, o.AppVersion)
+}
+
+// ExposureConfigHistory lists the versions of the exposure configuration,
+// ordered by EffectiveFrom, so risk scores can be reproduced with the version
+// that was effective at the time.
+type ExposureConfigHistory []ExposureConfigVersion
+
+// Effective returns the version of the default scope that is effective at t,
+// i.e. the last one effective from at or before t. If no version is effective
+// at t, false is returned.
+func (h ExposureConfigHistory) Effective(t time.Time) (ExposureConfigVersion, bool) {
+	return h.EffectiveFor(t, "", "")
+}
+
+// EffectiveFor returns the version that is effective at t for apps of the
+// given region and app version (empty if unknown): the version effective at t
+// of the most specific scope that matches, and has a version effective at t.
+// Versions of the default scope match all apps. If no version is effective at
+// t, false is returned.
+func (h ExposureConfigHistory) EffectiveFor(t time.Time, region, appVersion string) (ExposureConfigVersion, bool) {
+	var effective ExposureConfigVersion
+	var ok bool
+	for _, version := range h {
+		if version.EffectiveFrom.After(t) {
+			break
+		}
+		if !version.Matches(region, appVersion) {
+			continue
+		}
+		// Versions are ordered by effective time, so later versions of a
+		// scope replace earlier ones.
+		if !ok || version.specificity(effective.ExposureConfigScope) >= 0 {
+			effective, ok = version, true
+		}
+	}
+	return effective, ok
+}
+
+// CompareAppVersions compares app versions of dot separated numbers, and
+// returns -1 if a is lower than b, 1 if it's higher, and 0 if they're equal.
+// Missing numbers count as 0, e.g. `1.2` equals `1.2.0`; the empty version is
+// lower than all others.
+func CompareAppVersions(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return -1
+	case b == "":
+		return 1
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+func validAppVersion(v string) bool {
+	if v == "" || len(v) > maxAppVersionLength {
+		return false
+	}
+	for _, part := range strings.Split(v, ".") {
+		if part == "" {
+			return false
+		}
+		for _, r := range part {
+			if r < '0' || r > '9' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// StoredExposureConfigs returns the versions of the exposure configuration
+// stored in the repository, ordered by EffectiveFrom.
+func (s Service) StoredExposureConfigs(ctx context.Context) (ExposureConfigHistory, error) {
+	store, ok := s.repo.(ExposureConfigRepository)
+	if !ok {
+		return nil, ErrExposureConfigStoreUnsupported
+	}
+	return store.FindExposureConfigs(ctx)
+}
+
+// StoreExposureConfig stores cfg as a new version of the exposure
+// configuration of scope, effective from now, and returns it. The version is
+// identified by its effective time (RFC 3339, in milliseconds), prefixed with
+// the region and app version of its scope, e.g. `NL/1.2/{time}`.
+func (s Service) StoreExposureConfig(ctx context.Context, scope ExposureConfigScope, cfg ExposureConfig) (ExposureConfigVersion, error) {
+	store, ok := s.repo.(ExposureConfigRepository)
+	if !ok {
+		return ExposureConfigVersion{}, ErrExposureConfigStoreUnsupported
+	}
+	if err := scope.Validate(); err != nil {
+		return ExposureConfigVersion{}, err
+	}
+
+	effectiveFrom := time.Now().UTC().Truncate(time.Millisecond)
+	var id []string
+	for _, part := range []string{scope.Region, scope.AppVersion, effectiveFrom.Format(time.RFC3339Nano)} {
+		if part != "" {
+			id = append(id, part)
+		}
+	}
+	version := ExposureConfigVersion{
+		ID:                  strings.Join(id, "/"),
+		EffectiveFrom:       effectiveFrom,
+		ExposureConfigScope: scope,
+		Config:              cfg,
+	}
+	if err := store.StoreExposureConfig(ctx, version); err != nil {
+		return ExposureConfigVersion{}, err
+	}
+
+	return version, nil
+}
@@ -0,0 +1,80 @@
+package diag
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompareAppVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		exp  int
+	}{
+		{"1.2", "1.2", 0},
+		{"1.2", "1.2.0", 0},
+		{"1.2", "1.10", -1},
+		{"2", "1.10.3", 1},
+		{"", "0", -1},
+		{"0", "", 1},
+	}
+	for _, tt := range tests {
+		if got := CompareAppVersions(tt.a, tt.b); got != tt.exp {
+			t.Errorf("%q, %q: expected: %v, got: %v", tt.a, tt.b, tt.exp, got)
+		}
+	}
+}
+
+func TestExposureConfigScopeValidate(t *testing.T) {
+	for _, scope := range []ExposureConfigScope{{}, {Region: "NL"}, {AppVersion: "1"}, {Region: "204", AppVersion: "1.10.3"}} {
+		if err := scope.Validate(); err != nil {
+			t.Errorf("%+v: unexpected error: %v", scope, err)
+		}
+	}
+	for _, scope := range []ExposureConfigScope{{Region: "nl"}, {AppVersion: "1."}, {AppVersion: "v1"}, {AppVersion: "1.2-beta"}} {
+		if err := scope.Validate(); err == nil {
+			t.Errorf("%+v: expected error", scope)
+		}
+	}
+}
+
+func TestExposureConfigHistoryEffectiveFor(t *testing.T) {
+	t0 := time.Date(2020, time.May, 4, 0, 0, 0, 0, time.UTC)
+	history := ExposureConfigHistory{
+		{ID: "default", EffectiveFrom: t0},
+		{ID: "1.2", EffectiveFrom: t0.Add(time.Hour), ExposureConfigScope: ExposureConfigScope{AppVersion: "1.2"}},
+		{ID: "NL", EffectiveFrom: t0.Add(2 * time.Hour), ExposureConfigScope: ExposureConfigScope{Region: "NL"}},
+		{ID: "NL/2", EffectiveFrom: t0.Add(3 * time.Hour), ExposureConfigScope: ExposureConfigScope{Region: "NL", AppVersion: "2"}},
+		{ID: "default2", EffectiveFrom: t0.Add(4 * time.Hour)},
+	}
+
+	tests := []struct {
+		t          time.Time
+		region     string
+		appVersion string
+		exp        string
+	}{
+		{t0, "NL", "2.0", "default"},
+		{t0.Add(time.Hour), "", "1.1", "default"},
+		{t0.Add(time.Hour), "", "1.10", "1.2"},
+		{t0.Add(time.Hour), "", "", "default"},
+		{t0.Add(2 * time.Hour), "NL", "1.2", "NL"},
+		{t0.Add(2 * time.Hour), "BE", "1.2", "1.2"},
+		{t0.Add(3 * time.Hour), "NL", "1.9", "NL"},
+		{t0.Add(3 * time.Hour), "NL", "2", "NL/2"},
+		{t0.Add(4 * time.Hour), "NL", "2", "NL/2"},
+		{t0.Add(4 * time.Hour), "BE", "1.0", "default2"},
+	}
+	for _, tt := range tests {
+		got, ok := history.EffectiveFor(tt.t, tt.region, tt.appVersion)
+		if !ok || got.ID != tt.exp {
+			t.Errorf("%v, %q, %q: expected: %v, got: %v", tt.t.Format(time.Kitchen), tt.region, tt.appVersion, tt.exp, got.ID)
+		}
+	}
+
+	if _, ok := history.EffectiveFor(t0.Add(-time.Second), "", ""); ok {
+		t.Error("expected no effective version")
+	}
+	if got, _ := history.Effective(t0.Add(3 * time.Hour)); got.ID != "default" {
+		t.Errorf("expected: default, got: %v", got.ID)
+	}
+}
@@ -0,0 +1,82 @@
+package diag
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// RawDiagnosisKey is a Diagnosis Key as stored in the repository, before it's
+// decoded into a DiagnosisKey, so malformed rows can be inspected.
+type RawDiagnosisKey struct {
+	TemporaryExposureKey []byte
+	RollingStartNumber   int64
+	// TransmissionRiskLevel is nil if the stored value doesn't fit in a
+	// byte, for repositories that store it as an integer.
+	TransmissionRiskLevel []byte
+	UploadedAt            time.Time
+}
+
+// Violation is a stored Diagnosis Key violating an invariant, see Fsck.
+type Violation struct {
+	Key    RawDiagnosisKey
+	Reason string
+}
+
+// IntegrityChecker is implemented by repositories that can be scanned for
+// invariant violations, e.g. after imports or manual database surgery.
+type IntegrityChecker interface {
+	// ScanDiagnosisKeys calls fn for every stored Diagnosis Key, ordered by
+	// upload. If fn returns an error, the scan is aborted.
+	ScanDiagnosisKeys(ctx context.Context, fn func(RawDiagnosisKey) error) error
+	// QuarantineDiagnosisKeys moves the keys of violations out of the
+	// Diagnosis Keys, along with their reason, and returns the amount of
+	// moved keys.
+	QuarantineDiagnosisKeys(ctx context.Context, violations []Violation, quarantinedAt time.Time) (int64, error)
+}
+
+// CheckDiagnosisKey returns the reason why rawKey violates an invariant of
+// stored Diagnosis Keys, or an empty string if it doesn't. Timestamps more
+// than skew after now are considered to be in the future.
+func CheckDiagnosisKey(rawKey RawDiagnosisKey, now time.Time, skew time.Duration) string {
+	latest := now.Add(skew)
+	switch {
+	case len(rawKey.TemporaryExposureKey) != 16:
+		return fmt.Sprintf("temporary exposure key has %d bytes, expected 16", len(rawKey.TemporaryExposureKey))
+	case len(rawKey.TransmissionRiskLevel) != 1:
+		return "transmission risk level is malformed"
+	case rawKey.RollingStartNumber == 0:
+		return "rolling start number is zero"
+	case rawKey.RollingStartNumber < 0 || rawKey.RollingStartNumber > math.MaxUint32:
+		return fmt.Sprintf("rolling start number %d is out of range", rawKey.RollingStartNumber)
+	case rawKey.UploadedAt.IsZero():
+		return "upload time is zero"
+	case rawKey.UploadedAt.After(latest):
+		return fmt.Sprintf("upload time %v is in the future", rawKey.UploadedAt.UTC().Format(time.RFC3339))
+	}
+
+	start := rollingStartTime(DiagnosisKey{RollingStartNumber: uint32(rawKey.RollingStartNumber)})
+	if start.After(latest) {
+		return fmt.Sprintf("rolling start number %d (%v) is in the future", rawKey.RollingStartNumber, start.Format(time.RFC3339))
+	}
+
+	return ""
+}
+
+// Fsck scans the stored Diagnosis Keys of checker for invariant violations
+// (see CheckDiagnosisKey), and returns them in upload order.
+func Fsck(ctx context.Context, checker IntegrityChecker, now time.Time, skew time.Duration) ([]Violation, error) {
+	var violations []Violation
+	err := checker.ScanDiagnosisKeys(ctx, func(rawKey RawDiagnosisKey) error {
+		if reason := CheckDiagnosisKey(rawKey, now, skew); reason != "" {
+			violations = append(violations, Violation{Key: rawKey, Reason: reason})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return violations, nil
+}
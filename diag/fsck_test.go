@@ -0,0 +1,39 @@
+package diag
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckDiagnosisKey(t *testing.T) {
+	now := time.Date(2020, time.May, 4, 12, 0, 0, 0, time.UTC)
+	valid := RawDiagnosisKey{
+		TemporaryExposureKey:  make([]byte, 16),
+		RollingStartNumber:    now.Unix() / 600,
+		TransmissionRiskLevel: []byte{50},
+		UploadedAt:            now,
+	}
+	if reason := CheckDiagnosisKey(valid, now, time.Hour); reason != "" {
+		t.Errorf("expected no violation, got: %v", reason)
+	}
+
+	tests := map[string]func(*RawDiagnosisKey){
+		"short key":           func(k *RawDiagnosisKey) { k.TemporaryExposureKey = make([]byte, 15) },
+		"malformed risk":      func(k *RawDiagnosisKey) { k.TransmissionRiskLevel = nil },
+		"zero rolling start":  func(k *RawDiagnosisKey) { k.RollingStartNumber = 0 },
+		"rolling start range": func(k *RawDiagnosisKey) { k.RollingStartNumber = 1 << 32 },
+		"future rolling start": func(k *RawDiagnosisKey) {
+			k.RollingStartNumber = now.Add(2*time.Hour).Unix() / 600
+		},
+		"future upload": func(k *RawDiagnosisKey) { k.UploadedAt = now.Add(2 * time.Hour) },
+	}
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			rawKey := valid
+			mutate(&rawKey)
+			if reason := CheckDiagnosisKey(rawKey, now, time.Hour); reason == "" {
+				t.Error("expected violation")
+			}
+		})
+	}
+}
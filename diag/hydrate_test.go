@@ -0,0 +1,86 @@
+package diag
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingRepo counts the calls of FindAllDiagnosisKeys, and fails them once
+// fail is set.
+type countingRepo struct {
+	snapshotRepo
+	calls *int32
+	fail  *int32
+}
+
+func (r countingRepo) FindAllDiagnosisKeys(ctx context.Context) ([]DiagnosisKey, error) {
+	atomic.AddInt32(r.calls, 1)
+	if atomic.LoadInt32(r.fail) == 1 {
+		return nil, errors.New("boom")
+	}
+	return r.snapshotRepo.FindAllDiagnosisKeys(ctx)
+}
+
+func TestHydrateIfStale(t *testing.T) {
+	ctx := context.Background()
+	repo := countingRepo{
+		snapshotRepo: snapshotRepo{diagKeys: []DiagnosisKey{{TemporaryExposureKey: [16]byte{1}, UploadedAt: time.Now()}}},
+		calls:        new(int32),
+		fail:         new(int32),
+	}
+
+	t.Run("never hydrated", func(t *testing.T) {
+		svc, err := NewService(ctx, Config{Repository: repo, Logger: NewNopLogger(), RequestDriven: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		atomic.StoreInt32(repo.fail, 1)
+		defer atomic.StoreInt32(repo.fail, 0)
+
+		if err := svc.HydrateIfStale(ctx); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	atomic.StoreInt32(repo.calls, 0)
+	svc, err := NewService(ctx, Config{Repository: repo, Logger: NewNopLogger(), CacheInterval: 50 * time.Millisecond, RequestDriven: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(repo.calls); got != 0 {
+		t.Fatalf("expected no hydration on startup, got: %v", got)
+	}
+	if readiness := svc.Readiness(ctx); !readiness.Ready || readiness.Hydrated {
+		t.Errorf("expected ready and not hydrated, got: %+v", readiness)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := svc.HydrateIfStale(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := atomic.LoadInt32(repo.calls); got != 1 {
+		t.Errorf("expected: 1, got: %v", got)
+	}
+	if n, _ := svc.ReadSeeker([16]byte{}).Seek(0, io.SeekEnd); n != DiagnosisKeySize {
+		t.Errorf("expected: %v, got: %v", DiagnosisKeySize, n)
+	}
+
+	// A failed refresh keeps serving the stale cache.
+	time.Sleep(60 * time.Millisecond)
+	atomic.StoreInt32(repo.fail, 1)
+	if err := svc.HydrateIfStale(ctx); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+	atomic.StoreInt32(repo.fail, 0)
+	if err := svc.HydrateIfStale(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(repo.calls); got != 3 {
+		t.Errorf("expected: 3, got: %v", got)
+	}
+}
@@ -0,0 +1,119 @@
+package diag
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Types of background jobs.
+const (
+	JobRefresh = "refresh"
+	JobPurge   = "purge"
+)
+
+var (
+	// ErrJobHistoryUnsupported is used when the repository doesn't implement
+	// JobRecorder.
+	ErrJobHistoryUnsupported = errors.New("diag: job history is not supported")
+
+	// ErrJobRunNotFound is used when a job run doesn't exist.
+	ErrJobRunNotFound = errors.New("diag: job run not found")
+
+	// ErrJobRunNotFailed is used when retrying a job run that didn't fail.
+	ErrJobRunNotFailed = errors.New("diag: job run didn't fail")
+)
+
+// JobRun is a run of a background job, e.g. a scheduled cache refresh.
+type JobRun struct {
+	ID        int64     `json:"id"`
+	Type      string    `json:"type"`
+	StartedAt time.Time `json:"startedAt"`
+	EndedAt   time.Time `json:"endedAt"`
+	// Error is the error of a failed run, or empty if the run succeeded.
+	Error string `json:"error,omitempty"`
+	// RetryOf is the ID of the failed run that was retried, if any.
+	RetryOf int64 `json:"retryOf,omitempty"`
+}
+
+// JobRecorder is implemented by repositories that support storing the history
+// of background job runs.
+type JobRecorder interface {
+	// StoreJobRun stores run, and returns its ID.
+	StoreJobRun(ctx context.Context, run JobRun) (int64, error)
+	// FindJobRuns returns the latest job runs, newest first. If failedOnly is
+	// true, only failed runs are returned.
+	FindJobRuns(ctx context.Context, limit int, failedOnly bool) ([]JobRun, error)
+	// FindJobRun returns a job run by ID, or ErrJobRunNotFound.
+	FindJobRun(ctx context.Context, id int64) (JobRun, error)
+}
+
+// runJob runs a job of jobType, and records the run if the repository
+// implements JobRecorder. Runs interrupted by ctx being done (e.g. on
+// shutdown) aren't recorded.
+func (s Service) runJob(ctx context.Context, jobType string, retryOf int64) (JobRun, error) {
+	run := JobRun{Type: jobType, StartedAt: time.Now().UTC(), RetryOf: retryOf}
+
+	var job func(context.Context) error
+	switch jobType {
+	case JobRefresh:
+		job = s.hydrateCache
+	case JobPurge:
+		if s.purger != nil {
+			job = s.purge
+		}
+	}
+	if job == nil {
+		return JobRun{}, ErrJobRunNotFound
+	}
+
+	err := job(ctx)
+	run.EndedAt = time.Now().UTC()
+	result := "ok"
+	if err != nil {
+		run.Error = err.Error()
+		result = "error"
+	}
+	jobRuns.Inc(jobType, result)
+
+	if s.jobs != nil && ctx.Err() == nil {
+		id, recErr := s.jobs.StoreJobRun(ctx, run)
+		if recErr != nil {
+			s.logger.Warn("Could not record job run.", F("type", jobType), Err(recErr))
+		}
+		run.ID = id
+	}
+
+	return run, err
+}
+
+// JobRuns returns the latest runs of background jobs, newest first. If
+// failedOnly is true, only failed runs are returned.
+func (s Service) JobRuns(ctx context.Context, limit int, failedOnly bool) ([]JobRun, error) {
+	if s.jobs == nil {
+		return nil, ErrJobHistoryUnsupported
+	}
+	return s.jobs.FindJobRuns(ctx, limit, failedOnly)
+}
+
+// RetryJob reruns the job of a failed run, and returns the new run. The error
+// of a failed retry is returned in the run, not as error.
+func (s Service) RetryJob(ctx context.Context, id int64) (JobRun, error) {
+	if s.jobs == nil {
+		return JobRun{}, ErrJobHistoryUnsupported
+	}
+
+	failed, err := s.jobs.FindJobRun(ctx, id)
+	if err != nil {
+		return JobRun{}, err
+	}
+	if failed.Error == "" {
+		return JobRun{}, ErrJobRunNotFailed
+	}
+
+	run, err := s.runJob(ctx, failed.Type, failed.ID)
+	if err == ErrJobRunNotFound {
+		return JobRun{}, err
+	}
+	return run, nil
+}
@@ -0,0 +1,45 @@
+package diag
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrKeyLookupUnsupported is used when the repository doesn't implement
+// KeyFinder.
+var ErrKeyLookupUnsupported = errors.New("diag: repository doesn't support key lookups")
+
+// StoredDiagnosisKey is a Diagnosis Key with its storage metadata.
+type StoredDiagnosisKey struct {
+	DiagnosisKey
+	// Index is the position of the key in upload order, i.e. the sequence
+	// number assigned by the repository.
+	Index int64
+}
+
+// MarshalBinary returns the binary representation of the Diagnosis Key, as
+// served by listings.
+func (dk StoredDiagnosisKey) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, DiagnosisKeySize)
+	encodeDiagnosisKey(buf, dk.DiagnosisKey)
+	return buf, nil
+}
+
+// KeyFinder is implemented by repositories that can look up a single
+// Diagnosis Key, e.g. for incident response.
+type KeyFinder interface {
+	// FindDiagnosisKeyByTEK returns the stored Diagnosis Key with the given
+	// Temporary Exposure Key, or ErrKeyNotFound.
+	FindDiagnosisKeyByTEK(ctx context.Context, tek [16]byte) (StoredDiagnosisKey, error)
+}
+
+// FindDiagnosisKey returns the stored Diagnosis Key with the given Temporary
+// Exposure Key, looked up in the repository (not the cache), so keys that
+// aren't published yet are found too.
+func (s Service) FindDiagnosisKey(ctx context.Context, tek [16]byte) (StoredDiagnosisKey, error) {
+	finder, ok := s.repo.(KeyFinder)
+	if !ok {
+		return StoredDiagnosisKey{}, ErrKeyLookupUnsupported
+	}
+	return finder.FindDiagnosisKeyByTEK(ctx, tek)
+}
@@ -0,0 +1,56 @@
+package diag
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// ErrUploadQueueFull is used when the maximum amount of concurrent uploads is
+// reached, and no more uploads can be queued.
+var ErrUploadQueueFull = errors.New("diag: upload queue is full")
+
+// uploadLimiter is a semaphore limiting the amount of concurrent uploads, with
+// a bounded queue of uploads waiting for a slot.
+type uploadLimiter struct {
+	slots     chan struct{}
+	maxQueued int64
+	queued    *int64
+}
+
+func newUploadLimiter(maxConcurrent, maxQueued uint) *uploadLimiter {
+	return &uploadLimiter{
+		slots:     make(chan struct{}, maxConcurrent),
+		maxQueued: int64(maxQueued),
+		queued:    new(int64),
+	}
+}
+
+// acquire takes a slot, waiting in the queue if no slot is available. It
+// returns ErrUploadQueueFull if the queue is full, or the context error if
+// ctx is done before a slot becomes available.
+func (l *uploadLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if atomic.AddInt64(l.queued, 1) > l.maxQueued {
+		atomic.AddInt64(l.queued, -1)
+		return ErrUploadQueueFull
+	}
+	defer atomic.AddInt64(l.queued, -1)
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees a slot taken with acquire.
+func (l *uploadLimiter) release() {
+	<-l.slots
+}
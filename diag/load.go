@@ -0,0 +1,75 @@
+package diag
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// minUploadRetryAfter and maxUploadRetryAfter bound the delay clients
+	// are asked to wait before retrying a rejected upload.
+	minUploadRetryAfter = 5 * time.Second
+	maxUploadRetryAfter = 5 * time.Minute
+)
+
+// ErrOverloaded is used when uploads are shed because the saturation of the
+// upload queue or the repository exceeds Config.UploadSaturationThreshold.
+var ErrOverloaded = errors.New("diag: server is overloaded")
+
+// PoolSaturationReporter is implemented by repositories that can report the
+// saturation of their connection pool.
+type PoolSaturationReporter interface {
+	// PoolSaturation returns the fraction (0 to 1) of connections in use.
+	PoolSaturation() float64
+}
+
+// Load represents the current upload load of the service, as hints for
+// clients to adapt their retries.
+type Load struct {
+	// QueuedUploads is the amount of uploads waiting for a slot, if
+	// concurrent uploads are limited.
+	QueuedUploads int64 `json:"queuedUploads"`
+	// QueueSaturation is the fraction (0 to 1) of upload slots and queue
+	// positions taken, or zero if concurrent uploads aren't limited.
+	QueueSaturation float64 `json:"queueSaturation"`
+	// PoolSaturation is the fraction (0 to 1) of repository connections in
+	// use, or -1 if the repository doesn't implement PoolSaturationReporter.
+	PoolSaturation float64 `json:"poolSaturation"`
+	// Overloaded is true if new uploads are rejected with ErrOverloaded.
+	Overloaded bool `json:"overloaded"`
+	// RetryAfter is the delay clients should wait before retrying a rejected
+	// upload, derived from the queue depth.
+	RetryAfter time.Duration `json:"-"`
+}
+
+// Load returns the current upload load.
+func (s Service) Load() Load {
+	load := Load{PoolSaturation: -1}
+
+	if s.uploads != nil {
+		load.QueuedUploads = atomic.LoadInt64(s.uploads.queued)
+		capacity := int64(cap(s.uploads.slots)) + s.uploads.maxQueued
+		load.QueueSaturation = float64(int64(len(s.uploads.slots))+load.QueuedUploads) / float64(capacity)
+	}
+	if reporter, ok := s.repo.(PoolSaturationReporter); ok {
+		load.PoolSaturation = reporter.PoolSaturation()
+	}
+
+	if s.uploadSaturationThreshold > 0 {
+		load.Overloaded = load.QueueSaturation >= s.uploadSaturationThreshold ||
+			load.PoolSaturation >= s.uploadSaturationThreshold
+	}
+
+	// Every queued upload has to wait for a fraction of a slot, so the delay
+	// grows with the queue depth relative to the amount of slots.
+	load.RetryAfter = minUploadRetryAfter
+	if s.uploads != nil && cap(s.uploads.slots) > 0 {
+		load.RetryAfter += time.Duration(load.QueuedUploads) * minUploadRetryAfter / time.Duration(cap(s.uploads.slots))
+	}
+	if load.RetryAfter > maxUploadRetryAfter {
+		load.RetryAfter = maxUploadRetryAfter
+	}
+
+	return load
+}
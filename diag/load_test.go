@@ -0,0 +1,89 @@
+package diag
+
+import (
+	"context"
+	"testing"
+)
+
+// saturatedRepo is a repository reporting a fixed pool saturation.
+type saturatedRepo struct {
+	snapshotRepo
+	saturation float64
+}
+
+func (r saturatedRepo) PoolSaturation() float64 {
+	return r.saturation
+}
+
+func TestLoad(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	t.Run("unlimited uploads", func(t *testing.T) {
+		svc, err := NewService(ctx, Config{Repository: snapshotRepo{}, Logger: NewNopLogger()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp := Load{PoolSaturation: -1, RetryAfter: minUploadRetryAfter}
+		if got := svc.Load(); got != exp {
+			t.Errorf("expected: %+v, got: %+v", exp, got)
+		}
+	})
+
+	t.Run("queue saturation", func(t *testing.T) {
+		svc, err := NewService(ctx, Config{
+			Repository:                snapshotRepo{},
+			Logger:                    NewNopLogger(),
+			MaxConcurrentUploads:      2,
+			MaxQueuedUploads:          8,
+			UploadSaturationThreshold: 0.5,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Take both slots, and queue three uploads.
+		svc.uploads.acquire(ctx)
+		svc.uploads.acquire(ctx)
+		*svc.uploads.queued = 3
+
+		got := svc.Load()
+		if got.QueuedUploads != 3 || got.QueueSaturation != 0.5 || !got.Overloaded {
+			t.Errorf("unexpected load: %+v", got)
+		}
+		if exp := minUploadRetryAfter + 3*minUploadRetryAfter/2; got.RetryAfter != exp {
+			t.Errorf("expected: %v, got: %v", exp, got.RetryAfter)
+		}
+		if _, err := svc.StoreDiagnosisKeys(ctx, []DiagnosisKey{{TemporaryExposureKey: [16]byte{1}}}); err != ErrOverloaded {
+			t.Errorf("expected: %v, got: %v", ErrOverloaded, err)
+		}
+
+		*svc.uploads.queued = 1000
+		if got := svc.Load().RetryAfter; got != maxUploadRetryAfter {
+			t.Errorf("expected: %v, got: %v", maxUploadRetryAfter, got)
+		}
+	})
+
+	t.Run("pool saturation", func(t *testing.T) {
+		tests := []struct {
+			saturation float64
+			threshold  float64
+			exp        bool
+		}{
+			{saturation: 0.9, threshold: 0.8, exp: true},
+			{saturation: 0.5, threshold: 0.8, exp: false},
+			{saturation: 1, threshold: 0, exp: false},
+		}
+		for _, tt := range tests {
+			repo := saturatedRepo{saturation: tt.saturation}
+			svc, err := NewService(ctx, Config{Repository: repo, Logger: NewNopLogger(), UploadSaturationThreshold: tt.threshold})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := svc.Load()
+			if got.PoolSaturation != tt.saturation || got.Overloaded != tt.exp {
+				t.Errorf("expected overloaded: %v for saturation %v and threshold %v, got: %+v", tt.exp, tt.saturation, tt.threshold, got)
+			}
+		}
+	})
+}
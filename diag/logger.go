@@ -0,0 +1,39 @@
+package diag
+
+// Logger defines a minimal interface for structured, leveled logging. It
+// allows embedders to use a logging library of their choice. An adapter for
+// zap is provided by package zaplog.
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+}
+
+// Field represents a key-value pair for structured logging.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F returns a Field.
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Err returns a Field for an error, using `error` as key.
+func Err(err error) Field {
+	return Field{Key: "error", Value: err}
+}
+
+// NewNopLogger returns a Logger that discards all log entries.
+func NewNopLogger() Logger {
+	return nopLogger{}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...Field) {}
+func (nopLogger) Info(string, ...Field)  {}
+func (nopLogger) Warn(string, ...Field)  {}
+func (nopLogger) Error(string, ...Field) {}
@@ -0,0 +1,58 @@
+package diag
+
+import "github.com/dstotijn/ct-diag-server/metrics"
+
+var (
+	coalescedRefreshes = metrics.DefaultRegistry.Counter(
+		"ctdiag_cache_coalesced_refreshes_total",
+		"Total number of cache refreshes that shared the result of a concurrent refresh.",
+	)
+	duplicateKeys = metrics.DefaultRegistry.Counter(
+		"ctdiag_upload_duplicate_keys_total",
+		"Total number of uploaded Diagnosis Keys that were already stored, skipped without a repository call.",
+	)
+	defaultsApplied = metrics.DefaultRegistry.Counter(
+		"ctdiag_upload_defaults_applied_total",
+		"Total number of uploaded Diagnosis Keys with a default applied to a zero valued field.",
+		"field",
+	)
+	implausibleKeys = metrics.DefaultRegistry.Counter(
+		"ctdiag_upload_implausible_keys_total",
+		"Total number of uploaded Diagnosis Keys rejected for a rolling start number outside the accepted window.",
+	)
+	storedKeysGauge = metrics.DefaultRegistry.Gauge(
+		"ctdiag_stored_keys",
+		"Number of stored Diagnosis Keys, as of the last cache refresh.",
+	)
+	corruptSnapshots = metrics.DefaultRegistry.Counter(
+		"ctdiag_cache_snapshot_corrupt_total",
+		"Total number of corrupt cache snapshots, for which the cache was hydrated from the repository instead.",
+	)
+	cacheHydrationDuration = metrics.DefaultRegistry.Histogram(
+		"ctdiag_cache_hydration_duration_seconds",
+		"Duration of successful cache hydrations from the repository.",
+		metrics.DefaultBuckets,
+	)
+	cacheSizeBytes = metrics.DefaultRegistry.Gauge(
+		"ctdiag_cache_size_bytes",
+		"Size of the cached Diagnosis Keys listing in bytes.",
+	)
+	jobRuns = metrics.DefaultRegistry.Counter(
+		"ctdiag_job_runs_total",
+		"Total number of background job runs, by job type and result (`ok` or `error`).",
+		"type", "result",
+	)
+	lateKeysCounter = metrics.DefaultRegistry.Counter(
+		"ctdiag_cache_late_keys_total",
+		"Total number of Diagnosis Keys stored with an upload time in an already published time range, published in the next one instead.",
+	)
+	expiredFederatedKeys = metrics.DefaultRegistry.Counter(
+		"ctdiag_federation_received_expired_keys_total",
+		"Total number of Diagnosis Keys pushed by peer servers and dropped for exceeding the maximum federated key age, by origin.",
+		"origin",
+	)
+	bloomFalsePositives = metrics.DefaultRegistry.Counter(
+		"ctdiag_upload_bloom_false_positives_total",
+		"Total number of uploaded Diagnosis Keys falsely reported as known by the Bloom filter.",
+	)
+)
@@ -0,0 +1,42 @@
+package diag
+
+import (
+	"context"
+	"time"
+)
+
+// OriginStorer is implemented by repositories that can store the origin of
+// Diagnosis Keys, e.g. the region of the peer server that federated keys were
+// pulled from (see package federation). Keys uploaded to this server have no
+// origin.
+type OriginStorer interface {
+	// StoreDiagnosisKeysWithOrigin stores diagKeys like StoreDiagnosisKeys,
+	// tagged with origin. Keys that were already stored keep their origin.
+	StoreDiagnosisKeysWithOrigin(ctx context.Context, diagKeys []DiagnosisKey, uploadedAt time.Time, origin string) error
+}
+
+// LocalKeyFinder is implemented by repositories that can list the Diagnosis
+// Keys uploaded to this server, i.e. the keys without origin, e.g. to push
+// them to peer servers (see package federation).
+type LocalKeyFinder interface {
+	// FindLocalDiagnosisKeys returns at most limit keys without origin with an
+	// Index greater than after, ordered by Index.
+	FindLocalDiagnosisKeys(ctx context.Context, after int64, limit int) ([]StoredDiagnosisKey, error)
+}
+
+// StoreFederatedDiagnosisKeys stores keys pushed by a peer server, tagged with
+// origin if the repository implements OriginStorer. Unlike uploads, they
+// aren't subject to plausibility checks or quotas, as the peer applied its own
+// on upload. Keys older than Config.MaxFederatedKeyAge are dropped.
+func (s Service) StoreFederatedDiagnosisKeys(ctx context.Context, diagKeys []DiagnosisKey, origin string) error {
+	uploadedAt := time.Now().UTC()
+	diagKeys, dropped := DropExpiredKeys(diagKeys, s.maxFederatedKeyAge, uploadedAt)
+	expiredFederatedKeys.Add(float64(dropped), origin)
+	if len(diagKeys) == 0 {
+		return nil
+	}
+	if storer, ok := s.repo.(OriginStorer); ok {
+		return storer.StoreDiagnosisKeysWithOrigin(ctx, diagKeys, uploadedAt, origin)
+	}
+	return s.repo.StoreDiagnosisKeys(ctx, diagKeys, uploadedAt)
+}
@@ -0,0 +1,55 @@
+package diag
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// rollingInterval is the duration of a rolling interval, the unit of
+// RollingStartNumber.
+const rollingInterval = 10 * time.Minute
+
+// InvalidKeysError is returned by StoreDiagnosisKeys for uploads with
+// implausible Diagnosis Keys. It lists the problem of each invalid key, so
+// client developers can fix all of them at once.
+type InvalidKeysError struct {
+	Problems []string
+}
+
+func (e *InvalidKeysError) Error() string {
+	return fmt.Sprintf("diag: invalid diagnosis keys (%d problem(s)): %v", len(e.Problems), strings.Join(e.Problems, "; "))
+}
+
+// rollingStartTime returns the start of the rolling interval of diagKey.
+func rollingStartTime(diagKey DiagnosisKey) time.Time {
+	return time.Unix(int64(diagKey.RollingStartNumber)*int64(rollingInterval/time.Second), 0).UTC()
+}
+
+// checkPlausibility returns an *InvalidKeysError if the rolling interval of
+// any of diagKeys started more than maxKeyAge before now, or starts more than
+// keyClockSkew after now. Keys are referred to by their index in the upload.
+func (s Service) checkPlausibility(diagKeys []DiagnosisKey, now time.Time) error {
+	if s.maxKeyAge == 0 {
+		return nil
+	}
+
+	oldest, newest := now.Add(-s.maxKeyAge), now.Add(s.keyClockSkew)
+
+	var problems []string
+	for i, diagKey := range diagKeys {
+		start := rollingStartTime(diagKey)
+		switch {
+		case start.Before(oldest):
+			problems = append(problems, fmt.Sprintf("key %d: rolling start number %d (%v) is more than %v in the past", i, diagKey.RollingStartNumber, start.Format(time.RFC3339), s.maxKeyAge))
+		case start.After(newest):
+			problems = append(problems, fmt.Sprintf("key %d: rolling start number %d (%v) is in the future", i, diagKey.RollingStartNumber, start.Format(time.RFC3339)))
+		}
+	}
+	if len(problems) > 0 {
+		implausibleKeys.Add(float64(len(problems)))
+		return &InvalidKeysError{Problems: problems}
+	}
+
+	return nil
+}
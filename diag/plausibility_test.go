@@ -0,0 +1,58 @@
+package diag
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreDiagnosisKeysPlausibility(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	repo := storingRepo{stored: &[]DiagnosisKey{}}
+	svc, err := NewService(ctx, Config{Repository: repo, Logger: NewNopLogger(), MaxKeyAge: 14 * 24 * time.Hour, KeyClockSkew: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rollingStartNumber := func(t time.Time) uint32 {
+		return uint32(t.Unix() / int64(rollingInterval/time.Second))
+	}
+	now := time.Now()
+	today := rollingStartNumber(now.Truncate(24 * time.Hour))
+
+	diagKeys := []DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: today},
+		{TemporaryExposureKey: [16]byte{2}, RollingStartNumber: rollingStartNumber(now.Add(-15 * 24 * time.Hour))},
+		{TemporaryExposureKey: [16]byte{3}, RollingStartNumber: rollingStartNumber(now.Add(30 * time.Minute))},
+		{TemporaryExposureKey: [16]byte{4}, RollingStartNumber: rollingStartNumber(now.Add(2 * time.Hour))},
+	}
+	rejected := implausibleKeys.Value()
+
+	_, err = svc.StoreDiagnosisKeys(ctx, diagKeys)
+	verr, ok := err.(*InvalidKeysError)
+	if !ok {
+		t.Fatalf("expected *InvalidKeysError, got: %v", err)
+	}
+	if got, exp := len(verr.Problems), 2; got != exp {
+		t.Fatalf("expected: %v problems, got: %v (%v)", exp, got, verr)
+	}
+	if !strings.HasPrefix(verr.Problems[0], "key 1: ") || !strings.Contains(verr.Problems[0], "in the past") {
+		t.Errorf("unexpected problem: %v", verr.Problems[0])
+	}
+	if !strings.HasPrefix(verr.Problems[1], "key 3: ") || !strings.Contains(verr.Problems[1], "in the future") {
+		t.Errorf("unexpected problem: %v", verr.Problems[1])
+	}
+	if len(*repo.stored) != 0 {
+		t.Errorf("expected no keys to be stored, got: %+v", *repo.stored)
+	}
+	if got := implausibleKeys.Value() - rejected; got != 2 {
+		t.Errorf("expected: %v, got: %v", 2, got)
+	}
+
+	if _, err := svc.StoreDiagnosisKeys(ctx, diagKeys[:1]); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package diag
+
+import "time"
+
+// publicationTime returns the last modified time to publish for a cache
+// refresh, given the previously published time prev, the latest upload time
+// reported by the repository, and the time of hydration. It never moves
+// backwards, and if the cached keys changed without the upload time advancing
+// (e.g. keys with an older upload time were imported by federation backfill,
+// or keys were deleted), it's advanced to at least the next second, so HTTP
+// caches validating with `If-Modified-Since` don't miss the change.
+func publicationTime(prev, lastModified, hydratedAt time.Time, changed bool) time.Time {
+	if prev.IsZero() {
+		return lastModified
+	}
+	if !changed {
+		if lastModified.After(prev) {
+			return lastModified
+		}
+		return prev
+	}
+	if lastModified.Truncate(time.Second).After(prev.Truncate(time.Second)) {
+		return lastModified
+	}
+
+	next := prev.Truncate(time.Second).Add(time.Second)
+	if hydratedAt.After(next) {
+		next = hydratedAt
+	}
+	return next
+}
@@ -0,0 +1,143 @@
+package diag
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPublicationTime(t *testing.T) {
+	prev := time.Date(2020, time.May, 5, 12, 0, 0, 500, time.UTC)
+	hydratedAt := time.Date(2020, time.May, 5, 12, 10, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		prev         time.Time
+		lastModified time.Time
+		changed      bool
+		expected     time.Time
+	}{
+		{
+			name:         "first hydration",
+			lastModified: prev,
+			changed:      true,
+			expected:     prev,
+		},
+		{
+			name:         "new upload",
+			prev:         prev,
+			lastModified: prev.Add(time.Minute),
+			changed:      true,
+			expected:     prev.Add(time.Minute),
+		},
+		{
+			name:         "unchanged",
+			prev:         prev,
+			lastModified: prev,
+			expected:     prev,
+		},
+		{
+			name:         "unchanged, older upload time",
+			prev:         prev,
+			lastModified: prev.Add(-time.Hour),
+			expected:     prev,
+		},
+		{
+			name:         "backfill",
+			prev:         prev,
+			lastModified: prev.Add(-time.Hour),
+			changed:      true,
+			expected:     hydratedAt,
+		},
+		{
+			name:         "upload in same second",
+			prev:         prev,
+			lastModified: prev.Add(time.Millisecond),
+			changed:      true,
+			expected:     hydratedAt,
+		},
+		{
+			name:         "backfill, hydrated in same second",
+			prev:         hydratedAt,
+			lastModified: prev,
+			changed:      true,
+			expected:     hydratedAt.Add(time.Second),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := publicationTime(tt.prev, tt.lastModified, hydratedAt, tt.changed)
+			if !got.Equal(tt.expected) {
+				t.Errorf("expected: %v, got: %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestLastModifiedBackfill(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	diagKeys := []DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: 42, TransmissionRiskLevel: 1, UploadedAt: time.Date(2020, time.May, 5, 12, 0, 0, 0, time.UTC)},
+	}
+	svc, err := NewService(ctx, Config{Repository: snapshotRepo{diagKeys: diagKeys}, Logger: NewNopLogger()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := svc.LastModified()
+	etag := svc.ETag("")
+
+	// A key uploaded earlier is imported, so the repository reports an older
+	// upload time for the latest stored key.
+	backfilled := DiagnosisKey{TemporaryExposureKey: [16]byte{2}, RollingStartNumber: 43, TransmissionRiskLevel: 1, UploadedAt: time.Date(2020, time.May, 4, 12, 0, 0, 0, time.UTC)}
+	svc.repo = snapshotRepo{diagKeys: append(diagKeys, backfilled)}
+	if err := svc.hydrateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	after := svc.LastModified()
+	if !after.Truncate(time.Second).After(before.Truncate(time.Second)) {
+		t.Errorf("expected last modified time after %v, got: %v", before, after)
+	}
+	if svc.ETag("") == etag {
+		t.Error("expected entity tag to change")
+	}
+
+	// Refreshing without changes keeps the last modified time.
+	if err := svc.hydrateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := svc.LastModified(); !got.Equal(after) {
+		t.Errorf("expected: %v, got: %v", after, got)
+	}
+}
+
+func TestLastModifiedMergedRegions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	diagKey := DiagnosisKey{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: 42, TransmissionRiskLevel: 1, UploadedAt: time.Date(2020, time.May, 5, 12, 0, 0, 0, time.UTC), Regions: []string{"NL"}}
+	svc, err := NewService(ctx, Config{Repository: snapshotRepo{diagKeys: []DiagnosisKey{diagKey}}, Logger: NewNopLogger()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := svc.LastModified()
+	etag := svc.ETag("region=BE")
+
+	// The key is uploaded again for another region, which is merged into the
+	// stored key, so only its metadata changes.
+	diagKey.Regions = []string{"BE", "NL"}
+	svc.repo = snapshotRepo{diagKeys: []DiagnosisKey{diagKey}}
+	if err := svc.hydrateCache(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if after := svc.LastModified(); !after.Truncate(time.Second).After(before.Truncate(time.Second)) {
+		t.Errorf("expected last modified time after %v, got: %v", before, after)
+	}
+	if svc.ETag("region=BE") == etag {
+		t.Error("expected entity tag to change")
+	}
+}
@@ -0,0 +1,31 @@
+package diag
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrQuotaExceeded is used when uploads are refused because the amount of
+// stored keys exceeds the configured maximum.
+var ErrQuotaExceeded = errors.New("diag: stored keys quota exceeded")
+
+// checkQuota updates the amount of stored keys, and alerts if it exceeds the
+// configured maximum.
+func (s Service) checkQuota(storedKeys int) {
+	atomic.StoreInt64(s.storedKeys, int64(storedKeys))
+	storedKeysGauge.Set(float64(storedKeys))
+
+	if s.maxStoredKeys > 0 && storedKeys > s.maxStoredKeys {
+		s.logger.Error("Stored keys quota exceeded.",
+			F("storedKeys", storedKeys),
+			F("maxStoredKeys", s.maxStoredKeys),
+			F("refuseUploads", s.refuseUploadsOverQuota),
+		)
+	}
+}
+
+// quotaExceeded returns true if uploads must be refused because the amount of
+// stored keys (as of the last cache refresh) exceeds the configured maximum.
+func (s Service) quotaExceeded() bool {
+	return s.refuseUploadsOverQuota && s.maxStoredKeys > 0 && atomic.LoadInt64(s.storedKeys) > int64(s.maxStoredKeys)
+}
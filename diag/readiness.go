@@ -0,0 +1,87 @@
+package diag
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// Pinger is implemented by repositories that can check their connectivity,
+// e.g. for readiness probes.
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// Readiness represents whether the service is ready to serve requests, see
+// Service.Readiness.
+type Readiness struct {
+	// Ready is true if all dependencies are available and the cache is
+	// hydrated. Request-driven services (see Config.RequestDriven) hydrate
+	// on demand, so they don't need to be hydrated to be ready.
+	Ready bool
+	// Dependencies are the errors of pinging the repositories implementing
+	// Pinger, by name (`repository`, `revocations` or `jobs`), nil for
+	// available ones. Repositories shared by several names are pinged once.
+	Dependencies map[string]error
+	// Hydrated is true if the cache was hydrated, from the repository or a
+	// snapshot.
+	Hydrated bool
+	// RefreshedAt is the time of the last successful cache refresh.
+	RefreshedAt time.Time
+	// RefreshHealthy is false if the cache wasn't refreshed for more than two
+	// refresh intervals. A stale cache can still be served, so it doesn't
+	// affect Ready.
+	RefreshHealthy bool
+}
+
+// Readiness pings the repositories of the service, and reports its cache
+// hydration status. Pings are subject to the deadline of ctx.
+func (s Service) Readiness(ctx context.Context) Readiness {
+	refreshedAt := s.hydratedAt.get()
+	readiness := Readiness{
+		Dependencies:   make(map[string]error),
+		Hydrated:       !refreshedAt.IsZero(),
+		RefreshedAt:    refreshedAt,
+		RefreshHealthy: time.Since(refreshedAt) <= 2*s.cacheInterval,
+	}
+	readiness.Ready = readiness.Hydrated || s.requestDriven
+
+	dependencies := []struct {
+		name string
+		repo interface{}
+	}{
+		{"repository", s.repo},
+		{"revocations", s.revoker},
+		{"jobs", s.jobs},
+	}
+	var pinged []Pinger
+	for _, dep := range dependencies {
+		pinger, ok := dep.repo.(Pinger)
+		if !ok || containsPinger(pinged, pinger) {
+			continue
+		}
+		pinged = append(pinged, pinger)
+
+		err := pinger.PingContext(ctx)
+		readiness.Dependencies[dep.name] = err
+		if err != nil {
+			readiness.Ready = false
+		}
+	}
+
+	return readiness
+}
+
+// containsPinger returns true if pingers contains p. Pingers of types that
+// can't be compared (e.g. structs with func fields) are never equal.
+func containsPinger(pingers []Pinger, p Pinger) bool {
+	if !reflect.TypeOf(p).Comparable() {
+		return false
+	}
+	for _, pinger := range pingers {
+		if pinger == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,277 @@
+package diag
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// receiptVersion is the first byte of an upload receipt.
+const receiptVersion = 1
+
+// receiptContext is prepended to receipts before signing, so a signature made
+// by the same key for another purpose (e.g. via Sign) is never a valid receipt.
+const receiptContext = "ct-diag-server upload receipt\x00"
+
+// receiptEncoding encodes the parts of a receipt. Decoding is strict, so every
+// receipt has a single valid encoding.
+var receiptEncoding = base64.RawURLEncoding.Strict()
+
+var (
+	// ErrReceiptsUnsupported is used when upload receipts are disabled,
+	// because Config.UploadReceipts isn't set, no (ECDSA) signer is
+	// configured, or the repository doesn't implement KeyInserter.
+	ErrReceiptsUnsupported = errors.New("diag: upload receipts are not supported")
+	// ErrInvalidReceipt is used when a receipt can't be parsed, or its
+	// signature is invalid.
+	ErrInvalidReceipt = errors.New("diag: invalid upload receipt")
+	// ErrWithdrawalUnsupported is used when the repository doesn't implement
+	// KeyDeleter (and KeyFinder, with the transparency log).
+	ErrWithdrawalUnsupported = errors.New("diag: withdrawal is not supported")
+)
+
+// KeyInserter is implemented by repositories that report which keys of an
+// upload they inserted. Upload receipts require it, so a receipt never covers
+// keys that were stored already, e.g. by someone else's upload.
+type KeyInserter interface {
+	// InsertDiagnosisKeys stores diagKeys like StoreDiagnosisKeys, and
+	// returns the keys that were inserted, i.e. weren't stored yet.
+	InsertDiagnosisKeys(ctx context.Context, diagKeys []DiagnosisKey, uploadedAt time.Time) ([]DiagnosisKey, error)
+}
+
+// KeyDeleter is implemented by repositories that can delete specific
+// Diagnosis Keys, for withdrawing uploads.
+type KeyDeleter interface {
+	// DeleteDiagnosisKeys deletes the Diagnosis Keys with the given Temporary
+	// Exposure Keys that were uploaded in the second of uploadedAt (the
+	// precision of receipts), and returns the Temporary Exposure Keys of the
+	// deleted keys.
+	DeleteDiagnosisKeys(ctx context.Context, keys [][16]byte, uploadedAt time.Time) ([][16]byte, error)
+}
+
+// Withdrawal is the outcome of withdrawing an upload.
+type Withdrawal struct {
+	// Deleted is the amount of deleted keys, Revoked the amount of revoked
+	// keys, i.e. keys that were already published.
+	Deleted int64
+	Revoked int
+	// Kept is the amount of published keys that weren't deleted, as the
+	// transparency log is append-only.
+	Kept int
+}
+
+// IssueReceipt returns a signed receipt of an upload, which allows the
+// uploader to withdraw it (see Withdraw). The receipt is a token of two
+// base64url encoded parts, separated by a dot: the version, upload time (Unix
+// seconds, uint64, big endian) and Temporary Exposure Keys of the upload, and
+// its ASN.1 encoded ECDSA signature. diagKeys must be the keys the upload
+// stored, as returned by StoreDiagnosisKeys, so keys stored by other uploads
+// can't be withdrawn.
+func (s Service) IssueReceipt(diagKeys []DiagnosisKey, uploadedAt time.Time) (string, error) {
+	if _, ok := s.repo.(KeyInserter); !ok || !s.uploadReceipts || s.signer == nil {
+		return "", ErrReceiptsUnsupported
+	}
+	if len(diagKeys) == 0 {
+		return "", errors.New("diag: no keys to issue a receipt for")
+	}
+
+	payload := make([]byte, 9, 9+len(diagKeys)*16)
+	payload[0] = receiptVersion
+	binary.BigEndian.PutUint64(payload[1:9], uint64(uploadedAt.Unix()))
+	for _, diagKey := range diagKeys {
+		payload = append(payload, diagKey.TemporaryExposureKey[:]...)
+	}
+
+	digest := receiptDigest(payload)
+	signature, err := s.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("diag: could not sign receipt: %v", err)
+	}
+
+	return receiptEncoding.EncodeToString(payload) + "." + receiptEncoding.EncodeToString(signature), nil
+}
+
+// parseReceipt verifies a receipt issued by IssueReceipt, and returns the
+// Temporary Exposure Keys and upload time of the upload. Receipts signed with
+// a rotated signing key are invalid.
+func (s Service) parseReceipt(receipt string) ([][16]byte, time.Time, error) {
+	if !s.uploadReceipts || s.signer == nil {
+		return nil, time.Time{}, ErrReceiptsUnsupported
+	}
+	pub, ok := s.signer.Public().(*ecdsa.PublicKey)
+	if !ok {
+		return nil, time.Time{}, ErrReceiptsUnsupported
+	}
+
+	parts := strings.Split(receipt, ".")
+	if len(parts) != 2 {
+		return nil, time.Time{}, ErrInvalidReceipt
+	}
+	payload, err := receiptEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, time.Time{}, ErrInvalidReceipt
+	}
+	signature, err := receiptEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, time.Time{}, ErrInvalidReceipt
+	}
+	n := len(payload) - 9
+	if n <= 0 || n%16 != 0 || payload[0] != receiptVersion {
+		return nil, time.Time{}, ErrInvalidReceipt
+	}
+	digest := receiptDigest(payload)
+	if !ecdsa.VerifyASN1(pub, digest[:], signature) {
+		return nil, time.Time{}, ErrInvalidReceipt
+	}
+
+	keys := make([][16]byte, n/16)
+	for i := range keys {
+		copy(keys[i][:], payload[9+i*16:])
+	}
+	uploadedAt := time.Unix(int64(binary.BigEndian.Uint64(payload[1:9])), 0)
+	return keys, uploadedAt, nil
+}
+
+func receiptDigest(payload []byte) [sha256.Size]byte {
+	return sha256.Sum256(append([]byte(receiptContext), payload...))
+}
+
+// Withdraw deletes the Diagnosis Keys of the upload of a receipt issued by
+// IssueReceipt, e.g. on a data subject's request. Keys that were already
+// published are revoked as well, if revocation is supported. Unpublished keys
+// are never revoked, as the revocation list is public. With the transparency
+// log, published keys are only revoked, not deleted, as deleting logged keys
+// would break its append-only property.
+//
+// Only keys stored at the upload time of the receipt are deleted or revoked,
+// so a receipt never withdraws keys of another upload.
+func (s Service) Withdraw(ctx context.Context, receipt string) (Withdrawal, error) {
+	keys, uploadedAt, err := s.parseReceipt(receipt)
+	if err != nil {
+		return Withdrawal{}, err
+	}
+	deleter, ok := s.repo.(KeyDeleter)
+	if !ok {
+		return Withdrawal{}, ErrWithdrawalUnsupported
+	}
+
+	var published [][16]byte
+	if s.revoker != nil || s.tlog != nil {
+		if published, err = s.publishedKeys(keys); err != nil {
+			return Withdrawal{}, err
+		}
+	}
+
+	var w Withdrawal
+	var matched [][16]byte
+	deletable := keys
+	if s.tlog != nil {
+		deletable = withoutKeys(keys, published)
+		// Published keys aren't deleted, so they're matched to the upload
+		// by lookup instead.
+		if matched, err = s.keysUploadedAt(ctx, published, uploadedAt); err != nil {
+			return Withdrawal{}, err
+		}
+		w.Kept = len(matched)
+	}
+	if len(deletable) > 0 {
+		deleted, err := deleter.DeleteDiagnosisKeys(ctx, deletable, uploadedAt)
+		if err != nil {
+			return Withdrawal{}, err
+		}
+		w.Deleted = int64(len(deleted))
+		matched = append(matched, deleted...)
+	}
+
+	if s.revoker == nil {
+		return w, nil
+	}
+	revocable := withoutKeys(published, withoutKeys(published, matched))
+	if len(revocable) > 0 {
+		if err := s.RevokeDiagnosisKeys(ctx, revocable); err != nil {
+			return w, err
+		}
+	}
+	w.Revoked = len(revocable)
+
+	return w, nil
+}
+
+// keysUploadedAt returns the keys of keys that are stored with an upload time
+// in the second of uploadedAt.
+func (s Service) keysUploadedAt(ctx context.Context, keys [][16]byte, uploadedAt time.Time) ([][16]byte, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	finder, ok := s.repo.(KeyFinder)
+	if !ok {
+		return nil, ErrWithdrawalUnsupported
+	}
+
+	var matched [][16]byte
+	for _, key := range keys {
+		diagKey, err := finder.FindDiagnosisKeyByTEK(ctx, key)
+		if err == ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if diagKey.UploadedAt.Unix() == uploadedAt.Unix() {
+			matched = append(matched, key)
+		}
+	}
+	return matched, nil
+}
+
+// withoutKeys returns the keys of keys that aren't in excluded.
+func withoutKeys(keys, excluded [][16]byte) [][16]byte {
+	skip := make(map[[16]byte]bool, len(excluded))
+	for _, key := range excluded {
+		skip[key] = true
+	}
+	var remaining [][16]byte
+	for _, key := range keys {
+		if !skip[key] {
+			remaining = append(remaining, key)
+		}
+	}
+	return remaining
+}
+
+// publishedKeys returns the keys of keys that are in the cache.
+func (s Service) publishedKeys(keys [][16]byte) ([][16]byte, error) {
+	wanted := make(map[[16]byte]bool, len(keys))
+	for _, key := range keys {
+		wanted[key] = true
+	}
+
+	var published [][16]byte
+	br := bufio.NewReader(s.cache.ReadSeeker([16]byte{}))
+	var buf [DiagnosisKeySize]byte
+	for {
+		if _, err := io.ReadFull(br, buf[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		var key [16]byte
+		copy(key[:], buf[:16])
+		if wanted[key] {
+			published = append(published, key)
+			delete(wanted, key)
+		}
+	}
+
+	return published, nil
+}
@@ -0,0 +1,217 @@
+package diag
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withdrawingRepo is a repository recording deleted and revoked keys.
+type withdrawingRepo struct {
+	snapshotRepo
+	// unpublished are stored keys that aren't cached yet.
+	unpublished []DiagnosisKey
+	deleted     *[][16]byte
+	revoked     *[][16]byte
+}
+
+func (r withdrawingRepo) FindAllDiagnosisKeys(_ context.Context) ([]DiagnosisKey, error) {
+	deleted := make(map[[16]byte]bool)
+	for _, key := range *r.deleted {
+		deleted[key] = true
+	}
+	var diagKeys []DiagnosisKey
+	for _, diagKey := range r.diagKeys {
+		if !deleted[diagKey.TemporaryExposureKey] {
+			diagKeys = append(diagKeys, diagKey)
+		}
+	}
+	return diagKeys, nil
+}
+
+func (r withdrawingRepo) InsertDiagnosisKeys(_ context.Context, diagKeys []DiagnosisKey, _ time.Time) ([]DiagnosisKey, error) {
+	return diagKeys, nil
+}
+
+func (r withdrawingRepo) FindDiagnosisKeyByTEK(ctx context.Context, tek [16]byte) (StoredDiagnosisKey, error) {
+	diagKeys, _ := r.FindAllDiagnosisKeys(ctx)
+	for _, diagKey := range append(diagKeys, r.unpublished...) {
+		if diagKey.TemporaryExposureKey == tek {
+			return StoredDiagnosisKey{DiagnosisKey: diagKey}, nil
+		}
+	}
+	return StoredDiagnosisKey{}, ErrKeyNotFound
+}
+
+func (r withdrawingRepo) DeleteDiagnosisKeys(ctx context.Context, keys [][16]byte, uploadedAt time.Time) ([][16]byte, error) {
+	var deleted [][16]byte
+	for _, key := range keys {
+		diagKey, err := r.FindDiagnosisKeyByTEK(ctx, key)
+		if err == nil && diagKey.UploadedAt.Unix() == uploadedAt.Unix() {
+			deleted = append(deleted, key)
+		}
+	}
+	*r.deleted = append(*r.deleted, deleted...)
+	return deleted, nil
+}
+
+func (r withdrawingRepo) DeleteDiagnosisKeysBefore(_ context.Context, _ time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (r withdrawingRepo) StoreRevocations(_ context.Context, keys [][16]byte, _ time.Time) error {
+	*r.revoked = append(*r.revoked, keys...)
+	return nil
+}
+
+func (r withdrawingRepo) FindAllRevocations(_ context.Context) ([]Revocation, error) {
+	var revocations []Revocation
+	for _, key := range *r.revoked {
+		revocations = append(revocations, Revocation{TemporaryExposureKey: key})
+	}
+	return revocations, nil
+}
+
+func TestWithdraw(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	published := DiagnosisKey{TemporaryExposureKey: [16]byte{1}, UploadedAt: uploadedAt}
+	unpublished := DiagnosisKey{TemporaryExposureKey: [16]byte{2}, UploadedAt: uploadedAt}
+	repo := withdrawingRepo{
+		snapshotRepo: snapshotRepo{diagKeys: []DiagnosisKey{published}},
+		unpublished:  []DiagnosisKey{unpublished},
+		deleted:      &[][16]byte{},
+		revoked:      &[][16]byte{},
+	}
+
+	// With a retention period, the transparency log is disabled.
+	svc, err := NewService(ctx, Config{Repository: repo, Logger: NewNopLogger(), Signer: signingKey, UploadReceipts: true, RetentionPeriod: 14 * 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receipt, err := svc.IssueReceipt([]DiagnosisKey{published, unpublished}, uploadedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("invalid receipts", func(t *testing.T) {
+		parts := strings.Split(receipt, ".")
+		// Withdraw a different key with the same signature.
+		tampered := parts[0][:20] + "_" + parts[0][21:] + "." + parts[1]
+		for _, r := range []string{"", "foobar", parts[0], parts[0] + ".", tampered} {
+			if _, err := svc.Withdraw(ctx, r); err != ErrInvalidReceipt {
+				t.Errorf("%q: expected: %v, got: %v", r, ErrInvalidReceipt, err)
+			}
+		}
+
+		otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		other, err := NewService(ctx, Config{Repository: repo, Logger: NewNopLogger(), Signer: otherKey, UploadReceipts: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := other.Withdraw(ctx, receipt); err != ErrInvalidReceipt {
+			t.Errorf("expected: %v, got: %v", ErrInvalidReceipt, err)
+		}
+		if len(*repo.deleted) != 0 {
+			t.Errorf("expected no deleted keys, got: %v", *repo.deleted)
+		}
+	})
+
+	t.Run("keys of other uploads", func(t *testing.T) {
+		// E.g. published keys uploaded again: the receipt of the second
+		// upload has another upload time.
+		other, err := svc.IssueReceipt([]DiagnosisKey{published, unpublished}, uploadedAt.Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := svc.Withdraw(ctx, other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w != (Withdrawal{}) {
+			t.Errorf("expected nothing to be withdrawn, got: %+v", w)
+		}
+		if len(*repo.deleted) != 0 || len(*repo.revoked) != 0 {
+			t.Errorf("expected no deleted or revoked keys, got: %v, %v", *repo.deleted, *repo.revoked)
+		}
+	})
+
+	t.Run("only published keys are revoked", func(t *testing.T) {
+		w, err := svc.Withdraw(ctx, receipt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp := (Withdrawal{Deleted: 2, Revoked: 1}); w != exp {
+			t.Errorf("expected: %+v, got: %+v", exp, w)
+		}
+		if len(*repo.revoked) != 1 || (*repo.revoked)[0] != published.TemporaryExposureKey {
+			t.Errorf("expected only published key to be revoked, got: %v", *repo.revoked)
+		}
+	})
+
+	t.Run("transparency log", func(t *testing.T) {
+		logged := DiagnosisKey{TemporaryExposureKey: [16]byte{3}, UploadedAt: uploadedAt}
+		repo := withdrawingRepo{
+			snapshotRepo: snapshotRepo{diagKeys: []DiagnosisKey{logged}},
+			unpublished:  []DiagnosisKey{unpublished},
+			deleted:      &[][16]byte{},
+			revoked:      &[][16]byte{},
+		}
+		svc, err := NewService(ctx, Config{Repository: repo, Logger: NewNopLogger(), Signer: signingKey, UploadReceipts: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if head, err := svc.TreeHead(); err != nil || head.TreeSize != 1 {
+			t.Fatalf("expected logged key, got: %+v, %v", head, err)
+		}
+		receipt, err := svc.IssueReceipt([]DiagnosisKey{logged, unpublished}, uploadedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w, err := svc.Withdraw(ctx, receipt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exp := (Withdrawal{Deleted: 1, Revoked: 1, Kept: 1}); w != exp {
+			t.Errorf("expected: %+v, got: %+v", exp, w)
+		}
+		if len(*repo.deleted) != 1 || (*repo.deleted)[0] != unpublished.TemporaryExposureKey {
+			t.Errorf("expected only unpublished key to be deleted, got: %v", *repo.deleted)
+		}
+
+		// The log stays append-only, so refreshes keep succeeding.
+		if err := svc.RefreshCache(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if head, err := svc.TreeHead(); err != nil || head.TreeSize != 1 {
+			t.Errorf("expected tree size 1, got: %+v, %v", head, err)
+		}
+	})
+
+	t.Run("receipts disabled", func(t *testing.T) {
+		disabled, err := NewService(ctx, Config{Repository: repo, Logger: NewNopLogger(), Signer: signingKey})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := disabled.IssueReceipt([]DiagnosisKey{published}, time.Now()); err != ErrReceiptsUnsupported {
+			t.Errorf("expected: %v, got: %v", ErrReceiptsUnsupported, err)
+		}
+		if _, err := disabled.Withdraw(ctx, receipt); err != ErrReceiptsUnsupported {
+			t.Errorf("expected: %v, got: %v", ErrReceiptsUnsupported, err)
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package diag
+
+import (
+	"fmt"
+	"io"
+)
+
+// redacted replaces key material in log entries and formatted output.
+const redacted = "[redacted]"
+
+// String returns a representation of the Diagnosis Key that is safe to log:
+// the Temporary Exposure Key is redacted.
+func (dk DiagnosisKey) String() string {
+	return fmt.Sprintf("DiagnosisKey{TemporaryExposureKey: %v, RollingStartNumber: %v, TransmissionRiskLevel: %v, UploadedAt: %v}",
+		redacted,
+		dk.RollingStartNumber,
+		dk.TransmissionRiskLevel,
+		dk.UploadedAt,
+	)
+}
+
+// Format implements fmt.Formatter, so that key material is redacted for every
+// formatting verb (including `%x` and `%#v`), not just for `%v` and `%s`.
+func (dk DiagnosisKey) Format(f fmt.State, _ rune) {
+	io.WriteString(f, dk.String())
+}
+
+// Redact returns a representation of v that is safe to log. It is the policy
+// applied by Logger implementations to field values: Diagnosis Keys are
+// formatted with their key material redacted, and raw bytes (e.g. request
+// payload fragments) are never logged. Other values are returned as is.
+func Redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case DiagnosisKey:
+		return v.String()
+	case *DiagnosisKey:
+		if v == nil {
+			return nil
+		}
+		return v.String()
+	case []DiagnosisKey:
+		return fmt.Sprintf("[%d diagnosis key(s) %v]", len(v), redacted)
+	case [16]byte:
+		return redacted
+	case []byte:
+		return fmt.Sprintf("[%d byte(s) %v]", len(v), redacted)
+	default:
+		return v
+	}
+}
@@ -0,0 +1,77 @@
+package diag
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var testKey = DiagnosisKey{
+	TemporaryExposureKey:  [16]byte{0xde, 0xad, 0xbe, 0xef, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+	RollingStartNumber:    42,
+	TransmissionRiskLevel: 5,
+}
+
+// assertNoKeyMaterial fails the test if s contains any representation of the
+// Temporary Exposure Key of testKey.
+func assertNoKeyMaterial(t *testing.T, s string) {
+	t.Helper()
+
+	tek := testKey.TemporaryExposureKey
+	for _, leak := range []string{
+		hex.EncodeToString(tek[:]),
+		strings.ToUpper(hex.EncodeToString(tek[:])),
+		fmt.Sprint(tek[:]),
+		string(tek[:]),
+		"222, 173, 190, 239",
+		"0xde, 0xad",
+	} {
+		if strings.Contains(s, leak) {
+			t.Errorf("expected key material to be redacted, got: %q", s)
+		}
+	}
+	if !strings.Contains(s, redacted) {
+		t.Errorf("expected redaction marker, got: %q", s)
+	}
+}
+
+func TestDiagnosisKeyFormat(t *testing.T) {
+	for _, format := range []string{"%v", "%+v", "%#v", "%s", "%q", "%x", "%X", "%d"} {
+		t.Run(format, func(t *testing.T) {
+			assertNoKeyMaterial(t, fmt.Sprintf(format, testKey))
+			assertNoKeyMaterial(t, fmt.Sprintf(format, &testKey))
+			assertNoKeyMaterial(t, fmt.Sprintf(format, []DiagnosisKey{testKey}))
+		})
+	}
+
+	t.Run("wrapped in error", func(t *testing.T) {
+		err := fmt.Errorf("could not store key %v", testKey)
+		assertNoKeyMaterial(t, err.Error())
+	})
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{name: "diagnosis key", value: testKey},
+		{name: "diagnosis key pointer", value: &testKey},
+		{name: "diagnosis keys", value: []DiagnosisKey{testKey}},
+		{name: "temporary exposure key", value: testKey.TemporaryExposureKey},
+		{name: "raw bytes", value: testKey.TemporaryExposureKey[:]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertNoKeyMaterial(t, fmt.Sprintf("%#v", Redact(tt.value)))
+		})
+	}
+
+	t.Run("other values", func(t *testing.T) {
+		if got := Redact(42); got != 42 {
+			t.Errorf("expected: 42, got: %v", got)
+		}
+	})
+}
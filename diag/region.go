@@ -0,0 +1,116 @@
+package diag
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Limits of region tags, see ParseRegions.
+const (
+	maxRegions      = 16
+	maxRegionLength = 8
+)
+
+// ErrRegionsUnsupported is used when the cache doesn't implement RegionCache.
+var ErrRegionsUnsupported = errors.New("diag: cache doesn't support region-scoped listings")
+
+// ParseRegions parses comma separated region tags, e.g. `NL,BE`. Regions
+// consist of (at most 8) letters and digits, e.g. country codes or MCCs, and
+// are normalized to upper case. Duplicates are removed.
+func ParseRegions(s string) ([]string, error) {
+	var regions []string
+	seen := make(map[string]bool)
+	for _, region := range strings.Split(s, ",") {
+		region = strings.ToUpper(strings.TrimSpace(region))
+		if !validRegion(region) {
+			return nil, fmt.Errorf("diag: invalid region %q", region)
+		}
+		if seen[region] {
+			continue
+		}
+		seen[region] = true
+		regions = append(regions, region)
+	}
+	if len(regions) > maxRegions {
+		return nil, fmt.Errorf("diag: too many regions, at most %v are allowed", maxRegions)
+	}
+	return regions, nil
+}
+
+// MergeRegions returns the sorted union of the regions a and b. Keys are
+// stored once: when a key that is stored already is uploaded again for other
+// regions (e.g. by a border resident), repositories merge its regions.
+func MergeRegions(a, b []string) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	var merged []string
+	for _, region := range append(append([]string{}, a...), b...) {
+		if !seen[region] {
+			seen[region] = true
+			merged = append(merged, region)
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
+
+func validRegion(region string) bool {
+	if region == "" || len(region) > maxRegionLength {
+		return false
+	}
+	for _, r := range region {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// RegionCache is implemented by caches that support region-scoped listings.
+type RegionCache interface {
+	// ReadSeekerRegion returns an io.ReadSeeker for accessing the Diagnosis
+	// Keys tagged with region, ordered by upload.
+	ReadSeekerRegion(region string) io.ReadSeeker
+}
+
+// ReadSeekerRegion returns an io.ReadSeeker for accessing the Diagnosis Keys
+// tagged with region (see ParseRegions), ordered by upload. If the cache
+// doesn't implement RegionCache, ErrRegionsUnsupported is returned.
+func (s Service) ReadSeekerRegion(region string) (io.ReadSeeker, error) {
+	rc, ok := s.cache.(RegionCache)
+	if !ok {
+		return nil, ErrRegionsUnsupported
+	}
+	return rc.ReadSeekerRegion(region), nil
+}
+
+// ReadSeekerRegion implements RegionCache. Region tags aren't part of cache
+// snapshots, so after hydrating from a snapshot, region-scoped listings are
+// empty until the cache is refreshed from the repository.
+func (mc *MemoryCache) ReadSeekerRegion(region string) io.ReadSeeker {
+	mc.mu.RLock()
+	buf := mc.regions[region]
+	mc.mu.RUnlock()
+
+	return bytes.NewReader(buf)
+}
+
+// encodeRegions returns the binary representation of the keys of each region
+// diagKeys are tagged with.
+func encodeRegions(diagKeys []DiagnosisKey) map[string][]byte {
+	regions := make(map[string][]byte)
+	var b [DiagnosisKeySize]byte
+	for _, diagKey := range diagKeys {
+		if len(diagKey.Regions) == 0 {
+			continue
+		}
+		encodeDiagnosisKey(b[:], diagKey)
+		for _, region := range diagKey.Regions {
+			regions[region] = append(regions[region], b[:]...)
+		}
+	}
+	return regions
+}
@@ -0,0 +1,66 @@
+package diag
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRegions(t *testing.T) {
+	got, err := ParseRegions("nl, BE,NL,204")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"NL", "BE", "204"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected: %v, got: %v", exp, got)
+	}
+
+	for _, s := range []string{"", "NL,", "N-L", "NETHERLANDS", "A,B,C,D,E,F,G,H,I,J,K,L,M,N,O,P,Q"} {
+		if _, err := ParseRegions(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestMergeRegions(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		exp  []string
+	}{
+		{nil, nil, nil},
+		{[]string{"NL"}, nil, []string{"NL"}},
+		{nil, []string{"NL", "BE"}, []string{"BE", "NL"}},
+		{[]string{"NL", "DE"}, []string{"BE", "NL"}, []string{"BE", "DE", "NL"}},
+	}
+	for _, tt := range tests {
+		if got := MergeRegions(tt.a, tt.b); !reflect.DeepEqual(got, tt.exp) {
+			t.Errorf("%v, %v: expected: %v, got: %v", tt.a, tt.b, tt.exp, got)
+		}
+	}
+}
+
+func TestMemoryCacheReadSeekerRegion(t *testing.T) {
+	now := time.Date(2020, time.May, 4, 12, 0, 0, 0, time.UTC)
+	mc := &MemoryCache{}
+	mc.Set([]DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, UploadedAt: now, Regions: []string{"NL"}},
+		{TemporaryExposureKey: [16]byte{2}, UploadedAt: now},
+		{TemporaryExposureKey: [16]byte{3}, UploadedAt: now, Regions: []string{"BE", "NL"}},
+	}, now)
+
+	for region, exp := range map[string][]byte{"NL": {1, 3}, "BE": {3}, "DE": nil} {
+		buf, err := ioutil.ReadAll(mc.ReadSeekerRegion(region))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []byte
+		for i := 0; i < len(buf); i += DiagnosisKeySize {
+			got = append(got, buf[i])
+		}
+		if !bytes.Equal(got, exp) {
+			t.Errorf("%v: expected: %v, got: %v", region, exp, got)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package diag
+
+import "fmt"
+
+// ReportType is the type of diagnosis a Diagnosis Key was uploaded with, as
+// defined by version 1.5 of the Exposure Notification protocol.
+type ReportType uint8
+
+// Report types, with the values of the `ReportType` enum of export files.
+const (
+	ReportTypeUnknown ReportType = iota
+	ReportTypeConfirmedTest
+	ReportTypeConfirmedClinicalDiagnosis
+	ReportTypeSelfReport
+	// ReportTypeRecursive is reserved for keys shared between servers, and
+	// ReportTypeRevoked for keys that were revoked; they can't be uploaded.
+	ReportTypeRecursive
+	ReportTypeRevoked
+)
+
+var reportTypeNames = [...]string{
+	ReportTypeUnknown:                    "unknown",
+	ReportTypeConfirmedTest:              "confirmed_test",
+	ReportTypeConfirmedClinicalDiagnosis: "confirmed_clinical_diagnosis",
+	ReportTypeSelfReport:                 "self_report",
+	ReportTypeRecursive:                  "recursive",
+	ReportTypeRevoked:                    "revoked",
+}
+
+func (rt ReportType) String() string {
+	if int(rt) < len(reportTypeNames) {
+		return reportTypeNames[rt]
+	}
+	return fmt.Sprintf("ReportType(%d)", rt)
+}
+
+// ParseReportType parses the name of a report type, e.g. `confirmed_test`.
+func ParseReportType(s string) (ReportType, error) {
+	for rt, name := range reportTypeNames {
+		if s == name {
+			return ReportType(rt), nil
+		}
+	}
+	return 0, fmt.Errorf("diag: invalid report type %q", s)
+}
+
+// Bounds of DaysSinceOnsetOfSymptoms, as defined by the Exposure Notification
+// protocol.
+const (
+	MinDaysSinceOnsetOfSymptoms = -14
+	MaxDaysSinceOnsetOfSymptoms = 14
+)
+
+// DaysSinceOnsetOfSymptoms returns the amount of days between the day of the
+// symptom onset interval and the day of rollingStartNumber, both in
+// Exposure Notification intervals, or nil if it's out of bounds.
+func DaysSinceOnsetOfSymptoms(rollingStartNumber, symptomOnsetInterval uint32) *int32 {
+	const intervalsPerDay = 144
+	days := int32(rollingStartNumber/intervalsPerDay) - int32(symptomOnsetInterval/intervalsPerDay)
+	if days < MinDaysSinceOnsetOfSymptoms || days > MaxDaysSinceOnsetOfSymptoms {
+		return nil
+	}
+	return &days
+}
+
+// checkReports returns an *InvalidKeysError if any of diagKeys has a report
+// type that can't be uploaded, or days since onset of symptoms out of bounds.
+// Keys are referred to by their index in the upload.
+func checkReports(diagKeys []DiagnosisKey) error {
+	var problems []string
+	for i, diagKey := range diagKeys {
+		if diagKey.ReportType > ReportTypeSelfReport {
+			problems = append(problems, fmt.Sprintf("key %d: report type %v can't be uploaded", i, diagKey.ReportType))
+		}
+		if days := diagKey.DaysSinceOnsetOfSymptoms; days != nil && (*days < MinDaysSinceOnsetOfSymptoms || *days > MaxDaysSinceOnsetOfSymptoms) {
+			problems = append(problems, fmt.Sprintf("key %d: days since onset of symptoms %d is out of bounds", i, *days))
+		}
+	}
+	if len(problems) > 0 {
+		return &InvalidKeysError{Problems: problems}
+	}
+
+	return nil
+}
+
+// ReportCache is implemented by caches that hold the report types and days
+// since onset of symptoms of Diagnosis Keys, so they're included in export
+// files.
+type ReportCache interface {
+	// Report returns the report type and, if hasDays is true, the days since
+	// onset of symptoms of the Diagnosis Key with the given Temporary Exposure
+	// Key.
+	Report(key [16]byte) (reportType ReportType, days int32, hasDays bool)
+}
+
+// keyReport is the report of a Diagnosis Key, see ReportCache.
+type keyReport struct {
+	reportType ReportType
+	days       int8
+	hasDays    bool
+}
+
+// reportMap holds the reports of Diagnosis Keys by Temporary Exposure Key.
+// Keys without report type and days since onset of symptoms are left out.
+type reportMap map[[16]byte]keyReport
+
+func newReportMap(diagKeys []DiagnosisKey) reportMap {
+	var rm reportMap
+	for _, diagKey := range diagKeys {
+		if diagKey.ReportType == ReportTypeUnknown && diagKey.DaysSinceOnsetOfSymptoms == nil {
+			continue
+		}
+		if rm == nil {
+			rm = make(reportMap)
+		}
+		report := keyReport{reportType: diagKey.ReportType}
+		if days := diagKey.DaysSinceOnsetOfSymptoms; days != nil {
+			report.days, report.hasDays = int8(*days), true
+		}
+		rm[diagKey.TemporaryExposureKey] = report
+	}
+	return rm
+}
+
+// Report implements ReportCache.
+func (rm reportMap) Report(key [16]byte) (ReportType, int32, bool) {
+	report := rm[key]
+	return report.reportType, int32(report.days), report.hasDays
+}
+
+// Report implements ReportCache. Reports aren't part of cache snapshots, so
+// after hydrating from a snapshot, export files lack them until the cache is
+// refreshed from the repository.
+func (mc *MemoryCache) Report(key [16]byte) (ReportType, int32, bool) {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	return mc.reports.Report(key)
+}
@@ -0,0 +1,111 @@
+package diag
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+func TestParseReportType(t *testing.T) {
+	if got, err := ParseReportType("self_report"); err != nil || got != ReportTypeSelfReport {
+		t.Errorf("expected: %v, got: %v (err: %v)", ReportTypeSelfReport, got, err)
+	}
+	if _, err := ParseReportType("positive"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestDaysSinceOnsetOfSymptoms(t *testing.T) {
+	onset := uint32(144 * 18385)
+	tests := []struct {
+		rollingStartNumber uint32
+		exp                int32
+		ok                 bool
+	}{
+		{onset, 0, true},
+		{onset + 143, 0, true},
+		{onset - 1, -1, true},
+		{onset + 144*2 + 7, 2, true},
+		{onset + 144*14, 14, true},
+		{onset - 144*14, -14, true},
+		{onset + 144*15, 0, false},
+		{onset - 144*15, 0, false},
+	}
+	for _, tt := range tests {
+		got := DaysSinceOnsetOfSymptoms(tt.rollingStartNumber, onset)
+		switch {
+		case !tt.ok && got != nil:
+			t.Errorf("%v: expected nil, got: %v", tt.rollingStartNumber, *got)
+		case tt.ok && (got == nil || *got != tt.exp):
+			t.Errorf("%v: expected: %v, got: %v", tt.rollingStartNumber, tt.exp, got)
+		}
+	}
+}
+
+func TestCheckReports(t *testing.T) {
+	days := int32(15)
+	err := checkReports([]DiagnosisKey{
+		{ReportType: ReportTypeConfirmedTest},
+		{ReportType: ReportTypeRevoked},
+		{DaysSinceOnsetOfSymptoms: &days},
+	})
+	invalidKeysErr, ok := err.(*InvalidKeysError)
+	if !ok {
+		t.Fatalf("expected *InvalidKeysError, got: %v", err)
+	}
+	if len(invalidKeysErr.Problems) != 2 {
+		t.Errorf("expected 2 problems, got: %v", invalidKeysErr.Problems)
+	}
+}
+
+func TestWriteDiagnosisKeyExportReports(t *testing.T) {
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := ExportMeta{
+		StartTime:  time.Date(2020, time.May, 4, 0, 0, 0, 0, time.UTC),
+		EndTime:    time.Date(2020, time.May, 5, 0, 0, 0, 0, time.UTC),
+		BatchNum:   1,
+		BatchSize:  1,
+		Signatures: []ExportSignature{{Signer: signer}},
+	}
+	days := int32(-3)
+
+	buf := &bytes.Buffer{}
+	err = WriteDiagnosisKeyExport(buf, meta,
+		DiagnosisKey{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: 42, ReportType: ReportTypeConfirmedTest, DaysSinceOnsetOfSymptoms: &days},
+		DiagnosisKey{TemporaryExposureKey: [16]byte{2}, RollingStartNumber: 42},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := unzipExport(t, buf.Bytes())["export.bin"]
+
+	var reports []map[int]uint64
+	for _, f := range decodePB(t, bin[16:]) {
+		if f.num != 7 {
+			continue
+		}
+		report := make(map[int]uint64)
+		for _, kf := range decodePB(t, f.bytes) {
+			if kf.num == 5 || kf.num == 6 {
+				report[kf.num] = kf.varint
+			}
+		}
+		reports = append(reports, report)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("expected 2 keys, got: %v", len(reports))
+	}
+	// Days since onset of symptoms are zigzag encoded: -3 is 5.
+	if got := reports[0]; len(got) != 2 || got[5] != uint64(ReportTypeConfirmedTest) || got[6] != 5 {
+		t.Errorf("expected report type 1 and days 5 (zigzag), got: %v", got)
+	}
+	if got := reports[1]; len(got) != 0 {
+		t.Errorf("expected no report fields, got: %v", got)
+	}
+}
@@ -0,0 +1,146 @@
+package diag
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+// janitorInterval is the interval between purges of expired Diagnosis Keys.
+const janitorInterval = time.Hour
+
+var (
+	// ErrPurgeUnsupported is used when no retention period is configured.
+	ErrPurgeUnsupported = errors.New("diag: purging is not supported")
+
+	// ErrDryRunUnsupported is used when the repository doesn't implement
+	// IntegrityChecker, which dry runs of purges scan the stored keys with.
+	ErrDryRunUnsupported = errors.New("diag: dry runs are not supported")
+)
+
+// Purger is implemented by repositories that support deleting Diagnosis Keys
+// that exceeded the retention period.
+type Purger interface {
+	DeleteDiagnosisKeysBefore(ctx context.Context, t time.Time) (int64, error)
+}
+
+// PurgeBatch is the amount of Diagnosis Keys of a purge that were uploaded on
+// a day (UTC, e.g. `2020-05-04`), i.e. of the listing of that day.
+type PurgeBatch struct {
+	Date  string
+	Count int
+}
+
+// PurgeReport lists the Diagnosis Keys a purge would delete, see
+// PurgeDryRun.
+type PurgeReport struct {
+	// Before is the upload time keys are deleted before.
+	Before time.Time
+	// Keys are the keys uploaded before Before, in the order they were
+	// stored, and Batches their amounts per upload day, ordered by date.
+	Keys    []RawDiagnosisKey
+	Batches []PurgeBatch
+}
+
+// Purge purges expired Diagnosis Keys now, like the janitor, and returns the
+// (recorded) run. The error of a failed purge is returned in the run, not as
+// error.
+func (s Service) Purge(ctx context.Context) (JobRun, error) {
+	run, err := s.runJob(ctx, JobPurge, 0)
+	if err == ErrJobRunNotFound {
+		return JobRun{}, ErrPurgeUnsupported
+	}
+	return run, nil
+}
+
+// PurgeDryRun returns the Diagnosis Keys a purge would delete now, without
+// deleting them.
+func (s Service) PurgeDryRun(ctx context.Context) (PurgeReport, error) {
+	if s.purger == nil {
+		return PurgeReport{}, ErrPurgeUnsupported
+	}
+	checker, ok := s.repo.(IntegrityChecker)
+	if !ok {
+		return PurgeReport{}, ErrDryRunUnsupported
+	}
+
+	// Keys are scanned in insertion order, and imported keys may have been
+	// uploaded earlier than the keys stored before them, so all keys are
+	// scanned.
+	report := PurgeReport{Before: time.Now().UTC().Add(-s.retentionPeriod)}
+	counts := make(map[string]int)
+	err := checker.ScanDiagnosisKeys(ctx, func(rawKey RawDiagnosisKey) error {
+		if !rawKey.UploadedAt.Before(report.Before) {
+			return nil
+		}
+		report.Keys = append(report.Keys, rawKey)
+		counts[rawKey.UploadedAt.UTC().Format("2006-01-02")]++
+		return nil
+	})
+	if err != nil {
+		return PurgeReport{}, err
+	}
+
+	for date, count := range counts {
+		report.Batches = append(report.Batches, PurgeBatch{Date: date, Count: count})
+	}
+	sort.Slice(report.Batches, func(i, j int) bool { return report.Batches[i].Date < report.Batches[j].Date })
+
+	return report, nil
+}
+
+// purge deletes Diagnosis Keys uploaded before the retention period. In dry
+// run mode, the keys that would be deleted are logged instead.
+func (s Service) purge(ctx context.Context) error {
+	if s.purgeDryRun {
+		report, err := s.PurgeDryRun(ctx)
+		if err != nil {
+			return err
+		}
+		s.logger.Info("Expired diagnosis keys purge dry run; no keys were deleted.",
+			F("count", len(report.Keys)),
+			F("before", report.Before),
+			F("batches", report.Batches),
+		)
+		return nil
+	}
+
+	now := time.Now().UTC()
+	before := now.Add(-s.retentionPeriod)
+	n, err := s.purger.DeleteDiagnosisKeysBefore(ctx, before)
+	if err != nil {
+		return err
+	}
+	s.purgedAt.set(now)
+
+	s.logger.Info("Expired diagnosis keys purged.", F("count", n), F("before", before))
+
+	return nil
+}
+
+// runJanitor purges expired Diagnosis Keys on startup, and then every
+// janitorInterval or at the times of the purge schedule, until ctx is done.
+func (s Service) runJanitor(ctx context.Context) {
+	t := time.NewTicker(janitorInterval)
+	defer t.Stop()
+
+	for {
+		if _, err := s.runJob(ctx, JobPurge, 0); err != nil && err != context.Canceled {
+			s.logger.Error("Could not purge expired diagnosis keys.", Err(err))
+		}
+
+		if s.purgeSchedule != nil {
+			if err := waitUntil(ctx, s.purgeSchedule, 0); err != nil {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
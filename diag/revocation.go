@@ -0,0 +1,178 @@
+package diag
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRevocationUnsupported is used when the repository doesn't implement
+	// Revoker, or no signer is configured.
+	ErrRevocationUnsupported = errors.New("diag: revocation is not supported")
+
+	// ErrNilRevokedKeys is used when an empty set of revoked keys is encountered.
+	ErrNilRevokedKeys = errors.New("diag: revoked keys is nil")
+)
+
+// Revocation is a Temporary Exposure Key that was withdrawn after publication,
+// e.g. because its upload turned out to be fraudulent.
+type Revocation struct {
+	TemporaryExposureKey [16]byte
+	RevokedAt            time.Time
+}
+
+// Revoker is implemented by repositories that support revoking published
+// Diagnosis Keys.
+type Revoker interface {
+	StoreRevocations(ctx context.Context, keys [][16]byte, revokedAt time.Time) error
+	// FindAllRevocations returns all revocations, ordered by revocation.
+	FindAllRevocations(ctx context.Context) ([]Revocation, error)
+}
+
+// revocationList is the signed list of revoked keys. It's safe for
+// concurrent use.
+type revocationList struct {
+	mu           sync.RWMutex
+	buf          []byte
+	signature    []byte
+	lastModified time.Time
+}
+
+// RevokeDiagnosisKeys stores revoked Temporary Exposure Keys and updates the
+// revocation list.
+func (s Service) RevokeDiagnosisKeys(ctx context.Context, keys [][16]byte) error {
+	if s.revoker == nil {
+		return ErrRevocationUnsupported
+	}
+	if len(keys) == 0 {
+		return ErrNilRevokedKeys
+	}
+
+	if err := s.revoker.StoreRevocations(ctx, keys, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	return s.loadRevocations(ctx)
+}
+
+// RevocationReport lists what revoking keys would change, see
+// RevokeDiagnosisKeysDryRun. Keys are de-duplicated, and listed in request
+// order.
+type RevocationReport struct {
+	// Revoked are the keys that would be added to the revocation list, and
+	// AlreadyRevoked the keys that are on it already.
+	Revoked        [][16]byte
+	AlreadyRevoked [][16]byte
+	// Unknown are the keys of Revoked that aren't stored (they're revoked
+	// nevertheless, e.g. for keys that were purged already), or nil if the
+	// repository doesn't implement KeyFinder.
+	Unknown [][16]byte
+}
+
+// RevokeDiagnosisKeysDryRun returns what revoking keys would change, without
+// revoking them.
+func (s Service) RevokeDiagnosisKeysDryRun(ctx context.Context, keys [][16]byte) (RevocationReport, error) {
+	if s.revoker == nil {
+		return RevocationReport{}, ErrRevocationUnsupported
+	}
+	if len(keys) == 0 {
+		return RevocationReport{}, ErrNilRevokedKeys
+	}
+
+	revoked := make(map[[16]byte]bool)
+	s.revocations.mu.RLock()
+	for i := 0; i+16 <= len(s.revocations.buf); i += 16 {
+		var key [16]byte
+		copy(key[:], s.revocations.buf[i:])
+		revoked[key] = true
+	}
+	s.revocations.mu.RUnlock()
+
+	finder, canFind := s.repo.(KeyFinder)
+	var report RevocationReport
+	seen := make(map[[16]byte]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if revoked[key] {
+			report.AlreadyRevoked = append(report.AlreadyRevoked, key)
+			continue
+		}
+		report.Revoked = append(report.Revoked, key)
+
+		if !canFind {
+			continue
+		}
+		switch _, err := finder.FindDiagnosisKeyByTEK(ctx, key); err {
+		case nil:
+		case ErrKeyNotFound:
+			report.Unknown = append(report.Unknown, key)
+		default:
+			return RevocationReport{}, err
+		}
+	}
+	if canFind && report.Unknown == nil {
+		report.Unknown = [][16]byte{}
+	}
+
+	return report, nil
+}
+
+// Revocations returns an io.ReadSeeker for accessing the revocation list, its
+// signature and the time of the latest revocation. The list is a bytestream
+// of revoked Temporary Exposure Keys (16 bytes each), and the signature is an
+// ASN.1 encoded signature of its SHA-256 digest.
+func (s Service) Revocations() (rs io.ReadSeeker, signature []byte, lastModified time.Time, err error) {
+	if s.revoker == nil {
+		return nil, nil, time.Time{}, ErrRevocationUnsupported
+	}
+
+	s.revocations.mu.RLock()
+	defer s.revocations.mu.RUnlock()
+
+	return bytes.NewReader(s.revocations.buf), s.revocations.signature, s.revocations.lastModified, nil
+}
+
+// loadRevocations reads all revocations from the repository, and replaces the
+// signed revocation list.
+func (s Service) loadRevocations(ctx context.Context) error {
+	revocations, err := s.revoker.FindAllRevocations(ctx)
+	if err != nil {
+		return err
+	}
+
+	var lastModified time.Time
+	buf := make([]byte, 0, len(revocations)*16)
+	for _, revocation := range revocations {
+		buf = append(buf, revocation.TemporaryExposureKey[:]...)
+		if revocation.RevokedAt.After(lastModified) {
+			lastModified = revocation.RevokedAt
+		}
+	}
+
+	digest := sha256.Sum256(buf)
+	signature, err := s.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("diag: could not sign revocation list: %v", err)
+	}
+
+	s.revocations.mu.Lock()
+	defer s.revocations.mu.Unlock()
+
+	s.revocations.buf = buf
+	s.revocations.signature = signature
+	s.revocations.lastModified = lastModified
+
+	return nil
+}
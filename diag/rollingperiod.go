@@ -0,0 +1,127 @@
+package diag
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// MaxRollingPeriod is the maximum (and default) rolling period of a Diagnosis
+// Key, in Exposure Notification intervals (10 minutes), i.e. a day.
+const MaxRollingPeriod = 144
+
+// ExtendedDiagnosisKeySize is the size of a Diagnosis Key in the extended
+// binary representation, see ParseExtendedDiagnosisKeys.
+const ExtendedDiagnosisKeySize = DiagnosisKeySize + 1
+
+// ParseExtendedDiagnosisKeys reads and parses Diagnosis Keys in the extended
+// binary representation: the binary representation of WriteDiagnosisKeys,
+// followed by 1 byte for `RollingPeriod` per key, which must be between 1
+// and MaxRollingPeriod.
+func ParseExtendedDiagnosisKeys(r io.Reader) ([]DiagnosisKey, error) {
+	buf, err := ioutil.ReadAll(r)
+	n := len(buf)
+
+	switch {
+	case err != nil && err != io.EOF:
+		return nil, err
+	case n == 0:
+		return nil, io.ErrUnexpectedEOF
+	case n%ExtendedDiagnosisKeySize != 0:
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	diagKeys := make([]DiagnosisKey, n/ExtendedDiagnosisKeySize)
+	for i := range diagKeys {
+		b := buf[i*ExtendedDiagnosisKeySize : (i+1)*ExtendedDiagnosisKeySize]
+		diagKeys[i] = EncodedKeys(b[:DiagnosisKeySize]).DiagnosisKey(0)
+		rollingPeriod := b[DiagnosisKeySize]
+		if rollingPeriod == 0 || rollingPeriod > MaxRollingPeriod {
+			return nil, fmt.Errorf("diag: key %d: invalid rolling period %d", i, rollingPeriod)
+		}
+		diagKeys[i].RollingPeriod = rollingPeriod
+	}
+
+	return diagKeys, nil
+}
+
+// WriteExtendedDiagnosisKeys writes the extended binary representation of
+// diagKeys to w, see ParseExtendedDiagnosisKeys. Keys without rolling period
+// are written with MaxRollingPeriod.
+func WriteExtendedDiagnosisKeys(w io.Writer, diagKeys ...DiagnosisKey) error {
+	var buf [ExtendedDiagnosisKeySize]byte
+	for i := range diagKeys {
+		encodeDiagnosisKey(buf[:], diagKeys[i])
+		buf[DiagnosisKeySize] = diagKeys[i].rollingPeriod()
+		if _, err := w.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rollingPeriod returns the rolling period of diagKey, defaulting to
+// MaxRollingPeriod.
+func (diagKey DiagnosisKey) rollingPeriod() uint8 {
+	if diagKey.RollingPeriod == 0 {
+		return MaxRollingPeriod
+	}
+	return diagKey.RollingPeriod
+}
+
+// checkRollingPeriods returns an *InvalidKeysError if any of diagKeys has a
+// rolling period above MaxRollingPeriod. Keys are referred to by their index
+// in the upload.
+func checkRollingPeriods(diagKeys []DiagnosisKey) error {
+	var problems []string
+	for i, diagKey := range diagKeys {
+		if diagKey.RollingPeriod > MaxRollingPeriod {
+			problems = append(problems, fmt.Sprintf("key %d: rolling period %d is out of bounds", i, diagKey.RollingPeriod))
+		}
+	}
+	if len(problems) > 0 {
+		return &InvalidKeysError{Problems: problems}
+	}
+
+	return nil
+}
+
+// RollingPeriodCache is implemented by caches that hold the rolling periods
+// of Diagnosis Keys, so they're included in export files.
+type RollingPeriodCache interface {
+	// RollingPeriod returns the rolling period of the Diagnosis Key with the
+	// given Temporary Exposure Key, or zero if it's MaxRollingPeriod.
+	RollingPeriod(key [16]byte) uint8
+}
+
+// rollingPeriodMap holds the rolling periods of Diagnosis Keys by Temporary
+// Exposure Key. Keys with the default rolling period are left out.
+type rollingPeriodMap map[[16]byte]uint8
+
+func newRollingPeriodMap(diagKeys []DiagnosisKey) rollingPeriodMap {
+	var rpm rollingPeriodMap
+	for _, diagKey := range diagKeys {
+		if diagKey.rollingPeriod() == MaxRollingPeriod {
+			continue
+		}
+		if rpm == nil {
+			rpm = make(rollingPeriodMap)
+		}
+		rpm[diagKey.TemporaryExposureKey] = diagKey.RollingPeriod
+	}
+	return rpm
+}
+
+// RollingPeriod implements RollingPeriodCache.
+func (rpm rollingPeriodMap) RollingPeriod(key [16]byte) uint8 {
+	return rpm[key]
+}
+
+// RollingPeriod implements RollingPeriodCache. Like reports, rolling periods
+// aren't part of cache snapshots.
+func (mc *MemoryCache) RollingPeriod(key [16]byte) uint8 {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	return mc.rollingPeriods.RollingPeriod(key)
+}
@@ -0,0 +1,119 @@
+package diag
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExtendedDiagnosisKeys(t *testing.T) {
+	diagKeys := []DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: 42, TransmissionRiskLevel: 1, RollingPeriod: 72},
+		{TemporaryExposureKey: [16]byte{2}, RollingStartNumber: 43, TransmissionRiskLevel: 2, RollingPeriod: MaxRollingPeriod},
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteExtendedDiagnosisKeys(buf, diagKeys...); err != nil {
+		t.Fatal(err)
+	}
+	if got, exp := buf.Len(), len(diagKeys)*ExtendedDiagnosisKeySize; got != exp {
+		t.Errorf("expected: %v, got: %v", exp, got)
+	}
+
+	got, err := ParseExtendedDiagnosisKeys(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, diagKeys) {
+		t.Errorf("expected: %+v, got: %+v", diagKeys, got)
+	}
+
+	// Keys without rolling period are written with the default.
+	buf.Reset()
+	WriteExtendedDiagnosisKeys(buf, DiagnosisKey{TemporaryExposureKey: [16]byte{3}})
+	if got := buf.Bytes()[DiagnosisKeySize]; got != MaxRollingPeriod {
+		t.Errorf("expected: %v, got: %v", MaxRollingPeriod, got)
+	}
+
+	tests := []struct {
+		name string
+		buf  []byte
+	}{
+		{"empty", nil},
+		{"binary representation", make([]byte, DiagnosisKeySize)},
+		{"zero rolling period", make([]byte, ExtendedDiagnosisKeySize)},
+		{"rolling period out of bounds", append(make([]byte, DiagnosisKeySize), MaxRollingPeriod+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseExtendedDiagnosisKeys(bytes.NewReader(tt.buf)); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestCheckRollingPeriods(t *testing.T) {
+	diagKeys := []DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}},
+		{TemporaryExposureKey: [16]byte{2}, RollingPeriod: 1},
+		{TemporaryExposureKey: [16]byte{3}, RollingPeriod: MaxRollingPeriod},
+	}
+	if err := checkRollingPeriods(diagKeys); err != nil {
+		t.Fatalf("expected: nil, got: %v", err)
+	}
+
+	diagKeys = append(diagKeys, DiagnosisKey{TemporaryExposureKey: [16]byte{4}, RollingPeriod: MaxRollingPeriod + 1})
+	var invalidKeysErr *InvalidKeysError
+	if err := checkRollingPeriods(diagKeys); !errors.As(err, &invalidKeysErr) || len(invalidKeysErr.Problems) != 1 {
+		t.Errorf("expected 1 problem, got: %v", err)
+	}
+}
+
+func TestWriteDiagnosisKeyExportRollingPeriods(t *testing.T) {
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := ExportMeta{
+		StartTime:  time.Date(2020, time.May, 4, 0, 0, 0, 0, time.UTC),
+		EndTime:    time.Date(2020, time.May, 5, 0, 0, 0, 0, time.UTC),
+		BatchNum:   1,
+		BatchSize:  1,
+		Signatures: []ExportSignature{{Signer: signer}},
+	}
+
+	buf := &bytes.Buffer{}
+	err = WriteDiagnosisKeyExport(buf, meta,
+		DiagnosisKey{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: 42, RollingPeriod: 72},
+		DiagnosisKey{TemporaryExposureKey: [16]byte{2}, RollingStartNumber: 42, RollingPeriod: MaxRollingPeriod},
+		DiagnosisKey{TemporaryExposureKey: [16]byte{3}, RollingStartNumber: 42},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := unzipExport(t, buf.Bytes())["export.bin"]
+
+	var rollingPeriods []uint64
+	for _, f := range decodePB(t, bin[16:]) {
+		if f.num != 7 {
+			continue
+		}
+		var rollingPeriod uint64
+		for _, kf := range decodePB(t, f.bytes) {
+			if kf.num == 4 {
+				rollingPeriod = kf.varint
+			}
+		}
+		rollingPeriods = append(rollingPeriods, rollingPeriod)
+	}
+	// The default rolling period is left out.
+	if exp := []uint64{72, 0, 0}; !reflect.DeepEqual(rollingPeriods, exp) {
+		t.Errorf("expected: %v, got: %v", exp, rollingPeriods)
+	}
+}
@@ -0,0 +1,104 @@
+package diag
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrSigningUnsupported is used when no signer is configured.
+var ErrSigningUnsupported = errors.New("diag: signing is not supported")
+
+// Sign returns the ASN.1 encoded ECDSA signature of the SHA-256 digest of
+// data, like the signature of the revocation list, so artifacts taken offline
+// can be verified with the same public key.
+func (s Service) Sign(data []byte) ([]byte, error) {
+	if s.signer == nil {
+		return nil, ErrSigningUnsupported
+	}
+
+	digest := sha256.Sum256(data)
+	return s.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// SignReader is like Sign, but signs the data read from r, so large listings
+// needn't be buffered. If no signer is configured, r isn't read.
+func (s Service) SignReader(r io.Reader) ([]byte, error) {
+	if s.signer == nil {
+		return nil, ErrSigningUnsupported
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return s.signer.Sign(rand.Reader, h.Sum(nil), crypto.SHA256)
+}
+
+// maxCachedSignatures bounds the amount of cached signatures, as clients choose
+// the cursor and limit of pages.
+const maxCachedSignatures = 1024
+
+// signatureCache holds the signatures of listings of the cached Diagnosis Keys,
+// by variant (see ETag), so a listing is signed once per cache refresh. Its
+// generation changes whenever the cache is replaced. It's safe for concurrent
+// use.
+type signatureCache struct {
+	mu         sync.Mutex
+	generation uint64
+	// replacing is the amount of replacements of the cache in progress,
+	// during which no signatures are cached.
+	replacing  int
+	signatures map[string][]byte
+}
+
+// begin marks the start of a replacement of the cache, end its end.
+func (sc *signatureCache) begin() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.generation++
+	sc.replacing++
+	sc.signatures = nil
+}
+
+func (sc *signatureCache) end() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.generation++
+	sc.replacing--
+}
+
+func (sc *signatureCache) currentGeneration() uint64 {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.generation
+}
+
+// get returns the signature of variant, if the cache wasn't replaced since
+// generation.
+func (sc *signatureCache) get(generation uint64, variant string) ([]byte, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if generation != sc.generation || sc.replacing > 0 {
+		return nil, false
+	}
+	signature, ok := sc.signatures[variant]
+	return signature, ok
+}
+
+// set stores the signature of variant, if the cache wasn't replaced since
+// generation, i.e. if the signed listing is still current.
+func (sc *signatureCache) set(generation uint64, variant string, signature []byte) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if generation != sc.generation || sc.replacing > 0 {
+		return
+	}
+	if sc.signatures == nil || len(sc.signatures) >= maxCachedSignatures {
+		sc.signatures = make(map[string][]byte)
+	}
+	sc.signatures[variant] = signature
+}
@@ -0,0 +1,48 @@
+package diag
+
+import "sync"
+
+// flightGroup coalesces concurrent calls with the same key into one
+// execution, whose result is shared by all callers.
+type flightGroup struct {
+	// onJoin, if set, is called when a call joins a call in flight.
+	onJoin func()
+
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	wg  sync.WaitGroup
+	err error
+}
+
+// do executes fn, unless a call with the same key is in flight, in which case
+// it waits for that call and returns its error.
+func (g *flightGroup) do(key string, fn func() error) error {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flight)
+	}
+	if f, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		if g.onJoin != nil {
+			g.onJoin()
+		}
+		f.wg.Wait()
+		return f.err
+	}
+	f := &flight{}
+	f.wg.Add(1)
+	g.calls[key] = f
+	g.mu.Unlock()
+
+	f.err = fn()
+	f.wg.Done()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+
+	return f.err
+}
@@ -0,0 +1,64 @@
+package diag
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingRepo blocks FindAllDiagnosisKeys until release is closed.
+type blockingRepo struct {
+	snapshotRepo
+	calls   *int32
+	release chan struct{}
+}
+
+func (r blockingRepo) FindAllDiagnosisKeys(ctx context.Context) ([]DiagnosisKey, error) {
+	if atomic.AddInt32(r.calls, 1) > 1 {
+		<-r.release
+	}
+	return r.snapshotRepo.FindAllDiagnosisKeys(ctx)
+}
+
+func TestRefreshCacheCoalescing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	repo := blockingRepo{
+		snapshotRepo: snapshotRepo{diagKeys: []DiagnosisKey{{TemporaryExposureKey: [16]byte{1}, UploadedAt: time.Now()}}},
+		calls:        new(int32),
+		release:      make(chan struct{}),
+	}
+	svc, err := NewService(ctx, Config{Repository: repo, Logger: NewNopLogger(), CacheInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coalesced := coalescedRefreshes.Value()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := svc.RefreshCache(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	// Wait for all other refreshes to join the one in flight.
+	deadline := time.Now().Add(5 * time.Second)
+	for coalescedRefreshes.Value()-coalesced < 99 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(repo.release)
+	wg.Wait()
+
+	// One call for the initial hydration, and one for all refreshes.
+	if got := atomic.LoadInt32(repo.calls); got != 2 {
+		t.Errorf("expected: %v, got: %v", 2, got)
+	}
+}
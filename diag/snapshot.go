@@ -0,0 +1,149 @@
+package diag
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// snapshotMagic identifies a cache snapshot, followed by a version byte.
+var snapshotMagic = [4]byte{'C', 'T', 'D', 'S'}
+
+const snapshotVersion = 2
+
+// snapshotTable is the CRC-32 table used for snapshot checksums.
+var snapshotTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	// ErrSnapshotUnsupported is used when the cache doesn't support snapshots.
+	ErrSnapshotUnsupported = errors.New("diag: cache doesn't support snapshots")
+	// ErrSnapshotCorrupt is used when a snapshot is truncated, or its checksum
+	// doesn't match.
+	ErrSnapshotCorrupt = errors.New("diag: snapshot is corrupt")
+)
+
+// WriteCacheSnapshot writes a snapshot of the cache to w, to be used as
+// Config.CacheSnapshot on startup. Only MemoryCache supports snapshots.
+//
+// The snapshot consists of a header (magic, version, hydration and last
+// modified time, key count) followed by the binary representation of each
+// Diagnosis Key with its publication time, and a CRC-32C checksum (uint32, big
+// endian) of everything before it. Times are Unix nanoseconds (uint64, big
+// endian), zero for the zero time. If Config.SnapshotCodec is set, the
+// snapshot is compressed with it.
+func (s Service) WriteCacheSnapshot(w io.Writer) error {
+	mc, ok := s.cache.(*MemoryCache)
+	if !ok {
+		return ErrSnapshotUnsupported
+	}
+	if s.snapshotCodec == nil {
+		return s.writeCacheSnapshot(w, mc)
+	}
+
+	cw, err := writeCompressedSnapshot(w, s.snapshotCodec)
+	if err != nil {
+		return err
+	}
+	if err := s.writeCacheSnapshot(cw, mc); err != nil {
+		cw.Close()
+		return err
+	}
+	return cw.Close()
+}
+
+// writeCacheSnapshot writes an uncompressed snapshot of mc to w.
+func (s Service) writeCacheSnapshot(w io.Writer, mc *MemoryCache) error {
+	mc.mu.RLock()
+	buf := mc.buf
+	publishedAt := mc.publishedAt
+	lastModified := mc.lastModified
+	mc.mu.RUnlock()
+
+	crc := crc32.New(snapshotTable)
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+	bw.Write(snapshotMagic[:])
+	bw.WriteByte(snapshotVersion)
+
+	var b [8]byte
+	for _, v := range []uint64{unixNano(s.hydratedAt.get()), unixNano(lastModified), uint64(len(publishedAt))} {
+		binary.BigEndian.PutUint64(b[:], v)
+		bw.Write(b[:])
+	}
+	for i := range publishedAt {
+		bw.Write(buf[i*DiagnosisKeySize : (i+1)*DiagnosisKeySize])
+		binary.BigEndian.PutUint64(b[:], uint64(publishedAt[i]))
+		bw.Write(b[:])
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	binary.BigEndian.PutUint32(b[:4], crc.Sum32())
+	_, err := w.Write(b[:4])
+	return err
+}
+
+// readCacheSnapshot reads a snapshot written by WriteCacheSnapshot. The
+// publication times of the keys are returned as their upload times. A
+// truncated snapshot or checksum mismatch results in ErrSnapshotCorrupt.
+func readCacheSnapshot(r io.Reader) (diagKeys []DiagnosisKey, hydratedAt, lastModified time.Time, err error) {
+	br := bufio.NewReader(r)
+	crc := crc32.New(snapshotTable)
+	tr := io.TeeReader(br, crc)
+
+	var header [5 + 3*8]byte
+	if _, err := io.ReadFull(tr, header[:]); err != nil {
+		return nil, time.Time{}, time.Time{}, fmt.Errorf("diag: could not read snapshot header: %v", err)
+	}
+	if !(header[0] == snapshotMagic[0] && header[1] == snapshotMagic[1] && header[2] == snapshotMagic[2] && header[3] == snapshotMagic[3]) {
+		return nil, time.Time{}, time.Time{}, errors.New("diag: invalid snapshot")
+	}
+	if header[4] != snapshotVersion {
+		return nil, time.Time{}, time.Time{}, fmt.Errorf("diag: unsupported snapshot version: %v", header[4])
+	}
+
+	hydratedAt = fromUnixNano(binary.BigEndian.Uint64(header[5:13]))
+	lastModified = fromUnixNano(binary.BigEndian.Uint64(header[13:21]))
+	n := binary.BigEndian.Uint64(header[21:29])
+
+	var b [DiagnosisKeySize + 8]byte
+	for i := uint64(0); i < n; i++ {
+		if _, err := io.ReadFull(tr, b[:]); err != nil {
+			return nil, time.Time{}, time.Time{}, ErrSnapshotCorrupt
+		}
+		var diagKey DiagnosisKey
+		copy(diagKey.TemporaryExposureKey[:], b[:16])
+		diagKey.RollingStartNumber = binary.BigEndian.Uint32(b[16:20])
+		diagKey.TransmissionRiskLevel = b[20]
+		diagKey.UploadedAt = fromUnixNano(binary.BigEndian.Uint64(b[DiagnosisKeySize:]))
+		diagKeys = append(diagKeys, diagKey)
+	}
+
+	var sum [4]byte
+	if _, err := io.ReadFull(br, sum[:]); err != nil {
+		return nil, time.Time{}, time.Time{}, ErrSnapshotCorrupt
+	}
+	if binary.BigEndian.Uint32(sum[:]) != crc.Sum32() {
+		return nil, time.Time{}, time.Time{}, ErrSnapshotCorrupt
+	}
+
+	return diagKeys, hydratedAt, lastModified, nil
+}
+
+func unixNano(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+func fromUnixNano(v uint64) time.Time {
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(v)).UTC()
+}
@@ -0,0 +1,232 @@
+package diag
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+type snapshotRepo struct {
+	diagKeys []DiagnosisKey
+	err      error
+}
+
+func (r snapshotRepo) StoreDiagnosisKeys(_ context.Context, _ []DiagnosisKey, _ time.Time) error {
+	return nil
+}
+
+func (r snapshotRepo) FindAllDiagnosisKeys(_ context.Context) ([]DiagnosisKey, error) {
+	return r.diagKeys, r.err
+}
+
+func (r snapshotRepo) LastModified(_ context.Context) (time.Time, error) {
+	if r.err != nil {
+		return time.Time{}, r.err
+	}
+	if len(r.diagKeys) == 0 {
+		return time.Time{}, ErrNilDiagKeys
+	}
+	return r.diagKeys[len(r.diagKeys)-1].UploadedAt, nil
+}
+
+func TestCacheSnapshot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	diagKeys := []DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: 42, TransmissionRiskLevel: 1, UploadedAt: time.Date(2020, time.May, 4, 12, 0, 0, 0, time.UTC)},
+		{TemporaryExposureKey: [16]byte{2}, RollingStartNumber: 43, TransmissionRiskLevel: 2, UploadedAt: time.Date(2020, time.May, 5, 12, 0, 0, 0, time.UTC)},
+	}
+	svc, err := NewService(ctx, Config{Repository: snapshotRepo{diagKeys: diagKeys}, Logger: NewNopLogger()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := &bytes.Buffer{}
+	if err := svc.WriteCacheSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	// The repository fails, so the cache can only be hydrated from the snapshot.
+	repo := snapshotRepo{err: errors.New("database is down")}
+	restored, err := NewService(ctx, Config{Repository: repo, Logger: NewNopLogger(), CacheSnapshot: snapshot})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, exp := restored.LastModified(), svc.LastModified(); !got.Equal(exp) {
+		t.Errorf("expected: %v, got: %v", exp, got)
+	}
+
+	start := time.Date(2020, time.May, 5, 0, 0, 0, 0, time.UTC)
+	got, err := ioutil.ReadAll(restored.ReadSeekerBetween(start, start.AddDate(0, 0, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := &bytes.Buffer{}
+	WriteDiagnosisKeys(exp, diagKeys[1])
+	if !bytes.Equal(got, exp.Bytes()) {
+		t.Errorf("expected: %v, got: %v", exp.Bytes(), got)
+	}
+
+	if !restored.IsPublished(start) {
+		t.Error("expected hydration time to be restored")
+	}
+
+	// An invalid snapshot falls back to the repository.
+	_, err = NewService(ctx, Config{Repository: repo, Logger: NewNopLogger(), CacheSnapshot: bytes.NewReader([]byte("foobar"))})
+	if err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestCacheSnapshotCorrupt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	diagKeys := []DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{1}, RollingStartNumber: 42, TransmissionRiskLevel: 1, UploadedAt: time.Date(2020, time.May, 4, 12, 0, 0, 0, time.UTC)},
+	}
+	svc, err := NewService(ctx, Config{Repository: snapshotRepo{diagKeys: diagKeys}, Logger: NewNopLogger()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := &bytes.Buffer{}
+	if err := svc.WriteCacheSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	flipped := append([]byte(nil), snapshot.Bytes()...)
+	flipped[len(flipped)-10] ^= 0xff
+
+	tests := map[string][]byte{
+		"truncated":   snapshot.Bytes()[:snapshot.Len()-8],
+		"no checksum": snapshot.Bytes()[:snapshot.Len()-4],
+		"flipped":     flipped,
+	}
+	for name, buf := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, _, _, err := readCacheSnapshot(bytes.NewReader(buf)); err != ErrSnapshotCorrupt {
+				t.Errorf("expected: %v, got: %v", ErrSnapshotCorrupt, err)
+			}
+
+			// The cache is hydrated from the repository instead.
+			before := corruptSnapshots.Value()
+			restored, err := NewService(ctx, Config{Repository: snapshotRepo{diagKeys: diagKeys}, Logger: NewNopLogger(), CacheSnapshot: bytes.NewReader(buf)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, exp := restored.LastModified(), svc.LastModified(); !got.Equal(exp) {
+				t.Errorf("expected: %v, got: %v", exp, got)
+			}
+			if got := corruptSnapshots.Value() - before; got != 1 {
+				t.Errorf("expected corrupt snapshot to be counted once, got: %v", got)
+			}
+		})
+	}
+}
+
+// nopCodec is a SnapshotCodec that doesn't compress.
+type nopCodec struct{}
+
+func (nopCodec) Name() string { return "nop" }
+
+func (nopCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+
+func (nopCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(r), nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestCacheSnapshotCompression(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	diagKeys := make([]DiagnosisKey, 1000)
+	for i := range diagKeys {
+		diagKeys[i] = DiagnosisKey{
+			TemporaryExposureKey: [16]byte{byte(i >> 8), byte(i)},
+			RollingStartNumber:   42,
+			UploadedAt:           time.Date(2020, time.May, 4, 12, 0, 0, 0, time.UTC),
+		}
+	}
+	repo := snapshotRepo{diagKeys: diagKeys}
+
+	svc, err := NewService(ctx, Config{Repository: repo, Logger: NewNopLogger()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uncompressed := &bytes.Buffer{}
+	if err := svc.WriteCacheSnapshot(uncompressed); err != nil {
+		t.Fatal(err)
+	}
+
+	gzipSvc, err := NewService(ctx, Config{Repository: repo, Logger: NewNopLogger(), SnapshotCodec: GzipCodec{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := &bytes.Buffer{}
+	if err := gzipSvc.WriteCacheSnapshot(compressed); err != nil {
+		t.Fatal(err)
+	}
+	if compressed.Len() >= uncompressed.Len() {
+		t.Errorf("expected compressed snapshot (%v bytes) to be smaller than uncompressed snapshot (%v bytes)", compressed.Len(), uncompressed.Len())
+	}
+
+	nopSvc, err := NewService(ctx, Config{Repository: repo, Logger: NewNopLogger(), SnapshotCodec: nopCodec{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nop := &bytes.Buffer{}
+	if err := nopSvc.WriteCacheSnapshot(nop); err != nil {
+		t.Fatal(err)
+	}
+
+	failingRepo := snapshotRepo{err: errors.New("database is down")}
+	tests := []struct {
+		name     string
+		codec    SnapshotCodec
+		snapshot []byte
+		expErr   bool
+	}{
+		{name: "uncompressed, without codec", snapshot: uncompressed.Bytes()},
+		{name: "uncompressed, with codec", codec: nopCodec{}, snapshot: uncompressed.Bytes()},
+		{name: "gzip, without codec", snapshot: compressed.Bytes()},
+		{name: "gzip, with other codec", codec: nopCodec{}, snapshot: compressed.Bytes()},
+		{name: "custom codec", codec: nopCodec{}, snapshot: nop.Bytes()},
+		{name: "unknown codec", snapshot: nop.Bytes(), expErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Repository: failingRepo, Logger: NewNopLogger(), SnapshotCodec: tt.codec, CacheSnapshot: bytes.NewReader(tt.snapshot)}
+			restored, err := NewService(ctx, cfg)
+			if tt.expErr {
+				if err == nil {
+					t.Error("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ioutil.ReadAll(restored.ReadSeeker([16]byte{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			exp, _ := ioutil.ReadAll(svc.ReadSeeker([16]byte{}))
+			if !bytes.Equal(got, exp) {
+				t.Errorf("expected %v bytes of restored keys, got: %v", len(exp), len(got))
+			}
+		})
+	}
+}
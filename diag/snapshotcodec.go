@@ -0,0 +1,118 @@
+package diag
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// compressedSnapshotMagic identifies a compressed cache snapshot. It's
+// followed by the length of the codec name (1 byte), the codec name, and the
+// snapshot compressed by that codec.
+var compressedSnapshotMagic = [4]byte{'C', 'T', 'D', 'Z'}
+
+// SnapshotCodec compresses cache snapshots (see Config.SnapshotCodec).
+type SnapshotCodec interface {
+	// Name identifies the codec in compressed snapshots, e.g. `gzip`. It must
+	// be at most 255 bytes.
+	Name() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// GzipCodec is a SnapshotCodec using gzip. It's always available to read
+// snapshots.
+type GzipCodec struct {
+	// Level is the compression level, see package compress/gzip. Zero means
+	// gzip.DefaultCompression.
+	Level int
+}
+
+// Name returns `gzip`.
+func (GzipCodec) Name() string {
+	return "gzip"
+}
+
+// NewWriter returns a gzip writer.
+func (c GzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+// NewReader returns a gzip reader.
+func (GzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// ParseSnapshotCodec returns the SnapshotCodec with name. An empty name
+// returns nil, i.e. uncompressed snapshots. Only `gzip` is built in; other
+// codecs (e.g. zstd) can be set directly with Config.SnapshotCodec.
+func ParseSnapshotCodec(name string) (SnapshotCodec, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case GzipCodec{}.Name():
+		return GzipCodec{}, nil
+	default:
+		return nil, fmt.Errorf("diag: unsupported snapshot codec %q", name)
+	}
+}
+
+// writeCompressedSnapshot writes the header of a compressed snapshot to w,
+// and returns a writer compressing the snapshot with codec.
+func writeCompressedSnapshot(w io.Writer, codec SnapshotCodec) (io.WriteCloser, error) {
+	name := codec.Name()
+	if name == "" || len(name) > 255 {
+		return nil, fmt.Errorf("diag: invalid snapshot codec name %q", name)
+	}
+
+	header := append(compressedSnapshotMagic[:], byte(len(name)))
+	header = append(header, name...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return codec.NewWriter(w)
+}
+
+// decodeSnapshot returns a reader of the uncompressed snapshot in r. The codec
+// of a compressed snapshot is negotiated by its name, which must be gzip or
+// the name of the configured codec. Uncompressed snapshots are read as is.
+func (s Service) decodeSnapshot(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(compressedSnapshotMagic))
+	if err != nil || string(magic) != string(compressedSnapshotMagic[:]) {
+		return ioutil.NopCloser(br), nil
+	}
+	br.Discard(len(magic))
+
+	n, err := br.ReadByte()
+	if err != nil {
+		return nil, ErrSnapshotCorrupt
+	}
+	name := make([]byte, n)
+	if _, err := io.ReadFull(br, name); err != nil {
+		return nil, ErrSnapshotCorrupt
+	}
+
+	var codec SnapshotCodec
+	switch {
+	case s.snapshotCodec != nil && s.snapshotCodec.Name() == string(name):
+		codec = s.snapshotCodec
+	case string(name) == GzipCodec{}.Name():
+		codec = GzipCodec{}
+	default:
+		return nil, fmt.Errorf("diag: unsupported snapshot codec %q", name)
+	}
+
+	rc, err := codec.NewReader(br)
+	if err != nil {
+		return nil, ErrSnapshotCorrupt
+	}
+	return rc, nil
+}
@@ -0,0 +1,94 @@
+package diag
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// KeyCountEstimator is implemented by repositories that can cheaply estimate
+// the amount of stored Diagnosis Keys, e.g. from database statistics.
+type KeyCountEstimator interface {
+	EstimateKeyCount(ctx context.Context) (int64, error)
+}
+
+// Status represents an at-a-glance overview of the service, e.g. for an
+// operator status page.
+type Status struct {
+	// CachedKeys is the amount of Diagnosis Keys available for download.
+	CachedKeys int64
+	// EstimatedStoredKeys is the estimated amount of Diagnosis Keys in the
+	// repository, or -1 if the repository doesn't implement KeyCountEstimator
+	// or estimating failed.
+	EstimatedStoredKeys int64
+	// LastModified is the published last modified time of the cache, see
+	// Service.LastModified.
+	LastModified time.Time
+	// RefreshedAt is the time of the last successful cache refresh, i.e. when
+	// uploaded keys were last published.
+	RefreshedAt time.Time
+	// Refreshes is the amount of successful cache refreshes from the
+	// repository since startup.
+	Refreshes int64
+	// RefreshHealthy is false if the cache wasn't refreshed for more than two
+	// refresh intervals, e.g. because the repository is unavailable.
+	RefreshHealthy bool
+	// PurgeEnabled is true if keys are purged after a retention period.
+	PurgeEnabled bool
+	// PurgedAt is the time of the last successful purge.
+	PurgedAt time.Time
+	// PurgeHealthy is false if purging is enabled, but no purge succeeded
+	// for more than two janitor intervals, or with a purge schedule, for an
+	// hour after a scheduled purge.
+	PurgeHealthy bool
+	// QueuedUploads is the amount of uploads waiting for a slot, if concurrent
+	// uploads are limited.
+	QueuedUploads int64
+	// QuotaExceeded is true if the stored keys quota is exceeded.
+	QuotaExceeded bool
+}
+
+// Status returns the current status of the service.
+func (s Service) Status(ctx context.Context) (Status, error) {
+	n, err := s.cache.ReadSeeker([16]byte{}).Seek(0, io.SeekEnd)
+	if err != nil {
+		return Status{}, err
+	}
+
+	now := time.Now()
+	status := Status{
+		CachedKeys:          n / DiagnosisKeySize,
+		EstimatedStoredKeys: -1,
+		LastModified:        s.LastModified(),
+		RefreshedAt:         s.hydratedAt.get(),
+		Refreshes:           atomic.LoadInt64(s.refreshes),
+		PurgeEnabled:        s.purger != nil,
+		PurgeHealthy:        true,
+	}
+	if estimator, ok := s.repo.(KeyCountEstimator); ok {
+		if estimate, err := estimator.EstimateKeyCount(ctx); err != nil {
+			s.logger.Warn("Could not estimate stored keys.", Err(err))
+		} else {
+			status.EstimatedStoredKeys = estimate
+		}
+	}
+	status.RefreshHealthy = now.Sub(status.RefreshedAt) <= 2*s.cacheInterval
+	if s.purgedAt != nil {
+		status.PurgedAt = s.purgedAt.get()
+		status.PurgeHealthy = now.Sub(status.PurgedAt) <= 2*janitorInterval
+		if s.purgeSchedule != nil {
+			// Healthy until the next scheduled purge is overdue.
+			next := s.purgeSchedule.Next(status.PurgedAt)
+			status.PurgeHealthy = next.IsZero() || now.Before(next.Add(janitorInterval))
+		}
+	}
+	if s.uploads != nil {
+		status.QueuedUploads = atomic.LoadInt64(s.uploads.queued)
+	}
+	if s.maxStoredKeys > 0 {
+		status.QuotaExceeded = atomic.LoadInt64(s.storedKeys) > int64(s.maxStoredKeys)
+	}
+
+	return status, nil
+}
@@ -0,0 +1,177 @@
+package diag
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Key streams are full exports of the published Diagnosis Keys, for
+// transfers that are too large to buffer, e.g. to backfill a peer server. A
+// stream is a sequence of frames, each a varint encoded length followed by a
+// TemporaryExposureKey message of export files. Zero length frames are
+// heartbeats, sent to keep idle connections open, and carry no key.
+
+// maxKeyFrameSize is the maximum length of a frame of a key stream.
+const maxKeyFrameSize = 256
+
+// ErrInvalidKeyStream is used when a key stream can't be decoded.
+var ErrInvalidKeyStream = errors.New("diag: invalid key stream")
+
+// KeyStreamHeartbeat is the heartbeat frame of key streams.
+var KeyStreamHeartbeat = []byte{0}
+
+// WriteKeyStream writes the frames of all published Diagnosis Keys to w, in
+// publication order, pageSize keys per write. After each write, flush is
+// called (if set), so keys can be consumed while the stream is written. It
+// returns the amount of written keys. The stream is aborted once ctx is done.
+func (s Service) WriteKeyStream(ctx context.Context, w io.Writer, pageSize int, flush func() error) (int, error) {
+	p, ok := s.cache.(Paginator)
+	if !ok {
+		return 0, ErrPaginationUnsupported
+	}
+	reports, _ := s.cache.(ReportCache)
+	rollingPeriods, _ := s.cache.(RollingPeriodCache)
+
+	var (
+		cursor  Cursor
+		written int
+		buf     []byte
+	)
+	for more := true; more; {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+
+		var rs io.ReadSeeker
+		rs, cursor, more = p.ReadSeekerPage(cursor, pageSize)
+		page, err := io.ReadAll(rs)
+		if err != nil {
+			return written, err
+		}
+		keys := EncodedKeys(page)
+		if keys.Len() == 0 {
+			break
+		}
+
+		buf = buf[:0]
+		var key [exportKeySize]byte
+		for i := 0; i < keys.Len(); i++ {
+			msg := appendExportKey(key[:0], keys, i, reports, rollingPeriods)
+			buf = appendVarint(buf, uint64(len(msg)))
+			buf = append(buf, msg...)
+		}
+		if _, err := w.Write(buf); err != nil {
+			return written, err
+		}
+		written += keys.Len()
+		if flush != nil {
+			if err := flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// ReadKeyStream reads the frames of a key stream (see WriteKeyStream) from r,
+// and calls fn with the Diagnosis Key of each frame, until r returns io.EOF.
+// Heartbeats are skipped. If fn returns an error, reading is aborted.
+func ReadKeyStream(r io.Reader, fn func(DiagnosisKey) error) error {
+	br := bufio.NewReader(r)
+	var frame [maxKeyFrameSize]byte
+	for {
+		size, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("diag: could not read key stream: %v", err)
+		}
+		if size == 0 {
+			continue
+		}
+		if size > maxKeyFrameSize {
+			return ErrInvalidKeyStream
+		}
+		if _, err := io.ReadFull(br, frame[:size]); err != nil {
+			return fmt.Errorf("diag: could not read key stream: %v", err)
+		}
+		diagKey, err := parseExportKey(frame[:size])
+		if err != nil {
+			return err
+		}
+		if err := fn(diagKey); err != nil {
+			return err
+		}
+	}
+}
+
+// parseExportKey parses a TemporaryExposureKey message of export files.
+// Unknown fields are skipped.
+func parseExportKey(msg []byte) (DiagnosisKey, error) {
+	var (
+		diagKey DiagnosisKey
+		hasKey  bool
+	)
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return DiagnosisKey{}, ErrInvalidKeyStream
+		}
+		msg = msg[n:]
+
+		switch tag & 7 {
+		case wireVarint:
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return DiagnosisKey{}, ErrInvalidKeyStream
+			}
+			msg = msg[n:]
+			switch tag >> 3 {
+			case 2:
+				diagKey.TransmissionRiskLevel = byte(v)
+			case 3:
+				diagKey.RollingStartNumber = uint32(v)
+			case 4:
+				diagKey.RollingPeriod = uint8(v)
+			case 5:
+				diagKey.ReportType = ReportType(v)
+			case 6:
+				// `sint32`, zigzag encoded.
+				days := int32(uint32(v)>>1) ^ -int32(v&1)
+				diagKey.DaysSinceOnsetOfSymptoms = &days
+			}
+		case wireBytes:
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || size > uint64(len(msg)-n) {
+				return DiagnosisKey{}, ErrInvalidKeyStream
+			}
+			v := msg[n : n+int(size)]
+			msg = msg[n+int(size):]
+			if tag>>3 == 1 {
+				if len(v) != len(diagKey.TemporaryExposureKey) {
+					return DiagnosisKey{}, ErrInvalidKeyStream
+				}
+				copy(diagKey.TemporaryExposureKey[:], v)
+				hasKey = true
+			}
+		case wireFixed64:
+			if len(msg) < 8 {
+				return DiagnosisKey{}, ErrInvalidKeyStream
+			}
+			msg = msg[8:]
+		default:
+			return DiagnosisKey{}, ErrInvalidKeyStream
+		}
+	}
+	if !hasKey {
+		return DiagnosisKey{}, ErrInvalidKeyStream
+	}
+
+	return diagKey, nil
+}
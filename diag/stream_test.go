@@ -0,0 +1,78 @@
+package diag
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestKeyStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	days := int32(-3)
+	diagKeys := []DiagnosisKey{
+		{TemporaryExposureKey: [16]byte{9}, RollingStartNumber: 42, TransmissionRiskLevel: 1, UploadedAt: time.Date(2020, time.May, 4, 12, 0, 0, 0, time.UTC)},
+		{TemporaryExposureKey: [16]byte{3}, RollingStartNumber: 43, TransmissionRiskLevel: 2, RollingPeriod: 72, UploadedAt: time.Date(2020, time.May, 4, 13, 0, 0, 0, time.UTC)},
+		{TemporaryExposureKey: [16]byte{5}, RollingStartNumber: 44, TransmissionRiskLevel: 3, ReportType: ReportTypeConfirmedTest, DaysSinceOnsetOfSymptoms: &days, UploadedAt: time.Date(2020, time.May, 5, 12, 0, 0, 0, time.UTC)},
+	}
+	svc, err := NewService(ctx, Config{Repository: snapshotRepo{diagKeys: diagKeys}, Logger: NewNopLogger()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	flushes := 0
+	n, err := svc.WriteKeyStream(ctx, buf, 2, func() error { flushes++; return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(diagKeys) {
+		t.Errorf("expected: %v, got: %v", len(diagKeys), n)
+	}
+	if flushes != 2 {
+		t.Errorf("expected: 2 flushes, got: %v", flushes)
+	}
+
+	// Heartbeats are skipped.
+	stream := append(append([]byte{}, KeyStreamHeartbeat...), buf.Bytes()...)
+	var got []DiagnosisKey
+	err = ReadKeyStream(bytes.NewReader(stream), func(diagKey DiagnosisKey) error {
+		got = append(got, diagKey)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := make([]DiagnosisKey, len(diagKeys))
+	for i, diagKey := range diagKeys {
+		diagKey.UploadedAt = time.Time{}
+		exp[i] = diagKey
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected: %+v, got: %+v", exp, got)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		stream []byte
+	}{
+		{"truncated frame", buf.Bytes()[:buf.Len()-1]},
+		{"frame too large", []byte{0xff, 0x7f}},
+		{"missing key", []byte{2, 0x10, 1}},
+		{"invalid wire type", []byte{1, 0x0f}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ReadKeyStream(bytes.NewReader(tt.stream), func(DiagnosisKey) error { return nil }); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	cancel()
+	if _, err := svc.WriteKeyStream(ctx, &bytes.Buffer{}, 2, nil); err != context.Canceled {
+		t.Errorf("expected: %v, got: %v", context.Canceled, err)
+	}
+}
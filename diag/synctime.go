@@ -0,0 +1,24 @@
+package diag
+
+import (
+	"sync"
+	"time"
+)
+
+// syncTime is a time.Time that is safe for concurrent use.
+type syncTime struct {
+	mu sync.RWMutex
+	t  time.Time
+}
+
+func (st *syncTime) get() time.Time {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.t
+}
+
+func (st *syncTime) set(t time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.t = t
+}
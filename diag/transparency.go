@@ -0,0 +1,224 @@
+package diag
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/merkle"
+)
+
+var (
+	// ErrTransparencyUnsupported is used when the transparency log is disabled,
+	// because no signer is configured or keys are purged.
+	ErrTransparencyUnsupported = errors.New("diag: transparency log is not supported")
+
+	// ErrKeyNotFound is used when a key isn't stored (see KeyFinder), or isn't
+	// (yet) included in the transparency log.
+	ErrKeyNotFound = errors.New("diag: key not found")
+
+	// ErrInvalidTreeSize is used when a tree size exceeds the size of the
+	// transparency log.
+	ErrInvalidTreeSize = errors.New("diag: invalid tree size")
+)
+
+// TreeHead is a signed tree head of the transparency log. The signature is an
+// ASN.1 encoded signature of the SHA-256 digest of the timestamp (Unix
+// milliseconds, uint64), the tree size (uint64) and the root hash; integers
+// are big endian.
+type TreeHead struct {
+	TreeSize  uint64
+	Timestamp time.Time
+	RootHash  merkle.Hash
+	Signature []byte
+}
+
+// InclusionProof proves that the leaf at LeafIndex is included in the tree of
+// TreeSize leaves.
+type InclusionProof struct {
+	LeafIndex uint64
+	TreeSize  uint64
+	AuditPath []merkle.Hash
+}
+
+// ConsistencyProof proves that the tree of size First is a prefix of the tree
+// of size Second.
+type ConsistencyProof struct {
+	First  uint64
+	Second uint64
+	Proof  []merkle.Hash
+}
+
+// transparencyLog is an append-only Merkle tree over published Diagnosis Keys,
+// in order of publication. The leaves are the binary representations of the
+// keys. It's safe for concurrent use.
+type transparencyLog struct {
+	mu         sync.RWMutex
+	leaves     []byte
+	leafHashes []merkle.Hash
+	head       TreeHead
+}
+
+// TreeHead returns the latest signed tree head of the transparency log.
+func (s Service) TreeHead() (TreeHead, error) {
+	if s.tlog == nil {
+		return TreeHead{}, ErrTransparencyUnsupported
+	}
+
+	s.tlog.mu.RLock()
+	defer s.tlog.mu.RUnlock()
+
+	return s.tlog.head, nil
+}
+
+// InclusionProof returns the proof of inclusion of a Diagnosis Key in the tree
+// of the given size. A zero tree size means the latest tree.
+func (s Service) InclusionProof(key [16]byte, treeSize uint64) (InclusionProof, error) {
+	if s.tlog == nil {
+		return InclusionProof{}, ErrTransparencyUnsupported
+	}
+
+	s.tlog.mu.RLock()
+	leaves := s.tlog.leaves
+	leafHashes := s.tlog.leafHashes
+	s.tlog.mu.RUnlock()
+
+	if treeSize == 0 {
+		treeSize = uint64(len(leafHashes))
+	}
+	if treeSize > uint64(len(leafHashes)) {
+		return InclusionProof{}, ErrInvalidTreeSize
+	}
+
+	for i := uint64(0); i < treeSize; i++ {
+		if !bytes.Equal(leaves[i*DiagnosisKeySize:i*DiagnosisKeySize+16], key[:]) {
+			continue
+		}
+		auditPath, err := merkle.InclusionProof(leafHashes[:treeSize], int(i))
+		if err != nil {
+			return InclusionProof{}, err
+		}
+		return InclusionProof{LeafIndex: i, TreeSize: treeSize, AuditPath: auditPath}, nil
+	}
+
+	return InclusionProof{}, ErrKeyNotFound
+}
+
+// ConsistencyProof returns the proof that the tree of size first is a prefix
+// of the tree of size second. A zero second tree size means the latest tree.
+func (s Service) ConsistencyProof(first, second uint64) (ConsistencyProof, error) {
+	if s.tlog == nil {
+		return ConsistencyProof{}, ErrTransparencyUnsupported
+	}
+
+	s.tlog.mu.RLock()
+	leafHashes := s.tlog.leafHashes
+	s.tlog.mu.RUnlock()
+
+	if second == 0 {
+		second = uint64(len(leafHashes))
+	}
+	if first == 0 || first > second || second > uint64(len(leafHashes)) {
+		return ConsistencyProof{}, ErrInvalidTreeSize
+	}
+
+	proof, err := merkle.ConsistencyProof(leafHashes[:second], int(first))
+	if err != nil {
+		return ConsistencyProof{}, err
+	}
+
+	return ConsistencyProof{First: first, Second: second, Proof: proof}, nil
+}
+
+// Leaves returns an io.ReadSeeker for accessing the leaves of the
+// transparency log, i.e. the binary representations of its Diagnosis Keys, so
+// monitors can recompute and verify tree heads.
+func (s Service) Leaves() (io.ReadSeeker, error) {
+	if s.tlog == nil {
+		return nil, ErrTransparencyUnsupported
+	}
+
+	s.tlog.mu.RLock()
+	defer s.tlog.mu.RUnlock()
+
+	return bytes.NewReader(s.tlog.leaves), nil
+}
+
+// appendToLog appends Diagnosis Keys to the transparency log and signs a new
+// tree head. Only keys published at least publicationMargin before
+// hydratedAt are appended: keys that were uncommitted during hydration may
+// still appear in between them, which would break the append-only property.
+func (s Service) appendToLog(diagKeys []DiagnosisKey, hydratedAt time.Time) error {
+	settled := hydratedAt.Add(-publicationMargin)
+	var n int
+	var publishedAt time.Time
+	for n < len(diagKeys) {
+		if diagKeys[n].UploadedAt.After(publishedAt) {
+			publishedAt = diagKeys[n].UploadedAt
+		}
+		if !publishedAt.Before(settled) {
+			break
+		}
+		n++
+	}
+
+	s.tlog.mu.RLock()
+	oldLeaves := s.tlog.leaves
+	oldHashes := s.tlog.leafHashes
+	s.tlog.mu.RUnlock()
+
+	if n < len(oldHashes) {
+		return fmt.Errorf("diag: transparency log would shrink from %v to %v leaves", len(oldHashes), n)
+	}
+
+	leaves := make([]byte, n*DiagnosisKeySize)
+	for i := range diagKeys[:n] {
+		encodeDiagnosisKey(leaves[i*DiagnosisKeySize:], diagKeys[i])
+	}
+	if !bytes.Equal(leaves[:len(oldLeaves)], oldLeaves) {
+		return errors.New("diag: transparency log is not append-only")
+	}
+
+	leafHashes := make([]merkle.Hash, n)
+	copy(leafHashes, oldHashes)
+	for i := len(oldHashes); i < n; i++ {
+		leafHashes[i] = merkle.LeafHash(leaves[i*DiagnosisKeySize : (i+1)*DiagnosisKeySize])
+	}
+
+	head := TreeHead{
+		TreeSize:  uint64(n),
+		Timestamp: time.Now().UTC().Truncate(time.Millisecond),
+		RootHash:  merkle.Root(leafHashes),
+	}
+	digest := sha256.Sum256(treeHeadBytes(head))
+	signature, err := s.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("diag: could not sign tree head: %v", err)
+	}
+	head.Signature = signature
+
+	s.tlog.mu.Lock()
+	defer s.tlog.mu.Unlock()
+
+	s.tlog.leaves = leaves
+	s.tlog.leafHashes = leafHashes
+	s.tlog.head = head
+
+	return nil
+}
+
+// treeHeadBytes returns the signed representation of a tree head.
+func treeHeadBytes(head TreeHead) []byte {
+	buf := make([]byte, 16+merkle.HashSize)
+	binary.BigEndian.PutUint64(buf[0:8], uint64(head.Timestamp.UnixNano()/int64(time.Millisecond)))
+	binary.BigEndian.PutUint64(buf[8:16], head.TreeSize)
+	copy(buf[16:], head.RootHash[:])
+	return buf
+}
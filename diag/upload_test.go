@@ -0,0 +1,29 @@
+package diag
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStoreDiagnosisKeysMaxUploadBatchSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	repo := storingRepo{stored: &[]DiagnosisKey{}}
+	svc, err := NewService(ctx, Config{Repository: repo, Logger: NewNopLogger(), MaxUploadBatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diagKeys := []DiagnosisKey{{TemporaryExposureKey: [16]byte{1}}, {TemporaryExposureKey: [16]byte{2}}, {TemporaryExposureKey: [16]byte{3}}}
+	if _, err := svc.StoreDiagnosisKeys(ctx, diagKeys); err != ErrMaxUploadExceeded {
+		t.Errorf("expected: %v, got: %v", ErrMaxUploadExceeded, err)
+	}
+	if len(*repo.stored) != 0 {
+		t.Errorf("expected no keys to be stored, got: %+v", *repo.stored)
+	}
+
+	if _, err := svc.StoreDiagnosisKeys(ctx, diagKeys[:2]); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+// Package zaplog provides an implementation of diag.Logger using zap.
+package zaplog
+
+import (
+	"github.com/dstotijn/ct-diag-server/diag"
+
+	"go.uber.org/zap"
+)
+
+// Logger implements diag.Logger.
+type Logger struct {
+	l *zap.Logger
+}
+
+// New returns a new Logger that writes log entries to l.
+func New(l *zap.Logger) *Logger {
+	return &Logger{l: l.WithOptions(zap.AddCallerSkip(1))}
+}
+
+// Debug logs a message at debug level.
+func (l *Logger) Debug(msg string, fields ...diag.Field) {
+	l.l.Debug(msg, zapFields(fields)...)
+}
+
+// Info logs a message at info level.
+func (l *Logger) Info(msg string, fields ...diag.Field) {
+	l.l.Info(msg, zapFields(fields)...)
+}
+
+// Warn logs a message at warn level.
+func (l *Logger) Warn(msg string, fields ...diag.Field) {
+	l.l.Warn(msg, zapFields(fields)...)
+}
+
+// Error logs a message at error level.
+func (l *Logger) Error(msg string, fields ...diag.Field) {
+	l.l.Error(msg, zapFields(fields)...)
+}
+
+// zapFields converts fields, applying the redaction policy of diag.Redact.
+func zapFields(fields []diag.Field) []zap.Field {
+	zapFields := make([]zap.Field, len(fields))
+	for i, f := range fields {
+		zapFields[i] = zap.Any(f.Key, diag.Redact(f.Value))
+	}
+	return zapFields
+}
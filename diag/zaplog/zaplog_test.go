@@ -0,0 +1,48 @@
+package zaplog
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRedaction(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	logger := New(zap.New(core))
+
+	diagKey := diag.DiagnosisKey{
+		TemporaryExposureKey: [16]byte{0xde, 0xad, 0xbe, 0xef, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		RollingStartNumber:   42,
+	}
+	logger.Info("Stored key.",
+		diag.F("diagKey", diagKey),
+		diag.F("diagKeys", []diag.DiagnosisKey{diagKey}),
+		diag.F("body", diagKey.TemporaryExposureKey[:]),
+		diag.F("keyCount", 1),
+	)
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("expected: 1 log entry, got: %v", len(entries))
+	}
+
+	fields := entries[0].ContextMap()
+	for _, key := range []string{"diagKey", "diagKeys", "body"} {
+		v, ok := fields[key].(string)
+		if !ok {
+			t.Fatalf("expected field `%v` to be a string, got: %#v", key, fields[key])
+		}
+		if strings.Contains(v, hex.EncodeToString(diagKey.TemporaryExposureKey[:])) || !strings.Contains(v, "[redacted]") {
+			t.Errorf("expected field `%v` to be redacted, got: %q", key, v)
+		}
+	}
+
+	if got := fields["keyCount"]; got != int64(1) {
+		t.Errorf("expected: 1, got: %#v", got)
+	}
+}
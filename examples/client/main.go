@@ -3,11 +3,12 @@ package main
 import (
 	"bytes"
 	"crypto/rand"
-	"encoding/binary"
 	"flag"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
 	"strings"
 	"time"
 
@@ -28,26 +29,38 @@ func main() {
 		action    string
 		baseURL   string
 		batchSize int
+		after     string
+		file      string
 	)
 
 	flag.StringVar(&baseURL, "baseURL", "http://localhost:8080", "Base URL of cg-diag-server")
 	flag.StringVar(&action, "action", actionList, "Action (allowed values: `list`, `post`)")
-	flag.IntVar(&batchSize, "batchSize", 14, "Diagnosis Key batch size, used when posting keys")
+	flag.IntVar(&batchSize, "batchSize", 14, "Diagnosis Key batch size, used when posting random keys")
+	flag.StringVar(&after, "after", "", "Hexadecimal encoded key, used when listing keys uploaded after this key")
+	flag.StringVar(&file, "file", "", "Batch file of binary encoded Diagnosis Keys. When listing, the response is written to this file. When posting, keys are read from this file instead of randomly generated")
 	flag.Parse()
 
 	switch action {
 	case actionList:
-		listDiagnosisKeys(baseURL)
+		listDiagnosisKeys(baseURL, after, file)
 	case actionPost:
-		postDiagnosisKeys(baseURL, batchSize)
+		postDiagnosisKeys(baseURL, batchSize, file)
 	default:
 		log.Fatalf("Unsupported action (%v)", action)
 	}
 
 }
 
-func listDiagnosisKeys(baseURL string) {
-	req, err := http.NewRequest("GET", baseURL+"/diagnosis-keys", nil)
+func listDiagnosisKeys(baseURL, after, file string) {
+	u, err := url.Parse(baseURL + "/diagnosis-keys")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if after != "" {
+		u.RawQuery = url.Values{"after": []string{after}}.Encode()
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -58,7 +71,23 @@ func listDiagnosisKeys(baseURL string) {
 	}
 	defer resp.Body.Close()
 
-	diagKeys, err := diag.ParseDiagnosisKeys(resp.Body)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if file != "" {
+		if err := ioutil.WriteFile(file, body, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if len(body) == 0 {
+		log.Printf("Received HTTP response with 0 key(s): %v %v", resp.StatusCode, http.StatusText(resp.StatusCode))
+		return
+	}
+
+	diagKeys, err := diag.ParseDiagnosisKeys(bytes.NewReader(body))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -71,29 +100,24 @@ func listDiagnosisKeys(baseURL string) {
 	)
 }
 
-func postDiagnosisKeys(baseURL string, batchSize int) {
-	diagKeys := diagnosisKeys(batchSize)
+func postDiagnosisKeys(baseURL string, batchSize int, file string) {
+	var diagKeys []diag.DiagnosisKey
+	if file != "" {
+		diagKeys = readDiagnosisKeys(file)
+	} else {
+		diagKeys = diagnosisKeys(batchSize)
+	}
 
 	buf := &bytes.Buffer{}
-	for _, diagKey := range diagKeys {
-		_, err := buf.Write(diagKey.TemporaryExposureKey[:])
-		if err != nil {
-			log.Fatal(err)
-		}
-		err = binary.Write(buf, binary.BigEndian, diagKey.RollingStartNumber)
-		if err != nil {
-			log.Fatal(err)
-		}
-		_, err = buf.Write([]byte{diagKey.TransmissionRiskLevel})
-		if err != nil {
-			log.Fatal(err)
-		}
+	if err := diag.WriteDiagnosisKeys(buf, diagKeys...); err != nil {
+		log.Fatal(err)
 	}
 
 	req, err := http.NewRequest("POST", baseURL+"/diagnosis-keys", buf)
 	if err != nil {
 		log.Fatal(err)
 	}
+	req.Header.Set("Content-Type", "application/octet-stream")
 
 	resp, err := httpClient.Do(req)
 	if err != nil {
@@ -114,6 +138,21 @@ func postDiagnosisKeys(baseURL string, batchSize int) {
 
 }
 
+func readDiagnosisKeys(file string) []diag.DiagnosisKey {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	diagKeys, err := diag.ParseDiagnosisKeys(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return diagKeys
+}
+
 func diagnosisKeys(n int) (keys []diag.DiagnosisKey) {
 	for i := 0; i < n; i++ {
 		// rollingStartNumber is the RollingStartNumber that denotes the start
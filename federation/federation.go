@@ -0,0 +1,350 @@
+// Package federation pulls Diagnosis Keys from peer servers, e.g. the servers
+// of neighbouring countries, so roaming users are notified of exposures to
+// keys uploaded abroad. Pulled keys are stored in the local repository,
+// tagged with the origin of their peer (if the repository implements
+// diag.OriginStorer), and are published like uploaded keys.
+//
+// Peers are ct-diag-servers, which are pulled incrementally using the
+// cursor-based pagination of their `/diagnosis-keys` listing. Conversely, a
+// Pusher pushes the keys uploaded to this server to peers, which verify the
+// signature of pushes (see VerifyPush). Likewise, pulled pages can be verified
+// against the public keys pinned for each peer (see SignatureVerifier).
+package federation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// Defaults of Config.
+const (
+	defaultInterval = time.Hour
+	defaultPageSize = 10000
+	defaultTimeout  = time.Minute
+)
+
+// Peer is a server Diagnosis Keys are pulled from.
+type Peer struct {
+	// Origin is the region the keys of the peer are tagged with, e.g. the
+	// MCC (`262`) or country code (`DE`) of its country.
+	Origin string
+	// URL is the base URL of the peer, e.g. `https://diag.example.org`.
+	URL string
+}
+
+// ParsePeers parses comma separated `origin=url` pairs, e.g.
+// `DE=https://diag.example.de,BE=https://diag.example.be`.
+func ParsePeers(s string) ([]Peer, error) {
+	var peers []Peer
+	origins := make(map[string]bool)
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("federation: invalid peer %q, expected `origin=url`", pair)
+		}
+		u, err := url.Parse(kv[1])
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("federation: invalid URL of peer %q, expected an absolute HTTP(S) URL", kv[0])
+		}
+		if origins[kv[0]] {
+			return nil, fmt.Errorf("federation: duplicate peer %q", kv[0])
+		}
+		origins[kv[0]] = true
+		peers = append(peers, Peer{Origin: kv[0], URL: strings.TrimSuffix(kv[1], "/")})
+	}
+	return peers, nil
+}
+
+// Config represents the configuration to create a Puller.
+type Config struct {
+	Repository diag.Repository
+	Peers      []Peer
+	Logger     diag.Logger
+	// Interval is the interval between pulls. Defaults to an hour.
+	Interval time.Duration
+	// Schedule, if set, replaces Interval: pulls run at its next times.
+	Schedule diag.Schedule
+	// PageSize is the maximum amount of keys per request. Defaults to 10000.
+	PageSize int
+	// Client is used for requests to peers. Defaults to a client with a
+	// timeout of a minute.
+	Client *http.Client
+	// MaxKeyAge, if non zero, drops pulled keys whose rolling interval
+	// started more than MaxKeyAge ago (see diag.DropExpiredKeys). As peers
+	// are pulled from the start after a restart, this keeps keys that were
+	// purged locally from being stored again.
+	MaxKeyAge time.Duration
+	// Verifier, if set, verifies every pulled page before its keys are
+	// stored. Results are logged with Logger; refused pages aren't stored,
+	// and the pull of their peer fails.
+	Verifier Verifier
+}
+
+// Puller pulls Diagnosis Keys from peers. The position in the listing of
+// each peer is kept in memory: after a restart, peers are pulled from the
+// start again, and keys that were already stored are ignored.
+type Puller struct {
+	cfg Config
+
+	mu      sync.Mutex
+	cursors map[string]string
+
+	statusMu sync.Mutex
+	statuses map[string]*PeerStatus
+}
+
+// New returns a new Puller.
+func New(cfg Config) (*Puller, error) {
+	if cfg.Repository == nil {
+		return nil, errors.New("federation: repository cannot be nil")
+	}
+	if cfg.Logger == nil {
+		return nil, errors.New("federation: logger cannot be nil")
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.PageSize == 0 {
+		cfg.PageSize = defaultPageSize
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: defaultTimeout}
+	}
+
+	statuses := make(map[string]*PeerStatus, len(cfg.Peers))
+	for _, peer := range cfg.Peers {
+		statuses[peer.Origin] = &PeerStatus{Origin: peer.Origin, URL: peer.URL}
+	}
+
+	return &Puller{cfg: cfg, cursors: make(map[string]string), statuses: statuses}, nil
+}
+
+// Run pulls from all peers on startup, and then every Config.Interval (or at
+// the times of Config.Schedule), until ctx is done.
+func (p *Puller) Run(ctx context.Context) {
+	var tick <-chan time.Time
+	if p.cfg.Schedule == nil {
+		t := time.NewTicker(p.cfg.Interval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	for {
+		if err := p.Pull(ctx); err != nil && ctx.Err() == nil {
+			p.cfg.Logger.Error("Could not pull diagnosis keys from peers.", diag.Err(err))
+		}
+
+		if !waitNext(ctx, p.cfg.Schedule, tick) {
+			return
+		}
+	}
+}
+
+// waitNext waits until the next time of schedule, or the next tick if
+// schedule is nil. It returns false if ctx is done first. A schedule that
+// never matches waits until ctx is done.
+func waitNext(ctx context.Context, schedule diag.Schedule, tick <-chan time.Time) bool {
+	if schedule != nil {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			<-ctx.Done()
+			return false
+		}
+		t := time.NewTimer(time.Until(next))
+		defer t.Stop()
+		tick = t.C
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-tick:
+		return true
+	}
+}
+
+// Pull pulls the keys each peer published since the previous pull. A failing
+// peer doesn't prevent pulling from the others; the first error is returned.
+func (p *Puller) Pull(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Peers may federate each other's keys, so keys are de-duplicated
+	// across peers. The first peer to serve a key determines its origin.
+	seen := make(map[[16]byte]struct{})
+
+	var firstErr error
+	for _, peer := range p.cfg.Peers {
+		n, err := p.pullPeer(ctx, peer, seen)
+		result := "ok"
+		if err != nil {
+			result = "error"
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		pulls.Inc(peer.Origin, result)
+		pulledKeys.Add(float64(n), peer.Origin)
+		p.updateStatus(peer.Origin, func(status *PeerStatus) {
+			now := time.Now().UTC()
+			status.LastAttempt = &now
+			status.ImportedKeys += int64(n)
+			status.LastError = ""
+			if err != nil {
+				status.LastError = err.Error()
+				return
+			}
+			status.LastSuccess = &now
+		})
+
+		p.cfg.Logger.Info("Diagnosis keys pulled from peer.",
+			diag.F("origin", peer.Origin),
+			diag.F("count", n),
+			diag.F("ok", err == nil),
+		)
+	}
+
+	return firstErr
+}
+
+// pullPeer pulls all pages of peer after its cursor, and returns the amount
+// of stored keys. The cursor advances with every stored page, so a failed
+// pull resumes where it left off.
+func (p *Puller) pullPeer(ctx context.Context, peer Peer, seen map[[16]byte]struct{}) (int, error) {
+	var stored int
+	for {
+		keys, next, more, err := p.fetchPage(ctx, peer, p.cursors[peer.Origin])
+		if err != nil {
+			return stored, err
+		}
+
+		diagKeys := make([]diag.DiagnosisKey, 0, keys.Len())
+		for i := 0; i < keys.Len(); i++ {
+			tek := keys.TemporaryExposureKey(i)
+			if _, ok := seen[tek]; ok {
+				continue
+			}
+			seen[tek] = struct{}{}
+			diagKeys = append(diagKeys, keys.DiagnosisKey(i))
+		}
+		diagKeys, dropped := diag.DropExpiredKeys(diagKeys, p.cfg.MaxKeyAge, time.Now())
+		expiredKeys.Add(float64(dropped), peer.Origin)
+		if len(diagKeys) > 0 {
+			if err := p.store(ctx, diagKeys, peer.Origin); err != nil {
+				return stored, fmt.Errorf("federation: could not store keys of peer %q: %v", peer.Origin, err)
+			}
+			stored += len(diagKeys)
+		}
+
+		if next != "" {
+			p.cursors[peer.Origin] = next
+		}
+		if !more {
+			// All keys up to the advertised publication time of the listing
+			// are synced.
+			p.updateStatus(peer.Origin, func(status *PeerStatus) {
+				status.SyncedLastModified = status.PeerLastModified
+			})
+			return stored, nil
+		}
+	}
+}
+
+// fetchPage requests the page of peer after cursor, and returns its keys,
+// the cursor of the next page, and whether more keys follow.
+func (p *Puller) fetchPage(ctx context.Context, peer Peer, cursor string) (diag.EncodedKeys, string, bool, error) {
+	query := url.Values{"limit": {strconv.Itoa(p.cfg.PageSize)}}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	req, err := http.NewRequest(http.MethodGet, peer.URL+"/diagnosis-keys?"+query.Encode(), nil)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("federation: could not create request for peer %q: %v", peer.Origin, err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/octet-stream")
+
+	resp, err := p.cfg.Client.Do(req)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("federation: could not pull from peer %q: %v", peer.Origin, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", false, fmt.Errorf("federation: unexpected response of peer %q: %v", peer.Origin, resp.Status)
+	}
+
+	if t := lastModified(resp.Header); t != nil {
+		p.updateStatus(peer.Origin, func(status *PeerStatus) {
+			status.PeerLastModified = t
+		})
+	}
+
+	// Guard against peers ignoring the limit.
+	maxSize := int64(p.cfg.PageSize) * diag.DiagnosisKeySize
+	keys, err := diag.ReadEncodedKeys(io.LimitReader(resp.Body, maxSize+1))
+	if err == nil && int64(len(keys)) > maxSize {
+		err = errors.New("page exceeds limit")
+	}
+	if err != nil {
+		return nil, "", false, fmt.Errorf("federation: invalid response of peer %q: %v", peer.Origin, err)
+	}
+
+	next := resp.Header.Get("X-Next-Cursor")
+	more := strings.Contains(resp.Header.Get("Link"), `rel="next"`)
+	if more && next == "" {
+		return nil, "", false, fmt.Errorf("federation: invalid response of peer %q: missing `X-Next-Cursor` header", peer.Origin)
+	}
+	if p.cfg.Verifier != nil {
+		if err := p.verifyPage(peer, cursor, resp.Header, keys); err != nil {
+			return nil, "", false, err
+		}
+	}
+	return keys, next, more, nil
+}
+
+// verifyPage verifies the page of peer after cursor with the configured
+// Verifier, and logs the result.
+func (p *Puller) verifyPage(peer Peer, cursor string, header http.Header, keys diag.EncodedKeys) error {
+	err := p.cfg.Verifier.VerifyPage(peer, header, keys)
+	if err != nil {
+		pageVerifications.Inc(peer.Origin, "refused")
+		p.updateStatus(peer.Origin, func(status *PeerStatus) {
+			status.VerificationFailures++
+		})
+		p.cfg.Logger.Error("Page of peer refused.",
+			diag.F("origin", peer.Origin),
+			diag.F("cursor", cursor),
+			diag.F("count", keys.Len()),
+			diag.Err(err),
+		)
+		return fmt.Errorf("federation: could not verify page of peer %q: %v", peer.Origin, err)
+	}
+
+	pageVerifications.Inc(peer.Origin, "ok")
+	p.cfg.Logger.Info("Page of peer verified.",
+		diag.F("origin", peer.Origin),
+		diag.F("cursor", cursor),
+		diag.F("count", keys.Len()),
+	)
+	return nil
+}
+
+// store stores diagKeys, tagged with origin if the repository supports it.
+func (p *Puller) store(ctx context.Context, diagKeys []diag.DiagnosisKey, origin string) error {
+	uploadedAt := time.Now().UTC()
+	if storer, ok := p.cfg.Repository.(diag.OriginStorer); ok {
+		return storer.StoreDiagnosisKeysWithOrigin(ctx, diagKeys, uploadedAt, origin)
+	}
+	return p.cfg.Repository.StoreDiagnosisKeys(ctx, diagKeys, uploadedAt)
+}
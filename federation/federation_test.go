@@ -0,0 +1,412 @@
+package federation
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// testPeer serves its keys like the paginated `/diagnosis-keys` listing, with
+// the index of the next key as cursor. If signer is set, pages are signed.
+type testPeer struct {
+	mu           sync.Mutex
+	keys         []diag.DiagnosisKey
+	requests     []string
+	signer       crypto.Signer
+	lastModified time.Time
+}
+
+func (tp *testPeer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.requests = append(tp.requests, r.URL.RawQuery)
+
+	start, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	end := start + limit
+	if end > len(tp.keys) {
+		end = len(tp.keys)
+	}
+	w.Header().Set("X-Next-Cursor", strconv.Itoa(end))
+	if !tp.lastModified.IsZero() {
+		w.Header().Set("Last-Modified", tp.lastModified.UTC().Format(http.TimeFormat))
+	}
+	if end < len(tp.keys) {
+		w.Header().Set("Link", `</diagnosis-keys?cursor=`+strconv.Itoa(end)+`>; rel="next"`)
+	}
+	var buf bytes.Buffer
+	diag.WriteDiagnosisKeys(&buf, tp.keys[start:end]...)
+	if tp.signer != nil {
+		digest := sha256.Sum256(buf.Bytes())
+		signature, _ := tp.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		w.Header().Set(SignatureHeader, base64.StdEncoding.EncodeToString(signature))
+	}
+	w.Write(buf.Bytes())
+}
+
+func (tp *testPeer) add(keys ...diag.DiagnosisKey) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.keys = append(tp.keys, keys...)
+}
+
+// testRepository records the origin of stored keys.
+type testRepository struct {
+	origins map[[16]byte]string
+}
+
+func (tr *testRepository) StoreDiagnosisKeys(ctx context.Context, diagKeys []diag.DiagnosisKey, uploadedAt time.Time) error {
+	return tr.StoreDiagnosisKeysWithOrigin(ctx, diagKeys, uploadedAt, "")
+}
+
+func (tr *testRepository) StoreDiagnosisKeysWithOrigin(_ context.Context, diagKeys []diag.DiagnosisKey, _ time.Time, origin string) error {
+	for _, diagKey := range diagKeys {
+		if _, ok := tr.origins[diagKey.TemporaryExposureKey]; !ok {
+			tr.origins[diagKey.TemporaryExposureKey] = origin
+		}
+	}
+	return nil
+}
+
+func (tr *testRepository) FindAllDiagnosisKeys(context.Context) ([]diag.DiagnosisKey, error) {
+	return nil, nil
+}
+
+func (tr *testRepository) LastModified(context.Context) (time.Time, error) {
+	return time.Time{}, diag.ErrNilDiagKeys
+}
+
+func TestPull(t *testing.T) {
+	de, be := &testPeer{}, &testPeer{}
+	for i := byte(1); i <= 5; i++ {
+		de.add(diag.DiagnosisKey{TemporaryExposureKey: [16]byte{'d', i}, RollingStartNumber: uint32(i)})
+	}
+	// BE federates a key of DE as well.
+	be.add(diag.DiagnosisKey{TemporaryExposureKey: [16]byte{'b', 1}}, diag.DiagnosisKey{TemporaryExposureKey: [16]byte{'d', 1}})
+
+	deSrv, beSrv := httptest.NewServer(de), httptest.NewServer(be)
+	defer deSrv.Close()
+	defer beSrv.Close()
+
+	peers, err := ParsePeers("DE=" + deSrv.URL + ", BE=" + beSrv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &testRepository{origins: make(map[[16]byte]string)}
+	p, err := New(Config{Repository: repo, Peers: peers, Logger: diag.NewNopLogger(), PageSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := p.Pull(ctx); err != nil {
+		t.Fatal(err)
+	}
+	exp := map[[16]byte]string{
+		{'d', 1}: "DE", {'d', 2}: "DE", {'d', 3}: "DE", {'d', 4}: "DE", {'d', 5}: "DE",
+		{'b', 1}: "BE",
+	}
+	if !reflect.DeepEqual(repo.origins, exp) {
+		t.Errorf("expected: %v, got: %v", exp, repo.origins)
+	}
+	if exp := []string{"limit=2", "cursor=2&limit=2", "cursor=4&limit=2"}; !reflect.DeepEqual(de.requests, exp) {
+		t.Errorf("expected: %v, got: %v", exp, de.requests)
+	}
+
+	// The next pull resumes after the last pulled key.
+	de.add(diag.DiagnosisKey{TemporaryExposureKey: [16]byte{'d', 6}})
+	de.requests = nil
+	if err := p.Pull(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := repo.origins[[16]byte{'d', 6}]; got != "DE" {
+		t.Errorf("expected: DE, got: %q", got)
+	}
+	if exp := []string{"cursor=5&limit=2"}; !reflect.DeepEqual(de.requests, exp) {
+		t.Errorf("expected: %v, got: %v", exp, de.requests)
+	}
+}
+
+func TestPullFailingPeer(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	be := &testPeer{}
+	be.add(diag.DiagnosisKey{TemporaryExposureKey: [16]byte{'b', 1}})
+	beSrv := httptest.NewServer(be)
+	defer beSrv.Close()
+
+	repo := &testRepository{origins: make(map[[16]byte]string)}
+	p, err := New(Config{
+		Repository: repo,
+		Peers:      []Peer{{Origin: "DE", URL: failing.URL}, {Origin: "BE", URL: beSrv.URL}},
+		Logger:     diag.NewNopLogger(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Other peers are still pulled.
+	if err := p.Pull(context.Background()); err == nil {
+		t.Error("expected error")
+	}
+	if got := repo.origins[[16]byte{'b', 1}]; got != "BE" {
+		t.Errorf("expected: BE, got: %q", got)
+	}
+}
+
+// testSchedule returns the times of next, in order, and then the zero time.
+type testSchedule struct {
+	mu   sync.Mutex
+	next []time.Duration
+}
+
+func (s *testSchedule) Next(t time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.next) == 0 {
+		return time.Time{}
+	}
+	d := s.next[0]
+	s.next = s.next[1:]
+	return t.Add(d)
+}
+
+func TestRunSchedule(t *testing.T) {
+	peer := &testPeer{}
+	srv := httptest.NewServer(peer)
+	defer srv.Close()
+
+	p, err := New(Config{
+		Repository: &testRepository{origins: make(map[[16]byte]string)},
+		Peers:      []Peer{{Origin: "DE", URL: srv.URL}},
+		Logger:     diag.NewNopLogger(),
+		Interval:   time.Hour,
+		Schedule:   &testSchedule{next: []time.Duration{10 * time.Millisecond}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	// Pulled on startup and at the scheduled time, not every Interval; the
+	// schedule then never matches again.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		peer.mu.Lock()
+		n := len(peer.requests)
+		peer.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 2 pulls, got: %v", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestPullVerify(t *testing.T) {
+	deKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	de := &testPeer{signer: deKey}
+	de.add(diag.DiagnosisKey{TemporaryExposureKey: [16]byte{'d', 1}}, diag.DiagnosisKey{TemporaryExposureKey: [16]byte{'d', 2}})
+	// BE signs with a key other than the pinned one, and FR doesn't sign.
+	be, fr := &testPeer{signer: otherKey}, &testPeer{}
+	be.add(diag.DiagnosisKey{TemporaryExposureKey: [16]byte{'b', 1}})
+	fr.add(diag.DiagnosisKey{TemporaryExposureKey: [16]byte{'f', 1}})
+
+	deSrv, beSrv, frSrv := httptest.NewServer(de), httptest.NewServer(be), httptest.NewServer(fr)
+	defer deSrv.Close()
+	defer beSrv.Close()
+	defer frSrv.Close()
+
+	repo := &testRepository{origins: make(map[[16]byte]string)}
+	p, err := New(Config{
+		Repository: repo,
+		Peers:      []Peer{{Origin: "DE", URL: deSrv.URL}, {Origin: "BE", URL: beSrv.URL}, {Origin: "FR", URL: frSrv.URL}},
+		Logger:     diag.NewNopLogger(),
+		PageSize:   1,
+		Verifier: SignatureVerifier{
+			"DE": &deKey.PublicKey,
+			"BE": &deKey.PublicKey,
+			"FR": &deKey.PublicKey,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Pull(context.Background()); err == nil {
+		t.Error("expected error")
+	}
+	exp := map[[16]byte]string{{'d', 1}: "DE", {'d', 2}: "DE"}
+	if !reflect.DeepEqual(repo.origins, exp) {
+		t.Errorf("expected: %v, got: %v", exp, repo.origins)
+	}
+
+	// Refused pages are pulled again with the next pull.
+	be.requests = nil
+	if err := p.Pull(context.Background()); err == nil {
+		t.Error("expected error")
+	}
+	if exp := []string{"limit=1"}; !reflect.DeepEqual(be.requests, exp) {
+		t.Errorf("expected: %v, got: %v", exp, be.requests)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	published := time.Date(2020, time.May, 4, 12, 0, 0, 0, time.UTC)
+	de := &testPeer{lastModified: published}
+	de.add(diag.DiagnosisKey{TemporaryExposureKey: [16]byte{'d', 1}}, diag.DiagnosisKey{TemporaryExposureKey: [16]byte{'d', 2}})
+	be := &testPeer{lastModified: published}
+	be.add(diag.DiagnosisKey{TemporaryExposureKey: [16]byte{'b', 1}})
+
+	deSrv, beSrv := httptest.NewServer(de), httptest.NewServer(be)
+	defer deSrv.Close()
+	defer beSrv.Close()
+
+	refused := "BE"
+	p, err := New(Config{
+		Repository: &testRepository{origins: make(map[[16]byte]string)},
+		Peers:      []Peer{{Origin: "DE", URL: deSrv.URL}, {Origin: "BE", URL: beSrv.URL}},
+		Logger:     diag.NewNopLogger(),
+		Verifier: VerifierFunc(func(peer Peer, _ http.Header, _ []byte) error {
+			if peer.Origin == refused {
+				return errors.New("invalid signature")
+			}
+			return nil
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Peers are listed before the first pull.
+	statuses := p.Status()
+	if len(statuses) != 2 || statuses[0].Origin != "DE" || statuses[1].Origin != "BE" || statuses[0].LastAttempt != nil {
+		t.Fatalf("unexpected statuses: %+v", statuses)
+	}
+
+	p.Pull(context.Background())
+	statuses = p.Status()
+	deStatus, beStatus := statuses[0], statuses[1]
+	if deStatus.LastSuccess == nil || deStatus.LastError != "" || deStatus.ImportedKeys != 2 {
+		t.Errorf("unexpected status of DE: %+v", deStatus)
+	}
+	if deStatus.LagSeconds == nil || *deStatus.LagSeconds != 0 {
+		t.Errorf("expected no lag of DE, got: %v", deStatus.LagSeconds)
+	}
+	if beStatus.LastAttempt == nil || beStatus.LastSuccess != nil || beStatus.LastError == "" || beStatus.VerificationFailures != 1 {
+		t.Errorf("unexpected status of BE: %+v", beStatus)
+	}
+	if beStatus.LagSeconds != nil {
+		t.Errorf("expected unknown lag of BE, got: %v", *beStatus.LagSeconds)
+	}
+
+	// DE publishes new keys, which are refused; the lag grows with the
+	// advertised publication time.
+	refused = "DE"
+	de.mu.Lock()
+	de.lastModified = published.Add(time.Hour)
+	de.mu.Unlock()
+	de.add(diag.DiagnosisKey{TemporaryExposureKey: [16]byte{'d', 3}})
+
+	p.Pull(context.Background())
+	statuses = p.Status()
+	deStatus, beStatus = statuses[0], statuses[1]
+	if deStatus.LagSeconds == nil || *deStatus.LagSeconds != 3600 {
+		t.Errorf("expected lag of DE: 3600, got: %v", deStatus.LagSeconds)
+	}
+	if deStatus.LastError == "" || deStatus.ImportedKeys != 2 {
+		t.Errorf("unexpected status of DE: %+v", deStatus)
+	}
+	if beStatus.LastSuccess == nil || beStatus.LastError != "" || beStatus.ImportedKeys != 1 {
+		t.Errorf("unexpected status of BE: %+v", beStatus)
+	}
+}
+
+func TestSignatureVerifier(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte("page")
+	digest := sha256.Sum256(body)
+	signature, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{SignatureHeader: {base64.StdEncoding.EncodeToString(signature)}}
+	sv := SignatureVerifier{"DE": &key.PublicKey}
+
+	if err := sv.VerifyPage(Peer{Origin: "DE"}, header, body); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		peer   Peer
+		header http.Header
+		body   []byte
+	}{
+		{"unpinned peer", Peer{Origin: "BE"}, header, body},
+		{"missing signature", Peer{Origin: "DE"}, http.Header{}, body},
+		{"invalid encoding", Peer{Origin: "DE"}, http.Header{SignatureHeader: {"%"}}, body},
+		{"tampered body", Peer{Origin: "DE"}, header, []byte("pagf")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := sv.VerifyPage(tt.peer, tt.header, tt.body); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestParsePeers(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"https://diag.example.de",
+		"=https://diag.example.de",
+		"DE=diag.example.de",
+		"DE=ftp://diag.example.de",
+		"DE=https://diag.example.de,DE=https://diag2.example.de",
+	} {
+		if _, err := ParsePeers(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
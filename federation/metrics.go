@@ -0,0 +1,51 @@
+package federation
+
+import "github.com/dstotijn/ct-diag-server/metrics"
+
+var (
+	pulls = metrics.DefaultRegistry.Counter(
+		"ctdiag_federation_pulls_total",
+		"Total number of pulls from peer servers, by origin and result (`ok` or `error`).",
+		"origin", "result",
+	)
+	pulledKeys = metrics.DefaultRegistry.Counter(
+		"ctdiag_federation_pulled_keys_total",
+		"Total number of Diagnosis Keys pulled from peer servers and stored, by origin. Keys that were already stored are included.",
+		"origin",
+	)
+	pageVerifications = metrics.DefaultRegistry.Counter(
+		"ctdiag_federation_page_verifications_total",
+		"Total number of verified pages pulled from peer servers, by origin and result (`ok` or `refused`).",
+		"origin", "result",
+	)
+	expiredKeys = metrics.DefaultRegistry.Counter(
+		"ctdiag_federation_pulled_expired_keys_total",
+		"Total number of Diagnosis Keys pulled from peer servers and dropped for exceeding the maximum federated key age, by origin.",
+		"origin",
+	)
+	peerLastSync = metrics.DefaultRegistry.Gauge(
+		"ctdiag_federation_peer_last_sync_timestamp_seconds",
+		"Unix time of the last pull that synced all pages of a peer server, by origin.",
+		"origin",
+	)
+	peerLag = metrics.DefaultRegistry.Gauge(
+		"ctdiag_federation_peer_lag_seconds",
+		"Seconds the synced keys of a peer server lag behind the publication time (`Last-Modified`) its listing advertises, by origin.",
+		"origin",
+	)
+	pushes = metrics.DefaultRegistry.Counter(
+		"ctdiag_federation_pushes_total",
+		"Total number of pushes to peer servers, by origin and result (`ok` or `error`).",
+		"origin", "result",
+	)
+	pushedKeys = metrics.DefaultRegistry.Counter(
+		"ctdiag_federation_pushed_keys_total",
+		"Total number of local Diagnosis Keys pushed to peer servers, by origin.",
+		"origin",
+	)
+	pushRetries = metrics.DefaultRegistry.Counter(
+		"ctdiag_federation_push_retries_total",
+		"Total number of retried push requests, by origin.",
+		"origin",
+	)
+)
@@ -0,0 +1,320 @@
+package federation
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// Headers of pushes. The signature is the (base64 encoded) ASN.1 ECDSA
+// signature of the SHA-256 digest of the body, like the `X-Signature` header
+// of signed responses.
+const (
+	OriginHeader    = "X-Federation-Origin"
+	SignatureHeader = "X-Signature"
+)
+
+// MaxBatchSize is the maximum amount of keys per push.
+const MaxBatchSize = 10000
+
+// Defaults of PushConfig.
+const (
+	defaultMaxRetries = 4
+	defaultRetryDelay = time.Second
+)
+
+// PushConfig represents the configuration to create a Pusher.
+type PushConfig struct {
+	// Repository must implement diag.LocalKeyFinder.
+	Repository diag.Repository
+	// Peers are the servers keys are pushed to, identified by their origin.
+	Peers []Peer
+	// Origin is the region of this server, with which peers tag the pushed
+	// keys.
+	Origin string
+	// Signer signs pushes, so peers can verify their origin.
+	Signer crypto.Signer
+	Logger diag.Logger
+	// Interval is the interval between pushes. Defaults to an hour.
+	Interval time.Duration
+	// Schedule, if set, replaces Interval: pushes run at its next times.
+	Schedule diag.Schedule
+	// BatchSize is the maximum amount of keys per request. Defaults to (and
+	// can't exceed) MaxBatchSize.
+	BatchSize int
+	// CheckpointPath, if set, is the file the index of the last key pushed to
+	// each peer is kept in, so pushes resume where they left off after a
+	// restart. Otherwise, checkpoints are kept in memory, and all local keys
+	// are pushed again after a restart; peers ignore keys they already
+	// stored.
+	CheckpointPath string
+	// MaxRetries is the amount of times a failed request is retried, with an
+	// exponential backoff starting at RetryDelay. Defaults to 4 retries,
+	// starting at a second.
+	MaxRetries int
+	RetryDelay time.Duration
+	// Client is used for requests to peers. Defaults to a client with a
+	// timeout of a minute.
+	Client *http.Client
+}
+
+// Pusher pushes the Diagnosis Keys uploaded to this server, i.e. keys without
+// origin, to peers, in batches of new keys since the last push.
+type Pusher struct {
+	cfg    PushConfig
+	finder diag.LocalKeyFinder
+
+	mu          sync.Mutex
+	checkpoints map[string]int64
+}
+
+// NewPusher returns a new Pusher. Checkpoints are loaded from
+// PushConfig.CheckpointPath, if it exists.
+func NewPusher(cfg PushConfig) (*Pusher, error) {
+	finder, ok := cfg.Repository.(diag.LocalKeyFinder)
+	if !ok {
+		return nil, errors.New("federation: repository doesn't support listing local keys")
+	}
+	if cfg.Origin == "" {
+		return nil, errors.New("federation: origin cannot be empty")
+	}
+	if cfg.Signer == nil {
+		return nil, errors.New("federation: signer cannot be nil")
+	}
+	if cfg.Logger == nil {
+		return nil, errors.New("federation: logger cannot be nil")
+	}
+	if cfg.BatchSize > MaxBatchSize {
+		return nil, fmt.Errorf("federation: batch size cannot exceed %v", MaxBatchSize)
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = MaxBatchSize
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = defaultMaxRetries
+	}
+	if cfg.RetryDelay == 0 {
+		cfg.RetryDelay = defaultRetryDelay
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: defaultTimeout}
+	}
+
+	checkpoints := make(map[string]int64)
+	if cfg.CheckpointPath != "" {
+		buf, err := ioutil.ReadFile(cfg.CheckpointPath)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return nil, fmt.Errorf("federation: could not read checkpoints: %v", err)
+		default:
+			if err := json.Unmarshal(buf, &checkpoints); err != nil {
+				return nil, fmt.Errorf("federation: could not parse checkpoints: %v", err)
+			}
+		}
+	}
+
+	return &Pusher{cfg: cfg, finder: finder, checkpoints: checkpoints}, nil
+}
+
+// Run pushes to all peers on startup, and then every PushConfig.Interval (or
+// at the times of PushConfig.Schedule), until ctx is done.
+func (p *Pusher) Run(ctx context.Context) {
+	var tick <-chan time.Time
+	if p.cfg.Schedule == nil {
+		t := time.NewTicker(p.cfg.Interval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	for {
+		if err := p.Push(ctx); err != nil && ctx.Err() == nil {
+			p.cfg.Logger.Error("Could not push diagnosis keys to peers.", diag.Err(err))
+		}
+
+		if !waitNext(ctx, p.cfg.Schedule, tick) {
+			return
+		}
+	}
+}
+
+// Push pushes the local keys stored since the previous push to each peer. A
+// failing peer doesn't prevent pushing to the others; the first error is
+// returned.
+func (p *Pusher) Push(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var firstErr error
+	for _, peer := range p.cfg.Peers {
+		n, err := p.pushPeer(ctx, peer)
+		result := "ok"
+		if err != nil {
+			result = "error"
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		pushes.Inc(peer.Origin, result)
+		pushedKeys.Add(float64(n), peer.Origin)
+
+		p.cfg.Logger.Info("Diagnosis keys pushed to peer.",
+			diag.F("origin", peer.Origin),
+			diag.F("count", n),
+			diag.F("ok", err == nil),
+		)
+	}
+
+	return firstErr
+}
+
+// pushPeer pushes all local keys after the checkpoint of peer in batches, and
+// returns the amount of pushed keys. The checkpoint advances with every
+// pushed batch, so a failed push resumes where it left off.
+func (p *Pusher) pushPeer(ctx context.Context, peer Peer) (int, error) {
+	var pushed int
+	for {
+		diagKeys, err := p.finder.FindLocalDiagnosisKeys(ctx, p.checkpoints[peer.Origin], p.cfg.BatchSize)
+		if err != nil {
+			return pushed, fmt.Errorf("federation: could not find keys to push: %v", err)
+		}
+		if len(diagKeys) == 0 {
+			return pushed, nil
+		}
+
+		var buf bytes.Buffer
+		for _, diagKey := range diagKeys {
+			diag.WriteDiagnosisKeys(&buf, diagKey.DiagnosisKey)
+		}
+		if err := p.send(ctx, peer, buf.Bytes()); err != nil {
+			return pushed, err
+		}
+		pushed += len(diagKeys)
+
+		p.checkpoints[peer.Origin] = diagKeys[len(diagKeys)-1].Index
+		if err := p.saveCheckpoints(); err != nil {
+			return pushed, err
+		}
+		if len(diagKeys) < p.cfg.BatchSize {
+			return pushed, nil
+		}
+	}
+}
+
+// send POSTs a signed batch to peer, and retries with an exponential backoff
+// if the request fails or the peer is unavailable. Other errors of the peer,
+// e.g. a rejected signature, aren't retried.
+func (p *Pusher) send(ctx context.Context, peer Peer, body []byte) error {
+	digest := sha256.Sum256(body)
+	signature, err := p.cfg.Signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("federation: could not sign push: %v", err)
+	}
+
+	delay := p.cfg.RetryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := p.post(ctx, peer, body, signature)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt == p.cfg.MaxRetries {
+			return err
+		}
+
+		pushRetries.Inc(peer.Origin)
+		p.cfg.Logger.Warn("Push to peer failed, retrying.",
+			diag.F("origin", peer.Origin),
+			diag.F("delay", delay.String()),
+			diag.Err(err),
+		)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post POSTs a single push request, and returns whether it can be retried if
+// it failed.
+func (p *Pusher) post(ctx context.Context, peer Peer, body, signature []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, peer.URL+"/federation/diagnosis-keys", bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("federation: could not create request for peer %q: %v", peer.Origin, err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(OriginHeader, p.cfg.Origin)
+	req.Header.Set(SignatureHeader, base64.StdEncoding.EncodeToString(signature))
+
+	resp, err := p.cfg.Client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, fmt.Errorf("federation: could not push to peer %q: %v", peer.Origin, err)
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("federation: unexpected response of peer %q: %v", peer.Origin, resp.Status)
+	default:
+		return false, fmt.Errorf("federation: push rejected by peer %q: %v", peer.Origin, resp.Status)
+	}
+}
+
+// saveCheckpoints writes the checkpoints to PushConfig.CheckpointPath, if
+// set. The checkpoints are written to a temporary file first, so the existing
+// file is replaced atomically.
+func (p *Pusher) saveCheckpoints() error {
+	if p.cfg.CheckpointPath == "" {
+		return nil
+	}
+
+	buf, err := json.Marshal(p.checkpoints)
+	if err != nil {
+		return fmt.Errorf("federation: could not encode checkpoints: %v", err)
+	}
+	tmp := p.cfg.CheckpointPath + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		return fmt.Errorf("federation: could not write checkpoints: %v", err)
+	}
+	if err := os.Rename(tmp, p.cfg.CheckpointPath); err != nil {
+		return fmt.Errorf("federation: could not write checkpoints: %v", err)
+	}
+	return nil
+}
+
+// VerifyPush returns the origin of a push with header h and body, if it's
+// signed by the key of that origin in senders.
+func VerifyPush(senders map[string]*ecdsa.PublicKey, h http.Header, body []byte) (string, error) {
+	origin := h.Get(OriginHeader)
+	pub, ok := senders[origin]
+	if !ok {
+		return "", fmt.Errorf("federation: unknown origin %q", origin)
+	}
+	if err := verifySignature(pub, h, body); err != nil {
+		return "", err
+	}
+	return origin, nil
+}
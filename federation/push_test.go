@@ -0,0 +1,149 @@
+package federation
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// testLocalRepository lists its keys as local keys, indexed from 1.
+type testLocalRepository struct {
+	testRepository
+	keys []diag.DiagnosisKey
+}
+
+func (tr *testLocalRepository) FindLocalDiagnosisKeys(_ context.Context, after int64, limit int) ([]diag.StoredDiagnosisKey, error) {
+	var diagKeys []diag.StoredDiagnosisKey
+	for i := after; i < int64(len(tr.keys)) && len(diagKeys) < limit; i++ {
+		diagKeys = append(diagKeys, diag.StoredDiagnosisKey{DiagnosisKey: tr.keys[i], Index: i + 1})
+	}
+	return diagKeys, nil
+}
+
+// testReceiver verifies pushes, and responds with the next status of
+// statuses, or `200 OK` once they're used up.
+type testReceiver struct {
+	senders map[string]*ecdsa.PublicKey
+
+	mu       sync.Mutex
+	statuses []int
+	requests int
+	keys     map[[16]byte]string
+}
+
+func (tr *testReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.requests++
+
+	if len(tr.statuses) > 0 {
+		status := tr.statuses[0]
+		tr.statuses = tr.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	origin, err := VerifyPush(tr.senders, r.Header, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	keys, _ := diag.ReadEncodedKeys(bytes.NewReader(body))
+	for i := 0; i < keys.Len(); i++ {
+		tr.keys[keys.TemporaryExposureKey(i)] = origin
+	}
+}
+
+func newTestPusher(t *testing.T, repo diag.Repository, url, checkpointPath string) (*Pusher, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPusher(PushConfig{
+		Repository:     repo,
+		Peers:          []Peer{{Origin: "BE", URL: url}},
+		Origin:         "NL",
+		Signer:         key,
+		Logger:         diag.NewNopLogger(),
+		BatchSize:      2,
+		CheckpointPath: checkpointPath,
+		RetryDelay:     time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, key
+}
+
+func TestPush(t *testing.T) {
+	repo := &testLocalRepository{}
+	for i := byte(1); i <= 3; i++ {
+		repo.keys = append(repo.keys, diag.DiagnosisKey{TemporaryExposureKey: [16]byte{'n', i}})
+	}
+	recv := &testReceiver{keys: make(map[[16]byte]string)}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoints.json")
+	p, key := newTestPusher(t, repo, srv.URL, checkpointPath)
+	recv.senders = map[string]*ecdsa.PublicKey{"NL": &key.PublicKey}
+
+	// Unavailable peers are retried.
+	recv.statuses = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+	ctx := context.Background()
+	if err := p.Push(ctx); err != nil {
+		t.Fatal(err)
+	}
+	exp := map[[16]byte]string{{'n', 1}: "NL", {'n', 2}: "NL", {'n', 3}: "NL"}
+	if !reflect.DeepEqual(recv.keys, exp) {
+		t.Errorf("expected: %v, got: %v", exp, recv.keys)
+	}
+	if exp := 4; recv.requests != exp {
+		t.Errorf("expected %v requests, got: %v", exp, recv.requests)
+	}
+
+	// A new pusher resumes at the checkpoint, so only new keys are pushed.
+	repo.keys = append(repo.keys, diag.DiagnosisKey{TemporaryExposureKey: [16]byte{'n', 4}})
+	p, key = newTestPusher(t, repo, srv.URL, checkpointPath)
+	recv.senders["NL"] = &key.PublicKey
+	recv.keys = make(map[[16]byte]string)
+	if err := p.Push(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if exp := map[[16]byte]string{{'n', 4}: "NL"}; !reflect.DeepEqual(recv.keys, exp) {
+		t.Errorf("expected: %v, got: %v", exp, recv.keys)
+	}
+}
+
+func TestPushRejected(t *testing.T) {
+	repo := &testLocalRepository{keys: []diag.DiagnosisKey{{TemporaryExposureKey: [16]byte{'n', 1}}}}
+	// The receiver doesn't know the key of the pusher.
+	recv := &testReceiver{keys: make(map[[16]byte]string)}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+
+	p, _ := newTestPusher(t, repo, srv.URL, "")
+	if err := p.Push(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+	// Rejected pushes aren't retried, and the checkpoint doesn't advance.
+	if exp := 1; recv.requests != exp {
+		t.Errorf("expected %v requests, got: %v", exp, recv.requests)
+	}
+	if got := p.checkpoints["BE"]; got != 0 {
+		t.Errorf("expected checkpoint 0, got: %v", got)
+	}
+}
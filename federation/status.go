@@ -0,0 +1,87 @@
+package federation
+
+import (
+	"net/http"
+	"time"
+)
+
+// PeerStatus is the sync status of a peer, for noticing broken exchanges.
+type PeerStatus struct {
+	Origin string `json:"origin"`
+	URL    string `json:"url"`
+	// LastAttempt is the time of the last pull, and LastSuccess of the last
+	// pull that synced all pages of the peer; nil if there was none yet.
+	LastAttempt *time.Time `json:"lastAttempt"`
+	LastSuccess *time.Time `json:"lastSuccess"`
+	// LastError is the error of the last pull, or empty if it succeeded.
+	LastError string `json:"lastError,omitempty"`
+	// ImportedKeys is the amount of keys pulled and stored since startup, and
+	// VerificationFailures the amount of refused pages (see Verifier).
+	ImportedKeys         int64 `json:"importedKeys"`
+	VerificationFailures int64 `json:"verificationFailures"`
+	// PeerLastModified is the publication time of the listing of the peer,
+	// as advertised by its last response (`Last-Modified` header), and
+	// SyncedLastModified the one of the last fully synced listing.
+	PeerLastModified   *time.Time `json:"peerLastModified"`
+	SyncedLastModified *time.Time `json:"syncedLastModified"`
+	// LagSeconds is the time between both, i.e. how far the synced keys lag
+	// behind the peer; nil until the peer was synced once.
+	LagSeconds *float64 `json:"lagSeconds"`
+}
+
+// Status returns the sync status of all peers, in configuration order. It's
+// safe to call while pulling.
+func (p *Puller) Status() []PeerStatus {
+	p.statusMu.Lock()
+	defer p.statusMu.Unlock()
+
+	statuses := make([]PeerStatus, 0, len(p.cfg.Peers))
+	for _, peer := range p.cfg.Peers {
+		status := *p.statuses[peer.Origin]
+		if lag, ok := status.lag(); ok {
+			status.LagSeconds = &lag
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// updateStatus calls fn with the status of the peer with the given origin,
+// and updates the sync metrics of the peer.
+func (p *Puller) updateStatus(origin string, fn func(*PeerStatus)) {
+	p.statusMu.Lock()
+	defer p.statusMu.Unlock()
+
+	status := p.statuses[origin]
+	fn(status)
+
+	if status.LastSuccess != nil {
+		peerLastSync.Set(float64(status.LastSuccess.Unix()), origin)
+	}
+	if lag, ok := status.lag(); ok {
+		peerLag.Set(lag, origin)
+	}
+}
+
+// lag returns the seconds between the advertised and the synced publication
+// time of the listing of the peer, and false if the peer wasn't synced yet.
+func (s *PeerStatus) lag() (float64, bool) {
+	if s.PeerLastModified == nil || s.SyncedLastModified == nil {
+		return 0, false
+	}
+	lag := s.PeerLastModified.Sub(*s.SyncedLastModified).Seconds()
+	if lag < 0 {
+		lag = 0
+	}
+	return lag, true
+}
+
+// lastModified returns the `Last-Modified` header of h, or nil if it's
+// missing or invalid.
+func lastModified(h http.Header) *time.Time {
+	t, err := http.ParseTime(h.Get("Last-Modified"))
+	if err != nil {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,58 @@
+package federation
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Verifier verifies the pages pulled from peers before their keys are stored,
+// so a compromised peer can't inject keys unnoticed. Pages failing
+// verification are refused, and pulled again with the next pull.
+type Verifier interface {
+	// VerifyPage returns an error if the page of peer, with the given
+	// response header and body, can't be verified.
+	VerifyPage(peer Peer, header http.Header, body []byte) error
+}
+
+// VerifierFunc is an adapter to use a function as Verifier.
+type VerifierFunc func(peer Peer, header http.Header, body []byte) error
+
+// VerifyPage implements Verifier.
+func (fn VerifierFunc) VerifyPage(peer Peer, header http.Header, body []byte) error {
+	return fn(peer, header, body)
+}
+
+// SignatureVerifier verifies the `X-Signature` header of pages against the
+// public keys pinned for each peer, by origin. Pages of peers without a
+// pinned key are refused.
+type SignatureVerifier map[string]*ecdsa.PublicKey
+
+// VerifyPage implements Verifier.
+func (sv SignatureVerifier) VerifyPage(peer Peer, header http.Header, body []byte) error {
+	pub, ok := sv[peer.Origin]
+	if !ok {
+		return fmt.Errorf("federation: no public key pinned for peer %q", peer.Origin)
+	}
+	return verifySignature(pub, header, body)
+}
+
+// verifySignature verifies the signature of body in the `X-Signature` header
+// of h, by pub.
+func verifySignature(pub *ecdsa.PublicKey, h http.Header, body []byte) error {
+	if h.Get(SignatureHeader) == "" {
+		return errors.New("federation: missing signature")
+	}
+	signature, err := base64.StdEncoding.DecodeString(h.Get(SignatureHeader))
+	if err != nil {
+		return errors.New("federation: invalid signature encoding")
+	}
+	digest := sha256.Sum256(body)
+	if !ecdsa.VerifyASN1(pub, digest[:], signature) {
+		return errors.New("federation: invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/config"
+	"github.com/dstotijn/ct-diag-server/diag"
+	"github.com/dstotijn/ct-diag-server/diag/zaplog"
+
+	"go.uber.org/zap"
+)
+
+// Exit codes of `ct-diag-server fsck`, besides 1 for scans that couldn't be
+// completed.
+const (
+	fsckExitInvalidConfig = 2
+	fsckExitViolations    = 3
+)
+
+// runFsck runs `ct-diag-server fsck`: it scans the stored Diagnosis Keys for
+// invariant violations (see diag.CheckDiagnosisKey), e.g. after imports or
+// manual database surgery, and reports them on stdout. With `-quarantine`,
+// violating keys are moved to the `quarantined_keys` table. It returns the
+// exit code.
+func runFsck(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	quarantine := fs.Bool("quarantine", false, "Move diagnosis keys violating an invariant to the `quarantined_keys` table")
+
+	cfg, err := config.Load(fs, args, os.LookupEnv)
+	if err == nil {
+		_, err = cfg.FetchSecrets(ctx)
+	}
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return fsckExitInvalidConfig
+	}
+
+	logger, err := newLogger(cfg.Dev)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer logger.Sync()
+
+	repo, err := openRepository(ctx, cfg, cfg.DB, nil, zaplog.New(logger), logger)
+	if err != nil {
+		logger.Error("Could not open database.", zap.String("backend", cfg.DB), zap.Error(err))
+		return 1
+	}
+	defer repo.Close()
+
+	now := time.Now()
+	violations, err := diag.Fsck(ctx, repo, now, cfg.KeyClockSkew)
+	if err != nil {
+		logger.Error("Could not scan diagnosis keys.", zap.Error(err))
+		return 1
+	}
+	for _, v := range violations {
+		fmt.Printf("%v\t%v\t%v\n", hex.EncodeToString(v.Key.TemporaryExposureKey), v.Key.UploadedAt.Format(time.RFC3339), v.Reason)
+	}
+
+	if len(violations) == 0 {
+		logger.Info("No violations found.")
+		return 0
+	}
+	if !*quarantine {
+		logger.Warn("Violations found; run with `-quarantine` to move the keys to the `quarantined_keys` table.",
+			zap.Int("violations", len(violations)))
+		return fsckExitViolations
+	}
+
+	n, err := repo.QuarantineDiagnosisKeys(ctx, violations, now)
+	if err != nil {
+		logger.Error("Could not quarantine diagnosis keys.", zap.Error(err))
+		return 1
+	}
+	logger.Info("Diagnosis keys quarantined.", zap.Int("violations", len(violations)), zap.Int64("quarantined", n))
+
+	return 0
+}
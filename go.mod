@@ -1,8 +1,10 @@
 module github.com/dstotijn/ct-diag-server
 
-go 1.14
+go 1.16
 
 require (
 	github.com/lib/pq v1.3.0
+	github.com/mattn/go-sqlite3 v1.14.17
 	go.uber.org/zap v1.15.0
+	golang.org/x/crypto v0.0.0-20220214200702-86341886e292
 )
@@ -0,0 +1,227 @@
+// Package lambda serves an http.Handler as AWS Lambda function, via the Lambda
+// runtime API of custom runtimes (e.g. `provided.al2`). Invocations are events
+// of API Gateway HTTP APIs or function URLs, in payload format version 2.0.
+// Responses are buffered, so streaming responses aren't supported.
+package lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runtimeAPIVersion is the version of the Lambda runtime API.
+const runtimeAPIVersion = "2018-06-01"
+
+// event is an HTTP request, as invocation event.
+type event struct {
+	RawPath        string            `json:"rawPath"`
+	RawQueryString string            `json:"rawQueryString"`
+	Cookies        []string          `json:"cookies"`
+	Headers        map[string]string `json:"headers"`
+	RequestContext struct {
+		DomainName string `json:"domainName"`
+		HTTP       struct {
+			Method   string `json:"method"`
+			SourceIP string `json:"sourceIp"`
+		} `json:"http"`
+	} `json:"requestContext"`
+	Body            string `json:"body"`
+	IsBase64Encoded bool   `json:"isBase64Encoded"`
+}
+
+// response is an HTTP response, as invocation response. Bodies are always
+// base64 encoded, as they may be binary.
+type response struct {
+	StatusCode      int               `json:"statusCode"`
+	Headers         map[string]string `json:"headers"`
+	Cookies         []string          `json:"cookies,omitempty"`
+	Body            string            `json:"body"`
+	IsBase64Encoded bool              `json:"isBase64Encoded"`
+}
+
+// invocationError is the error of an invocation that couldn't be handled.
+type invocationError struct {
+	ErrorMessage string `json:"errorMessage"`
+	ErrorType    string `json:"errorType"`
+}
+
+// Serve serves the invocations of the function with handler, until ctx is
+// done or the runtime API fails. api is the host of the runtime API, i.e. the
+// `AWS_LAMBDA_RUNTIME_API` environment variable.
+func Serve(ctx context.Context, api string, handler http.Handler) error {
+	// Requests for the next invocation block until there is one, so the
+	// client has no timeout.
+	client := &http.Client{}
+	baseURL := "http://" + api + "/" + runtimeAPIVersion + "/runtime/invocation/"
+
+	for {
+		if err := serveNext(ctx, client, baseURL, handler); err != nil {
+			return err
+		}
+	}
+}
+
+// serveNext waits for the next invocation, and posts its response or error.
+func serveNext(ctx context.Context, client *http.Client, baseURL string, handler http.Handler) error {
+	req, err := http.NewRequest(http.MethodGet, baseURL+"next", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("lambda: could not get next invocation: %v", err)
+	}
+	buf, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("lambda: could not read next invocation: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("lambda: could not get next invocation: unexpected status %v", resp.Status)
+	}
+
+	id := resp.Header.Get("Lambda-Runtime-Aws-Request-Id")
+	invocationCtx := ctx
+	if ms, err := strconv.ParseInt(resp.Header.Get("Lambda-Runtime-Deadline-Ms"), 10, 64); err == nil {
+		var cancel context.CancelFunc
+		invocationCtx, cancel = context.WithDeadline(ctx, time.Unix(0, ms*int64(time.Millisecond)))
+		defer cancel()
+	}
+
+	out, err := invoke(invocationCtx, handler, buf)
+	if err != nil {
+		return post(ctx, client, baseURL+id+"/error", invocationError{
+			ErrorMessage: err.Error(),
+			ErrorType:    "InvalidEvent",
+		})
+	}
+	return post(ctx, client, baseURL+id+"/response", out)
+}
+
+// invoke serves the HTTP request of an invocation event with handler.
+func invoke(ctx context.Context, handler http.Handler, buf []byte) (response, error) {
+	var e event
+	if err := json.Unmarshal(buf, &e); err != nil {
+		return response{}, fmt.Errorf("lambda: invalid event: %v", err)
+	}
+	r, err := e.request(ctx)
+	if err != nil {
+		return response{}, err
+	}
+
+	rec := &recorder{header: make(http.Header)}
+	handler.ServeHTTP(rec, r)
+
+	return rec.response(), nil
+}
+
+// request returns the HTTP request of the event.
+func (e event) request(ctx context.Context) (*http.Request, error) {
+	body := []byte(e.Body)
+	if e.IsBase64Encoded {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(e.Body)
+		if err != nil {
+			return nil, fmt.Errorf("lambda: invalid event body: %v", err)
+		}
+	}
+
+	target := e.RawPath
+	if e.RawQueryString != "" {
+		target += "?" + e.RawQueryString
+	}
+	r, err := http.NewRequest(e.RequestContext.HTTP.Method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("lambda: invalid event: %v", err)
+	}
+
+	// Headers with multiple values are joined with commas.
+	for k, v := range e.Headers {
+		r.Header.Set(k, v)
+	}
+	if len(e.Cookies) > 0 {
+		r.Header.Set("Cookie", strings.Join(e.Cookies, "; "))
+	}
+	r.Host = e.RequestContext.DomainName
+	if host := r.Header.Get("Host"); host != "" {
+		r.Host = host
+		r.Header.Del("Host")
+	}
+	r.RemoteAddr = net.JoinHostPort(e.RequestContext.HTTP.SourceIP, "0")
+
+	return r.WithContext(ctx), nil
+}
+
+// recorder is an http.ResponseWriter buffering the response.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *recorder) Write(p []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(p)
+}
+
+// response returns the recorded response, as invocation response.
+func (rec *recorder) response() response {
+	resp := response{
+		StatusCode:      rec.status,
+		Headers:         make(map[string]string, len(rec.header)),
+		Body:            base64.StdEncoding.EncodeToString(rec.body.Bytes()),
+		IsBase64Encoded: true,
+	}
+	if resp.StatusCode == 0 {
+		resp.StatusCode = http.StatusOK
+	}
+	for k, v := range rec.header {
+		if k == "Set-Cookie" {
+			resp.Cookies = v
+			continue
+		}
+		resp.Headers[k] = strings.Join(v, ",")
+	}
+	return resp
+}
+
+// post posts v in JSON to the runtime API.
+func post(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("lambda: could not post invocation result: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("lambda: could not post invocation result: unexpected status %v", resp.Status)
+	}
+	return nil
+}
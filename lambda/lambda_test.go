@@ -0,0 +1,108 @@
+package lambda
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestServe(t *testing.T) {
+	events := []string{
+		`{
+			"version": "2.0",
+			"rawPath": "/diagnosis-keys",
+			"rawQueryString": "after=abc",
+			"cookies": ["a=1", "b=2"],
+			"headers": {"host": "example.com", "content-type": "application/octet-stream"},
+			"requestContext": {"domainName": "example.com", "http": {"method": "POST", "sourceIp": "192.0.2.1"}},
+			"body": "` + base64.StdEncoding.EncodeToString([]byte{0, 1, 2}) + `",
+			"isBase64Encoded": true
+		}`,
+		`not json`,
+	}
+
+	var mu sync.Mutex
+	results := make(map[string][]byte)
+	runtime := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.Method == http.MethodPost {
+			buf, _ := ioutil.ReadAll(r.Body)
+			results[r.URL.Path] = buf
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		if r.URL.Path != "/2018-06-01/runtime/invocation/next" {
+			t.Errorf("unexpected path: %v", r.URL.Path)
+		}
+		// The runtime fails once all events are served, so Serve returns.
+		if len(events) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Lambda-Runtime-Aws-Request-Id", string(rune('a'+len(results))))
+		w.Header().Set("Lambda-Runtime-Deadline-Ms", "4102444800000")
+		w.Write([]byte(events[0]))
+		events = events[1:]
+	}))
+	defer runtime.Close()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.URL.Path != "/diagnosis-keys" || r.URL.Query().Get("after") != "abc" {
+			t.Errorf("unexpected request: %v %v", r.Method, r.URL)
+		}
+		if r.Host != "example.com" || r.RemoteAddr != "192.0.2.1:0" {
+			t.Errorf("unexpected host or remote address: %v, %v", r.Host, r.RemoteAddr)
+		}
+		if c, err := r.Cookie("b"); err != nil || c.Value != "2" {
+			t.Errorf("unexpected cookie: %v, %v", c, err)
+		}
+		if string(body) != "\x00\x01\x02" {
+			t.Errorf("unexpected body: %q", body)
+		}
+		if _, ok := r.Context().Deadline(); !ok {
+			t.Error("expected deadline")
+		}
+		http.SetCookie(w, &http.Cookie{Name: "c", Value: "3"})
+		w.Header().Add("Vary", "Accept")
+		w.Header().Add("Vary", "Accept-Encoding")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("OK"))
+	})
+
+	err := Serve(context.Background(), strings.TrimPrefix(runtime.URL, "http://"), handler)
+	if err == nil || !strings.Contains(err.Error(), "unexpected status") {
+		t.Fatalf("expected runtime error, got: %v", err)
+	}
+
+	var resp response
+	if err := json.Unmarshal(results["/2018-06-01/runtime/invocation/a/response"], &resp); err != nil {
+		t.Fatal(err)
+	}
+	body, _ := base64.StdEncoding.DecodeString(resp.Body)
+	if resp.StatusCode != http.StatusCreated || string(body) != "OK" || !resp.IsBase64Encoded {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if got := resp.Headers["Vary"]; got != "Accept,Accept-Encoding" {
+		t.Errorf("expected: Accept,Accept-Encoding, got: %v", got)
+	}
+	if len(resp.Cookies) != 1 || resp.Cookies[0] != "c=3" {
+		t.Errorf("unexpected cookies: %v", resp.Cookies)
+	}
+
+	var invErr invocationError
+	if err := json.Unmarshal(results["/2018-06-01/runtime/invocation/b/error"], &invErr); err != nil {
+		t.Fatal(err)
+	}
+	if invErr.ErrorType != "InvalidEvent" {
+		t.Errorf("expected: InvalidEvent, got: %v", invErr.ErrorType)
+	}
+}
@@ -2,90 +2,246 @@ package main
 
 import (
 	"context"
+	"crypto"
+	"expvar"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
+	// Embedded time zone data, for `-scheduleTimezone` on hosts without it.
+	_ "time/tzdata"
 
-	"github.com/dstotijn/ct-diag-server/api"
+	"github.com/dstotijn/ct-diag-server/config"
 	"github.com/dstotijn/ct-diag-server/db/postgres"
+	"github.com/dstotijn/ct-diag-server/db/sqlite"
 	"github.com/dstotijn/ct-diag-server/diag"
+	"github.com/dstotijn/ct-diag-server/diag/zaplog"
+	"github.com/dstotijn/ct-diag-server/metrics"
+	"github.com/dstotijn/ct-diag-server/secrets"
 
 	"go.uber.org/zap"
 )
 
+// shutdownTimeout is the time to wait for active connections on shutdown.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	ctx := context.Background()
 
-	var (
-		addr               string
-		maxUploadBatchSize uint
-		isDev              bool
-		cacheInterval      time.Duration
-	)
-	flag.StringVar(&addr, "addr", ":80", "HTTP listen address")
-	flag.UintVar(&maxUploadBatchSize, "maxUploadBatchSize", 14, "Maximum upload batch size")
-	flag.BoolVar(&isDev, "dev", false, "Boolean indicating whether the app is running in a dev environment")
-	flag.DurationVar(&cacheInterval, "cacheInterval", 5*time.Minute, "Interval between cache refresh")
-	flag.Parse()
-
-	logger, err := newLogger(isDev)
+	if len(os.Args) > 1 && os.Args[1] == "fsck" {
+		os.Exit(runFsck(ctx, os.Args[2:]))
+	}
+
+	// Settings are taken from flags, environment variables, a config file or
+	// defaults, in that order of precedence.
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:], os.LookupEnv)
+	if serverlessBuild {
+		cfg.Serverless = true
+	}
+	var secretsWatcher *secrets.Watcher
+	if err == nil {
+		secretsWatcher, err = cfg.FetchSecrets(ctx)
+	}
+	if err == nil {
+		err = cfg.Validate()
+	}
+
+	// Report all configuration problems at once, before anything is started.
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logger, err := newLogger(cfg.Dev)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer logger.Sync()
 	zap.RedirectStdLog(logger)
+	// Recent errors are listed on the admin status page.
+	errorLog := diag.NewErrorLog(zaplog.New(logger), 50)
 
-	db, err := postgres.New(mustGetEnv("POSTGRES_DSN"))
+	a, err := newApp(ctx, cfg, secretsWatcher, errorLog, logger)
 	if err != nil {
-		logger.Fatal("Could not create PostgreSQL client.", zap.Error(err))
+		logger.Fatal("Could not start server.", zap.Error(err))
 	}
-	defer db.Close()
+	defer a.close()
 
-	err = db.Ping()
+	// Serverless instances only run while serving requests (see
+	// `-serverless`): AWS Lambda invocations are served via the runtime API,
+	// and other platforms (e.g. Cloud Run) are served HTTP on `$PORT`.
+	if cfg.Serverless {
+		if ok, err := serveLambda(ctx, a.handler); ok {
+			logger.Fatal("Lambda runtime stopped.", zap.Error(err))
+		}
+		if port := os.Getenv("PORT"); port != "" {
+			cfg.Addr = ":" + port
+		}
+	} else {
+		a.run(ctx, cfg, secretsWatcher, errorLog)
+	}
+
+	// Start the debug HTTP server, exposing metrics via `/debug/vars` and, in
+	// the Prometheus text format, via `/metrics`.
+	if cfg.DebugAddr != "" {
+		expvar.Publish("metrics", metrics.DefaultRegistry)
+		http.Handle("/metrics", metrics.DefaultRegistry)
+		go func() {
+			logger.Info("Debug server started.", zap.String("addr", cfg.DebugAddr))
+			if err := http.ListenAndServe(cfg.DebugAddr, http.DefaultServeMux); err != nil {
+				logger.Error("Debug server stopped.", zap.Error(err))
+			}
+		}()
+	}
+
+	// Start the HTTP server, serving HTTPS if TLS is configured.
+	tlsCfg, err := newTLSConfig(cfg)
 	if err != nil {
-		logger.Fatal("Could not connect to database.", zap.Error(err))
-	}
-
-	exposureCfg := diag.ExposureConfig{
-		MinimumRiskScore:                 0,
-		AttenuationLevelValues:           []int{1, 2, 3, 4, 5, 6, 7, 8},
-		AttenuationWeight:                50,
-		DaysSinceLastExposureLevelValues: []int{1, 2, 3, 4, 5, 6, 7, 8},
-		DaysSinceLastExposureWeight:      50,
-		DurationLevelValues:              []int{1, 2, 3, 4, 5, 6, 7, 8},
-		DurationWeight:                   50,
-		TransmissionRiskLevelValues:      []int{1, 2, 3, 4, 5, 6, 7, 8},
-		TransmissionRiskWeight:           50,
-	}
-
-	cfg := diag.Config{
-		Repository:         db,
-		Cache:              &diag.MemoryCache{},
-		CacheInterval:      cacheInterval,
-		MaxUploadBatchSize: maxUploadBatchSize,
-		ExposureConfig:     exposureCfg,
-		Logger:             logger,
-	}
-	handler, err := api.NewHandler(ctx, cfg, logger)
+		logger.Fatal("Could not configure TLS.", zap.Error(err))
+	}
+	srv := &http.Server{Addr: cfg.Addr, Handler: a.handler, TLSConfig: tlsCfg}
+	go func() {
+		logger.Info("Server started.", zap.String("addr", cfg.Addr), zap.Bool("tls", tlsCfg != nil))
+		var err error
+		if tlsCfg != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logger.Fatal("Server stopped.", zap.Error(err))
+		}
+	}()
+
+	// Shut down gracefully on SIGINT or SIGTERM.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Could not shut down server gracefully.", zap.Error(err))
+	}
+
+	if cfg.CacheSnapshot != "" {
+		if err := writeCacheSnapshot(a.diagSvc, cfg.CacheSnapshot); err != nil {
+			logger.Error("Could not write cache snapshot.", zap.Error(err))
+		} else {
+			logger.Info("Cache snapshot written.", zap.String("path", cfg.CacheSnapshot))
+		}
+	}
+
+	logger.Info("Server stopped.")
+}
+
+// repository is implemented by both database backends.
+type repository interface {
+	diag.Repository
+	diag.Revoker
+	diag.JobRecorder
+	diag.IntegrityChecker
+	Close() error
+}
+
+// openRepository opens the database backend `backend`, i.e. `sqlite` or
+// `postgres`.
+func openRepository(ctx context.Context, cfg config.Config, backend string, dsnSource func() string, diagLogger diag.Logger, logger *zap.Logger) (repository, error) {
+	if backend == config.DBSQLite {
+		db, err := sqlite.New(ctx, sqlite.Config{Path: cfg.SQLitePath})
+		if err != nil {
+			return nil, fmt.Errorf("could not open SQLite database: %v", err)
+		}
+		return db, nil
+	}
+
+	// The configuration is validated, so parse errors can be ignored.
+	partitions, _ := postgres.ParsePartitionInterval(cfg.PartitionInterval)
+
+	db, err := postgres.New(postgres.Config{
+		DSN:                cfg.PostgresDSN,
+		DSNSource:          dsnSource,
+		Logger:             diagLogger,
+		SlowQueryThreshold: cfg.SlowQueryThreshold,
+		PartitionInterval:  partitions,
+		ReadTimeout:        cfg.DBReadTimeout,
+		WriteTimeout:       cfg.DBWriteTimeout,
+		Regions:            cfg.Regions,
+		ReportTypes:        cfg.ReportTypes,
+		RollingPeriods:     cfg.RollingPeriods,
+		PublicationTimes:   cfg.PublicationTimes,
+	})
 	if err != nil {
-		logger.Fatal("Could not create HTTP handler.", zap.Error(err))
+		return nil, fmt.Errorf("could not create PostgreSQL client: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not connect to database: %v", err)
 	}
 
-	// Start the HTTP server.
-	logger.Info("Server started.", zap.String("addr", addr))
-	if err := http.ListenAndServe(addr, handler); err != nil {
-		logger.Fatal("Server stopped.", zap.Error(err))
+	missingIndexes, err := db.MissingIndexes(ctx)
+	if err != nil {
+		logger.Warn("Could not check database indexes.", zap.Error(err))
+	}
+	if len(missingIndexes) > 0 {
+		logger.Warn("Database indexes are missing, queries may be slow. See `db/postgres/migrations`.",
+			zap.Strings("indexes", missingIndexes))
 	}
+
+	return db, nil
 }
 
-func mustGetEnv(key string) string {
-	v := os.Getenv(key)
-	if v == "" {
-		log.Fatalf("Environment variable `%s` cannot be empty.", key)
+// writeCacheSnapshot writes a cache snapshot to path. The snapshot is written
+// to a temporary file first, so an existing snapshot is replaced atomically.
+func writeCacheSnapshot(diagSvc diag.Service, path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err := diagSvc.WriteCacheSnapshot(f); err != nil {
+		f.Close()
+		return err
 	}
-	return v
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// rotatingSigner is a crypto.Signer delegating to the current signing key, so
+// the key can be rotated without a restart.
+type rotatingSigner struct {
+	mu  sync.RWMutex
+	key crypto.Signer
+}
+
+func (rs *rotatingSigner) set(key crypto.Signer) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.key = key
+}
+
+func (rs *rotatingSigner) get() crypto.Signer {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.key
+}
+
+func (rs *rotatingSigner) Public() crypto.PublicKey {
+	return rs.get().Public()
+}
+
+func (rs *rotatingSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return rs.get().Sign(rand, digest, opts)
 }
 
 func newLogger(isDev bool) (*zap.Logger, error) {
@@ -94,3 +250,18 @@ func newLogger(isDev bool) (*zap.Logger, error) {
 	}
 	return zap.NewProduction()
 }
+
+// newUploadEventLogger returns a logger writing upload events as JSON to path.
+// Entries have no timestamp, as events carry a truncated upload time.
+func newUploadEventLogger(path string) (*zap.Logger, error) {
+	cfg := zap.NewProductionConfig()
+	cfg.Sampling = nil
+	cfg.OutputPaths = []string{path}
+	cfg.DisableCaller = true
+	cfg.DisableStacktrace = true
+	cfg.EncoderConfig.TimeKey = ""
+	cfg.EncoderConfig.LevelKey = ""
+	cfg.EncoderConfig.MessageKey = "event"
+
+	return cfg.Build()
+}
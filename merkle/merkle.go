@@ -0,0 +1,186 @@
+// Package merkle implements the Merkle Hash Tree of RFC 6962 (Certificate
+// Transparency), for computing tree hashes and verifiable audit paths over an
+// append-only list of leaves.
+package merkle
+
+import (
+	"crypto/sha256"
+	"errors"
+)
+
+// HashSize is the size of a tree hash in bytes.
+const HashSize = sha256.Size
+
+// Hash is a node or leaf hash of a Merkle tree.
+type Hash [HashSize]byte
+
+// ErrInvalidIndex is used when a leaf index or tree size is out of range.
+var ErrInvalidIndex = errors.New("merkle: invalid index or tree size")
+
+// Domain separation prefixes, to prevent second preimage attacks.
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// LeafHash returns the hash of a leaf with the given data.
+func LeafHash(data []byte) Hash {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(data)
+
+	var hash Hash
+	copy(hash[:], h.Sum(nil))
+	return hash
+}
+
+func nodeHash(left, right Hash) Hash {
+	var buf [1 + 2*HashSize]byte
+	buf[0] = nodePrefix
+	copy(buf[1:], left[:])
+	copy(buf[1+HashSize:], right[:])
+	return sha256.Sum256(buf[:])
+}
+
+// Root returns the root hash of the tree with the given leaf hashes.
+func Root(leaves []Hash) Hash {
+	switch len(leaves) {
+	case 0:
+		return sha256.Sum256(nil)
+	case 1:
+		return leaves[0]
+	}
+
+	k := split(len(leaves))
+	return nodeHash(Root(leaves[:k]), Root(leaves[k:]))
+}
+
+// InclusionProof returns the audit path for the leaf at index in the tree
+// with the given leaf hashes.
+func InclusionProof(leaves []Hash, index int) ([]Hash, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, ErrInvalidIndex
+	}
+	return path(leaves, index), nil
+}
+
+func path(leaves []Hash, m int) []Hash {
+	if len(leaves) <= 1 {
+		return nil
+	}
+
+	k := split(len(leaves))
+	if m < k {
+		return append(path(leaves[:k], m), Root(leaves[k:]))
+	}
+	return append(path(leaves[k:], m-k), Root(leaves[:k]))
+}
+
+// VerifyInclusion returns true if proof proves the inclusion of the leaf with
+// the given hash at index, in the tree of the given size and root hash.
+func VerifyInclusion(leaf Hash, index, size uint64, proof []Hash, root Hash) bool {
+	if index >= size {
+		return false
+	}
+
+	fn, sn, r := index, size-1, leaf
+	for _, p := range proof {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	return sn == 0 && r == root
+}
+
+// ConsistencyProof returns the proof that the tree with the first m leaf
+// hashes is a prefix of the tree with the given leaf hashes.
+func ConsistencyProof(leaves []Hash, m int) ([]Hash, error) {
+	if m <= 0 || m > len(leaves) {
+		return nil, ErrInvalidIndex
+	}
+	return subproof(leaves, m, true), nil
+}
+
+func subproof(leaves []Hash, m int, complete bool) []Hash {
+	n := len(leaves)
+	if m == n {
+		if complete {
+			return nil
+		}
+		return []Hash{Root(leaves)}
+	}
+
+	k := split(n)
+	if m <= k {
+		return append(subproof(leaves[:k], m, complete), Root(leaves[k:]))
+	}
+	return append(subproof(leaves[k:], m-k, false), Root(leaves[:k]))
+}
+
+// VerifyConsistency returns true if proof proves that the tree of size first
+// with root hash firstRoot is a prefix of the tree of size second with root
+// hash secondRoot.
+func VerifyConsistency(first, second uint64, firstRoot, secondRoot Hash, proof []Hash) bool {
+	switch {
+	case first == 0 || first > second:
+		return false
+	case first == second:
+		return len(proof) == 0 && firstRoot == secondRoot
+	}
+
+	// If first is an exact power of two, its root is part of the proof.
+	if first&(first-1) == 0 {
+		proof = append([]Hash{firstRoot}, proof...)
+	}
+	if len(proof) == 0 {
+		return false
+	}
+
+	fn, sn := first-1, second-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	return sn == 0 && fr == firstRoot && sr == secondRoot
+}
+
+// split returns the largest power of two smaller than n, for n > 1.
+func split(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
@@ -0,0 +1,90 @@
+package merkle
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// leaves returns the leaf hashes of the test vectors of RFC 6962, as used by
+// the Certificate Transparency reference implementation.
+func leaves(n int) []Hash {
+	data := []string{"", "00", "10", "2021", "3031", "40414243", "5051525354555657", "606162636465666768696a6b6c6d6e6f"}
+	hashes := make([]Hash, n)
+	for i := range hashes {
+		buf, _ := hex.DecodeString(data[i])
+		hashes[i] = LeafHash(buf)
+	}
+	return hashes
+}
+
+func TestRoot(t *testing.T) {
+	tests := []struct {
+		size int
+		exp  string
+	}{
+		{0, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{1, "6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d"},
+		{2, "fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125"},
+		{3, "aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77"},
+		{7, "ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c"},
+		{8, "5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328"},
+	}
+
+	for _, tt := range tests {
+		got := Root(leaves(tt.size))
+		if hex.EncodeToString(got[:]) != tt.exp {
+			t.Errorf("size %v: expected: %v, got: %x", tt.size, tt.exp, got)
+		}
+	}
+}
+
+func TestInclusionProof(t *testing.T) {
+	for size := 1; size <= 8; size++ {
+		hashes := leaves(size)
+		root := Root(hashes)
+
+		for i := 0; i < size; i++ {
+			proof, err := InclusionProof(hashes, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !VerifyInclusion(hashes[i], uint64(i), uint64(size), proof, root) {
+				t.Errorf("size %v, index %v: expected valid proof", size, i)
+			}
+			if i > 0 && VerifyInclusion(hashes[i], uint64(i-1), uint64(size), proof, root) {
+				t.Errorf("size %v, index %v: expected invalid proof for wrong index", size, i)
+			}
+		}
+	}
+
+	if _, err := InclusionProof(leaves(3), 3); err != ErrInvalidIndex {
+		t.Errorf("expected: %v, got: %v", ErrInvalidIndex, err)
+	}
+}
+
+func TestConsistencyProof(t *testing.T) {
+	hashes := leaves(8)
+
+	for second := 1; second <= 8; second++ {
+		secondRoot := Root(hashes[:second])
+
+		for first := 1; first <= second; first++ {
+			firstRoot := Root(hashes[:first])
+
+			proof, err := ConsistencyProof(hashes[:second], first)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !VerifyConsistency(uint64(first), uint64(second), firstRoot, secondRoot, proof) {
+				t.Errorf("%v -> %v: expected valid proof", first, second)
+			}
+			if first < second && VerifyConsistency(uint64(first), uint64(second), secondRoot, firstRoot, proof) {
+				t.Errorf("%v -> %v: expected invalid proof for swapped roots", first, second)
+			}
+		}
+	}
+
+	if _, err := ConsistencyProof(hashes, 0); err != ErrInvalidIndex {
+		t.Errorf("expected: %v, got: %v", ErrInvalidIndex, err)
+	}
+}
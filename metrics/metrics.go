@@ -0,0 +1,342 @@
+// Package metrics provides a minimal, dependency free registry of counters,
+// gauges and histograms. A Registry implements expvar.Var, so it can be
+// published and inspected via the standard library's `/debug/vars` handler,
+// and http.Handler, so it can be scraped by Prometheus.
+package metrics
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the default histogram buckets, tailored to measure
+// latencies (in seconds) of database queries and HTTP requests.
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultRegistry is the registry used by the packages of this module.
+var DefaultRegistry = NewRegistry()
+
+// Metric types.
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// labelSep separates label values in series keys.
+const labelSep = "\xff"
+
+// Registry holds a set of metrics.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+type metric interface {
+	family() Family
+}
+
+// Family represents a snapshot of a metric and all its series.
+type Family struct {
+	Name    string   `json:"name"`
+	Help    string   `json:"help"`
+	Type    string   `json:"type"`
+	Samples []Sample `json:"samples"`
+}
+
+// Sample represents a snapshot of a single series of a metric.
+type Sample struct {
+	Labels  map[string]string `json:"labels,omitempty"`
+	Value   float64           `json:"value"`
+	Count   uint64            `json:"count,omitempty"`
+	Buckets []Bucket          `json:"buckets,omitempty"`
+}
+
+// Bucket represents a cumulative histogram bucket.
+type Bucket struct {
+	UpperBound float64 `json:"le"`
+	Count      uint64  `json:"count"`
+}
+
+// NewRegistry returns a new Registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// Counter returns the counter registered under name, registering it if it
+// doesn't exist yet.
+func (r *Registry) Counter(name, help string, labelNames ...string) *Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c, ok := r.metrics[name].(*Counter); ok {
+		return c
+	}
+	c := &Counter{vec: newVec(name, help, labelNames)}
+	r.metrics[name] = c
+	return c
+}
+
+// Gauge returns the gauge registered under name, registering it if it doesn't
+// exist yet.
+func (r *Registry) Gauge(name, help string, labelNames ...string) *Gauge {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if g, ok := r.metrics[name].(*Gauge); ok {
+		return g
+	}
+	g := &Gauge{vec: newVec(name, help, labelNames)}
+	r.metrics[name] = g
+	return g
+}
+
+// GaugeFunc registers a gauge whose value is computed by fn upon collection.
+// An existing gauge func with the same name is replaced.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metrics[name] = gaugeFunc{name: name, help: help, fn: fn}
+}
+
+// Histogram returns the histogram registered under name, registering it if it
+// doesn't exist yet. Buckets must be sorted in increasing order.
+func (r *Registry) Histogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if h, ok := r.metrics[name].(*Histogram); ok {
+		return h
+	}
+	h := &Histogram{vec: newVec(name, help, labelNames), buckets: buckets}
+	r.metrics[name] = h
+	return h
+}
+
+// Snapshot returns the current state of all registered metrics, sorted by name.
+func (r *Registry) Snapshot() []Family {
+	r.mu.Lock()
+	metrics := make([]metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mu.Unlock()
+
+	families := make([]Family, len(metrics))
+	for i, m := range metrics {
+		families[i] = m.family()
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].Name < families[j].Name })
+
+	return families
+}
+
+// String returns a JSON representation of the registry, to satisfy expvar.Var.
+func (r *Registry) String() string {
+	buf, err := json.Marshal(r.Snapshot())
+	if err != nil {
+		return "null"
+	}
+	return string(buf)
+}
+
+// vec holds the series of a metric, keyed by their label values.
+type vec struct {
+	name       string
+	help       string
+	labelNames []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	count       uint64
+	buckets     []uint64
+}
+
+func newVec(name, help string, labelNames []string) vec {
+	return vec{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		series:     make(map[string]*series),
+	}
+}
+
+// get returns the series for the given label values. The caller must hold
+// v.mu. Missing label values are treated as empty strings, superfluous values
+// are dropped.
+func (v *vec) get(labelValues []string) *series {
+	values := make([]string, len(v.labelNames))
+	copy(values, labelValues)
+	key := strings.Join(values, labelSep)
+
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: values}
+		v.series[key] = s
+	}
+	return s
+}
+
+func (v *vec) samples(fn func(s *series) Sample) []Sample {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	samples := make([]Sample, len(keys))
+	for i, k := range keys {
+		s := v.series[k]
+		sample := fn(s)
+		if len(v.labelNames) > 0 {
+			sample.Labels = make(map[string]string, len(v.labelNames))
+			for j, name := range v.labelNames {
+				sample.Labels[name] = s.labelValues[j]
+			}
+		}
+		samples[i] = sample
+	}
+
+	return samples
+}
+
+// Counter is a monotonically increasing metric.
+type Counter struct {
+	vec
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter by delta, which must not be negative.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	c.mu.Lock()
+	c.get(labelValues).value += delta
+	c.mu.Unlock()
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.get(labelValues).value
+}
+
+func (c *Counter) family() Family {
+	return Family{
+		Name: c.name,
+		Help: c.help,
+		Type: TypeCounter,
+		Samples: c.samples(func(s *series) Sample {
+			return Sample{Value: s.value}
+		}),
+	}
+}
+
+// Gauge is a metric that can go up and down.
+type Gauge struct {
+	vec
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.mu.Lock()
+	g.get(labelValues).value = v
+	g.mu.Unlock()
+}
+
+// Add adds delta (which may be negative) to the gauge.
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.mu.Lock()
+	g.get(labelValues).value += delta
+	g.mu.Unlock()
+}
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value(labelValues ...string) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.get(labelValues).value
+}
+
+func (g *Gauge) family() Family {
+	return Family{
+		Name: g.name,
+		Help: g.help,
+		Type: TypeGauge,
+		Samples: g.samples(func(s *series) Sample {
+			return Sample{Value: s.value}
+		}),
+	}
+}
+
+type gaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+func (g gaugeFunc) family() Family {
+	return Family{
+		Name:    g.name,
+		Help:    g.help,
+		Type:    TypeGauge,
+		Samples: []Sample{{Value: g.fn()}},
+	}
+}
+
+// Histogram samples observations in cumulative buckets. The value of its
+// samples is the sum of all observations.
+type Histogram struct {
+	vec
+	buckets []float64
+}
+
+// Observe adds an observation to the histogram.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.get(labelValues)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(h.buckets))
+	}
+	s.value += v
+	s.count++
+	for i, upperBound := range h.buckets {
+		if v <= upperBound {
+			s.buckets[i]++
+		}
+	}
+}
+
+func (h *Histogram) family() Family {
+	return Family{
+		Name: h.name,
+		Help: h.help,
+		Type: TypeHistogram,
+		Samples: h.samples(func(s *series) Sample {
+			buckets := make([]Bucket, len(h.buckets))
+			for i, upperBound := range h.buckets {
+				buckets[i] = Bucket{UpperBound: upperBound, Count: s.buckets[i]}
+			}
+			return Sample{Value: s.value, Count: s.count, Buckets: buckets}
+		}),
+	}
+}
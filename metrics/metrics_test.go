@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	c := r.Counter("requests_total", "Total requests.", "code")
+	c.Inc("200")
+	c.Add(2, "200")
+	c.Inc("500")
+	c.Add(-1, "500")
+
+	if got := r.Counter("requests_total", "Total requests.", "code"); got != c {
+		t.Fatal("expected registering an existing counter to return the same counter")
+	}
+
+	h := r.Histogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+
+	r.GaugeFunc("answer", "The answer.", func() float64 { return 42 })
+
+	exp := []Family{
+		{Name: "answer", Help: "The answer.", Type: TypeGauge, Samples: []Sample{{Value: 42}}},
+		{Name: "latency_seconds", Help: "Latency.", Type: TypeHistogram, Samples: []Sample{
+			{Value: 5.55, Count: 3, Buckets: []Bucket{{UpperBound: 0.1, Count: 1}, {UpperBound: 1, Count: 2}}},
+		}},
+		{Name: "requests_total", Help: "Total requests.", Type: TypeCounter, Samples: []Sample{
+			{Labels: map[string]string{"code": "200"}, Value: 3},
+			{Labels: map[string]string{"code": "500"}, Value: 1},
+		}},
+	}
+
+	got := r.Snapshot()
+	if !reflect.DeepEqual(exp, got) {
+		t.Errorf("expected: %+v, got: %+v", exp, got)
+	}
+
+	var decoded []Family
+	if err := json.Unmarshal([]byte(r.String()), &decoded); err != nil {
+		t.Fatalf("expected valid JSON, got: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// prometheusContentType is the content type of the Prometheus text format.
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// WritePrometheus writes all registered metrics to w, in the Prometheus text
+// exposition format.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	for _, f := range r.Snapshot() {
+		bw.WriteString("# HELP " + f.Name + " " + helpEscaper.Replace(f.Help) + "\n")
+		bw.WriteString("# TYPE " + f.Name + " " + f.Type + "\n")
+
+		for _, s := range f.Samples {
+			if f.Type != TypeHistogram {
+				writeSample(bw, f.Name, s.Labels, "", "", s.Value)
+				continue
+			}
+			for _, b := range s.Buckets {
+				writeSample(bw, f.Name+"_bucket", s.Labels, "le", formatFloat(b.UpperBound), float64(b.Count))
+			}
+			writeSample(bw, f.Name+"_bucket", s.Labels, "le", "+Inf", float64(s.Count))
+			writeSample(bw, f.Name+"_sum", s.Labels, "", "", s.Value)
+			writeSample(bw, f.Name+"_count", s.Labels, "", "", float64(s.Count))
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ServeHTTP writes all registered metrics in the Prometheus text exposition
+// format, so a Registry can be scraped at e.g. `/metrics`.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", prometheusContentType)
+	r.WritePrometheus(w)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// writeSample writes a sample line. The extra label (e.g. `le`) is appended
+// to labels, if its name is not empty.
+func writeSample(w *bufio.Writer, name string, labels map[string]string, extraName, extraValue string, v float64) {
+	w.WriteString(name)
+
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	if len(names) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, k := range names {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(k + `="` + labelEscaper.Replace(labels[k]) + `"`)
+		}
+		if extraName != "" {
+			if len(names) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extraName + `="` + extraValue + `"`)
+		}
+		w.WriteByte('}')
+	}
+
+	w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
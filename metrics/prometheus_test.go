@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("requests_total", "Total requests.", "code", "path").Inc("200", `/a"b`)
+	r.Histogram("latency_seconds", "Latency.\nIn seconds.", []float64{0.1, 1}).Observe(0.5)
+	r.GaugeFunc("answer", "The answer.", func() float64 { return 42 })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if got, exp := w.Header().Get("Content-Type"), "text/plain; version=0.0.4; charset=utf-8"; got != exp {
+		t.Errorf("expected: %v, got: %v", exp, got)
+	}
+
+	exp := `# HELP answer The answer.
+# TYPE answer gauge
+answer 42
+# HELP latency_seconds Latency.\nIn seconds.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 0
+latency_seconds_bucket{le="1"} 1
+latency_seconds_bucket{le="+Inf"} 1
+latency_seconds_sum 0.5
+latency_seconds_count 1
+# HELP requests_total Total requests.
+# TYPE requests_total counter
+requests_total{code="200",path="/a\"b"} 1
+`
+	if got := w.Body.String(); got != exp {
+		t.Errorf("expected:\n%v\ngot:\n%v", exp, got)
+	}
+}
@@ -0,0 +1,166 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	awsService    = "secretsmanager"
+	awsTimeLayout = "20060102T150405Z"
+)
+
+// AWSProvider fetches secrets from AWS Secrets Manager, using static
+// credentials (e.g. from the `AWS_ACCESS_KEY_ID`, `AWS_SECRET_ACCESS_KEY` and
+// `AWS_SESSION_TOKEN` environment variables). References have the format
+// `{secret-id}` for a plain text secret, or `{secret-id}#{key}` for a key in
+// a JSON secret.
+type AWSProvider struct {
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Endpoint defaults to `https://secretsmanager.{region}.amazonaws.com`.
+	Endpoint string
+	// Client is used for requests, defaults to a client with a 10 second
+	// timeout.
+	Client *http.Client
+
+	now func() time.Time
+}
+
+// GetSecret returns the current version of a secret.
+func (ap AWSProvider) GetSecret(ctx context.Context, ref string) (string, error) {
+	secretID, key := ref, ""
+	if i := strings.LastIndexByte(ref, '#'); i != -1 {
+		secretID, key = ref[:i], ref[i+1:]
+	}
+
+	body, err := json.Marshal(map[string]string{"SecretId": secretID})
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := ap.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%v.%v.amazonaws.com", awsService, ap.Region)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	if ap.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", ap.SessionToken)
+	}
+
+	now := time.Now
+	if ap.now != nil {
+		now = ap.now
+	}
+	signAWSRequest(req, body, ap.AccessKeyID, ap.SecretAccessKey, ap.Region, awsService, now())
+
+	resp, err := client(ap.Client).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("secrets: could not get AWS secret: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		drain(resp.Body)
+		return "", fmt.Errorf("secrets: unexpected AWS Secrets Manager response status: %v", resp.Status)
+	}
+
+	var secret struct {
+		SecretString *string
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&secret); err != nil {
+		return "", fmt.Errorf("secrets: could not parse AWS Secrets Manager response: %v", err)
+	}
+	if secret.SecretString == nil {
+		return "", fmt.Errorf("secrets: AWS secret %q has no string value", secretID)
+	}
+	if key == "" {
+		return *secret.SecretString, nil
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(*secret.SecretString), &values); err != nil {
+		return "", fmt.Errorf("secrets: AWS secret %q is not a JSON object", secretID)
+	}
+	v, ok := values[key].(string)
+	if !ok {
+		return "", fmt.Errorf("secrets: key %q not found (or not a string) in AWS secret %q", key, secretID)
+	}
+
+	return v, nil
+}
+
+// signAWSRequest signs req with AWS Signature Version 4, signing all headers
+// set on req, and the host.
+func signAWSRequest(req *http.Request, body []byte, accessKeyID, secretAccessKey, region, service string, t time.Time) {
+	t = t.UTC()
+	amzDate := t.Format(awsTimeLayout)
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	bodyHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(canonicalHash[:])}, "\n")
+
+	key := []byte("AWS4" + secretAccessKey)
+	for _, v := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		accessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
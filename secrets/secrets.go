@@ -0,0 +1,119 @@
+// Package secrets provides access to secrets stored in a secret manager
+// (HashiCorp Vault or AWS Secrets Manager), with periodic refreshes, so
+// rotated secrets are picked up without a restart.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+// Provider defines an interface for fetching secrets from a secret manager.
+type Provider interface {
+	// GetSecret returns the current value of the secret referred to by ref.
+	// The format of ref is specific to the implementation.
+	GetSecret(ctx context.Context, ref string) (string, error)
+}
+
+// Watcher holds the current values of a set of named secrets, and refreshes
+// them periodically (see Run). It's safe for concurrent use.
+type Watcher struct {
+	provider Provider
+	refs     map[string]string
+
+	mu       sync.RWMutex
+	values   map[string]string
+	onChange map[string][]func(string)
+}
+
+// NewWatcher returns a Watcher for the given secrets, keyed by name, with the
+// provider specific reference of each secret as value. All secrets are
+// fetched once, so missing secrets are reported early.
+func NewWatcher(ctx context.Context, provider Provider, refs map[string]string) (*Watcher, error) {
+	w := &Watcher{
+		provider: provider,
+		refs:     refs,
+		values:   make(map[string]string, len(refs)),
+		onChange: make(map[string][]func(string)),
+	}
+
+	for _, name := range w.names() {
+		v, err := provider.GetSecret(ctx, refs[name])
+		if err != nil {
+			return nil, fmt.Errorf("secrets: could not get secret %v (%v): %v", name, refs[name], err)
+		}
+		w.values[name] = v
+	}
+
+	return w, nil
+}
+
+// Get returns the current value of the named secret.
+func (w *Watcher) Get(name string) string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.values[name]
+}
+
+// OnChange registers fn to be called with the new value of the named secret
+// whenever it changes.
+func (w *Watcher) OnChange(name string, fn func(value string)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onChange[name] = append(w.onChange[name], fn)
+}
+
+// Run refreshes all secrets every interval, until ctx is done. If a secret
+// can't be fetched, its previous value is kept.
+func (w *Watcher) Run(ctx context.Context, interval time.Duration, logger diag.Logger) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		for _, name := range w.names() {
+			if err := w.refresh(ctx, name); err != nil {
+				logger.Error("Could not refresh secret.", diag.F("name", name), diag.Err(err))
+			}
+		}
+	}
+}
+
+func (w *Watcher) refresh(ctx context.Context, name string) error {
+	v, err := w.provider.GetSecret(ctx, w.refs[name])
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	changed := w.values[name] != v
+	w.values[name] = v
+	fns := w.onChange[name]
+	w.mu.Unlock()
+
+	if changed {
+		for _, fn := range fns {
+			fn(v)
+		}
+	}
+	return nil
+}
+
+func (w *Watcher) names() []string {
+	names := make([]string, 0, len(w.refs))
+	for name := range w.refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,182 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/ct-diag-server/diag"
+)
+
+func TestSignAWSRequest(t *testing.T) {
+	// Test vectors from the AWS Signature Version 4 test suite, and the IAM
+	// example of the AWS General Reference.
+	tests := []struct {
+		name    string
+		url     string
+		headers map[string]string
+		region  string
+		service string
+		exp     string
+	}{
+		{
+			name:    "get-vanilla",
+			url:     "https://example.amazonaws.com/",
+			region:  "us-east-1",
+			service: "service",
+			exp:     "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:    "iam",
+			url:     "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08",
+			headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded; charset=utf-8"},
+			region:  "us-east-1",
+			service: "iam",
+			exp:     "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.url, nil)
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		signAWSRequest(req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", tt.region, tt.service, time.Date(2015, time.August, 30, 12, 36, 0, 0, time.UTC))
+
+		if got := req.Header.Get("Authorization"); got != tt.exp {
+			t.Errorf("%v: expected: %v, got: %v", tt.name, tt.exp, got)
+		}
+	}
+}
+
+func TestVaultProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s3cret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/ct-diag":
+			w.Write([]byte(`{"data": {"data": {"dsn": "postgres://kv2"}, "metadata": {"version": 1}}}`))
+		case "/v1/kv/ct-diag":
+			w.Write([]byte(`{"data": {"dsn": "postgres://kv1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	vp := VaultProvider{Addr: srv.URL, Token: "s3cret"}
+	for ref, exp := range map[string]string{"secret/data/ct-diag#dsn": "postgres://kv2", "kv/ct-diag#dsn": "postgres://kv1"} {
+		got, err := vp.GetSecret(context.Background(), ref)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", ref, err)
+		}
+		if got != exp {
+			t.Errorf("%v: expected: %v, got: %v", ref, exp, got)
+		}
+	}
+
+	for _, ref := range []string{"secret/data/ct-diag", "secret/data/ct-diag#foo", "secret/data/foo#dsn"} {
+		if _, err := vp.GetSecret(context.Background(), ref); err == nil {
+			t.Errorf("%v: expected error", ref)
+		}
+	}
+}
+
+func TestAWSProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" ||
+			!strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/20200504/eu-west-1/secretsmanager/aws4_request") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var body struct{ SecretId string }
+		json.NewDecoder(r.Body).Decode(&body)
+		switch body.SecretId {
+		case "ct-diag/dsn":
+			w.Write([]byte(`{"SecretString": "postgres://plain"}`))
+		case "ct-diag":
+			w.Write([]byte(`{"SecretString": "{\"dsn\": \"postgres://json\"}"}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	ap := AWSProvider{
+		Region:          "eu-west-1",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "s3cret",
+		Endpoint:        srv.URL,
+		now:             func() time.Time { return time.Date(2020, time.May, 4, 12, 0, 0, 0, time.UTC) },
+	}
+	for ref, exp := range map[string]string{"ct-diag/dsn": "postgres://plain", "ct-diag#dsn": "postgres://json"} {
+		got, err := ap.GetSecret(context.Background(), ref)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", ref, err)
+		}
+		if got != exp {
+			t.Errorf("%v: expected: %v, got: %v", ref, exp, got)
+		}
+	}
+
+	for _, ref := range []string{"ct-diag#foo", "foobar"} {
+		if _, err := ap.GetSecret(context.Background(), ref); err == nil {
+			t.Errorf("%v: expected error", ref)
+		}
+	}
+}
+
+type testProvider struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (tp *testProvider) GetSecret(_ context.Context, ref string) (string, error) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	return tp.values[ref], nil
+}
+
+func (tp *testProvider) set(ref, v string) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.values[ref] = v
+}
+
+func TestWatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	provider := &testProvider{values: map[string]string{"dsn-ref": "postgres://old"}}
+	w, err := NewWatcher(ctx, provider, map[string]string{"POSTGRES_DSN": "dsn-ref"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, exp := w.Get("POSTGRES_DSN"), "postgres://old"; got != exp {
+		t.Errorf("expected: %v, got: %v", exp, got)
+	}
+
+	changed := make(chan string, 1)
+	w.OnChange("POSTGRES_DSN", func(v string) { changed <- v })
+	provider.set("dsn-ref", "postgres://new")
+	go w.Run(ctx, time.Millisecond, diag.NewNopLogger())
+
+	select {
+	case v := <-changed:
+		if exp := "postgres://new"; v != exp {
+			t.Errorf("expected: %v, got: %v", exp, v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected rotated secret to be picked up")
+	}
+	if got, exp := w.Get("POSTGRES_DSN"), "postgres://new"; got != exp {
+		t.Errorf("expected: %v, got: %v", exp, got)
+	}
+}
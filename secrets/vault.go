@@ -0,0 +1,91 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultTimeout is the timeout of requests to secret managers.
+const defaultTimeout = 10 * time.Second
+
+// VaultProvider fetches secrets from a HashiCorp Vault KV secrets engine
+// (version 1 or 2). References have the format `{path}#{key}`, where path is
+// the API path of the secret, e.g. `secret/data/ct-diag#dsn` for the `dsn` key
+// of the `ct-diag` secret in a KV version 2 engine mounted at `secret`.
+type VaultProvider struct {
+	// Addr is the address of the Vault server, e.g. `https://vault:8200`.
+	Addr  string
+	Token string
+	// Client is used for requests, defaults to a client with a 10 second
+	// timeout.
+	Client *http.Client
+}
+
+// GetSecret returns the value of a key in a Vault KV secret.
+func (vp VaultProvider) GetSecret(ctx context.Context, ref string) (string, error) {
+	i := strings.LastIndexByte(ref, '#')
+	if i <= 0 || i == len(ref)-1 {
+		return "", fmt.Errorf("secrets: invalid Vault reference %q, expected `{path}#{key}`", ref)
+	}
+	path, key := ref[:i], ref[i+1:]
+
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimRight(vp.Addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", vp.Token)
+
+	resp, err := client(vp.Client).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("secrets: could not get Vault secret: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		drain(resp.Body)
+		return "", fmt.Errorf("secrets: unexpected Vault response status: %v", resp.Status)
+	}
+
+	// KV version 2 nests the secret in `data.data`, version 1 in `data`.
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("secrets: could not parse Vault response: %v", err)
+	}
+	data := body.Data
+	if nested, ok := body.Data["data"]; ok {
+		if err := json.Unmarshal(nested, &data); err != nil {
+			data = body.Data
+		}
+	}
+
+	raw, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("secrets: key %q not found in Vault secret %q", key, path)
+	}
+	var v string
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", fmt.Errorf("secrets: value of key %q in Vault secret %q is not a string", key, path)
+	}
+
+	return v, nil
+}
+
+func client(c *http.Client) *http.Client {
+	if c != nil {
+		return c
+	}
+	return &http.Client{Timeout: defaultTimeout}
+}
+
+// drain discards the rest of r, so connections can be reused.
+func drain(r io.Reader) {
+	io.Copy(ioutil.Discard, io.LimitReader(r, 1<<20))
+}
@@ -0,0 +1,27 @@
+//go:build serverless
+// +build serverless
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/dstotijn/ct-diag-server/lambda"
+)
+
+// serverlessBuild is set for builds with the `serverless` tag, which always
+// run request-driven, as if `-serverless` is set.
+const serverlessBuild = true
+
+// serveLambda serves handler via the AWS Lambda runtime API, and returns false
+// if the server wasn't started by the Lambda runtime. Otherwise, it only
+// returns if the runtime API fails.
+func serveLambda(ctx context.Context, handler http.Handler) (bool, error) {
+	api := os.Getenv("AWS_LAMBDA_RUNTIME_API")
+	if api == "" {
+		return false, nil
+	}
+	return true, lambda.Serve(ctx, api, handler)
+}
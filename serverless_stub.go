@@ -0,0 +1,19 @@
+//go:build !serverless
+// +build !serverless
+
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// serverlessBuild is set for builds with the `serverless` tag, see
+// serverless.go.
+const serverlessBuild = false
+
+// serveLambda returns false: AWS Lambda is only supported by builds with the
+// `serverless` tag.
+func serveLambda(ctx context.Context, handler http.Handler) (bool, error) {
+	return false, nil
+}
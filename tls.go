@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/tls"
+	"strings"
+
+	"github.com/dstotijn/ct-diag-server/config"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newTLSConfig returns the TLS configuration of the HTTP server: the key pair
+// of `-tlsCert` and `-tlsKey`, or certificates obtained from Let's Encrypt
+// for `-autocertHosts`. It returns nil if TLS is disabled.
+func newTLSConfig(cfg config.Config) (*tls.Config, error) {
+	switch {
+	case cfg.TLSCert != "":
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+	case cfg.AutocertHosts != "":
+		var hosts []string
+		for _, host := range strings.Split(cfg.AutocertHosts, ",") {
+			hosts = append(hosts, strings.TrimSpace(host))
+		}
+		// Certificates are obtained with the TLS-ALPN-01 challenge, so no
+		// plain HTTP listener is needed.
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(hosts...),
+			Cache:      autocert.DirCache(cfg.AutocertCacheDir),
+			Email:      cfg.AutocertEmail,
+		}
+		tlsCfg := m.TLSConfig()
+		tlsCfg.MinVersion = tls.VersionTLS12
+		return tlsCfg, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,287 @@
+// Package verification validates verification certificates: JWTs issued by a
+// health authority's verification server after confirming a diagnosis, which
+// authorize a single upload of Diagnosis Keys.
+//
+// A certificate is bound to the uploaded keys with its `tekmac` claim, the
+// (base64 encoded) HMAC-SHA256 of the upload body, keyed by a random key the
+// app generated and sent to the verification server. The app passes the same
+// key along with the upload, so a certificate can't be used for other keys.
+//
+// With region policies, a certificate only authorizes uploads for the regions
+// of its issuer and signing key, so a verification server (or a leaked key) of
+// one health authority can't authorize uploads for the region of another.
+package verification
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// defaultLeeway is the default allowed clock skew between the verification
+// server and this server.
+const defaultLeeway = time.Minute
+
+// Config represents the configuration to create a Verifier. At least one of
+// PublicKeys and HMACSecret must be set.
+type Config struct {
+	// Issuer and Audience must match the `iss` and `aud` claims.
+	Issuer   string
+	Audience string
+	// PublicKeys are the ECDSA P-256 public keys of ES256 signed
+	// certificates, keyed by key ID (the `kid` header), so keys can be
+	// rotated.
+	PublicKeys map[string]*ecdsa.PublicKey
+	// HMACSecret is the shared secret of HS256 signed certificates.
+	HMACSecret []byte
+	// Leeway is the allowed clock skew for the `exp`, `nbf` and `iat`
+	// claims. Defaults to a minute.
+	Leeway time.Duration
+	// Regions are the policies of uploads for regions, keyed by region
+	// code. Uploads for other regions, or without regions, require a
+	// certificate of Issuer.
+	Regions map[string]RegionPolicy
+}
+
+// RegionPolicy restricts the certificates that authorize uploads for a region.
+type RegionPolicy struct {
+	// Issuers are the allowed `iss` claims. Defaults to Config.Issuer.
+	Issuers []string `json:"issuers"`
+	// KeyIDs are the allowed key IDs of ES256 signed certificates. If set,
+	// HS256 signed certificates aren't allowed, as their signer can't be
+	// told apart.
+	KeyIDs []string `json:"keyIDs"`
+}
+
+// Verifier validates verification certificates.
+type Verifier struct {
+	cfg Config
+	now func() time.Time
+}
+
+// Claims are the claims of a verification certificate.
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	IssuedAt  int64    `json:"iat"`
+	// ReportType is the type of diagnosis, e.g. `confirmed`.
+	ReportType string `json:"reportType"`
+	// SymptomOnsetInterval is the Exposure Notification interval number of
+	// the symptom onset, zero if unknown.
+	SymptomOnsetInterval uint32 `json:"symptomOnsetInterval"`
+	// TEKMAC is the HMAC of the uploaded keys (see package docs).
+	TEKMAC string `json:"tekmac"`
+	// KeyID is the key ID (the `kid` header) of ES256 signed certificates.
+	KeyID string `json:"-"`
+}
+
+// audience is the `aud` claim, which is either a string or an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+// header is the JOSE header of a certificate.
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// New returns a new Verifier.
+func New(cfg Config) (*Verifier, error) {
+	if cfg.Issuer == "" || cfg.Audience == "" {
+		return nil, errors.New("verification: issuer and audience are required")
+	}
+	if len(cfg.PublicKeys) == 0 && len(cfg.HMACSecret) == 0 {
+		return nil, errors.New("verification: public keys or HMAC secret required")
+	}
+	for region, policy := range cfg.Regions {
+		for _, kid := range policy.KeyIDs {
+			if _, ok := cfg.PublicKeys[kid]; !ok {
+				return nil, fmt.Errorf("verification: unknown key ID %q in policy of region %q", kid, region)
+			}
+		}
+	}
+	if cfg.Leeway == 0 {
+		cfg.Leeway = defaultLeeway
+	}
+
+	return &Verifier{cfg: cfg, now: time.Now}, nil
+}
+
+// Verify validates certificate, and checks that it authorizes the upload of
+// body with its `tekmac` claim, using hmacKey. It returns the claims of a
+// valid certificate.
+func (v *Verifier) Verify(certificate string, hmacKey, body []byte) (Claims, error) {
+	parts := strings.Split(certificate, ".")
+	if len(parts) != 3 {
+		return Claims{}, errors.New("verification: malformed certificate")
+	}
+
+	var hdr header
+	if err := decodeSegment(parts[0], &hdr); err != nil {
+		return Claims{}, fmt.Errorf("verification: invalid header: %v", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("verification: invalid signature encoding: %v", err)
+	}
+	if err := v.verifySignature(hdr, parts[0]+"."+parts[1], sig); err != nil {
+		return Claims{}, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Claims{}, fmt.Errorf("verification: invalid claims: %v", err)
+	}
+	if err := v.validateClaims(claims); err != nil {
+		return Claims{}, err
+	}
+	if hdr.Alg == "ES256" {
+		claims.KeyID = hdr.Kid
+	}
+
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(body)
+	tekmac, err := base64.StdEncoding.DecodeString(claims.TEKMAC)
+	if err != nil || len(hmacKey) == 0 || !hmac.Equal(tekmac, mac.Sum(nil)) {
+		return Claims{}, errors.New("verification: certificate doesn't match uploaded keys")
+	}
+
+	return claims, nil
+}
+
+// verifySignature checks the signature of signed (the header and claims
+// segments) with the key of the algorithm and key ID in hdr.
+func (v *Verifier) verifySignature(hdr header, signed string, sig []byte) error {
+	switch hdr.Alg {
+	case "ES256":
+		pub, ok := v.cfg.PublicKeys[hdr.Kid]
+		if !ok {
+			return fmt.Errorf("verification: unknown key ID %q", hdr.Kid)
+		}
+		if len(sig) != 64 {
+			return errors.New("verification: invalid signature")
+		}
+		digest := sha256.Sum256([]byte(signed))
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return errors.New("verification: invalid signature")
+		}
+	case "HS256":
+		if len(v.cfg.HMACSecret) == 0 {
+			return errors.New("verification: HS256 signed certificates are not accepted")
+		}
+		mac := hmac.New(sha256.New, v.cfg.HMACSecret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return errors.New("verification: invalid signature")
+		}
+	default:
+		return fmt.Errorf("verification: unsupported algorithm %q", hdr.Alg)
+	}
+	return nil
+}
+
+// validateClaims checks the registered claims of a certificate.
+func (v *Verifier) validateClaims(claims Claims) error {
+	now := v.now()
+	leeway := int64(v.cfg.Leeway / time.Second)
+
+	if !v.knownIssuer(claims.Issuer) {
+		return fmt.Errorf("verification: unexpected issuer %q", claims.Issuer)
+	}
+	var audOK bool
+	for _, aud := range claims.Audience {
+		if aud == v.cfg.Audience {
+			audOK = true
+		}
+	}
+	if !audOK {
+		return fmt.Errorf("verification: unexpected audience %q", []string(claims.Audience))
+	}
+	if claims.ExpiresAt == 0 || now.Unix() > claims.ExpiresAt+leeway {
+		return errors.New("verification: certificate is expired")
+	}
+	if now.Unix() < claims.NotBefore-leeway || now.Unix() < claims.IssuedAt-leeway {
+		return errors.New("verification: certificate is not valid yet")
+	}
+	return nil
+}
+
+// knownIssuer returns if iss is Config.Issuer, or an issuer of a region policy.
+func (v *Verifier) knownIssuer(iss string) bool {
+	if iss == v.cfg.Issuer {
+		return true
+	}
+	for _, policy := range v.cfg.Regions {
+		if contains(policy.Issuers, iss) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizeRegions checks that the certificate of claims (as returned by
+// Verify) authorizes an upload for regions, according to the region policies.
+func (v *Verifier) AuthorizeRegions(claims Claims, regions []string) error {
+	if len(regions) == 0 {
+		if claims.Issuer != v.cfg.Issuer {
+			return fmt.Errorf("verification: issuer %q isn't allowed for uploads without regions", claims.Issuer)
+		}
+		return nil
+	}
+
+	for _, region := range regions {
+		policy, ok := v.cfg.Regions[region]
+		issuers := policy.Issuers
+		if !ok || len(issuers) == 0 {
+			issuers = []string{v.cfg.Issuer}
+		}
+		if !contains(issuers, claims.Issuer) {
+			return fmt.Errorf("verification: issuer %q isn't allowed for region %q", claims.Issuer, region)
+		}
+		if len(policy.KeyIDs) > 0 && !contains(policy.KeyIDs, claims.KeyID) {
+			return fmt.Errorf("verification: key ID %q isn't allowed for region %q", claims.KeyID, region)
+		}
+	}
+	return nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	buf, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
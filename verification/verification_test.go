@@ -0,0 +1,224 @@
+package verification
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sign returns a JWT with the given header and claims, signed with key (an
+// *ecdsa.PrivateKey for ES256, or a []byte secret for HS256).
+func sign(t *testing.T, hdr header, claims map[string]interface{}, key interface{}) string {
+	t.Helper()
+	encode := func(v interface{}) string {
+		buf, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(buf)
+	}
+	signed := encode(hdr) + "." + encode(claims)
+
+	var sig []byte
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerify(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("s3cret")
+	now := time.Date(2020, time.May, 4, 13, 0, 0, 0, time.UTC)
+
+	v, err := New(Config{
+		Issuer:     "gov.example.verification",
+		Audience:   "gov.example.keyserver",
+		PublicKeys: map[string]*ecdsa.PublicKey{"v1": &key.PublicKey},
+		HMACSecret: secret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.now = func() time.Time { return now }
+
+	body := make([]byte, 21)
+	hmacKey := []byte("app generated key")
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(body)
+	tekmac := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	claims := func(override map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":        "gov.example.verification",
+			"aud":        "gov.example.keyserver",
+			"iat":        now.Add(-time.Minute).Unix(),
+			"exp":        now.Add(14 * time.Minute).Unix(),
+			"reportType": "confirmed",
+			"tekmac":     tekmac,
+		}
+		for k, v := range override {
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		cert    string
+		hmacKey []byte
+		expErr  string
+	}{
+		{"ES256", sign(t, header{Alg: "ES256", Kid: "v1"}, claims(nil), key), hmacKey, ""},
+		{"HS256", sign(t, header{Alg: "HS256"}, claims(nil), secret), hmacKey, ""},
+		{"audience array", sign(t, header{Alg: "HS256"}, claims(map[string]interface{}{"aud": []string{"foo", "gov.example.keyserver"}}), secret), hmacKey, ""},
+		{"malformed", "foobar", hmacKey, "malformed certificate"},
+		{"unknown key ID", sign(t, header{Alg: "ES256", Kid: "v2"}, claims(nil), key), hmacKey, "unknown key ID"},
+		{"invalid signature", sign(t, header{Alg: "HS256"}, claims(nil), []byte("foobar")), hmacKey, "invalid signature"},
+		{"unsigned", sign(t, header{Alg: "none"}, claims(nil), nil), hmacKey, "unsupported algorithm"},
+		{"wrong issuer", sign(t, header{Alg: "HS256"}, claims(map[string]interface{}{"iss": "foobar"}), secret), hmacKey, "unexpected issuer"},
+		{"wrong audience", sign(t, header{Alg: "HS256"}, claims(map[string]interface{}{"aud": "foobar"}), secret), hmacKey, "unexpected audience"},
+		{"expired", sign(t, header{Alg: "HS256"}, claims(map[string]interface{}{"exp": now.Add(-2 * time.Minute).Unix()}), secret), hmacKey, "expired"},
+		{"not valid yet", sign(t, header{Alg: "HS256"}, claims(map[string]interface{}{"nbf": now.Add(5 * time.Minute).Unix()}), secret), hmacKey, "not valid yet"},
+		{"other keys", sign(t, header{Alg: "HS256"}, claims(nil), secret), []byte("other key"), "doesn't match uploaded keys"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := v.Verify(tt.cert, tt.hmacKey, body)
+			if tt.expErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if got.ReportType != "confirmed" {
+					t.Errorf("expected report type `confirmed`, got: %q", got.ReportType)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expErr) {
+				t.Errorf("expected error containing %q, got: %v", tt.expErr, err)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Config{Issuer: "foo", Audience: "bar"}); err == nil {
+		t.Error("expected error without keys")
+	}
+	if _, err := New(Config{HMACSecret: []byte("foo")}); err == nil {
+		t.Error("expected error without issuer and audience")
+	}
+}
+
+func TestAuthorizeRegions(t *testing.T) {
+	nlKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	beKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("s3cret")
+	now := time.Date(2020, time.May, 4, 13, 0, 0, 0, time.UTC)
+
+	v, err := New(Config{
+		Issuer:     "gov.nl.verification",
+		Audience:   "eu.keyserver",
+		PublicKeys: map[string]*ecdsa.PublicKey{"nl1": &nlKey.PublicKey, "be1": &beKey.PublicKey},
+		HMACSecret: secret,
+		Regions: map[string]RegionPolicy{
+			"NL": {KeyIDs: []string{"nl1"}},
+			"BE": {Issuers: []string{"gov.be.verification"}, KeyIDs: []string{"be1"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.now = func() time.Time { return now }
+
+	body := make([]byte, 21)
+	hmacKey := []byte("app generated key")
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(body)
+	claims := func(iss string) map[string]interface{} {
+		return map[string]interface{}{
+			"iss":    iss,
+			"aud":    "eu.keyserver",
+			"iat":    now.Unix(),
+			"exp":    now.Add(15 * time.Minute).Unix(),
+			"tekmac": base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		}
+	}
+
+	nl := sign(t, header{Alg: "ES256", Kid: "nl1"}, claims("gov.nl.verification"), nlKey)
+	be := sign(t, header{Alg: "ES256", Kid: "be1"}, claims("gov.be.verification"), beKey)
+	beWithNLIssuer := sign(t, header{Alg: "ES256", Kid: "be1"}, claims("gov.nl.verification"), beKey)
+	hs := sign(t, header{Alg: "HS256"}, claims("gov.nl.verification"), secret)
+
+	tests := []struct {
+		name    string
+		cert    string
+		regions []string
+		expErr  string
+	}{
+		{"NL", nl, []string{"NL"}, ""},
+		{"BE", be, []string{"BE"}, ""},
+		{"region without policy", nl, []string{"DE"}, ""},
+		{"no regions", nl, nil, ""},
+		{"HS256 for region without policy", hs, []string{"DE"}, ""},
+		{"other issuer", be, []string{"NL"}, `issuer "gov.be.verification" isn't allowed for region "NL"`},
+		{"other issuer for region without policy", be, []string{"DE"}, `issuer "gov.be.verification" isn't allowed for region "DE"`},
+		{"other issuer without regions", be, nil, "isn't allowed for uploads without regions"},
+		{"other key", beWithNLIssuer, []string{"NL"}, `key ID "be1" isn't allowed for region "NL"`},
+		{"HS256", hs, []string{"NL"}, `key ID "" isn't allowed for region "NL"`},
+		{"one of regions", nl, []string{"BE", "NL"}, `isn't allowed for region "BE"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := v.Verify(tt.cert, hmacKey, body)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			err = v.AuthorizeRegions(got, tt.regions)
+			if tt.expErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expErr) {
+				t.Errorf("expected error containing %q, got: %v", tt.expErr, err)
+			}
+		})
+	}
+
+	if _, err := New(Config{Issuer: "foo", Audience: "bar", HMACSecret: secret, Regions: map[string]RegionPolicy{"NL": {KeyIDs: []string{"v1"}}}}); err == nil {
+		t.Error("expected error for unknown key ID in policy")
+	}
+}